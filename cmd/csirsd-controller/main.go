@@ -0,0 +1,39 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command csirsd-controller runs only the controller service of the driver,
+// e.g. in a Deployment. Its image doesn't need the nvme, mount and mkfs tools.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/intel/csi-intel-rsd/cmd/internal/kube"
+	"github.com/intel/csi-intel-rsd/cmd/internal/setup"
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+)
+
+func main() {
+	config := setup.RegisterFlags(flag.CommandLine, setup.Options{
+		Mode:           csirsd.DriverModeController,
+		NodeNameSource: kube.NodeNameSource,
+		CSIDriver:      kube.CSIDriver,
+	})
+	flag.Parse()
+
+	if err := config.Run(); err != nil {
+		log.Fatalln(err)
+	}
+}
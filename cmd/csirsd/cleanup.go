@@ -0,0 +1,42 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+)
+
+// runCleanup implements 'csirsd cleanup' subcommand: it releases mounts and
+// NVMe connections left on the node after crashes or failed upgrades
+func runCleanup(args []string) int {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	stagingRoot := flags.String("staging-root", "/var/lib/kubelet/plugins/kubernetes.io/csi/pv", "root directory of the volume staging paths")
+	nodeJournal := flags.String("node-journal", "", "node journal of the node plugin, the staging paths recorded in it are unmounted")
+	nqnPrefix := flags.String("nqn-prefix", "", "unmount and disconnect NVMe devices with subsystem NQN starting with this prefix")
+	hostRoot := flags.String("host-root", "", "run mount and nvme tools chrooted into this directory")
+	dryRun := flags.Bool("dry-run", false, "only report what would be cleaned up")
+	flags.Parse(args) // nolint: errcheck
+
+	report := csirsd.Cleanup(*hostRoot, *stagingRoot, *nodeJournal, *nqnPrefix, *dryRun)
+	fmt.Print(report)
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+)
+
+// runDiag implements 'csirsd diag' subcommand: it collects the diagnostics
+// bundle to be attached to bug reports
+func runDiag(args []string) int {
+	flags := flag.NewFlagSet("diag", flag.ExitOnError)
+	output := flags.String("output", "csirsd-diag.tgz", "file to write the diagnostics bundle to")
+	driverAddress := flags.String("driver-address", "", "address of the driver HTTP server (see driver http-address flag) to collect logs, volumes and RSD responses from")
+	hostRoot := flags.String("host-root", "", "run nvme tool chrooted into this directory")
+	flags.Parse(args) // nolint: errcheck
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	errs := csirsd.WriteDiagBundle(f, csirsd.DiagOptions{
+		DriverAddress: *driverAddress,
+		HostRoot:      *hostRoot,
+	})
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("diagnostics bundle written to %s\n", *output)
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
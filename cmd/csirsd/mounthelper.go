@@ -0,0 +1,66 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/cmd/internal/setup"
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+)
+
+// runMountHelper implements 'csirsd mount-helper' subcommand: a privileged
+// daemon running mount, mkfs and nvme tools for the unprivileged node plugin
+func runMountHelper(args []string) int {
+	flags := flag.NewFlagSet("mount-helper", flag.ExitOnError)
+	socket := flags.String("socket", "/run/csi-rsd/mount-helper.sock", "unix socket to listen on, passed to the node plugin mount-helper flag")
+	allowedPaths := flags.String("allowed-paths", "/var/lib/kubelet", "comma separated list of directories volumes may be mounted under")
+	allowedNQNs := flags.String("allowed-nqns", "", "comma separated list of prefixes of the subsystem NQNs of the volumes which may be connected, formatted and mounted")
+	hostRoot := flags.String("host-root", "", "run mount, mkfs and nvme tools chrooted into this directory")
+	commandTimeout := flags.Duration("command-timeout", 5*time.Minute, "timeout of nvme, mount and mkfs commands (no limit if 0)")
+	flags.Parse(args) // nolint: errcheck
+
+	helper, err := csirsd.NewMountHelper(csirsd.MountHelperOptions{
+		Address:        *socket,
+		HostRoot:       *hostRoot,
+		AllowedPaths:   setup.SplitList(*allowedPaths),
+		AllowedNQNs:    setup.SplitList(*allowedNQNs),
+		CommandTimeout: *commandTimeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, stopping", sig)
+		helper.Stop()
+	}()
+
+	if err := helper.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
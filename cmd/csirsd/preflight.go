@@ -0,0 +1,58 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/cmd/internal/setup"
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+)
+
+// runPreflight implements 'csirsd preflight' subcommand: it checks the node
+// prerequisites and prints JSON report, e.g. in an init container of the node plugin
+func runPreflight(args []string) int {
+	flags := flag.NewFlagSet("preflight", flag.ExitOnError)
+	transports := flags.String("transports", "rdma", "comma separated list of NVMe-oF transports to check kernel modules of: rdma, tcp")
+	filesystems := flags.String("filesystems", "ext4,xfs", "comma separated list of filesystems to check mkfs tools of")
+	portals := flags.String("portals", "", "comma separated list of storage portal addresses to check connectivity to, port 4420 is used if it's not specified")
+	timeout := flags.Duration("timeout", 5*time.Second, "time limit of connecting to each storage portal")
+	hostRoot := flags.String("host-root", "", "look for the tools in this directory")
+	flags.Parse(args) // nolint: errcheck
+
+	report := csirsd.Preflight(csirsd.PreflightOptions{
+		HostRoot:    *hostRoot,
+		Transports:  setup.SplitList(*transports),
+		Filesystems: setup.SplitList(*filesystems),
+		Portals:     setup.SplitList(*portals),
+		Timeout:     *timeout,
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
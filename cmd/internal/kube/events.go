@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventSink creates Kubernetes events about the PVCs and pods of the volumes
+type eventSink struct {
+	client kubernetes.Interface
+	// host is the Kubernetes node reporting the events
+	host string
+}
+
+func newEventSink(client kubernetes.Interface) *eventSink {
+	return &eventSink{client: client, host: os.Getenv(kubeNodeEnv)}
+}
+
+// uid returns UID of the object, kubectl describe lists only the events referring to it
+func (sink *eventSink) uid(object csirsd.EventObject) types.UID {
+	var meta metav1.Object
+	var err error
+	switch object.Kind {
+	case csirsd.EventKindPVC:
+		meta, err = sink.client.CoreV1().PersistentVolumeClaims(object.Namespace).Get(object.Name, metav1.GetOptions{})
+	case csirsd.EventKindPod:
+		meta, err = sink.client.CoreV1().Pods(object.Namespace).Get(object.Name, metav1.GetOptions{})
+	default:
+		return ""
+	}
+	if err != nil {
+		return ""
+	}
+	return meta.GetUID()
+}
+
+// Warning implements csirsd.EventSink interface
+func (sink *eventSink) Warning(object csirsd.EventObject, reason, message string) error {
+	return sink.event(object, corev1.EventTypeWarning, reason, message)
+}
+
+// Normal implements csirsd.EventSink interface
+func (sink *eventSink) Normal(object csirsd.EventObject, reason, message string) error {
+	return sink.event(object, corev1.EventTypeNormal, reason, message)
+}
+
+// event creates the event of the type about the object
+func (sink *eventSink) event(object csirsd.EventObject, eventType, reason, message string) error {
+	now := metav1.Now()
+	_, err := sink.client.CoreV1().Events(object.Namespace).Create(&corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", object.Name, now.UnixNano()),
+			Namespace: object.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       object.Kind,
+			APIVersion: "v1",
+			Namespace:  object.Namespace,
+			Name:       object.Name,
+			UID:        sink.uid(object),
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: csirsd.DriverName, Host: sink.host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	return err
+}
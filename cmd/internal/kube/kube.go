@@ -0,0 +1,120 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kube has the integrations of the driver binaries with Kubernetes API.
+// It's left out of the node binary, so its image is built without client-go.
+package kube
+
+import (
+	"fmt"
+	"os"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const kubeNodeEnv string = "KUBE_NODE_NAME"
+
+// client returns Kubernetes client of the driver running in the cluster
+func client() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// NodeLabel returns the label of the current Kubernetes node by name
+func NodeLabel(name string) (string, error) {
+	clientset, err := client()
+	if err != nil {
+		return "", err
+	}
+
+	nodeName := os.Getenv(kubeNodeEnv)
+	if nodeName == "" {
+		return "", fmt.Errorf("environment variable %s is not set", kubeNodeEnv)
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("can't get node %s: %v", nodeName, err)
+	}
+
+	label, exists := node.GetLabels()[name]
+	if !exists {
+		return "", fmt.Errorf("Label %s is not set for a node %s", name, nodeName)
+	}
+
+	return label, nil
+}
+
+// EventSink returns sink creating Kubernetes events about the PVCs and pods of the volumes
+func EventSink() (csirsd.EventSink, error) {
+	clientset, err := client()
+	if err != nil {
+		return nil, err
+	}
+	return newEventSink(clientset), nil
+}
+
+// nodeLabelSource returns RSD node IDs of the Kubernetes nodes from their labels
+type nodeLabelSource struct {
+	client kubernetes.Interface
+	label  string
+}
+
+// RSDNodeIDs implements csirsd.NodeNameSource interface
+func (source *nodeLabelSource) RSDNodeIDs() (map[string]string, error) {
+	nodes, err := source.client.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: source.label})
+	if err != nil {
+		return nil, fmt.Errorf("can't list nodes with label %s: %v", source.label, err)
+	}
+
+	result := map[string]string{}
+	for _, node := range nodes.Items {
+		result[node.Name] = node.Labels[source.label]
+	}
+	return result, nil
+}
+
+// NodeNameSource returns source of RSD node IDs of the Kubernetes nodes from the label
+func NodeNameSource(label string) (csirsd.NodeNameSource, error) {
+	clientset, err := client()
+	if err != nil {
+		return nil, err
+	}
+	return &nodeLabelSource{client: clientset, label: label}, nil
+}
+
+// CSIDriver returns the settings of the CSIDriver object of the driver by
+// name, nil if the object or its API doesn't exist, e.g. in Kubernetes 1.13
+func CSIDriver(name string) (*csirsd.CSIDriverSpec, error) {
+	clientset, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := clientset.StorageV1beta1().CSIDrivers().Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get CSIDriver %s: %v", name, err)
+	}
+	return &csirsd.CSIDriverSpec{AttachRequired: driver.Spec.AttachRequired, PodInfoOnMount: driver.Spec.PodInfoOnMount}, nil
+}
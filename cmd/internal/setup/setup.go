@@ -0,0 +1,724 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package setup configures and runs the driver from the command line flags
+// shared by the combined, controller and node binaries.
+package setup
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+const (
+	rsdUsernameEnv string = "rsd-username"
+	rsdPasswordEnv string = "rsd-password"
+	rsdNodeLabel   string = "csi.intel.com/rsd-node"
+)
+
+// Options select the flags and the Kubernetes integrations of the binary
+type Options struct {
+	// Mode is one of csirsd.DriverModes(), only the flags of its services are registered.
+	// The mode flag selecting the services at runtime is registered for csirsd.DriverModeAll.
+	Mode string
+	// NodeLabel returns the label of the Kubernetes node the driver runs on.
+	// RSD node ID must be set by the nodeid flag if it's nil.
+	NodeLabel func(name string) (string, error)
+	// EventSink returns sink of the Kubernetes events. The volume events
+	// aren't reported and their flag isn't registered if it's nil.
+	EventSink func() (csirsd.EventSink, error)
+	// NodeNameSource returns source of RSD node IDs of the Kubernetes nodes
+	// labeled with them. Kubernetes node names aren't resolved to RSD node
+	// IDs and their flag isn't registered if it's nil.
+	NodeNameSource func(label string) (csirsd.NodeNameSource, error)
+	// CSIDriver returns settings of the Kubernetes CSIDriver object of the
+	// driver, nil if it doesn't exist. The object isn't checked on start and
+	// its flag isn't registered if it's nil.
+	CSIDriver func(name string) (*csirsd.CSIDriverSpec, error)
+}
+
+// Config is the driver configuration loaded from the command line flags.
+// Flags of the services the binary doesn't serve are left zero.
+type Config struct {
+	options Options
+
+	mode                  string
+	endpoint              string
+	endpointTLS           csirsd.EndpointTLSOptions
+	controllerClients     string
+	nodeClients           string
+	username              string
+	password              string
+	baseurl               string
+	racks                 string
+	nodeID                string
+	timeout               time.Duration
+	writeTimeout          time.Duration
+	taskPollTimeout       time.Duration
+	requestRetries        int
+	requestRetryDelay     time.Duration
+	requestRetryCodes     string
+	nodeActionTimeout     time.Duration
+	commandTimeout        time.Duration
+	deviceWaitTimeout     time.Duration
+	endpointWaitTimeout   time.Duration
+	tls                   rsd.TLSOptions
+	clusterID             string
+	volumeNamePrefix      string
+	httpAddress           string
+	diagHistory           int
+	reconcileInterval     time.Duration
+	reconcileRepair       bool
+	resyncInterval        time.Duration
+	allocationInterval    time.Duration
+	allocationCSV         string
+	allocationWebhook     string
+	allocationMetrics     bool
+	maxTotalCapacity      string
+	defaultVolumeSize     string
+	spareVolumes          string
+	fabricDirect          bool
+	topology              bool
+	strictCapabilities    bool
+	expandSecretsRequired bool
+	supportedFsTypes      string
+	endPointSelection     string
+	preferredPortals      string
+	portalCheckTimeout    time.Duration
+	powerOnNodes          bool
+	transportPreference   string
+	transportCheck        string
+	csiCompat             string
+	nodeCacheTTL          time.Duration
+	capacityCacheTTL      time.Duration
+	maxConcurrentStages   int
+	maxConcurrentDeletes  int
+	eventFailureThreshold int
+	csiDriverCheck        string
+	fakeNode              bool
+	mountBackend          string
+	nvmeBackend           string
+	mountHelper           string
+	hostRoot              string
+	credentialsDir        string
+	usernameFile          string
+	passwordFile          string
+	credentialsReload     time.Duration
+	redactLogs            bool
+	rsdEndpointSecrets    bool
+	verbosity             int
+	registrationDir       string
+	nodeJournal           string
+	identityXattrs        bool
+	registrationInterval  time.Duration
+	nodeNameMappingTTL    time.Duration
+}
+
+// RegisterFlags registers the driver flags of the mode in the flag set.
+// The returned configuration is filled when the flags are parsed.
+func RegisterFlags(flags *flag.FlagSet, options Options) *Config {
+	c := &Config{options: options}
+	controller := options.Mode != csirsd.DriverModeNode
+	node := options.Mode != csirsd.DriverModeController
+
+	if controller && node {
+		flags.StringVar(&c.mode, "mode", csirsd.DriverModeAll, fmt.Sprintf("CSI services to serve, one of %v; flags of the other services are ignored", csirsd.DriverModes()))
+	}
+	flags.StringVar(&c.endpoint, "endpoint", "unix:///var/lib/kubelet/plugins/csi-intel-rsd.sock", "CSI endpoint, tcp://<host>:<port> endpoints need endpoint-tls-cert, endpoint-tls-key and endpoint-client-ca")
+	flags.StringVar(&c.endpointTLS.CertFile, "endpoint-tls-cert", "", "PEM server certificate of the tcp CSI endpoint")
+	flags.StringVar(&c.endpointTLS.KeyFile, "endpoint-tls-key", "", "PEM key of the tcp CSI endpoint certificate")
+	flags.StringVar(&c.endpointTLS.ClientCAFile, "endpoint-client-ca", "", "PEM bundle of the CAs verifying client certificates of the tcp CSI endpoint")
+	flags.StringVar(&c.controllerClients, "endpoint-controller-clients", "", "comma separated list of client certificate SANs allowed to call Controller RPCs on the tcp CSI endpoint")
+	flags.StringVar(&c.nodeClients, "endpoint-node-clients", "", "comma separated list of client certificate SANs allowed to call Node RPCs on the tcp CSI endpoint")
+	flags.StringVar(&c.username, "username", os.Getenv(rsdUsernameEnv), "RSD username")
+	flags.StringVar(&c.password, "password", os.Getenv(rsdPasswordEnv), "RSD password")
+	flags.StringVar(&c.baseurl, "baseurl", "http://localhost:2443", "Redfish URL")
+	flags.StringVar(&c.racks, "racks", "", "comma separated list of <rack ID>=<Redfish URL> of the RSD PodMs of other racks served next to baseurl with the same credentials, TLS configuration and policies, their volume and node IDs are prefixed with <rack ID>:")
+	flags.DurationVar(&c.timeout, "timeout", 10*time.Second, "timeout of RSD read requests")
+	flags.DurationVar(&c.writeTimeout, "write-timeout", 2*time.Minute, "timeout of RSD requests creating or changing resources, e.g. volume creation or node actions")
+	flags.DurationVar(&c.taskPollTimeout, "task-poll-timeout", 5*time.Minute, "time limit of waiting for asynchronous RSD tasks")
+	flags.IntVar(&c.requestRetries, "request-retries", 2, "number of retries of RSD requests failing with transient errors (disabled if 0)")
+	flags.DurationVar(&c.requestRetryDelay, "request-retry-delay", 500*time.Millisecond, "delay before the first retry of RSD requests, doubled for every next retry up to 5s and randomized by 20%")
+	flags.StringVar(&c.requestRetryCodes, "request-retry-status-codes", "429,502,503,504", "comma separated list of HTTP status codes of transient RSD errors")
+	flags.BoolVar(&c.tls.Insecure, "insecure", false, "allow connections to https RSD without certificate verification")
+	flags.StringVar(&c.tls.CAFile, "ca-file", "", "PEM bundle of the CAs verifying the RSD server certificate instead of the system CAs")
+	flags.StringVar(&c.tls.CertFile, "client-cert", "", "PEM client certificate presented to RSD for mutual TLS")
+	flags.StringVar(&c.tls.KeyFile, "client-key", "", "PEM key of the client certificate")
+	flags.StringVar(&c.tls.MinVersion, "tls-min-version", "1.2", "minimum TLS version of the RSD connections: 1.0, 1.1, 1.2 or 1.3")
+	flags.StringVar(&c.tls.ServerName, "tls-server-name", "", "host name the RSD server certificate is verified for instead of the baseurl host")
+	flags.StringVar(&c.clusterID, "cluster-id", "", "cluster ID stored in RSD volume descriptions to tell apart volumes of the clusters sharing RSD storage")
+	flags.StringVar(&c.volumeNamePrefix, "volume-name-prefix", "pvc-", "prefix of the CSI volume names managed by the driver")
+	flags.StringVar(&c.httpAddress, "http-address", "", "address of the HTTP server exposing /metrics (disabled if empty)")
+	flags.IntVar(&c.diagHistory, "diag-history", 1000, "number of latest log lines and RSD responses exposed on the HTTP server for 'csirsd diag' (disabled if 0)")
+	flags.StringVar(&c.supportedFsTypes, "supported-fstypes", "ext4,xfs", "comma separated list of filesystems volumes may be formatted with, the first one is the default; ext2, ext3, ext4 and xfs are allowed if empty")
+	flags.BoolVar(&c.topology, "topology", false, "report RSD storage services reachable from the nodes as their topology and create volumes in the storage services reachable from the requested topology")
+	flags.BoolVar(&c.strictCapabilities, "strict-capabilities", false, "advertise only the capabilities whose prerequisites are enabled and fail their RPCs with Unimplemented otherwise, e.g. GET_VOLUME_STATS without the node journal")
+	flags.BoolVar(&c.fabricDirect, "fabric-direct", false, "publish volumes by zoning them to the node host NQN instead of attaching them to RSD nodes, for storage without RSD nodes; node ID is the host NQN")
+	flags.StringVar(&c.csiCompat, "csi-compat", csirsd.DefaultCSICompat, fmt.Sprintf("CSI spec version the behaviors changed across versions follow, one of %v", csirsd.CSICompatLevels()))
+	flags.StringVar(&c.credentialsDir, "credentials-dir", "", "directory with rsd-username and rsd-password files overriding the username and password flags, reloaded on SIGHUP")
+	flags.StringVar(&c.usernameFile, "username-file", "", "file with RSD username overriding the username flag, reloaded on SIGHUP")
+	flags.StringVar(&c.passwordFile, "password-file", "", "file with RSD password overriding the password flag, reloaded on SIGHUP")
+	flags.DurationVar(&c.credentialsReload, "credentials-reload-interval", time.Minute, "interval of reloading RSD credentials changed in credentials-dir or the username and password files, only on SIGHUP if 0")
+	flags.BoolVar(&c.redactLogs, "redact-logs", true, "redact credentials and CSI secrets in logs and error messages")
+	flags.BoolVar(&c.rsdEndpointSecrets, "rsd-endpoint-secrets", false, "manage the volumes of the RSD endpoints set in the rsdEndpoint, rsdUsername and rsdPassword CSI secrets, e.g. PodMs of other racks, with the TLS configuration and policies of the default endpoint")
+	flags.IntVar(&c.verbosity, "v", csirsd.LogLevelRequest, fmt.Sprintf("log verbosity: 0 logs warnings and errors, %d adds volume state changes, %d adds CSI requests and responses, %d adds RSD requests", csirsd.LogLevelState, csirsd.LogLevelRequest, csirsd.LogLevelRSD))
+	if options.CSIDriver != nil {
+		flags.StringVar(&c.csiDriverCheck, "csidriver-check", csirsd.CSIDriverCheckFail, fmt.Sprintf("handling of the Kubernetes CSIDriver object settings not matching the driver, checked on start if Kubernetes API is reachable, one of %v", csirsd.CSIDriverCheckModes()))
+	}
+	if options.EventSink != nil {
+		flags.IntVar(&c.eventFailureThreshold, "event-failure-threshold", 3, "report every this number of consecutive failures of staging or publishing a volume as Kubernetes event of its PVC and pod, disabled if 0")
+	}
+
+	if controller {
+		flags.DurationVar(&c.nodeActionTimeout, "node-action-timeout", 5*time.Minute, "time limit of waiting for the volume to become allowed in the RSD node attach and detach actions")
+		flags.DurationVar(&c.endpointWaitTimeout, "endpoint-wait-timeout", 30*time.Second, "time limit of waiting for the RSD endpoints of the volume to appear after attaching it (read once if 0)")
+		flags.DurationVar(&c.reconcileInterval, "reconcile-interval", 0, "interval of reconciling volume records with RSD volumes and RSD node attachments (disabled if 0)")
+		flags.BoolVar(&c.reconcileRepair, "reconcile-repair", false, "attach published volumes detached out of band to their RSD nodes again during the reconciliation")
+		flags.BoolVar(&c.expandSecretsRequired, "expand-secrets-required", false, "expand only the volumes of the StorageClasses with controller expand secrets")
+		flags.DurationVar(&c.resyncInterval, "resync-interval", 10*time.Minute, "interval of refreshing volume capacity from RSD (disabled if 0)")
+		flags.DurationVar(&c.allocationInterval, "allocation-export-interval", 5*time.Minute, "interval of exporting volume allocation records")
+		flags.StringVar(&c.allocationCSV, "allocation-csv", "", "CSV file to append volume allocation records to (disabled if empty)")
+		flags.StringVar(&c.allocationWebhook, "allocation-webhook", "", "URL to post volume allocation records to as JSON (disabled if empty)")
+		flags.BoolVar(&c.allocationMetrics, "allocation-metrics", false, "expose volume allocation records as metrics on the HTTP server")
+		flags.StringVar(&c.maxTotalCapacity, "max-total-capacity", "", "budget of the total capacity of the volumes provisioned by the driver, e.g. 10Ti, CreateVolume exceeding it fails (unlimited if empty)")
+		flags.StringVar(&c.defaultVolumeSize, "default-volume-size", "1Gi", "capacity of the volumes created without capacity range, e.g. 1Gi or 500M")
+		flags.StringVar(&c.spareVolumes, "spare-volumes", "", "comma separated list of <capacity>:<count> pairs of volumes pre-created for fast provisioning, e.g. 1Gi:3,10Gi:1")
+		flags.BoolVar(&c.powerOnNodes, "power-on-nodes", false, "power on the RSD composed node and wait until it's attachable before attaching volumes to it")
+		flags.IntVar(&c.maxConcurrentDeletes, "max-concurrent-deletes", 8, "maximum number of RSD volumes deleted by the controller at the same time (unlimited if 0)")
+		flags.DurationVar(&c.capacityCacheTTL, "capacity-cache-ttl", 30*time.Second, "time the capacity reported by GetCapacity is cached, dropped when the driver creates, deletes or expands volumes (disabled if 0)")
+		flags.DurationVar(&c.nodeCacheTTL, "node-cache-ttl", 5*time.Minute, "time RSD nodes and their NQNs used to publish volumes are cached (disabled if 0)")
+		if options.NodeNameSource != nil {
+			flags.DurationVar(&c.nodeNameMappingTTL, "node-name-mapping-ttl", 0, "time Kubernetes node names of the RSD nodes, read from the csi.intel.com/rsd-node node labels, are cached to publish volumes to nodes identified by their names (disabled if 0)")
+		}
+	}
+
+	if node {
+		flags.StringVar(&c.nodeID, "nodeid", "", "RSD Node id")
+		flags.DurationVar(&c.commandTimeout, "command-timeout", 5*time.Minute, "timeout of nvme, mount and mkfs commands (no limit if 0)")
+		flags.DurationVar(&c.deviceWaitTimeout, "device-wait-timeout", 45*time.Second, "time limit of waiting for the NVMe device to appear after connecting the volume")
+		flags.StringVar(&c.endPointSelection, "endpoint-selection", csirsd.EndPointSelectionFirst, fmt.Sprintf("policy of selecting the portal of volumes exposed by multiple endpoints, one of %v", csirsd.EndPointSelectionPolicies()))
+		flags.StringVar(&c.preferredPortals, "preferred-portals", "", "comma separated list of IP addresses or CIDR networks of the portals in the order of preference for the preferred endpoint selection")
+		flags.DurationVar(&c.portalCheckTimeout, "portal-check-timeout", 0, "time limit of checking the volume portal is reachable from the node before connecting to it, the next portal of the volume is tried if it's not (disabled if 0)")
+		flags.StringVar(&c.transportPreference, "transport-preference", "", "comma separated list of NVMe-oF transports of the volume portals in the order of preference, e.g. tcp,rdma. Portals of other transports are not used, portals of all transports are used in the RSD order if empty")
+		flags.StringVar(&c.transportCheck, "transport-check", csirsd.TransportCheckFail, fmt.Sprintf("handling of published volumes without endpoints of the NVMe-oF transports available on the node, one of %v", csirsd.TransportCheckModes()))
+		flags.IntVar(&c.maxConcurrentStages, "max-concurrent-stages", 4, "maximum number of volumes staged on the node at the same time (unlimited if 0)")
+		flags.BoolVar(&c.fakeNode, "fake-node", false, "simulate formatting, mounting and NVMe connections of the node in memory, the driver must be built with the fakenode build tag")
+		flags.StringVar(&c.mountBackend, "mount-backend", csirsd.MountBackendMount, fmt.Sprintf("backend mounting the volumes on the node, one of %v", csirsd.MountBackends()))
+		flags.StringVar(&c.nvmeBackend, "nvme-backend", csirsd.NVMeBackendNative, fmt.Sprintf("backend connecting the volumes on the node, one of %v", csirsd.NVMeBackends()))
+		flags.StringVar(&c.mountHelper, "mount-helper", "", "unix socket of the 'csirsd mount-helper' running mount, mkfs and nvme tools for the node plugin, so the plugin can run unprivileged (disabled if empty)")
+		flags.StringVar(&c.hostRoot, "host-root", "", "run mount, mkfs and nvme tools chrooted into this directory, e.g. /host with the host root filesystem mounted")
+		flags.StringVar(&c.registrationDir, "registration-dir", "", "kubelet plugin registration directory to watch for the driver registration socket (disabled if empty)")
+		flags.DurationVar(&c.registrationInterval, "registration-check-interval", time.Minute, "interval of the driver registration checks")
+		flags.BoolVar(&c.identityXattrs, "identity-xattrs", false, "set the volume ID, RSD volume and subsystem NQN of the staged volumes as user."+csirsd.DriverName+".* extended attributes of the staging path for node agents")
+		flags.StringVar(&c.nodeJournal, "node-journal", "", "file keeping staged state of the volumes on the node to restore it after the driver restart, e.g. /var/lib/csi-rsd/journal.json on a host path (disabled if empty)")
+	}
+
+	return c
+}
+
+// SplitList splits comma separated list skipping empty items
+func SplitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// rackURL is the Redfish URL of the RSD PodM of the rack
+type rackURL struct {
+	id      string
+	baseurl string
+}
+
+// parseRacks returns the Redfish URLs of the racks in the order of the
+// comma separated list of <rack ID>=<Redfish URL>
+func parseRacks(list string) ([]rackURL, error) {
+	var result []rackURL
+	for _, item := range SplitList(list) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%q is not <rack ID>=<Redfish URL>", item)
+		}
+		result = append(result, rackURL{id: parts[0], baseurl: parts[1]})
+	}
+	return result, nil
+}
+
+// credentialFiles returns the files RSD username and password are read from,
+// credentials-dir has the files named after the secret keys, e.g. of a mounted
+// Kubernetes secret
+func (c *Config) credentialFiles() (rsd.CredentialFiles, error) {
+	if c.credentialsDir == "" {
+		return rsd.CredentialFiles{UsernameFile: c.usernameFile, PasswordFile: c.passwordFile}, nil
+	}
+	if c.usernameFile != "" || c.passwordFile != "" {
+		return rsd.CredentialFiles{}, fmt.Errorf("credentials-dir can't be combined with username-file or password-file")
+	}
+	return rsd.CredentialFiles{
+		UsernameFile: filepath.Join(c.credentialsDir, rsdUsernameEnv),
+		PasswordFile: filepath.Join(c.credentialsDir, rsdPasswordEnv),
+	}, nil
+}
+
+// watchCredentials periodically reloads RSD credentials changed in the files, so
+// they are rotated without restarting the driver. Kubernetes replaces the files
+// of the updated secret by swapping a symlink after the kubelet sync period, so
+// the files are polled rather than watched. It returns when stop is closed.
+func watchCredentials(rsdClients []*rsd.Client, files rsd.CredentialFiles, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// the racks share the credentials of the default RSD endpoint
+		for _, rsdClient := range rsdClients {
+			reloaded, err := rsdClient.ReloadCredentials(files)
+			if err != nil {
+				log.Printf("can't reload RSD credentials: %v", err)
+			} else if reloaded {
+				log.Printf("RSD credentials changed, reloaded")
+			}
+		}
+	}
+}
+
+// handleSignals reloads RSD credentials on SIGHUP and stops the driver on SIGINT or SIGTERM.
+// The stopped channel is closed when the driver is stopped.
+func handleSignals(driver *csirsd.Driver, rsdClients []*rsd.Client, files rsd.CredentialFiles, stopped chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			log.Printf("received %v, stopping", sig)
+			driver.Stop()
+			close(stopped)
+			return
+		}
+
+		if !files.Enabled() {
+			log.Printf("received %v, closing idle RSD connections", sig)
+			for _, rsdClient := range rsdClients {
+				rsdClient.CloseIdleConnections()
+			}
+			continue
+		}
+		for _, rsdClient := range rsdClients {
+			reloaded, err := rsdClient.ReloadCredentials(files)
+			if err != nil {
+				log.Printf("can't reload RSD credentials: %v", err)
+				continue
+			}
+			if !reloaded {
+				// SIGHUP drops idle connections even if the credentials are unchanged
+				rsdClient.CloseIdleConnections()
+			}
+		}
+		log.Printf("received %v, RSD credentials reloaded", sig)
+	}
+}
+
+// checkCSIDriver compares the Kubernetes CSIDriver object with the driver
+// behavior, so misconfigurations are reported on start instead of failing
+// volumes later. Mismatches fail the start unless csidriver-check is warn.
+func (c *Config) checkCSIDriver(driver *csirsd.Driver) error {
+	switch c.csiDriverCheck {
+	case "", csirsd.CSIDriverCheckOff:
+		return nil
+	case csirsd.CSIDriverCheckFail, csirsd.CSIDriverCheckWarn:
+	default:
+		return fmt.Errorf("Invalid CSIDriver check %q, must be one of %v", c.csiDriverCheck, csirsd.CSIDriverCheckModes())
+	}
+
+	spec, err := c.options.CSIDriver(csirsd.DriverName)
+	if err != nil {
+		log.Printf("Kubernetes API is not reachable, CSIDriver object is not checked: %v", err)
+		return nil
+	}
+	if spec == nil {
+		log.Printf("CSIDriver object %s doesn't exist, checking Kubernetes defaults", csirsd.DriverName)
+		spec = &csirsd.CSIDriverSpec{}
+	}
+
+	mismatches, warnings := driver.CheckCSIDriver(*spec)
+	for _, warning := range warnings {
+		log.Printf("CSIDriver object %s: %s", csirsd.DriverName, warning)
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("CSIDriver object %s doesn't match the driver: %s", csirsd.DriverName, strings.Join(mismatches, "; "))
+	if c.csiDriverCheck == csirsd.CSIDriverCheckWarn {
+		log.Print(err)
+		return nil
+	}
+	return err
+}
+
+// lookupNodeID returns RSD node ID of the node the driver runs on
+func (c *Config) lookupNodeID() (string, error) {
+	if c.fabricDirect {
+		return csirsd.ReadHostNQN(c.hostRoot)
+	}
+	if c.options.NodeLabel == nil {
+		return "", fmt.Errorf("nodeid flag is not set")
+	}
+	return c.options.NodeLabel(rsdNodeLabel)
+}
+
+// driverMode returns the mode of the driver: the mode of the binary, or the
+// mode flag if the binary serves all services
+func (c *Config) driverMode() (string, error) {
+	mode := c.options.Mode
+	if mode == "" || mode == csirsd.DriverModeAll {
+		mode = c.mode
+	}
+	if mode == "" {
+		mode = csirsd.DriverModeAll
+	}
+	if err := csirsd.ValidateMode(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// rsdNodeID returns RSD node ID of the driver in the mode, it's looked up
+// unless it's set by the nodeid flag. The controller alone has none.
+func (c *Config) rsdNodeID(mode string) (string, error) {
+	if c.nodeID != "" || mode == csirsd.DriverModeController {
+		return c.nodeID, nil
+	}
+	return c.lookupNodeID()
+}
+
+// rsdPolicies returns the RSD client policies of the driver in the mode,
+// the timeouts of the services the driver doesn't serve are left default
+func (c *Config) rsdPolicies(mode string) (policy.Policies, error) {
+	policies := policy.Default()
+	policies.ReadTimeout = c.timeout
+	policies.WriteTimeout = c.writeTimeout
+	policies.TaskPoll = policies.TaskPoll.WithTimeout(c.taskPollTimeout)
+	if c.requestRetries < 0 {
+		return policies, fmt.Errorf("Invalid number of request retries %d", c.requestRetries)
+	}
+	policies.Request.Attempts = c.requestRetries + 1
+	policies.Request.Delay = c.requestRetryDelay
+	policies.RetryStatusCodes = nil
+	for _, item := range SplitList(c.requestRetryCodes) {
+		code, err := strconv.Atoi(item)
+		if err != nil {
+			return policies, fmt.Errorf("Invalid request retry status code %q: %v", item, err)
+		}
+		policies.RetryStatusCodes = append(policies.RetryStatusCodes, code)
+	}
+	if mode != csirsd.DriverModeNode {
+		policies.NodeAction = policies.NodeAction.WithTimeout(c.nodeActionTimeout)
+		policies.EndpointWait = policies.EndpointWait.WithTimeout(c.endpointWaitTimeout)
+	}
+	if mode != csirsd.DriverModeController {
+		policies.CommandTimeout = c.commandTimeout
+		policies.DeviceWait = policies.DeviceWait.WithTimeout(c.deviceWaitTimeout)
+	}
+	return policies, nil
+}
+
+// Run creates the driver configured by the parsed flags and serves it until
+// it's stopped by a signal
+func (c *Config) Run() error {
+	mode, err := c.driverMode()
+	if err != nil {
+		return err
+	}
+
+	rsd.SetRedaction(c.redactLogs)
+
+	var logs *csirsd.LogBuffer
+	var recorder *rsd.Recorder
+	if c.httpAddress != "" && c.diagHistory > 0 {
+		logs = csirsd.NewLogBuffer(c.diagHistory)
+		log.SetOutput(io.MultiWriter(os.Stderr, logs))
+		recorder = rsd.NewRecorder(c.diagHistory)
+	}
+
+	if strings.Contains(c.clusterID, ":") {
+		return fmt.Errorf("Cluster ID %q must not contain ':'", c.clusterID)
+	}
+
+	credentialFiles, err := c.credentialFiles()
+	if err != nil {
+		return err
+	}
+	c.username, c.password, err = credentialFiles.Read(c.username, c.password)
+	if err != nil {
+		return fmt.Errorf("Can't read RSD credentials: %v", err)
+	}
+
+	// uset RSD access creds for security reasons
+	os.Unsetenv(rsdUsernameEnv)
+	os.Unsetenv(rsdPasswordEnv)
+
+	c.nodeID, err = c.rsdNodeID(mode)
+	if err != nil {
+		return fmt.Errorf("Can't get RSD node ID: %v", err)
+	}
+
+	httpClient, err := rsd.NewHTTPClient(c.tls)
+	if err != nil {
+		return fmt.Errorf("Invalid RSD TLS configuration: %v", err)
+	}
+
+	rsdClient, err := rsd.NewClient(c.baseurl, c.username, c.password, httpClient)
+	if err != nil {
+		return err
+	}
+	policies, err := c.rsdPolicies(mode)
+	if err != nil {
+		return err
+	}
+	rsdClient.SetPolicies(policies)
+	rsdClient.SetRecorder(recorder)
+	logger := csirsd.NewLogger(c.verbosity)
+	rsdClient.SetRequestLogger(logger.LogRSDRequest)
+	schemas := rsd.NewSchemas(logger.Warning)
+	rsdClient.SetSchemas(schemas)
+
+	// clients of other RSD endpoints are configured like the default one
+	newRSDClient := func(baseurl, username, password string) (*rsd.Client, error) {
+		client, err := rsd.NewClient(baseurl, username, password, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		client.SetPolicies(policies)
+		client.SetRecorder(recorder)
+		client.SetRequestLogger(logger.LogRSDRequest)
+		client.SetSchemas(schemas)
+		return client, nil
+	}
+
+	driver := csirsd.NewDriver(c.endpoint, c.nodeID, rsd.NewRetryTransport(rsdClient))
+	if c.rsdEndpointSecrets {
+		driver.SetRSDClientFactory(func(baseurl, username, password string) (rsd.Transport, error) {
+			client, err := newRSDClient(baseurl, username, password)
+			if err != nil {
+				return nil, err
+			}
+			return rsd.NewRetryTransport(client), nil
+		})
+	}
+	racks, err := parseRacks(c.racks)
+	if err != nil {
+		return fmt.Errorf("Invalid racks: %v", err)
+	}
+	rsdClients := []*rsd.Client{rsdClient}
+	for _, rack := range racks {
+		client, err := newRSDClient(rack.baseurl, c.username, c.password)
+		if err != nil {
+			return fmt.Errorf("Can't create RSD client of the rack %s: %v", rack.id, err)
+		}
+		if err := driver.AddRack(rack.id, rsd.NewRetryTransport(client)); err != nil {
+			return fmt.Errorf("Invalid racks: %v", err)
+		}
+		rsdClients = append(rsdClients, client)
+	}
+	driver.ClusterID = c.clusterID
+	driver.VolumeNamePrefix = c.volumeNamePrefix
+	driver.SetLogger(logger)
+	driver.SetPolicies(policies)
+	if err := driver.SetMode(mode); err != nil {
+		return err
+	}
+	if c.endpointTLS.CertFile != "" {
+		c.endpointTLS.ControllerClients = SplitList(c.controllerClients)
+		c.endpointTLS.NodeClients = SplitList(c.nodeClients)
+		if err := driver.SetEndpointTLS(c.endpointTLS); err != nil {
+			return fmt.Errorf("Invalid endpoint TLS configuration: %v", err)
+		}
+	}
+	if c.hostRoot != "" {
+		driver.SetHostRoot(c.hostRoot)
+	}
+	if c.mountBackend != "" {
+		if err := driver.SetMountBackend(c.mountBackend); err != nil {
+			return err
+		}
+	}
+	if c.nvmeBackend != "" {
+		if err := driver.SetNVMeBackend(c.nvmeBackend); err != nil {
+			return err
+		}
+	}
+	if c.defaultVolumeSize != "" {
+		size, err := csirsd.ParseSize(c.defaultVolumeSize)
+		if err != nil {
+			return fmt.Errorf("Invalid default volume size %q: %v", c.defaultVolumeSize, err)
+		}
+		driver.SetDefaultVolumeSize(size)
+	}
+	if c.maxTotalCapacity != "" {
+		budget, err := csirsd.ParseSize(c.maxTotalCapacity)
+		if err != nil {
+			return fmt.Errorf("Invalid max total capacity %q: %v", c.maxTotalCapacity, err)
+		}
+		driver.SetMaxTotalCapacity(budget)
+	}
+	driver.SetMaxConcurrentStages(c.maxConcurrentStages)
+	driver.SetMaxConcurrentDeletes(c.maxConcurrentDeletes)
+	driver.SetNodeCacheTTL(c.nodeCacheTTL)
+	driver.SetCapacityCacheTTL(c.capacityCacheTTL)
+	driver.SetFabricDirect(c.fabricDirect)
+	driver.SetTopology(c.topology)
+	driver.SetStrictCapabilities(c.strictCapabilities)
+	driver.SetExpandSecretsRequired(c.expandSecretsRequired)
+	if err := driver.SetSupportedFilesystems(SplitList(c.supportedFsTypes)); err != nil {
+		return err
+	}
+	driver.SetPowerOnNodes(c.powerOnNodes)
+	driver.SetPortalCheckTimeout(c.portalCheckTimeout)
+	driver.SetIdentityXattrs(c.identityXattrs)
+	driver.SetReconciliation(c.reconcileInterval, c.reconcileRepair)
+	if c.endPointSelection != "" {
+		if err := driver.SetEndPointSelection(c.endPointSelection, SplitList(c.preferredPortals)); err != nil {
+			return err
+		}
+	}
+	if err := driver.SetCSICompat(c.csiCompat); err != nil {
+		return err
+	}
+	if err := driver.SetTransportPreference(SplitList(c.transportPreference)); err != nil {
+		return err
+	}
+	if c.transportCheck != "" {
+		if err := driver.SetTransportCheck(c.transportCheck); err != nil {
+			return err
+		}
+	}
+	if c.eventFailureThreshold > 0 {
+		if sink, err := c.options.EventSink(); err != nil {
+			log.Printf("Kubernetes API is not reachable, volume events are not reported: %v", err)
+		} else {
+			driver.SetEventSink(sink, c.eventFailureThreshold)
+		}
+	}
+	if err := c.checkCSIDriver(driver); err != nil {
+		return err
+	}
+	if c.nodeNameMappingTTL > 0 {
+		source, err := c.options.NodeNameSource(rsdNodeLabel)
+		if err != nil {
+			return fmt.Errorf("Can't read Kubernetes node labels: %v", err)
+		}
+		driver.SetNodeNameMapping(source, c.nodeNameMappingTTL)
+	}
+	if c.mountHelper != "" {
+		if err := driver.SetMountHelper(c.mountHelper); err != nil {
+			return err
+		}
+	}
+	if c.nodeJournal != "" {
+		if err := driver.SetNodeJournal(c.nodeJournal); err != nil {
+			return err
+		}
+	}
+	if c.fakeNode {
+		if err := driver.SetFakeNode(); err != nil {
+			return err
+		}
+	}
+	if c.spareVolumes != "" {
+		counts, err := csirsd.ParseSpareVolumes(c.spareVolumes)
+		if err != nil {
+			return err
+		}
+		driver.SetSpareVolumes(counts)
+	}
+
+	var allocationSinks []csirsd.AllocationSink
+	if c.allocationCSV != "" {
+		allocationSinks = append(allocationSinks, csirsd.NewCSVAllocationSink(c.allocationCSV))
+	}
+	if c.allocationWebhook != "" {
+		allocationSinks = append(allocationSinks, csirsd.NewWebhookAllocationSink(c.allocationWebhook))
+	}
+	if c.allocationMetrics {
+		allocationSinks = append(allocationSinks, driver.AllocationMetricsSink())
+	}
+	if len(allocationSinks) > 0 {
+		if c.allocationInterval <= 0 {
+			return fmt.Errorf("Invalid allocation export interval %v", c.allocationInterval)
+		}
+		driver.SetAllocationSinks(allocationSinks...)
+	}
+
+	if c.httpAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", driver.MetricsHandler())
+		mux.Handle("/debug/", driver.DebugHandler(logs, recorder, schemas))
+		go func() {
+			log.Fatalln(http.ListenAndServe(c.httpAddress, mux))
+		}()
+	}
+
+	if c.resyncInterval > 0 {
+		go driver.ResyncVolumes(c.resyncInterval, nil)
+	}
+
+	if c.spareVolumes != "" {
+		go driver.RunSparePool(nil)
+	}
+
+	if c.capacityCacheTTL > 0 {
+		go driver.RunCapacityRefresh(nil)
+	}
+
+	if len(allocationSinks) > 0 {
+		go driver.RunAllocationExport(c.allocationInterval, nil)
+	}
+
+	if c.registrationDir != "" {
+		go driver.WatchRegistration(c.registrationDir, c.registrationInterval, nil)
+	}
+
+	stopped := make(chan struct{})
+	go handleSignals(driver, rsdClients, credentialFiles, stopped)
+	if credentialFiles.Enabled() && c.credentialsReload > 0 {
+		go watchCredentials(rsdClients, credentialFiles, c.credentialsReload, stopped)
+	}
+
+	if err := driver.Run(); err != nil {
+		return err
+	}
+	// Run returns without error only when the driver is stopped
+	<-stopped
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+func TestConfigDriverMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		binary  string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "combined default", args: nil, want: csirsd.DriverModeAll},
+		{name: "combined controller", args: []string{"-mode", "controller"}, want: csirsd.DriverModeController},
+		{name: "combined node", args: []string{"-mode", "node"}, want: csirsd.DriverModeNode},
+		{name: "combined empty", args: []string{"-mode", ""}, want: csirsd.DriverModeAll},
+		{name: "combined invalid", args: []string{"-mode", "both"}, wantErr: true},
+		{name: "all binary node", binary: csirsd.DriverModeAll, args: []string{"-mode", "node"}, want: csirsd.DriverModeNode},
+		{name: "all binary empty", binary: csirsd.DriverModeAll, args: []string{"-mode", ""}, want: csirsd.DriverModeAll},
+		{name: "controller binary", binary: csirsd.DriverModeController, want: csirsd.DriverModeController},
+		{name: "node binary", binary: csirsd.DriverModeNode, want: csirsd.DriverModeNode},
+		{name: "invalid binary", binary: "both", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("csirsd", flag.ContinueOnError)
+			c := RegisterFlags(flags, Options{Mode: tt.binary})
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.driverMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("driverMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("driverMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigDriverModeOfBinary(t *testing.T) {
+	// the mode flag isn't registered by the controller and node binaries,
+	// their mode wins over the mode set anyway
+	for _, binary := range []string{csirsd.DriverModeController, csirsd.DriverModeNode} {
+		c := &Config{options: Options{Mode: binary}, mode: csirsd.DriverModeAll}
+		if got, err := c.driverMode(); err != nil || got != binary {
+			t.Errorf("driverMode() of %s binary = %q, %v, want %q", binary, got, err, binary)
+		}
+	}
+}
+
+func TestConfigRSDNodeID(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		nodeID     string
+		label      func(name string) (string, error)
+		want       string
+		wantLookup bool
+		wantErr    bool
+	}{
+		{name: "controller", mode: csirsd.DriverModeController, want: ""},
+		{name: "controller with label", mode: csirsd.DriverModeController, label: func(string) (string, error) { return "2", nil }, want: ""},
+		{name: "controller with nodeid", mode: csirsd.DriverModeController, nodeID: "1", want: "1"},
+		{name: "node with nodeid", mode: csirsd.DriverModeNode, nodeID: "1", label: func(string) (string, error) { return "2", nil }, want: "1"},
+		{name: "node with label", mode: csirsd.DriverModeNode, label: func(string) (string, error) { return "2", nil }, want: "2", wantLookup: true},
+		{name: "all with label", mode: csirsd.DriverModeAll, label: func(string) (string, error) { return "2", nil }, want: "2", wantLookup: true},
+		{name: "node without label", mode: csirsd.DriverModeNode, wantErr: true},
+		{name: "node label failure", mode: csirsd.DriverModeNode, label: func(string) (string, error) { return "", errors.New("no label") }, wantLookup: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			looked := false
+			c := &Config{nodeID: tt.nodeID}
+			if tt.label != nil {
+				c.options.NodeLabel = func(name string) (string, error) {
+					looked = true
+					if name != rsdNodeLabel {
+						t.Errorf("looked up label %q, want %q", name, rsdNodeLabel)
+					}
+					return tt.label(name)
+				}
+			}
+			got, err := c.rsdNodeID(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rsdNodeID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rsdNodeID() = %q, want %q", got, tt.want)
+			}
+			if looked != tt.wantLookup {
+				t.Errorf("rsdNodeID() looked up the node label %v, want %v", looked, tt.wantLookup)
+			}
+		})
+	}
+}
+
+func TestConfigRSDPolicies(t *testing.T) {
+	c := &Config{
+		timeout:             time.Second,
+		writeTimeout:        time.Minute,
+		taskPollTimeout:     time.Minute,
+		requestRetries:      1,
+		requestRetryDelay:   time.Second,
+		requestRetryCodes:   "503",
+		nodeActionTimeout:   3 * time.Second,
+		endpointWaitTimeout: time.Second,
+		commandTimeout:      time.Minute,
+		deviceWaitTimeout:   2 * time.Second,
+	}
+	defaults := policy.Default()
+	controller := struct{ nodeAction, endpointWait policy.Retry }{
+		defaults.NodeAction.WithTimeout(c.nodeActionTimeout),
+		defaults.EndpointWait.WithTimeout(c.endpointWaitTimeout),
+	}
+	node := struct {
+		command    time.Duration
+		deviceWait policy.Retry
+	}{c.commandTimeout, defaults.DeviceWait.WithTimeout(c.deviceWaitTimeout)}
+
+	tests := []struct {
+		mode           string
+		wantController bool
+		wantNode       bool
+	}{
+		{mode: csirsd.DriverModeAll, wantController: true, wantNode: true},
+		{mode: csirsd.DriverModeController, wantController: true},
+		{mode: csirsd.DriverModeNode, wantNode: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := c.rsdPolicies(tt.mode)
+			if err != nil {
+				t.Fatalf("rsdPolicies() unexpected error: %v", err)
+			}
+			if got.ReadTimeout != c.timeout || got.WriteTimeout != c.writeTimeout || got.Request.Attempts != 2 ||
+				len(got.RetryStatusCodes) != 1 || got.RetryStatusCodes[0] != 503 {
+				t.Errorf("rsdPolicies() = %+v, want the RSD request flags applied", got)
+			}
+
+			wantNodeAction, wantEndpointWait := defaults.NodeAction, defaults.EndpointWait
+			if tt.wantController {
+				wantNodeAction, wantEndpointWait = controller.nodeAction, controller.endpointWait
+			}
+			if got.NodeAction != wantNodeAction || got.EndpointWait != wantEndpointWait {
+				t.Errorf("rsdPolicies() node action %+v, endpoint wait %+v, want %+v, %+v", got.NodeAction, got.EndpointWait, wantNodeAction, wantEndpointWait)
+			}
+
+			wantCommand, wantDeviceWait := defaults.CommandTimeout, defaults.DeviceWait
+			if tt.wantNode {
+				wantCommand, wantDeviceWait = node.command, node.deviceWait
+			}
+			if got.CommandTimeout != wantCommand || got.DeviceWait != wantDeviceWait {
+				t.Errorf("rsdPolicies() command timeout %v, device wait %+v, want %v, %+v", got.CommandTimeout, got.DeviceWait, wantCommand, wantDeviceWait)
+			}
+		})
+	}
+
+	for name, invalid := range map[string]*Config{
+		"negative retries": {requestRetries: -1},
+		"invalid code":     {requestRetryCodes: "5xx"},
+	} {
+		if _, err := invalid.rsdPolicies(csirsd.DriverModeAll); err == nil {
+			t.Errorf("rsdPolicies() with %s unexpected success", name)
+		}
+	}
+}
+
+func TestConfigRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		binary  string
+		args    []string
+		wantErr string
+	}{
+		{name: "invalid mode", args: []string{"-mode", "both"}, wantErr: "unsupported driver mode"},
+		{name: "invalid binary mode", binary: "both", wantErr: "unsupported driver mode"},
+		{name: "node without node ID", binary: csirsd.DriverModeNode, wantErr: "Can't get RSD node ID"},
+		{name: "all without node ID", args: []string{"-mode", ""}, wantErr: "Can't get RSD node ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("csirsd", flag.ContinueOnError)
+			c := RegisterFlags(flags, Options{Mode: tt.binary})
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Run(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// accessType is a way the volume is accessed on the node
+type accessType string
+
+const (
+	mountAccess accessType = "mount"
+	blockAccess accessType = "block"
+)
+
+// supportedCapabilities is a matrix of supported access modes and access types.
+// It's the only place where supported combinations are defined.
+// Files built with extra build tags can extend it using registerCapability.
+var supportedCapabilities = map[csi.VolumeCapability_AccessMode_Mode]map[accessType]bool{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER: {mountAccess: true, blockAccess: true},
+}
+
+// registerCapability adds access mode and access type combination to the supported ones
+func registerCapability(mode csi.VolumeCapability_AccessMode_Mode, access accessType) {
+	if supportedCapabilities[mode] == nil {
+		supportedCapabilities[mode] = map[accessType]bool{}
+	}
+	supportedCapabilities[mode][access] = true
+}
+
+// getAccessType returns access type of the capability or empty string if it's not set
+func getAccessType(capability *csi.VolumeCapability) accessType {
+	switch {
+	case capability.GetMount() != nil:
+		return mountAccess
+	case capability.GetBlock() != nil:
+		return blockAccess
+	}
+	return ""
+}
+
+// isReadOnlyMode checks if the access mode allows only read access
+func isReadOnlyMode(capability *csi.VolumeCapability) bool {
+	switch capability.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// isReadOnlyAccessMode checks if the access mode allows only read access
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return isReadOnlyMode(&csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}})
+}
+
+// isSingleNodeMultiWriterMode checks if the access mode is one of the single
+// node writer modes of CSI 1.5
+func isSingleNodeMultiWriterMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// accessModeControllerCapabilities returns the controller capabilities of the
+// supported access modes: PUBLISH_READONLY if a read only mode is supported
+// and SINGLE_NODE_MULTI_WRITER if the single node writer modes of CSI 1.5 are
+func accessModeControllerCapabilities() []csi.ControllerServiceCapability_RPC_Type {
+	var readOnly, multiWriter bool
+	for mode := range supportedCapabilities {
+		readOnly = readOnly || isReadOnlyAccessMode(mode)
+		multiWriter = multiWriter || isSingleNodeMultiWriterMode(mode)
+	}
+	var caps []csi.ControllerServiceCapability_RPC_Type
+	if readOnly {
+		caps = append(caps, csi.ControllerServiceCapability_RPC_PUBLISH_READONLY)
+	}
+	if multiWriter {
+		caps = append(caps, csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER)
+	}
+	return caps
+}
+
+// accessModeNodeCapabilities returns the node capabilities of the supported
+// access modes: SINGLE_NODE_MULTI_WRITER if the single node writer modes of
+// CSI 1.5 are supported
+func accessModeNodeCapabilities() []csi.NodeServiceCapability_RPC_Type {
+	for mode := range supportedCapabilities {
+		if isSingleNodeMultiWriterMode(mode) {
+			return []csi.NodeServiceCapability_RPC_Type{csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER}
+		}
+	}
+	return nil
+}
+
+// isExpandable checks if the volumes of any supported access mode can be
+// expanded, i.e. the matrix has a mode allowing writes
+func isExpandable() bool {
+	for mode := range supportedCapabilities {
+		if !isReadOnlyAccessMode(mode) {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedModes returns human readable list of supported access modes
+func supportedModes() string {
+	var modes []string
+	for mode := range supportedCapabilities {
+		modes = append(modes, mode.String())
+	}
+	sort.Strings(modes)
+	return strings.Join(modes, ", ")
+}
+
+// validateCapability checks if the capability is supported by the driver.
+// Access type is checked only if it's set, as CO may validate the access mode alone.
+func validateCapability(capability *csi.VolumeCapability) error {
+	if capability == nil || capability.AccessMode == nil {
+		return fmt.Errorf("access mode is missing")
+	}
+
+	types, exists := supportedCapabilities[capability.AccessMode.Mode]
+	if !exists {
+		return fmt.Errorf("unsupported access mode %s, supported modes: %s", capability.AccessMode.Mode, supportedModes())
+	}
+
+	if access := getAccessType(capability); access != "" && !types[access] {
+		return fmt.Errorf("unsupported access type %s for access mode %s", access, capability.AccessMode.Mode)
+	}
+
+	return nil
+}
+
+// validateNodeCapability checks if the capability is supported on the node.
+// Access mode is validated by the controller, so it's checked only if it's set.
+func validateNodeCapability(capability *csi.VolumeCapability) error {
+	if capability.GetAccessMode() != nil {
+		return validateCapability(capability)
+	}
+	for _, types := range supportedCapabilities {
+		if types[getAccessType(capability)] {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported access type %s", getAccessType(capability))
+}
+
+// validateCapabilities validates the requested capabilities.
+func validateCapabilities(caps []*csi.VolumeCapability) error {
+	for _, capability := range caps {
+		if err := validateCapability(capability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build readonlymodes
+// +build readonlymodes
+
+package csirsd
+
+import "github.com/container-storage-interface/spec/lib/go/csi"
+
+// Volumes published in read only mode are mounted with 'ro' option
+func init() {
+	registerCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, mountAccess)
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestAccessModeCapabilities(t *testing.T) {
+	saved := supportedCapabilities
+	defer func() { supportedCapabilities = saved }()
+
+	tests := []struct {
+		name                string
+		modes               []csi.VolumeCapability_AccessMode_Mode
+		wantReadOnly        bool
+		wantMultiWriter     bool
+		wantVolumeExpansion bool
+	}{
+		{
+			name:                "single node writer",
+			modes:               []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			wantVolumeExpansion: true,
+		},
+		{
+			name:                "read only",
+			modes:               []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY},
+			wantReadOnly:        true,
+			wantVolumeExpansion: true,
+		},
+		{
+			name:                "single node multi writer",
+			modes:               []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER},
+			wantMultiWriter:     true,
+			wantVolumeExpansion: true,
+		},
+		{
+			name:         "read only alone",
+			modes:        []csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+			wantReadOnly: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			supportedCapabilities = map[csi.VolumeCapability_AccessMode_Mode]map[accessType]bool{}
+			for _, mode := range tt.modes {
+				registerCapability(mode, mountAccess)
+			}
+			drv := &Driver{}
+
+			controller, err := drv.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("ControllerGetCapabilities() unexpected error: %v", err)
+			}
+			var readOnly, controllerMultiWriter bool
+			for _, cap := range controller.Capabilities {
+				switch cap.GetRpc().GetType() {
+				case csi.ControllerServiceCapability_RPC_PUBLISH_READONLY:
+					readOnly = true
+				case csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER:
+					controllerMultiWriter = true
+				}
+			}
+			if readOnly != tt.wantReadOnly || controllerMultiWriter != tt.wantMultiWriter {
+				t.Errorf("controller PUBLISH_READONLY %v, SINGLE_NODE_MULTI_WRITER %v, want %v, %v", readOnly, controllerMultiWriter, tt.wantReadOnly, tt.wantMultiWriter)
+			}
+
+			node, err := drv.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("NodeGetCapabilities() unexpected error: %v", err)
+			}
+			var nodeMultiWriter bool
+			for _, cap := range node.Capabilities {
+				nodeMultiWriter = nodeMultiWriter || cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER
+			}
+			if nodeMultiWriter != tt.wantMultiWriter {
+				t.Errorf("node SINGLE_NODE_MULTI_WRITER %v, want %v", nodeMultiWriter, tt.wantMultiWriter)
+			}
+
+			plugin, err := drv.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("GetPluginCapabilities() unexpected error: %v", err)
+			}
+			var volumeExpansion bool
+			for _, cap := range plugin.Capabilities {
+				volumeExpansion = volumeExpansion || cap.GetVolumeExpansion() != nil
+			}
+			if volumeExpansion != tt.wantVolumeExpansion {
+				t.Errorf("plugin volume expansion %v, want %v", volumeExpansion, tt.wantVolumeExpansion)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// AllowedNodesParameter is a StorageClass parameter with comma separated
+	// list of RSD node IDs or glob patterns the volume may be published to
+	AllowedNodesParameter = "allowedNodes"
+	// ForbiddenNodesParameter is a StorageClass parameter with comma separated
+	// list of RSD node IDs or glob patterns the volume must not be published to
+	ForbiddenNodesParameter = "forbiddenNodes"
+)
+
+// parseNodePatterns splits comma separated list of node patterns and validates them
+func parseNodePatterns(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid node pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// matchesAny checks if node ID matches any of the patterns
+func matchesAny(nodeID string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, nodeID); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// affinityContext validates node constraints in the CreateVolume parameters and
+// returns them to be stored in the volume context
+func affinityContext(parameters map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, key := range []string{AllowedNodesParameter, ForbiddenNodesParameter} {
+		value, exists := parameters[key]
+		if !exists {
+			continue
+		}
+		patterns, err := parseNodePatterns(value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", key, err)
+		}
+		if len(patterns) > 0 {
+			result[key] = strings.Join(patterns, ",")
+		}
+	}
+	return result, nil
+}
+
+// checkNodeAffinity checks if the volume with given context may be published to the node
+func checkNodeAffinity(volumeContext map[string]string, nodeID string) error {
+	forbidden, err := parseNodePatterns(volumeContext[ForbiddenNodesParameter])
+	if err != nil {
+		return err
+	}
+	if matchesAny(nodeID, forbidden) {
+		return fmt.Errorf("node %s is forbidden by %s=%q", nodeID, ForbiddenNodesParameter, volumeContext[ForbiddenNodesParameter])
+	}
+
+	allowed, err := parseNodePatterns(volumeContext[AllowedNodesParameter])
+	if err != nil {
+		return err
+	}
+	if len(allowed) > 0 && !matchesAny(nodeID, allowed) {
+		return fmt.Errorf("node %s is not allowed by %s=%q", nodeID, AllowedNodesParameter, volumeContext[AllowedNodesParameter])
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAffinityContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "no constraints",
+			parameters: map[string]string{"fsType": "ext4"},
+			want:       map[string]string{},
+		},
+		{
+			name:       "normalized lists",
+			parameters: map[string]string{AllowedNodesParameter: " 1, rack1-*,", ForbiddenNodesParameter: "rack1-2"},
+			want:       map[string]string{AllowedNodesParameter: "1,rack1-*", ForbiddenNodesParameter: "rack1-2"},
+		},
+		{
+			name:       "invalid pattern",
+			parameters: map[string]string{AllowedNodesParameter: "rack[1"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := affinityContext(tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("affinityContext() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("affinityContext() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckNodeAffinity(t *testing.T) {
+	tests := []struct {
+		name          string
+		volumeContext map[string]string
+		nodeID        string
+		wantErr       bool
+	}{
+		{
+			name:   "no constraints",
+			nodeID: "1",
+		},
+		{
+			name:          "allowed node",
+			volumeContext: map[string]string{AllowedNodesParameter: "1,2"},
+			nodeID:        "2",
+		},
+		{
+			name:          "node is not allowed",
+			volumeContext: map[string]string{AllowedNodesParameter: "1,2"},
+			nodeID:        "3",
+			wantErr:       true,
+		},
+		{
+			name:          "allowed by pattern",
+			volumeContext: map[string]string{AllowedNodesParameter: "rack1-*"},
+			nodeID:        "rack1-7",
+		},
+		{
+			name:          "forbidden node",
+			volumeContext: map[string]string{ForbiddenNodesParameter: "rack2-*"},
+			nodeID:        "rack2-1",
+			wantErr:       true,
+		},
+		{
+			name:          "forbidden overrides allowed",
+			volumeContext: map[string]string{AllowedNodesParameter: "rack1-*", ForbiddenNodesParameter: "rack1-2"},
+			nodeID:        "rack1-2",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkNodeAffinity(tt.volumeContext, tt.nodeID); (err != nil) != tt.wantErr {
+				t.Errorf("checkNodeAffinity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CreateVolume parameters with the PVC passed by external-provisioner with --extra-create-metadata
+const (
+	pvcNameParameter      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceParameter = "csi.storage.k8s.io/pvc/namespace"
+)
+
+// webhookTimeout limits exporting allocation records to the HTTP webhook
+const webhookTimeout = 30 * time.Second
+
+// AllocationRecord describes RSD storage allocated for a volume, e.g. for chargeback
+type AllocationRecord struct {
+	VolumeID      string `json:"volumeId"`
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace,omitempty"`
+	PVC           string `json:"pvc,omitempty"`
+	CapacityBytes int64  `json:"capacityBytes"`
+	Pool          string `json:"pool,omitempty"`
+	// Created is nil for the volumes adopted after the driver restart
+	Created *time.Time `json:"created,omitempty"`
+	// Deleted is nil until the volume is deleted
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+// AllocationSink receives allocation records of all volumes exported periodically
+// by the driver. Deleted volumes are exported until all sinks accept them.
+type AllocationSink interface {
+	ExportAllocations(timestamp time.Time, records []AllocationRecord) error
+}
+
+// allocationLog keeps allocation records of the volumes. All methods are no-op
+// for nil allocationLog, so allocations are not tracked unless they're exported.
+type allocationLog struct {
+	mu    sync.Mutex
+	sinks []AllocationSink
+	// records maps IDs of the existing volumes to their records
+	records map[string]*AllocationRecord
+	// deleted are records of the deleted volumes not exported yet, kept
+	// apart as RSD may reuse the volume ID
+	deleted []*AllocationRecord
+	now     func() time.Time
+}
+
+// SetAllocationSinks makes the driver track allocation records of the volumes
+// and export them to the sinks by RunAllocationExport
+func (drv *Driver) SetAllocationSinks(sinks ...AllocationSink) {
+	drv.allocations = &allocationLog{
+		sinks:   sinks,
+		records: map[string]*AllocationRecord{},
+		now:     drv.now,
+	}
+}
+
+// volumePool returns OdataID of the storage pool providing capacity of the volume
+func volumePool(volume *Volume) string {
+	for _, source := range volume.RSDVolume.CapacitySources {
+		for _, pool := range source.ProvidingPools {
+			if id := pool["@odata.id"]; id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// created records allocation of the volume created with the CreateVolume parameters
+func (allocations *allocationLog) created(volume *Volume, parameters map[string]string) {
+	if allocations == nil {
+		return
+	}
+	created := allocations.now()
+	allocations.add(volume, parameters[pvcNamespaceParameter], parameters[pvcNameParameter], &created)
+}
+
+// adopted records allocation of the volume adopted after the driver restart,
+// its PVC and creation time are unknown
+func (allocations *allocationLog) adopted(volume *Volume) {
+	if allocations == nil {
+		return
+	}
+	allocations.add(volume, "", "", nil)
+}
+
+// add adds allocation record of the volume unless it's already known
+func (allocations *allocationLog) add(volume *Volume, namespace, pvc string, created *time.Time) {
+	allocations.mu.Lock()
+	defer allocations.mu.Unlock()
+
+	volumeID := volume.CSIVolume.VolumeId
+	if _, exists := allocations.records[volumeID]; exists {
+		return
+	}
+	allocations.records[volumeID] = &AllocationRecord{
+		VolumeID:      volumeID,
+		Name:          volume.Name,
+		Namespace:     namespace,
+		PVC:           pvc,
+		CapacityBytes: volume.CSIVolume.CapacityBytes,
+		Pool:          volumePool(volume),
+		Created:       created,
+	}
+}
+
+// remove records deletion of the volume
+func (allocations *allocationLog) remove(volumeID string) {
+	if allocations == nil {
+		return
+	}
+	allocations.mu.Lock()
+	defer allocations.mu.Unlock()
+
+	if record, exists := allocations.records[volumeID]; exists {
+		deleted := allocations.now()
+		record.Deleted = &deleted
+		allocations.deleted = append(allocations.deleted, record)
+		delete(allocations.records, volumeID)
+	}
+}
+
+// allocationRecords returns copies of the allocation records sorted by volume ID,
+// with capacity of the existing volumes refreshed, and the number of records
+// of the deleted volumes among them
+func (drv *Driver) allocationRecords() ([]AllocationRecord, int) {
+	allocations := drv.allocations
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+	allocations.mu.Lock()
+	defer allocations.mu.Unlock()
+
+	result := make([]AllocationRecord, 0, len(allocations.records)+len(allocations.deleted))
+	for volumeID, record := range allocations.records {
+		if _, vol := drv.findVolByID(volumeID); vol != nil {
+			record.CapacityBytes = vol.CSIVolume.CapacityBytes
+		}
+		result = append(result, *record)
+	}
+	for _, record := range allocations.deleted {
+		result = append(result, *record)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].VolumeID < result[j].VolumeID })
+	return result, len(allocations.deleted)
+}
+
+// exportAllocations exports the records to all sinks. Records of the deleted
+// volumes are dropped once all sinks accept them.
+func (drv *Driver) exportAllocations() {
+	allocations := drv.allocations
+	records, deleted := drv.allocationRecords()
+	timestamp := allocations.now()
+
+	failed := false
+	for _, sink := range allocations.sinks {
+		if err := sink.ExportAllocations(timestamp, records); err != nil {
+			drv.logger.Error(err, "can't export volume allocations")
+			failed = true
+		}
+	}
+	if failed {
+		return
+	}
+
+	// volumes deleted during the export stay for the next one
+	allocations.mu.Lock()
+	defer allocations.mu.Unlock()
+	allocations.deleted = allocations.deleted[deleted:]
+}
+
+// RunAllocationExport exports allocation records to the sinks set by SetAllocationSinks
+// every interval until stop is closed
+func (drv *Driver) RunAllocationExport(interval time.Duration, stop <-chan struct{}) {
+	if drv.allocations == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			drv.exportAllocations()
+		}
+	}
+}
+
+// formatTime formats optional time for the CSV sink
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvSink appends allocation records to the CSV file, one row per volume and export
+type csvSink struct {
+	path string
+}
+
+// NewCSVAllocationSink returns AllocationSink appending records to the CSV file
+func NewCSVAllocationSink(path string) AllocationSink {
+	return &csvSink{path: path}
+}
+
+// csvHeader is the first line of the CSV file
+var csvHeader = []string{"timestamp", "volume_id", "name", "namespace", "pvc", "capacity_bytes", "pool", "created", "deleted"}
+
+func (sink *csvSink) ExportAllocations(timestamp time.Time, records []AllocationRecord) error {
+	f, err := os.OpenFile(sink.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+
+	// write all rows at once, so the file doesn't end with a partial export
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if info.Size() == 0 {
+		w.Write(csvHeader) // nolint: errcheck
+	}
+	for _, record := range records {
+		w.Write([]string{ // nolint: errcheck
+			timestamp.UTC().Format(time.RFC3339),
+			record.VolumeID,
+			record.Name,
+			record.Namespace,
+			record.PVC,
+			strconv.FormatInt(record.CapacityBytes, 10),
+			record.Pool,
+			formatTime(record.Created),
+			formatTime(record.Deleted),
+		})
+	}
+	w.Flush()
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	return f.Close()
+}
+
+// webhookSink posts allocation records as JSON to the HTTP endpoint
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookAllocationSink returns AllocationSink posting records as JSON
+// {"timestamp": ..., "allocations": [...]} to the URL
+func NewWebhookAllocationSink(url string) AllocationSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (sink *webhookSink) ExportAllocations(timestamp time.Time, records []AllocationRecord) error {
+	body, err := json.Marshal(struct {
+		Timestamp   time.Time          `json:"timestamp"`
+		Allocations []AllocationRecord `json:"allocations"`
+	}{timestamp.UTC(), records})
+	if err != nil {
+		return err
+	}
+
+	resp, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP error %d while posting allocations to %s", resp.StatusCode, sink.url)
+	}
+	return nil
+}
+
+// metricsSink exposes allocations of the existing volumes as driver metrics
+type metricsSink struct {
+	allocated *metricVec
+	created   *metricVec
+}
+
+// AllocationMetricsSink returns AllocationSink exposing allocated capacity
+// and creation time of the existing volumes on the driver /metrics
+func (drv *Driver) AllocationMetricsSink() AllocationSink {
+	return &metricsSink{allocated: drv.metrics.volumeAllocatedBytes, created: drv.metrics.volumeCreatedTimestamp}
+}
+
+func (sink *metricsSink) ExportAllocations(timestamp time.Time, records []AllocationRecord) error {
+	sink.allocated.Reset()
+	sink.created.Reset()
+	for _, record := range records {
+		if record.Deleted != nil {
+			continue
+		}
+		labels := []string{record.VolumeID, record.Namespace, record.PVC, record.Pool}
+		sink.allocated.Set(float64(record.CapacityBytes), labels...)
+		if record.Created != nil {
+			sink.created.Set(float64(record.Created.Unix()), labels...)
+		}
+	}
+	return nil
+}
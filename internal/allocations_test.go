@@ -0,0 +1,147 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// recordingSink records exported allocations and fails if err is set
+type recordingSink struct {
+	exports [][]AllocationRecord
+	err     error
+}
+
+func (sink *recordingSink) ExportAllocations(timestamp time.Time, records []AllocationRecord) error {
+	sink.exports = append(sink.exports, records)
+	return sink.err
+}
+
+func TestExportAllocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	csvPath := filepath.Join(dir, "allocations.csv")
+
+	drv := &Driver{
+		ClusterID:        "cluster1",
+		VolumeNamePrefix: "pvc-",
+		rsdClient: &TestClient{results: map[string]string{
+			"/redfish/v1/StorageServices":           `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+			"/redfish/v1/StorageServices/1":         `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+			"/redfish/v1/StorageServices/1/Volumes": `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}`,
+			"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100,
+				"CapacitySources": [{"ProvidingPools": [{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1"}]}]}`,
+			"/redfish/v1/StorageServices/1/Volumes/2": `{"Id": "2", "CapacityBytes": 200, "Description": "csi.rsd.intel.com:cluster1:pvc-2"}`,
+		}},
+		volumes: map[string]*Volume{},
+		metrics: newDriverMetrics(),
+	}
+	sink := &recordingSink{}
+	drv.SetAllocationSinks(sink, NewCSVAllocationSink(csvPath), drv.AllocationMetricsSink())
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	drv.allocations.now = func() time.Time { return now }
+
+	if err := drv.adoptVolumes(); err != nil {
+		t.Fatalf("adoptVolumes() unexpected error: %v", err)
+	}
+	_, err = drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100},
+		Parameters:    map[string]string{pvcNameParameter: "data", pvcNamespaceParameter: "tenant1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() unexpected error: %v", err)
+	}
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "2"}); err != nil {
+		t.Fatalf("DeleteVolume() unexpected error: %v", err)
+	}
+
+	// failed export keeps the deleted volume
+	sink.err = errors.New("sink is down")
+	drv.exportAllocations()
+	sink.err = nil
+	drv.exportAllocations()
+	drv.exportAllocations()
+
+	if len(sink.exports) != 3 {
+		t.Fatalf("%d exports, want 3", len(sink.exports))
+	}
+	for i, records := range sink.exports[:2] {
+		if len(records) != 2 {
+			t.Fatalf("export %d: records %+v, want 2", i, records)
+		}
+		created, deleted := records[0], records[1]
+		if created.VolumeID != "1" || created.Namespace != "tenant1" || created.PVC != "data" || created.CapacityBytes != 100 ||
+			created.Pool != "/redfish/v1/StorageServices/1/StoragePools/1" || created.Created == nil || !created.Created.Equal(now) || created.Deleted != nil {
+			t.Errorf("export %d: unexpected record of the created volume %+v", i, created)
+		}
+		if deleted.VolumeID != "2" || deleted.Name != "pvc-2" || deleted.CapacityBytes != 200 || deleted.Created != nil || deleted.Deleted == nil {
+			t.Errorf("export %d: unexpected record of the adopted and deleted volume %+v", i, deleted)
+		}
+	}
+	if records := sink.exports[2]; len(records) != 1 || records[0].VolumeID != "1" {
+		t.Errorf("records after deleted volume export %+v, want volume 1 only", records)
+	}
+
+	content, err := ioutil.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	wantLines := []string{
+		"timestamp,volume_id,name,namespace,pvc,capacity_bytes,pool,created,deleted",
+		"2019-07-01T12:00:00Z,1,pvc-1,tenant1,data,100,/redfish/v1/StorageServices/1/StoragePools/1,2019-07-01T12:00:00Z,",
+		"2019-07-01T12:00:00Z,2,pvc-2,,,200,,,2019-07-01T12:00:00Z",
+	}
+	if len(lines) != 6 {
+		t.Fatalf("CSV file has %d lines, want 6:\n%s", len(lines), content)
+	}
+	for i, want := range wantLines {
+		if lines[i] != want {
+			t.Errorf("CSV line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	for _, want := range []string{
+		`csi_rsd_volume_allocated_bytes{volume_id="1",namespace="tenant1",pvc="data",pool="/redfish/v1/StorageServices/1/StoragePools/1"} 100`,
+		`csi_rsd_volume_created_timestamp_seconds{volume_id="1",namespace="tenant1",pvc="data",pool="/redfish/v1/StorageServices/1/StoragePools/1"} 1.5619824e+09`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics don't contain %s:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `volume_id="2"`) {
+		t.Errorf("metrics contain deleted volume:\n%s", metrics)
+	}
+}
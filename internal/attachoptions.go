@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+const (
+	// AttachProtocolParameter is a StorageClass parameter with the protocol
+	// RSD attaches the volume to the node with, e.g. NVMeOverFabrics
+	AttachProtocolParameter = "attachProtocol"
+	// MinBandwidthParameter is a StorageClass parameter with the fabric
+	// bandwidth in Mbps reserved for the volume attachment
+	MinBandwidthParameter = "minBandwidthMbps"
+	// MaxBandwidthParameter is a StorageClass parameter limiting the fabric
+	// bandwidth in Mbps of the volume attachment
+	MaxBandwidthParameter = "maxBandwidthMbps"
+	// MaxIOPSParameter is a StorageClass parameter limiting I/O operations
+	// per second of the volume attachment
+	MaxIOPSParameter = "maxIOPS"
+)
+
+// attachQoSParameters are the numeric attach parameters
+var attachQoSParameters = []string{MinBandwidthParameter, MaxBandwidthParameter, MaxIOPSParameter}
+
+// attachContext validates attach options in the CreateVolume parameters and
+// returns them to be stored in the volume context
+func attachContext(parameters map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, key := range append([]string{AttachProtocolParameter}, attachQoSParameters...) {
+		if value := parameters[key]; value != "" {
+			result[key] = value
+		}
+	}
+	if _, err := attachOptions(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// hasAttachOptions checks if any attach option is set in the volume context
+func hasAttachOptions(volumeContext map[string]string) bool {
+	for _, key := range append([]string{AttachProtocolParameter}, attachQoSParameters...) {
+		if volumeContext[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// attachOptions returns RSD attach options set in the volume context,
+// nil if none of them is set
+func attachOptions(volumeContext map[string]string) (*rsd.AttachOptions, error) {
+	if !hasAttachOptions(volumeContext) {
+		return nil, nil
+	}
+
+	values := map[string]int64{}
+	for _, key := range attachQoSParameters {
+		value, exists := volumeContext[key]
+		if !exists || value == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("parameter %s: %q is not a positive integer", key, value)
+		}
+		values[key] = number
+	}
+
+	opts := &rsd.AttachOptions{
+		Protocol:         volumeContext[AttachProtocolParameter],
+		MinBandwidthMbps: values[MinBandwidthParameter],
+		MaxBandwidthMbps: values[MaxBandwidthParameter],
+		MaxIOPS:          values[MaxIOPSParameter],
+	}
+	if opts.MaxBandwidthMbps > 0 && opts.MinBandwidthMbps > opts.MaxBandwidthMbps {
+		return nil, fmt.Errorf("parameter %s: %d is greater than %s %d", MinBandwidthParameter, opts.MinBandwidthMbps, MaxBandwidthParameter, opts.MaxBandwidthMbps)
+	}
+	return opts, nil
+}
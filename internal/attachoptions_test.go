@@ -0,0 +1,83 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestAttachOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       *rsd.AttachOptions
+		wantErr    bool
+	}{
+		{
+			name:       "no options",
+			parameters: map[string]string{AllowedNodesParameter: "node-1"},
+		},
+		{
+			name: "all options",
+			parameters: map[string]string{
+				AttachProtocolParameter: "NVMeOverFabrics",
+				MinBandwidthParameter:   "1000",
+				MaxBandwidthParameter:   "2000",
+				MaxIOPSParameter:        "50000",
+			},
+			want: &rsd.AttachOptions{Protocol: "NVMeOverFabrics", MinBandwidthMbps: 1000, MaxBandwidthMbps: 2000, MaxIOPS: 50000},
+		},
+		{
+			name:       "not a number",
+			parameters: map[string]string{MaxIOPSParameter: "fast"},
+			wantErr:    true,
+		},
+		{
+			name:       "negative",
+			parameters: map[string]string{MinBandwidthParameter: "-1"},
+			wantErr:    true,
+		},
+		{
+			name:       "min greater than max",
+			parameters: map[string]string{MinBandwidthParameter: "2000", MaxBandwidthParameter: "1000"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumeContext, err := attachContext(tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("attachContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, exists := volumeContext[AllowedNodesParameter]; exists {
+				t.Errorf("attachContext() = %v contains other parameters", volumeContext)
+			}
+			got, err := attachOptions(volumeContext)
+			if err != nil {
+				t.Fatalf("attachOptions() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attachOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
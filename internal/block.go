@@ -0,0 +1,90 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// Raw block volumes are staged by connecting them to the node only: they are
+// neither formatted nor mounted to the staging path. Publishing bind-mounts
+// the NVMe device node to the target path, which is a file in this case.
+
+// nodeStageBlockVolume connects the volume to the node using nvme connect
+func (drv *Driver) nodeStageBlockVolume(ctx context.Context, volume *Volume, volumeContext map[string]string, stagingTargetPath string, secrets stageSecrets) error {
+	if !volume.IsPublished {
+		return fmt.Errorf("nodeStageBlockVolume: volume %s is not published", volume.Name)
+	}
+
+	if volume.IsStaged {
+		if !volume.IsBlock {
+			return fmt.Errorf("nodeStageBlockVolume: volume %s is staged with a filesystem", volume.Name)
+		}
+		reason := drv.staleStaging(volume)
+		if reason == "" {
+			return nil
+		}
+		if err := drv.resetStaleStaging(volume, reason); err != nil {
+			return err
+		}
+	}
+
+	if volume.EndPoint == nil {
+		return fmt.Errorf("nodeStageBlockVolume: no endpoint found for volume %s", volume.Name)
+	}
+
+	override, err := parsePortalOverride(volumeContext)
+	if err != nil {
+		return err
+	}
+	dev, err := drv.connectVolume(ctx, volume, override, secrets)
+	if err != nil {
+		return err
+	}
+
+	volume.Device = dev
+	volume.DeviceByID = resolveDeviceByID(drv.hostRoot, dev)
+	volume.IsBlock = true
+	volume.IsStaged = true
+	volume.StagingTargetPath = stagingTargetPath
+
+	return nil
+}
+
+// nodePublishBlockVolume bind-mounts the device of the staged volume to the Target Path
+func (drv *Driver) nodePublishBlockVolume(volume *Volume, targetPath string, mountOpts []string) error {
+	if !volume.IsBlock || volume.Device == "" {
+		return fmt.Errorf("nodePublishBlockVolume: volume %s is not staged as a block device", volume.Name)
+	}
+
+	// the source of the device bind mount is reported as devtmpfs,
+	// so only the target is checked
+	mounted, err := drv.mounter.IsMounted("", targetPath)
+	if err != nil {
+		return err
+	}
+
+	if !mounted {
+		if err := drv.mounter.MountBlock(volume.Device, targetPath, mountOpts...); err != nil {
+			return err
+		}
+	}
+
+	volume.TargetPaths[targetPath] = true
+
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// blockMounter records the mount calls
+type blockMounter struct {
+	testMounter
+	calls []string
+}
+
+func (m *blockMounter) Mount(source string, target string, fstype string, opts ...string) error {
+	m.calls = append(m.calls, "mount "+source+" "+target)
+	return nil
+}
+
+func (m *blockMounter) MountBlock(source, target string, opts ...string) error {
+	m.calls = append(m.calls, "mount block "+source+" "+target)
+	for _, opt := range opts {
+		m.calls = append(m.calls, "option "+opt)
+	}
+	return nil
+}
+
+func (m *blockMounter) Format(source, fsType, label string, opts ...string) error {
+	m.calls = append(m.calls, "format "+source)
+	return nil
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func TestBlockVolume(t *testing.T) {
+	mounter := &blockMounter{}
+	drv := &Driver{
+		nvme:    &testNVMe{},
+		mounter: mounter,
+		volumes: map[string]*Volume{
+			"pvc-1": {
+				Name:        "pvc-1",
+				CSIVolume:   &csi.Volume{VolumeId: "1"},
+				RSDVolume:   &rsd.Volume{},
+				EndPoint:    &endpoint.Portal{Transport: "rdma", Address: "192.168.1.1", Port: 4420, NQN: "nqn.1"},
+				IsPublished: true,
+				TargetPaths: map[string]bool{},
+			},
+		},
+	}
+
+	_, err := drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: "/staging",
+		VolumeCapability:  blockCapability(),
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume() unexpected error: %v", err)
+	}
+	vol := drv.volumes["pvc-1"]
+	if !vol.IsStaged || !vol.IsBlock || vol.Device != "/dev/nvme1n1" {
+		t.Errorf("volume is not staged as a block device: %+v", vol)
+	}
+	if len(mounter.calls) != 0 {
+		t.Errorf("NodeStageVolume() formatted or mounted the block volume: %v", mounter.calls)
+	}
+
+	_, err = drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: "/staging",
+		TargetPath:        "/target",
+		Readonly:          true,
+		VolumeCapability:  blockCapability(),
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume() unexpected error: %v", err)
+	}
+	want := []string{"mount block /dev/nvme1n1 /target", "option ro"}
+	if !reflect.DeepEqual(mounter.calls, want) {
+		t.Errorf("NodePublishVolume() mounted %v, want %v", mounter.calls, want)
+	}
+	if !vol.TargetPaths["/target"] {
+		t.Error("target path of the block volume is not recorded")
+	}
+
+	if err := drv.nodeExpandVolume(vol); err != nil {
+		t.Errorf("nodeExpandVolume() of block volume unexpected error: %v", err)
+	}
+
+	_, err = drv.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "1", StagingTargetPath: "/staging"})
+	if err != nil {
+		t.Fatalf("NodeUnstageVolume() unexpected error: %v", err)
+	}
+	if vol.IsStaged || vol.IsBlock || vol.Device != "" {
+		t.Errorf("volume is not unstaged: %+v", vol)
+	}
+}
+
+func TestNodePublishRecoversBlockStaging(t *testing.T) {
+	mounter := &blockMounter{}
+	drv := &Driver{
+		nvme:    &testNVMe{},
+		mounter: mounter,
+		volumes: map[string]*Volume{
+			"pvc-1": {Name: "pvc-1", CSIVolume: &csi.Volume{VolumeId: "1"}},
+		},
+	}
+
+	_, err := drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "1",
+		PublishContext:    map[string]string{PublishInfoSubsystemNQN: "nqn.2014-08.org.nvmexpress:uuid:1"},
+		StagingTargetPath: "/staging",
+		TargetPath:        "/target",
+		VolumeCapability:  blockCapability(),
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume() unexpected error: %v", err)
+	}
+	vol := drv.volumes["pvc-1"]
+	if !vol.IsStaged || !vol.IsBlock || vol.Device != "/dev/nvme1n1" {
+		t.Errorf("block volume staging is not recovered: %+v", vol)
+	}
+	want := []string{"mount block /dev/nvme1n1 /target"}
+	if !reflect.DeepEqual(mounter.calls, want) {
+		t.Errorf("NodePublishVolume() mounted %v, want %v", mounter.calls, want)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// capacityKey selects the storage services the capacity is summed of
+type capacityKey struct {
+	serviceID   string
+	serviceName string
+	pool        string
+	rack        string
+	// topology is the text of the accessibility requirement
+	topology string
+}
+
+func capacityKeyOf(provisioning *volumeProvisioning) capacityKey {
+	key := capacityKey{
+		serviceID:   provisioning.storageService(),
+		serviceName: provisioning.storageServiceName(),
+		pool:        provisioning.storagePool(),
+		rack:        provisioning.rackID(),
+	}
+	if requirement := provisioning.accessibilityRequirement(); requirement != nil {
+		key.topology = requirement.String()
+	}
+	return key
+}
+
+type capacityCacheEntry struct {
+	provisioning *volumeProvisioning
+	capacity     int64
+	fetched      time.Time
+	// used is set when the entry is served, the entries not used
+	// since the previous refresh are dropped instead of refreshed
+	used bool
+}
+
+// capacityCache keeps available capacity of the storage services, so
+// repeated GetCapacity calls of the scheduler don't query RSD every time.
+// The capacity is dropped when the driver creates, deletes or expands volumes.
+// All methods are no-op for nil capacityCache, i.e. caching is disabled.
+type capacityCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[capacityKey]*capacityCacheEntry
+	// generation changes on every invalidation, so the capacity queried
+	// before it isn't cached
+	generation uint64
+	now        func() time.Time
+}
+
+func newCapacityCache(ttl time.Duration) *capacityCache {
+	return &capacityCache{
+		ttl:     ttl,
+		entries: map[capacityKey]*capacityCacheEntry{},
+		now:     time.Now,
+	}
+}
+
+// get returns the cached capacity and its age. It returns false if the
+// capacity is not cached or expired.
+func (cache *capacityCache) get(key capacityKey) (int64, time.Duration, bool) {
+	if cache == nil {
+		return 0, 0, false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, exists := cache.entries[key]
+	if !exists {
+		return 0, 0, false
+	}
+	age := cache.now().Sub(entry.fetched)
+	if age >= cache.ttl {
+		return 0, 0, false
+	}
+	entry.used = true
+	return entry.capacity, age, true
+}
+
+// begin returns the generation the capacity is queried in
+func (cache *capacityCache) begin() uint64 {
+	if cache == nil {
+		return 0
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.generation
+}
+
+// add caches the capacity queried in the generation, unless it was invalidated since
+func (cache *capacityCache) add(key capacityKey, provisioning *volumeProvisioning, capacity int64, generation uint64) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	entry, exists := cache.entries[key]
+	if !exists {
+		entry = &capacityCacheEntry{provisioning: provisioning, used: true}
+		cache.entries[key] = entry
+	}
+	entry.capacity = capacity
+	entry.fetched = cache.now()
+}
+
+// invalidate drops all the cached capacity
+func (cache *capacityCache) invalidate() {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.generation++
+	cache.entries = map[capacityKey]*capacityCacheEntry{}
+}
+
+// stale returns provisioning of the entries to refresh and drops the
+// entries which weren't served since the previous refresh
+func (cache *capacityCache) stale() map[capacityKey]*volumeProvisioning {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	result := map[capacityKey]*volumeProvisioning{}
+	for key, entry := range cache.entries {
+		if !entry.used {
+			delete(cache.entries, key)
+			continue
+		}
+		entry.used = false
+		result[key] = entry.provisioning
+	}
+	return result
+}
+
+// oldest returns age of the oldest cached capacity, 0 if nothing is cached
+func (cache *capacityCache) oldest() time.Duration {
+	if cache == nil {
+		return 0
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	var result time.Duration
+	for _, entry := range cache.entries {
+		if age := cache.now().Sub(entry.fetched); age > result {
+			result = age
+		}
+	}
+	return result
+}
+
+// SetCapacityCacheTTL enables caching of the capacity reported by GetCapacity.
+// Zero ttl disables caching.
+func (drv *Driver) SetCapacityCacheTTL(ttl time.Duration) {
+	drv.capacity = nil
+	if ttl > 0 {
+		drv.capacity = newCapacityCache(ttl)
+		drv.capacity.now = drv.now
+	}
+}
+
+// cachedCapacity returns available capacity of the storage services
+// from the cache or queries it
+func (drv *Driver) cachedCapacity(ctx context.Context, provisioning *volumeProvisioning) (int64, error) {
+	if drv.capacity == nil {
+		return drv.getCapacity(ctx, provisioning)
+	}
+
+	key := capacityKeyOf(provisioning)
+	if capacity, _, ok := drv.capacity.get(key); ok {
+		drv.metrics.capacityCacheRequests.Inc("hit")
+		return capacity, nil
+	}
+	drv.metrics.capacityCacheRequests.Inc("miss")
+	return drv.refreshCapacityCache(ctx, key, provisioning)
+}
+
+// refreshCapacityCache queries available capacity of the storage services and caches it
+func (drv *Driver) refreshCapacityCache(ctx context.Context, key capacityKey, provisioning *volumeProvisioning) (int64, error) {
+	generation := drv.capacity.begin()
+	capacity, err := drv.getCapacity(ctx, provisioning)
+	if err != nil {
+		return 0, err
+	}
+	drv.capacity.add(key, provisioning, capacity, generation)
+	return capacity, nil
+}
+
+// RunCapacityRefresh refreshes the cached capacity served since the previous
+// refresh every half of the cache ttl until stop is closed, so GetCapacity
+// calls repeated by the scheduler are served from the cache
+func (drv *Driver) RunCapacityRefresh(stop <-chan struct{}) {
+	if drv.capacity == nil {
+		return
+	}
+	ticker := time.NewTicker(drv.capacity.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for key, provisioning := range drv.capacity.stale() {
+				if _, err := drv.refreshCapacityCache(context.Background(), key, provisioning); err != nil {
+					drv.logger.Warning("can't refresh cached capacity", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// collectCapacityCacheMetrics reports age of the oldest cached capacity
+func (drv *Driver) collectCapacityCacheMetrics() {
+	if drv.capacity == nil {
+		return
+	}
+	drv.metrics.capacityCacheAge.Set(drv.capacity.oldest().Seconds())
+}
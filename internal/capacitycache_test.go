@@ -0,0 +1,109 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestCapacityCache(t *testing.T) {
+	client := &countingClient{TestClient: *twoServicesClient(), gets: map[string]int{}}
+	drv := &Driver{rsdClient: client}
+	drv.SetCapacityCacheTTL(time.Minute)
+	now := time.Now()
+	drv.capacity.now = func() time.Time { return now }
+
+	// getCapacity checks the number of the storage pool queries
+	getCapacity := func(parameters map[string]string, want int64, wantGets int) {
+		t.Helper()
+		resp, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: parameters})
+		if err != nil {
+			t.Fatalf("GetCapacity(%v) unexpected error: %v", parameters, err)
+		}
+		if resp.AvailableCapacity != want {
+			t.Errorf("GetCapacity(%v) = %d, want %d", parameters, resp.AvailableCapacity, want)
+		}
+		if gets := client.gets["/redfish/v1/StorageServices/1/StoragePools/1"]; gets != wantGets {
+			t.Fatalf("GetCapacity(%v) queried RSD storage pool %d times, want %d", parameters, gets, wantGets)
+		}
+	}
+	rackA := map[string]string{StorageServiceNameParameter: "rack-a"}
+
+	getCapacity(nil, 3500, 1)
+	getCapacity(nil, 3500, 1)
+	getCapacity(rackA, 1000, 2)
+	getCapacity(rackA, 1000, 2)
+
+	now = now.Add(30 * time.Second)
+	if age := drv.capacity.oldest(); age != 30*time.Second {
+		t.Errorf("oldest() = %v, want 30s", age)
+	}
+
+	// the capacity expires
+	now = now.Add(30 * time.Second)
+	getCapacity(nil, 3500, 3)
+
+	// the capacity changed by the driver is queried again
+	client.results["/redfish/v1/StorageServices/1/StoragePools/1"] = `{"Capacity": {"Data": {"GuaranteedBytes": 800}}}`
+	getCapacity(nil, 3500, 3)
+	drv.capacity.invalidate()
+	getCapacity(nil, 3300, 4)
+	getCapacity(rackA, 800, 5)
+
+	// caching is disabled
+	drv.SetCapacityCacheTTL(0)
+	getCapacity(rackA, 800, 6)
+	getCapacity(rackA, 800, 7)
+}
+
+func TestCapacityCacheInvalidatedDuringQuery(t *testing.T) {
+	cache := newCapacityCache(time.Minute)
+	key := capacityKey{serviceID: "1"}
+
+	generation := cache.begin()
+	cache.invalidate()
+	cache.add(key, nil, 1000, generation)
+	if _, _, ok := cache.get(key); ok {
+		t.Errorf("get() returned capacity queried before invalidation")
+	}
+
+	cache.add(key, nil, 1000, cache.begin())
+	if capacity, _, ok := cache.get(key); !ok || capacity != 1000 {
+		t.Errorf("get() = %d, %v, want 1000, true", capacity, ok)
+	}
+}
+
+func TestCapacityCacheStale(t *testing.T) {
+	cache := newCapacityCache(time.Minute)
+	used, unused := capacityKey{serviceID: "1"}, capacityKey{serviceID: "2"}
+	cache.add(used, nil, 1000, cache.begin())
+	cache.add(unused, nil, 2000, cache.begin())
+
+	if stale := cache.stale(); len(stale) != 2 {
+		t.Fatalf("stale() = %v, want both new entries", stale)
+	}
+	cache.get(used)
+	stale := cache.stale()
+	if _, exists := stale[used]; !exists || len(stale) != 1 {
+		t.Fatalf("stale() = %v, want only the entry served since the previous refresh", stale)
+	}
+	if _, _, ok := cache.get(unused); ok {
+		t.Errorf("get() returned the entry dropped as unused")
+	}
+}
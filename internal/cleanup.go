@@ -0,0 +1,256 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+const procMounts = "/proc/self/mounts"
+
+// mountEntry is a single line of the mounts file
+type mountEntry struct {
+	device string
+	target string
+}
+
+// CleanupReport describes node resources released by Cleanup
+type CleanupReport struct {
+	DryRun       bool
+	Unmounted    []string
+	Disconnected []string
+	// Skipped are the mounts under the staging root not owned by the driver
+	Skipped []string
+	Errors  []error
+}
+
+// String formats the report for printing
+func (report *CleanupReport) String() string {
+	var b strings.Builder
+	prefix := ""
+	if report.DryRun {
+		prefix = "would be "
+	}
+	for _, target := range report.Unmounted {
+		fmt.Fprintf(&b, "%sunmounted: %s\n", prefix, target)
+	}
+	for _, device := range report.Disconnected {
+		fmt.Fprintf(&b, "%sdisconnected: %s\n", prefix, device)
+	}
+	for _, target := range report.Skipped {
+		fmt.Fprintf(&b, "skipped, not a driver mount: %s\n", target)
+	}
+	for _, err := range report.Errors {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+	if len(report.Unmounted)+len(report.Disconnected)+len(report.Skipped)+len(report.Errors) == 0 {
+		b.WriteString("nothing to clean up\n")
+	}
+	return b.String()
+}
+
+// unescapeMountPath decodes octal escapes (e.g. \040 for space) used in the mounts file
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// readMounts parses mounts file in the /proc/self/mounts format
+func readMounts(fname string) ([]mountEntry, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mounts = append(mounts, mountEntry{
+			device: unescapeMountPath(fields[0]),
+			target: unescapeMountPath(fields[1]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// isUnder checks if the path is the root directory or is located under it
+func isUnder(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// journalStagingPaths returns the staging paths of the volumes recorded in
+// the node journal, there are none if the journal doesn't exist
+func journalStagingPaths(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := decodeJournal(content)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, volume := range data.Volumes {
+		if volume.StagingTargetPath != "" {
+			paths = append(paths, volume.StagingTargetPath)
+		}
+	}
+	return paths, nil
+}
+
+// Cleanup releases node resources left after node crashes or failed upgrades.
+// Only the driver mounts under the staging root directory are unmounted: the
+// staging paths recorded in the node journal at journalPath and the mounts of
+// the NVMe devices with subsystem NQN starting with nqnPrefix. Other mounts
+// under the staging root are skipped and reported. NVMe devices used by the
+// unmounted mounts or with subsystem NQN starting with nqnPrefix are
+// disconnected then. Nothing is changed if dryRun is true. Tools are run
+// chrooted into hostRoot unless it's empty.
+func Cleanup(hostRoot, stagingRoot, journalPath, nqnPrefix string, dryRun bool) *CleanupReport {
+	execer := newExecer(hostRoot)
+	policies := policy.Default()
+	return cleanup(newMounter(execer, policies), newNVMe(execer, policies), procMounts, stagingRoot, journalPath, nqnPrefix, dryRun)
+}
+
+func cleanup(m Mounter, n NVMe, mountsFile, stagingRoot, journalPath, nqnPrefix string, dryRun bool) *CleanupReport {
+	report := &CleanupReport{DryRun: dryRun}
+
+	mounts, err := readMounts(mountsFile)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("can't read mounts: %v", err))
+		return report
+	}
+
+	var stagingPaths []string
+	if journalPath != "" {
+		stagingPaths, err = journalStagingPaths(journalPath)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("can't read node journal %s: %v", journalPath, err))
+		}
+	}
+
+	// driverDevices are the NVMe devices of the subsystems with nqnPrefix
+	driverDevices := map[string]bool{}
+	if nqnPrefix != "" {
+		devices, err := n.List()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("can't list NVMe devices: %v", err))
+		}
+		for device, nqn := range devices {
+			if strings.HasPrefix(nqn, nqnPrefix) {
+				driverDevices[device] = true
+			}
+		}
+	}
+	isDriverMount := func(mnt mountEntry) bool {
+		if driverDevices[mnt.device] {
+			return true
+		}
+		for _, stagingPath := range stagingPaths {
+			if isUnder(mnt.target, stagingPath) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// unmount nested mount points first
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].target) > len(mounts[j].target)
+	})
+
+	// devices used by the driver staging mounts are driver devices regardless of their NQN
+	candidates := map[string]bool{}
+	for device := range driverDevices {
+		candidates[device] = true
+	}
+	// devices which are still mounted somewhere after cleaning up the staging root
+	inUse := map[string]string{}
+	for _, mnt := range mounts {
+		if !isUnder(mnt.target, stagingRoot) {
+			inUse[mnt.device] = mnt.target
+			continue
+		}
+		if !isDriverMount(mnt) {
+			report.Skipped = append(report.Skipped, mnt.target)
+			inUse[mnt.device] = mnt.target
+			continue
+		}
+		if strings.HasPrefix(mnt.device, "/dev/nvme") {
+			candidates[mnt.device] = true
+		}
+		if !dryRun {
+			if err := m.Unmount(mnt.target); err != nil {
+				report.Errors = append(report.Errors, err)
+				inUse[mnt.device] = mnt.target
+				continue
+			}
+		}
+		report.Unmounted = append(report.Unmounted, mnt.target)
+	}
+
+	devices := make([]string, 0, len(candidates))
+	for device := range candidates {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		if target, mounted := inUse[device]; mounted {
+			report.Errors = append(report.Errors, fmt.Errorf("device %s is still mounted on %s, not disconnecting", device, target))
+			continue
+		}
+		if !dryRun {
+			if err := n.Disconnect(device); err != nil {
+				report.Errors = append(report.Errors, err)
+				continue
+			}
+		}
+		report.Disconnected = append(report.Disconnected, device)
+	}
+
+	return report
+}
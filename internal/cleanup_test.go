@@ -0,0 +1,176 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// cleanupMounter records unmounted targets
+type cleanupMounter struct {
+	testMounter
+	unmounted []string
+}
+
+func (m *cleanupMounter) Unmount(target string) error {
+	m.unmounted = append(m.unmounted, target)
+	return nil
+}
+
+// cleanupNVMe records disconnected devices
+type cleanupNVMe struct {
+	testNVMe
+	devices      map[string]string
+	disconnected []string
+}
+
+func (n *cleanupNVMe) List() (map[string]string, error) {
+	return n.devices, nil
+}
+
+func (n *cleanupNVMe) Disconnect(device string) error {
+	n.disconnected = append(n.disconnected, device)
+	return nil
+}
+
+const testMounts = `/dev/sda1 / ext4 rw,relatime 0 0
+/dev/nvme1n1 /staging/pv/pvc-1/globalmount ext4 rw,relatime 0 0
+/dev/nvme2n1 /staging/pv/pvc\0402/globalmount ext4 rw,relatime 0 0
+/dev/nvme2n1 /pods/pod1/volumes/pvc-2/mount ext4 rw,relatime 0 0
+/dev/sdb1 /staging-other ext4 rw,relatime 0 0
+/dev/sdc1 /staging/pv/pvc-other/globalmount ext4 rw,relatime 0 0
+`
+
+func TestCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mountsFile := filepath.Join(dir, "mounts")
+	if err := ioutil.WriteFile(mountsFile, []byte(testMounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := encodeJournal(&journalData{Volumes: []journalVolume{
+		{VolumeID: "1", Name: "pvc-1", StagingTargetPath: "/staging/pv/pvc-1/globalmount", Device: "/dev/nvme1n1"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	journalFile := filepath.Join(dir, "journal.json")
+	if err := ioutil.WriteFile(journalFile, journal, 0600); err != nil {
+		t.Fatal(err)
+	}
+	corruptedJournalFile := filepath.Join(dir, "corrupted.json")
+	if err := ioutil.WriteFile(corruptedJournalFile, journal[:len(journal)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := map[string]string{
+		"/dev/nvme1n1": "nqn.2014-08.org.nvmexpress:uuid:1",
+		"/dev/nvme2n1": "nqn.2014-08.org.nvmexpress:uuid:2",
+		"/dev/nvme3n1": "nqn.2014-08.org.nvmexpress:uuid:3",
+		"/dev/nvme4n1": "nqn.2019-01.com.example:local",
+	}
+
+	tests := []struct {
+		name             string
+		journal          string
+		nqnPrefix        string
+		dryRun           bool
+		wantUnmounted    []string
+		wantDisconnected []string
+		wantSkipped      []string
+		wantErrors       int
+	}{
+		{
+			name:        "no driver mounts",
+			wantSkipped: []string{"/staging/pv/pvc-other/globalmount", "/staging/pv/pvc-1/globalmount", "/staging/pv/pvc 2/globalmount"},
+		},
+		{
+			name:             "journal staging paths",
+			journal:          journalFile,
+			wantUnmounted:    []string{"/staging/pv/pvc-1/globalmount"},
+			wantDisconnected: []string{"/dev/nvme1n1"},
+			wantSkipped:      []string{"/staging/pv/pvc-other/globalmount", "/staging/pv/pvc 2/globalmount"},
+		},
+		{
+			name:             "journal staging paths and NQN prefix",
+			journal:          journalFile,
+			nqnPrefix:        "nqn.2014-08.org.nvmexpress:uuid:",
+			wantUnmounted:    []string{"/staging/pv/pvc-1/globalmount", "/staging/pv/pvc 2/globalmount"},
+			wantDisconnected: []string{"/dev/nvme1n1", "/dev/nvme3n1"},
+			wantSkipped:      []string{"/staging/pv/pvc-other/globalmount"},
+			wantErrors:       1,
+		},
+		{
+			name:             "corrupted journal",
+			journal:          corruptedJournalFile,
+			nqnPrefix:        "nqn.2014-08.org.nvmexpress:uuid:",
+			wantUnmounted:    []string{"/staging/pv/pvc-1/globalmount", "/staging/pv/pvc 2/globalmount"},
+			wantDisconnected: []string{"/dev/nvme1n1", "/dev/nvme3n1"},
+			wantSkipped:      []string{"/staging/pv/pvc-other/globalmount"},
+			wantErrors:       2,
+		},
+		{
+			name:             "dry run",
+			journal:          journalFile,
+			nqnPrefix:        "nqn.2014-08.org.nvmexpress:uuid:",
+			dryRun:           true,
+			wantUnmounted:    []string{"/staging/pv/pvc-1/globalmount", "/staging/pv/pvc 2/globalmount"},
+			wantDisconnected: []string{"/dev/nvme1n1", "/dev/nvme3n1"},
+			wantSkipped:      []string{"/staging/pv/pvc-other/globalmount"},
+			wantErrors:       1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &cleanupMounter{}
+			n := &cleanupNVMe{devices: devices}
+			report := cleanup(m, n, mountsFile, "/staging/pv", tt.journal, tt.nqnPrefix, tt.dryRun)
+
+			if !reflect.DeepEqual(report.Unmounted, tt.wantUnmounted) {
+				t.Errorf("unmounted = %v, want %v", report.Unmounted, tt.wantUnmounted)
+			}
+			if !reflect.DeepEqual(report.Disconnected, tt.wantDisconnected) {
+				t.Errorf("disconnected = %v, want %v", report.Disconnected, tt.wantDisconnected)
+			}
+			if !reflect.DeepEqual(report.Skipped, tt.wantSkipped) {
+				t.Errorf("skipped = %v, want %v", report.Skipped, tt.wantSkipped)
+			}
+			if len(report.Errors) != tt.wantErrors {
+				t.Errorf("errors = %v, want %d errors", report.Errors, tt.wantErrors)
+			}
+
+			if tt.dryRun {
+				if len(m.unmounted) != 0 || len(n.disconnected) != 0 {
+					t.Errorf("dry run changed the node: unmounted %v, disconnected %v", m.unmounted, n.disconnected)
+				}
+				return
+			}
+			if !reflect.DeepEqual(m.unmounted, tt.wantUnmounted) {
+				t.Errorf("Unmount() called for %v, want %v", m.unmounted, tt.wantUnmounted)
+			}
+			if !reflect.DeepEqual(n.disconnected, tt.wantDisconnected) {
+				t.Errorf("Disconnect() called for %v, want %v", n.disconnected, tt.wantDisconnected)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultCSICompat is the CSI compatibility level used unless it's configured
+const DefaultCSICompat = "1.0"
+
+// csiCompat implements the driver behaviors which changed across CSI spec
+// versions, so the driver works with the sidecars of different Kubernetes versions
+type csiCompat interface {
+	// releaseUnknownVolume returns the result of unpublishing or unstaging
+	// a volume unknown to the driver. notFound is the NotFound error to return
+	// if such volume can't be deemed released, it's logged by logger otherwise.
+	releaseUnknownVolume(logger Logger, notFound error) error
+	// checkStaged returns an error if the volume must be staged
+	// to stagingTargetPath before it's published
+	checkStaged(volume *Volume, stagingTargetPath string) error
+}
+
+// compatV10 follows CSI 1.0: releasing unknown volumes fails
+// with NOT_FOUND and publishing doesn't require the volume staged
+type compatV10 struct{}
+
+func (compatV10) releaseUnknownVolume(logger Logger, notFound error) error {
+	return notFound
+}
+
+func (compatV10) checkStaged(volume *Volume, stagingTargetPath string) error {
+	return nil
+}
+
+// compatV12 follows CSI 1.2: unknown volumes are deemed released and
+// publishing fails with FAILED_PRECONDITION until the volume is staged
+type compatV12 struct{}
+
+func (compatV12) releaseUnknownVolume(logger Logger, notFound error) error {
+	logger.V(LogLevelState).Info("volume is deemed released", "error", notFound)
+	return nil
+}
+
+func (compatV12) checkStaged(volume *Volume, stagingTargetPath string) error {
+	if !volume.IsStaged || volume.StagingTargetPath != stagingTargetPath {
+		return fmt.Errorf("volume %s is not staged to %s", volume.Name, stagingTargetPath)
+	}
+	return nil
+}
+
+// csiCompatLevels maps compatibility levels to their implementations
+var csiCompatLevels = map[string]csiCompat{
+	"1.0": compatV10{},
+	"1.2": compatV12{},
+}
+
+// CSICompatLevels returns the supported CSI compatibility levels
+func CSICompatLevels() []string {
+	var levels []string
+	for level := range csiCompatLevels {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	return levels
+}
+
+// SetCSICompat sets CSI compatibility level of the driver
+func (drv *Driver) SetCSICompat(level string) error {
+	compat, ok := csiCompatLevels[level]
+	if !ok {
+		return fmt.Errorf("unsupported CSI compatibility level %q, supported levels: %v", level, CSICompatLevels())
+	}
+	drv.compat = compat
+	return nil
+}
+
+// csiCompat returns CSI compatibility strategy of the driver
+func (drv *Driver) csiCompat() csiCompat {
+	if drv.compat == nil {
+		return csiCompatLevels[DefaultCSICompat]
+	}
+	return drv.compat
+}
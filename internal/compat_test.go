@@ -0,0 +1,87 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCSICompat(t *testing.T) {
+	tests := []struct {
+		level          string
+		wantUnknown    codes.Code
+		wantNotStaged  codes.Code
+		wantInvalidErr bool
+	}{
+		{level: "1.0", wantUnknown: codes.NotFound, wantNotStaged: codes.OK},
+		{level: "1.2", wantUnknown: codes.OK, wantNotStaged: codes.FailedPrecondition},
+		{level: "0.3", wantInvalidErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			drv := &Driver{
+				mounter: &testMounter{},
+				volumes: map[string]*Volume{
+					"pvc-1": {
+						Name:        "pvc-1",
+						CSIVolume:   &csi.Volume{VolumeId: "1"},
+						TargetPaths: map[string]bool{},
+					},
+				},
+			}
+			err := drv.SetCSICompat(tt.level)
+			if (err != nil) != tt.wantInvalidErr {
+				t.Fatalf("SetCSICompat(%s) error = %v, wantErr %v", tt.level, err, tt.wantInvalidErr)
+			}
+			if tt.wantInvalidErr {
+				return
+			}
+
+			_, err = drv.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "2",
+				TargetPath: "/target",
+			})
+			if code := status.Code(err); code != tt.wantUnknown {
+				t.Errorf("NodeUnpublishVolume of unknown volume returned %v, want %v", code, tt.wantUnknown)
+			}
+
+			_, err = drv.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "2",
+				NodeId:   "1",
+			})
+			if code := status.Code(err); code != tt.wantUnknown {
+				t.Errorf("ControllerUnpublishVolume of unknown volume returned %v, want %v", code, tt.wantUnknown)
+			}
+
+			_, err = drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:          "1",
+				StagingTargetPath: "/staging",
+				TargetPath:        "/target",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if code := status.Code(err); code != tt.wantNotStaged {
+				t.Errorf("NodePublishVolume of volume not staged returned %v, want %v", code, tt.wantNotStaged)
+			}
+		})
+	}
+}
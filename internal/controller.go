@@ -16,8 +16,8 @@ package csirsd
 
 import (
 	"context"
-	"log"
 	"strconv"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -34,7 +34,9 @@ const (
 )
 
 const (
-	defaultVolumeCapacity int64 = 16 * MB
+	// defaultVolumeCapacity is the capacity of the volumes created without
+	// capacity range unless it's configured with SetDefaultVolumeSize
+	defaultVolumeCapacity int64 = 1 * GB
 )
 
 func newCap(cap csi.ControllerServiceCapability_RPC_Type) *csi.ControllerServiceCapability {
@@ -47,16 +49,25 @@ func newCap(cap csi.ControllerServiceCapability_RPC_Type) *csi.ControllerService
 	}
 }
 
+// controllerCapabilities are the capabilities of the controller service
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+	csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+	csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	csi.ControllerServiceCapability_RPC_GET_VOLUME,
+	csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+}
+
 // ControllerGetCapabilities returns the capabilities of the controller service.
 func (drv *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	log.Printf("ControllerGetCapabilities request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ControllerGetCapabilities request", "request", req)
 	var caps []*csi.ControllerServiceCapability
-	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
-		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
-	} {
+	for _, cap := range drv.controllerCapabilities() {
 		caps = append(caps, newCap(cap))
 	}
 
@@ -64,31 +75,25 @@ func (drv *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Contr
 		Capabilities: caps,
 	}
 
-	log.Printf("ControllerGetCapabilities response: %v", resp)
+	logger.V(LogLevelRequest).Info("ControllerGetCapabilities response", "response", resp)
 
 	return resp, nil
 }
 
 // ListVolumes returns a list of available volumes created by the driver
 func (drv *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	log.Printf("ListVolumes request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ListVolumes request", "request", req)
 
-	var startingToken int
-	var err error
-	if req.StartingToken != "" {
-		startingToken, err = strconv.Atoi(req.StartingToken)
-		if err != nil {
-			return nil, status.Errorf(codes.Aborted, "can't convert startingToken %s into int32: %v", req.StartingToken, err)
-		}
+	// pages are served from the snapshot of the volumes the listing started
+	// with, so the volumes created or deleted meanwhile don't shift them
+	list, startingToken, err := drv.volumeListPage(req.StartingToken)
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
 	}
-
-	// lock driver volumes to satisfy idepotency requirements
-	drv.volumesRWL.Lock()
-	defer drv.volumesRWL.Unlock()
-
-	volumes := drv.listCSIVolumes()
+	volumes := list.volumes
 	numVols := len(volumes)
-	if startingToken > numVols {
+	if startingToken < 0 || startingToken > numVols {
 		return nil, status.Errorf(codes.Aborted, "startingToken %d is greater than amount of volumes %d", startingToken, numVols)
 	}
 
@@ -96,7 +101,7 @@ func (drv *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest)
 	var nextToken string
 	if req.MaxEntries > 0 && req.MaxEntries < int32(numEntries) {
 		numEntries = int(req.MaxEntries)
-		nextToken = strconv.Itoa(startingToken + numEntries)
+		nextToken = drv.nextToken(list, startingToken+numEntries)
 	}
 
 	var entries []*csi.ListVolumesResponse_Entry
@@ -114,13 +119,14 @@ func (drv *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest)
 
 	resp := &csi.ListVolumesResponse{Entries: entries, NextToken: nextToken}
 
-	log.Printf("ListVolumes response: %v", resp)
+	logger.V(LogLevelRequest).Info("ListVolumes response", "response", resp)
 	return resp, nil
 }
 
 // ValidateVolumeCapabilities checks if requested volume capabilities are supported
 func (drv *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	log.Printf("ValidateVolumeCapabilities request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ValidateVolumeCapabilities request", "request", req)
 
 	if req == nil || req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID can't be empty")
@@ -131,7 +137,9 @@ func (drv *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Vali
 	}
 
 	// Check if volume exists
+	drv.volumesRWL.RLock()
 	_, vol := drv.findVolByID(req.VolumeId)
+	drv.volumesRWL.RUnlock()
 	if vol == nil {
 		return nil, status.Errorf(codes.NotFound, "Volume Id '%s' not found", req.VolumeId)
 	}
@@ -144,121 +152,264 @@ func (drv *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Vali
 		},
 	}
 
-	for _, cap := range req.VolumeCapabilities {
-		// Only confirm requests for supported mode
-		if cap.AccessMode != nil && cap.AccessMode.Mode != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-			resp.Confirmed = nil
-			return resp, status.Errorf(codes.InvalidArgument, "Unsupported Access Mode: %v", cap.AccessMode)
-		}
+	// Only confirm requests for supported capabilities
+	if err := validateCapabilities(req.VolumeCapabilities); err != nil {
+		resp.Confirmed = nil
+		return resp, status.Errorf(codes.InvalidArgument, "Unsupported volume capabilities: %v", err)
+	}
+	if err := drv.validateFsTypes(req.VolumeCapabilities); err != nil {
+		resp.Confirmed = nil
+		return resp, status.Errorf(codes.InvalidArgument, "Unsupported volume capabilities: %v", err)
 	}
 
-	log.Printf("ValidateVolumeCapabilities response: %v", resp)
+	logger.V(LogLevelRequest).Info("ValidateVolumeCapabilities response", "response", resp)
 	return resp, nil
 }
 
-// validateCapabilities validates the requested capabilities.
-func validateCapabilities(caps []*csi.VolumeCapability) bool {
-	vcaps := []*csi.VolumeCapability_AccessMode{&csi.VolumeCapability_AccessMode{
-		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-	}}
+// CreateVolume creates new RSD Volume
+func (drv *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("CreateVolume request", "request", redactRequest(req))
 
-	hasSupport := func(mode csi.VolumeCapability_AccessMode_Mode) bool {
-		for _, m := range vcaps {
-			if mode == m.Mode {
-				return true
-			}
-		}
-		return false
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume Name can't be empty")
 	}
 
-	for _, cap := range caps {
-		if !hasSupport(cap.AccessMode.Mode) {
-			return false
-		}
+	// volumes of other names wouldn't be adopted nor listed
+	if !strings.HasPrefix(req.Name, drv.VolumeNamePrefix) {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: name doesn't start with the volume name prefix %q of the driver", req.Name, drv.VolumeNamePrefix)
 	}
 
-	return true
-}
+	if req.VolumeCapabilities == nil || len(req.VolumeCapabilities) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: capabilities are missing", req.Name)
+	}
 
-func getRequiredCapacity(req *csi.CreateVolumeRequest) int64 {
-	requiredCapacity := defaultVolumeCapacity
-	if capRange := req.CapacityRange; capRange != nil {
-		if requiredBytes := capRange.GetRequiredBytes(); requiredBytes > 0 {
-			requiredCapacity = requiredBytes
+	if err := validateCapabilities(req.VolumeCapabilities); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: invalid volume capabilities requested: %v", req.Name, err)
+	}
+	if err := drv.validateFsTypes(req.VolumeCapabilities); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: invalid volume capabilities requested: %v", req.Name, err)
+	}
+
+	ctx, err := drv.withRSDSecrets(ctx, req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+
+	capacity, err := parseCapacity(req.CapacityRange, req.Parameters, drv.getDefaultVolumeSize())
+	if _, outOfRange := err.(*capacityRangeError); outOfRange {
+		return nil, status.Errorf(codes.OutOfRange, "Volume %s: invalid capacity range: %v", req.Name, err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	// the capacity rounded to a known allocation unit must fit the limit
+	if capacity.unit > 0 {
+		if _, err := capacity.rounded(capacity.unit); err != nil {
+			return nil, status.Errorf(codes.OutOfRange, "Volume %s: invalid capacity range: %v", req.Name, err)
 		}
-		if limitBytes := capRange.GetLimitBytes(); limitBytes > 0 {
-			requiredCapacity = limitBytes
+	}
+	requiredCapacity := capacity.required
+
+	// validate node constraints to store them in the volume context
+	volumeContext, err := affinityContext(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	attach, err := attachContext(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	reformat, err := reformatContext(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	mkfs, err := drv.mkfsContext(req.Parameters, req.VolumeCapabilities)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	portal, err := portalContext(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	provisioning, err := parseProvisioning(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	provisioning.accessibility = req.AccessibilityRequirements
+	if _, err := drv.rackContexts(ctx, provisioning); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.Name, err)
+	}
+	for key, value := range attach {
+		volumeContext[key] = value
+	}
+	for key, value := range reformat {
+		volumeContext[key] = value
+	}
+	for key, value := range mkfs {
+		volumeContext[key] = value
+	}
+	for key, value := range portal {
+		volumeContext[key] = value
+	}
+	for key, value := range provisioningContext(req.Parameters) {
+		volumeContext[key] = value
+	}
+	// PVC of the volume lets the node report events about it
+	for _, key := range []string{pvcNameParameter, pvcNamespaceParameter} {
+		if value := req.Parameters[key]; value != "" {
+			volumeContext[key] = value
 		}
 	}
-	return requiredCapacity
-}
 
-// CreateVolume creates new RSD Volume
-func (drv *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	log.Printf("CreateVolume request: %v", req)
+	contentSource := req.GetVolumeContentSource()
+	if contentSource != nil && contentSource.GetSnapshot() == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: only snapshots are supported as volume content source", req.Name)
+	}
+	if contentSource != nil && rsdEndpointOf(ctx) != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: volumes of the RSD endpoints from the secrets can't be created from snapshots", req.Name)
+	}
+	if contentSource != nil && provisioning.rackID() != "" && provisioning.rackID() != DefaultRack {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: volumes in other racks than the default one can't be created from snapshots", req.Name)
+	}
 
-	if req.Name == "" {
-		return nil, status.Error(codes.InvalidArgument, "Volume Name can't be empty")
+	// lock the volume name to satisfy idepotency requirements
+	if err := drv.creationLocks.lock(req.Name, "CreateVolume"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
 	}
+	defer drv.creationLocks.unlock(req.Name)
 
-	if req.VolumeCapabilities == nil || len(req.VolumeCapabilities) == 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: capabilities are missing", req.Name)
+	existing, snapshot, reserved, err := drv.reserveVolume(req, capacity)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return &csi.CreateVolumeResponse{Volume: existing}, nil
 	}
 
-	if !validateCapabilities(req.VolumeCapabilities) {
-		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: invalid volume capabilities requested. Only SINGLE_NODE_WRITER is supported", req.Name)
+	// Volume doesn't exist - create new one, with the snapshot content if it's requested
+	var volume *Volume
+	if snapshot != nil {
+		volume, err = drv.newVolumeFromSnapshot(ctx, req.Name, requiredCapacity, volumeContext, snapshot)
+	} else {
+		volume, err = drv.newVolume(ctx, req.Name, capacity, volumeContext, provisioning)
 	}
+	if err == nil {
+		volume.CSIVolume.AccessibleTopology = drv.volumeTopology(volume.RSDVolume)
+	}
+	drv.storeCreated(ctx, volume, reserved)
+	if _, outOfRange := err.(*capacityRangeError); outOfRange {
+		return nil, status.Errorf(codes.OutOfRange, "Volume %s: invalid capacity range: %v", req.Name, err)
+	}
+	if pending, ok := err.(*creationPendingError); ok {
+		return nil, pending.status(req.Name)
+	}
+	if category := drv.observeOperation(operationCreate, err); err != nil {
+		return nil, status.Errorf(rsdStatusCode(category, codes.Internal), "Volume %s: create failed (%s): %v", req.Name, category, err)
+	}
+	drv.allocations.created(volume, req.Parameters)
+
+	resp := &csi.CreateVolumeResponse{Volume: volume.CSIVolume}
 
-	// get required capacity
-	requiredCapacity := getRequiredCapacity(req)
+	logger.V(LogLevelRequest).Info("CreateVolume response", "response", resp)
+	return resp, nil
+}
 
-	// lock driver volumes to satisfy idepotency requirements
+// reserveVolume returns the existing volume if it satisfies the request,
+// otherwise it returns the snapshot the volume is created of, if it's
+// requested, and reserves the volume capacity in the total capacity budget
+// until the volume is stored by storeCreated, so the volume is created
+// without holding drv.volumesRWL.
+func (drv *Driver) reserveVolume(req *csi.CreateVolumeRequest, capacity volumeCapacity) (*csi.Volume, *Snapshot, int64, error) {
 	drv.volumesRWL.Lock()
 	defer drv.volumesRWL.Unlock()
 
+	requiredCapacity := capacity.required
 	// Check if the volume already exists.
-	if vol := drv.findCSIVolumeByName(req.Name); vol != nil {
+	if volume, exists := drv.lookupVolume(req.Name); exists {
 		// Check if existing volume's capacity satisfies request
+		vol := volume.CSIVolume
 		capacityBytes := vol.GetCapacityBytes()
 		if capacityBytes < requiredCapacity {
-			return nil, status.Errorf(codes.AlreadyExists, "Volume %s has smaller size(%d) than required(%d)", req.Name, capacityBytes, requiredCapacity)
+			return nil, nil, 0, status.Errorf(codes.AlreadyExists, "Volume %s has smaller size(%d) than required(%d)", req.Name, capacityBytes, requiredCapacity)
+		}
+		if capacity.limit > 0 && capacityBytes > capacity.limit {
+			return nil, nil, 0, status.Errorf(codes.AlreadyExists, "Volume %s has larger size(%d) than limit(%d)", req.Name, capacityBytes, capacity.limit)
 		}
-		return &csi.CreateVolumeResponse{Volume: vol}, nil
+		vol.AccessibleTopology = drv.volumeTopology(volume.RSDVolume)
+		return vol, nil, 0, nil
 	}
 
-	// Volume doesn't exist - create new one
-	vol, err := drv.newVolume(req.Name, requiredCapacity)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	var snapshot *Snapshot
+	budgetBytes := requiredCapacity
+	if contentSource := req.GetVolumeContentSource(); contentSource != nil {
+		snapshotID := contentSource.GetSnapshot().SnapshotId
+		snapshot = drv.findSnapshotByID(snapshotID)
+		if snapshot == nil {
+			return nil, nil, 0, status.Errorf(codes.NotFound, "Volume %s: no snapshot with id '%s' found", req.Name, snapshotID)
+		}
+		snapshotBytes := snapshot.CSISnapshot.SizeBytes
+		if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && limitBytes < snapshotBytes {
+			return nil, nil, 0, status.Errorf(codes.OutOfRange, "Volume %s: capacity limit %d is smaller than snapshot %s size %d", req.Name, limitBytes, snapshotID, snapshotBytes)
+		}
+		if snapshotBytes > budgetBytes {
+			budgetBytes = snapshotBytes
+		}
 	}
+	if err := drv.checkCapacityBudget(budgetBytes); err != nil {
+		return nil, nil, 0, status.Errorf(codes.ResourceExhausted, "Volume %s: %v", req.Name, err)
+	}
+	drv.reservedCapacity += budgetBytes
+	return nil, snapshot, budgetBytes, nil
+}
 
-	resp := &csi.CreateVolumeResponse{Volume: vol}
-
-	log.Printf("CreateVolume response: %v", resp)
-	return resp, nil
+// storeCreated adds the created volume to the volumes map, if it's not nil,
+// and releases the capacity reserved by reserveVolume
+func (drv *Driver) storeCreated(ctx context.Context, volume *Volume, reserved int64) {
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	drv.reservedCapacity -= reserved
+	if volume != nil {
+		drv.volumes[volume.Name] = volume
+		drv.observeVolumeProgress(ctx, volume)
+	}
 }
 
 // DeleteVolume deletes existing RSD Volume
 func (drv *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	log.Printf("DeleteVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("DeleteVolume request", "request", redactRequest(req))
 
 	//  If the volume is not specified, return error
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID is missing")
 	}
 
-	err := drv.deleteVolume(req.VolumeId)
+	ctx, err := drv.withRSDSecrets(ctx, req.Secrets)
 	if err != nil {
-		return nil, err
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.VolumeId, err)
+	}
+	drv.adoptEndpointVolume(ctx, req.VolumeId)
+
+	err = drv.deleteVolume(ctx, req.VolumeId)
+	if _, pending := err.(*operationPendingError); pending {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if _, mismatch := err.(*rsdEndpointMismatchError); mismatch {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s: %v", req.VolumeId, err)
+	}
+	if category := drv.observeOperation(operationDelete, err); err != nil {
+		return nil, status.Errorf(rsdStatusCode(category, codes.Internal), "Volume %s: delete failed (%s): %v", req.VolumeId, category, err)
 	}
 
-	log.Printf("DeleteVolume: volume %s has been deleted", req.VolumeId)
+	logger.V(LogLevelState).Info("volume has been deleted", "volume_id", req.VolumeId)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
 // ControllerPublishVolume attaches the given volume to the node
 func (drv *Driver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	log.Printf("ControllerPublishVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ControllerPublishVolume request", "request", redactRequest(req))
 
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID is missing")
@@ -272,41 +423,113 @@ func (drv *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Control
 		return nil, status.Error(codes.InvalidArgument, "Volume capability is missing")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
-	drv.volumesRWL.Lock()
-	defer drv.volumesRWL.Unlock()
+	nodeID, err := drv.resolveNodeID(req.NodeId)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s can't be published: %v", req.VolumeId, err)
+	}
 
-	// Check if the volume exists
-	name, vol := drv.findVolByID(req.VolumeId)
+	// lock the volume to satisfy idepotency requirements
+	if err := drv.volumeLocks.lock(req.VolumeId, "ControllerPublishVolume"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
+
+	ctx, err = drv.withRSDSecrets(ctx, req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s can't be published: %v", req.VolumeId, err)
+	}
+	drv.adoptEndpointVolume(ctx, req.VolumeId)
+
+	// Check if the volume exists. A copy of the volume record is published
+	// without holding the lock, as attaching may wait for the RSD node.
+	drv.volumesRWL.RLock()
+	name, record := drv.findVolByID(req.VolumeId)
+	var vol *Volume
+	if name != "" {
+		vol = record.copy()
+	}
+	drv.volumesRWL.RUnlock()
 	if name == "" {
 		return nil, status.Errorf(codes.NotFound, "No volume with id '%s' found", req.VolumeId)
 	}
+	ctx, err = volumeRSDContext(ctx, vol)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s(%s) can't be published: %v", name, req.VolumeId, err)
+	}
+
+	// Check node constraints of the volume. Context of the adopted volumes
+	// is not known to the driver, so it's taken from the request.
+	volumeContext := vol.CSIVolume.VolumeContext
+	if _, hasAllowed := volumeContext[AllowedNodesParameter]; !hasAllowed {
+		if _, hasForbidden := volumeContext[ForbiddenNodesParameter]; !hasForbidden {
+			volumeContext = req.VolumeContext
+		}
+	}
+	if err := checkNodeAffinity(volumeContext, nodeID); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s(%s) can't be published: %v", name, req.VolumeId, err)
+	}
+	if err := drv.checkNodeRack(vol, nodeID); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s(%s) can't be published: %v", name, req.VolumeId, err)
+	}
 
 	// Check if node ID is correct
-	if drv.RSDNodeID != req.NodeId {
+	if !drv.isPublishableNode(nodeID) {
 		return nil, status.Errorf(codes.NotFound, "No node with id '%s' found", req.NodeId)
 	}
 
-	err := drv.publishVolume(vol, req.NodeId)
+	// Portal override of the adopted volumes is taken from the request as well
+	portalVolumeContext := vol.CSIVolume.VolumeContext
+	if !hasPortalOverride(portalVolumeContext) {
+		portalVolumeContext = req.VolumeContext
+	}
+	override, err := parsePortalOverride(portalVolumeContext)
 	if err != nil {
-		return nil, status.Errorf(codes.Aborted, "error attaching volume %s(%s) to the node %s: %v", name, req.VolumeId, req.NodeId, err)
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s(%s) can't be published: %v", name, req.VolumeId, err)
+	}
+	if err := drv.checkNodeTransports(ctx, vol, override.transport); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s(%s) can't be published to the node %s: %v", name, req.VolumeId, nodeID, err)
 	}
 
-	log.Printf("volume %s(%s) has been attached to the node %s", name, req.VolumeId, req.NodeId)
+	// Attach options of the adopted volumes are taken from the request as well
+	attachVolumeContext := vol.CSIVolume.VolumeContext
+	if !hasAttachOptions(attachVolumeContext) {
+		attachVolumeContext = req.VolumeContext
+	}
+	opts, err := attachOptions(attachVolumeContext)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s(%s) can't be published: %v", name, req.VolumeId, err)
+	}
+
+	err = drv.publishVolume(ctx, vol, nodeID, opts)
+	drv.storePublished(record, vol)
+	drv.observeAttachment(operationAttach, nodeID, err)
+	if category := drv.observeOperation(operationAttach, err); err != nil {
+		message := err.Error()
+		if progress := vol.operationProgress(); progress != "" {
+			message += " (" + progress + ")"
+		}
+		return nil, status.Errorf(rsdStatusCode(category, codes.Aborted), "error attaching volume %s(%s) to the node %s (%s): %s", name, req.VolumeId, nodeID, category, message)
+	}
+
+	logger.V(LogLevelState).Info("volume has been attached", "volume", name, "volume_id", req.VolumeId, "node_id", nodeID)
 
 	resp := &csi.ControllerPublishVolumeResponse{
 		PublishContext: map[string]string{
 			PublishInfoVolumeName: name,
 		},
 	}
+	if vol.EndPoint != nil {
+		resp.PublishContext[PublishInfoSubsystemNQN] = vol.EndPoint.NQN
+	}
 
-	log.Printf("ControllerPublishVolume response: %v", resp)
+	logger.V(LogLevelRequest).Info("ControllerPublishVolume response", "response", resp)
 	return resp, nil
 }
 
 // ControllerUnpublishVolume deattaches the given volume from the node
 func (drv *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	log.Printf("ControllerUnpublishVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ControllerUnpublishVolume request", "request", redactRequest(req))
 
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID is missing")
@@ -316,60 +539,229 @@ func (drv *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Contr
 		return nil, status.Error(codes.InvalidArgument, "Node ID is missing")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
-	drv.volumesRWL.Lock()
-	defer drv.volumesRWL.Unlock()
+	nodeID, err := drv.resolveNodeID(req.NodeId)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s can't be unpublished: %v", req.VolumeId, err)
+	}
+
+	// lock the volume to satisfy idepotency requirements
+	if err := drv.volumeLocks.lock(req.VolumeId, "ControllerUnpublishVolume"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
+
+	ctx, err = drv.withRSDSecrets(ctx, req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s can't be unpublished: %v", req.VolumeId, err)
+	}
+	drv.adoptEndpointVolume(ctx, req.VolumeId)
 
-	// Check if the volume exists
-	name, vol := drv.findVolByID(req.VolumeId)
+	// Check if the volume exists. A copy of the volume record is unpublished
+	// without holding the lock, as detaching may wait for the RSD node.
+	drv.volumesRWL.RLock()
+	name, record := drv.findVolByID(req.VolumeId)
+	var vol *Volume
+	if name != "" {
+		vol = record.copy()
+	}
+	drv.volumesRWL.RUnlock()
 	if name == "" {
-		return nil, status.Errorf(codes.NotFound, "No volume with id '%s' found", req.VolumeId)
+		notFound := status.Errorf(codes.NotFound, "No volume with id '%s' found", req.VolumeId)
+		if err := drv.csiCompat().releaseUnknownVolume(logger, notFound); err != nil {
+			return nil, err
+		}
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+	ctx, err = volumeRSDContext(ctx, vol)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s(%s) can't be unpublished: %v", name, req.VolumeId, err)
 	}
 
 	// Check if node ID is correct
-	if drv.RSDNodeID != req.NodeId {
+	if !drv.isPublishableNode(nodeID) {
 		return nil, status.Errorf(codes.NotFound, "No node with id '%s' found", req.NodeId)
 	}
 
-	err := drv.unpublishVolume(vol, req.NodeId)
-	if err != nil {
-		return nil, status.Errorf(codes.Aborted, "error detaching volume %s(%s) from the node %s: %v", name, req.VolumeId, req.NodeId, err)
+	// RSD can't attach the volume to the nodes of other racks
+	if err := drv.checkNodeRack(vol, nodeID); err != nil {
+		logger.V(LogLevelState).Info("volume is not attached to the node", "volume", name, "volume_id", req.VolumeId, "node_id", nodeID, "reason", err)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
 
-	log.Printf("volume %s(%s) has been detached from the node %s", name, req.VolumeId, req.NodeId)
+	err = drv.unpublishVolume(ctx, vol, nodeID)
+	drv.storePublished(record, vol)
+	drv.observeAttachment(operationDetach, nodeID, err)
+	if category := drv.observeOperation(operationDetach, err); err != nil {
+		return nil, status.Errorf(rsdStatusCode(category, codes.Aborted), "error detaching volume %s(%s) from the node %s (%s): %v", name, req.VolumeId, nodeID, category, err)
+	}
+
+	logger.V(LogLevelState).Info("volume has been detached", "volume", name, "volume_id", req.VolumeId, "node_id", nodeID)
 
 	resp := &csi.ControllerUnpublishVolumeResponse{}
 
-	log.Printf("ControllerUnpublishVolume response: %v", resp)
+	logger.V(LogLevelRequest).Info("ControllerUnpublishVolume response", "response", resp)
 	return resp, nil
 }
 
 // GetCapacity returns the capacity of the storage
 func (drv *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	log.Printf("GetCapacity request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("GetCapacity request", "request", req)
 
-	capacity, err := drv.getCapacity()
+	provisioning, err := parseProvisioning(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "GetCapacity: %v", err)
+	}
+	if topology := req.AccessibleTopology; topology != nil {
+		provisioning.accessibility = &csi.TopologyRequirement{Requisite: []*csi.Topology{topology}}
+	}
+	if _, err := drv.rackContexts(ctx, provisioning); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "GetCapacity: %v", err)
+	}
+
+	capacity, err := drv.cachedCapacity(ctx, provisioning)
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "error getting capacity: %v", err)
 	}
+	if remaining := drv.remainingCapacityBudget(); remaining >= 0 && remaining < capacity {
+		capacity = remaining
+	}
 
 	resp := &csi.GetCapacityResponse{AvailableCapacity: capacity}
 
-	log.Printf("GetCapacity response: %v", resp)
+	logger.V(LogLevelRequest).Info("GetCapacity response", "response", resp)
 	return resp, nil
 }
 
 // ListSnapshots returns a list of requested volume snapshots
 func (drv *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "ListSnapshots is not implemented")
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ListSnapshots request", "request", req)
+
+	var startingToken int
+	var err error
+	if req.StartingToken != "" {
+		startingToken, err = strconv.Atoi(req.StartingToken)
+		if err != nil {
+			return nil, status.Errorf(codes.Aborted, "can't convert startingToken %s into int32: %v", req.StartingToken, err)
+		}
+	}
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+
+	snapshots := drv.listCSISnapshots(req.SnapshotId, req.SourceVolumeId)
+	numSnapshots := len(snapshots)
+	if startingToken > numSnapshots {
+		return nil, status.Errorf(codes.Aborted, "startingToken %d is greater than amount of snapshots %d", startingToken, numSnapshots)
+	}
+
+	numEntries := numSnapshots - startingToken
+	var nextToken string
+	if req.MaxEntries > 0 && req.MaxEntries < int32(numEntries) {
+		numEntries = int(req.MaxEntries)
+		nextToken = strconv.Itoa(startingToken + numEntries)
+	}
+
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, snapshot := range snapshots[startingToken : startingToken+numEntries] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+
+	resp := &csi.ListSnapshotsResponse{Entries: entries, NextToken: nextToken}
+
+	logger.V(LogLevelRequest).Info("ListSnapshots response", "response", resp)
+	return resp, nil
 }
 
 // CreateSnapshot creates new volume snapshot
 func (drv *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not implemented")
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("CreateSnapshot request", "request", redactRequest(req))
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot Name can't be empty")
+	}
+
+	if req.SourceVolumeId == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Snapshot %s: source volume ID is missing", req.Name)
+	}
+
+	// lock the snapshot name to satisfy idepotency requirements
+	if err := drv.snapshotLocks.lock(req.Name, "CreateSnapshot"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer drv.snapshotLocks.unlock(req.Name)
+
+	// Check if the snapshot already exists
+	drv.volumesRWL.Lock()
+	snapshot, exists := drv.lookupSnapshot(req.Name)
+	drv.volumesRWL.Unlock()
+	if exists {
+		if snapshot.CSISnapshot.SourceVolumeId != req.SourceVolumeId {
+			return nil, status.Errorf(codes.AlreadyExists, "Snapshot %s of the volume %s already exists", req.Name, snapshot.CSISnapshot.SourceVolumeId)
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: snapshot.CSISnapshot}, nil
+	}
+
+	// lock the source volume, so it isn't deleted while it's replicated
+	if err := drv.volumeLocks.lock(req.SourceVolumeId, "CreateSnapshot"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer drv.volumeLocks.unlock(req.SourceVolumeId)
+
+	drv.volumesRWL.RLock()
+	name, vol := drv.findVolByID(req.SourceVolumeId)
+	drv.volumesRWL.RUnlock()
+	if name == "" {
+		return nil, status.Errorf(codes.NotFound, "Snapshot %s: no volume with id '%s' found", req.Name, req.SourceVolumeId)
+	}
+	if vol.RSDEndpoint != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "Snapshot %s: snapshots of the volumes of the RSD endpoints from the secrets are not supported", req.Name)
+	}
+	if vol.Rack != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "Snapshot %s: snapshots of the volumes in other racks than the default one are not supported", req.Name)
+	}
+
+	csiSnapshot, err := drv.newSnapshot(ctx, req.Name, vol)
+	if category := drv.observeOperation(operationSnapshot, err); err != nil {
+		return nil, status.Errorf(rsdStatusCode(category, codes.Internal), "Snapshot %s: create failed (%s): %v", req.Name, category, err)
+	}
+
+	resp := &csi.CreateSnapshotResponse{Snapshot: csiSnapshot}
+
+	logger.V(LogLevelRequest).Info("CreateSnapshot response", "response", resp)
+	return resp, nil
 }
 
 // DeleteSnapshot deletes volume snapshot
 func (drv *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "DeleteSnapshot is not implemented")
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("DeleteSnapshot request", "request", redactRequest(req))
+
+	if req.SnapshotId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID is missing")
+	}
+
+	drv.volumesRWL.RLock()
+	snapshot := drv.findSnapshotByID(req.SnapshotId)
+	drv.volumesRWL.RUnlock()
+	if snapshot == nil {
+		logger.V(LogLevelState).Info("snapshot is already deleted", "snapshot_id", req.SnapshotId)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	// lock the snapshot name, so it isn't deleted twice or taken again meanwhile
+	if err := drv.snapshotLocks.lock(snapshot.Name, "DeleteSnapshot"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer drv.snapshotLocks.unlock(snapshot.Name)
+
+	err := drv.deleteSnapshot(ctx, snapshot)
+	if category := drv.observeOperation(operationDeleteSnapshot, err); err != nil {
+		return nil, status.Errorf(rsdStatusCode(category, codes.Internal), "Snapshot %s: delete failed (%s): %v", req.SnapshotId, category, err)
+	}
+
+	logger.V(LogLevelState).Info("snapshot has been deleted", "snapshot_id", req.SnapshotId)
+	return &csi.DeleteSnapshotResponse{}, nil
 }
@@ -19,12 +19,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
 	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestControllerGetCapabilities(t *testing.T) {
@@ -42,10 +44,18 @@ func TestControllerGetCapabilities(t *testing.T) {
 				newCap(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME),
 				newCap(csi.ControllerServiceCapability_RPC_LIST_VOLUMES),
 				newCap(csi.ControllerServiceCapability_RPC_GET_CAPACITY),
+				newCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT),
+				newCap(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS),
+				newCap(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME),
+				newCap(csi.ControllerServiceCapability_RPC_GET_VOLUME),
+				newCap(csi.ControllerServiceCapability_RPC_VOLUME_CONDITION),
 			},
 		}
+		for _, cap := range accessModeControllerCapabilities() {
+			want.Capabilities = append(want.Capabilities, newCap(cap))
+		}
 
-		if !reflect.DeepEqual(got, want) {
+		if !proto.Equal(got, want) {
 			t.Errorf("Driver.ControllerGetCapabilities() = %v, want %v", got, want)
 		}
 	})
@@ -97,7 +107,7 @@ func TestListVolumes(t *testing.T) {
 				t.Errorf("Driver.ListVolumes() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.ListVolumes() = %v, want %v", got, tt.want)
 			}
 		})
@@ -206,7 +216,7 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 				t.Errorf("Driver.ValidateVolumeCapabilities() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.ValidateVolumeCapabilities() = %v, want %v", got, tt.want)
 			}
 		})
@@ -218,16 +228,72 @@ func TestValidateCapabilities(t *testing.T) {
 		caps []*csi.VolumeCapability
 	}
 	tests := []struct {
-		name string
-		args args
-		want bool
+		name    string
+		args    args
+		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			name: "supported access mode",
+			args: args{caps: []*csi.VolumeCapability{
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			}},
+		},
+		{
+			name: "supported access mode and type",
+			args: args{caps: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			}},
+		},
+		{
+			name: "supported block access type",
+			args: args{caps: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			}},
+		},
+		{
+			name: "unsupported access mode",
+			args: args{caps: []*csi.VolumeCapability{
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+				{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "missing access mode",
+			args:    args{caps: []*csi.VolumeCapability{{}}},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := validateCapabilities(tt.args.caps); got != tt.want {
-				t.Errorf("validateCapabilities() = %v, want %v", got, tt.want)
+			if err := validateCapabilities(tt.args.caps); (err != nil) != tt.wantErr {
+				t.Errorf("validateCapabilities() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -236,10 +302,12 @@ func TestValidateCapabilities(t *testing.T) {
 // TestClient is a mock that implements rsd.Transport interface
 type TestClient struct {
 	results map[string]string
+	// deleteErr is returned by Delete
+	deleteErr error
 }
 
 // Get gets json string from TestClient.results and decodes into the result
-func (client *TestClient) Get(entrypoint string, result interface{}) error {
+func (client *TestClient) Get(ctx context.Context, entrypoint string, result interface{}) error {
 	res, ok := client.results[entrypoint]
 	if !ok {
 		return fmt.Errorf("Unsupported entry point: %s", entrypoint)
@@ -252,12 +320,17 @@ func (client *TestClient) Get(entrypoint string, result interface{}) error {
 }
 
 // Post returns correct location
-func (client *TestClient) Post(entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+func (client *TestClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
 	return &http.Header{"Location": []string{"/redfish/v1/StorageServices/1/Volumes/1"}}, nil
 }
 
-// Delete does nothing
-func (client *TestClient) Delete(entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+// Delete returns deleteErr
+func (client *TestClient) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	return nil, client.deleteErr
+}
+
+// Patch does nothing
+func (client *TestClient) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
 	return nil, nil
 }
 
@@ -391,13 +464,44 @@ func TestCreateVolume(t *testing.T) {
 				t.Errorf("Driver.CreateVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.CreateVolume() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestCreateVolumeNamePrefix(t *testing.T) {
+	drv := &Driver{
+		VolumeNamePrefix: "pvc-",
+		rsdClient: &TestClient{results: map[string]string{
+			"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+			"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+			"/redfish/v1/StorageServices/1/Volumes":   `{"Members": []}`,
+			"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100}`,
+		}},
+		volumes: map[string]*Volume{},
+	}
+	req := func(name string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:               name,
+			VolumeCapabilities: []*csi.VolumeCapability{mountCapability("")},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 100},
+		}
+	}
+
+	// the volume wouldn't be adopted after the driver restart
+	if _, err := drv.CreateVolume(context.Background(), req("data-1")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() of a non-prefixed name error = %v, want InvalidArgument", err)
+	}
+	if len(drv.volumes) != 0 {
+		t.Errorf("volumes %v created for a non-prefixed name", drv.volumes)
+	}
+	if _, err := drv.CreateVolume(context.Background(), req("pvc-1")); err != nil {
+		t.Errorf("CreateVolume() of a prefixed name unexpected error: %v", err)
+	}
+}
+
 func TestDeleteVolume(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -425,6 +529,36 @@ func TestDeleteVolume(t *testing.T) {
 			want:    &csi.DeleteVolumeResponse{},
 			wantErr: false,
 		},
+		{
+			name: "Volume deleted out of band",
+			driver: &Driver{
+				rsdClient: &TestClient{deleteErr: &rsd.HTTPError{StatusCode: http.StatusNotFound}},
+				volumes: map[string]*Volume{
+					"CSI-generated": &Volume{
+						RSDVolume: &rsd.Volume{},
+						CSIVolume: &csi.Volume{VolumeId: "1"},
+					},
+				},
+				metrics: newDriverMetrics(),
+			},
+			req:  &csi.DeleteVolumeRequest{VolumeId: "1"},
+			want: &csi.DeleteVolumeResponse{},
+		},
+		{
+			name: "RSD failure",
+			driver: &Driver{
+				rsdClient: &TestClient{deleteErr: &rsd.HTTPError{StatusCode: http.StatusInternalServerError}},
+				volumes: map[string]*Volume{
+					"CSI-generated": &Volume{
+						RSDVolume: &rsd.Volume{},
+						CSIVolume: &csi.Volume{VolumeId: "1"},
+					},
+				},
+			},
+			req:     &csi.DeleteVolumeRequest{VolumeId: "1"},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "missing Volume Id",
 			driver:  &Driver{},
@@ -440,9 +574,12 @@ func TestDeleteVolume(t *testing.T) {
 				t.Errorf("Driver.DeleteVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.DeleteVolume() = %v, want %v", got, tt.want)
 			}
+			if _, exists := tt.driver.volumes["CSI-generated"]; exists && err == nil {
+				t.Error("deleted volume is kept")
+			}
 		})
 	}
 }
@@ -576,7 +713,7 @@ func TestPublishVolume(t *testing.T) {
 		},
 	}
 
-	rsdVolume, err := rsd.GetVolume(testClient, 0, "1")
+	rsdVolume, err := rsd.GetVolumeByPath(context.Background(), testClient, "/redfish/v1/StorageServices/1/Volumes/1")
 	if err != nil {
 		t.Fatalf("can't get volume id 1: %v", err)
 	}
@@ -615,7 +752,8 @@ func TestPublishVolume(t *testing.T) {
 			},
 			want: &csi.ControllerPublishVolumeResponse{
 				PublishContext: map[string]string{
-					PublishInfoVolumeName: "CSI-generated",
+					PublishInfoVolumeName:   "CSI-generated",
+					PublishInfoSubsystemNQN: "nqn.1",
 				},
 			},
 			wantErr: false,
@@ -651,6 +789,33 @@ func TestPublishVolume(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name: "node is forbidden",
+			driver: &Driver{
+				RSDNodeID: "1",
+				volumes: map[string]*Volume{
+					"CSI-generated": &Volume{
+						RSDVolume: &rsd.Volume{},
+						CSIVolume: &csi.Volume{
+							VolumeId:      "1",
+							VolumeContext: map[string]string{"name": "CSI-generated", ForbiddenNodesParameter: "1,2"},
+							CapacityBytes: 100,
+						},
+					},
+				},
+			},
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: "1",
+				NodeId:   "1",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "missing Volume Id",
 			driver:  &Driver{},
@@ -696,7 +861,7 @@ func TestPublishVolume(t *testing.T) {
 				t.Errorf("Driver.PublishVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.PublishVolume() = %v, want %v", got, tt.want)
 			}
 		})
@@ -764,7 +929,7 @@ func TestUnpublishVolume(t *testing.T) {
 		},
 	}
 
-	rsdVolume, err := rsd.GetVolume(testClient, 0, "1")
+	rsdVolume, err := rsd.GetVolumeByPath(context.Background(), testClient, "/redfish/v1/StorageServices/1/Volumes/1")
 	if err != nil {
 		t.Fatalf("can't get volume id 1: %v", err)
 	}
@@ -860,9 +1025,145 @@ func TestUnpublishVolume(t *testing.T) {
 				t.Errorf("Driver.UnpublishVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.UnpublishVolume() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+// actionClient is TestClient recording the POSTed entry points
+type actionClient struct {
+	TestClient
+	posted []string
+}
+
+func (client *actionClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.posted = append(client.posted, entrypoint)
+	return client.TestClient.Post(ctx, entrypoint, data, result)
+}
+
+func TestUnpublishVolumeNotPublished(t *testing.T) {
+	newClient := func(storage string) *actionClient {
+		return &actionClient{TestClient: TestClient{results: map[string]string{
+			"/redfish/v1/Nodes": `{"Members": [{"@odata.id": "/redfish/v1/Nodes/1"}]}`,
+			"/redfish/v1/Nodes/1": `{
+				"@odata.id": "/redfish/v1/Nodes/1",
+				"Id": "1",
+				"Links": {"Storage": [` + storage + `]},
+				"Actions": {
+					"#ComposedNode.DetachResource": {
+						"target": "/redfish/v1/Nodes/1/Actions/ComposedNode.DetachResource"
+					}
+				}
+			}`,
+		}}}
+	}
+
+	tests := []struct {
+		name       string
+		storage    string
+		wantDetach bool
+	}{
+		{
+			name:       "attached in RSD",
+			storage:    `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}`,
+			wantDetach: true,
+		},
+		{
+			name:    "not attached in RSD",
+			storage: `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient(tt.storage)
+			vol := &Volume{
+				Name:      "CSI-generated",
+				RSDVolume: &rsd.Volume{ID: "1", OdataID: "/redfish/v1/StorageServices/1/Volumes/1"},
+				CSIVolume: &csi.Volume{VolumeId: "1"},
+			}
+			drv := &Driver{
+				rsdClient: client,
+				RSDNodeID: "1",
+				volumes:   map[string]*Volume{"CSI-generated": vol},
+			}
+
+			_, err := drv.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "1", NodeId: "1"})
+			if err != nil {
+				t.Fatalf("ControllerUnpublishVolume() unexpected error: %v", err)
+			}
+			detached := len(client.posted) == 1 && client.posted[0] == "/redfish/v1/Nodes/1/Actions/ComposedNode.DetachResource"
+			if detached != tt.wantDetach {
+				t.Errorf("detached = %v (requests %v), want %v", detached, client.posted, tt.wantDetach)
+			}
+			if vol.IsPublished || vol.RSDNodeID != "" {
+				t.Errorf("volume is still published to %q", vol.RSDNodeID)
+			}
+		})
+	}
+}
+
+func TestAttachToNodeAlreadyAttached(t *testing.T) {
+	newClient := func(storage string) *actionClient {
+		return &actionClient{TestClient: TestClient{results: map[string]string{
+			"/redfish/v1/Nodes": `{"Members": [{"@odata.id": "/redfish/v1/Nodes/1"}]}`,
+			"/redfish/v1/Nodes/1": `{
+				"@odata.id": "/redfish/v1/Nodes/1",
+				"Id": "1",
+				"Links": {
+					"ComputerSystem": {"@odata.id": "/redfish/v1/Systems/1"},
+					"Storage": [` + storage + `]
+				},
+				"Actions": {
+					"#ComposedNode.AttachResource": {
+						"target": "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource"
+					}
+				}
+			}`,
+			"/redfish/v1/Systems/1":                 `{"Links": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/nqn.2"}]}}`,
+			"/redfish/v1/Fabrics/1/Endpoints/nqn.2": `{"Id": "nqn.2", "Identifiers": [{"DurableName": "nqn.2", "DurableNameFormat": "NQN"}]}`,
+		}}}
+	}
+
+	tests := []struct {
+		name       string
+		storage    string
+		wantAttach bool
+	}{
+		{
+			name:    "attached in RSD",
+			storage: `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}`,
+		},
+		{
+			name:       "not attached in RSD",
+			storage:    `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}`,
+			wantAttach: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient(tt.storage)
+			vol := &Volume{
+				Name:      "CSI-generated",
+				RSDVolume: &rsd.Volume{ID: "1", OdataID: "/redfish/v1/StorageServices/1/Volumes/1"},
+				CSIVolume: &csi.Volume{VolumeId: "1"},
+			}
+			drv := &Driver{rsdClient: client}
+
+			nqn, err := drv.attachToNode(context.Background(), vol, "1", nil)
+			if err != nil {
+				t.Fatalf("attachToNode() unexpected error: %v", err)
+			}
+			if nqn != "nqn.2" {
+				t.Errorf("attachToNode() = %q, want the node NQN nqn.2", nqn)
+			}
+			attached := len(client.posted) == 1 && client.posted[0] == "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource"
+			if attached != tt.wantAttach || (!tt.wantAttach && len(client.posted) != 0) {
+				t.Errorf("attached = %v (requests %v), want %v", attached, client.posted, tt.wantAttach)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const (
+	// CSIDriverCheckFail fails the driver start if the CSIDriver object doesn't match the driver
+	CSIDriverCheckFail = "fail"
+	// CSIDriverCheckWarn only logs the mismatches
+	CSIDriverCheckWarn = "warn"
+	// CSIDriverCheckOff disables the check of the CSIDriver object
+	CSIDriverCheckOff = "off"
+)
+
+// CSIDriverCheckModes returns the supported modes of the CSIDriver object check
+func CSIDriverCheckModes() []string {
+	return []string{CSIDriverCheckFail, CSIDriverCheckWarn, CSIDriverCheckOff}
+}
+
+// CSIDriverSpec are the settings of the Kubernetes CSIDriver object of the
+// driver. Settings not set in the object are nil, Kubernetes defaults apply.
+type CSIDriverSpec struct {
+	// AttachRequired makes Kubernetes call ControllerPublishVolume, it's true by default
+	AttachRequired *bool
+	// PodInfoOnMount makes Kubernetes pass the pod to NodePublishVolume, it's false by default
+	PodInfoOnMount *bool
+}
+
+// attachRequired returns the attachRequired setting with its Kubernetes default
+func (spec CSIDriverSpec) attachRequired() bool {
+	return spec.AttachRequired == nil || *spec.AttachRequired
+}
+
+// podInfoOnMount returns the podInfoOnMount setting with its Kubernetes default
+func (spec CSIDriverSpec) podInfoOnMount() bool {
+	return spec.PodInfoOnMount != nil && *spec.PodInfoOnMount
+}
+
+// CheckCSIDriver compares the CSIDriver object settings with the behavior of
+// the driver. It returns the mismatches the driver doesn't work with, e.g.
+// attachRequired disabled while volumes are published by ControllerPublishVolume,
+// and the warnings about the settings disabling the optional driver features.
+func (drv *Driver) CheckCSIDriver(spec CSIDriverSpec) (mismatches, warnings []string) {
+	publishes := false
+	for _, cap := range drv.controllerCapabilities() {
+		if cap == csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME {
+			publishes = true
+		}
+	}
+	switch {
+	case publishes && !spec.attachRequired():
+		mismatches = append(mismatches, "attachRequired is false, but the volumes are attached to the nodes by ControllerPublishVolume")
+	case !publishes && spec.attachRequired():
+		mismatches = append(mismatches, "attachRequired is true, but the driver doesn't report the ControllerPublishVolume capability")
+	}
+
+	if drv.events != nil && !spec.podInfoOnMount() {
+		warnings = append(warnings, "podInfoOnMount is false, volume events are not reported about the pods")
+	}
+	return mismatches, warnings
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"testing"
+)
+
+func TestCheckCSIDriver(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name           string
+		spec           CSIDriverSpec
+		events         bool
+		wantMismatches int
+		wantWarnings   int
+	}{
+		{name: "defaults"},
+		{name: "attach required", spec: CSIDriverSpec{AttachRequired: &enabled}},
+		{name: "attach not required", spec: CSIDriverSpec{AttachRequired: &disabled}, wantMismatches: 1},
+		{name: "events without pod info", events: true, wantWarnings: 1},
+		{name: "events with pod info disabled", spec: CSIDriverSpec{PodInfoOnMount: &disabled}, events: true, wantWarnings: 1},
+		{name: "events with pod info", spec: CSIDriverSpec{PodInfoOnMount: &enabled}, events: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{}
+			if tt.events {
+				drv.SetEventSink(&recordingEventSink{}, 1)
+			}
+			mismatches, warnings := drv.CheckCSIDriver(tt.spec)
+			if len(mismatches) != tt.wantMismatches || len(warnings) != tt.wantWarnings {
+				t.Errorf("CheckCSIDriver() = %q, %q, want %d mismatches and %d warnings", mismatches, warnings, tt.wantMismatches, tt.wantWarnings)
+			}
+		})
+	}
+}
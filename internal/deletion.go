@@ -0,0 +1,107 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// SetMaxConcurrentDeletes limits the number of RSD volumes deleted by the
+// controller at the same time, so tearing down a namespace with many volumes
+// doesn't overload RSD. Zero means no limit.
+func (drv *Driver) SetMaxConcurrentDeletes(n int) {
+	drv.deleteSlots = nil
+	if n > 0 {
+		drv.deleteSlots = make(chan struct{}, n)
+	}
+}
+
+// acquireDeleteSlot waits until the volume can be deleted without
+// exceeding the limit of concurrent deletions or the request is cancelled
+func (drv *Driver) acquireDeleteSlot(ctx context.Context) error {
+	if drv.deleteSlots == nil {
+		return nil
+	}
+	select {
+	case drv.deleteSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseDeleteSlot allows another volume to be deleted
+func (drv *Driver) releaseDeleteSlot() {
+	if drv.deleteSlots != nil {
+		<-drv.deleteSlots
+	}
+}
+
+// deleteVolume deletes RSD volume using RSD API and removes volume from the
+// internal map drv.volumes. It does nothing if volume doesn't exist.
+// The RSD volume is deleted without holding drv.volumesRWL, so deletions of
+// different volumes run in parallel, and the capacity cache is invalidated
+// once the last of the concurrent deletions finishes.
+func (drv *Driver) deleteVolume(ctx context.Context, volumeID string) error {
+	if err := drv.acquireDeleteSlot(ctx); err != nil {
+		return err
+	}
+	defer drv.releaseDeleteSlot()
+
+	if err := drv.volumeLocks.lock(volumeID, "DeleteVolume"); err != nil {
+		return err
+	}
+	defer drv.volumeLocks.unlock(volumeID)
+
+	drv.volumesRWL.Lock()
+	name, vol := drv.findVolByID(volumeID)
+	if name == "" {
+		drv.volumesRWL.Unlock()
+		return nil
+	}
+	ctx, err := volumeRSDContext(ctx, vol)
+	if err != nil {
+		drv.volumesRWL.Unlock()
+		return err
+	}
+	drv.deletesInFlight++
+	drv.volumesRWL.Unlock()
+
+	err = vol.RSDVolume.Delete(ctx, drv.client(ctx))
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	drv.deletesInFlight--
+	if drv.deletesInFlight == 0 {
+		drv.capacity.invalidate()
+	}
+
+	if rsd.Classify(err) == rsd.CategoryNotFound {
+		// deleted out of band, forget it so the deletion doesn't fail forever
+		drv.logger.Warning("RSD volume is already deleted", "volume", name, "rsd_volume", vol.RSDVolume.ID, "error", err)
+		drv.metrics.volumesDeletedOutOfBand.Inc()
+	} else if err != nil {
+		return errors.Wrapf(err, "can't delete RSD Volume %s", vol.RSDVolume.ID)
+	}
+
+	// delete volume from the map
+	delete(drv.volumes, name)
+	drv.allocations.remove(volumeID)
+	drv.metrics.volumeCapacityShrunk.Delete(volumeID)
+	return nil
+}
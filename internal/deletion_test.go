@@ -0,0 +1,157 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockingDeleteClient blocks Delete until release is closed
+type blockingDeleteClient struct {
+	TestClient
+	started chan string
+	release chan struct{}
+}
+
+func (client *blockingDeleteClient) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.started <- entrypoint
+	<-client.release
+	return client.TestClient.Delete(ctx, entrypoint, data, result)
+}
+
+func deletionTestDriver(count int) (*Driver, *blockingDeleteClient) {
+	client := &blockingDeleteClient{
+		started: make(chan string, count),
+		release: make(chan struct{}),
+	}
+	drv := &Driver{
+		rsdClient: client,
+		volumes:   map[string]*Volume{},
+		capacity:  newCapacityCache(time.Minute),
+	}
+	for i := 1; i <= count; i++ {
+		id := fmt.Sprint(i)
+		drv.volumes["pvc-"+id] = &Volume{
+			RSDVolume: &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/" + id},
+			CSIVolume: &csi.Volume{VolumeId: id},
+		}
+	}
+	return drv, client
+}
+
+func TestDeleteVolumesConcurrently(t *testing.T) {
+	const volumes, limit = 6, 2
+	drv, client := deletionTestDriver(volumes)
+	drv.SetMaxConcurrentDeletes(limit)
+	generation := drv.capacity.begin()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, volumes)
+	for i := 1; i <= volumes; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: id})
+			errs <- err
+		}(fmt.Sprint(i))
+	}
+
+	for i := 0; i < limit; i++ {
+		<-client.started
+	}
+	select {
+	case entrypoint := <-client.started:
+		t.Fatalf("deletion of %s started over the limit of %d", entrypoint, limit)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the volumes being deleted don't block the others from reading them
+	drv.volumesRWL.RLock()
+	known := len(drv.volumes)
+	drv.volumesRWL.RUnlock()
+	if known != volumes {
+		t.Errorf("%d volumes known while deleting, want %d", known, volumes)
+	}
+
+	close(client.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("DeleteVolume() unexpected error: %v", err)
+		}
+	}
+	if len(drv.volumes) != 0 {
+		t.Errorf("volumes left after deleting: %v", drv.volumes)
+	}
+	if drv.capacity.begin() == generation {
+		t.Error("capacity cache is not invalidated")
+	}
+	if drv.deletesInFlight != 0 {
+		t.Errorf("%d deletions in flight after deleting", drv.deletesInFlight)
+	}
+}
+
+func TestDeleteVolumePending(t *testing.T) {
+	drv, client := deletionTestDriver(1)
+
+	done := make(chan error)
+	go func() {
+		_, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "1"})
+		done <- err
+	}()
+	<-client.started
+
+	_, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "1"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("DeleteVolume() of the volume being deleted = %v, want %v", err, codes.Aborted)
+	}
+
+	close(client.release)
+	if err := <-done; err != nil {
+		t.Fatalf("DeleteVolume() unexpected error: %v", err)
+	}
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "1"}); err != nil {
+		t.Errorf("DeleteVolume() of the deleted volume unexpected error: %v", err)
+	}
+}
+
+func TestDeleteVolumeSlotCancelled(t *testing.T) {
+	drv, client := deletionTestDriver(2)
+	drv.SetMaxConcurrentDeletes(1)
+
+	go drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "1"})
+	<-client.started
+	defer close(client.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := drv.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "2"}); err == nil {
+		t.Error("DeleteVolume() waiting for a deletion slot succeeded after the request is cancelled")
+	}
+	if _, exists := drv.volumes["pvc-2"]; !exists {
+		t.Error("volume is forgotten although its deletion is cancelled")
+	}
+}
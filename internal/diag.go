@@ -0,0 +1,335 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// Paths of the driver HTTP server used by 'csirsd diag'
+const (
+	debugVolumesPath   = "/debug/volumes"
+	debugPublishedPath = "/debug/published"
+	debugLogsPath      = "/debug/logs"
+	debugRSDPath       = "/debug/rsd"
+	debugSchemasPath   = "/debug/schemas"
+)
+
+// diagRequestTimeout limits the time of getting driver state from its HTTP server
+const diagRequestTimeout = 10 * time.Second
+
+// LogBuffer keeps the latest driver log lines for the diagnostics.
+// It implements io.Writer, so it can be added to the log output.
+type LogBuffer struct {
+	mu    sync.Mutex
+	size  int
+	next  int
+	lines []string
+}
+
+// NewLogBuffer returns LogBuffer keeping size latest log lines
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{size: size}
+}
+
+// Write implements io.Writer interface
+func (buf *LogBuffer) Write(p []byte) (int, error) {
+	if buf.size <= 0 {
+		return len(p), nil
+	}
+
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if len(buf.lines) < buf.size {
+			buf.lines = append(buf.lines, line)
+			continue
+		}
+		buf.lines[buf.next] = line
+		buf.next = (buf.next + 1) % buf.size
+	}
+	return len(p), nil
+}
+
+// Lines returns buffered log lines starting from the oldest one
+func (buf *LogBuffer) Lines() []string {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	result := make([]string, 0, len(buf.lines))
+	result = append(result, buf.lines[buf.next:]...)
+	return append(result, buf.lines[:buf.next]...)
+}
+
+// volumeDump is a volume record as it's exposed for the diagnostics
+type volumeDump struct {
+	Name              string            `json:"name"`
+	VolumeID          string            `json:"volumeId"`
+	CapacityBytes     int64             `json:"capacityBytes"`
+	RequiredBytes     int64             `json:"requiredBytes"`
+	VolumeContext     map[string]string `json:"volumeContext,omitempty"`
+	RSDVolume         string            `json:"rsdVolume"`
+	RSDNodeID         string            `json:"rsdNodeId,omitempty"`
+	RSDNodeNQN        string            `json:"rsdNodeNqn,omitempty"`
+	Device            string            `json:"device,omitempty"`
+	FSLabel           string            `json:"fsLabel,omitempty"`
+	FSUUID            string            `json:"fsUuid,omitempty"`
+	DeviceByID        string            `json:"deviceById,omitempty"`
+	IsBlock           bool              `json:"isBlock,omitempty"`
+	IsPublished       bool              `json:"isPublished"`
+	IsStaged          bool              `json:"isStaged"`
+	StagingTargetPath string            `json:"stagingTargetPath,omitempty"`
+	TargetPaths       []string          `json:"targetPaths,omitempty"`
+	Conflicts         []string          `json:"conflictingRsdVolumes,omitempty"`
+	Portal            string            `json:"portal,omitempty"`
+	AltPortals        []string          `json:"alternativePortals,omitempty"`
+	Operation         string            `json:"operationInProgress,omitempty"`
+	RSDOperation      string            `json:"rsdOperation,omitempty"`
+	Abnormal          string            `json:"abnormal,omitempty"`
+}
+
+// dumpVolumes returns records of all known volumes sorted by name. They're
+// copied at once, so the dump is consistent while volumes are created and
+// deleted.
+func (drv *Driver) dumpVolumes() []volumeDump {
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+
+	result := []volumeDump{}
+	for name, vol := range drv.volumes {
+		dump := volumeDump{
+			Name:              name,
+			VolumeID:          vol.CSIVolume.VolumeId,
+			CapacityBytes:     vol.CSIVolume.CapacityBytes,
+			RequiredBytes:     vol.RequiredBytes,
+			VolumeContext:     copyStringMap(vol.CSIVolume.VolumeContext),
+			RSDNodeID:         vol.RSDNodeID,
+			RSDNodeNQN:        vol.RSDNodeNQN,
+			Device:            vol.Device,
+			FSLabel:           vol.FSLabel,
+			FSUUID:            vol.FSUUID,
+			DeviceByID:        vol.DeviceByID,
+			IsBlock:           vol.IsBlock,
+			IsPublished:       vol.IsPublished,
+			IsStaged:          vol.IsStaged,
+			StagingTargetPath: vol.StagingTargetPath,
+			Conflicts:         vol.Conflicts,
+			Operation:         drv.volumeLocks.inFlight(vol.CSIVolume.VolumeId),
+			RSDOperation:      vol.operationProgress(),
+			Abnormal:          vol.Abnormal,
+		}
+		if vol.RSDVolume != nil {
+			dump.RSDVolume = vol.RSDVolume.OdataID
+		}
+		if vol.EndPoint != nil {
+			dump.Portal = vol.EndPoint.HostPort()
+		}
+		for _, ep := range vol.AltEndPoints {
+			dump.AltPortals = append(dump.AltPortals, ep.HostPort())
+		}
+		for path := range vol.TargetPaths {
+			dump.TargetPaths = append(dump.TargetPaths, path)
+		}
+		sort.Strings(dump.TargetPaths)
+		result = append(result, dump)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// copyStringMap returns a copy of the map, nil for nil map
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	return result
+}
+
+// writeJSON writes indented JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(value) // nolint: errcheck
+}
+
+// DebugHandler returns http.Handler exposing driver state collected by 'csirsd diag':
+// volume records, published volume devices, latest log lines, latest RSD responses
+// and OData schema versions of the RSD resources. Logs, recorder and schemas may be nil.
+func (drv *Driver) DebugHandler(logs *LogBuffer, recorder *rsd.Recorder, schemas *rsd.Schemas) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugVolumesPath, func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, drv.dumpVolumes())
+	})
+	mux.HandleFunc(debugPublishedPath, func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, drv.publishedVolumes())
+	})
+	mux.HandleFunc(debugLogsPath, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if logs == nil {
+			return
+		}
+		for _, line := range logs.Lines() {
+			fmt.Fprintln(w, rsd.Redact(line))
+		}
+	})
+	mux.HandleFunc(debugRSDPath, func(w http.ResponseWriter, req *http.Request) {
+		responses := []rsd.RecordedResponse{}
+		if recorder != nil {
+			responses = recorder.Responses()
+		}
+		writeJSON(w, responses)
+	})
+	mux.HandleFunc(debugSchemasPath, func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, schemas.Versions())
+	})
+	return mux
+}
+
+// diagItem is a file of the diagnostics bundle
+type diagItem struct {
+	name    string
+	collect func() ([]byte, error)
+}
+
+// DiagOptions defines where 'csirsd diag' collects the diagnostics from
+type DiagOptions struct {
+	// DriverAddress is the address of the driver HTTP server, e.g. localhost:8080.
+	// Driver state is not collected if it's empty.
+	DriverAddress string
+	// HostRoot is a directory to run nvme tool chrooted into, if it's not empty
+	HostRoot string
+}
+
+// WriteDiagBundle writes gzipped tar archive with the driver logs, volume records,
+// latest RSD responses, NVMe devices and mount table of the node. Credentials
+// are redacted. Items which can't be collected are listed in errors.txt of the
+// archive and returned.
+func WriteDiagBundle(w io.Writer, opts DiagOptions) []error {
+	execer := withCommandTimeout(newExecer(opts.HostRoot), policy.Default().CommandTimeout)
+	return writeDiagBundle(w, execer, procMounts, opts.DriverAddress)
+}
+
+func writeDiagBundle(w io.Writer, execer Execer, mountsFile, driverAddress string) []error {
+	var items []diagItem
+	if driverAddress != "" {
+		client := &http.Client{Timeout: diagRequestTimeout}
+		for name, path := range map[string]string{
+			"logs.txt":           debugLogsPath,
+			"volumes.json":       debugVolumesPath,
+			"published.json":     debugPublishedPath,
+			"rsd-responses.json": debugRSDPath,
+			"rsd-schemas.json":   debugSchemasPath,
+		} {
+			url := "http://" + driverAddress + path
+			items = append(items, diagItem{name: name, collect: func() ([]byte, error) {
+				return httpGet(client, url)
+			}})
+		}
+	}
+	items = append(items,
+		diagItem{name: "nvme-list.json", collect: func() ([]byte, error) {
+			out, err := execer.CombinedOutput("nvme", "list", "-o", "json")
+			if err != nil {
+				return nil, fmt.Errorf("'nvme list' failed: %v, output: %q", err, string(out))
+			}
+			return out, nil
+		}},
+		diagItem{name: "mounts.txt", collect: func() ([]byte, error) {
+			return ioutil.ReadFile(mountsFile)
+		}},
+	)
+	sort.Slice(items, func(i, j int) bool { return items[i].name < items[j].name })
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	var errs []error
+	for _, item := range items {
+		content, err := item.collect()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", item.name, err))
+			continue
+		}
+		if err := writeTarFile(tw, item.name, []byte(rsd.Redact(string(content))), now); err != nil {
+			return append(errs, err)
+		}
+	}
+
+	var errText strings.Builder
+	for _, err := range errs {
+		fmt.Fprintln(&errText, rsd.Redact(err.Error()))
+	}
+	if err := writeTarFile(tw, "errors.txt", []byte(errText.String()), now); err != nil {
+		return append(errs, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return append(errs, err)
+	}
+	if err := gz.Close(); err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+// httpGet returns body of the successful HTTP GET response
+func httpGet(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error %d while requesting %s", resp.StatusCode, url)
+	}
+	return body, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
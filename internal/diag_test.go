@@ -0,0 +1,148 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buf := NewLogBuffer(3)
+	logger := log.New(buf, "", 0)
+	for i := 1; i <= 3; i++ {
+		logger.Printf("line %d", i)
+	}
+	logger.Print("line 4\nline 5")
+
+	want := []string{"line 3", "line 4", "line 5"}
+	if got := buf.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lines() = %q, want %q", got, want)
+	}
+}
+
+// readBundle returns files of the gzipped tar archive
+func readBundle(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+func TestWriteDiagBundle(t *testing.T) {
+	drv := &Driver{
+		volumes: map[string]*Volume{
+			"pvc-1": &Volume{
+				Name:        "pvc-1",
+				CSIVolume:   &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+				RSDVolume:   &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/1"},
+				Device:      "/dev/nvme1n1",
+				IsStaged:    true,
+				TargetPaths: map[string]bool{"/mnt/target": true},
+			},
+		},
+	}
+	logs := NewLogBuffer(10)
+	fmt.Fprintln(logs, `RSD response: {"Password": "secret"}`)
+	server := httptest.NewServer(drv.DebugHandler(logs, nil, nil))
+	defer server.Close()
+
+	mounts, err := ioutil.TempFile("", "csi-rsd-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mounts.Name())
+	mounts.WriteString("/dev/nvme1n1 /var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount ext4 rw 0 0\n") // nolint: errcheck
+	mounts.Close()
+
+	execer := &fakeExecer{
+		outputs: map[string]string{"nvme list -o json": `{"Devices": [{"DevicePath": "/dev/nvme1n1"}]}`},
+	}
+
+	var buf bytes.Buffer
+	errs := writeDiagBundle(&buf, execer, mounts.Name(), strings.TrimPrefix(server.URL, "http://"))
+	if len(errs) != 0 {
+		t.Fatalf("writeDiagBundle() unexpected errors: %v", errs)
+	}
+
+	files := readBundle(t, buf.Bytes())
+	for name, want := range map[string]string{
+		"logs.txt":           `"Password": "***"`,
+		"volumes.json":       `"device": "/dev/nvme1n1"`,
+		"published.json":     `"targetPath": "/mnt/target"`,
+		"rsd-responses.json": "[]",
+		"rsd-schemas.json":   "[]",
+		"nvme-list.json":     "/dev/nvme1n1",
+		"mounts.txt":         "globalmount",
+		"errors.txt":         "",
+	} {
+		content, exists := files[name]
+		if !exists {
+			t.Errorf("bundle doesn't contain %s", name)
+			continue
+		}
+		if !strings.Contains(content, want) {
+			t.Errorf("%s doesn't contain %q:\n%s", name, want, content)
+		}
+	}
+	if strings.Contains(files["logs.txt"], "secret") {
+		t.Errorf("logs are not redacted:\n%s", files["logs.txt"])
+	}
+
+	// driver state can't be collected without the driver
+	buf.Reset()
+	execer.failures = map[string]error{"nvme list -o json": fmt.Errorf("exit status 1")}
+	errs = writeDiagBundle(&buf, execer, mounts.Name(), "")
+	if len(errs) != 1 {
+		t.Fatalf("writeDiagBundle() errors = %v, want nvme failure", errs)
+	}
+	files = readBundle(t, buf.Bytes())
+	if _, exists := files["logs.txt"]; exists {
+		t.Error("bundle contains driver logs without driver address")
+	}
+	if !strings.Contains(files["errors.txt"], "nvme-list.json") {
+		t.Errorf("errors.txt doesn't mention nvme failure:\n%s", files["errors.txt"])
+	}
+}
@@ -15,22 +15,30 @@
 package csirsd
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
 	"github.com/intel/csi-intel-rsd/pkg/rsd"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -44,22 +52,25 @@ const (
 	// PublishInfoVolumeName is used to pass the volume name from
 	// `ControllerPublishVolume` to `NodeStageVolume or `NodePublishVolume`
 	PublishInfoVolumeName = DriverName + "/volume-name"
-)
 
-type endPointInfo struct {
-	ipAddress         string
-	ipAddressFamily   string
-	ipPort            int
-	transportProtocol string
-	nqn               string
-}
+	// PublishInfoSubsystemNQN passes subsystem NQN of the published volume,
+	// so the node can find its device after the driver restart
+	PublishInfoSubsystemNQN = DriverName + "/subsystem-nqn"
+
+	// fsLabelPrefix starts filesystem labels of the volumes formatted by the driver
+	fsLabelPrefix = "rsd-"
+
+	// descriptionSeparator separates driver name, cluster ID and
+	// CSI volume name in the RSD volume Description
+	descriptionSeparator = ":"
+)
 
 // Volume contains mapping between CSI and RSD volumes and internal driver information about a volume status
 type Volume struct {
 	Name              string
 	CSIVolume         *csi.Volume
 	RSDVolume         *rsd.Volume
-	EndPoint          *endPointInfo
+	EndPoint          *endpoint.Portal
 	RSDNodeID         string
 	RSDNodeNQN        string
 	Device            string
@@ -67,44 +78,303 @@ type Volume struct {
 	IsStaged          bool
 	StagingTargetPath string
 	TargetPaths       map[string]bool
+	// FSLabel is the filesystem label set by the driver when formatting the volume
+	FSLabel string
+	// FSUUID is the filesystem UUID of the staged volume
+	FSUUID string
+	// DeviceByID is a stable /dev/disk/by-id path of the staged volume device
+	DeviceByID string
+	// IsBlock is set if the volume is staged as a raw block device without a filesystem
+	IsBlock bool
+	// Conflicts are OdataIDs of other RSD volumes tagged with the same CSI name,
+	// which are not managed by the driver
+	Conflicts []string
+	// AltEndPoints are other portals of the volume tried in order
+	// if the EndPoint portal is unreachable from the node
+	AltEndPoints []*endpoint.Portal
+
+	// RequiredBytes is the capacity requested when the volume was created
+	RequiredBytes int64
+	// Operation is the RSD operation of the volume in progress as of the
+	// latest read of the RSD volume, e.g. background initialization
+	Operation string
+	// OperationPercent is the completion percentage of Operation
+	OperationPercent int
+	// Abnormal is why NodeGetVolumeStats found the volume abnormal,
+	// empty if it's healthy
+	Abnormal string
+	// RSDEndpoint is URL of the RSD endpoint from the CSI secrets managing
+	// the volume, empty for the default one
+	RSDEndpoint string
+	// Rack is ID of the rack the volume is in, empty for the default RSD endpoint
+	Rack string
+	// rsdClient is the client of RSDEndpoint or Rack, nil for the default one
+	rsdClient rsd.Transport
 }
 
 // Driver implements the following CSI interfaces:
 //
-//   csi.IdentityServer
-//   csi.ControllerServer
-//   csi.NodeServer
-//
+//	csi.IdentityServer
+//	csi.ControllerServer
+//	csi.NodeServer
 type Driver struct {
 	sync.Mutex
+	// RPCs of newer CSI specs the driver doesn't serve return UNIMPLEMENTED
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
 	endpoint  string
 	srv       *grpc.Server
 	RSDNodeID string
+	// mode selects the CSI services the driver serves, DriverModeAll if empty
+	mode string
+	// nodeNames resolves Kubernetes node names to RSD node IDs, disabled if nil
+	nodeNames *nodeNameMapping
+
+	// ClusterID identifies the cluster in the RSD volume descriptions,
+	// so several clusters can share the same RSD storage
+	ClusterID string
+	// VolumeNamePrefix limits the volumes the driver creates, adopts from
+	// RSD and lists to the ones with the CSI names starting with it
+	VolumeNamePrefix string
 
 	rsdClient rsd.Transport
-	mounter   Mounter
-	nvme      NVMe
+	// rsdClients are the clients of the RSD endpoints from the CSI secrets,
+	// nil if they're not enabled
+	rsdClients *rsdClientPool
+	// racks are the RSD PodMs of other racks served next to the default one
+	racks   []*rack
+	mounter Mounter
+	nvme    NVMe
+	// execer runs the node tools, nil on the fake node
+	execer Execer
+	// hostRoot is a directory with the host root filesystem, empty if it's the driver root
+	hostRoot string
+	// mountBackend is the backend mounting the volumes, MountBackendMount if empty
+	mountBackend string
+	// nvmeBackend is the backend connecting the volumes, NVMeBackendCLI if empty
+	nvmeBackend string
+	// filesystems are the filesystems the volumes may be formatted with,
+	// the first one is the default, any filesystem is allowed if it's empty
+	filesystems []string
+	// fakeNode makes the node use in-memory mounter and NVMe tools
+	fakeNode bool
+	// mountHelper is the connection to the mount helper running the tools,
+	// nil if the driver runs them itself
+	mountHelper *grpc.ClientConn
+	// policies are timeouts and retries of the nvme and mount tools
+	policies policy.Policies
+
+	// defaultVolumeSize is the capacity of the volumes created without capacity range,
+	// defaultVolumeCapacity if it's 0
+	defaultVolumeSize int64
+	// maxTotalCapacity is the budget of the total capacity of the volumes
+	// provisioned by the driver, unlimited if it's 0
+	maxTotalCapacity int64
+	// expandSecretsRequired makes the driver expand only the volumes with the expansion secrets
+	expandSecretsRequired bool
+
+	// stageSlots limits the number of volumes staged at the same time, nil if unlimited
+	stageSlots chan struct{}
+	// deleteSlots limits the number of volumes deleted at the same time, nil if unlimited
+	deleteSlots chan struct{}
+	// deletesInFlight counts the volumes being deleted, guarded by volumesRWL
+	deletesInFlight int
+
+	// nodes caches RSD nodes volumes are published to, nil if caching is disabled
+	nodes *nodeCache
+	// capacity caches the capacity reported by GetCapacity, nil if caching is disabled
+	capacity *capacityCache
+
+	// compat implements behaviors of the configured CSI compatibility level,
+	// nil for the default level
+	compat csiCompat
+
+	// fabricDirect makes the controller zone volumes to the host NQNs
+	// instead of attaching them to RSD nodes
+	fabricDirect bool
+
+	// endPointSelector orders portals of the volumes, nil to keep the RSD order
+	endPointSelector endPointSelector
+
+	// portalCheckTimeout limits checking the volume portal is reachable before
+	// connecting to it, portals are not checked if it's 0
+	portalCheckTimeout time.Duration
+	// checkPortal checks the portal is reachable, checkPortal function if nil
+	checkPortal func(address string, timeout time.Duration) error
+
+	// identityXattrs makes the node set the identity of the staged volumes as
+	// extended attributes of their staging paths
+	identityXattrs bool
+	// setXattr sets the extended attribute of the path, setXattr function if nil
+	setXattr func(path, name, value string) error
+
+	// powerOnNodes makes the controller power on RSD nodes before attaching volumes
+	powerOnNodes bool
+
+	// topology makes the driver report the storage services reachable from
+	// the nodes as their topology and place the volumes accordingly
+	topology bool
+
+	// transports are NVMe-oF transports probed on the node, nil if the
+	// transports of the published volumes are not checked
+	transports nodeTransports
+	// transportCheckWarn makes publishing of volumes the node can't connect
+	// only log a warning
+	transportCheckWarn bool
+	// reconcileInterval is the interval of reconciling the volume records
+	// with RSD in the background of Run, disabled if it's 0
+	reconcileInterval time.Duration
+	// reconcileRepair makes the reconciliation attach the volumes detached
+	// out of band again
+	reconcileRepair bool
+
+	// transportPreference lists NVMe-oF transports of the portals used in the
+	// order of preference, portals of all transports are used in the RSD order if empty
+	transportPreference []string
+
+	// spares is a pool of pre-created volumes, nil if it's not configured
+	spares *sparePool
+	// names maps sanitized volume names stored in RSD to the CSI volume names
+	names volumeNames
+
+	// allocations are the volume allocation records, nil if they're not exported
+	allocations *allocationLog
+	// events report repeated failures of the node operations, nil if disabled
+	events *volumeEvents
+	// journal keeps staged state of the volumes on the node, nil if disabled
+	journal *nodeJournal
+
+	// snapshots are the volume snapshots, protected by volumesRWL
+	snapshots map[string]*Snapshot
+	// pendingCreations are the volume creations with RSD tasks still
+	// running, by the volume name, protected by volumesRWL
+	pendingCreations map[string]*pendingCreation
 
 	volumes    map[string]*Volume
 	volumesRWL sync.RWMutex
+	// volumeLists are the snapshots of the volumes paged through by ListVolumes
+	volumeLists volumeLists
+	// volumeLocks are the volumes with operations in progress by the volume ID
+	volumeLocks volumeLocks
+	// creationLocks are the volumes being created by the volume name
+	creationLocks volumeLocks
+	// snapshotLocks are the snapshots being taken or deleted by the snapshot name
+	snapshotLocks volumeLocks
+	// reservedCapacity is the capacity of the volumes being created,
+	// counted in the total capacity budget, protected by volumesRWL
+	reservedCapacity int64
+
+	metrics driverMetrics
+
+	// clock returns the current time, time.Now if nil
+	clock func() time.Time
+	// endpointAuth verifies the clients of the tcp:// endpoint, nil for unix:// endpoints
+	endpointAuth *endpointAuth
+	// strictCapabilities hides the capabilities whose prerequisites aren't
+	// enabled and makes their RPCs fail with Unimplemented
+	strictCapabilities bool
+	// disabledCapabilities are the controller capabilities not reported
+	// by ControllerGetCapabilities, nil if all are reported
+	disabledCapabilities map[csi.ControllerServiceCapability_RPC_Type]bool
+
+	// logger logs the driver operations, CSI calls are logged
+	// with the Logger of their context
+	logger Logger
 
 	// ready defines whether the driver is ready to function. This value will
 	// be used by the `Identity` service via the `Probe()` method.
 	ready   bool
 	readyMu sync.Mutex // protects ready
+
+	// health is a standard gRPC health service reporting the same readiness as Probe()
+	health *health.Server
 }
 
 // NewDriver returns a CSI plugin that contains the necessary gRPC
 // interfaces to interact with Kubernetes over unix domain socket
 func NewDriver(ep string, RSDNodeID string, rsdClient rsd.Transport) *Driver {
-	return &Driver{
+	policies := policy.Default()
+	drv := &Driver{
 		endpoint:  ep,
 		RSDNodeID: RSDNodeID,
 		rsdClient: rsdClient,
-		mounter:   &mounter{},
-		nvme:      &nvme{},
+		policies:  policies,
+		mounter:   newMounter(hostExecer{}, policies),
+		nvme:      newNVMe(hostExecer{}, policies),
 		volumes:   map[string]*Volume{},
+		snapshots: map[string]*Snapshot{},
+		metrics:   newDriverMetrics(),
+	}
+	drv.addMetricsCollectors()
+	return drv
+}
+
+// addMetricsCollectors registers the collectors of the driver metrics read on exposition
+func (drv *Driver) addMetricsCollectors() {
+	drv.metrics.registry.addCollector(drv.collectNVMeMetrics)
+	drv.metrics.registry.addCollector(drv.collectNodeVolumeMetrics)
+	drv.metrics.registry.addCollector(drv.collectCapacityCacheMetrics)
+}
+
+// SetHostRoot makes the driver run mount, mkfs and nvme tools chrooted into
+// the host root directory instead of using the ones from the driver image
+func (drv *Driver) SetHostRoot(hostRoot string) {
+	drv.hostRoot = hostRoot
+	drv.setTools()
+}
+
+// SetPolicies sets timeouts and retries of the nvme and mount tools
+func (drv *Driver) SetPolicies(policies policy.Policies) {
+	drv.policies = policies
+	drv.setTools()
+}
+
+// setTools creates mounter and nvme tools for the host root and policies
+func (drv *Driver) setTools() {
+	if drv.fakeNode {
+		drv.mounter, drv.nvme = newFakeNode()
+		drv.execer = nil
+		return
+	}
+	execer := newExecer(drv.hostRoot)
+	if drv.mountHelper != nil {
+		execer = &helperExecer{conn: drv.mountHelper, root: drv.hostRoot}
+	}
+	drv.execer = execer
+	if drv.mountBackend == MountBackendSystemd {
+		drv.mounter = newSystemdMounter(execer, drv.policies)
+	} else {
+		drv.mounter = newMounter(execer, drv.policies)
+	}
+	if drv.nvmeBackend == NVMeBackendNative && drv.mountHelper == nil {
+		drv.nvme = newNativeNVMe(execer, drv.policies, drv.logger)
+	} else {
+		drv.nvme = newNVMe(execer, drv.policies)
+	}
+}
+
+// lastRequestID is the ID of the latest CSI call, it's accessed atomically
+var lastRequestID uint64
+
+// interceptor adds Logger with a request ID to the context, logs response errors and observes duration of the CSI calls
+func (drv *Driver) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	logger := drv.logger.WithValues("request_id", atomic.AddUint64(&lastRequestID, 1))
+	if drv.endpointAuth != nil {
+		if err := drv.endpointAuth.authorize(ctx, info.FullMethod); err != nil {
+			logger.Error(err, "method not authorized", "method", info.FullMethod)
+			return nil, err
+		}
+	}
+	start := time.Now()
+	resp, err := handler(withLogger(ctx, logger), req)
+	drv.metrics.operationsSeconds.Observe(time.Since(start).Seconds(), DriverName, info.FullMethod, status.Code(err).String())
+	drv.observeNodeOperation(logger, req, err)
+	if err != nil {
+		logger.Error(err, "method failed", "method", info.FullMethod)
 	}
+	return resp, err
 }
 
 // Run starts the CSI plugin by communication over the given endpoint
@@ -119,18 +389,29 @@ func (drv *Driver) Run() error {
 		spath = filepath.FromSlash(u.Path)
 	}
 
-	// CSI plugins talk only over UNIX sockets currently
-	if u.Scheme != "unix" {
-		return fmt.Errorf("currently only unix domain sockets are supported, have: %s", u.Scheme)
+	// CSI plugins talk over UNIX sockets, TCP is served only with mutual TLS
+	options := []grpc.ServerOption{grpc.UnaryInterceptor(drv.interceptor)}
+	switch {
+	case u.Scheme == "tcp" && drv.endpointAuth != nil:
+		spath = u.Host
+		options = append(options, drv.endpointAuth.serverOptions()...)
+	case u.Scheme == "tcp":
+		return fmt.Errorf("tcp endpoint %s needs endpoint TLS", drv.endpoint)
+	case u.Scheme != "unix":
+		return fmt.Errorf("only unix domain sockets and tcp with TLS are supported, have: %s", u.Scheme)
+	case drv.endpointAuth != nil:
+		return fmt.Errorf("endpoint TLS needs a tcp endpoint, have: %s", drv.endpoint)
 	}
 
 	// remove the socket if it's already there. This can happen if we
 	// deploy a new version and the socket was created from the old running
 	// plugin.
-	if _, err = os.Stat(spath); !os.IsNotExist(err) {
-		log.Printf("removing socket %s", spath)
-		if err = os.Remove(spath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove unix domain socket file %s, error: %v", spath, err)
+	if u.Scheme == "unix" {
+		if _, err = os.Stat(spath); !os.IsNotExist(err) {
+			drv.logger.Info("removing socket", "path", spath)
+			if err = os.Remove(spath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove unix domain socket file %s, error: %v", spath, err)
+			}
 		}
 	}
 
@@ -139,158 +420,317 @@ func (drv *Driver) Run() error {
 		return fmt.Errorf("failed to listen socket %s: %v", spath, err)
 	}
 
-	// log response errors
-	errHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		resp, err := handler(ctx, req)
-		if err != nil {
-			log.Printf("method %s failed, error: %s", info.FullMethod, err)
-		}
-		return resp, err
+	srv := grpc.NewServer(options...)
+	csi.RegisterIdentityServer(srv, drv)
+	if drv.servesController() {
+		csi.RegisterControllerServer(srv, drv)
 	}
+	if drv.servesNode() {
+		csi.RegisterNodeServer(srv, drv)
+	}
+	drv.Lock()
+	drv.srv = srv
+	drv.Unlock()
 
-	drv.srv = grpc.NewServer(grpc.UnaryInterceptor(errHandler))
-	csi.RegisterIdentityServer(drv.srv, drv)
-	csi.RegisterControllerServer(drv.srv, drv)
-	csi.RegisterNodeServer(drv.srv, drv)
-
-	drv.ready = true
-	log.Printf("server started serving on %s", drv.endpoint)
-	return drv.srv.Serve(listener)
-}
+	drv.health = health.NewServer()
+	healthpb.RegisterHealthServer(srv, drv.health)
+	drv.setReady(false)
 
-// List existing volumes sorted by name
-func (drv *Driver) listCSIVolumes() []*csi.Volume {
-	// sort volume names
-	keys := make([]string, 0, len(drv.volumes))
-	for k := range drv.volumes {
-		keys = append(keys, k)
+	if err := drv.adoptVolumes(); err != nil {
+		drv.logger.Warning("can't adopt existing RSD volumes", "error", err)
 	}
-	sort.Strings(keys)
 
-	// collect CSI volumes ordered by name
-	csiVolumes := []*csi.Volume{}
-	for _, k := range keys {
-		csiVolumes = append(csiVolumes, drv.volumes[k].CSIVolume)
+	if drv.servesController() {
+		if err := drv.checkDefaultVolumeSize(); err != nil {
+			drv.logger.Warning("can't validate default volume size", "error", err)
+		}
 	}
-	return csiVolumes
-}
 
-// Creates new volume and adds it to the Volumes map
-func (drv *Driver) newVolume(name string, requiredCapacity int64) (*csi.Volume, error) {
-	if _, exists := drv.volumes[name]; exists {
-		return nil, fmt.Errorf("failed attempt to create exisiting volume %s", name)
+	if drv.reconcileInterval > 0 && drv.servesController() {
+		stop := make(chan struct{})
+		defer close(stop)
+		go drv.ReconcileVolumes(drv.reconcileInterval, stop)
 	}
 
-	// Volume doesn't exist - create new one
+	drv.setReady(true)
+	drv.logger.Info("server started serving", "endpoint", drv.endpoint)
+	return srv.Serve(listener)
+}
 
-	// Get volume collection
-	client := drv.rsdClient
-	volCollection, err := rsd.GetVolumeCollection(client, 0)
-	if err != nil {
-		return nil, err
-	}
+// Stop stops the CSI server after the requests in progress are finished
+// and closes the connections to RSD
+func (drv *Driver) Stop() {
+	drv.setReady(false)
 
-	// Create new RSD volume
-	rsdVolume, err := volCollection.NewVolume(client, requiredCapacity)
-	if err != nil {
-		return nil, err
+	drv.Lock()
+	srv := drv.srv
+	drv.Unlock()
+	if srv != nil {
+		srv.GracefulStop()
 	}
 
-	csiVolume := &csi.Volume{
-		VolumeId:      rsdVolume.ID,
-		VolumeContext: map[string]string{"name": name},
-		CapacityBytes: rsdVolume.CapacityBytes,
+	if closer, ok := drv.rsdClient.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			drv.logger.Error(err, "can't close RSD client")
+		}
 	}
-
-	drv.volumes[name] = &Volume{
-		Name:        name,
-		CSIVolume:   csiVolume,
-		RSDVolume:   rsdVolume,
-		RSDNodeID:   "",
-		TargetPaths: make(map[string]bool),
+	for _, r := range drv.racks {
+		if closer, ok := r.client.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				drv.logger.Error(err, "can't close RSD client of the rack", "rack", r.id)
+			}
+		}
+	}
+	if drv.rsdClients != nil {
+		if err := drv.rsdClients.close(); err != nil {
+			drv.logger.Error(err, "can't close RSD clients of the endpoints from the secrets")
+		}
 	}
+	if drv.mountHelper != nil {
+		drv.mountHelper.Close() // nolint: errcheck
+	}
+	drv.logger.Info("server stopped")
+}
 
-	return csiVolume, nil
+// volumeDescription returns RSD volume Description for the CSI volume name.
+// The Description is used to find volumes created by the driver in the
+// cluster, e.g. csi.rsd.intel.com:cluster1:pvc-a385b1a2. The name is sanitized
+// if it doesn't fit in the Description or contains unsafe characters.
+func (drv *Driver) volumeDescription(name string) string {
+	return strings.Join([]string{DriverName, drv.ClusterID, drv.rsdVolumeName(name)}, descriptionSeparator)
 }
 
-func (drv *Driver) findCSIVolumeByName(name string) *csi.Volume {
-	if vol, exists := drv.volumes[name]; exists {
-		return vol.CSIVolume
+// volumeNameFromDescription returns CSI volume name from the RSD volume Description
+// It returns false if volume wasn't created by the driver in the cluster.
+// Sanitized names are returned as they are unless their CSI names are known.
+func (drv *Driver) volumeNameFromDescription(description string) (string, bool) {
+	parts := strings.SplitN(description, descriptionSeparator, 3)
+	if len(parts) != 3 || parts[0] != DriverName || parts[1] != drv.ClusterID || parts[2] == "" {
+		return "", false
 	}
-	return nil
+	return drv.names.csiName(parts[2]), true
 }
 
-func (drv *Driver) findVolByID(volumeID string) (string, *Volume) {
-	for name, vol := range drv.volumes {
-		if vol.CSIVolume.VolumeId == volumeID {
-			return name, vol
-		}
+// newVolumeRecord creates internal driver record for the RSD volume
+func newVolumeRecord(name string, rsdVolume *rsd.Volume) *Volume {
+	return &Volume{
+		Name: name,
+		CSIVolume: &csi.Volume{
+			VolumeId:      rsdVolume.ID,
+			VolumeContext: map[string]string{"name": name},
+			CapacityBytes: rsdVolume.CapacityBytes,
+		},
+		RSDVolume:     rsdVolume,
+		RSDNodeID:     "",
+		TargetPaths:   make(map[string]bool),
+		RequiredBytes: rsdVolume.CapacityBytes,
 	}
-	return "", nil
 }
 
-// deleteVolume deletes RSD volume using RSD API
-// and removes volume from the internal map drv.volumes
-// It does nothing if volume doesn't exist
-func (drv *Driver) deleteVolume(volumeID string) error {
+// copy returns a copy of the volume record to be published or unpublished
+// without holding drv.volumesRWL. The CSI volume is copied, as publishing
+// refreshes its capacity, the other shared fields are not modified.
+func (volume *Volume) copy() *Volume {
+	result := *volume
+	result.CSIVolume = proto.Clone(volume.CSIVolume).(*csi.Volume)
+	return &result
+}
+
+// storePublished updates the volume record with the published state of
+// its copy published or unpublished without holding drv.volumesRWL
+func (drv *Driver) storePublished(volume, published *Volume) {
 	drv.volumesRWL.Lock()
 	defer drv.volumesRWL.Unlock()
+	volume.RSDVolume = published.RSDVolume
+	volume.CSIVolume.CapacityBytes = published.CSIVolume.CapacityBytes
+	volume.EndPoint = published.EndPoint
+	volume.AltEndPoints = published.AltEndPoints
+	volume.RSDNodeNQN = published.RSDNodeNQN
+	volume.RSDNodeID = published.RSDNodeID
+	volume.IsPublished = published.IsPublished
+	volume.rsdClient = published.rsdClient
+}
+
+// forEachRSDVolume calls fn with each volume of all RSD storage services,
+// as StorageClasses may create volumes in other than the default one.
+// Volume collections are read page by page, so they're never loaded at once.
+// The volumes of the RSD endpoint of the context are listed.
+func (drv *Driver) forEachRSDVolume(ctx context.Context, fn func(*rsd.Volume) error) error {
+	client := drv.client(ctx)
+	services, err := drv.listStorageServices(ctx)
+	if err != nil {
+		return err
+	}
 
-	name, vol := drv.findVolByID(volumeID)
-	if name != "" {
-		// delete RSD volume
-		err := vol.RSDVolume.Delete(drv.rsdClient)
+	for _, service := range services {
+		volCollection, err := service.GetVolumeCollection(ctx, client)
 		if err != nil {
-			return fmt.Errorf("can't delete RSD Volume %s: %v", vol.RSDVolume.ID, err)
+			return err
 		}
+		if err := volCollection.ForEachMember(ctx, client, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// delete volume from the map
-		delete(drv.volumes, name)
+// adoptVolumes adds volumes and snapshots previously created by the driver in the
+// cluster to the volumes and snapshots maps, so they are not lost when the driver is restarted.
+// Volumes of all racks are adopted, snapshots only of the default RSD endpoint.
+func (drv *Driver) adoptVolumes() error {
+	var conflicts []string
+	var listErr error
+	for _, ctx := range drv.allRackContexts(context.Background()) {
+		rack := rackOf(ctx)
+		err := drv.forEachRSDVolume(ctx, func(rsdVolume *rsd.Volume) error {
+			drv.volumesRWL.Lock()
+			defer drv.volumesRWL.Unlock()
+
+			if name, ok := drv.snapshotNameFromDescription(rsdVolume.Description); ok {
+				if rack == "" {
+					drv.adoptSnapshot(name, rsdVolume)
+				}
+				return nil
+			}
+			name, ok := drv.volumeNameFromDescription(rsdVolume.Description)
+			if !ok || !strings.HasPrefix(name, drv.VolumeNamePrefix) {
+				return nil
+			}
+			existing, exists := drv.volumes[name]
+			if !exists {
+				volume := newVolumeRecord(name, rsdVolume)
+				volume.setRSDEndpoint(ctx)
+				drv.volumes[name] = volume
+				drv.allocations.adopted(volume)
+				drv.logger.V(LogLevelState).Info("adopted RSD volume", "rsd_volume", rsdVolume.ID, "volume", name, "rack", rack)
+				return nil
+			}
+			if existing.Rack != rack {
+				conflicts = append(conflicts, fmt.Sprintf("%s: RSD volume %s in %s conflicts with %s in %s", name, rsdVolume.OdataID, describeRack(rack), existing.RSDVolume.OdataID, describeRack(existing.Rack)))
+				drv.logger.Warning("RSD volumes of different racks are tagged with the same volume name, using the first one", "volume", name, "rack", existing.Rack, "conflicting_rack", rack)
+			} else if existing.RSDVolume.OdataID != rsdVolume.OdataID {
+				conflicts = append(conflicts, drv.resolveConflict(name, existing, rsdVolume))
+			}
+			return nil
+		})
+		// volumes of the other racks are adopted even if a rack is unreachable
+		if err != nil && listErr == nil {
+			listErr = err
+			if len(drv.racks) > 0 {
+				listErr = fmt.Errorf("%s: %v", describeRack(rack), err)
+			}
+		}
+	}
+	if listErr != nil {
+		return listErr
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("RSD volumes tagged with the names of other volumes are not adopted: %s", strings.Join(conflicts, "; "))
 	}
 	return nil
 }
 
-func findTransportDetails(endPoint *rsd.EndPoint) *endPointInfo {
-	for _, ipTransportDetail := range endPoint.IPTransportDetails {
-		proto := strings.ToUpper(ipTransportDetail.TransportProtocol)
-		if proto != "ROCE" && proto != "ROCEV2" {
-			continue
+// resolveConflict decides which of the RSD volumes tagged with the same CSI name
+// the driver manages: the volume published or staged by the driver, then the volume
+// tagged by the driver for this name, then the volume known first. The other one is
+// recorded in the Conflicts of the record and is never published nor deleted.
+// It returns description of the conflict.
+func (drv *Driver) resolveConflict(name string, existing *Volume, rsdVolume *rsd.Volume) string {
+	description := drv.volumeDescription(name)
+	replace := !existing.IsPublished && !existing.IsStaged &&
+		existing.RSDVolume.Description != description && rsdVolume.Description == description
+
+	rejected := rsdVolume
+	if replace {
+		rejected = existing.RSDVolume
+		record := newVolumeRecord(name, rsdVolume)
+		record.setRSDEndpoint(withVolumeRSDEndpoint(context.Background(), existing))
+		record.Conflicts = existing.Conflicts
+		existing = record
+		drv.volumes[name] = existing
+	}
+	if !containsString(existing.Conflicts, rejected.OdataID) {
+		existing.Conflicts = append(existing.Conflicts, rejected.OdataID)
+		drv.logger.Warning("RSD volumes are tagged with the same volume name, using the first one", "volume", name, "rsd_volume", existing.RSDVolume.ID, "conflicting_rsd_volume", rejected.ID)
+	}
+	return fmt.Sprintf("%s: RSD volume %s conflicts with %s", name, rejected.OdataID, existing.RSDVolume.OdataID)
+}
+
+// containsString checks if the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
-		if ipTransportDetail.IPv4Address.Address != "" {
-			return &endPointInfo{
-				ipAddress:         ipTransportDetail.IPv4Address.Address,
-				ipAddressFamily:   "IPv4",
-				ipPort:            ipTransportDetail.Port,
-				transportProtocol: "rdma",
+	}
+	return false
+}
+
+// Creates new volume of the capacity rounded up to its allocation unit with the
+// provisioning properties, RSD defaults if provisioning is nil, and returns its
+// record to be added to the Volumes map. It must be called with the creation
+// lock of the volume name held, drv.volumesRWL is not held meanwhile.
+func (drv *Driver) newVolume(ctx context.Context, name string, capacity volumeCapacity, volumeContext map[string]string, provisioning *volumeProvisioning) (*Volume, error) {
+	ctx, err := drv.creationRack(ctx, name, provisioning)
+	if err != nil {
+		return nil, err
+	}
+
+	// Volume doesn't exist - take spare one or create new one.
+	// Spare volumes are created with the RSD defaults of the default RSD endpoint only.
+	var rsdVolume *rsd.Volume
+	if !drv.creationPending(name) && provisioning.isDefault() && rsdEndpointOf(ctx) == "" && rackOf(ctx) == "" {
+		rsdVolume = drv.claimSpareVolume(ctx, name, capacity.required)
+	}
+	if rsdVolume == nil {
+		rsdVolume, err = drv.createRSDVolume(ctx, name, func() (*rsd.Volume, error) {
+			// Get volume collection of the storage service the volume is placed in
+			client := drv.client(ctx)
+			service, err := drv.selectStorageService(ctx, provisioning, capacity.required)
+			if err != nil {
+				return nil, err
 			}
-		}
-		if ipTransportDetail.IPv6Address.Address != "" {
-			return &endPointInfo{
-				ipAddress:         ipTransportDetail.IPv6Address.Address,
-				ipAddressFamily:   "IPv6",
-				ipPort:            ipTransportDetail.Port,
-				transportProtocol: "rdma",
+			allocated, err := drv.allocatedCapacity(ctx, service, capacity, provisioning)
+			if err != nil {
+				return nil, err
+			}
+			volCollection, err := service.GetVolumeCollection(ctx, client)
+			if err != nil {
+				return nil, err
 			}
+
+			// Create new RSD volume
+			return volCollection.NewVolume(ctx, client, provisioning.newVolumeRequest(allocated, drv.volumeDescription(name)))
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
-	return nil
+
+	volume := newVolumeRecord(name, rsdVolume)
+	volume.setRSDEndpoint(ctx)
+	volume.RequiredBytes = capacity.required
+	for key, value := range volumeContext {
+		volume.CSIVolume.VolumeContext[key] = value
+	}
+
+	return volume, nil
 }
 
-func findEndPointInfo(endPoints []*rsd.EndPoint) *endPointInfo {
-	for _, endPoint := range endPoints {
-		epi := findTransportDetails(endPoint)
-		if epi != nil {
-			epi.nqn = endPoint.GetNQN()
-			return epi
+func (drv *Driver) findVolByID(volumeID string) (string, *Volume) {
+	for name, vol := range drv.volumes {
+		if vol.CSIVolume.VolumeId == volumeID {
+			return name, vol
 		}
 	}
-	return nil
+	return "", nil
 }
 
-// getVolumeEndPointInfo gets RSD EndPoint and validates it
-func (drv *Driver) getVolumeEndPointInfo(volume *Volume) (*endPointInfo, error) {
+// getVolumeEndPointInfo gets RSD EndPoints and returns their suitable portals
+func (drv *Driver) getVolumeEndPointInfo(ctx context.Context, volume *Volume) ([]*endpoint.Portal, error) {
 	// Get Entry Point associated with this RSD volume
-	endPoints, err := volume.RSDVolume.GetEndPoints(drv.rsdClient)
+	endPoints, err := volume.RSDVolume.GetEndPoints(ctx, drv.client(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -298,16 +738,22 @@ func (drv *Driver) getVolumeEndPointInfo(volume *Volume) (*endPointInfo, error)
 		return nil, fmt.Errorf("no RSD Endpoints found for the volume %s", volume.Name)
 	}
 
-	epi := findEndPointInfo(endPoints)
-	if epi == nil {
+	epis := endpoint.Find(endPoints)
+	if len(epis) == 0 {
 		return nil, fmt.Errorf("no suitable RSD endpoints found for the volume %s", volume.Name)
 	}
-	return epi, nil
+	if len(drv.transportPreference) > 0 {
+		epis = endpoint.Prefer(epis, drv.transportPreference)
+		if len(epis) == 0 {
+			return nil, fmt.Errorf("no RSD endpoints of the preferred transports %v found for the volume %s", drv.transportPreference, volume.Name)
+		}
+	}
+	return epis, nil
 }
 
 // getComputerSystemNQN gets NQN of the Computer System
-func (drv *Driver) getComputerSystemNQN(computerSystem *rsd.ComputerSystem) (string, error) {
-	endPoints, err := computerSystem.GetEndPoints(drv.rsdClient)
+func (drv *Driver) getComputerSystemNQN(ctx context.Context, computerSystem *rsd.ComputerSystem) (string, error) {
+	endPoints, err := computerSystem.GetEndPoints(ctx, drv.client(ctx))
 	if err != nil {
 		return "", err
 	}
@@ -326,65 +772,150 @@ func (drv *Driver) getComputerSystemNQN(computerSystem *rsd.ComputerSystem) (str
 	return "", fmt.Errorf("no NQN found for the computer system %s", computerSystem.Name)
 }
 
-// publishVolume publishes volume on the node
-func (drv *Driver) publishVolume(volume *Volume, RSDNodeID string) error {
+// publishVolume publishes volume on the node. Attach options may be nil.
+// Waiting for the node stops when the context is done.
+func (drv *Driver) publishVolume(ctx context.Context, volume *Volume, RSDNodeID string, opts *rsd.AttachOptions) error {
 	if volume.IsPublished {
 		return nil
 	}
-	node, err := rsd.GetNode(drv.rsdClient, RSDNodeID)
-	if err != nil {
-		return err
-	}
 
-	// Attach RSD volume to the node
-	err = node.AttachResource(drv.rsdClient, volume.RSDVolume.OdataID)
+	var nqn string
+	var err error
+	if drv.fabricDirect {
+		// node ID is the host NQN supplied by the node plugin
+		nqn = RSDNodeID
+		if opts != nil {
+			drv.logger.Warning("attach options are not applied in fabric-direct mode", "volume", volume.Name)
+		}
+		err = drv.attachFabricDirect(ctx, volume, nqn)
+	} else {
+		nqn, err = drv.attachToNode(ctx, volume, RSDNodeID, opts)
+	}
 	if err != nil {
 		return err
 	}
 
 	// Read volume info again as volume endpoint appears only after attachment
-	volume.RSDVolume, err = rsd.GetVolume(drv.rsdClient, 0, volume.RSDVolume.ID)
+	endPoints, err := drv.waitVolumeEndPoints(ctx, volume)
 	if err != nil {
 		return err
 	}
+	drv.selectEndPoints(ctx, volume, endPoints)
 
-	// Get endpoint associated with this RSD volume
-	volume.EndPoint, err = drv.getVolumeEndPointInfo(volume)
-	if err != nil {
-		return err
+	volume.RSDNodeNQN = nqn
+	volume.RSDNodeID = RSDNodeID
+	volume.IsPublished = true
+
+	return nil
+}
+
+// waitVolumeEndPoints reads the volume again after attaching it until its
+// endpoints appear, some PODMs link them a few seconds after the attachment.
+// The volume is polled according to the EndpointWait policy until the
+// context is done, the error tells the endpoint links RSD reported last.
+func (drv *Driver) waitVolumeEndPoints(ctx context.Context, volume *Volume) ([]*endpoint.Portal, error) {
+	wait := drv.policies.EndpointWait
+	for attempt := 0; ; attempt++ {
+		rsdVolume, err := rsd.GetVolumeByPath(ctx, drv.client(ctx), volume.RSDVolume.OdataID)
+		if err != nil {
+			return nil, err
+		}
+		volume.RSDVolume = rsdVolume
+		drv.refreshCapacity(volume)
+
+		endPoints, err := drv.getVolumeEndPointInfo(ctx, volume)
+		if err == nil {
+			return endPoints, nil
+		}
+		if attempt+1 >= wait.Attempts {
+			return nil, fmt.Errorf("%v after %d attempts, RSD reported %s", err, attempt+1, describeEndPointLinks(rsdVolume))
+		}
+
+		delay := wait.DelayAfter(attempt)
+		drv.logger.V(LogLevelState).Info("waiting for RSD endpoints of the attached volume", "volume", volume.Name, "rsd_volume", rsdVolume.ID, "delay", delay, "reason", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("stopped waiting for RSD endpoints of the volume %s: %v, last error: %v, RSD reported %s", volume.Name, ctx.Err(), err, describeEndPointLinks(rsdVolume))
+		}
 	}
+}
 
-	// Get Computer System associated with the node
-	var computerSystem rsd.ComputerSystem
-	err = rsd.GetByOdataID(drv.rsdClient, node.Links.ComputerSystem.OdataID, &computerSystem)
-	if err != nil {
-		return err
+// describeEndPointLinks returns the endpoint links of the RSD volume for
+// the error messages
+func describeEndPointLinks(rsdVolume *rsd.Volume) string {
+	var links []string
+	for _, link := range rsdVolume.Links.Oem.IntelRackScale.Endpoints {
+		links = append(links, link.OdataID)
+	}
+	if len(links) == 0 {
+		return "no endpoint links"
 	}
+	return "endpoint links " + strings.Join(links, ", ")
+}
 
-	// Get NQN of this Computer System
-	volume.RSDNodeNQN, err = drv.getComputerSystemNQN(&computerSystem)
+// SetPowerOnNodes makes the controller power on the RSD composed node, if it's
+// powered off, and wait until it's attachable before attaching volumes to it
+func (drv *Driver) SetPowerOnNodes(enabled bool) {
+	drv.powerOnNodes = enabled
+}
+
+// attachToNode attaches volume to the RSD node and returns NQN of the node.
+// Waiting for the node stops when the context is done.
+func (drv *Driver) attachToNode(ctx context.Context, volume *Volume, RSDNodeID string, opts *rsd.AttachOptions) (string, error) {
+	node, err := drv.getNode(ctx, RSDNodeID)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	volume.RSDNodeID = RSDNodeID
-	volume.IsPublished = true
-
-	return nil
-}
+	client := drv.client(ctx)
+	if drv.powerOnNodes {
+		if err := node.PowerOn(ctx, client); err != nil {
+			drv.invalidateNode(RSDNodeID, err)
+			return "", err
+		}
+	}
 
-// unpublishVolume unpublishes volume from the node
-func (drv *Driver) unpublishVolume(volume *Volume, RSDNodeID string) error {
-	if !volume.IsPublished {
-		return nil
+	// The volume is already attached if the driver restarted before
+	// recording the attachment, RSD rejects attaching it again
+	attached, err := node.IsAttached(ctx, client, volume.RSDVolume.OdataID)
+	if err != nil {
+		drv.invalidateNode(RSDNodeID, err)
+		return "", err
+	}
+	if attached {
+		drv.logger.V(LogLevelState).Info("volume is already attached to the RSD node", "volume", volume.Name, "rsd_node", RSDNodeID)
+		return drv.getNodeNQN(ctx, RSDNodeID, node)
 	}
-	node, err := rsd.GetNode(drv.rsdClient, RSDNodeID)
+
+	// Attach RSD volume to the node
+	ignored, err := node.AttachResourceWithOptions(ctx, client, volume.RSDVolume.OdataID, opts)
 	if err != nil {
-		return err
+		// the node may be recomposed or gone, query it again next time
+		drv.invalidateNode(RSDNodeID, err)
+		return "", err
+	}
+	if len(ignored) > 0 {
+		drv.logger.Warning("RSD node doesn't support attach parameters, volume is attached without them", "rsd_node", RSDNodeID, "parameters", ignored, "volume", volume.Name)
 	}
 
-	// Detach RSD volume from the node
-	err = node.DetachResource(drv.rsdClient, volume.RSDVolume.OdataID)
+	// Get NQN of the node computer system
+	return drv.getNodeNQN(ctx, RSDNodeID, node)
+}
+
+// unpublishVolume unpublishes volume from the node. The attachment is
+// verified against RSD even if the volume is not published according to
+// the driver records, so attachments left by lost records are released too.
+// Waiting for the node stops when the context is done.
+func (drv *Driver) unpublishVolume(ctx context.Context, volume *Volume, RSDNodeID string) error {
+	var err error
+	if drv.fabricDirect {
+		err = drv.detachFabricDirect(ctx, volume, RSDNodeID)
+	} else {
+		err = drv.detachFromNode(ctx, volume, RSDNodeID)
+	}
 	if err != nil {
 		return err
 	}
@@ -396,51 +927,136 @@ func (drv *Driver) unpublishVolume(volume *Volume, RSDNodeID string) error {
 	return nil
 }
 
-// getCapacity gets total capacity of all available RSD storage pools
-func (drv *Driver) getCapacity() (int64, error) {
-	var result int64
+// detachFromNode detaches volume from the RSD node if it's attached
+func (drv *Driver) detachFromNode(ctx context.Context, volume *Volume, RSDNodeID string) error {
+	node, err := drv.getNode(ctx, RSDNodeID)
+	if err != nil {
+		return err
+	}
 
-	client := drv.rsdClient
-	poolCollection, err := rsd.GetStoragePoolCollection(client, 0)
+	client := drv.client(ctx)
+	attached, err := node.IsAttached(ctx, client, volume.RSDVolume.OdataID)
 	if err != nil {
-		return result, err
+		drv.invalidateNode(RSDNodeID, err)
+		return err
+	}
+	if !attached {
+		return nil
+	}
+	if !volume.IsPublished {
+		drv.logger.V(LogLevelState).Info("volume is attached to the RSD node, but it's not published, detaching it", "volume", volume.Name, "rsd_node", RSDNodeID)
 	}
 
-	pools, err := poolCollection.GetMembers(client)
+	// Detach RSD volume from the node
+	err = node.DetachResource(ctx, client, volume.RSDVolume.OdataID)
 	if err != nil {
-		return result, err
+		drv.invalidateNode(RSDNodeID, err)
 	}
+	return err
+}
 
-	for _, pool := range pools {
-		result += pool.Capacity.Data.GuaranteedBytes
+// getCapacity gets total available capacity of the storage services of
+// all racks the volumes with the provisioning parameters may be created in
+func (drv *Driver) getCapacity(ctx context.Context, provisioning *volumeProvisioning) (int64, error) {
+	contexts, err := drv.rackContexts(ctx, provisioning)
+	if err != nil {
+		return 0, err
+	}
+
+	var result int64
+	for _, rackCtx := range contexts {
+		services, err := drv.candidateStorageServices(rackCtx, provisioning)
+		if err != nil {
+			return 0, err
+		}
+		for _, service := range services {
+			available, err := drv.availableCapacity(rackCtx, service)
+			if err != nil {
+				return 0, err
+			}
+			result += available
+		}
 	}
 
 	return result, nil
 }
 
-// nodeStageVolume connects the volume to the node using nvme connect and mounts it to the Target Staging path
-func (drv *Driver) nodeStageVolume(volume *Volume, fsType, stagingTargetPath string, mountOpts []string) error {
+// fsLabelMaxLen returns maximum length of the filesystem label
+func fsLabelMaxLen(fsType string) int {
+	switch fsType {
+	case "xfs":
+		return 12
+	default:
+		// ext2, ext3 and ext4
+		return 16
+	}
+}
+
+// volumeFSLabel returns deterministic filesystem label of the volume, e.g. rsd-1.
+// IDs too long for the filesystem label are replaced with their hash.
+func volumeFSLabel(volumeID, fsType string) string {
+	label := fsLabelPrefix + volumeID
+	maxLen := fsLabelMaxLen(fsType)
+	if len(label) > maxLen {
+		sum := sha256.Sum256([]byte(volumeID))
+		label = fsLabelPrefix + hex.EncodeToString(sum[:])[:maxLen-len(fsLabelPrefix)]
+	}
+	return label
+}
+
+// mountSource returns the source to mount the volume from. Label or UUID
+// of the filesystem is preferred to the device, as NVMe device names
+// can change when devices are reconnected.
+func (drv *Driver) mountSource(volume *Volume, dev, label string) (string, error) {
+	fsLabel, uuid, err := drv.mounter.GetFilesystemIDs(dev)
+	if err != nil {
+		return "", err
+	}
+	volume.FSUUID = uuid
+	switch {
+	case fsLabel != "" && fsLabel == label:
+		volume.FSLabel = fsLabel
+		return "LABEL=" + fsLabel, nil
+	case uuid != "":
+		return "UUID=" + uuid, nil
+	default:
+		return dev, nil
+	}
+}
+
+// nodeStageVolume connects the volume to the node using nvme connect and mounts it to the Target Staging path.
+// The volume is formatted with the mkfs options of the volume context, its portals are overridden by the portal
+// parameters of the volume context and a filesystem of another type than fsType is handled according to its
+// reformat policy.
+func (drv *Driver) nodeStageVolume(ctx context.Context, volume *Volume, fsType string, volumeContext map[string]string, stagingTargetPath string, mountOpts []string, secrets stageSecrets) error {
 	if !volume.IsPublished {
 		return fmt.Errorf("nodeStageVolume: volume %s is not published", volume.Name)
 	}
 
 	if volume.IsStaged {
-		return nil
+		reason := drv.staleStaging(volume)
+		if reason == "" {
+			return nil
+		}
+		if err := drv.resetStaleStaging(volume, reason); err != nil {
+			return err
+		}
 	}
 
-	ep := volume.EndPoint
-	if ep == nil {
+	if volume.EndPoint == nil {
 		return fmt.Errorf("nodeStageVolume: no endpoint found for volume %s", volume.Name)
 	}
 
-	dev, err := drv.nvme.Connect(
-		ep.transportProtocol,
-		ep.ipAddress,
-		ep.ipAddressFamily,
-		strconv.Itoa(ep.ipPort),
-		ep.nqn,
-		volume.RSDNodeNQN)
+	mkfsOpts, err := mkfsArgs(fsType, volumeContext[MkfsOptionsParameter])
+	if err != nil {
+		return err
+	}
+	override, err := parsePortalOverride(volumeContext)
+	if err != nil {
+		return err
+	}
 
+	dev, err := drv.connectVolume(ctx, volume, override, secrets)
 	if err != nil {
 		return err
 	}
@@ -450,10 +1066,22 @@ func (drv *Driver) nodeStageVolume(volume *Volume, fsType, stagingTargetPath str
 		return err
 	}
 
-	if !formatted {
-		if err := drv.mounter.Format(dev, fsType); err != nil {
+	label := volumeFSLabel(volume.CSIVolume.VolumeId, fsType)
+	if !formatted || drv.formatIncomplete(volume) {
+		// make sure no other host uses the volume before formatting it
+		if err := drv.checkFencing(ctx, volume); err != nil {
 			return err
 		}
+		if err := drv.formatVolume(volume, dev, fsType, label, mkfsOpts); err != nil {
+			return err
+		}
+	} else if fsType, err = drv.stagedFilesystem(ctx, volume, volumeContext[ReformatPolicyParameter], dev, fsType, label, mkfsOpts, stagingTargetPath); err != nil {
+		return err
+	}
+
+	source, err := drv.mountSource(volume, dev, label)
+	if err != nil {
+		return err
 	}
 
 	mounted, err := drv.mounter.IsMounted(dev, stagingTargetPath)
@@ -462,13 +1090,14 @@ func (drv *Driver) nodeStageVolume(volume *Volume, fsType, stagingTargetPath str
 	}
 
 	if !mounted {
-		err = drv.mounter.Mount(dev, stagingTargetPath, fsType, mountOpts...)
+		err = drv.mounter.Mount(source, stagingTargetPath, fsType, mountOpts...)
 		if err != nil {
 			return err
 		}
 	}
 
 	volume.Device = dev
+	volume.DeviceByID = resolveDeviceByID(drv.hostRoot, dev)
 	volume.IsStaged = true
 	volume.StagingTargetPath = stagingTargetPath
 
@@ -496,7 +1125,14 @@ func (drv *Driver) nodeUnstageVolume(volume *Volume, stagingTargetPath string) e
 		return err
 	}
 
+	if err := drv.mounter.RemoveTarget(stagingTargetPath); err != nil {
+		drv.logger.Warning("staging target path is kept", "volume", volume.Name, "error", err)
+	}
+
 	volume.Device = ""
+	volume.DeviceByID = ""
+	volume.FSUUID = ""
+	volume.IsBlock = false
 	volume.IsStaged = false
 	volume.StagingTargetPath = ""
 
@@ -537,5 +1173,9 @@ func (drv *Driver) nodeUnpublishVolume(volume *Volume, targetPath string) error
 
 	delete(volume.TargetPaths, targetPath)
 
-	return err
+	if err := drv.mounter.RemoveTarget(targetPath); err != nil {
+		drv.logger.Warning("target path is kept", "volume", volume.Name, "error", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,332 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestVolumeDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterID   string
+		description string
+		wantName    string
+		wantOK      bool
+	}{
+		{
+			name:        "own volume",
+			clusterID:   "cluster1",
+			description: "csi.rsd.intel.com:cluster1:pvc-1",
+			wantName:    "pvc-1",
+			wantOK:      true,
+		},
+		{
+			name:        "own volume, empty cluster ID",
+			description: "csi.rsd.intel.com::pvc-1",
+			wantName:    "pvc-1",
+			wantOK:      true,
+		},
+		{
+			name:        "volume of another cluster",
+			clusterID:   "cluster1",
+			description: "csi.rsd.intel.com:cluster2:pvc-1",
+		},
+		{
+			name:        "volume created out of band",
+			clusterID:   "cluster1",
+			description: "Volume for the database",
+		},
+		{
+			name:        "empty volume name",
+			description: "csi.rsd.intel.com::",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{ClusterID: tt.clusterID}
+			name, ok := drv.volumeNameFromDescription(tt.description)
+			if name != tt.wantName || ok != tt.wantOK {
+				t.Errorf("volumeNameFromDescription(%q) = %q, %v, want %q, %v", tt.description, name, ok, tt.wantName, tt.wantOK)
+			}
+			if ok {
+				if description := drv.volumeDescription(name); description != tt.description {
+					t.Errorf("volumeDescription(%q) = %q, want %q", name, description, tt.description)
+				}
+			}
+		})
+	}
+}
+
+func TestVolumeFSLabel(t *testing.T) {
+	tests := []struct {
+		volumeID string
+		fsType   string
+		want     string
+	}{
+		{volumeID: "1", fsType: "ext4", want: "rsd-1"},
+		{volumeID: "1", fsType: "xfs", want: "rsd-1"},
+		{volumeID: "0123456789ab", fsType: "ext4", want: "rsd-0123456789ab"},
+		{volumeID: "0123456789ab", fsType: "xfs", want: "rsd-d407ad90"},
+	}
+	for _, tt := range tests {
+		if got := volumeFSLabel(tt.volumeID, tt.fsType); got != tt.want {
+			t.Errorf("volumeFSLabel(%q, %q) = %q, want %q", tt.volumeID, tt.fsType, got, tt.want)
+		}
+		if got := volumeFSLabel(tt.volumeID, tt.fsType); len(got) > fsLabelMaxLen(tt.fsType) {
+			t.Errorf("volumeFSLabel(%q, %q) = %q is too long", tt.volumeID, tt.fsType, got)
+		}
+	}
+}
+
+func TestAdoptVolumes(t *testing.T) {
+	drv := &Driver{
+		ClusterID:        "cluster1",
+		VolumeNamePrefix: "pvc-",
+		rsdClient: &TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1": `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+				"/redfish/v1/StorageServices/1/Volumes": `{"Members": [
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"},
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"},
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/3"},
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/4"}
+				]}`,
+				"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100, "Description": "csi.rsd.intel.com:cluster1:pvc-1"}`,
+				"/redfish/v1/StorageServices/1/Volumes/2": `{"Id": "2", "CapacityBytes": 100, "Description": "csi.rsd.intel.com:cluster2:pvc-2"}`,
+				"/redfish/v1/StorageServices/1/Volumes/3": `{"Id": "3", "CapacityBytes": 100, "Description": "csi.rsd.intel.com:cluster1:sanity-3"}`,
+				"/redfish/v1/StorageServices/1/Volumes/4": `{"Id": "4", "CapacityBytes": 100}`,
+			},
+		},
+		volumes: map[string]*Volume{},
+	}
+
+	if err := drv.adoptVolumes(); err != nil {
+		t.Fatalf("adoptVolumes() unexpected error: %v", err)
+	}
+
+	var names []string
+	for name := range drv.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"pvc-1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("adopted volumes = %v, want %v", names, want)
+	}
+
+	if vol := drv.volumes["pvc-1"]; vol.CSIVolume.VolumeId != "1" || vol.CSIVolume.CapacityBytes != 100 {
+		t.Errorf("unexpected adopted volume: %v", vol.CSIVolume)
+	}
+}
+
+func TestAdoptVolumesConflicts(t *testing.T) {
+	const (
+		volume1 = "/redfish/v1/StorageServices/1/Volumes/1"
+		volume2 = "/redfish/v1/StorageServices/1/Volumes/2"
+	)
+	results := map[string]string{
+		"/redfish/v1/StorageServices":           `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+		"/redfish/v1/StorageServices/1":         `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+		"/redfish/v1/StorageServices/1/Volumes": `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}`,
+		volume2:                                 `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2", "Id": "2", "Description": "csi.rsd.intel.com:cluster1:pvc-1"}`,
+	}
+	existingVolume := func(description string, published bool) *Volume {
+		vol := newVolumeRecord("pvc-1", &rsd.Volume{OdataID: volume1, ID: "1", Description: description})
+		vol.IsPublished = published
+		return vol
+	}
+
+	tests := []struct {
+		name          string
+		volumes       map[string]*Volume
+		members       string
+		wantVolume    string
+		wantConflicts []string
+	}{
+		{
+			name:          "restart with two tagged volumes",
+			volumes:       map[string]*Volume{},
+			members:       `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}, {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}`,
+			wantVolume:    volume1,
+			wantConflicts: []string{volume2},
+		},
+		{
+			name:          "driver-created volume is kept",
+			volumes:       map[string]*Volume{"pvc-1": existingVolume("csi.rsd.intel.com:cluster1:pvc-1", false)},
+			wantVolume:    volume1,
+			wantConflicts: []string{volume2},
+		},
+		{
+			name:          "tagged volume replaces retagged one",
+			volumes:       map[string]*Volume{"pvc-1": existingVolume("out of band", false)},
+			wantVolume:    volume2,
+			wantConflicts: []string{volume1},
+		},
+		{
+			name:          "published volume is kept",
+			volumes:       map[string]*Volume{"pvc-1": existingVolume("out of band", true)},
+			wantVolume:    volume1,
+			wantConflicts: []string{volume2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &TestClient{results: map[string]string{
+				volume1: `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "Description": "csi.rsd.intel.com:cluster1:pvc-1"}`,
+			}}
+			for url, result := range results {
+				client.results[url] = result
+			}
+			if tt.members != "" {
+				client.results["/redfish/v1/StorageServices/1/Volumes"] = tt.members
+			}
+			drv := &Driver{
+				ClusterID:        "cluster1",
+				VolumeNamePrefix: "pvc-",
+				rsdClient:        client,
+				volumes:          tt.volumes,
+			}
+
+			if err := drv.adoptVolumes(); err == nil {
+				t.Error("adoptVolumes() unexpectedly reported no conflicts")
+			}
+			vol := drv.volumes["pvc-1"]
+			if vol == nil || vol.RSDVolume.OdataID != tt.wantVolume {
+				t.Fatalf("pvc-1 is %v, want RSD volume %s", vol, tt.wantVolume)
+			}
+			if !reflect.DeepEqual(vol.Conflicts, tt.wantConflicts) {
+				t.Errorf("conflicts = %v, want %v", vol.Conflicts, tt.wantConflicts)
+			}
+
+			// adopting again reports the conflicts without changing the records
+			drv.adoptVolumes() // nolint: errcheck
+			if vol := drv.volumes["pvc-1"]; vol.RSDVolume.OdataID != tt.wantVolume || !reflect.DeepEqual(vol.Conflicts, tt.wantConflicts) {
+				t.Errorf("after adopting again pvc-1 is %s with conflicts %v", vol.RSDVolume.OdataID, vol.Conflicts)
+			}
+		})
+	}
+}
+
+// closingClient is a TestClient recording Close calls
+type closingClient struct {
+	TestClient
+	closed bool
+}
+
+func (client *closingClient) Close() error {
+	client.closed = true
+	return nil
+}
+
+func TestStopClosesRSDClient(t *testing.T) {
+	client := &closingClient{}
+	drv := &Driver{rsdClient: client}
+	drv.Stop()
+	if !client.closed {
+		t.Error("Stop() didn't close RSD client")
+	}
+	if drv.ready {
+		t.Error("driver is ready after Stop()")
+	}
+}
+
+// linkingClient links the endpoint to the RSD volume only after the volume
+// is read the given number of times, like PODMs linking it asynchronously
+type linkingClient struct {
+	*TestClient
+	linkAfter int
+	reads     int
+}
+
+func (client *linkingClient) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	if entrypoint == "/redfish/v1/StorageServices/1/Volumes/1" {
+		client.reads++
+		if client.reads < client.linkAfter {
+			entrypoint = "/redfish/v1/StorageServices/1/Volumes/1/unlinked"
+		}
+	}
+	return client.TestClient.Get(ctx, entrypoint, result)
+}
+
+func TestWaitVolumeEndPoints(t *testing.T) {
+	newClient := func(linkAfter int) *linkingClient {
+		return &linkingClient{linkAfter: linkAfter, TestClient: &TestClient{results: map[string]string{
+			"/redfish/v1/StorageServices/1/Volumes/1/unlinked": `{"Id": "1", "@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "CapacityBytes": 100}`,
+			"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "CapacityBytes": 100,
+				"Links": {"Oem": {"Intel_RackScale": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/nqn.1"}]}}}}`,
+			"/redfish/v1/Fabrics/1/Endpoints/nqn.1": `{"Id": "nqn.1",
+				"IPTransportDetails": [{"IPv4Address": {"Address": "192.168.1.1"}, "Port": 4420, "TransportProtocol": "RoCEv2"}],
+				"Identifiers": [{"DurableName": "nqn.1", "DurableNameFormat": "NQN"}]}`,
+		}}}
+	}
+	newVolume := func() *Volume {
+		return newVolumeRecord("pvc-1", &rsd.Volume{ID: "1", OdataID: "/redfish/v1/StorageServices/1/Volumes/1"})
+	}
+	wait := policy.Retry{Attempts: 5, Delay: time.Millisecond}
+
+	// endpoints linked by the third read
+	client := newClient(3)
+	drv := &Driver{rsdClient: client, policies: policy.Policies{EndpointWait: wait}}
+	portals, err := drv.waitVolumeEndPoints(context.Background(), newVolume())
+	if err != nil {
+		t.Fatalf("waitVolumeEndPoints() failed: %v", err)
+	}
+	if len(portals) != 1 || portals[0].NQN != "nqn.1" || client.reads != 3 {
+		t.Errorf("waitVolumeEndPoints() = %v after %d reads, want portal of nqn.1 after 3 reads", portals, client.reads)
+	}
+
+	// endpoints never linked
+	drv.rsdClient = newClient(10)
+	_, err = drv.waitVolumeEndPoints(context.Background(), newVolume())
+	if err == nil || !strings.Contains(err.Error(), "after 5 attempts") || !strings.Contains(err.Error(), "no endpoint links") {
+		t.Errorf("waitVolumeEndPoints() error = %v, want the link state after 5 attempts", err)
+	}
+
+	// endpoint linked, but not readable yet
+	client = newClient(0)
+	delete(client.results, "/redfish/v1/Fabrics/1/Endpoints/nqn.1")
+	drv.rsdClient = client
+	_, err = drv.waitVolumeEndPoints(context.Background(), newVolume())
+	if err == nil || !strings.Contains(err.Error(), "endpoint links /redfish/v1/Fabrics/1/Endpoints/nqn.1") {
+		t.Errorf("waitVolumeEndPoints() error = %v, want the endpoint link", err)
+	}
+
+	// waiting stops when the context is done
+	drv.rsdClient = newClient(10)
+	drv.policies.EndpointWait = policy.Retry{Attempts: 5, Delay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = drv.waitVolumeEndPoints(ctx, newVolume())
+	if err == nil || !strings.Contains(err.Error(), "stopped waiting") {
+		t.Errorf("waitVolumeEndPoints() error = %v, want waiting stopped by the context", err)
+	}
+
+	// volume read once without the policy
+	client = newClient(2)
+	drv = &Driver{rsdClient: client}
+	if _, err := drv.waitVolumeEndPoints(context.Background(), newVolume()); err == nil || client.reads != 1 {
+		t.Errorf("waitVolumeEndPoints() error = %v after %d reads, want error after 1 read", err, client.reads)
+	}
+}
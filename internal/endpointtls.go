@@ -0,0 +1,163 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Prefixes of the full gRPC method names of the CSI services
+const (
+	controllerMethodPrefix = "/csi.v1.Controller/"
+	nodeMethodPrefix       = "/csi.v1.Node/"
+)
+
+// EndpointTLSOptions configure mutual TLS and authorization of the clients
+// of a tcp:// CSI endpoint, e.g. for remote debugging of the driver
+type EndpointTLSOptions struct {
+	// CertFile and KeyFile are PEM server certificate and its key
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of the CAs verifying the client certificates
+	ClientCAFile string
+	// ControllerClients and NodeClients are the identities of the clients
+	// allowed to call Controller and Node RPCs: DNS names, URIs, email
+	// or IP addresses in the Subject Alternative Names of their certificates.
+	// Identity and health RPCs are allowed to the clients of both lists.
+	ControllerClients []string
+	NodeClients       []string
+}
+
+// endpointAuth verifies and authorizes the clients of the tcp:// CSI endpoint
+type endpointAuth struct {
+	config     *tls.Config
+	controller map[string]bool
+	node       map[string]bool
+}
+
+// SetEndpointTLS makes the driver serve a tcp:// CSI endpoint with mutual
+// TLS: only clients with a certificate of the client CAs and identity in the
+// allowlists are accepted, and node clients can't call Controller RPCs and
+// vice versa. Endpoints other than tcp:// fail to start with TLS set.
+func (drv *Driver) SetEndpointTLS(opts EndpointTLSOptions) error {
+	if opts.CertFile == "" || opts.KeyFile == "" || opts.ClientCAFile == "" {
+		return fmt.Errorf("endpoint TLS needs server certificate, its key and client CA bundle")
+	}
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "Can't load endpoint certificate")
+	}
+	pem, err := ioutil.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return errors.Wrap(err, "Can't read client CA bundle")
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in client CA bundle %s", opts.ClientCAFile)
+	}
+	if len(opts.ControllerClients) == 0 && len(opts.NodeClients) == 0 {
+		return fmt.Errorf("endpoint TLS needs at least one allowed client identity")
+	}
+
+	auth := &endpointAuth{
+		config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    clientCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+		controller: map[string]bool{},
+		node:       map[string]bool{},
+	}
+	for _, identity := range opts.ControllerClients {
+		auth.controller[identity] = true
+	}
+	for _, identity := range opts.NodeClients {
+		auth.node[identity] = true
+	}
+	drv.endpointAuth = auth
+	return nil
+}
+
+// serverOptions returns the gRPC server options of the endpoint TLS
+func (auth *endpointAuth) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(auth.config)),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	}
+}
+
+// identities returns Subject Alternative Names of the verified client certificate
+func identities(ctx context.Context) ([]string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("no peer in the request context")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, fmt.Errorf("no verified client certificate")
+	}
+	cert := info.State.VerifiedChains[0][0]
+	result := append([]string{}, cert.DNSNames...)
+	result = append(result, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		result = append(result, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		result = append(result, uri.String())
+	}
+	return result, nil
+}
+
+// authorize checks the client of the request is allowed to call the method
+func (auth *endpointAuth) authorize(ctx context.Context, method string) error {
+	clients, err := identities(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "%s: %v", method, err)
+	}
+	for _, identity := range clients {
+		allowed := auth.controller[identity] || auth.node[identity]
+		switch {
+		case strings.HasPrefix(method, controllerMethodPrefix):
+			allowed = auth.controller[identity]
+		case strings.HasPrefix(method, nodeMethodPrefix):
+			allowed = auth.node[identity]
+		}
+		if allowed {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "%s: client %s is not allowed to call it", method, strings.Join(clients, ","))
+}
+
+// streamInterceptor authorizes the streaming calls, e.g. health Watch
+func (auth *endpointAuth) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := auth.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
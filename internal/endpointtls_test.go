@@ -0,0 +1,224 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// testCertificate is a certificate with its key signed by the parent, self-signed if parent is nil
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, parent *testCertificate, name string, ips ...net.IP) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer := &testCertificate{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key}
+}
+
+// write writes the certificate and its key to PEM files in the directory
+func (c *testCertificate) write(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func TestSetEndpointTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-endpoint-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCertificate(t, nil, "ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCertificate(t, ca, "localhost").write(t, dir, "server")
+
+	tests := []struct {
+		name    string
+		opts    EndpointTLSOptions
+		wantErr bool
+	}{
+		{name: "valid", opts: EndpointTLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, NodeClients: []string{"node-1"}}},
+		{name: "no client CA", opts: EndpointTLSOptions{CertFile: certFile, KeyFile: keyFile, NodeClients: []string{"node-1"}}, wantErr: true},
+		{name: "no clients", opts: EndpointTLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, wantErr: true},
+		{name: "key mismatch", opts: EndpointTLSOptions{CertFile: certFile, KeyFile: filepath.Join(dir, "ca.key"), ClientCAFile: caFile, NodeClients: []string{"node-1"}}, wantErr: true},
+		{name: "CA bundle without certificates", opts: EndpointTLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile, NodeClients: []string{"node-1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{}
+			if err := drv.SetEndpointTLS(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("SetEndpointTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunEndpointWithoutTLS(t *testing.T) {
+	drv := NewDriver("tcp://127.0.0.1:0", "1", &TestClient{})
+	if err := drv.Run(); err == nil {
+		t.Error("Run() of tcp endpoint without TLS succeeded")
+	}
+
+	drv = NewDriver("unix:///tmp/csi-rsd-endpoint-tls.sock", "1", &TestClient{})
+	drv.endpointAuth = &endpointAuth{}
+	if err := drv.Run(); err == nil {
+		t.Error("Run() of unix endpoint with TLS succeeded")
+	}
+}
+
+func TestEndpointTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-endpoint-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCertificate(t, nil, "ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCertificate(t, ca, "localhost", net.ParseIP("127.0.0.1")).write(t, dir, "server")
+	controllerClient := newTestCertificate(t, ca, "provisioner.example.com")
+	nodeClient := newTestCertificate(t, ca, "node-1.example.com")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	drv := NewDriver("tcp://"+address, "1", &TestClient{results: map[string]string{}})
+	err = drv.SetEndpointTLS(EndpointTLSOptions{
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		ClientCAFile:      caFile,
+		ControllerClients: []string{"provisioner.example.com"},
+		NodeClients:       []string{"node-1.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("SetEndpointTLS() unexpected error: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- drv.Run() }()
+	defer func() {
+		drv.Stop()
+		if err := <-served; err != nil {
+			t.Errorf("Run() unexpected error: %v", err)
+		}
+	}()
+
+	dial := func(certificates ...tls.Certificate) *grpc.ClientConn {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: certificates, ServerName: "localhost"})
+		conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	calls := func(conn *grpc.ClientConn) (error, error, error) {
+		_, identityErr := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true))
+		_, controllerErr := csi.NewControllerClient(conn).ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+		_, nodeErr := csi.NewNodeClient(conn).NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+		return identityErr, controllerErr, nodeErr
+	}
+
+	conn := dial(controllerClient.tlsCertificate())
+	defer conn.Close()
+	identityErr, controllerErr, nodeErr := calls(conn)
+	if identityErr != nil || controllerErr != nil || status.Code(nodeErr) != codes.PermissionDenied {
+		t.Errorf("controller client: identity error = %v, controller error = %v, node error = %v, want only node RPC denied", identityErr, controllerErr, nodeErr)
+	}
+
+	conn = dial(nodeClient.tlsCertificate())
+	defer conn.Close()
+	identityErr, controllerErr, nodeErr = calls(conn)
+	if identityErr != nil || status.Code(controllerErr) != codes.PermissionDenied || nodeErr != nil {
+		t.Errorf("node client: identity error = %v, controller error = %v, node error = %v, want only controller RPC denied", identityErr, controllerErr, nodeErr)
+	}
+
+	// the handshake fails without a client certificate
+	conn = dial()
+	defer conn.Close()
+	if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); err == nil {
+		t.Error("GetPluginInfo() without client certificate succeeded")
+	}
+}
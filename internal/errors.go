@@ -0,0 +1,34 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+)
+
+// rsdStatusCode returns gRPC status code of the failed RSD operation,
+// fallback if the category of the failure has no code of its own
+func rsdStatusCode(category rsd.ErrorCategory, fallback codes.Code) codes.Code {
+	switch category {
+	case rsd.CategoryCapacity:
+		return codes.ResourceExhausted
+	case rsd.CategoryNotFound:
+		return codes.NotFound
+	case rsd.CategoryAuth:
+		return codes.Unauthenticated
+	}
+	return fallback
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRSDStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "insufficient capacity", err: &rsd.HTTPError{StatusCode: http.StatusBadRequest, Body: `{"error": {"@Message.ExtendedInfo": [{"MessageId": "Swordfish.1.0.0.InsufficientCapacity"}]}}`}, want: codes.ResourceExhausted},
+		{name: "not found", err: &rsd.HTTPError{StatusCode: http.StatusNotFound}, want: codes.NotFound},
+		{name: "unauthorized", err: &rsd.HTTPError{StatusCode: http.StatusUnauthorized}, want: codes.Unauthenticated},
+		{name: "other", err: &rsd.HTTPError{StatusCode: http.StatusInternalServerError, Body: "oops"}, want: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rsdStatusCode(rsd.Classify(tt.err), codes.Internal); got != tt.want {
+				t.Errorf("rsdStatusCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
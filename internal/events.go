@@ -0,0 +1,225 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of the Kubernetes objects the volume events are reported about
+const (
+	EventKindPVC = "PersistentVolumeClaim"
+	EventKindPod = "Pod"
+)
+
+// Volume context keys of the pod publishing the volume, set by kubelet
+// if podInfoOnMount of the CSIDriver object is enabled
+const (
+	podNameContext      = "csi.storage.k8s.io/pod.name"
+	podNamespaceContext = "csi.storage.k8s.io/pod.namespace"
+)
+
+// Node operations reported by the events
+const (
+	eventOperationStage   = "stage"
+	eventOperationPublish = "publish"
+)
+
+// EventObject identifies the Kubernetes object an event is reported about
+type EventObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// EventSink reports warning events about the Kubernetes objects,
+// e.g. to the Kubernetes API
+type EventSink interface {
+	Warning(object EventObject, reason, message string) error
+	Normal(object EventObject, reason, message string) error
+}
+
+// failureCause is a category of the node operation failures
+// recognized by the error messages
+type failureCause struct {
+	reason string
+	// patterns are lower case substrings of the error messages
+	patterns []string
+	hint     string
+}
+
+// failureCauses are checked in order, the first matching one is reported
+var failureCauses = []failureCause{
+	{
+		reason:   "StageInProgress",
+		patterns: []string{"is being staged"},
+		hint:     "a previous stage of the volume is still running, check the node plugin logs for the operation it waits for",
+	},
+	{
+		reason:   "FencingCheckFailed",
+		patterns: []string{"fencing check failed"},
+		hint:     "the volume is still attached to another host, detach it from the host or its RSD zone",
+	},
+	{
+		reason:   "PortalUnreachable",
+		patterns: []string{"unreachable from node", "connection refused", "no route to host", "connection timed out"},
+		hint:     "check the network between the node and the storage portal and the portal of the volume endpoint in RSD",
+	},
+	{
+		reason:   "DeviceNotFound",
+		patterns: []string{"can't find nvme device"},
+		hint:     "the volume was connected, but its device didn't appear, check the node kernel log and device-wait-timeout",
+	},
+	{
+		reason:   "ConnectFailed",
+		patterns: []string{"'nvme connect"},
+		hint:     "check nvme-cli and the NVMe-oF transport kernel modules of the node, e.g. with 'csirsd preflight'",
+	},
+	{
+		reason:   "FormatFailed",
+		patterns: []string{"formatting disk failed", "mkfs."},
+		hint:     "check the mkfs tool of the filesystem type is installed on the node or in host-root",
+	},
+	{
+		reason:   "MountFailed",
+		patterns: []string{"mounting failed"},
+		hint:     "check the filesystem type and mount options of the StorageClass and the node kernel log",
+	},
+}
+
+// classifyFailure returns the event reason and the remediation hint of the failure
+func classifyFailure(operation, message string) (string, string) {
+	lower := strings.ToLower(message)
+	for _, cause := range failureCauses {
+		for _, pattern := range cause.patterns {
+			if strings.Contains(lower, pattern) {
+				return cause.reason, cause.hint
+			}
+		}
+	}
+	reason := "StageFailed"
+	if operation == eventOperationPublish {
+		reason = "PublishFailed"
+	}
+	return reason, "check the node plugin logs or collect the diagnostics with 'csirsd diag'"
+}
+
+// volumeEvents reports repeated failures of the node operations
+type volumeEvents struct {
+	sink      EventSink
+	threshold int
+
+	mu sync.Mutex
+	// failures are the numbers of consecutive failures by operation and volume ID
+	failures map[string]int
+}
+
+// SetEventSink makes the node report every threshold consecutive failures
+// of staging or publishing a volume as a warning event about its PVC and
+// pod. Events are not reported if sink is nil or threshold is 0.
+func (drv *Driver) SetEventSink(sink EventSink, threshold int) {
+	if sink == nil || threshold <= 0 {
+		drv.events = nil
+		return
+	}
+	drv.events = &volumeEvents{sink: sink, threshold: threshold, failures: map[string]int{}}
+}
+
+// eventObjects returns PVC and pod of the volume known from its volume context
+func eventObjects(volumeContext map[string]string) []EventObject {
+	var objects []EventObject
+	if name := volumeContext[pvcNameParameter]; name != "" {
+		objects = append(objects, EventObject{Kind: EventKindPVC, Namespace: volumeContext[pvcNamespaceParameter], Name: name})
+	}
+	if name := volumeContext[podNameContext]; name != "" {
+		objects = append(objects, EventObject{Kind: EventKindPod, Namespace: volumeContext[podNamespaceContext], Name: name})
+	}
+	return objects
+}
+
+// observe counts consecutive failures of the volume operation and reports
+// every threshold one. Invalid requests are not counted. Failures to report
+// the events are logged with the logger.
+func (events *volumeEvents) observe(logger Logger, nodeID, operation, volumeID string, volumeContext map[string]string, err error) {
+	if events == nil || status.Code(err) == codes.InvalidArgument {
+		return
+	}
+
+	key := operation + "/" + volumeID
+	events.mu.Lock()
+	if err == nil {
+		delete(events.failures, key)
+		events.mu.Unlock()
+		return
+	}
+	events.failures[key]++
+	count := events.failures[key]
+	events.mu.Unlock()
+
+	if count%events.threshold != 0 {
+		return
+	}
+	message := rsd.Redact(status.Convert(err).Message())
+	reason, hint := classifyFailure(operation, message)
+	text := fmt.Sprintf("%s of the volume %s on the node %s failed %d times: %s. Hint: %s", operation, volumeID, nodeID, count, message, hint)
+	for _, object := range eventObjects(volumeContext) {
+		if err := events.sink.Warning(object, reason, text); err != nil {
+			logger.Error(err, "can't report event", "reason", reason, "volume_id", volumeID, "kind", object.Kind, "namespace", object.Namespace, "name", object.Name)
+		}
+	}
+}
+
+// report reports a Normal event about the PVC and pod of the volume,
+// failures to report it are logged with the logger
+func (events *volumeEvents) report(logger Logger, volumeContext map[string]string, reason, message string) {
+	if events == nil {
+		return
+	}
+	for _, object := range eventObjects(volumeContext) {
+		if err := events.sink.Normal(object, reason, message); err != nil {
+			logger.Error(err, "can't report event", "reason", reason, "kind", object.Kind, "namespace", object.Namespace, "name", object.Name)
+		}
+	}
+}
+
+// warn reports a Warning event about the objects of the volume,
+// failures to report it are logged with the logger
+func (events *volumeEvents) warn(logger Logger, volumeContext map[string]string, reason, message string) {
+	if events == nil {
+		return
+	}
+	for _, object := range eventObjects(volumeContext) {
+		if err := events.sink.Warning(object, reason, message); err != nil {
+			logger.Error(err, "can't report event", "reason", reason, "kind", object.Kind, "namespace", object.Namespace, "name", object.Name)
+		}
+	}
+}
+
+// observeNodeOperation reports repeated failures of staging and publishing the volumes
+func (drv *Driver) observeNodeOperation(logger Logger, req interface{}, err error) {
+	switch r := req.(type) {
+	case *csi.NodeStageVolumeRequest:
+		drv.events.observe(logger, drv.RSDNodeID, eventOperationStage, r.VolumeId, r.VolumeContext, err)
+	case *csi.NodePublishVolumeRequest:
+		drv.events.observe(logger, drv.RSDNodeID, eventOperationPublish, r.VolumeId, r.VolumeContext, err)
+	}
+}
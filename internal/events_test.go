@@ -0,0 +1,123 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type recordedEvent struct {
+	object  EventObject
+	reason  string
+	message string
+}
+
+// recordingEventSink records the reported events
+type recordingEventSink struct {
+	events []recordedEvent
+}
+
+func (sink *recordingEventSink) Warning(object EventObject, reason, message string) error {
+	sink.events = append(sink.events, recordedEvent{object, reason, message})
+	return nil
+}
+
+func (sink *recordingEventSink) Normal(object EventObject, reason, message string) error {
+	sink.events = append(sink.events, recordedEvent{object, reason, message})
+	return nil
+}
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		operation string
+		message   string
+		want      string
+	}{
+		{eventOperationStage, "NodeStageVolume: volume pvc-1(1) is being staged", "StageInProgress"},
+		{eventOperationStage, "portal 10.0.0.1:4420 unreachable from node: dial tcp 10.0.0.1:4420: i/o timeout", "PortalUnreachable"},
+		{eventOperationStage, "can't find NVMe device by NQN nqn.1", "DeviceNotFound"},
+		{eventOperationStage, "command failed: exit status 1, command: 'nvme connect --transport rdma', output: \"\"", "ConnectFailed"},
+		{eventOperationStage, "formatting disk failed: exit status 1 cmd: 'mkfs.ext4 -F /dev/nvme1n1'", "FormatFailed"},
+		{eventOperationStage, "\"mkfs.xfs\" executable not found in $PATH", "FormatFailed"},
+		{eventOperationPublish, "mounting failed: exit status 32 cmd: 'mount -t ext4'", "MountFailed"},
+		{eventOperationStage, "fencing check failed: volume 1 is also attached to the initiator 2", "FencingCheckFailed"},
+		{eventOperationStage, "something else", "StageFailed"},
+		{eventOperationPublish, "something else", "PublishFailed"},
+	}
+	for _, tt := range tests {
+		if reason, hint := classifyFailure(tt.operation, tt.message); reason != tt.want || hint == "" {
+			t.Errorf("classifyFailure(%q) = %q, %q, want %q with a hint", tt.message, reason, hint, tt.want)
+		}
+	}
+}
+
+func TestNodeOperationEvents(t *testing.T) {
+	sink := &recordingEventSink{}
+	drv := &Driver{RSDNodeID: "node-1"}
+	drv.SetEventSink(sink, 2)
+
+	stage := &csi.NodeStageVolumeRequest{
+		VolumeId:      "1",
+		VolumeContext: map[string]string{pvcNameParameter: "data", pvcNamespaceParameter: "default"},
+	}
+	publish := &csi.NodePublishVolumeRequest{
+		VolumeId: "1",
+		VolumeContext: map[string]string{
+			pvcNameParameter: "data", pvcNamespaceParameter: "default",
+			podNameContext: "app-0", podNamespaceContext: "default",
+		},
+	}
+	connectErr := status.Error(codes.Aborted, "NodeStageVolume: error staging volume: can't find NVMe device by NQN nqn.1")
+
+	drv.observeNodeOperation(drv.logger, stage, status.Error(codes.InvalidArgument, "NodeStageVolume: Volume Capability is missing"))
+	drv.observeNodeOperation(drv.logger, stage, connectErr)
+	if len(sink.events) != 0 {
+		t.Fatalf("events reported before the threshold: %v", sink.events)
+	}
+	drv.observeNodeOperation(drv.logger, stage, connectErr)
+	if len(sink.events) != 1 {
+		t.Fatalf("expected 1 event, got %v", sink.events)
+	}
+	event := sink.events[0]
+	if event.object != (EventObject{Kind: EventKindPVC, Namespace: "default", Name: "data"}) || event.reason != "DeviceNotFound" ||
+		!strings.Contains(event.message, "failed 2 times") || !strings.Contains(event.message, "node-1") {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// success resets the failures
+	drv.observeNodeOperation(drv.logger, stage, nil)
+	drv.observeNodeOperation(drv.logger, stage, connectErr)
+	if len(sink.events) != 1 {
+		t.Errorf("event reported after the success reset: %v", sink.events[1:])
+	}
+
+	// publish failures are reported about both PVC and pod
+	sink.events = nil
+	for i := 0; i < 2; i++ {
+		drv.observeNodeOperation(drv.logger, publish, status.Error(codes.Aborted, "mounting failed: exit status 32"))
+	}
+	if len(sink.events) != 2 || sink.events[0].object.Kind != EventKindPVC || sink.events[1].object.Kind != EventKindPod || sink.events[1].reason != "MountFailed" {
+		t.Errorf("unexpected publish events %+v", sink.events)
+	}
+
+	// disabled events
+	drv.SetEventSink(sink, 0)
+	drv.observeNodeOperation(drv.logger, stage, connectErr)
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hostSearchPath is used to find executables in the host root
+const hostSearchPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Execer interface declares running external tools like mount or nvme
+type Execer interface {
+	// LookPath searches for an executable in the execution root
+	LookPath(file string) (string, error)
+	// CombinedOutput runs the command and returns its combined standard output and error
+	CombinedOutput(name string, args ...string) ([]byte, error)
+	// HostPath translates the path in the execution root to the path in the driver filesystem
+	HostPath(path string) string
+}
+
+// hostExecer runs commands in the driver filesystem
+type hostExecer struct {
+	// timeout kills commands running longer, no limit if it's 0
+	timeout time.Duration
+}
+
+func (hostExecer) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (e hostExecer) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return combinedOutput(e.timeout, name, args...)
+}
+
+func (hostExecer) HostPath(path string) string {
+	return path
+}
+
+// chrootExecer runs commands chrooted into the host root directory, so the node
+// plugin can use tools installed on the host instead of the ones from the image
+type chrootExecer struct {
+	root    string
+	timeout time.Duration
+}
+
+func (e *chrootExecer) LookPath(file string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(hostSearchPath) {
+		path := filepath.Join(dir, file)
+		info, err := os.Stat(e.HostPath(path))
+		if err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%q not found in %s of the host root %s: %v", file, hostSearchPath, e.root, exec.ErrNotFound)
+}
+
+func (e *chrootExecer) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return combinedOutput(e.timeout, "chroot", append([]string{e.root, name}, args...)...)
+}
+
+func (e *chrootExecer) HostPath(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+// newExecer returns Execer running commands in the host root directory.
+// Commands are run in the driver filesystem if hostRoot is empty or "/".
+func newExecer(hostRoot string) Execer {
+	if hostRoot == "" || filepath.Clean(hostRoot) == "/" {
+		return hostExecer{}
+	}
+	return &chrootExecer{root: filepath.Clean(hostRoot)}
+}
+
+// withCommandTimeout returns Execer killing commands running longer than
+// the timeout. Other Execer implementations are returned unchanged.
+func withCommandTimeout(e Execer, timeout time.Duration) Execer {
+	switch execer := e.(type) {
+	case hostExecer:
+		execer.timeout = timeout
+		return execer
+	case *chrootExecer:
+		return &chrootExecer{root: execer.root, timeout: timeout}
+	}
+	return e
+}
+
+// combinedOutput runs the command killing it after the timeout, if it's not 0
+func combinedOutput(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	if timeout <= 0 {
+		return exec.Command(name, args...).CombinedOutput()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("%s timed out after %v: %v", name, timeout, err)
+	}
+	return out, err
+}
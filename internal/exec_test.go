@@ -0,0 +1,243 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+// fakeExecer records executed commands and returns canned outputs
+type fakeExecer struct {
+	// outputs maps command lines to their outputs
+	outputs map[string]string
+	// failures maps command lines to errors
+	failures map[string]error
+	commands []string
+}
+
+func (e *fakeExecer) LookPath(file string) (string, error) {
+	return "/usr/bin/" + file, nil
+}
+
+func (e *fakeExecer) CombinedOutput(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	e.commands = append(e.commands, cmd)
+	if err, failed := e.failures[cmd]; failed {
+		return []byte(err.Error()), err
+	}
+	return []byte(e.outputs[cmd]), nil
+}
+
+func (e *fakeExecer) HostPath(path string) string {
+	return path
+}
+
+func TestNewExecer(t *testing.T) {
+	for _, root := range []string{"", "/", "//"} {
+		if _, ok := newExecer(root).(hostExecer); !ok {
+			t.Errorf("newExecer(%q) is not a host execer", root)
+		}
+	}
+	if e, ok := newExecer("/host/").(*chrootExecer); !ok || e.root != "/host" {
+		t.Errorf("newExecer(\"/host/\") = %v, want chroot execer with /host root", e)
+	}
+}
+
+func TestChrootExecerLookPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "csi-rsd-host-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for fname, mode := range map[string]os.FileMode{"usr/sbin/nvme": 0755, "usr/bin/mkfs.xfs": 0644} {
+		path := filepath.Join(root, fname)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := &chrootExecer{root: root}
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: "nvme", want: "/usr/sbin/nvme"},
+		{file: "mkfs.xfs", wantErr: true},
+		{file: "lsblk", wantErr: true},
+		{file: "/opt/bin/tool", want: "/opt/bin/tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := e.LookPath(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookPath(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LookPath(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := e.HostPath("/var/lib/kubelet"), filepath.Join(root, "var/lib/kubelet"); got != want {
+		t.Errorf("HostPath() = %q, want %q", got, want)
+	}
+}
+
+func TestNVMeFindDevice(t *testing.T) {
+	e := &fakeExecer{
+		outputs: map[string]string{
+			"nvme list -o json":                 `{"Devices": [{"DevicePath": "/dev/nvme0n1"}, {"DevicePath": "/dev/nvme1n1"}]}`,
+			"nvme id-ctrl /dev/nvme0n1 -o json": `{"subnqn": "nqn.2014-08.org.nvmexpress:uuid:0"}`,
+			"nvme id-ctrl /dev/nvme1n1 -o json": `{"subnqn": "nqn.2014-08.org.nvmexpress:uuid:1 "}`,
+		},
+	}
+	policies := policy.Default()
+	policies.DeviceWait = policy.Retry{Attempts: 3, Delay: time.Millisecond}
+	n := newNVMe(e, policies)
+	// sysfs is not available, devices are listed by nvme
+	n.sysBlock = "/nonexistent"
+	device, err := n.findDevice(context.Background(), "nqn.2014-08.org.nvmexpress:uuid:1", nil)
+	if err != nil {
+		t.Fatalf("findDevice() unexpected error: %v", err)
+	}
+	if device != "/dev/nvme1n1" {
+		t.Errorf("findDevice() = %q, want /dev/nvme1n1", device)
+	}
+
+	if _, err := n.findDevice(context.Background(), "nqn.2014-08.org.nvmexpress:uuid:2", nil); err == nil {
+		t.Error("findDevice() unexpected success for unknown NQN")
+	}
+
+	e.failures = map[string]error{"nvme list -o json": fmt.Errorf("exit status 1")}
+	if _, err := n.findDevice(context.Background(), "nqn.2014-08.org.nvmexpress:uuid:1", nil); err == nil {
+		t.Error("findDevice() unexpected success")
+	}
+}
+
+func TestNVMeFindDeviceCancelled(t *testing.T) {
+	e := &fakeExecer{outputs: map[string]string{"nvme list -o json": `{"Devices": []}`}}
+	policies := policy.Default()
+	// delays are longer than the test timeout, waiting is stopped by the context
+	policies.DeviceWait = policy.Retry{Attempts: 2, Delay: time.Hour}
+	n := newNVMe(e, policies)
+	n.sysBlock = "/nonexistent"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := n.findDevice(ctx, "nqn.2014-08.org.nvmexpress:uuid:1", nil)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("findDevice() error = %v, want the context error", err)
+	}
+	if len(e.commands) != 1 {
+		t.Errorf("listed devices %d times after the context is done, want 1", len(e.commands))
+	}
+}
+
+// fakeDeviceEvents runs added callback and reports added disk on every wait
+type fakeDeviceEvents struct {
+	added func()
+	waits int
+}
+
+func (events *fakeDeviceEvents) wait(timeout time.Duration) bool {
+	events.waits++
+	events.added()
+	return true
+}
+
+func (events *fakeDeviceEvents) Close() error {
+	return nil
+}
+
+func TestNVMeFindDeviceSysfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addDevice := func(name, subnqn string) {
+		deviceDir := filepath.Join(dir, name, "device")
+		if err := os.MkdirAll(deviceDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(deviceDir, "subsysnqn"), []byte(subnqn+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addDevice("nvme0n1", "nqn.2014-08.org.nvmexpress:uuid:0")
+	addDevice("nvme1c1n1", "nqn.2014-08.org.nvmexpress:uuid:1")
+
+	// no nvme commands are expected, they fail
+	e := &fakeExecer{failures: map[string]error{"nvme list -o json": fmt.Errorf("exit status 1")}}
+	policies := policy.Default()
+	// delays are longer than the test timeout, the device is found by the event
+	policies.DeviceWait = policy.Retry{Attempts: 2, Delay: time.Hour}
+	n := newNVMe(e, policies)
+	n.sysBlock = dir
+
+	events := &fakeDeviceEvents{added: func() { addDevice("nvme1n1", "nqn.2014-08.org.nvmexpress:uuid:1") }}
+	device, err := n.findDevice(context.Background(), "nqn.2014-08.org.nvmexpress:uuid:1", events)
+	if err != nil {
+		t.Fatalf("findDevice() unexpected error: %v", err)
+	}
+	if device != "/dev/nvme1n1" {
+		t.Errorf("findDevice() = %q, want /dev/nvme1n1", device)
+	}
+	if events.waits != 1 {
+		t.Errorf("waited for %d events, want 1", events.waits)
+	}
+	if len(e.commands) != 0 {
+		t.Errorf("unexpected commands %v", e.commands)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	e := withCommandTimeout(newExecer(""), 50*time.Millisecond)
+	start := time.Now()
+	if _, err := e.CombinedOutput("sleep", "10"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("CombinedOutput() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command was killed after %v", elapsed)
+	}
+
+	if out, err := e.CombinedOutput("echo", "ok"); err != nil || strings.TrimSpace(string(out)) != "ok" {
+		t.Errorf("CombinedOutput() = %q, %v, want \"ok\"", out, err)
+	}
+
+	if e, ok := withCommandTimeout(newExecer("/host"), time.Second).(*chrootExecer); !ok || e.root != "/host" || e.timeout != time.Second {
+		t.Errorf("withCommandTimeout() = %v, want chroot execer with /host root and 1s timeout", e)
+	}
+
+	fake := &fakeExecer{}
+	if withCommandTimeout(fake, time.Second) != Execer(fake) {
+		t.Error("withCommandTimeout() changed fake execer")
+	}
+}
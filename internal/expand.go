@@ -0,0 +1,244 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Keys of the ControllerExpandVolume secrets, set per StorageClass with the
+// csi.storage.k8s.io/controller-expand-secret-name and -namespace parameters
+const (
+	// expandSecretUsername is the RSD username the volumes are expanded with
+	expandSecretUsername = "rsdUsername"
+	// expandSecretPassword is the RSD password the volumes are expanded with
+	expandSecretPassword = "rsdPassword"
+)
+
+// SetExpandSecretsRequired makes the driver expand only the volumes with
+// the expansion secrets, i.e. of the StorageClasses allowed to grow volumes
+func (drv *Driver) SetExpandSecretsRequired(required bool) {
+	drv.expandSecretsRequired = required
+}
+
+// expandContext returns context of the RSD requests expanding the volume, with
+// the RSD credentials of the expansion secrets if they're set. Errors never
+// contain secret values.
+func (drv *Driver) expandContext(ctx context.Context, secrets map[string]string) (context.Context, error) {
+	if len(secrets) == 0 {
+		if drv.expandSecretsRequired {
+			return nil, errors.New("volume expansion requires the controller expand secrets of the StorageClass")
+		}
+		return ctx, nil
+	}
+	username, password := secrets[expandSecretUsername], secrets[expandSecretPassword]
+	if username == "" && password == "" {
+		return ctx, nil
+	}
+	if username == "" || password == "" {
+		return nil, errors.New(expandSecretUsername + " and " + expandSecretPassword + " expand secrets must be set together")
+	}
+	return rsd.WithCredentials(ctx, username, password), nil
+}
+
+// ControllerExpandVolume grows the RSD volume to the required capacity
+func (drv *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ControllerExpandVolume request", "request", redactRequest(req))
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume: Volume ID is missing")
+	}
+	if req.CapacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerExpandVolume: Capacity Range is missing")
+	}
+	requiredBytes, limitBytes := req.CapacityRange.RequiredBytes, req.CapacityRange.LimitBytes
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return nil, status.Errorf(codes.OutOfRange, "ControllerExpandVolume: required capacity %d is larger than limit %d", requiredBytes, limitBytes)
+	}
+	if req.VolumeCapability != nil {
+		if err := validateCapability(req.VolumeCapability); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "ControllerExpandVolume: %v", err)
+		}
+	}
+
+	ctx, err := drv.expandContext(ctx, req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerExpandVolume: %v", err)
+	}
+
+	if err := drv.volumeLocks.lock(req.VolumeId, "ControllerExpandVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "ControllerExpandVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
+
+	drv.volumesRWL.RLock()
+	_, volume := drv.findVolByID(req.VolumeId)
+	drv.volumesRWL.RUnlock()
+	if volume == nil {
+		return nil, status.Errorf(codes.NotFound, "ControllerExpandVolume: No volume with id '%s' found", req.VolumeId)
+	}
+
+	capacityBytes, err := drv.expandVolume(ctx, volume, requiredBytes)
+	if budgetErr, exhausted := err.(*capacityBudgetError); exhausted {
+		return nil, status.Errorf(codes.ResourceExhausted, "ControllerExpandVolume: volume %s: %v", req.VolumeId, budgetErr)
+	}
+	if err != nil {
+		return nil, status.Errorf(rsdStatusCode(rsd.Classify(err), codes.Internal), "ControllerExpandVolume: can't expand volume %s: %v", req.VolumeId, err)
+	}
+	if limitBytes > 0 && capacityBytes > limitBytes {
+		return nil, status.Errorf(codes.OutOfRange, "ControllerExpandVolume: volume %s capacity %d is larger than limit %d", req.VolumeId, capacityBytes, limitBytes)
+	}
+
+	resp := &csi.ControllerExpandVolumeResponse{
+		CapacityBytes: capacityBytes,
+		// there is no filesystem to grow on raw block volumes
+		NodeExpansionRequired: req.VolumeCapability.GetBlock() == nil,
+	}
+	logger.V(LogLevelRequest).Info("ControllerExpandVolume response", "response", resp)
+	return resp, nil
+}
+
+// capacityBudgetError is returned when the expansion would exceed the total capacity budget
+type capacityBudgetError struct {
+	err error
+}
+
+func (e *capacityBudgetError) Error() string {
+	return e.err.Error()
+}
+
+// expandVolume grows the RSD volume to at least requiredBytes and returns the
+// volume capacity. RSD volume is changed with the credentials of ctx, see
+// expandContext. The RSD requests are sent without holding drv.volumesRWL, the
+// grown capacity is reserved in the total capacity budget meanwhile. It must
+// be called with the volume locked in drv.volumeLocks.
+func (drv *Driver) expandVolume(ctx context.Context, volume *Volume, requiredBytes int64) (int64, error) {
+	drv.volumesRWL.Lock()
+	capacityBytes := volume.CSIVolume.CapacityBytes
+	if requiredBytes <= capacityBytes {
+		drv.volumesRWL.Unlock()
+		return capacityBytes, nil
+	}
+	ctx, err := volumeRSDContext(ctx, volume)
+	if err != nil {
+		drv.volumesRWL.Unlock()
+		return 0, err
+	}
+	reserved := requiredBytes - capacityBytes
+	if err := drv.checkCapacityBudget(reserved); err != nil {
+		drv.volumesRWL.Unlock()
+		return 0, &capacityBudgetError{err}
+	}
+	drv.reservedCapacity += reserved
+	odataID := volume.RSDVolume.OdataID
+	drv.volumesRWL.Unlock()
+
+	rsdVolume, err := drv.setRSDCapacity(ctx, odataID, requiredBytes)
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	drv.reservedCapacity -= reserved
+	if err != nil {
+		return 0, err
+	}
+	volume.RSDVolume.CapacityBytes = rsdVolume.CapacityBytes
+	volume.RequiredBytes = requiredBytes
+	drv.refreshCapacity(volume)
+	drv.logger.V(LogLevelState).Info("volume has been expanded", "volume", volume.Name, "volume_id", volume.CSIVolume.VolumeId, "capacity", volume.CSIVolume.CapacityBytes)
+	return volume.CSIVolume.CapacityBytes, nil
+}
+
+// setRSDCapacity sets capacity of the RSD volume and returns the volume read
+// back, as RSD may allocate more than requested
+func (drv *Driver) setRSDCapacity(ctx context.Context, odataID string, requiredBytes int64) (*rsd.Volume, error) {
+	client := drv.client(ctx)
+	if err := (&rsd.Volume{OdataID: odataID}).SetCapacity(ctx, client, requiredBytes); err != nil {
+		return nil, err
+	}
+	drv.capacity.invalidate()
+
+	rsdVolume, err := rsd.GetVolumeByPath(ctx, client, odataID)
+	if err != nil {
+		return nil, err
+	}
+	if rsdVolume.CapacityBytes < requiredBytes {
+		return nil, fmt.Errorf("RSD volume %s capacity %d is smaller than requested %d", rsdVolume.ID, rsdVolume.CapacityBytes, requiredBytes)
+	}
+	return rsdVolume, nil
+}
+
+// NodeExpandVolume grows the filesystem of the staged volume up to the size of its device
+func (drv *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeExpandVolume request", "request", redactRequest(req))
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume: Volume ID is missing")
+	}
+	if req.VolumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume: Volume Path is missing")
+	}
+
+	if err := drv.volumeLocks.lock(req.VolumeId, "NodeExpandVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeExpandVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
+	drv.volumesRWL.RLock()
+	_, vol := drv.findVolByID(req.VolumeId)
+	if vol == nil {
+		drv.volumesRWL.RUnlock()
+		return nil, status.Errorf(codes.NotFound, "NodeExpandVolume: No volume with id '%s' found", req.VolumeId)
+	}
+	if _, published := vol.TargetPaths[req.VolumePath]; !published && req.VolumePath != vol.StagingTargetPath {
+		drv.volumesRWL.RUnlock()
+		return nil, status.Errorf(codes.NotFound, "NodeExpandVolume: Path '%s' is neither a staging target path nor target path for the volume '%s'", req.VolumePath, req.VolumeId)
+	}
+
+	// Grow the filesystem of a copy of the volume record without holding the
+	// lock, as resizing may take a while. Resizing doesn't change the record,
+	// the volume lock keeps it staged meanwhile.
+	expanded := *vol
+	drv.volumesRWL.RUnlock()
+	if err := drv.nodeExpandVolume(&expanded); err != nil {
+		return nil, status.Errorf(codes.Internal, "NodeExpandVolume: can't expand volume %s: %v", req.VolumeId, err)
+	}
+
+	resp := &csi.NodeExpandVolumeResponse{CapacityBytes: req.GetCapacityRange().GetRequiredBytes()}
+	logger.V(LogLevelState).Info("volume filesystem has been expanded", "volume_id", req.VolumeId, "volume_path", req.VolumePath)
+	logger.V(LogLevelRequest).Info("NodeExpandVolume response", "response", resp)
+	return resp, nil
+}
+
+// nodeExpandVolume grows filesystem of the staged volume up to the size of
+// its device. Raw block volumes don't need it. It must be called with the
+// volume locked in drv.volumeLocks.
+func (drv *Driver) nodeExpandVolume(volume *Volume) error {
+	if !volume.IsStaged || volume.Device == "" {
+		return fmt.Errorf("volume %s is not staged", volume.Name)
+	}
+	if volume.IsBlock {
+		// there is no filesystem on raw block volumes
+		return nil
+	}
+	return drv.mounter.ResizeFilesystem(volume.Device, volume.StagingTargetPath)
+}
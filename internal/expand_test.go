@@ -0,0 +1,233 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// resizingClient allocates the patched capacity rounded up to granularity
+type resizingClient struct {
+	TestClient
+	granularity int64
+}
+
+func (client *resizingClient) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	if patch, ok := data.(map[string]int64); ok {
+		capacity := (patch["CapacityBytes"] + client.granularity - 1) / client.granularity * client.granularity
+		client.results[entrypoint] = fmt.Sprintf(`{"@odata.id": %q, "Id": "1", "CapacityBytes": %d}`, entrypoint, capacity)
+	}
+	return nil, nil
+}
+
+func TestExpandVolume(t *testing.T) {
+	const odataID = "/redfish/v1/StorageServices/1/Volumes/1"
+	client := &resizingClient{
+		TestClient:  TestClient{results: map[string]string{odataID: `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 100}`}},
+		granularity: 64,
+	}
+	drv := &Driver{rsdClient: client, metrics: newDriverMetrics()}
+	volume := &Volume{
+		Name:          "pvc-1",
+		CSIVolume:     &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+		RSDVolume:     &rsd.Volume{OdataID: odataID, ID: "1", CapacityBytes: 100},
+		RequiredBytes: 100,
+	}
+
+	if _, err := drv.expandVolume(context.Background(), volume, 50); err != nil || volume.CSIVolume.CapacityBytes != 100 {
+		t.Errorf("expandVolume() to smaller capacity = %v, capacity %d", err, volume.CSIVolume.CapacityBytes)
+	}
+	if _, err := drv.expandVolume(context.Background(), volume, 150); err != nil {
+		t.Fatalf("expandVolume() unexpected error: %v", err)
+	}
+	if volume.CSIVolume.CapacityBytes != 192 || volume.RSDVolume.CapacityBytes != 192 || volume.RequiredBytes != 150 {
+		t.Errorf("expanded volume capacity %d, RSD capacity %d, required %d, want 192, 192, 150",
+			volume.CSIVolume.CapacityBytes, volume.RSDVolume.CapacityBytes, volume.RequiredBytes)
+	}
+
+	// RSD didn't change the capacity
+	drv.rsdClient = &client.TestClient
+	if _, err := drv.expandVolume(context.Background(), volume, 500); err == nil {
+		t.Error("expandVolume() not done by RSD unexpected success")
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	drv := &Driver{mounter: &testMounter{}}
+	volume := &Volume{Name: "pvc-1", CSIVolume: &csi.Volume{VolumeId: "1"}}
+	if err := drv.nodeExpandVolume(volume); err == nil {
+		t.Error("nodeExpandVolume() of not staged volume unexpected success")
+	}
+	volume.IsStaged, volume.Device, volume.StagingTargetPath = true, "/dev/nvme1n1", "/staging"
+	if err := drv.nodeExpandVolume(volume); err != nil {
+		t.Errorf("nodeExpandVolume() unexpected error: %v", err)
+	}
+}
+
+func TestExpandVolumeSecrets(t *testing.T) {
+	const odataID = "/redfish/v1/StorageServices/1/Volumes/1"
+	var patchedBy []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPatch {
+			username, _, _ := req.BasicAuth()
+			patchedBy = append(patchedBy, username)
+		}
+		fmt.Fprintf(rw, `{"@odata.id": %q, "Id": "1", "CapacityBytes": 200}`, odataID)
+	}))
+	defer server.Close()
+	client, err := rsd.NewClient(server.URL, "reader", "secret", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := &Driver{rsdClient: client, metrics: newDriverMetrics()}
+	expand := func(secrets map[string]string) error {
+		drv.volumes = map[string]*Volume{"pvc-1": {
+			Name:      "pvc-1",
+			CSIVolume: &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+			RSDVolume: &rsd.Volume{OdataID: odataID, ID: "1", CapacityBytes: 100},
+		}}
+		_, err := drv.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+			VolumeId:      "1",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 200},
+			Secrets:       secrets,
+		})
+		return err
+	}
+
+	modifier := map[string]string{expandSecretUsername: "modifier", expandSecretPassword: "secret"}
+	if err := expand(modifier); err != nil {
+		t.Fatalf("ControllerExpandVolume() unexpected error: %v", err)
+	}
+	if err := expand(map[string]string{expandSecretUsername: "modifier"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume() with username secret only error = %v, want InvalidArgument", err)
+	}
+
+	drv.SetExpandSecretsRequired(true)
+	if err := expand(nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume() without required secrets error = %v, want InvalidArgument", err)
+	}
+	if err := expand(modifier); err != nil {
+		t.Fatalf("ControllerExpandVolume() unexpected error: %v", err)
+	}
+
+	if len(patchedBy) != 2 || patchedBy[0] != "modifier" || patchedBy[1] != "modifier" {
+		t.Errorf("volumes expanded by %v, want modifier of the expand secrets twice", patchedBy)
+	}
+}
+
+func TestExpandVolumeRPCs(t *testing.T) {
+	const odataID = "/redfish/v1/StorageServices/1/Volumes/1"
+	dir, err := ioutil.TempDir("", "csi-rsd-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "csi.sock")
+
+	client := &resizingClient{
+		TestClient:  TestClient{results: map[string]string{odataID: `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 100}`}},
+		granularity: 64,
+	}
+	drv := NewDriver("unix://"+socket, "1", client)
+	drv.mounter = &testMounter{}
+	drv.volumes["pvc-1"] = &Volume{
+		Name:              "pvc-1",
+		CSIVolume:         &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+		RSDVolume:         &rsd.Volume{OdataID: odataID, ID: "1", CapacityBytes: 100},
+		RequiredBytes:     100,
+		IsStaged:          true,
+		Device:            "/dev/nvme1n1",
+		StagingTargetPath: "/staging",
+		TargetPaths:       map[string]bool{"/target": true},
+	}
+	served := make(chan error, 1)
+	go func() { served <- drv.Run() }()
+	defer func() {
+		drv.Stop()
+		if err := <-served; err != nil {
+			t.Errorf("Run() unexpected error: %v", err)
+		}
+	}()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	controller, node := csi.NewControllerClient(conn), csi.NewNodeClient(conn)
+
+	resp, err := controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 150},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume() unexpected error: %v", err)
+	}
+	if resp.CapacityBytes != 192 || !resp.NodeExpansionRequired {
+		t.Errorf("ControllerExpandVolume() = %v, want capacity 192 with node expansion", resp)
+	}
+
+	_, err = controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 300, LimitBytes: 200},
+	})
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("ControllerExpandVolume() required over limit error = %v, want OutOfRange", err)
+	}
+	_, err = controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      "2",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 300},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerExpandVolume() of unknown volume error = %v, want NotFound", err)
+	}
+
+	nodeResp, err := node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{
+		VolumeId:      "1",
+		VolumePath:    "/target",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 150},
+	})
+	if err != nil {
+		t.Fatalf("NodeExpandVolume() unexpected error: %v", err)
+	}
+	if nodeResp.CapacityBytes != 150 {
+		t.Errorf("NodeExpandVolume() capacity = %d, want 150", nodeResp.CapacityBytes)
+	}
+	_, err = node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumeId: "1", VolumePath: "/other"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("NodeExpandVolume() of unknown path error = %v, want NotFound", err)
+	}
+}
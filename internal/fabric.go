@@ -0,0 +1,181 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// hostNQNFile keeps NVMe host NQN of the node
+const hostNQNFile = "/etc/nvme/hostnqn"
+
+// ReadHostNQN returns NVMe host NQN of the node. It's used as the node ID
+// in the fabric-direct mode, so the controller can zone volumes to the node.
+func ReadHostNQN(hostRoot string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(hostRoot, hostNQNFile))
+	if err != nil {
+		return "", fmt.Errorf("can't read host NQN: %v", err)
+	}
+	nqn := strings.TrimSpace(string(content))
+	if nqn == "" {
+		return "", fmt.Errorf("host NQN in %s is empty", hostNQNFile)
+	}
+	return nqn, nil
+}
+
+// SetFabricDirect makes the controller publish volumes by zoning the volume
+// endpoints with the node endpoint directly instead of attaching volumes to
+// RSD composed nodes. Node IDs are host NQNs of the nodes in this mode.
+func (drv *Driver) SetFabricDirect(enabled bool) {
+	drv.fabricDirect = enabled
+}
+
+// attachFabricDirect zones volume target endpoint with the initiator
+// endpoint of the host, creating the endpoints if they don't exist
+func (drv *Driver) attachFabricDirect(ctx context.Context, volume *Volume, hostNQN string) error {
+	client := drv.client(ctx)
+	fabric, err := rsd.GetFabricByID(ctx, client, "")
+	if err != nil {
+		return err
+	}
+
+	initiator, err := drv.getInitiatorEndPoint(ctx, fabric, hostNQN)
+	if err != nil {
+		return err
+	}
+
+	targets, err := drv.getTargetEndPoints(ctx, volume)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		target, err := fabric.NewEndPoint(ctx, client, rsd.NewTargetEndPointRequest(volume.RSDVolume.OdataID))
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	for _, target := range targets {
+		zones, err := target.GetZones(ctx, client)
+		if err != nil {
+			return err
+		}
+		for _, zone := range zones {
+			if zoneHasEndPoint(zone, initiator.OdataID) {
+				return nil
+			}
+		}
+	}
+
+	_, err = fabric.NewZone(ctx, client, []string{initiator.OdataID, targets[0].OdataID})
+	return err
+}
+
+// detachFabricDirect removes the host initiator endpoint from the zones
+// of the volume target endpoints. Zones left without initiators are deleted.
+func (drv *Driver) detachFabricDirect(ctx context.Context, volume *Volume, hostNQN string) error {
+	client := drv.client(ctx)
+	targets, err := drv.getTargetEndPoints(ctx, volume)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		zones, err := target.GetZones(ctx, client)
+		if err != nil {
+			return err
+		}
+		for _, zone := range zones {
+			endPoints, err := zone.GetEndPoints(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			var remaining []string
+			removed, initiators := false, 0
+			for _, endPoint := range endPoints {
+				if endPoint.IsInitiator() {
+					if endPoint.GetNQN() == hostNQN {
+						removed = true
+						continue
+					}
+					initiators++
+				}
+				remaining = append(remaining, endPoint.OdataID)
+			}
+			if !removed {
+				continue
+			}
+
+			if initiators == 0 {
+				err = zone.Delete(ctx, client)
+			} else {
+				err = zone.SetEndPoints(ctx, client, remaining)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getInitiatorEndPoint returns initiator endpoint of the host in the fabric,
+// the endpoint is created if it doesn't exist yet
+func (drv *Driver) getInitiatorEndPoint(ctx context.Context, fabric *rsd.Fabric, hostNQN string) (*rsd.EndPoint, error) {
+	client := drv.client(ctx)
+	endPoints, err := fabric.GetEndPoints(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	for _, endPoint := range endPoints {
+		if endPoint.IsInitiator() && endPoint.GetNQN() == hostNQN {
+			return endPoint, nil
+		}
+	}
+	return fabric.NewEndPoint(ctx, client, rsd.NewInitiatorEndPointRequest(hostNQN))
+}
+
+// getTargetEndPoints returns endpoints exposing the volume
+func (drv *Driver) getTargetEndPoints(ctx context.Context, volume *Volume) ([]*rsd.EndPoint, error) {
+	endPoints, err := volume.RSDVolume.GetEndPoints(ctx, drv.client(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var result []*rsd.EndPoint
+	for _, endPoint := range endPoints {
+		if !endPoint.IsInitiator() {
+			result = append(result, endPoint)
+		}
+	}
+	return result, nil
+}
+
+// zoneHasEndPoint checks if the zone contains the endpoint
+func zoneHasEndPoint(zone *rsd.Zone, endPointID string) bool {
+	for _, endPoint := range zone.Links.Endpoints {
+		if endPoint.OdataID == endPointID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// fabricClient is a TestClient recording changes of the fabric
+type fabricClient struct {
+	TestClient
+	// locations maps collections to the location of the created resources
+	locations map[string]string
+	posts     []string
+	patches   map[string]interface{}
+	deletes   []string
+}
+
+func (client *fabricClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.posts = append(client.posts, entrypoint)
+	return &http.Header{"Location": []string{client.locations[entrypoint]}}, nil
+}
+
+func (client *fabricClient) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.patches[entrypoint] = data
+	return nil, nil
+}
+
+func (client *fabricClient) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.deletes = append(client.deletes, entrypoint)
+	return nil, nil
+}
+
+func newFabricClient(results map[string]string) *fabricClient {
+	fabric := map[string]string{
+		"/redfish/v1/Fabrics":   `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1"}]}`,
+		"/redfish/v1/Fabrics/1": `{"Id": "1", "Zones": {"@odata.id": "/redfish/v1/Fabrics/1/Zones"}, "Endpoints": {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints"}}`,
+		"/redfish/v1/Fabrics/1/Endpoints/t1": `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1",
+			"ConnectedEntities": [{"EntityRole": "Target"}],
+			"Links": {"Oem": {"Intel_RackScale": {"Zones": [{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1"}]}}}}`,
+		"/redfish/v1/Fabrics/1/Endpoints/i1": `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i1",
+			"ConnectedEntities": [{"EntityRole": "Initiator"}],
+			"Identifiers": [{"DurableNameFormat": "NQN", "DurableName": "nqn.host1"}]}`,
+		"/redfish/v1/Fabrics/1/Endpoints/i2": `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i2",
+			"ConnectedEntities": [{"EntityRole": "Initiator"}],
+			"Identifiers": [{"DurableNameFormat": "NQN", "DurableName": "nqn.host2"}]}`,
+	}
+	for url, result := range results {
+		fabric[url] = result
+	}
+	return &fabricClient{
+		TestClient: TestClient{results: fabric},
+		locations: map[string]string{
+			"/redfish/v1/Fabrics/1/Endpoints": "/redfish/v1/Fabrics/1/Endpoints/i1",
+			"/redfish/v1/Fabrics/1/Zones":     "/redfish/v1/Fabrics/1/Zones/1",
+		},
+		patches: map[string]interface{}{},
+	}
+}
+
+func newFabricVolume(t *testing.T) *Volume {
+	var rsdVolume rsd.Volume
+	err := json.Unmarshal([]byte(`{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1",
+		"Links": {"Oem": {"Intel_RackScale": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}]}}}}`), &rsdVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Volume{Name: "pvc-1", RSDVolume: &rsdVolume}
+}
+
+func TestAttachFabricDirect(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   map[string]string
+		wantPosts []string
+	}{
+		{
+			name: "new initiator and zone",
+			results: map[string]string{
+				"/redfish/v1/Fabrics/1/Endpoints": `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}]}`,
+				"/redfish/v1/Fabrics/1/Zones/1":   `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1", "Links": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}]}}`,
+			},
+			wantPosts: []string{"/redfish/v1/Fabrics/1/Endpoints", "/redfish/v1/Fabrics/1/Zones"},
+		},
+		{
+			name: "already zoned",
+			results: map[string]string{
+				"/redfish/v1/Fabrics/1/Endpoints": `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i1"}]}`,
+				"/redfish/v1/Fabrics/1/Zones/1": `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1", "Links": {"Endpoints": [
+					{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i1"}]}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFabricClient(tt.results)
+			drv := &Driver{rsdClient: client}
+			if err := drv.attachFabricDirect(context.Background(), newFabricVolume(t), "nqn.host1"); err != nil {
+				t.Fatalf("attachFabricDirect() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(client.posts, tt.wantPosts) {
+				t.Errorf("attachFabricDirect() created %v, want %v", client.posts, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestDetachFabricDirect(t *testing.T) {
+	tests := []struct {
+		name        string
+		zone        string
+		wantDeletes []string
+		wantPatches int
+	}{
+		{
+			name: "zone of the host",
+			zone: `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1", "Links": {"Endpoints": [
+				{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i1"}]}}`,
+			wantDeletes: []string{"/redfish/v1/Fabrics/1/Zones/1"},
+		},
+		{
+			name: "zone shared with another host",
+			zone: `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1", "Links": {"Endpoints": [
+				{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i1"},
+				{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i2"}]}}`,
+			wantPatches: 1,
+		},
+		{
+			name: "zone of another host",
+			zone: `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1", "Links": {"Endpoints": [
+				{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/t1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/i2"}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFabricClient(map[string]string{"/redfish/v1/Fabrics/1/Zones/1": tt.zone})
+			drv := &Driver{rsdClient: client}
+			if err := drv.detachFabricDirect(context.Background(), newFabricVolume(t), "nqn.host1"); err != nil {
+				t.Fatalf("detachFabricDirect() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(client.deletes, tt.wantDeletes) {
+				t.Errorf("detachFabricDirect() deleted %v, want %v", client.deletes, tt.wantDeletes)
+			}
+			if len(client.patches) != tt.wantPatches {
+				t.Errorf("detachFabricDirect() patched %v, want %d patches", client.patches, tt.wantPatches)
+			}
+		})
+	}
+}
+
+func TestReadHostNQN(t *testing.T) {
+	hostRoot, err := ioutil.TempDir("", "csirsd-hostnqn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostRoot)
+
+	if _, err := ReadHostNQN(hostRoot); err == nil {
+		t.Error("ReadHostNQN() didn't fail without the host NQN file")
+	}
+
+	path := filepath.Join(hostRoot, hostNQNFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("nqn.2014-08.org.nvmexpress:uuid:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nqn, err := ReadHostNQN(hostRoot)
+	if err != nil {
+		t.Fatalf("ReadHostNQN() unexpected error: %v", err)
+	}
+	if want := "nqn.2014-08.org.nvmexpress:uuid:1"; nqn != want {
+		t.Errorf("ReadHostNQN() = %q, want %q", nqn, want)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"errors"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+// newFakeNode returns in-memory mounter and NVMe tools of the fake node.
+// It's nil unless the driver is built with the fakenode build tag.
+var newFakeNode func() (Mounter, NVMe)
+
+// SetFakeNode makes the node simulate formatting, mounting and NVMe
+// connections in memory instead of running the tools, so the driver runs
+// without root, nvme-cli and RDMA hardware, e.g. for csi-sanity node tests.
+// The node then has all the transports the driver supports, so it must be
+// called after SetTransportCheck. It fails unless the driver is built with
+// the fakenode build tag.
+func (drv *Driver) SetFakeNode() error {
+	if newFakeNode == nil {
+		return errors.New("fake node is not supported, the driver must be built with the fakenode build tag")
+	}
+	drv.fakeNode = true
+	drv.setTools()
+	if drv.transports != nil {
+		drv.transports = nodeTransports{}
+		for _, transport := range endpoint.Transports() {
+			drv.transports[transport] = nil
+		}
+	}
+	drv.logger.Warning("the node is fake, volumes are neither connected nor mounted")
+	return nil
+}
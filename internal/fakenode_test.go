@@ -0,0 +1,180 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build fakenode
+// +build fakenode
+
+package csirsd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestFakeNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-fake-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+
+	drv := &Driver{transports: nodeTransports{}}
+	if err := drv.SetFakeNode(); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := drv.transports["rdma"]; !ok || err != nil {
+		t.Errorf("fake node transports %v, want usable rdma", drv.transports)
+	}
+	m, n := drv.mounter, drv.nvme
+
+	device, err := n.Connect(context.Background(), "rdma", "10.0.0.1", "IPv4", "4420", "nqn.1", "nqn.host", FabricAuth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := n.List(); devices[device] != "nqn.1" {
+		t.Errorf("List() = %v, want %s of nqn.1", devices, device)
+	}
+	if err := m.Mount(device, staging, "ext4"); err == nil {
+		t.Error("Mount() of not formatted device unexpected success")
+	}
+	if err := m.Format(device, "ext4", "rsd-1"); err != nil {
+		t.Fatal(err)
+	}
+	if formatted, _ := m.IsFormatted(device); !formatted {
+		t.Error("IsFormatted() = false after Format()")
+	}
+	if err := m.Mount("LABEL=rsd-1", staging, "ext4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mount(staging, target, "ext4", "bind"); err != nil {
+		t.Fatal(err)
+	}
+	for source, target := range map[string]string{device: staging, staging: target, "": target} {
+		if mounted, _ := m.IsMounted(source, target); !mounted {
+			t.Errorf("IsMounted(%q, %q) = false", source, target)
+		}
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("Mount() didn't create the target: %v", err)
+	}
+
+	for _, path := range []string{target, staging} {
+		if err := m.Unmount(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Unmount(staging); err == nil {
+		t.Error("Unmount() of not mounted target unexpected success")
+	}
+	if err := n.Disconnect(device); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := n.List(); len(devices) != 0 {
+		t.Errorf("List() = %v after Disconnect()", devices)
+	}
+
+	// reconnected subsystem keeps its device and filesystem
+	reconnected, err := n.Connect(context.Background(), "rdma", "10.0.0.1", "IPv4", "4420", "nqn.1", "nqn.host", FabricAuth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label, _, _ := m.GetFilesystemIDs(reconnected); reconnected != device || label != "rsd-1" {
+		t.Errorf("reconnected device %s with label %q, want %s with rsd-1", reconnected, label, device)
+	}
+}
+
+func TestFakeNodeInterruptedFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-fake-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "journal.json")
+	staging := filepath.Join(dir, "staging")
+
+	newDriver := func() *Driver {
+		drv := &Driver{transports: nodeTransports{}}
+		if err := drv.SetFakeNode(); err != nil {
+			t.Fatal(err)
+		}
+		if err := drv.SetNodeJournal(journal); err != nil {
+			t.Fatal(err)
+		}
+		return drv
+	}
+	newVolume := func() *Volume {
+		return &Volume{
+			CSIVolume: &csi.Volume{VolumeId: "1"},
+			RSDVolume: &rsd.Volume{},
+			Name:      "Vol1",
+			EndPoint: &endpoint.Portal{
+				Transport: "rdma",
+				Address:   "10.0.0.1",
+				Port:      4420,
+				NQN:       "nqn.1",
+			},
+			IsPublished: true,
+		}
+	}
+
+	drv := newDriver()
+	m := drv.mounter.(*fakeMounter)
+	m.formatFaults = 1
+	if err := drv.nodeStageVolume(context.Background(), newVolume(), "ext4", nil, staging, nil, stageSecrets{}); err == nil {
+		t.Fatal("nodeStageVolume() succeeded with interrupted formatting")
+	}
+	device, err := drv.nvme.Connect(context.Background(), "rdma", "10.0.0.1", "", "4420", "nqn.1", "", FabricAuth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the half-written filesystem looks like the requested one
+	if fsType, _ := m.GetFilesystemType(device); fsType != "ext4" {
+		t.Fatalf("interrupted formatting left %q filesystem, want ext4", fsType)
+	}
+
+	// the driver restarted on the same node finds the fence in the journal
+	restarted := newDriver()
+	restarted.mounter, restarted.nvme = drv.mounter, drv.nvme
+	if _, fenced := restarted.journal.formatFence("1"); !fenced {
+		t.Fatal("no format fence in the journal after interrupted formatting")
+	}
+	volume := newVolume()
+	if err := restarted.nodeStageVolume(context.Background(), volume, "ext4", nil, staging, nil, stageSecrets{}); err != nil {
+		t.Fatal(err)
+	}
+	if label, _, _ := m.GetFilesystemIDs(device); label != "rsd-1" {
+		t.Errorf("filesystem label %q after formatting again, want rsd-1", label)
+	}
+	if volume.FSLabel != "rsd-1" || !volume.IsStaged {
+		t.Errorf("volume staged = %v with label %q, want staged with rsd-1", volume.IsStaged, volume.FSLabel)
+	}
+	if _, fenced := restarted.journal.formatFence("1"); fenced {
+		t.Error("format fence is kept after completed formatting")
+	}
+
+	// the completed filesystem isn't formatted again
+	m.formatFaults = 1
+	if err := restarted.nodeStageVolume(context.Background(), newVolume(), "ext4", nil, staging, nil, stageSecrets{}); err != nil {
+		t.Errorf("nodeStageVolume() of the formatted volume formatted it again: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build fakenode
+// +build fakenode
+
+package csirsd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Fake node keeps devices and mounts in memory, only mount targets are created
+func init() {
+	newFakeNode = func() (Mounter, NVMe) {
+		return newFakeMounter(), newFakeNVMe()
+	}
+}
+
+// fakeFilesystem is a filesystem formatted on a fake device
+type fakeFilesystem struct {
+	fsType string
+	label  string
+	uuid   string
+}
+
+// fakeMounter simulates formatting and mounting of the devices
+type fakeMounter struct {
+	mu sync.Mutex
+	// mounts maps mount targets to their sources
+	mounts map[string]string
+	// filesystems maps devices to their filesystems
+	filesystems map[string]fakeFilesystem
+	// formatFaults is the number of the next formats interrupted half-way,
+	// they leave the filesystem signature without its label like a crash during mkfs
+	formatFaults int
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: map[string]string{}, filesystems: map[string]fakeFilesystem{}}
+}
+
+// resolve returns the device of LABEL= and UUID= sources like mount does
+func (m *fakeMounter) resolve(source string) string {
+	for device, fs := range m.filesystems {
+		if source == "LABEL="+fs.label || source == "UUID="+fs.uuid {
+			return device
+		}
+	}
+	return source
+}
+
+func (m *fakeMounter) Mount(source, target, fsType string, opts ...string) error {
+	if fsType == "" {
+		return errors.New("fs type is not specified for mounting the volume")
+	}
+	if source == "" {
+		return errors.New("source is not specified for mounting the volume")
+	}
+	if target == "" {
+		return errors.New("target is not specified for mounting the volume")
+	}
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source = m.resolve(source)
+	if strings.HasPrefix(source, "/dev/") {
+		if _, formatted := m.filesystems[source]; !formatted {
+			return fmt.Errorf("mounting failed: %s is not formatted", source)
+		}
+	}
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) MountBlock(source, target string, opts ...string) error {
+	if source == "" {
+		return errors.New("source is not specified for mounting the volume")
+	}
+	if target == "" {
+		return errors.New("target is not specified for mounting the volume")
+	}
+	if err := createFile(target); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	if target == "" {
+		return errors.New("target is not specified for unmounting the volume")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, mounted := m.mounts[target]; !mounted {
+		return fmt.Errorf("unmounting failed: %s is not mounted", target)
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) RemoveTarget(target string) error {
+	if target == "" {
+		return errors.New("target is not specified for removing")
+	}
+	return removeTarget(target)
+}
+
+func (m *fakeMounter) IsMounted(source, target string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mounted, ok := m.mounts[target]
+	return ok && (source == "" || m.resolve(source) == mounted), nil
+}
+
+func (m *fakeMounter) IsFormatted(source string) (bool, error) {
+	if source == "" {
+		return false, errors.New("source is not specified")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, formatted := m.filesystems[source]
+	return formatted, nil
+}
+
+func (m *fakeMounter) GetFilesystemType(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("source is not specified")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filesystems[source].fsType, nil
+}
+
+// IsFilesystemEmpty reports all fake filesystems as empty, as they hold no files
+func (m *fakeMounter) IsFilesystemEmpty(source, fsType, target string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if fs, formatted := m.filesystems[source]; !formatted || fs.fsType != fsType {
+		return false, fmt.Errorf("mounting failed: %s is not formatted as %s", source, fsType)
+	}
+	return true, nil
+}
+
+func (m *fakeMounter) Format(source, fsType, label string, opts ...string) error {
+	if fsType == "" {
+		return errors.New("fs type is not specified for formatting the volume")
+	}
+	if source == "" {
+		return errors.New("source is not specified for formatting the volume")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.formatFaults > 0 {
+		m.formatFaults--
+		m.filesystems[source] = fakeFilesystem{fsType: fsType}
+		return fmt.Errorf("formatting disk failed: mkfs.%s of %s interrupted", fsType, source)
+	}
+	m.filesystems[source] = fakeFilesystem{
+		fsType: fsType,
+		label:  label,
+		uuid:   fmt.Sprintf("00000000-0000-4000-8000-%012d", len(m.filesystems)+1),
+	}
+	return nil
+}
+
+func (m *fakeMounter) GetFilesystemIDs(source string) (string, string, error) {
+	if source == "" {
+		return "", "", errors.New("source is not specified")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := m.filesystems[source]
+	return fs.label, fs.uuid, nil
+}
+
+func (m *fakeMounter) ResizeFilesystem(source, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, formatted := m.filesystems[source]; !formatted {
+		return fmt.Errorf("resizing filesystem failed: %s is not formatted", source)
+	}
+	return nil
+}
+
+// fakeNVMe simulates connections of the NVMe subsystems. Every subsystem
+// gets the same device on reconnect, so its filesystem is kept.
+type fakeNVMe struct {
+	mu sync.Mutex
+	// devices maps subsystem NQNs to their devices
+	devices map[string]string
+	// connected are the connected devices
+	connected map[string]bool
+}
+
+func newFakeNVMe() *fakeNVMe {
+	return &fakeNVMe{devices: map[string]string{}, connected: map[string]bool{}}
+}
+
+func (n *fakeNVMe) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error) {
+	if transport == "" || traddr == "" || nqn == "" {
+		return "", fmt.Errorf("connecting to %s failed: transport, address and NQN are required", nqn)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	device, ok := n.devices[nqn]
+	if !ok {
+		device = fmt.Sprintf("/dev/nvme%dn1", len(n.devices))
+		n.devices[nqn] = device
+	}
+	n.connected[device] = true
+	return device, nil
+}
+
+func (n *fakeNVMe) Disconnect(device string) error {
+	if device == "" {
+		return errors.New("device node is empty string")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.connected[device] {
+		return fmt.Errorf("device %s is not connected", device)
+	}
+	delete(n.connected, device)
+	return nil
+}
+
+func (n *fakeNVMe) SmartLog(device string) (*SmartLog, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.connected[device] {
+		return nil, fmt.Errorf("device %s is not connected", device)
+	}
+	// 25 Celsius in Kelvins
+	return &SmartLog{Temperature: 298}, nil
+}
+
+func (n *fakeNVMe) List() (map[string]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	devices := map[string]string{}
+	for nqn, device := range n.devices {
+		if n.connected[device] {
+			devices[device] = nqn
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// checkFencing verifies that the volume is attached only to the node NQN,
+// i.e. zones of the volume target endpoints don't contain initiator
+// endpoints of other hosts. Formatting the volume still mounted by another
+// host would destroy its data, which can happen if attach bookkeeping drifts.
+func (drv *Driver) checkFencing(ctx context.Context, volume *Volume) error {
+	client := drv.client(ctx)
+	targets, err := volume.RSDVolume.GetEndPoints(ctx, client)
+	if err != nil {
+		return errors.Wrap(err, "fencing check failed")
+	}
+
+	for _, target := range targets {
+		zones, err := target.GetZones(ctx, client)
+		if err != nil {
+			return errors.Wrap(err, "fencing check failed")
+		}
+		for _, zone := range zones {
+			endPoints, err := zone.GetEndPoints(ctx, client)
+			if err != nil {
+				return errors.Wrap(err, "fencing check failed")
+			}
+			for _, endPoint := range endPoints {
+				if !endPoint.IsInitiator() {
+					continue
+				}
+				if nqn := endPoint.GetNQN(); nqn != volume.RSDNodeNQN {
+					return fmt.Errorf("fencing check failed: volume %s is also attached to the initiator %s (NQN %q) in the zone %s",
+						volume.Name, endPoint.OdataID, nqn, zone.OdataID)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestCheckFencing(t *testing.T) {
+	const (
+		target = `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1",
+			"ConnectedEntities": [{"EntityRole": "Target"}],
+			"Links": {"Oem": {"Intel_RackScale": {"Zones": [{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1"}]}}}}`
+		zone = `{"@odata.id": "/redfish/v1/Fabrics/1/Zones/1",
+			"Links": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/2"}, {"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/3"}]}}`
+		node = `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/2",
+			"ConnectedEntities": [{"EntityRole": "Initiator"}],
+			"Identifiers": [{"DurableNameFormat": "NQN", "DurableName": "nqn.2014-08.org.nvmexpress:uuid:node1"}]}`
+	)
+	tests := []struct {
+		name    string
+		other   string
+		wantErr bool
+	}{
+		{
+			name: "attached only to the node",
+			// another target of the same zone is ignored
+			other: `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/3", "ConnectedEntities": [{"EntityRole": "Target"}]}`,
+		},
+		{
+			name: "attached also to another host",
+			other: `{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/3",
+				"ConnectedEntities": [{"EntityRole": "Initiator"}],
+				"Identifiers": [{"DurableNameFormat": "NQN", "DurableName": "nqn.2014-08.org.nvmexpress:uuid:node2"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "zone endpoint query failure",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := map[string]string{
+				"/redfish/v1/Fabrics/1/Endpoints/1": target,
+				"/redfish/v1/Fabrics/1/Endpoints/2": node,
+				"/redfish/v1/Fabrics/1/Zones/1":     zone,
+			}
+			if tt.other != "" {
+				results["/redfish/v1/Fabrics/1/Endpoints/3"] = tt.other
+			}
+			var rsdVolume rsd.Volume
+			volumeJSON := `{"Links": {"Oem": {"Intel_RackScale": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1"}]}}}}`
+			if err := json.NewDecoder(strings.NewReader(volumeJSON)).Decode(&rsdVolume); err != nil {
+				t.Fatal(err)
+			}
+			drv := &Driver{rsdClient: &TestClient{results: results}}
+			volume := &Volume{
+				Name:       "pvc-1",
+				RSDVolume:  &rsdVolume,
+				RSDNodeNQN: "nqn.2014-08.org.nvmexpress:uuid:node1",
+			}
+
+			err := drv.checkFencing(context.Background(), volume)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkFencing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const (
+	// defaultFsType is the filesystem of the volumes requested without it,
+	// unless the supported filesystems are configured
+	defaultFsType = "ext4"
+	// manifestSupportedFsTypes is the GetPluginInfo manifest key listing
+	// the supported filesystems
+	manifestSupportedFsTypes = "supported-fstypes"
+)
+
+// formatFilesystems are the filesystems the driver formats volumes with
+var formatFilesystems = map[string]bool{"ext2": true, "ext3": true, "ext4": true, "xfs": true}
+
+// formatFilesystemList returns the sorted formatFilesystems
+func formatFilesystemList() []string {
+	var list []string
+	for fsType := range formatFilesystems {
+		list = append(list, fsType)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// SetSupportedFilesystems limits the filesystems the volumes may be formatted
+// with, e.g. to the ones the node kernels and backup tools support. Volumes
+// requested without a filesystem are formatted with the first one. All
+// filesystems the driver formats volumes with are allowed if the list is empty.
+func (drv *Driver) SetSupportedFilesystems(fsTypes []string) error {
+	var filesystems []string
+	seen := map[string]bool{}
+	for _, fsType := range fsTypes {
+		fsType = strings.ToLower(strings.TrimSpace(fsType))
+		if fsType == "" || seen[fsType] {
+			continue
+		}
+		if !formatFilesystems[fsType] {
+			return fmt.Errorf("unsupported filesystem %q, the volumes can be formatted with %s", fsType, strings.Join(formatFilesystemList(), ", "))
+		}
+		seen[fsType] = true
+		filesystems = append(filesystems, fsType)
+	}
+	drv.filesystems = filesystems
+	return nil
+}
+
+// fsType returns the filesystem the volume is formatted with, the default
+// one if it's not requested
+func (drv *Driver) fsType(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if len(drv.filesystems) > 0 {
+		return drv.filesystems[0]
+	}
+	return defaultFsType
+}
+
+// validateFsType checks if the filesystem of the mount capability is supported,
+// i.e. it's configured or the driver formats volumes with it
+func (drv *Driver) validateFsType(capability *csi.VolumeCapability) error {
+	mnt := capability.GetMount()
+	if mnt == nil {
+		return nil
+	}
+	fsType := drv.fsType(mnt.FsType)
+	if len(drv.filesystems) == 0 {
+		if formatFilesystems[fsType] {
+			return nil
+		}
+		return fmt.Errorf("unsupported filesystem %s, supported filesystems: %s", fsType, strings.Join(formatFilesystemList(), ", "))
+	}
+	for _, supported := range drv.filesystems {
+		if fsType == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported filesystem %s, supported filesystems: %s", fsType, strings.Join(drv.filesystems, ", "))
+}
+
+// validateFsTypes checks if the filesystems of the capabilities are supported
+func (drv *Driver) validateFsTypes(caps []*csi.VolumeCapability) error {
+	for _, capability := range caps {
+		if err := drv.validateFsType(capability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
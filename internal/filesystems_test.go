@@ -0,0 +1,117 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountCapability returns single node writer capability of the filesystem
+func mountCapability(fsType string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func TestSetSupportedFilesystems(t *testing.T) {
+	tests := []struct {
+		fsTypes     []string
+		want        []string
+		wantDefault string
+		wantErr     bool
+	}{
+		{fsTypes: nil, want: nil, wantDefault: "ext4"},
+		{fsTypes: []string{"xfs", " EXT4", "xfs"}, want: []string{"xfs", "ext4"}, wantDefault: "xfs"},
+		{fsTypes: []string{"../ext4"}, wantErr: true},
+		{fsTypes: []string{"ext4", "btrfs"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		drv := &Driver{}
+		err := drv.SetSupportedFilesystems(tt.fsTypes)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("SetSupportedFilesystems(%v) error = %v, wantErr %v", tt.fsTypes, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(drv.filesystems, tt.want) {
+			t.Errorf("SetSupportedFilesystems(%v) = %v, want %v", tt.fsTypes, drv.filesystems, tt.want)
+		}
+		if got := drv.fsType(""); got != tt.wantDefault {
+			t.Errorf("SetSupportedFilesystems(%v) default filesystem = %s, want %s", tt.fsTypes, got, tt.wantDefault)
+		}
+	}
+}
+
+func TestValidateFsTypeAllowlist(t *testing.T) {
+	drv := &Driver{}
+	for _, fsType := range []string{"", "ext2", "ext3", "ext4", "xfs"} {
+		if err := drv.validateFsType(mountCapability(fsType)); err != nil {
+			t.Errorf("validateFsType(%q) unexpected error: %v", fsType, err)
+		}
+	}
+	for _, fsType := range []string{"btrfs", "vfat", "../ext4"} {
+		if err := drv.validateFsType(mountCapability(fsType)); err == nil {
+			t.Errorf("validateFsType(%q) accepted filesystem the driver doesn't format volumes with", fsType)
+		}
+	}
+}
+
+func TestSupportedFilesystemsValidation(t *testing.T) {
+	drv := &Driver{volumes: map[string]*Volume{}}
+	if err := drv.SetSupportedFilesystems([]string{"xfs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() of unsupported filesystem error = %v, want InvalidArgument", err)
+	}
+
+	_, err = drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: "/staging",
+		VolumeCapability:  mountCapability(""),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("NodeStageVolume() of the default filesystem error = %v, want NotFound of the volume", err)
+	}
+	_, err = drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: "/staging",
+		VolumeCapability:  mountCapability("btrfs"),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodeStageVolume() of unsupported filesystem error = %v, want InvalidArgument", err)
+	}
+
+	info, err := drv.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginInfo() unexpected error: %v", err)
+	}
+	if got := info.Manifest[manifestSupportedFsTypes]; got != "xfs" {
+		t.Errorf("GetPluginInfo() manifest %s = %q, want xfs", manifestSupportedFsTypes, got)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+)
+
+// journalFormat is the format fence of the volume. It's recorded in the node
+// journal before formatting the volume device and removed once the written
+// filesystem is verified, so it outlives only interrupted formatting.
+type journalFormat struct {
+	VolumeID string `json:"volumeId"`
+	Device   string `json:"device"`
+	FSType   string `json:"fsType"`
+}
+
+// fenceFormat records the format fence of the volume formatted on the device
+func (journal *nodeJournal) fenceFormat(volumeID, device, fsType string) error {
+	if journal == nil {
+		return nil
+	}
+	journal.formats[volumeID] = journalFormat{VolumeID: volumeID, Device: device, FSType: fsType}
+	return journal.save()
+}
+
+// unfenceFormat removes the format fence of the volume
+func (journal *nodeJournal) unfenceFormat(volumeID string) error {
+	if journal == nil {
+		return nil
+	}
+	if _, fenced := journal.formats[volumeID]; !fenced {
+		return nil
+	}
+	delete(journal.formats, volumeID)
+	return journal.save()
+}
+
+// formatFence returns the format fence of the volume, if any
+func (journal *nodeJournal) formatFence(volumeID string) (journalFormat, bool) {
+	if journal == nil {
+		return journalFormat{}, false
+	}
+	format, fenced := journal.formats[volumeID]
+	return format, fenced
+}
+
+// formatIncomplete checks if the previous formatting of the volume was
+// interrupted, e.g. by a crash of the node during mkfs. The half-written
+// filesystem can look complete, so the volume must be formatted again.
+func (drv *Driver) formatIncomplete(volume *Volume) bool {
+	drv.volumesRWL.RLock()
+	format, fenced := drv.journal.formatFence(volume.CSIVolume.VolumeId)
+	drv.volumesRWL.RUnlock()
+	if fenced {
+		drv.logger.Warning("Previous formatting of the volume is incomplete, it's formatted again", "volume", volume.Name, "device", format.Device, "filesystem", format.FSType)
+	}
+	return fenced
+}
+
+// formatVolume formats the volume device with the mkfs arguments within the
+// format fence. The fence is kept if formatting fails, so it's redone when the
+// volume is staged again.
+func (drv *Driver) formatVolume(volume *Volume, dev, fsType, label string, mkfsOpts []string) error {
+	volumeID := volume.CSIVolume.VolumeId
+	drv.volumesRWL.Lock()
+	err := drv.journal.fenceFormat(volumeID, dev, fsType)
+	drv.volumesRWL.Unlock()
+	if err != nil {
+		return fmt.Errorf("can't record format fence of the volume %s: %v", volume.Name, err)
+	}
+
+	if err := drv.mounter.Format(dev, fsType, label, mkfsOpts...); err != nil {
+		return err
+	}
+
+	drv.volumesRWL.Lock()
+	err = drv.journal.unfenceFormat(volumeID)
+	drv.volumesRWL.Unlock()
+	if err != nil {
+		// the volume isn't staged yet, so formatting it again loses no data
+		return fmt.Errorf("can't remove format fence of the volume %s: %v", volume.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatFence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.json")
+
+	drv := &Driver{}
+	if err := drv.SetNodeJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.journal.fenceFormat("1", "/dev/nvme1n1", "ext4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fence survives the driver restart
+	restarted := &Driver{}
+	if err := restarted.SetNodeJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	want := journalFormat{VolumeID: "1", Device: "/dev/nvme1n1", FSType: "ext4"}
+	if got, fenced := restarted.journal.formatFence("1"); !fenced || got != want {
+		t.Errorf("formatFence() = %v, %v after restart, want %v", got, fenced, want)
+	}
+	if _, fenced := restarted.journal.formatFence("2"); fenced {
+		t.Error("formatFence() of not formatted volume is fenced")
+	}
+
+	if err := restarted.journal.unfenceFormat("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.SetNodeJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, fenced := restarted.journal.formatFence("1"); fenced {
+		t.Error("formatFence() is fenced after unfenceFormat()")
+	}
+
+	// disabled journal keeps no fences
+	var disabled *nodeJournal
+	if err := disabled.fenceFormat("1", "/dev/nvme1n1", "ext4"); err != nil {
+		t.Errorf("fenceFormat() of disabled journal error: %v", err)
+	}
+	if _, fenced := disabled.formatFence("1"); fenced {
+		t.Error("formatFence() of disabled journal is fenced")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// abnormalVolumeStates are Redfish Status.State values of the RSD volumes
+// which can't serve I/O
+var abnormalVolumeStates = map[string]bool{
+	"Absent":             true,
+	"Disabled":           true,
+	"Quiesced":           true,
+	"StandbyOffline":     true,
+	"UnavailableOffline": true,
+}
+
+// rsdVolumeCondition returns the condition of the RSD volume from its Redfish
+// Status. The volume is abnormal if its state can't serve I/O or its health
+// is Warning or Critical, the RSD operation in progress is reported as the
+// message of the normal volume.
+func rsdVolumeCondition(rsdVolume *rsd.Volume) *csi.VolumeCondition {
+	state, health := rsdVolume.Status.State, rsdVolume.Status.Health
+	if abnormalVolumeStates[state] || (health != "" && health != "OK") {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("RSD volume %s is %s with health %s", rsdVolume.ID, state, health),
+		}
+	}
+	if operation, percent := volumeOperation(rsdVolume); operation != "" {
+		return &csi.VolumeCondition{
+			Message: fmt.Sprintf("RSD operation %s of the volume is %d%% complete", operation, percent),
+		}
+	}
+	return &csi.VolumeCondition{Message: "volume is healthy"}
+}
+
+// ControllerGetVolume returns the volume with the node it's published to and
+// its condition read from RSD
+func (drv *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("ControllerGetVolume request", "request", req)
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "ControllerGetVolume: Volume ID is missing")
+	}
+
+	// query RSD without holding the lock
+	drv.volumesRWL.RLock()
+	_, volume := drv.findVolByID(req.VolumeId)
+	if volume == nil {
+		drv.volumesRWL.RUnlock()
+		return nil, status.Errorf(codes.NotFound, "ControllerGetVolume: No volume with id '%s' found", req.VolumeId)
+	}
+	csiVolume := proto.Clone(volume.CSIVolume).(*csi.Volume)
+	var publishedNodeIDs []string
+	if volume.IsPublished {
+		publishedNodeIDs = []string{volume.RSDNodeID}
+	}
+	odataID := volume.RSDVolume.OdataID
+	client := drv.client(withVolumeRSDEndpoint(ctx, volume))
+	drv.volumesRWL.RUnlock()
+
+	var condition *csi.VolumeCondition
+	var rsdVolume rsd.Volume
+	err := rsd.GetByOdataID(ctx, client, odataID, &rsdVolume)
+	switch {
+	case rsd.Classify(err) == rsd.CategoryNotFound:
+		condition = &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("RSD volume %s is gone", odataID)}
+	case err != nil:
+		return nil, status.Errorf(rsdStatusCode(rsd.Classify(err), codes.Internal), "ControllerGetVolume: can't get RSD volume %s: %v", odataID, err)
+	default:
+		condition = rsdVolumeCondition(&rsdVolume)
+	}
+
+	resp := &csi.ControllerGetVolumeResponse{
+		Volume: csiVolume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs,
+			VolumeCondition:  condition,
+		},
+	}
+	logger.V(LogLevelRequest).Info("ControllerGetVolume response", "response", resp)
+	return resp, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// goneClient reports every RSD resource as not found
+type goneClient struct {
+	TestClient
+}
+
+func (*goneClient) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	return &rsd.HTTPError{StatusCode: http.StatusNotFound, URL: entrypoint}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	const odataID = "/redfish/v1/StorageServices/1/Volumes/1"
+	tests := []struct {
+		name         string
+		rsdVolume    string
+		client       rsd.Transport
+		wantAbnormal bool
+		wantMessage  string
+		wantCode     codes.Code
+	}{
+		{name: "healthy", rsdVolume: `{"Id": "1", "Status": {"State": "Enabled", "Health": "OK"}}`, wantMessage: "healthy"},
+		{name: "no status", rsdVolume: `{"Id": "1"}`, wantMessage: "healthy"},
+		{name: "initializing", rsdVolume: `{"Id": "1", "Status": {"State": "Starting", "Health": "OK"}, "Operations": [{"OperationName": "Initialize", "PercentageComplete": 40}]}`,
+			wantMessage: "Initialize of the volume is 40% complete"},
+		{name: "critical", rsdVolume: `{"Id": "1", "Status": {"State": "Enabled", "Health": "Critical"}}`, wantAbnormal: true, wantMessage: "Enabled with health Critical"},
+		{name: "offline", rsdVolume: `{"Id": "1", "Status": {"State": "UnavailableOffline", "Health": "OK"}}`, wantAbnormal: true, wantMessage: "UnavailableOffline"},
+		{name: "gone", client: &goneClient{}, wantAbnormal: true, wantMessage: "is gone"},
+		{name: "RSD failure", client: &TestClient{}, wantCode: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client
+			if client == nil {
+				client = &TestClient{results: map[string]string{odataID: tt.rsdVolume}}
+			}
+			drv := &Driver{rsdClient: client, volumes: map[string]*Volume{"pvc-1": {
+				Name:        "pvc-1",
+				CSIVolume:   &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+				RSDVolume:   &rsd.Volume{OdataID: odataID, ID: "1"},
+				RSDNodeID:   "2",
+				IsPublished: true,
+			}}}
+
+			resp, err := drv.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "1"})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("ControllerGetVolume() error = %v, want %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if resp.Volume.VolumeId != "1" || resp.Volume.CapacityBytes != 100 {
+				t.Errorf("ControllerGetVolume() volume %v, want volume 1 of capacity 100", resp.Volume)
+			}
+			if nodes := resp.Status.PublishedNodeIds; len(nodes) != 1 || nodes[0] != "2" {
+				t.Errorf("ControllerGetVolume() published node IDs %v, want [2]", nodes)
+			}
+			condition := resp.Status.VolumeCondition
+			if condition.Abnormal != tt.wantAbnormal || !strings.Contains(condition.Message, tt.wantMessage) {
+				t.Errorf("ControllerGetVolume() condition %v, want abnormal %v with message %q", condition, tt.wantAbnormal, tt.wantMessage)
+			}
+		})
+	}
+
+	drv := &Driver{volumes: map[string]*Volume{}}
+	if _, err := drv.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("ControllerGetVolume() of unknown volume error = %v, want NotFound", err)
+	}
+}
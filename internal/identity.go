@@ -16,48 +16,97 @@ package csirsd
 
 import (
 	"context"
-	"log"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// healthServices are the services the driver reports health status for.
+// Empty name stands for the overall health of the server.
+var healthServices = []string{"", "csi.v1.Identity", "csi.v1.Controller", "csi.v1.Node"}
+
 // GetPluginInfo returns metadata of the plugin
 func (drv *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
-	log.Printf("GetPluginInfo request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("GetPluginInfo request", "request", req)
 
 	resp := &csi.GetPluginInfoResponse{
 		Name:          DriverName,
 		VendorVersion: DriverVersion,
 	}
+	if len(drv.filesystems) > 0 {
+		resp.Manifest = map[string]string{manifestSupportedFsTypes: strings.Join(drv.filesystems, ",")}
+	}
 
-	log.Printf("GetPluginInfo response: %v", resp)
+	logger.V(LogLevelRequest).Info("GetPluginInfo response", "response", resp)
 	return resp, nil
 }
 
 // GetPluginCapabilities returns available capabilities of the plugin
 func (drv *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	log.Printf("GetPluginCapabilities request: %v", req)
-
-	resp := &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("GetPluginCapabilities request", "request", req)
+
+	resp := &csi.GetPluginCapabilitiesResponse{}
+	if drv.servesController() {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
 				},
 			},
-		},
+		})
+	}
+	// the filesystem is grown while the volume is published, volumes of
+	// the read only access modes alone can't be expanded
+	if isExpandable() {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+				},
+			},
+		})
+	}
+	if drv.topology {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+				},
+			},
+		})
 	}
 
-	log.Printf("GetPluginCapabilities response: %v", resp)
+	logger.V(LogLevelRequest).Info("GetPluginCapabilities response", "response", resp)
 	return resp, nil
 }
 
+// setReady sets readiness of the driver reported by Probe() and gRPC health service
+func (drv *Driver) setReady(ready bool) {
+	drv.readyMu.Lock()
+	defer drv.readyMu.Unlock()
+
+	drv.ready = ready
+
+	if drv.health == nil {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range healthServices {
+		drv.health.SetServingStatus(service, status)
+	}
+}
+
 // Probe returns the health and readiness of the plugin
 func (drv *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	log.Printf("Probe request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("Probe request", "request", req)
 
 	drv.readyMu.Lock()
 	defer drv.readyMu.Unlock()
@@ -68,6 +117,6 @@ func (drv *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.Probe
 		},
 	}
 
-	log.Printf("Probe response: %v", resp)
+	logger.V(LogLevelRequest).Info("Probe response", "response", resp)
 	return resp, nil
 }
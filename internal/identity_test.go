@@ -16,11 +16,13 @@ package csirsd
 
 import (
 	"context"
-	"reflect"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func TestDriver_GetPluginInfo(t *testing.T) {
@@ -32,7 +34,7 @@ func TestDriver_GetPluginInfo(t *testing.T) {
 			return
 		}
 		expected := &csi.GetPluginInfoResponse{Name: DriverName, VendorVersion: DriverVersion}
-		if !reflect.DeepEqual(got, expected) {
+		if !proto.Equal(got, expected) {
 			t.Errorf("Driver.GetPluginInfo() = %v, want %v", got, expected)
 		}
 	})
@@ -55,9 +57,16 @@ func TestDriver_GetPluginCapabilities(t *testing.T) {
 						},
 					},
 				},
+				{
+					Type: &csi.PluginCapability_VolumeExpansion_{
+						VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+							Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+						},
+					},
+				},
 			},
 		}
-		if !reflect.DeepEqual(got, expected) {
+		if !proto.Equal(got, expected) {
 			t.Errorf("Driver.GetPluginInfo() = %v, want %v", got, expected)
 		}
 	})
@@ -79,9 +88,38 @@ func TestDriver_Probe(t *testing.T) {
 				t.Errorf("Driver.Probe() unexpected error: %v", err)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.Probe() = %v, want %+v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDriver_SetReady(t *testing.T) {
+	drv := &Driver{health: health.NewServer()}
+	for _, ready := range []bool{false, true, false} {
+		drv.setReady(ready)
+
+		want := healthpb.HealthCheckResponse_NOT_SERVING
+		if ready {
+			want = healthpb.HealthCheckResponse_SERVING
+		}
+		for _, service := range healthServices {
+			resp, err := drv.health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatalf("health check of service %q: unexpected error: %v", service, err)
+			}
+			if resp.Status != want {
+				t.Errorf("health status of service %q = %v, want %v", service, resp.Status, want)
+			}
+		}
+
+		probe, err := drv.Probe(context.Background(), &csi.ProbeRequest{})
+		if err != nil {
+			t.Fatalf("Driver.Probe() unexpected error: %v", err)
+		}
+		if probe.Ready.Value != ready {
+			t.Errorf("Driver.Probe() ready = %v, want %v", probe.Ready.Value, ready)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+// Extended attributes of the staging path root with the identity of the
+// volume staged there, so node agents which see only the mounts can
+// attribute the I/O to the RSD volume without querying the driver
+const (
+	// VolumeIDXattr is the CSI volume ID
+	VolumeIDXattr = "user." + DriverName + ".volume-id"
+	// RSDVolumeXattr is @odata.id of the RSD volume
+	RSDVolumeXattr = "user." + DriverName + ".rsd-volume"
+	// SubsystemNQNXattr is the NVMe subsystem NQN the volume is connected with
+	SubsystemNQNXattr = "user." + DriverName + ".subsystem-nqn"
+)
+
+// SetIdentityXattrs makes the node set the identity of the staged volumes as
+// extended attributes of the staging path: the root of the volume filesystem
+// or the staging directory of block volumes. The filesystem must support
+// user extended attributes. The path is looked up in the host root the node
+// tools run in.
+func (drv *Driver) SetIdentityXattrs(enabled bool) {
+	drv.identityXattrs = enabled
+}
+
+// setIdentityXattrs sets the identity of the staged volume on the staging
+// path. Failures are only logged, as the volume is usable without them.
+func (drv *Driver) setIdentityXattrs(volume *Volume, stagingTargetPath string) {
+	if !drv.identityXattrs {
+		return
+	}
+	set := drv.setXattr
+	if set == nil {
+		set = setXattr
+	}
+	// the staging path is in the host root the node tools run in
+	path := stagingTargetPath
+	if drv.execer != nil {
+		path = drv.execer.HostPath(stagingTargetPath)
+	}
+
+	attrs := [][2]string{{VolumeIDXattr, volume.CSIVolume.VolumeId}}
+	if volume.RSDVolume != nil {
+		attrs = append(attrs, [2]string{RSDVolumeXattr, volume.RSDVolume.OdataID})
+	}
+	if volume.EndPoint != nil && volume.EndPoint.NQN != "" {
+		attrs = append(attrs, [2]string{SubsystemNQNXattr, volume.EndPoint.NQN})
+	}
+	for _, attr := range attrs {
+		if err := set(path, attr[0], attr[1]); err != nil {
+			drv.logger.Warning("can't set volume identity on the staging path", "volume", volume.Name, "staging_target_path", stagingTargetPath, "xattr", attr[0], "error", err)
+			return
+		}
+	}
+}
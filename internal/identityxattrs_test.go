@@ -0,0 +1,70 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestSetIdentityXattrs(t *testing.T) {
+	volume := newVolumeRecord("pvc-1", &rsd.Volume{ID: "1", OdataID: "/redfish/v1/StorageServices/1/Volumes/1"})
+	volume.EndPoint = &endpoint.Portal{NQN: "nqn.2014-08.org.nvmexpress:uuid:1"}
+
+	xattrs := map[string]string{}
+	drv := &Driver{setXattr: func(path, name, value string) error {
+		if path != "/staging/pvc-1" {
+			return errors.New("unexpected path " + path)
+		}
+		xattrs[name] = value
+		return nil
+	}}
+
+	drv.setIdentityXattrs(volume, "/staging/pvc-1")
+	if len(xattrs) != 0 {
+		t.Errorf("xattrs %v are set while they're disabled", xattrs)
+	}
+
+	drv.SetIdentityXattrs(true)
+	drv.setIdentityXattrs(volume, "/staging/pvc-1")
+	want := map[string]string{
+		VolumeIDXattr:     "1",
+		RSDVolumeXattr:    "/redfish/v1/StorageServices/1/Volumes/1",
+		SubsystemNQNXattr: "nqn.2014-08.org.nvmexpress:uuid:1",
+	}
+	if !reflect.DeepEqual(xattrs, want) {
+		t.Errorf("xattrs = %v, want %v", xattrs, want)
+	}
+
+	// the staging path is set in the host root
+	xattrs = map[string]string{}
+	drv.SetHostRoot("/host")
+	drv.setXattr = func(path, name, value string) error {
+		xattrs[path+" "+name] = value
+		return nil
+	}
+	drv.setIdentityXattrs(volume, "/staging/pvc-1")
+	if xattrs["/host/staging/pvc-1 "+VolumeIDXattr] != "1" {
+		t.Errorf("xattrs = %v, want them set on /host/staging/pvc-1", xattrs)
+	}
+
+	// failures don't fail the staging
+	drv.setXattr = func(path, name, value string) error { return errors.New("operation not supported") }
+	drv.setIdentityXattrs(volume, "/staging/pvc-1")
+}
@@ -0,0 +1,327 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// journalVersion is the version of the node journal data written by the
+// driver. It's increased with a migration in journalMigrations whenever
+// the data changes incompatibly. New optional fields don't need it.
+const journalVersion = 1
+
+// journalChecksumPrefix names the algorithm of the journal checksum
+const journalChecksumPrefix = "sha256:"
+
+// journalMigrations upgrade journal data of the version to the next one,
+// they are applied in order to journals written by older drivers
+var journalMigrations = map[int]func(data json.RawMessage) (json.RawMessage, error){}
+
+// journalFile is the wire format of the node journal. Checksum covers
+// the data exactly as written, so partial or corrupted writes are detected.
+type journalFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// journalData are the journal records of the staged volumes and the format
+// fences of the volumes being formatted
+type journalData struct {
+	Volumes []journalVolume `json:"volumes"`
+	Formats []journalFormat `json:"formats,omitempty"`
+}
+
+// journalVolume is the staged state of the volume on the node
+type journalVolume struct {
+	VolumeID          string   `json:"volumeId"`
+	Name              string   `json:"name"`
+	StagingTargetPath string   `json:"stagingTargetPath"`
+	Device            string   `json:"device"`
+	NQN               string   `json:"nqn,omitempty"`
+	DeviceByID        string   `json:"deviceById,omitempty"`
+	FSLabel           string   `json:"fsLabel,omitempty"`
+	FSUUID            string   `json:"fsUuid,omitempty"`
+	Block             bool     `json:"block,omitempty"`
+	TargetPaths       []string `json:"targetPaths,omitempty"`
+}
+
+// nodeJournal keeps staged state of the volumes in a file on the node, so
+// it's restored after the driver restart. All methods are no-op for nil
+// nodeJournal. It's protected by drv.volumesRWL.
+type nodeJournal struct {
+	path string
+	// volumes are the records of the staged volumes by volume ID
+	volumes map[string]journalVolume
+	// formats are the format fences of the volumes by volume ID
+	formats map[string]journalFormat
+}
+
+// journalChecksum returns checksum of the journal data
+func journalChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return journalChecksumPrefix + hex.EncodeToString(sum[:])
+}
+
+// journalVersionError is returned for the journal written by a newer driver
+type journalVersionError struct {
+	version int
+}
+
+func (e *journalVersionError) Error() string {
+	return fmt.Sprintf("journal version %d is newer than version %d supported by the driver", e.version, journalVersion)
+}
+
+// migrateJournal upgrades journal data of the version to the target
+// version with the migrations
+func migrateJournal(data json.RawMessage, version, target int, migrations map[int]func(json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	if version > target {
+		return nil, &journalVersionError{version: version}
+	}
+	if version < 1 {
+		return nil, fmt.Errorf("invalid journal version %d", version)
+	}
+	for ; version < target; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration of the journal version %d", version)
+		}
+		var err error
+		if data, err = migrate(data); err != nil {
+			return nil, fmt.Errorf("can't migrate the journal version %d: %v", version, err)
+		}
+	}
+	return data, nil
+}
+
+// decodeJournal verifies and decodes the journal file content, migrating
+// it to the current version. It returns if the journal was migrated.
+func decodeJournal(content []byte) (*journalData, bool, error) {
+	var file journalFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, false, fmt.Errorf("can't decode the journal: %v", err)
+	}
+	if !strings.HasPrefix(file.Checksum, journalChecksumPrefix) {
+		return nil, false, fmt.Errorf("unsupported journal checksum %q", file.Checksum)
+	}
+	if checksum := journalChecksum(file.Data); checksum != file.Checksum {
+		return nil, false, fmt.Errorf("journal checksum %s doesn't match its data checksum %s", file.Checksum, checksum)
+	}
+	data, err := migrateJournal(file.Data, file.Version, journalVersion, journalMigrations)
+	if err != nil {
+		return nil, false, err
+	}
+	var result journalData
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, false, fmt.Errorf("can't decode the journal data: %v", err)
+	}
+	return &result, file.Version != journalVersion, nil
+}
+
+// encodeJournal returns the journal file content of the current version
+func encodeJournal(data *journalData) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&journalFile{Version: journalVersion, Checksum: journalChecksum(raw), Data: raw})
+}
+
+// SetNodeJournal makes the node keep staged state of the volumes in the
+// journal file and loads the state kept by the previous driver run. The
+// journal is started empty if it's corrupted, the corrupted file is kept
+// with .corrupted suffix. It fails for the journal of a newer driver.
+func (drv *Driver) SetNodeJournal(path string) error {
+	journal := &nodeJournal{path: path, volumes: map[string]journalVolume{}, formats: map[string]journalFormat{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		drv.journal = journal
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't read node journal %s: %v", path, err)
+	}
+
+	data, migrated, err := decodeJournal(content)
+	if _, newer := err.(*journalVersionError); newer {
+		return fmt.Errorf("node journal %s: %v", path, err)
+	}
+	if err != nil {
+		drv.logger.Warning("Node journal is corrupted, starting with an empty one", "path", path, "error", err)
+		if err := os.Rename(path, path+".corrupted"); err != nil {
+			return fmt.Errorf("can't keep corrupted node journal %s: %v", path, err)
+		}
+		drv.journal = journal
+		return nil
+	}
+
+	for _, volume := range data.Volumes {
+		journal.volumes[volume.VolumeID] = volume
+	}
+	for _, format := range data.Formats {
+		journal.formats[format.VolumeID] = format
+	}
+	drv.journal = journal
+	if migrated {
+		drv.logger.Info("Node journal migrated", "path", path, "version", journalVersion)
+		return journal.save()
+	}
+	return nil
+}
+
+// save writes the journal atomically: to a temporary file synced to the disk
+// and renamed to the journal, so a crash leaves either the old or new journal
+func (journal *nodeJournal) save() error {
+	data := &journalData{Volumes: []journalVolume{}}
+	for _, volume := range journal.volumes {
+		data.Volumes = append(data.Volumes, volume)
+	}
+	sort.Slice(data.Volumes, func(i, j int) bool { return data.Volumes[i].VolumeID < data.Volumes[j].VolumeID })
+	for _, format := range journal.formats {
+		data.Formats = append(data.Formats, format)
+	}
+	sort.Slice(data.Formats, func(i, j int) bool { return data.Formats[i].VolumeID < data.Formats[j].VolumeID })
+	content, err := encodeJournal(data)
+	if err != nil {
+		return err
+	}
+
+	tmp := journal.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, journal.path); err != nil {
+		return err
+	}
+
+	// sync the directory, so the rename survives a crash too
+	dir, err := os.Open(filepath.Dir(journal.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close() // nolint: errcheck
+	return dir.Sync()
+}
+
+// record updates the journal record of the volume, it's removed
+// if the volume isn't staged
+func (journal *nodeJournal) record(volume *Volume) error {
+	if journal == nil {
+		return nil
+	}
+	volumeID := volume.CSIVolume.VolumeId
+	if !volume.IsStaged {
+		if _, exists := journal.volumes[volumeID]; !exists {
+			return nil
+		}
+		delete(journal.volumes, volumeID)
+		return journal.save()
+	}
+
+	record := journalVolume{
+		VolumeID:          volumeID,
+		Name:              volume.Name,
+		StagingTargetPath: volume.StagingTargetPath,
+		Device:            volume.Device,
+		DeviceByID:        volume.DeviceByID,
+		FSLabel:           volume.FSLabel,
+		FSUUID:            volume.FSUUID,
+		Block:             volume.IsBlock,
+	}
+	if volume.EndPoint != nil {
+		record.NQN = volume.EndPoint.NQN
+	}
+	for targetPath := range volume.TargetPaths {
+		record.TargetPaths = append(record.TargetPaths, targetPath)
+	}
+	sort.Strings(record.TargetPaths)
+	journal.volumes[volumeID] = record
+	return journal.save()
+}
+
+// stagingTargetPath returns the staging path of the volume recorded in the
+// journal, it's empty if the volume has no record or the journal is disabled
+func (journal *nodeJournal) stagingTargetPath(volumeID string) string {
+	if journal == nil {
+		return ""
+	}
+	return journal.volumes[volumeID].StagingTargetPath
+}
+
+// recordJournal updates the journal record of the volume, failures are only
+// logged as staged state is still recovered from the node without it.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) recordJournal(volume *Volume) {
+	if err := drv.journal.record(volume); err != nil {
+		drv.logger.Warning("Can't update node journal", "volume", volume.Name, "error", err)
+	}
+}
+
+// restoreJournaled restores staged state of the volume lost on the driver
+// restart from its journal record, if the recorded device is still connected
+// to the same subsystem. It must be called with drv.volumesRWL locked.
+func (drv *Driver) restoreJournaled(volume *Volume, stagingTargetPath string) error {
+	if drv.journal == nil {
+		return fmt.Errorf("node journal is disabled")
+	}
+	record, ok := drv.journal.volumes[volume.CSIVolume.VolumeId]
+	if !ok || record.StagingTargetPath != stagingTargetPath {
+		return fmt.Errorf("no journal record of the volume staged to %s", stagingTargetPath)
+	}
+
+	devices, err := drv.nvme.List()
+	if err != nil {
+		return fmt.Errorf("can't list NVMe devices: %v", err)
+	}
+	subnqn, connected := devices[record.Device]
+	if !connected || (record.NQN != "" && subnqn != record.NQN) {
+		return fmt.Errorf("journaled device %s of the subsystem %s is not connected", record.Device, record.NQN)
+	}
+
+	volume.Device = record.Device
+	volume.DeviceByID = record.DeviceByID
+	volume.FSLabel = record.FSLabel
+	volume.FSUUID = record.FSUUID
+	volume.IsBlock = record.Block
+	volume.StagingTargetPath = record.StagingTargetPath
+	volume.IsStaged = true
+	volume.TargetPaths = map[string]bool{}
+	for _, targetPath := range record.TargetPaths {
+		volume.TargetPaths[targetPath] = true
+	}
+	drv.logger.V(LogLevelState).Info("Restored staged volume from the node journal", "volume", volume.Name, "device", record.Device, "staging_target_path", stagingTargetPath)
+	return nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMigrateJournal(t *testing.T) {
+	migrations := map[int]func(json.RawMessage) (json.RawMessage, error){
+		1: func(data json.RawMessage) (json.RawMessage, error) { return append(data, '2'), nil },
+		2: func(data json.RawMessage) (json.RawMessage, error) { return append(data, '3'), nil },
+	}
+	for _, tt := range []struct {
+		name    string
+		version int
+		want    string
+		wantErr bool
+	}{
+		{name: "Current", version: 3, want: "1"},
+		{name: "One version", version: 2, want: "13"},
+		{name: "Two versions", version: 1, want: "123"},
+		{name: "Newer", version: 4, wantErr: true},
+		{name: "Invalid", version: 0, wantErr: true},
+	} {
+		got, err := migrateJournal(json.RawMessage("1"), tt.version, 3, migrations)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: migrateJournal() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && string(got) != tt.want {
+			t.Errorf("%s: migrateJournal() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if _, err := migrateJournal(json.RawMessage("1"), 1, 4, migrations); err == nil {
+		t.Errorf("migrateJournal() without a migration of version 3 succeeded")
+	}
+}
+
+func TestDecodeJournal(t *testing.T) {
+	valid, err := encodeJournal(&journalData{Volumes: []journalVolume{{VolumeID: "1", Device: "/dev/nvme1n1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := `{"volumes":[]}`
+	for _, tt := range []struct {
+		name        string
+		content     string
+		wantVolumes int
+		wantErr     bool
+		wantNewer   bool
+	}{
+		{name: "Valid", content: string(valid), wantVolumes: 1},
+		{name: "Truncated", content: string(valid[:len(valid)-10]), wantErr: true},
+		{name: "Wrong checksum", content: `{"version":1,"checksum":"` + journalChecksum([]byte(`{"volumes":null}`)) + `","data":` + data + `}`, wantErr: true},
+		{name: "Unknown checksum", content: `{"version":1,"checksum":"md5:0","data":` + data + `}`, wantErr: true},
+		{name: "Newer version", content: `{"version":99,"checksum":"` + journalChecksum([]byte(data)) + `","data":` + data + `}`, wantErr: true, wantNewer: true},
+	} {
+		got, migrated, err := decodeJournal([]byte(tt.content))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeJournal() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if _, newer := err.(*journalVersionError); newer != tt.wantNewer {
+			t.Errorf("%s: decodeJournal() error %v, want newer version error %v", tt.name, err, tt.wantNewer)
+		}
+		if err == nil && (migrated || len(got.Volumes) != tt.wantVolumes) {
+			t.Errorf("%s: decodeJournal() = %d volumes, migrated %v, want %d volumes", tt.name, len(got.Volumes), migrated, tt.wantVolumes)
+		}
+	}
+}
+
+func TestNodeJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.json")
+
+	drv := &Driver{nvme: &testNVMe{}}
+	if err := drv.SetNodeJournal(path); err != nil {
+		t.Fatalf("SetNodeJournal() without journal file: %v", err)
+	}
+	staged := &Volume{
+		Name:              "pvc-1",
+		CSIVolume:         &csi.Volume{VolumeId: "1"},
+		EndPoint:          &endpoint.Portal{NQN: "nqn.2014-08.org.nvmexpress:uuid:1"},
+		Device:            "/dev/nvme1n1",
+		FSLabel:           "csi-1",
+		IsStaged:          true,
+		StagingTargetPath: "/staging/1",
+		TargetPaths:       map[string]bool{"/target/b": true, "/target/a": true},
+	}
+	drv.recordJournal(staged)
+	drv.recordJournal(&Volume{Name: "pvc-2", CSIVolume: &csi.Volume{VolumeId: "2"}, Device: "/dev/nvme2n1", IsStaged: true, StagingTargetPath: "/staging/2"})
+	drv.recordJournal(&Volume{Name: "pvc-2", CSIVolume: &csi.Volume{VolumeId: "2"}})
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary journal file is left: %v", err)
+	}
+
+	restarted := &Driver{nvme: &testNVMe{}}
+	if err := restarted.SetNodeJournal(path); err != nil {
+		t.Fatalf("SetNodeJournal() after restart: %v", err)
+	}
+	if len(restarted.journal.volumes) != 1 {
+		t.Fatalf("journal after restart has records %v, want only volume 1", restarted.journal.volumes)
+	}
+
+	lost := &Volume{Name: "pvc-1", CSIVolume: &csi.Volume{VolumeId: "1"}}
+	if err := restarted.restoreJournaled(lost, "/staging/other"); err == nil {
+		t.Errorf("restoreJournaled() to another staging path succeeded")
+	}
+	if err := restarted.restoreJournaled(lost, "/staging/1"); err != nil {
+		t.Fatalf("restoreJournaled() error = %v", err)
+	}
+	lost.EndPoint = staged.EndPoint
+	if !reflect.DeepEqual(lost, staged) {
+		t.Errorf("restoreJournaled() = %+v, want %+v", lost, staged)
+	}
+
+	// corrupted journal is kept aside and the driver starts with an empty one
+	if err := ioutil.WriteFile(path, []byte(`{"version":1,"checksum":"sha256:00","data":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := &Driver{}
+	if err := corrupted.SetNodeJournal(path); err != nil {
+		t.Fatalf("SetNodeJournal() of corrupted journal: %v", err)
+	}
+	if len(corrupted.journal.volumes) != 0 {
+		t.Errorf("corrupted journal has records %v", corrupted.journal.volumes)
+	}
+	if _, err := os.Stat(path + ".corrupted"); err != nil {
+		t.Errorf("corrupted journal isn't kept: %v", err)
+	}
+
+	// journal of a newer driver isn't touched
+	newer := `{"version":99,"checksum":"` + journalChecksum([]byte("{}")) + `","data":{}}`
+	if err := ioutil.WriteFile(path, []byte(newer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Driver{}).SetNodeJournal(path); err == nil {
+		t.Errorf("SetNodeJournal() of newer journal succeeded")
+	}
+}
+
+func TestNodeJournalRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.json")
+
+	const (
+		nqn     = "nqn.2014-08.org.nvmexpress:uuid:1"
+		staging = "/staging/1"
+		target  = "/target/1"
+	)
+	drv := &Driver{nvme: &testNVMe{}}
+	if err := drv.SetNodeJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	drv.recordJournal(&Volume{
+		Name:              "pvc-1",
+		CSIVolume:         &csi.Volume{VolumeId: "1"},
+		EndPoint:          &endpoint.Portal{NQN: nqn},
+		Device:            "/dev/nvme1n1",
+		IsBlock:           true,
+		IsStaged:          true,
+		StagingTargetPath: staging,
+		TargetPaths:       map[string]bool{target: true},
+	})
+
+	// the driver restarted after staging and publishing the volume knows it
+	// from RSD only
+	restart := func(journaled bool) (*Driver, *cleanupNVMe, *Volume) {
+		nvme := &cleanupNVMe{devices: map[string]string{"/dev/nvme1n1": nqn}}
+		restarted := &Driver{mounter: &testMounter{}, nvme: nvme}
+		if journaled {
+			if err := restarted.SetNodeJournal(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vol := &Volume{
+			Name:      "pvc-1",
+			CSIVolume: &csi.Volume{VolumeId: "1"},
+			RSDVolume: &rsd.Volume{CapacityBytes: 1 << 30},
+			EndPoint:  &endpoint.Portal{NQN: nqn},
+		}
+		restarted.volumes = map[string]*Volume{"pvc-1": vol}
+		return restarted, nvme, vol
+	}
+
+	for _, journaled := range []bool{true, false} {
+		restarted, _, _ := restart(journaled)
+		resp, err := restarted.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "1", VolumePath: target})
+		if !journaled {
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("NodeGetVolumeStats() without journal error = %v, want NotFound", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("NodeGetVolumeStats() of the journaled target path: %v", err)
+		}
+		if len(resp.Usage) != 1 || resp.Usage[0].Total != 1<<30 || resp.VolumeCondition.Abnormal {
+			t.Errorf("NodeGetVolumeStats() = %v, want capacity of the healthy block volume", resp)
+		}
+	}
+
+	for _, journaled := range []bool{true, false} {
+		restarted, nvme, vol := restart(journaled)
+		if _, err := restarted.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "1", StagingTargetPath: staging}); err != nil {
+			t.Fatalf("NodeUnstageVolume() (journal %v) unexpected error: %v", journaled, err)
+		}
+		var want []string
+		if journaled {
+			want = []string{"/dev/nvme1n1"}
+		}
+		if !reflect.DeepEqual(nvme.disconnected, want) {
+			t.Errorf("NodeUnstageVolume() (journal %v) disconnected %v, want %v", journaled, nvme.disconnected, want)
+		}
+		if vol.IsStaged || vol.Device != "" {
+			t.Errorf("NodeUnstageVolume() (journal %v) left the volume staged on %q", journaled, vol.Device)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// Verbosity levels of the driver log, warnings and errors are always logged
+const (
+	// LogLevelState logs volume state changes: created, attached, staged, ...
+	LogLevelState = 1
+	// LogLevelRequest logs CSI requests and responses
+	LogLevelRequest = 2
+	// LogLevelRSD logs requests sent to RSD
+	LogLevelRSD = 4
+)
+
+// Logger writes leveled log lines of a message followed by key=value pairs.
+// Lines are written by the standard log package, so they reach all its
+// outputs, e.g. LogBuffer, and credentials are redacted from them.
+// Zero value Logger logs only warnings and errors.
+type Logger struct {
+	verbosity int
+	level     int
+	values    []interface{}
+}
+
+// NewLogger returns Logger writing messages up to the verbosity level
+func NewLogger(verbosity int) Logger {
+	return Logger{verbosity: verbosity}
+}
+
+// WithValues returns Logger adding key/value pairs to every message
+func (l Logger) WithValues(keysAndValues ...interface{}) Logger {
+	values := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	l.values = append(append(values, l.values...), keysAndValues...)
+	return l
+}
+
+// V returns Logger writing info messages only if the verbosity is at least level
+func (l Logger) V(level int) Logger {
+	l.level = level
+	return l
+}
+
+// Enabled checks if info messages of the Logger are written
+func (l Logger) Enabled() bool {
+	return l.level <= l.verbosity
+}
+
+// Info logs the message if the Logger is enabled
+func (l Logger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		l.output("", msg, keysAndValues)
+	}
+}
+
+// Warning logs the message regardless of the verbosity
+func (l Logger) Warning(msg string, keysAndValues ...interface{}) {
+	l.output("WARNING: ", msg, keysAndValues)
+}
+
+// Error logs the message with the error regardless of the verbosity
+func (l Logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.output("ERROR: ", msg, append([]interface{}{"error", err}, keysAndValues...))
+}
+
+// LogRSDRequest logs requests sent to RSD, it's rsd.RequestLogger
+func (l Logger) LogRSDRequest(method, url, body string) {
+	l.V(LogLevelRSD).Info("RSD request", "method", method, "url", url, "body", body)
+}
+
+func (l Logger) output(prefix, msg string, keysAndValues []interface{}) {
+	var line strings.Builder
+	line.WriteString(prefix)
+	line.WriteString(msg)
+	writeValues(&line, l.values)
+	writeValues(&line, keysAndValues)
+	log.Print(rsd.Redact(line.String()))
+}
+
+// writeValues writes key=value pairs, a key without value gets value "(MISSING)"
+func writeValues(line *strings.Builder, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fmt.Fprintf(line, " %v=%s", keysAndValues[i], quoteValue(value))
+	}
+}
+
+// quoteValue formats the value, quoting it if it's empty or contains spaces or quotes.
+// Credentials are redacted before quoting, which escapes JSON payloads.
+func quoteValue(value interface{}) string {
+	text := rsd.Redact(fmt.Sprintf("%v", value))
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// loggerKey is the context key of the request Logger
+type loggerKey struct{}
+
+// withLogger returns context carrying the Logger of the request
+func withLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// requestLogger returns Logger of the request context,
+// or the driver Logger if the context has none
+func (drv *Driver) requestLogger(ctx context.Context) Logger {
+	return contextLogger(ctx, drv.logger)
+}
+
+// contextLogger returns Logger of the request context, or logger if the
+// context has none
+func contextLogger(ctx context.Context, logger Logger) Logger {
+	if requestLogger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return requestLogger
+	}
+	return logger
+}
+
+// SetLogger sets Logger of the driver and of its nvme tool
+func (drv *Driver) SetLogger(logger Logger) {
+	drv.logger = logger
+	drv.setTools()
+}
@@ -0,0 +1,457 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// operationBuckets are the buckets of csi_plugin_operations_seconds in seconds,
+// the same as the buckets of the CSI sidecars
+var operationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 25, 50, 120, 300, 600}
+
+// RSD operations counted by the csi_rsd_operations_total metric
+const (
+	operationCreate = "create"
+	operationDelete = "delete"
+	operationAttach = "attach"
+	operationDetach = "detach"
+
+	operationSnapshot       = "snapshot"
+	operationDeleteSnapshot = "delete_snapshot"
+
+	operationSuccess = "success"
+	operationFailure = "failure"
+)
+
+// Volume states counted by the csi_rsd_node_volumes metric
+const (
+	volumeStateKnown     = "known"
+	volumeStatePublished = "published"
+	volumeStateStaged    = "staged"
+	// volumeStateAttached is the volume exposed by RSD endpoints as of the
+	// latest publish or resync of the volume
+	volumeStateAttached = "attached"
+)
+
+// metricSample is a value of a metric for a particular set of label values.
+// Value of a histogram is the sum of the observations.
+type metricSample struct {
+	labelValues []string
+	value       float64
+	// bucketCounts are the numbers of histogram observations in the buckets, not cumulative
+	bucketCounts []uint64
+	count        uint64
+}
+
+// metricVec is a metric family partitioned by label values.
+// All methods are no-op for nil metricVec, so drivers constructed
+// without metrics (e.g. in unit tests) don't need special handling.
+type metricVec struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	// buckets are upper bounds of the histogram buckets
+	buckets []float64
+
+	mu      sync.Mutex
+	samples map[string]*metricSample
+}
+
+func (vec *metricVec) sample(labelValues []string) *metricSample {
+	key := strings.Join(labelValues, "\xff")
+	s, exists := vec.samples[key]
+	if !exists {
+		s = &metricSample{labelValues: append([]string(nil), labelValues...), bucketCounts: make([]uint64, len(vec.buckets))}
+		vec.samples[key] = s
+	}
+	return s
+}
+
+// Add adds delta to the metric value for the given label values
+func (vec *metricVec) Add(delta float64, labelValues ...string) {
+	if vec == nil {
+		return
+	}
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.sample(labelValues).value += delta
+}
+
+// Inc increments the metric value for the given label values
+func (vec *metricVec) Inc(labelValues ...string) {
+	vec.Add(1, labelValues...)
+}
+
+// Set sets the metric value for the given label values
+func (vec *metricVec) Set(value float64, labelValues ...string) {
+	if vec == nil {
+		return
+	}
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.sample(labelValues).value = value
+}
+
+// Observe adds the value to the histogram for the given label values
+func (vec *metricVec) Observe(value float64, labelValues ...string) {
+	if vec == nil {
+		return
+	}
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	s := vec.sample(labelValues)
+	for i, bound := range vec.buckets {
+		if value <= bound {
+			s.bucketCounts[i]++
+			break
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+// Delete removes the sample for the given label values
+func (vec *metricVec) Delete(labelValues ...string) {
+	if vec == nil {
+		return
+	}
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	delete(vec.samples, strings.Join(labelValues, "\xff"))
+}
+
+// Reset removes all samples of the metric
+func (vec *metricVec) Reset() {
+	if vec == nil {
+		return
+	}
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	vec.samples = map[string]*metricSample{}
+}
+
+// escapeLabelValue escapes label value according to the Prometheus text format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// write writes metric family in the Prometheus text exposition format
+func (vec *metricVec) write(w io.Writer) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", vec.name, vec.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", vec.name, vec.metricType)
+
+	keys := make([]string, 0, len(vec.samples))
+	for k := range vec.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := vec.samples[k]
+		var labels []string
+		for i, name := range vec.labelNames {
+			if i < len(s.labelValues) {
+				labels = append(labels, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(s.labelValues[i])))
+			}
+		}
+		if vec.metricType != histogramMetric {
+			writeSample(w, vec.name, labels, s.value)
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range vec.buckets {
+			cumulative += s.bucketCounts[i]
+			le := fmt.Sprintf("le=\"%s\"", strconv.FormatFloat(bound, 'g', -1, 64))
+			writeSample(w, vec.name+"_bucket", append(labels[:len(labels):len(labels)], le), float64(cumulative))
+		}
+		writeSample(w, vec.name+"_bucket", append(labels[:len(labels):len(labels)], "le=\"+Inf\""), float64(s.count))
+		writeSample(w, vec.name+"_sum", labels, s.value)
+		writeSample(w, vec.name+"_count", labels, float64(s.count))
+	}
+}
+
+// writeSample writes a sample line in the Prometheus text exposition format
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	formatted := strconv.FormatFloat(value, 'g', -1, 64)
+	if len(labels) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), formatted)
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatted)
+	}
+}
+
+// MetricsRegistry keeps registered metric families and collectors
+// and serves them over HTTP in the Prometheus text format
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	vecs       []*metricVec
+	collectors []func()
+}
+
+// NewMetricsRegistry returns an empty registry for the metrics of a driver
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+func (reg *MetricsRegistry) newVec(name, help, metricType string, labelNames []string, buckets []float64) *metricVec {
+	vec := &metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		buckets:    buckets,
+		samples:    map[string]*metricSample{},
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.vecs = append(reg.vecs, vec)
+	return vec
+}
+
+// newCounterVec registers new counter metric family
+func (reg *MetricsRegistry) newCounterVec(name, help string, labelNames ...string) *metricVec {
+	return reg.newVec(name, help, counterMetric, labelNames, nil)
+}
+
+// newGaugeVec registers new gauge metric family
+func (reg *MetricsRegistry) newGaugeVec(name, help string, labelNames ...string) *metricVec {
+	return reg.newVec(name, help, gaugeMetric, labelNames, nil)
+}
+
+// newHistogramVec registers new histogram metric family with the bucket upper bounds
+func (reg *MetricsRegistry) newHistogramVec(name, help string, buckets []float64, labelNames ...string) *metricVec {
+	return reg.newVec(name, help, histogramMetric, labelNames, buckets)
+}
+
+// addCollector registers a function that is called before
+// every metrics exposition to refresh metric values
+func (reg *MetricsRegistry) addCollector(collector func()) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.collectors = append(reg.collectors, collector)
+}
+
+// ServeHTTP implements http.Handler interface
+func (reg *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reg.mu.Lock()
+	collectors := append([]func(){}, reg.collectors...)
+	vecs := append([]*metricVec{}, reg.vecs...)
+	reg.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	var buf bytes.Buffer
+	for _, vec := range vecs {
+		vec.write(&buf)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes()) // nolint: errcheck
+}
+
+// driverMetrics contains metrics exported by the driver
+type driverMetrics struct {
+	registry *MetricsRegistry
+
+	nvmeCriticalWarning  *metricVec
+	nvmeTemperature      *metricVec
+	nvmeMediaErrors      *metricVec
+	nvmeErrorLogEntries  *metricVec
+	nvmeSmartLogFailures *metricVec
+
+	kubeletRegistered *metricVec
+
+	operations *metricVec
+	// operationsSeconds follows csi_sidecar_operations_seconds of the CSI sidecars
+	operationsSeconds *metricVec
+
+	volumeCapacityShrunk    *metricVec
+	volumeOperationProgress *metricVec
+	volumesDeletedOutOfBand *metricVec
+	volumeDrift             *metricVec
+
+	spareVolumes *metricVec
+
+	capacityCacheRequests *metricVec
+	capacityCacheAge      *metricVec
+
+	volumeAllocatedBytes   *metricVec
+	volumeCreatedTimestamp *metricVec
+
+	nodeVolumes     *metricVec
+	nodeAttachments *metricVec
+}
+
+func newDriverMetrics() driverMetrics {
+	return newDriverMetricsIn(NewMetricsRegistry())
+}
+
+// newDriverMetricsIn registers the driver metrics in the registry
+func newDriverMetricsIn(reg *MetricsRegistry) driverMetrics {
+	return driverMetrics{
+		registry: reg,
+		nvmeCriticalWarning: reg.newGaugeVec("csi_rsd_nvme_critical_warning",
+			"NVMe SMART critical warning bitmap of the volume device", "volume_id"),
+		nvmeTemperature: reg.newGaugeVec("csi_rsd_nvme_temperature_celsius",
+			"NVMe composite temperature of the volume device", "volume_id"),
+		nvmeMediaErrors: reg.newCounterVec("csi_rsd_nvme_media_errors_total",
+			"Number of unrecovered data integrity errors of the volume device", "volume_id"),
+		nvmeErrorLogEntries: reg.newCounterVec("csi_rsd_nvme_error_log_entries_total",
+			"Number of error information log entries of the volume device", "volume_id"),
+		nvmeSmartLogFailures: reg.newCounterVec("csi_rsd_nvme_smart_log_failures_total",
+			"Number of failed attempts to read NVMe SMART log of the volume device", "volume_id"),
+		kubeletRegistered: reg.newGaugeVec("csi_rsd_kubelet_registered",
+			"Whether the driver registration socket is served by node-driver-registrar"),
+		operations: reg.newCounterVec("csi_rsd_operations_total",
+			"Number of RSD operations by result: success or failure category", "operation", "result"),
+		operationsSeconds: reg.newHistogramVec("csi_plugin_operations_seconds",
+			"Duration of the CSI operations served by the plugin", operationBuckets, "driver_name", "method_name", "grpc_status_code"),
+		volumeCapacityShrunk: reg.newGaugeVec("csi_rsd_volume_capacity_shrunk",
+			"Whether RSD volume capacity is smaller than the capacity requested on creation", "volume_id"),
+		volumeOperationProgress: reg.newGaugeVec("csi_rsd_volume_operation_percent_complete",
+			"Completion percentage of the RSD operation of the volume in progress, e.g. background initialization", "volume_id", "operation"),
+		volumesDeletedOutOfBand: reg.newCounterVec("csi_rsd_volumes_deleted_out_of_band_total",
+			"Number of deleted volumes whose RSD volume was already deleted out of band"),
+		volumeDrift: reg.newCounterVec("csi_rsd_volume_drift_total",
+			"Number of volume records found out of sync with RSD by the reconciliation by drift: deleted, detached or reattached", "drift"),
+		spareVolumes: reg.newGaugeVec("csi_rsd_spare_volumes",
+			"Number of available pre-created spare volumes by requested capacity", "capacity_bytes"),
+		capacityCacheRequests: reg.newCounterVec("csi_rsd_capacity_cache_requests_total",
+			"Number of GetCapacity calls served by the capacity cache by result: hit or miss", "result"),
+		capacityCacheAge: reg.newGaugeVec("csi_rsd_capacity_cache_age_seconds",
+			"Age of the oldest capacity in the capacity cache"),
+		volumeAllocatedBytes: reg.newGaugeVec("csi_rsd_volume_allocated_bytes",
+			"RSD capacity allocated for the volume", "volume_id", "namespace", "pvc", "pool"),
+		volumeCreatedTimestamp: reg.newGaugeVec("csi_rsd_volume_created_timestamp_seconds",
+			"Creation time of the volume, unknown for the volumes adopted after the driver restart", "volume_id", "namespace", "pvc", "pool"),
+		nodeVolumes: reg.newGaugeVec("csi_rsd_node_volumes",
+			"Number of volumes of the RSD node by state: known, published, staged or attached in RSD", "rsd_node_id", "state"),
+		nodeAttachments: reg.newCounterVec("csi_rsd_node_attachments_total",
+			"Number of attaches and detaches of the volumes to the RSD node by result: success or failure", "rsd_node_id", "operation", "result"),
+	}
+}
+
+// MetricsHandler returns http.Handler serving driver metrics in the Prometheus text format
+func (drv *Driver) MetricsHandler() http.Handler {
+	return drv.metrics.registry
+}
+
+// observeOperation counts the result of the RSD operation and returns category of its error
+func (drv *Driver) observeOperation(operation string, err error) rsd.ErrorCategory {
+	category := rsd.Classify(err)
+	result := string(category)
+	if err == nil {
+		result = operationSuccess
+	}
+	drv.metrics.operations.Inc(operation, result)
+	return category
+}
+
+// observeAttachment counts the result of attaching or detaching a volume to the RSD node
+func (drv *Driver) observeAttachment(operation, nodeID string, err error) {
+	result := operationSuccess
+	if err != nil {
+		result = operationFailure
+	}
+	drv.metrics.nodeAttachments.Inc(nodeID, operation, result)
+}
+
+// collectNodeVolumeMetrics counts volumes of the RSD nodes in every state.
+// Volumes which are not published are counted for the driver node.
+func (drv *Driver) collectNodeVolumeMetrics() {
+	m := drv.metrics
+	m.nodeVolumes.Reset()
+	if drv.RSDNodeID != "" {
+		for _, state := range []string{volumeStateKnown, volumeStatePublished, volumeStateStaged, volumeStateAttached} {
+			m.nodeVolumes.Set(0, drv.RSDNodeID, state)
+		}
+	}
+
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+	for _, vol := range drv.volumes {
+		nodeID := vol.RSDNodeID
+		if nodeID == "" {
+			nodeID = drv.RSDNodeID
+		}
+		m.nodeVolumes.Add(1, nodeID, volumeStateKnown)
+		if vol.IsPublished {
+			m.nodeVolumes.Add(1, nodeID, volumeStatePublished)
+		}
+		if vol.IsStaged {
+			m.nodeVolumes.Add(1, nodeID, volumeStateStaged)
+		}
+		if vol.RSDVolume != nil && len(vol.RSDVolume.Links.Oem.IntelRackScale.Endpoints) > 0 {
+			m.nodeVolumes.Add(1, nodeID, volumeStateAttached)
+		}
+	}
+}
+
+// collectNVMeMetrics reads SMART log of devices of all staged volumes
+func (drv *Driver) collectNVMeMetrics() {
+	// collect devices under the lock and query them without it
+	// as nvme commands may take a while
+	devices := map[string]string{}
+	drv.volumesRWL.RLock()
+	for _, vol := range drv.volumes {
+		if vol.IsStaged && vol.Device != "" {
+			devices[vol.CSIVolume.VolumeId] = vol.Device
+		}
+	}
+	drv.volumesRWL.RUnlock()
+
+	m := drv.metrics
+	m.nvmeCriticalWarning.Reset()
+	m.nvmeTemperature.Reset()
+	m.nvmeMediaErrors.Reset()
+	m.nvmeErrorLogEntries.Reset()
+
+	for volumeID, device := range devices {
+		smartLog, err := drv.nvme.SmartLog(device)
+		if err != nil {
+			drv.logger.Warning("can't get SMART log of the volume device", "volume_id", volumeID, "device", device, "error", err)
+			m.nvmeSmartLogFailures.Inc(volumeID)
+			continue
+		}
+		m.nvmeCriticalWarning.Set(float64(smartLog.CriticalWarning), volumeID)
+		// NVMe reports temperature in Kelvins
+		m.nvmeTemperature.Set(float64(smartLog.Temperature)-273, volumeID)
+		m.nvmeMediaErrors.Set(smartLog.MediaErrors, volumeID)
+		m.nvmeErrorLogEntries.Set(smartLog.NumErrLogEntries, volumeID)
+	}
+}
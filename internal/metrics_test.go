@@ -0,0 +1,199 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricVecWrite(t *testing.T) {
+	reg := NewMetricsRegistry()
+	counter := reg.newCounterVec("test_total", "Test counter", "name")
+	counter.Inc("b")
+	counter.Add(2, "a\"quoted\"")
+	gauge := reg.newGaugeVec("test_gauge", "Test gauge")
+	gauge.Set(1.5)
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP test_total Test counter
+# TYPE test_total counter
+test_total{name="a\"quoted\""} 2
+test_total{name="b"} 1
+# HELP test_gauge Test gauge
+# TYPE test_gauge gauge
+test_gauge 1.5
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected metrics output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	reg := NewMetricsRegistry()
+	histogram := reg.newHistogramVec("test_seconds", "Test histogram", []float64{0.5, 1}, "method")
+	histogram.Observe(0.25, "a")
+	histogram.Observe(0.75, "a")
+	histogram.Observe(2, "a")
+
+	rec := httptest.NewRecorder()
+	reg.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{method="a",le="0.5"} 1
+test_seconds_bucket{method="a",le="1"} 2
+test_seconds_bucket{method="a",le="+Inf"} 3
+test_seconds_sum{method="a"} 3
+test_seconds_count{method="a"} 3
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected metrics output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestInterceptorMetrics(t *testing.T) {
+	drv := &Driver{metrics: newDriverMetrics()}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if _, err := drv.interceptor(context.Background(), nil, info, handler); status.Code(err) != codes.NotFound {
+		t.Errorf("interceptor() error = %v, want handler error", err)
+	}
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	want := `csi_plugin_operations_seconds_count{driver_name="csi.rsd.intel.com",method_name="/csi.v1.Controller/CreateVolume",grpc_status_code="NotFound"} 1`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics don't contain %s:\n%s", want, rec.Body.String())
+	}
+}
+
+func TestMetricVecNil(t *testing.T) {
+	var vec *metricVec
+	vec.Inc("a")
+	vec.Set(1, "a")
+	vec.Reset()
+}
+
+func TestCollectNVMeMetrics(t *testing.T) {
+	drv := &Driver{
+		nvme:    &testNVMe{},
+		metrics: newDriverMetrics(),
+		volumes: map[string]*Volume{
+			"Vol1": &Volume{
+				CSIVolume: &csi.Volume{VolumeId: "1"},
+				Device:    "/dev/nvme1n1",
+				IsStaged:  true,
+			},
+			"Vol2": &Volume{
+				CSIVolume: &csi.Volume{VolumeId: "2"},
+			},
+		},
+	}
+	drv.metrics.registry.addCollector(drv.collectNVMeMetrics)
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+
+	for _, line := range []string{
+		`csi_rsd_nvme_critical_warning{volume_id="1"} 0`,
+		`csi_rsd_nvme_temperature_celsius{volume_id="1"} 27`,
+		`csi_rsd_nvme_media_errors_total{volume_id="1"} 2`,
+		`csi_rsd_nvme_error_log_entries_total{volume_id="1"} 5`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+		}
+	}
+
+	if strings.Contains(got, `volume_id="2"`) {
+		t.Errorf("metrics output contains unstaged volume:\n%s", got)
+	}
+}
+
+func TestObserveOperation(t *testing.T) {
+	drv := &Driver{metrics: newDriverMetrics()}
+
+	drv.observeOperation(operationCreate, nil)
+	category := drv.observeOperation(operationAttach, &rsd.HTTPError{StatusCode: 401})
+	if category != rsd.CategoryAuth {
+		t.Errorf("observeOperation() = %q, want %q", category, rsd.CategoryAuth)
+	}
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+
+	for _, line := range []string{
+		`csi_rsd_operations_total{operation="attach",result="auth"} 1`,
+		`csi_rsd_operations_total{operation="create",result="success"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestNodeVolumeMetrics(t *testing.T) {
+	attached := &rsd.Volume{}
+	if err := json.Unmarshal([]byte(`{"Links": {"Oem": {"Intel_RackScale": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1"}]}}}}`), attached); err != nil {
+		t.Fatal(err)
+	}
+	drv := &Driver{
+		RSDNodeID: "node-1",
+		metrics:   newDriverMetrics(),
+		volumes: map[string]*Volume{
+			"Vol1": &Volume{RSDVolume: attached, RSDNodeID: "node-1", IsPublished: true, IsStaged: true},
+			"Vol2": &Volume{RSDVolume: attached, RSDNodeID: "node-1", IsPublished: true},
+			"Vol3": &Volume{RSDVolume: &rsd.Volume{}},
+		},
+	}
+	drv.metrics.registry.addCollector(drv.collectNodeVolumeMetrics)
+	drv.observeAttachment(operationAttach, "node-1", nil)
+	drv.observeAttachment(operationAttach, "node-1", nil)
+	drv.observeAttachment(operationDetach, "node-1", errors.New("detach failed"))
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+
+	for _, line := range []string{
+		`csi_rsd_node_volumes{rsd_node_id="node-1",state="known"} 3`,
+		`csi_rsd_node_volumes{rsd_node_id="node-1",state="published"} 2`,
+		`csi_rsd_node_volumes{rsd_node_id="node-1",state="staged"} 1`,
+		`csi_rsd_node_volumes{rsd_node_id="node-1",state="attached"} 2`,
+		`csi_rsd_node_attachments_total{rsd_node_id="node-1",operation="attach",result="success"} 2`,
+		`csi_rsd_node_attachments_total{rsd_node_id="node-1",operation="detach",result="failure"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+		}
+	}
+}
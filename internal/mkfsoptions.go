@@ -0,0 +1,108 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// MkfsOptionsParameter is a StorageClass parameter with comma separated mkfs
+// options the volumes are formatted with, e.g. lazy_itable_init=0 for ext
+// filesystems or reflink=1 for xfs
+const MkfsOptionsParameter = "mkfsOptions"
+
+// mkfsOptionFlags map the filesystems to their mkfs options allowed in
+// MkfsOptionsParameter and the flags passing them to mkfs
+var mkfsOptionFlags = map[string]map[string]string{
+	"ext2": extMkfsOptions,
+	"ext3": extMkfsOptions,
+	"ext4": extMkfsOptions,
+	"xfs": {
+		"reflink":    "-m",
+		"crc":        "-m",
+		"finobt":     "-m",
+		"rmapbt":     "-m",
+		"bigtime":    "-m",
+		"inobtcount": "-m",
+	},
+}
+
+// extMkfsOptions are the extended options of mke2fs
+var extMkfsOptions = map[string]string{
+	"lazy_itable_init":  "-E",
+	"lazy_journal_init": "-E",
+	"discard":           "-E",
+	"nodiscard":         "-E",
+	"stride":            "-E",
+	"stripe_width":      "-E",
+}
+
+// mkfsOptionValue matches the values of the mkfs options
+var mkfsOptionValue = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// mkfsArgs returns the mkfs arguments of the comma separated options of the
+// filesystem, the options of a flag are joined, e.g. -E lazy_itable_init=0,discard
+func mkfsArgs(fsType, options string) ([]string, error) {
+	if options == "" {
+		return nil, nil
+	}
+	allowed := mkfsOptionFlags[fsType]
+	var flags []string
+	values := map[string][]string{}
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		parts := strings.SplitN(option, "=", 2)
+		flag, ok := allowed[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("parameter %s: option %q is not supported for %s filesystem", MkfsOptionsParameter, parts[0], fsType)
+		}
+		if len(parts) == 2 && !mkfsOptionValue.MatchString(parts[1]) {
+			return nil, fmt.Errorf("parameter %s: invalid value of option %q", MkfsOptionsParameter, option)
+		}
+		if _, seen := values[flag]; !seen {
+			flags = append(flags, flag)
+		}
+		values[flag] = append(values[flag], option)
+	}
+	var args []string
+	for _, flag := range flags {
+		args = append(args, flag, strings.Join(values[flag], ","))
+	}
+	return args, nil
+}
+
+// mkfsContext validates the mkfs options in the CreateVolume parameters
+// against the filesystems of the mount capabilities and returns them to be
+// stored in the volume context
+func (drv *Driver) mkfsContext(parameters map[string]string, caps []*csi.VolumeCapability) (map[string]string, error) {
+	result := map[string]string{}
+	options, exists := parameters[MkfsOptionsParameter]
+	if !exists {
+		return result, nil
+	}
+	for _, capability := range caps {
+		if mnt := capability.GetMount(); mnt != nil {
+			if _, err := mkfsArgs(drv.fsType(mnt.FsType), options); err != nil {
+				return nil, err
+			}
+		}
+	}
+	result[MkfsOptionsParameter] = options
+	return result, nil
+}
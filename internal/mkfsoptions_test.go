@@ -0,0 +1,135 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mkfsMounter records the mkfs options the volumes are formatted with
+type mkfsMounter struct {
+	testMounter
+	opts []string
+}
+
+func (m *mkfsMounter) IsFormatted(source string) (bool, error) {
+	return false, nil
+}
+
+func (m *mkfsMounter) Format(source, fsType, label string, opts ...string) error {
+	m.opts = opts
+	return nil
+}
+
+func TestMkfsArgs(t *testing.T) {
+	tests := []struct {
+		fsType  string
+		options string
+		want    []string
+		wantErr bool
+	}{
+		{fsType: "ext4", options: "", want: nil},
+		{fsType: "ext4", options: "lazy_itable_init=0, lazy_journal_init=0,nodiscard", want: []string{"-E", "lazy_itable_init=0,lazy_journal_init=0,nodiscard"}},
+		{fsType: "ext3", options: "stride=16", want: []string{"-E", "stride=16"}},
+		{fsType: "xfs", options: "reflink=1,crc=1", want: []string{"-m", "reflink=1,crc=1"}},
+		{fsType: "xfs", options: "lazy_itable_init=0", wantErr: true},
+		{fsType: "ext4", options: "reflink=1", wantErr: true},
+		{fsType: "ext4", options: "root_owner=0:0", wantErr: true},
+		{fsType: "ext4", options: "lazy_itable_init=0 -O", wantErr: true},
+		{fsType: "ext4", options: "lazy_itable_init=0,,", wantErr: true},
+		{fsType: "btrfs", options: "reflink=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := mkfsArgs(tt.fsType, tt.options)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mkfsArgs(%s, %q) error = %v, wantErr %v", tt.fsType, tt.options, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mkfsArgs(%s, %q) = %q, want %q", tt.fsType, tt.options, got, tt.want)
+		}
+	}
+}
+
+func TestMkfsContext(t *testing.T) {
+	drv := &Driver{}
+	parameters := map[string]string{MkfsOptionsParameter: "reflink=1"}
+	got, err := drv.mkfsContext(parameters, []*csi.VolumeCapability{mountCapability("xfs"), blockCapability()})
+	if err != nil || got[MkfsOptionsParameter] != "reflink=1" {
+		t.Errorf("mkfsContext() of xfs = %v, %v", got, err)
+	}
+	if _, err := drv.mkfsContext(parameters, []*csi.VolumeCapability{mountCapability("")}); err == nil {
+		t.Error("mkfsContext() accepted xfs options for the default ext4 filesystem")
+	}
+	if got, err := drv.mkfsContext(map[string]string{}, []*csi.VolumeCapability{mountCapability("")}); err != nil || len(got) != 0 {
+		t.Errorf("mkfsContext() without options = %v, %v, want empty context", got, err)
+	}
+
+	_, err = drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		Parameters:         parameters,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() with options of other filesystem error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestNodeStageVolumeMkfsOptions(t *testing.T) {
+	mounter := &mkfsMounter{}
+	drv := &Driver{
+		volumes: map[string]*Volume{
+			"Vol1": &Volume{
+				CSIVolume: &csi.Volume{VolumeId: "1"},
+				RSDVolume: &rsd.Volume{},
+				Name:      "Vol1",
+				EndPoint: &endpoint.Portal{
+					Transport: "rdma",
+					Address:   "192.168.1.1",
+					Port:      4420,
+					NQN:       "nqn.2014-08.org.nvmexpress:uuid:1",
+				},
+				IsPublished: true,
+			},
+		},
+		nvme:    &testNVMe{},
+		mounter: mounter,
+	}
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: "/mnt",
+		VolumeCapability:  mountCapability("ext4"),
+		VolumeContext:     map[string]string{MkfsOptionsParameter: "reflink=1"},
+	}
+	if _, err := drv.NodeStageVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodeStageVolume() with options of other filesystem error = %v, want InvalidArgument", err)
+	}
+
+	req.VolumeContext[MkfsOptionsParameter] = "lazy_itable_init=0"
+	if _, err := drv.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() unexpected error: %v", err)
+	}
+	if want := []string{"-E", "lazy_itable_init=0"}; !reflect.DeepEqual(mounter.opts, want) {
+		t.Errorf("volume formatted with options %q, want %q", mounter.opts, want)
+	}
+}
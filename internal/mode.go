@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+)
+
+// Modes of the driver selecting the CSI services it serves
+const (
+	// DriverModeAll serves both controller and node services
+	DriverModeAll = "all"
+	// DriverModeController serves only the controller service, e.g. in a
+	// Deployment on any node of the cluster
+	DriverModeController = "controller"
+	// DriverModeNode serves only the node service, e.g. in a DaemonSet on the
+	// nodes composed by RSD
+	DriverModeNode = "node"
+)
+
+// DriverModes returns the supported driver modes
+func DriverModes() []string {
+	return []string{DriverModeAll, DriverModeController, DriverModeNode}
+}
+
+// ValidateMode returns an error if the driver mode is not supported
+func ValidateMode(mode string) error {
+	switch mode {
+	case DriverModeAll, DriverModeController, DriverModeNode:
+		return nil
+	}
+	return fmt.Errorf("unsupported driver mode %q, supported modes: %v", mode, DriverModes())
+}
+
+// SetMode sets the CSI services the driver serves, DriverModeAll by default
+func (drv *Driver) SetMode(mode string) error {
+	if err := ValidateMode(mode); err != nil {
+		return err
+	}
+	drv.mode = mode
+	return nil
+}
+
+// servesController checks if the driver serves the controller service
+func (drv *Driver) servesController() bool {
+	return drv.mode != DriverModeNode
+}
+
+// servesNode checks if the driver serves the node service
+func (drv *Driver) servesNode() bool {
+	return drv.mode != DriverModeController
+}
+
+// isPublishableNode checks if the controller publishes volumes to the RSD node.
+// The driver serving also the node service publishes volumes only to its own
+// node, the controller alone publishes them to any node.
+func (drv *Driver) isPublishableNode(nodeID string) bool {
+	return !drv.servesNode() || drv.RSDNodeID == nodeID
+}
+
+// adoptMissingVolume adopts RSD volumes again if the volume isn't known to
+// the driver in the node mode, as the volumes are created by the controller
+// running in another process
+func (drv *Driver) adoptMissingVolume(volumeID string) {
+	if drv.servesController() {
+		return
+	}
+
+	drv.volumesRWL.RLock()
+	name, _ := drv.findVolByID(volumeID)
+	drv.volumesRWL.RUnlock()
+	if name != "" {
+		return
+	}
+
+	if err := drv.adoptVolumes(); err != nil {
+		drv.logger.Warning("can't adopt RSD volumes", "volume_id", volumeID, "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestSetMode(t *testing.T) {
+	tests := []struct {
+		mode           string
+		wantController bool
+		wantNode       bool
+		wantErr        bool
+	}{
+		{mode: DriverModeAll, wantController: true, wantNode: true},
+		{mode: DriverModeController, wantController: true},
+		{mode: DriverModeNode, wantNode: true},
+		{mode: "storage", wantController: true, wantNode: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			drv := &Driver{}
+			if err := drv.SetMode(tt.mode); (err != nil) != tt.wantErr {
+				t.Fatalf("SetMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if drv.servesController() != tt.wantController || drv.servesNode() != tt.wantNode {
+				t.Errorf("SetMode(%s) serves controller %v, node %v", tt.mode, drv.servesController(), drv.servesNode())
+			}
+			drv.RSDNodeID = "1"
+			if got := drv.isPublishableNode("2"); got != !tt.wantNode {
+				t.Errorf("SetMode(%s) publishes to other nodes: %v", tt.mode, got)
+			}
+
+			resp, err := drv.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("GetPluginCapabilities() unexpected error: %v", err)
+			}
+			hasController := false
+			for _, capability := range resp.Capabilities {
+				if capability.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+					hasController = true
+				}
+			}
+			if hasController != tt.wantController {
+				t.Errorf("GetPluginCapabilities() advertises controller service: %v, want %v", hasController, tt.wantController)
+			}
+		})
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{mode: DriverModeAll},
+		{mode: DriverModeController},
+		{mode: DriverModeNode},
+		{mode: "", wantErr: true},
+		{mode: "Node", wantErr: true},
+		{mode: "storage", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			if err := ValidateMode(tt.mode); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMode(%q) error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+			}
+		})
+	}
+}
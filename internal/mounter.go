@@ -17,30 +17,60 @@ package csirsd
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
 )
 
 // Mounter interface declares volume mounting and formatting operations
 type Mounter interface {
 	// Mount mounts source to target as fstype with given options.
 	Mount(source string, target string, fstype string, opts ...string) error
+	// MountBlock bind-mounts the source device node to the target file
+	// with given options. The target file is created if it doesn't exist.
+	MountBlock(source, target string, opts ...string) error
 	// Unmount unmounts given target.
 	Unmount(target string) error
+	// RemoveTarget removes the unmounted target directory or block volume
+	// target file if it's empty and looks created by the driver.
+	RemoveTarget(target string) error
 	/// IsMounted checks whether the source device is mounted to the target
 	// path. Source can be empty. In that case it only checks whether the
 	// device is mounted or not.
 	// It returns true if it's mounted.
 	IsMounted(source, target string) (bool, error)
 	// IsFormatted checks whether the source device is formatted or not. It
-	// returns true if the source device is already formatted.
+	// returns true if the source device has a filesystem or a partition table.
 	IsFormatted(source string) (bool, error)
-	// Format formats the source with the given filesystem type
-	Format(source, fsType string) error
+	// GetFilesystemType returns type of the filesystem on the source device
+	// itself, empty if it has none.
+	GetFilesystemType(source string) (string, error)
+	// IsFilesystemEmpty checks whether the fsType filesystem on the source
+	// device has no files by mounting it read only to the target temporarily.
+	IsFilesystemEmpty(source, fsType, target string) (bool, error)
+	// Format formats the source with the given filesystem type and verifies
+	// the written filesystem. Filesystem is labeled if label is not empty.
+	// Options are additional mkfs arguments, e.g. -E lazy_itable_init=0.
+	Format(source, fsType, label string, opts ...string) error
+	// GetFilesystemIDs returns label and UUID of the filesystem on the source
+	// device. They are empty if the filesystem doesn't have them.
+	GetFilesystemIDs(source string) (label, uuid string, err error)
+	// ResizeFilesystem grows the filesystem on the source device mounted
+	// to the target up to the device size.
+	ResizeFilesystem(source, target string) error
+}
+
+type mounter struct {
+	exec Execer
 }
 
-type mounter struct{}
+func newMounter(e Execer, policies policy.Policies) *mounter {
+	return &mounter{exec: withCommandTimeout(e, policies.CommandTimeout)}
+}
 
 func (m *mounter) Mount(source, target, fsType string, opts ...string) error {
 	if fsType == "" {
@@ -65,12 +95,12 @@ func (m *mounter) Mount(source, target, fsType string, opts ...string) error {
 	mountArgs = append(mountArgs, target)
 
 	// create target, os.Mkdirall is noop if it exists
-	err := os.MkdirAll(target, 0750)
+	err := os.MkdirAll(m.exec.HostPath(target), 0750)
 	if err != nil {
 		return err
 	}
 
-	out, err := exec.Command("mount", mountArgs...).CombinedOutput()
+	out, err := m.exec.CombinedOutput("mount", mountArgs...)
 	if err != nil {
 		return fmt.Errorf("mounting failed: %v cmd: 'mount %s' output: %q", err, strings.Join(mountArgs, " "), string(out))
 	}
@@ -78,12 +108,46 @@ func (m *mounter) Mount(source, target, fsType string, opts ...string) error {
 	return nil
 }
 
+func (m *mounter) MountBlock(source, target string, opts ...string) error {
+	if source == "" {
+		return errors.New("source is not specified for mounting the volume")
+	}
+
+	if target == "" {
+		return errors.New("target is not specified for mounting the volume")
+	}
+
+	if err := createFile(m.exec.HostPath(target)); err != nil {
+		return err
+	}
+
+	mountArgs := []string{"-o", strings.Join(append([]string{"bind"}, opts...), ","), source, target}
+	out, err := m.exec.CombinedOutput("mount", mountArgs...)
+	if err != nil {
+		return fmt.Errorf("mounting failed: %v cmd: 'mount %s' output: %q", err, strings.Join(mountArgs, " "), string(out))
+	}
+
+	return nil
+}
+
+// createFile creates the file and its parent directories, it's noop if the file exists
+func createFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
 func (m *mounter) Unmount(target string) error {
 	if target == "" {
 		return errors.New("target is not specified for unmounting the volume")
 	}
 
-	out, err := exec.Command("umount", target).CombinedOutput()
+	out, err := m.exec.CombinedOutput("umount", target)
 	if err != nil {
 		return fmt.Errorf("unmounting failed: %v cmd: 'umount %s' output: %q",
 			err, target, string(out))
@@ -92,37 +156,96 @@ func (m *mounter) Unmount(target string) error {
 	return nil
 }
 
-func (m *mounter) IsFormatted(source string) (bool, error) {
+func (m *mounter) RemoveTarget(target string) error {
+	if target == "" {
+		return errors.New("target is not specified for removing")
+	}
+	return removeTarget(m.exec.HostPath(target))
+}
+
+// deviceSignature returns the filesystem and partition table types of the
+// source device itself, lsblk would report the ones of its partitions otherwise
+func (m *mounter) deviceSignature(source string) (fsType, ptType string, err error) {
 	if source == "" {
-		return false, errors.New("source is not specified")
+		return "", "", errors.New("source is not specified")
 	}
 
 	lsblkCmd := "lsblk"
-	_, err := exec.LookPath(lsblkCmd)
+	_, err = m.exec.LookPath(lsblkCmd)
 	if err != nil {
 		if err == exec.ErrNotFound {
-			return false, fmt.Errorf("%q executable not found in $PATH", lsblkCmd)
+			return "", "", fmt.Errorf("%q executable not found in $PATH", lsblkCmd)
 		}
-		return false, err
+		return "", "", err
 	}
 
-	lsblkArgs := []string{"-n", "-o", "FSTYPE", source}
-	out, err := exec.Command(lsblkCmd, lsblkArgs...).CombinedOutput()
+	// -d skips the partitions, -P prints KEY="value" pairs, so empty values can be told apart
+	lsblkArgs := []string{"-n", "-d", "-P", "-o", "FSTYPE,PTTYPE", source}
+	out, err := m.exec.CombinedOutput(lsblkCmd, lsblkArgs...)
 	if err != nil {
-		return false, fmt.Errorf("checking formatting failed: %v cmd: %q %s, output: %q",
+		return "", "", fmt.Errorf("checking formatting failed: %v cmd: %q %s, output: %q",
 			err, lsblkCmd, strings.Join(lsblkArgs, " "), string(out))
 	}
 
-	if strings.TrimSpace(string(out)) == "" {
-		return false, nil
+	for _, field := range strings.Fields(string(out)) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(parts[1], `"`)
+		switch parts[0] {
+		case "FSTYPE":
+			fsType = value
+		case "PTTYPE":
+			ptType = value
+		}
 	}
+	return fsType, ptType, nil
+}
 
-	return true, nil
+// IsFormatted reports devices with a filesystem or a partition table as formatted,
+// so partitioned devices are never formatted over
+func (m *mounter) IsFormatted(source string) (bool, error) {
+	fsType, ptType, err := m.deviceSignature(source)
+	if err != nil {
+		return false, err
+	}
+	return fsType != "" || ptType != "", nil
+}
+
+func (m *mounter) GetFilesystemType(source string) (string, error) {
+	fsType, _, err := m.deviceSignature(source)
+	return fsType, err
+}
+
+func (m *mounter) IsFilesystemEmpty(source, fsType, target string) (bool, error) {
+	if err := m.Mount(source, target, fsType, "ro"); err != nil {
+		return false, err
+	}
+	entries, readErr := ioutil.ReadDir(m.exec.HostPath(target))
+	if err := m.Unmount(target); err != nil {
+		return false, err
+	}
+	if readErr != nil {
+		return false, readErr
+	}
+	return isEmptyFilesystemRoot(entries), nil
+}
+
+// isEmptyFilesystemRoot checks if the filesystem root directory has only
+// the entries mkfs creates
+func isEmptyFilesystemRoot(entries []os.FileInfo) bool {
+	for _, entry := range entries {
+		if entry.Name() != "lost+found" {
+			return false
+		}
+	}
+	return true
 }
 
 func (m *mounter) IsMounted(source, target string) (bool, error) {
 	findmntCmd := "findmnt"
-	_, err := exec.LookPath(findmntCmd)
+	_, err := m.exec.LookPath(findmntCmd)
 	if err != nil {
 		if err == exec.ErrNotFound {
 			return false, fmt.Errorf("%q executable not found in $PATH", findmntCmd)
@@ -135,7 +258,7 @@ func (m *mounter) IsMounted(source, target string) (bool, error) {
 		findmntArgs = append(findmntArgs, "--source", source)
 	}
 
-	out, err := exec.Command(findmntCmd, findmntArgs...).CombinedOutput()
+	out, err := m.exec.CombinedOutput(findmntCmd, findmntArgs...)
 	if err != nil {
 		// findmnt exits with non zero exit status if it couldn't find anything
 		if strings.TrimSpace(string(out)) == "" {
@@ -153,10 +276,10 @@ func (m *mounter) IsMounted(source, target string) (bool, error) {
 	return true, nil
 }
 
-func (m *mounter) Format(source, fsType string) error {
+func (m *mounter) Format(source, fsType, label string, opts ...string) error {
 	mkfsCmd := fmt.Sprintf("mkfs.%s", fsType)
 
-	_, err := exec.LookPath(mkfsCmd)
+	_, err := m.exec.LookPath(mkfsCmd)
 	if err != nil {
 		if err == exec.ErrNotFound {
 			return fmt.Errorf("%q executable not found in $PATH", mkfsCmd)
@@ -174,16 +297,123 @@ func (m *mounter) Format(source, fsType string) error {
 		return errors.New("source is not specified for formatting the volume")
 	}
 
-	mkfsArgs = append(mkfsArgs, source)
-	if fsType == "ext4" || fsType == "ext3" {
-		mkfsArgs = []string{"-F", source}
+	// force overwriting an existing filesystem, e.g. a half-written one
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		mkfsArgs = append(mkfsArgs, "-F")
+	case "xfs":
+		mkfsArgs = append(mkfsArgs, "-f")
 	}
+	mkfsArgs = append(mkfsArgs, opts...)
+	if label != "" {
+		mkfsArgs = append(mkfsArgs, "-L", label)
+	}
+	mkfsArgs = append(mkfsArgs, source)
 
-	out, err := exec.Command(mkfsCmd, mkfsArgs...).CombinedOutput()
+	out, err := m.exec.CombinedOutput(mkfsCmd, mkfsArgs...)
 	if err != nil {
 		return fmt.Errorf("formatting disk failed: %v cmd: '%s %s' output: %q",
 			err, mkfsCmd, strings.Join(mkfsArgs, " "), string(out))
 	}
 
+	return m.verifyFormat(source, fsType, label)
+}
+
+// verifyFormat probes the source device bypassing the blkid cache and checks
+// it has the fsType filesystem labeled by mkfs, so a filesystem mkfs didn't
+// complete isn't taken for a formatted one
+func (m *mounter) verifyFormat(source, fsType, label string) error {
+	blkidCmd := "blkid"
+	blkidArgs := []string{"-p", "-o", "export", source}
+	out, err := m.exec.CombinedOutput(blkidCmd, blkidArgs...)
+	if err != nil {
+		return fmt.Errorf("verifying formatted disk failed: %v cmd: '%s %s' output: %q",
+			err, blkidCmd, strings.Join(blkidArgs, " "), string(out))
+	}
+
+	var probedType, probedLabel string
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "TYPE":
+			probedType = parts[1]
+		case "LABEL":
+			probedLabel = parts[1]
+		}
+	}
+	if probedType != fsType || (label != "" && probedLabel != label) {
+		return fmt.Errorf("formatting disk %s is incomplete: found %q filesystem labeled %q instead of %q labeled %q",
+			source, probedType, probedLabel, fsType, label)
+	}
+	return nil
+}
+
+func (m *mounter) GetFilesystemIDs(source string) (string, string, error) {
+	if source == "" {
+		return "", "", errors.New("source is not specified")
+	}
+
+	lsblkCmd := "lsblk"
+	// -P prints KEY="value" pairs, so empty values can be told apart
+	lsblkArgs := []string{"-n", "-P", "-o", "LABEL,UUID", source}
+	out, err := m.exec.CombinedOutput(lsblkCmd, lsblkArgs...)
+	if err != nil {
+		return "", "", fmt.Errorf("getting filesystem IDs failed: %v cmd: %q %s, output: %q",
+			err, lsblkCmd, strings.Join(lsblkArgs, " "), string(out))
+	}
+
+	var label, uuid string
+	for _, field := range strings.Fields(string(out)) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(parts[1], `"`)
+		switch parts[0] {
+		case "LABEL":
+			label = value
+		case "UUID":
+			uuid = value
+		}
+	}
+	return label, uuid, nil
+}
+
+func (m *mounter) ResizeFilesystem(source, target string) error {
+	if source == "" {
+		return errors.New("source is not specified for resizing the filesystem")
+	}
+
+	lsblkArgs := []string{"-n", "-o", "FSTYPE", source}
+	out, err := m.exec.CombinedOutput("lsblk", lsblkArgs...)
+	if err != nil {
+		return fmt.Errorf("getting filesystem type failed: %v cmd: 'lsblk %s', output: %q",
+			err, strings.Join(lsblkArgs, " "), string(out))
+	}
+
+	// ext filesystems are resized by the device, xfs by the mount point
+	var resizeCmd string
+	var resizeArgs []string
+	switch fsType := strings.TrimSpace(string(out)); fsType {
+	case "ext2", "ext3", "ext4":
+		resizeCmd, resizeArgs = "resize2fs", []string{source}
+	case "xfs":
+		if target == "" {
+			return errors.New("target is not specified for resizing the xfs filesystem")
+		}
+		resizeCmd, resizeArgs = "xfs_growfs", []string{target}
+	default:
+		return fmt.Errorf("resizing of the filesystem %q on %s is not supported", fsType, source)
+	}
+
+	out, err = m.exec.CombinedOutput(resizeCmd, resizeArgs...)
+	if err != nil {
+		return fmt.Errorf("resizing filesystem failed: %v cmd: '%s %s' output: %q",
+			err, resizeCmd, strings.Join(resizeArgs, " "), string(out))
+	}
+
 	return nil
 }
@@ -0,0 +1,299 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+func TestMounterMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "staging")
+
+	e := &fakeExecer{}
+	if err := newMounter(e, policy.Default()).Mount("/dev/nvme1n1", target, "ext4", "noatime", "ro"); err != nil {
+		t.Fatalf("Mount() unexpected error: %v", err)
+	}
+	want := []string{fmt.Sprintf("mount -t ext4 -o noatime,ro /dev/nvme1n1 %s", target)}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("Mount() executed %v, want %v", e.commands, want)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("Mount() didn't create target: %v", err)
+	}
+
+	if err := newMounter(e, policy.Default()).Mount("/dev/nvme1n1", target, ""); err == nil {
+		t.Error("Mount() without fs type: unexpected success")
+	}
+}
+
+func TestMounterMountBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "publish", "1")
+
+	e := &fakeExecer{}
+	if err := newMounter(e, policy.Default()).MountBlock("/dev/nvme1n1", target, "ro"); err != nil {
+		t.Fatalf("MountBlock() unexpected error: %v", err)
+	}
+	want := []string{fmt.Sprintf("mount -o bind,ro /dev/nvme1n1 %s", target)}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("MountBlock() executed %v, want %v", e.commands, want)
+	}
+	if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("MountBlock() didn't create target file: %v", err)
+	}
+
+	// existing target file is reused
+	if err := newMounter(e, policy.Default()).MountBlock("/dev/nvme1n1", target); err != nil {
+		t.Errorf("MountBlock() to existing target unexpected error: %v", err)
+	}
+}
+
+func TestMounterIsFormatted(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		failure    error
+		want       bool
+		wantFSType string
+		wantErr    bool
+	}{
+		{name: "ext4", output: `FSTYPE="ext4" PTTYPE=""` + "\n", want: true, wantFSType: "ext4"},
+		{name: "xfs", output: `FSTYPE="xfs" PTTYPE=""` + "\n", want: true, wantFSType: "xfs"},
+		{name: "not formatted", output: `FSTYPE="" PTTYPE=""` + "\n"},
+		{name: "partition table", output: `FSTYPE="" PTTYPE="gpt"` + "\n", want: true},
+		{name: "filesystem over stale partition table", output: `FSTYPE="ext4" PTTYPE="dos"` + "\n", want: true, wantFSType: "ext4"},
+		{name: "no output", output: ""},
+		{name: "lsblk failure", failure: fmt.Errorf("exit status 32"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := "lsblk -n -d -P -o FSTYPE,PTTYPE /dev/nvme1n1"
+			e := &fakeExecer{outputs: map[string]string{cmd: tt.output}}
+			if tt.failure != nil {
+				e.failures = map[string]error{cmd: tt.failure}
+			}
+			m := newMounter(e, policy.Default())
+			got, err := m.IsFormatted("/dev/nvme1n1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsFormatted() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsFormatted() = %v, want %v", got, tt.want)
+			}
+
+			fsType, err := m.GetFilesystemType("/dev/nvme1n1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFilesystemType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fsType != tt.wantFSType {
+				t.Errorf("GetFilesystemType() = %q, want %q", fsType, tt.wantFSType)
+			}
+		})
+	}
+
+	if _, err := newMounter(&fakeExecer{}, policy.Default()).IsFormatted(""); err == nil {
+		t.Error("IsFormatted() of empty source succeeded")
+	}
+}
+
+func TestMounterIsFilesystemEmpty(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		unmounted error
+		want      bool
+		wantErr   bool
+	}{
+		{name: "empty", want: true},
+		{name: "lost+found only", files: []string{"lost+found"}, want: true},
+		{name: "data", files: []string{"lost+found", "data"}},
+		{name: "hidden file", files: []string{".keep"}},
+		{name: "unmount failure", unmounted: fmt.Errorf("exit status 32"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := ioutil.TempDir("", "csi-rsd-empty")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(target)
+			for _, file := range tt.files {
+				if err := os.Mkdir(filepath.Join(target, file), 0750); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			e := &fakeExecer{}
+			if tt.unmounted != nil {
+				e.failures = map[string]error{"umount " + target: tt.unmounted}
+			}
+			got, err := newMounter(e, policy.Default()).IsFilesystemEmpty("/dev/nvme1n1", "ext4", target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsFilesystemEmpty() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsFilesystemEmpty() = %v, want %v", got, tt.want)
+			}
+			want := []string{"mount -t ext4 -o ro /dev/nvme1n1 " + target, "umount " + target}
+			if strings.Join(e.commands, "\n") != strings.Join(want, "\n") {
+				t.Errorf("commands %q, want %q", e.commands, want)
+			}
+		})
+	}
+}
+
+func TestMounterFormat(t *testing.T) {
+	const blkid = "blkid -p -o export /dev/nvme1n1"
+	tests := []struct {
+		fsType  string
+		label   string
+		opts    []string
+		probed  string
+		want    string
+		wantErr bool
+	}{
+		{fsType: "ext4", probed: "DEVNAME=/dev/nvme1n1\nTYPE=ext4\n", want: "mkfs.ext4 -F /dev/nvme1n1"},
+		{fsType: "ext4", label: "rsd-1", probed: "LABEL=rsd-1\nTYPE=ext4\n", want: "mkfs.ext4 -F -L rsd-1 /dev/nvme1n1"},
+		{fsType: "xfs", probed: "TYPE=xfs\n", want: "mkfs.xfs -f /dev/nvme1n1"},
+		{fsType: "xfs", label: "rsd-1", probed: "LABEL=rsd-1\nTYPE=xfs\n", want: "mkfs.xfs -f -L rsd-1 /dev/nvme1n1"},
+		{fsType: "ext4", opts: []string{"-E", "lazy_itable_init=0"}, probed: "TYPE=ext4\n", want: "mkfs.ext4 -F -E lazy_itable_init=0 /dev/nvme1n1"},
+		{fsType: "xfs", label: "rsd-1", opts: []string{"-m", "reflink=1"}, probed: "LABEL=rsd-1\nTYPE=xfs\n", want: "mkfs.xfs -f -m reflink=1 -L rsd-1 /dev/nvme1n1"},
+		{fsType: "ext4", label: "rsd-1", probed: "TYPE=ext4\n", want: "mkfs.ext4 -F -L rsd-1 /dev/nvme1n1", wantErr: true},
+		{fsType: "xfs", label: "rsd-1", probed: "LABEL=rsd-1\nTYPE=ext4\n", want: "mkfs.xfs -f -L rsd-1 /dev/nvme1n1", wantErr: true},
+		{fsType: "xfs", probed: "", want: "mkfs.xfs -f /dev/nvme1n1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			e := &fakeExecer{outputs: map[string]string{blkid: tt.probed}}
+			err := newMounter(e, policy.Default()).Format("/dev/nvme1n1", tt.fsType, tt.label, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if want := []string{tt.want, blkid}; !reflect.DeepEqual(e.commands, want) {
+				t.Errorf("Format() executed %v, want %v", e.commands, want)
+			}
+		})
+	}
+
+	e := &fakeExecer{failures: map[string]error{blkid: fmt.Errorf("exit status 2")}}
+	if err := newMounter(e, policy.Default()).Format("/dev/nvme1n1", "ext4", ""); err == nil {
+		t.Error("Format() succeeded without a probed filesystem")
+	}
+}
+
+func TestMounterGetFilesystemIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		wantLabel string
+		wantUUID  string
+	}{
+		{name: "labeled", output: `LABEL="rsd-1" UUID="0b7dbe5f-7d4a-4b43-8e3e-4c1e1a6b0e41"` + "\n", wantLabel: "rsd-1", wantUUID: "0b7dbe5f-7d4a-4b43-8e3e-4c1e1a6b0e41"},
+		{name: "not labeled", output: `LABEL="" UUID="0b7dbe5f-7d4a-4b43-8e3e-4c1e1a6b0e41"` + "\n", wantUUID: "0b7dbe5f-7d4a-4b43-8e3e-4c1e1a6b0e41"},
+		{name: "not formatted", output: `LABEL="" UUID=""` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &fakeExecer{outputs: map[string]string{"lsblk -n -P -o LABEL,UUID /dev/nvme1n1": tt.output}}
+			label, uuid, err := newMounter(e, policy.Default()).GetFilesystemIDs("/dev/nvme1n1")
+			if err != nil {
+				t.Fatalf("GetFilesystemIDs() unexpected error: %v", err)
+			}
+			if label != tt.wantLabel || uuid != tt.wantUUID {
+				t.Errorf("GetFilesystemIDs() = %q, %q, want %q, %q", label, uuid, tt.wantLabel, tt.wantUUID)
+			}
+		})
+	}
+}
+
+func TestSystemdMounter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-mounter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "staging")
+
+	e := &fakeExecer{}
+	m := newSystemdMounter(e, policy.Default())
+	if err := m.Mount("/dev/nvme1n1", target, "ext4", "noatime"); err != nil {
+		t.Fatalf("Mount() unexpected error: %v", err)
+	}
+	if err := m.Unmount(target); err != nil {
+		t.Fatalf("Unmount() unexpected error: %v", err)
+	}
+	want := []string{
+		fmt.Sprintf("systemd-mount --fsck=no --collect -t ext4 -o noatime /dev/nvme1n1 %s", target),
+		fmt.Sprintf("systemd-mount --umount %s", target),
+	}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("executed %v, want %v", e.commands, want)
+	}
+
+	drv := &Driver{}
+	if err := drv.SetMountBackend(MountBackendSystemd); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := drv.mounter.(*systemdMounter); !ok {
+		t.Errorf("SetMountBackend(%q) mounter is %T", MountBackendSystemd, drv.mounter)
+	}
+	if err := drv.SetMountBackend("fuse"); err == nil {
+		t.Error("SetMountBackend(\"fuse\") unexpected success")
+	}
+}
+
+func TestMounterResizeFilesystem(t *testing.T) {
+	tests := []struct {
+		fsType  string
+		want    string
+		wantErr bool
+	}{
+		{fsType: "ext4", want: "resize2fs /dev/nvme1n1"},
+		{fsType: "xfs", want: "xfs_growfs /staging"},
+		{fsType: "btrfs", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fsType, func(t *testing.T) {
+			e := &fakeExecer{outputs: map[string]string{"lsblk -n -o FSTYPE /dev/nvme1n1": tt.fsType + "\n"}}
+			err := newMounter(e, policy.Default()).ResizeFilesystem("/dev/nvme1n1", "/staging")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResizeFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if last := e.commands[len(e.commands)-1]; last != tt.want {
+				t.Errorf("ResizeFilesystem() executed %q, want %q", last, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// The mount helper is a privileged daemon running mount, mkfs and nvme tools
+// for the node plugin over a local gRPC socket, so the node plugin itself can
+// run unprivileged. The helper authorizes every command: volumes are mounted
+// only in the allowed paths and only devices of the allowed subsystem NQNs
+// are connected, formatted and mounted. Messages are JSON encoded, so the
+// service doesn't need generated protobuf code.
+
+// mountHelperCodec is the name of the JSON codec of the mount helper messages
+const mountHelperCodec = "csirsd-json"
+
+// mountHelperRequest is the tool and its arguments the helper runs or looks up
+type mountHelperRequest struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+}
+
+// mountHelperResponse is the result of the tool, Error is empty if it succeeded
+type mountHelperResponse struct {
+	Output []byte `json:"output,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// jsonCodec encodes the mount helper messages as JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return mountHelperCodec
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// mountHelperService is the gRPC service of the mount helper
+type mountHelperService interface {
+	Run(ctx context.Context, req *mountHelperRequest) (*mountHelperResponse, error)
+	LookPath(ctx context.Context, req *mountHelperRequest) (*mountHelperResponse, error)
+}
+
+// mountHelperHandler returns the gRPC handler of the mount helper method
+func mountHelperHandler(method string, call func(mountHelperService, context.Context, *mountHelperRequest) (*mountHelperResponse, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &mountHelperRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return call(srv.(mountHelperService), ctx, req)
+		},
+	}
+}
+
+var mountHelperServiceDesc = grpc.ServiceDesc{
+	ServiceName: "csirsd.MountHelper",
+	HandlerType: (*mountHelperService)(nil),
+	Methods: []grpc.MethodDesc{
+		mountHelperHandler("Run", mountHelperService.Run),
+		mountHelperHandler("LookPath", mountHelperService.LookPath),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "mounthelper.go",
+}
+
+// MountHelperOptions configure the mount helper
+type MountHelperOptions struct {
+	// Address is the path of the unix socket the helper listens on
+	Address string
+	// HostRoot is the directory the tools are run chrooted into, the helper root if it's empty
+	HostRoot string
+	// AllowedPaths are the directories volumes may be mounted under
+	AllowedPaths []string
+	// AllowedNQNs are prefixes of the subsystem NQNs of the volumes
+	// which may be connected, formatted and mounted
+	AllowedNQNs []string
+	// CommandTimeout kills the tools running longer, no limit if it's 0
+	CommandTimeout time.Duration
+}
+
+// MountHelper runs mount, mkfs and nvme tools authorized by its options
+// for the node plugin
+type MountHelper struct {
+	address string
+	server  *mountHelperServer
+	srv     *grpc.Server
+}
+
+// mountHelperServer implements the mount helper service
+type mountHelperServer struct {
+	exec   Execer
+	policy *mountHelperPolicy
+}
+
+// NewMountHelper returns the mount helper. Allowed paths and NQNs must be set.
+func NewMountHelper(opts MountHelperOptions) (*MountHelper, error) {
+	if opts.Address == "" {
+		return nil, errors.New("mount helper socket is not set")
+	}
+	if len(opts.AllowedPaths) == 0 {
+		return nil, errors.New("no paths are allowed to mount volumes under")
+	}
+	if len(opts.AllowedNQNs) == 0 {
+		return nil, errors.New("no subsystem NQNs of the volumes are allowed")
+	}
+	for _, path := range opts.AllowedPaths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("allowed path %q is not absolute", path)
+		}
+	}
+
+	policies := policy.Default()
+	policies.CommandTimeout = opts.CommandTimeout
+	execer := withCommandTimeout(newExecer(opts.HostRoot), opts.CommandTimeout)
+	return &MountHelper{
+		address: opts.Address,
+		server: &mountHelperServer{
+			exec:   execer,
+			policy: newMountHelperPolicy(execer, newNVMe(execer, policies), opts.AllowedPaths, opts.AllowedNQNs),
+		},
+	}, nil
+}
+
+// Run serves the node plugin on the unix socket until the helper is stopped.
+// The socket is accessible only to the helper user and group.
+func (h *MountHelper) Run() error {
+	if err := os.MkdirAll(filepath.Dir(h.address), 0750); err != nil {
+		return err
+	}
+	if err := os.Remove(h.address); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove mount helper socket %s: %v", h.address, err)
+	}
+	listener, err := net.Listen("unix", h.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen mount helper socket %s: %v", h.address, err)
+	}
+	if err := os.Chmod(h.address, 0660); err != nil {
+		listener.Close() // nolint: errcheck
+		return err
+	}
+
+	h.srv = grpc.NewServer()
+	h.srv.RegisterService(&mountHelperServiceDesc, h.server)
+	log.Printf("mount helper listening on %s", h.address)
+	return h.srv.Serve(listener)
+}
+
+// Stop stops the helper after the commands in progress are finished
+func (h *MountHelper) Stop() {
+	if h.srv != nil {
+		h.srv.GracefulStop()
+	}
+}
+
+// Run runs the tool if the policy allows it. Failures of the tool
+// are returned in the response, denied commands as PermissionDenied.
+func (h *mountHelperServer) Run(ctx context.Context, req *mountHelperRequest) (*mountHelperResponse, error) {
+	if err := h.policy.authorize(req.Name, req.Args); err != nil {
+		log.Printf("denied %s %v: %v", req.Name, req.Args, err)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	out, err := h.exec.CombinedOutput(req.Name, req.Args...)
+	resp := &mountHelperResponse{Output: out}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, nil
+}
+
+// LookPath looks the tool up if the helper may run it
+func (h *mountHelperServer) LookPath(ctx context.Context, req *mountHelperRequest) (*mountHelperResponse, error) {
+	if !h.policy.knownTool(req.Name) {
+		return nil, status.Errorf(codes.PermissionDenied, "%q is not run by the mount helper", req.Name)
+	}
+	path, err := h.exec.LookPath(req.Name)
+	resp := &mountHelperResponse{Path: path}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp, nil
+}
+
+// helperExecer runs the tools by the mount helper. Paths are translated
+// to the host root of the node plugin.
+type helperExecer struct {
+	conn *grpc.ClientConn
+	root string
+}
+
+// dialMountHelper connects to the mount helper unix socket. The connection
+// is established in the background and re-established if the helper restarts,
+// the commands fail while the helper isn't reachable.
+func dialMountHelper(address string) (*grpc.ClientConn, error) {
+	return grpc.Dial(address,
+		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(mountHelperCodec)),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+}
+
+func (e *helperExecer) call(method, name string, args []string) (*mountHelperResponse, error) {
+	resp := &mountHelperResponse{}
+	err := e.conn.Invoke(context.Background(), "/csirsd.MountHelper/"+method, &mountHelperRequest{Name: name, Args: args}, resp)
+	if err != nil {
+		return nil, fmt.Errorf("mount helper: %v", status.Convert(err).Message())
+	}
+	return resp, nil
+}
+
+func (e *helperExecer) LookPath(file string) (string, error) {
+	resp, err := e.call("LookPath", file, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Path, nil
+}
+
+func (e *helperExecer) CombinedOutput(name string, args ...string) ([]byte, error) {
+	resp, err := e.call("Run", name, args)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return resp.Output, errors.New(resp.Error)
+	}
+	return resp.Output, nil
+}
+
+func (e *helperExecer) HostPath(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+// SetMountHelper makes the node run mount, mkfs and nvme tools by the mount
+// helper listening on the unix socket instead of running them itself
+func (drv *Driver) SetMountHelper(address string) error {
+	conn, err := dialMountHelper(address)
+	if err != nil {
+		return fmt.Errorf("can't connect to mount helper %s: %v", address, err)
+	}
+	drv.mountHelper = conn
+	drv.setTools()
+	return nil
+}
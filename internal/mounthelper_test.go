@@ -0,0 +1,361 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// newHelperRoot returns host root with kubelet directories and NVMe device
+// nodes, /dev/nvme1n1 is the device of the allowed subsystem of testNVMe
+func newHelperRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "csi-rsd-helper-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"var/lib/kubelet/pods/1/volumes", "dev/disk/by-label", "etc"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"dev/nvme1n1", "dev/nvme2n1", "dev/sda"} {
+		if err := ioutil.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"dev/disk/by-label/pvc-1":      "../../nvme1n1",
+		"dev/disk/by-label/root":       "../../sda",
+		"var/lib/kubelet/pods/1/etc":   "../../../../../etc",
+		"var/lib/kubelet/pods/1/stage": "volumes",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// rootedExecer is fakeExecer of the host root, as mount helper policy
+// resolves paths in it and finds the mounts with findmnt
+type rootedExecer struct {
+	*fakeExecer
+	root string
+}
+
+func (e rootedExecer) HostPath(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+// stagingMounts returns the findmnt outputs of the mount helper policy
+// for the device mounted to the staging paths
+func stagingMounts(device string, stagingPaths ...string) map[string]string {
+	outputs := map[string]string{}
+	for _, path := range stagingPaths {
+		outputs["findmnt --noheadings --output SOURCE --mountpoint "+path] = device + "\n"
+	}
+	return outputs
+}
+
+func TestMountHelperPolicy(t *testing.T) {
+	root := newHelperRoot(t)
+	defer os.RemoveAll(root)
+	e := rootedExecer{root: root, fakeExecer: &fakeExecer{
+		outputs: stagingMounts("/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/staged"),
+	}}
+	e.outputs["findmnt --noheadings --output SOURCE --mountpoint /var/lib/kubelet/pods/1/volumes/other"] = "/dev/nvme2n1[/dir]\n"
+	e.failures = map[string]error{"findmnt --noheadings --output SOURCE --mountpoint /var/lib/kubelet/pods/1/volumes/unmounted": errors.New("exit status 1")}
+	p := newMountHelperPolicy(e, &testNVMe{}, []string{"/var/lib/kubelet/"}, []string{"nqn.2014-08.org.nvmexpress:uuid:1"})
+
+	tests := []struct {
+		name    string
+		tool    string
+		args    []string
+		wantErr bool
+	}{
+		{"mount", "mount", []string{"-t", "ext4", "-o", "noatime", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv/mount"}, false},
+		{"mount by label", "mount", []string{"-t", "ext4", "LABEL=pvc-1", "/var/lib/kubelet/pods/1/stage/pv"}, false},
+		{"bind mount", "mount", []string{"-o", "bind", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"mount other subsystem", "mount", []string{"-t", "ext4", "/dev/nvme2n1", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount non-NVMe device", "mount", []string{"-t", "ext4", "/dev/sda", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount non-NVMe label", "mount", []string{"-t", "ext4", "LABEL=root", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount label path", "mount", []string{"-t", "ext4", "LABEL=../../sda", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount outside", "mount", []string{"-t", "ext4", "/dev/nvme1n1", "/etc"}, true},
+		{"mount traversal", "mount", []string{"-t", "ext4", "/dev/nvme1n1", "/var/lib/kubelet/../../../etc"}, true},
+		{"mount symlink outside", "mount", []string{"-t", "ext4", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/etc"}, true},
+		{"mount over allowed path", "mount", []string{"-t", "ext4", "/dev/nvme1n1", "/var/lib/kubelet"}, true},
+		{"mount relative", "mount", []string{"-t", "ext4", "/dev/nvme1n1", "var/lib/kubelet/pods/1"}, true},
+		{"bind mount read-only", "mount", []string{"-o", "bind,ro", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"bind mount staging", "mount", []string{"-t", "ext4", "-o", "noatime,bind", "/var/lib/kubelet/pods/1/volumes/staged", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"bind mount staging by symlink", "mount", []string{"-t", "ext4", "-o", "bind", "/var/lib/kubelet/pods/1/stage/staged", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"bind mount other subsystem", "mount", []string{"-t", "ext4", "-o", "bind", "/var/lib/kubelet/pods/1/volumes/other", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"bind mount not mounted", "mount", []string{"-t", "ext4", "-o", "bind", "/var/lib/kubelet/pods/1/volumes/unmounted", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"bind mount outside", "mount", []string{"-o", "bind", "/etc", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"bind mount staging without bind", "mount", []string{"-t", "ext4", "/var/lib/kubelet/pods/1/volumes/staged", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"remount", "mount", []string{"-o", "remount,rw", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount unknown option", "mount", []string{"-t", "ext4", "-o", "suid", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"mount unknown flag", "mount", []string{"--make-shared", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"systemd-mount", "systemd-mount", []string{"--fsck=no", "--collect", "-t", "xfs", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"systemd-mount staging", "systemd-mount", []string{"--fsck=no", "--collect", "-t", "xfs", "-o", "bind", "/var/lib/kubelet/pods/1/volumes/staged", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"systemd-mount unknown option", "systemd-mount", []string{"-t", "xfs", "-o", "remount", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"}, true},
+		{"systemd-mount umount", "systemd-mount", []string{"--umount", "/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"systemd-mount umount outside", "systemd-mount", []string{"--umount", "/"}, true},
+		{"umount", "umount", []string{"/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"umount outside", "umount", []string{"/var/lib"}, true},
+		{"format", "mkfs.ext4", []string{"-F", "-L", "pvc-1", "/dev/nvme1n1"}, false},
+		{"format with options", "mkfs.ext4", []string{"-F", "-E", "lazy_itable_init=0,discard", "/dev/nvme1n1"}, false},
+		{"format xfs with options", "mkfs.xfs", []string{"-f", "-m", "reflink=1", "/dev/nvme1n1"}, false},
+		{"format unknown option", "mkfs.ext4", []string{"-E", "root_owner=0:0", "/dev/nvme1n1"}, true},
+		{"format option of other filesystem", "mkfs.xfs", []string{"-m", "lazy_itable_init=0", "/dev/nvme1n1"}, true},
+		{"format option by other flag", "mkfs.ext4", []string{"-m", "lazy_itable_init=0", "/dev/nvme1n1"}, true},
+		{"format empty options", "mkfs.ext4", []string{"-E=", "/dev/nvme1n1"}, true},
+		{"format other device", "mkfs.xfs", []string{"/dev/sda"}, true},
+		{"format unknown filesystem", "mkfs.vfat", []string{"/dev/nvme1n1"}, true},
+		{"probe", "blkid", []string{"-p", "-o", "export", "/dev/nvme1n1"}, false},
+		{"probe by label", "blkid", []string{"-p", "-o", "export", "LABEL=pvc-1"}, false},
+		{"probe other device", "blkid", []string{"-p", "-o", "export", "/dev/sda"}, true},
+		{"probe cached", "blkid", []string{"-o", "export", "/dev/nvme1n1"}, true},
+		{"probe other output", "blkid", []string{"-p", "-o", "value", "/dev/nvme1n1"}, true},
+		{"probe all devices", "blkid", []string{"-p", "-o", "export"}, true},
+		{"resize ext4", "resize2fs", []string{"/dev/nvme1n1"}, false},
+		{"resize xfs", "xfs_growfs", []string{"/var/lib/kubelet/pods/1/volumes/pv"}, false},
+		{"connect", "nvme", []string{"connect", "--transport", "rdma", "--traddr", "10.0.0.1", "--trsvcid", "4420", "--nqn", "nqn.2014-08.org.nvmexpress:uuid:1", "--hostnqn", "nqn.host"}, false},
+		{"connect other subsystem", "nvme", []string{"connect", "--transport", "rdma", "--nqn", "nqn.2014-08.org.nvmexpress:uuid:2"}, true},
+		{"connect without NQN", "nvme", []string{"connect", "--transport", "rdma"}, true},
+		{"disconnect", "nvme", []string{"disconnect", "--device", "/dev/nvme1n1"}, false},
+		{"disconnect other device", "nvme", []string{"disconnect", "--device", "/dev/nvme2n1"}, true},
+		{"disconnect all", "nvme", []string{"disconnect-all"}, true},
+		{"list", "nvme", []string{"list", "-o", "json"}, false},
+		{"findmnt", "findmnt", []string{"--mountpoint", "/"}, false},
+		{"shell", "sh", []string{"-c", "true"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.authorize(tt.tool, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("authorize(%s %v) error = %v, wantErr %v", tt.tool, tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// runMountHelper serves the mount helper running the commands by e
+// on a socket in the temporary directory until stop is called
+func runMountHelper(t *testing.T, e Execer, policy *mountHelperPolicy) (h *MountHelper, stop func()) {
+	dir, err := ioutil.TempDir("", "csi-rsd-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = &MountHelper{
+		address: filepath.Join(dir, "helper.sock"),
+		server:  &mountHelperServer{exec: e, policy: policy},
+	}
+	served := make(chan error, 1)
+	go func() { served <- h.Run() }()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(h.address); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return h, func() {
+		h.Stop()
+		if err := <-served; err != nil {
+			t.Errorf("Run() unexpected error: %v", err)
+		}
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+}
+
+func TestMountHelper(t *testing.T) {
+	root := newHelperRoot(t)
+	defer os.RemoveAll(root)
+
+	e := &fakeExecer{failures: map[string]error{"umount /var/lib/kubelet/pods/1/volumes/pv": errors.New("exit status 32")}}
+	h, stop := runMountHelper(t, e, newMountHelperPolicy(&chrootExecer{root: root}, &testNVMe{}, []string{"/var/lib/kubelet"}, []string{"nqn.2014-08.org.nvmexpress:uuid:"}))
+	defer stop()
+
+	drv := &Driver{}
+	if err := drv.SetMountHelper(h.address); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.mountHelper.Close() // nolint: errcheck
+	execer, ok := drv.nvme.(*nvme).exec.(*helperExecer)
+	if !ok {
+		t.Fatalf("nvme runs commands by %T, want the mount helper", drv.nvme.(*nvme).exec)
+	}
+
+	if err := drv.mounter.Mount("/dev/nvme1n1", filepath.Join(root, "var/lib/kubelet/pods/1/volumes/pv"), "ext4"); err == nil {
+		t.Error("Mount() to the path of the node plugin root succeeded")
+	}
+	if _, err := execer.CombinedOutput("mount", "-t", "ext4", "/dev/nvme1n1", "/var/lib/kubelet/pods/1/volumes/pv"); err != nil {
+		t.Errorf("mount unexpected error: %v", err)
+	}
+	out, err := execer.CombinedOutput("umount", "/var/lib/kubelet/pods/1/volumes/pv")
+	if err == nil || err.Error() != "exit status 32" || string(out) != "exit status 32" {
+		t.Errorf("umount = %q, %v, want the output and error of the tool", out, err)
+	}
+	if _, err := execer.CombinedOutput("mount", "-t", "ext4", "/dev/sda", "/var/lib/kubelet/pods/1/volumes/pv"); err == nil || !strings.Contains(err.Error(), "not a connected NVMe device") {
+		t.Errorf("mount of /dev/sda error = %v, want the denial", err)
+	}
+	if path, err := execer.LookPath("mkfs.xfs"); err != nil || path != "/usr/bin/mkfs.xfs" {
+		t.Errorf("LookPath(mkfs.xfs) = %q, %v", path, err)
+	}
+	if _, err := execer.LookPath("sh"); err == nil {
+		t.Error("LookPath(sh) unexpected success")
+	}
+
+	want := []string{
+		"mount -t ext4 /dev/nvme1n1 /var/lib/kubelet/pods/1/volumes/pv",
+		"umount /var/lib/kubelet/pods/1/volumes/pv",
+	}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("helper ran %v, want %v", e.commands, want)
+	}
+}
+
+func TestMountHelperFormat(t *testing.T) {
+	root := newHelperRoot(t)
+	defer os.RemoveAll(root)
+
+	const blkid = "blkid -p -o export /dev/nvme1n1"
+	e := &fakeExecer{outputs: map[string]string{blkid: "LABEL=pvc-1\nTYPE=ext4\n"}}
+	h, stop := runMountHelper(t, e, newMountHelperPolicy(&chrootExecer{root: root}, &testNVMe{}, []string{"/var/lib/kubelet"}, []string{"nqn.2014-08.org.nvmexpress:uuid:"}))
+	defer stop()
+
+	drv := &Driver{}
+	if err := drv.SetMountHelper(h.address); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.mountHelper.Close() // nolint: errcheck
+
+	if err := drv.mounter.Format("/dev/nvme1n1", "ext4", "pvc-1"); err != nil {
+		t.Errorf("Format() unexpected error: %v", err)
+	}
+	if err := drv.mounter.Format("/dev/sda", "ext4", "root"); err == nil || !strings.Contains(err.Error(), "not a connected NVMe device") {
+		t.Errorf("Format() of /dev/sda error = %v, want the denial", err)
+	}
+
+	want := []string{"mkfs.ext4 -F -L pvc-1 /dev/nvme1n1", blkid}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("helper ran %v, want %v", e.commands, want)
+	}
+}
+
+func TestMountHelperStageAndPublish(t *testing.T) {
+	root := newHelperRoot(t)
+	defer os.RemoveAll(root)
+	const (
+		stagingPath = "/var/lib/kubelet/plugins/pv/globalmount"
+		targetPath  = "/var/lib/kubelet/pods/1/volumes/pv/mount"
+	)
+	e := &fakeExecer{outputs: map[string]string{
+		"lsblk -n -d -P -o FSTYPE,PTTYPE /dev/nvme1n1": `FSTYPE="ext4" PTTYPE=""`,
+		// the staging mount is checked before publishing
+		"findmnt --mountpoint " + stagingPath: stagingPath + " /dev/nvme1n1 ext4 rw",
+	}}
+	p := newMountHelperPolicy(rootedExecer{root: root, fakeExecer: &fakeExecer{outputs: stagingMounts("/dev/nvme1n1", stagingPath)}},
+		&testNVMe{}, []string{"/var/lib/kubelet"}, []string{"nqn.2014-08.org.nvmexpress:uuid:"})
+	h, stop := runMountHelper(t, e, p)
+	defer stop()
+
+	drv := &Driver{
+		volumes: map[string]*Volume{
+			"pvc-1": {
+				Name:        "pvc-1",
+				CSIVolume:   &csi.Volume{VolumeId: "1"},
+				RSDVolume:   &rsd.Volume{},
+				EndPoint:    &endpoint.Portal{Transport: "rdma", Address: "192.168.1.1", Port: 4420, NQN: "nqn.2014-08.org.nvmexpress:uuid:1"},
+				IsPublished: true,
+				TargetPaths: map[string]bool{},
+			},
+		},
+	}
+	if err := drv.SetMountHelper(h.address); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.mountHelper.Close() // nolint: errcheck
+	drv.SetHostRoot(root)
+	drv.nvme = &testNVMe{}
+
+	_, err := drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume() unexpected error: %v", err)
+	}
+	_, err = drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "1",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		Readonly:          true,
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume() unexpected error: %v", err)
+	}
+
+	var mounts []string
+	for _, cmd := range e.commands {
+		if strings.HasPrefix(cmd, "mount ") {
+			mounts = append(mounts, cmd)
+		}
+	}
+	want := []string{
+		"mount -t ext4 /dev/nvme1n1 " + stagingPath,
+		"mount -t ext4 -o bind,ro " + stagingPath + " " + targetPath,
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("helper mounted %v, want %v", mounts, want)
+	}
+
+	// the staging path of another volume is not published
+	_, err = drv.execer.CombinedOutput("mount", "-t", "ext4", "-o", "bind", "/var/lib/kubelet/plugins/other/globalmount", targetPath)
+	if err == nil || !strings.Contains(err.Error(), "is not a mount point") {
+		t.Errorf("bind mount of unstaged path error = %v, want the denial", err)
+	}
+}
+
+func TestNewMountHelper(t *testing.T) {
+	valid := MountHelperOptions{Address: "/run/csi-rsd/helper.sock", AllowedPaths: []string{"/var/lib/kubelet"}, AllowedNQNs: []string{"nqn.2014-08.org.nvmexpress"}}
+	if _, err := NewMountHelper(valid); err != nil {
+		t.Errorf("NewMountHelper() unexpected error: %v", err)
+	}
+	for name, modify := range map[string]func(*MountHelperOptions){
+		"no socket":     func(opts *MountHelperOptions) { opts.Address = "" },
+		"no paths":      func(opts *MountHelperOptions) { opts.AllowedPaths = nil },
+		"relative path": func(opts *MountHelperOptions) { opts.AllowedPaths = []string{"var/lib/kubelet"} },
+		"no NQNs":       func(opts *MountHelperOptions) { opts.AllowedNQNs = nil },
+	} {
+		opts := valid
+		modify(&opts)
+		if _, err := NewMountHelper(opts); err == nil {
+			t.Errorf("NewMountHelper() with %s unexpected success", name)
+		}
+	}
+}
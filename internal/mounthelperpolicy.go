@@ -0,0 +1,384 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountHelperPolicy authorizes the commands the mount helper runs: volumes
+// are mounted only under the allowed paths and only NVMe devices of the
+// subsystems with the allowed NQN prefixes are connected, formatted and mounted
+type mountHelperPolicy struct {
+	exec  Execer
+	nvme  NVMe
+	paths []string
+	nqns  []string
+}
+
+func newMountHelperPolicy(e Execer, n NVMe, paths, nqns []string) *mountHelperPolicy {
+	p := &mountHelperPolicy{exec: e, nvme: n, nqns: nqns}
+	for _, path := range paths {
+		p.paths = append(p.paths, filepath.Clean(path))
+	}
+	return p
+}
+
+// toolArgs declares the flags of the tool, the ones not declared are denied
+type toolArgs struct {
+	// values are the flags followed by a value
+	values map[string]bool
+	// switches are the flags without a value
+	switches map[string]bool
+}
+
+// parse returns positional arguments and values of the flags
+func (spec toolArgs) parse(args []string) ([]string, map[string]string, error) {
+	var positional []string
+	values := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 && spec.values[parts[0]] {
+			values[parts[0]] = parts[1]
+			continue
+		}
+		switch {
+		case spec.switches[arg]:
+			values[arg] = ""
+		case spec.values[arg] && i+1 < len(args):
+			i++
+			values[arg] = args[i]
+		default:
+			return nil, nil, fmt.Errorf("flag %s is not allowed", arg)
+		}
+	}
+	return positional, values, nil
+}
+
+var (
+	mountArgs         = toolArgs{values: map[string]bool{"-t": true, "-o": true}}
+	systemdMountArgs  = toolArgs{values: map[string]bool{"-t": true, "-o": true, "--fsck": true}, switches: map[string]bool{"--collect": true, "--umount": true}}
+	formatArgs        = toolArgs{values: map[string]bool{"-L": true, "-E": true, "-m": true}, switches: map[string]bool{"-F": true, "-f": true}}
+	nvmeConnectArgs   = toolArgs{values: map[string]bool{"--transport": true, "--traddr": true, "--trsvcid": true, "--nqn": true, "--hostnqn": true, "--dhchap-secret": true, "--dhchap-ctrl-secret": true}}
+	nvmeDisconnectArg = toolArgs{values: map[string]bool{"--device": true}}
+)
+
+// allowedMountOptions are the mount options the driver mounts volumes with:
+// bind and ro of NodePublishVolume and the common mount flags of volume
+// capabilities. Options changing existing mounts, e.g. remount, are denied.
+var allowedMountOptions = map[string]bool{
+	"bind": true, "ro": true, "rw": true,
+	"noatime": true, "nodiratime": true, "relatime": true, "strictatime": true,
+	"nodev": true, "noexec": true, "nosuid": true,
+	"sync": true, "dirsync": true, "discard": true,
+}
+
+// readOnlyNVMeCommands are nvme subcommands only reading the devices
+var readOnlyNVMeCommands = map[string]bool{"list": true, "id-ctrl": true, "smart-log": true}
+
+// mountHelperTools authorize arguments of the tools the mount helper runs
+var mountHelperTools = map[string]func(p *mountHelperPolicy, args []string) error{
+	"mount":         (*mountHelperPolicy).authorizeMount,
+	"systemd-mount": (*mountHelperPolicy).authorizeSystemdMount,
+	"umount":        (*mountHelperPolicy).authorizeTarget,
+	"nvme":          (*mountHelperPolicy).authorizeNVMe,
+	"resize2fs":     (*mountHelperPolicy).authorizeResize,
+	"xfs_growfs":    (*mountHelperPolicy).authorizeTarget,
+	"blkid":         (*mountHelperPolicy).authorizeProbe,
+	"findmnt":       func(*mountHelperPolicy, []string) error { return nil },
+	"lsblk":         func(*mountHelperPolicy, []string) error { return nil },
+}
+
+// knownTool checks if the mount helper runs the tool
+func (p *mountHelperPolicy) knownTool(name string) bool {
+	if strings.HasPrefix(name, "mkfs.") {
+		return formatFilesystems[strings.TrimPrefix(name, "mkfs.")]
+	}
+	_, ok := mountHelperTools[name]
+	return ok
+}
+
+// authorize returns an error if the mount helper must not run the command
+func (p *mountHelperPolicy) authorize(name string, args []string) error {
+	if !p.knownTool(name) {
+		return fmt.Errorf("%q is not run by the mount helper", name)
+	}
+	if strings.HasPrefix(name, "mkfs.") {
+		return p.authorizeFormat(strings.TrimPrefix(name, "mkfs."), args)
+	}
+	return mountHelperTools[name](p, args)
+}
+
+// authorizeMount allows mounting devices of the allowed subsystems to the
+// allowed paths and bind-mounting their staging mounts with the allowed options
+func (p *mountHelperPolicy) authorizeMount(args []string) error {
+	positional, values, err := mountArgs.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("mount needs source and target, got %v", positional)
+	}
+	if err := p.authorizeMountSource(positional[0], values["-o"]); err != nil {
+		return err
+	}
+	return p.authorizePath(positional[1])
+}
+
+// authorizeMountSource allows the comma-separated allowedMountOptions and
+// the source they mount: a device of the allowed subsystems or, for bind
+// mounts, its staging mount
+func (p *mountHelperPolicy) authorizeMountSource(source, options string) error {
+	bind := false
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			if !allowedMountOptions[option] {
+				return fmt.Errorf("mount option %q is not allowed", option)
+			}
+			bind = bind || option == "bind"
+		}
+	}
+	if bind {
+		return p.authorizeBindSource(source)
+	}
+	return p.authorizeDevice(source)
+}
+
+// authorizeBindSource allows bind-mounting devices of the allowed subsystems,
+// as block volumes are published, and the mounts of the devices under the
+// allowed paths, as the staging mounts of filesystem volumes are published
+func (p *mountHelperPolicy) authorizeBindSource(source string) error {
+	resolved, err := p.resolve(source)
+	if err != nil {
+		return err
+	}
+	if isUnder(resolved, "/dev") {
+		return p.authorizeDevice(source)
+	}
+	if err := p.authorizePath(source); err != nil {
+		return err
+	}
+	device, err := p.mountedDevice(resolved)
+	if err != nil {
+		return err
+	}
+	if device == "" {
+		return fmt.Errorf("%s is not a mount point", source)
+	}
+	if err := p.authorizeDevice(device); err != nil {
+		return fmt.Errorf("%s is not a staging mount: %v", source, err)
+	}
+	return nil
+}
+
+// mountedDevice returns the source of the mount point in the host root,
+// or an empty string if nothing is mounted there
+func (p *mountHelperPolicy) mountedDevice(mountpoint string) (string, error) {
+	out, err := p.exec.CombinedOutput("findmnt", "--noheadings", "--output", "SOURCE", "--mountpoint", mountpoint)
+	source := strings.TrimSpace(string(out))
+	if err != nil {
+		// findmnt exits with non zero exit status if it couldn't find anything
+		if source == "" {
+			return "", nil
+		}
+		return "", fmt.Errorf("can't find the mount of %s: %v, output: %q", mountpoint, err, source)
+	}
+	// sources of bind mounts are followed by the bound directory, e.g. /dev/nvme0n1[/dir]
+	if i := strings.Index(source, "["); i >= 0 {
+		source = source[:i]
+	}
+	return source, nil
+}
+
+// authorizeSystemdMount allows mounting like authorizeMount and unmounting like authorizeTarget
+func (p *mountHelperPolicy) authorizeSystemdMount(args []string) error {
+	positional, values, err := systemdMountArgs.parse(args)
+	if err != nil {
+		return err
+	}
+	if _, umount := values["--umount"]; umount {
+		if len(positional) != 1 {
+			return fmt.Errorf("systemd-mount --umount needs target, got %v", positional)
+		}
+		return p.authorizePath(positional[0])
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("systemd-mount needs source and target, got %v", positional)
+	}
+	if err := p.authorizeMountSource(positional[0], values["-o"]); err != nil {
+		return err
+	}
+	return p.authorizePath(positional[1])
+}
+
+// authorizeTarget allows the tool taking a single path under the allowed paths
+func (p *mountHelperPolicy) authorizeTarget(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a single target is needed, got %v", args)
+	}
+	return p.authorizePath(args[0])
+}
+
+// authorizeResize allows the tool taking a single device of the allowed subsystems
+func (p *mountHelperPolicy) authorizeResize(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a single device is needed, got %v", args)
+	}
+	return p.authorizeDevice(args[0])
+}
+
+// authorizeProbe allows probing a device of the allowed subsystems for its
+// filesystem in the export format only, as formatted filesystems are verified
+func (p *mountHelperPolicy) authorizeProbe(args []string) error {
+	if len(args) != 4 || args[0] != "-p" || args[1] != "-o" || args[2] != "export" {
+		return fmt.Errorf("blkid is allowed only with -p -o export and a device, got %v", args)
+	}
+	return p.authorizeDevice(args[3])
+}
+
+// authorizeFormat allows formatting devices of the allowed subsystems with
+// the mkfs options allowed in MkfsOptionsParameter
+func (p *mountHelperPolicy) authorizeFormat(fsType string, args []string) error {
+	positional, values, err := formatArgs.parse(args)
+	if err != nil {
+		return err
+	}
+	for _, flag := range []string{"-E", "-m"} {
+		options, exists := values[flag]
+		if !exists {
+			continue
+		}
+		formatted, err := mkfsArgs(fsType, options)
+		if err != nil {
+			return err
+		}
+		if len(formatted) == 0 || formatted[0] != flag {
+			return fmt.Errorf("options %q are not passed by %s", options, flag)
+		}
+	}
+	return p.authorizeResize(positional)
+}
+
+// authorizeNVMe allows connecting the allowed subsystems, disconnecting their
+// devices and the subcommands reading the devices
+func (p *mountHelperPolicy) authorizeNVMe(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("nvme subcommand is missing")
+	}
+	switch command := args[0]; {
+	case readOnlyNVMeCommands[command]:
+		return nil
+	case command == "connect":
+		_, values, err := nvmeConnectArgs.parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return p.authorizeNQN(values["--nqn"])
+	case command == "disconnect":
+		_, values, err := nvmeDisconnectArg.parse(args[1:])
+		if err != nil {
+			return err
+		}
+		return p.authorizeDevice(values["--device"])
+	default:
+		return fmt.Errorf("nvme %s is not allowed", command)
+	}
+}
+
+// authorizeNQN allows the subsystem NQNs starting with the allowed prefixes
+func (p *mountHelperPolicy) authorizeNQN(nqn string) error {
+	for _, prefix := range p.nqns {
+		if nqn != "" && strings.HasPrefix(nqn, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("subsystem NQN %q is not allowed", nqn)
+}
+
+// authorizePath allows paths under the allowed ones, symlinks are resolved
+func (p *mountHelperPolicy) authorizePath(path string) error {
+	resolved, err := p.resolve(path)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range p.paths {
+		if resolved != allowed && isUnder(resolved, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("path %s is not under the allowed paths %v", path, p.paths)
+}
+
+// authorizeDevice allows NVMe devices of the allowed subsystems. The device
+// may be referred to by LABEL= or UUID= of its filesystem as well.
+func (p *mountHelperPolicy) authorizeDevice(source string) error {
+	path := source
+	for prefix, dir := range map[string]string{"LABEL=": "/dev/disk/by-label", "UUID=": "/dev/disk/by-uuid"} {
+		if value := strings.TrimPrefix(source, prefix); value != source {
+			if value == "" || strings.Contains(value, "/") {
+				return fmt.Errorf("invalid device %q", source)
+			}
+			path = filepath.Join(dir, value)
+		}
+	}
+
+	device, err := p.resolve(path)
+	if err != nil {
+		return err
+	}
+	if !isUnder(device, "/dev") {
+		return fmt.Errorf("%s is not a device", source)
+	}
+	devices, err := p.nvme.List()
+	if err != nil {
+		return err
+	}
+	nqn, ok := devices[device]
+	if !ok {
+		return fmt.Errorf("%s is not a connected NVMe device", source)
+	}
+	return p.authorizeNQN(nqn)
+}
+
+// resolve returns the absolute path with symlinks in the host root resolved.
+// The part of the path which doesn't exist yet is kept.
+func (p *mountHelperPolicy) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %q is not absolute", path)
+	}
+	root := p.exec.HostPath("/")
+	dir, rest := filepath.Clean(path), ""
+	for {
+		real, err := filepath.EvalSymlinks(p.exec.HostPath(dir))
+		if err == nil {
+			rel, err := filepath.Rel(root, real)
+			if err != nil || !isUnder(real, root) {
+				return "", fmt.Errorf("path %s resolves outside of the host root", path)
+			}
+			return filepath.Join("/", rel, rest), nil
+		}
+		if !os.IsNotExist(err) || dir == "/" {
+			return "", err
+		}
+		dir, rest = filepath.Dir(dir), filepath.Join(filepath.Base(dir), rest)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sync"
+)
+
+const (
+	// maxDescriptionLength limits the RSD volume Description set by the driver
+	maxDescriptionLength = 255
+	// nameHashLength is the number of hex digits of the name hash in the sanitized names
+	nameHashLength = 16
+)
+
+// unsafeNameChars matches characters of the CSI volume names which are replaced
+// in the RSD volume Description
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// volumeNames maps sanitized names stored in RSD back to the CSI volume names
+type volumeNames struct {
+	mu       sync.Mutex
+	csiNames map[string]string
+}
+
+func (names *volumeNames) add(rsdName, name string) {
+	names.mu.Lock()
+	defer names.mu.Unlock()
+	if names.csiNames == nil {
+		names.csiNames = map[string]string{}
+	}
+	names.csiNames[rsdName] = name
+}
+
+// csiName returns the CSI volume name of the name stored in RSD, which is the
+// same name unless it was sanitized
+func (names *volumeNames) csiName(rsdName string) string {
+	names.mu.Lock()
+	defer names.mu.Unlock()
+	if name, ok := names.csiNames[rsdName]; ok {
+		return name
+	}
+	return rsdName
+}
+
+// rsdVolumeName returns the CSI volume name as it's stored in the RSD volume
+// Description. Names with unsafe characters or too long to fit in the Description
+// are sanitized: unsafe characters are replaced with '_' and the name is truncated
+// and suffixed with a hash of the whole name, e.g. pvc-0123...-1a2b3c4d5e6f7a8b.
+// Other names, including the names generated by Kubernetes, are kept intact.
+func (drv *Driver) rsdVolumeName(name string) string {
+	maxLength := maxDescriptionLength - len(DriverName) - len(drv.ClusterID) - 2*len(descriptionSeparator)
+	if len(name) <= maxLength && !unsafeNameChars.MatchString(name) {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(hash[:])[:nameHashLength]
+	sanitized := unsafeNameChars.ReplaceAllString(name, "_")
+	if keep := maxLength - len(suffix); len(sanitized) > keep {
+		if keep < 0 {
+			keep = 0
+		}
+		sanitized = sanitized[:keep]
+	}
+	rsdName := sanitized + suffix
+	drv.names.add(rsdName, name)
+	return rsdName
+}
+
+// lookupVolume returns the volume with the CSI name. The volumes with sanitized
+// names adopted after the driver restart are recorded under the sanitized names
+// until their CSI names are known, they are renamed when they're looked up.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) lookupVolume(name string) (*Volume, bool) {
+	if vol, exists := drv.volumes[name]; exists {
+		return vol, true
+	}
+	rsdName := drv.rsdVolumeName(name)
+	vol, exists := drv.volumes[rsdName]
+	if !exists || rsdName == name {
+		return nil, false
+	}
+
+	delete(drv.volumes, rsdName)
+	vol.Name = name
+	vol.CSIVolume.VolumeContext["name"] = name
+	drv.volumes[name] = vol
+	return vol, true
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestRSDVolumeName(t *testing.T) {
+	drv := &Driver{ClusterID: "cluster1"}
+	maxLength := maxDescriptionLength - len(DriverName+":cluster1:")
+	long := "pvc-" + strings.Repeat("a", maxLength)
+
+	tests := []struct {
+		name   string
+		want   string
+		prefix string
+	}{
+		{name: "pvc-a385b1a2-1b6e-4d4c-a8f3-0f9b8f9d6c3e", want: "pvc-a385b1a2-1b6e-4d4c-a8f3-0f9b8f9d6c3e"},
+		{name: "pvc-" + strings.Repeat("a", maxLength-4), want: "pvc-" + strings.Repeat("a", maxLength-4)},
+		{name: "pvc-ns/claim:1", prefix: "pvc-ns_claim_1-"},
+		{name: long, prefix: long[:maxLength-nameHashLength-1] + "-"},
+	}
+	for _, tt := range tests {
+		got := drv.rsdVolumeName(tt.name)
+		if tt.want != "" && got != tt.want {
+			t.Errorf("rsdVolumeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.prefix != "" && (!strings.HasPrefix(got, tt.prefix) || len(got) != len(tt.prefix)+nameHashLength) {
+			t.Errorf("rsdVolumeName(%q) = %q, want %q with hash", tt.name, got, tt.prefix)
+		}
+		if len(drv.volumeDescription(tt.name)) > maxDescriptionLength {
+			t.Errorf("description of %q is longer than %d", tt.name, maxDescriptionLength)
+		}
+		if again := drv.rsdVolumeName(tt.name); again != got {
+			t.Errorf("rsdVolumeName(%q) is not deterministic: %q, %q", tt.name, got, again)
+		}
+		if name, ok := drv.volumeNameFromDescription(drv.volumeDescription(tt.name)); !ok || name != tt.name {
+			t.Errorf("volumeNameFromDescription() = %q, %v, want %q", name, ok, tt.name)
+		}
+	}
+
+	if drv.rsdVolumeName(long+"1") == drv.rsdVolumeName(long+"2") {
+		t.Error("truncated names with different suffixes are the same")
+	}
+}
+
+func TestAdoptSanitizedVolume(t *testing.T) {
+	const name = "pvc-ns/claim"
+	description := (&Driver{ClusterID: "cluster1"}).volumeDescription(name)
+
+	// restarted driver doesn't know the CSI name of the sanitized name
+	drv := &Driver{
+		ClusterID:        "cluster1",
+		VolumeNamePrefix: "pvc-",
+		rsdClient: &TestClient{results: map[string]string{
+			"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+			"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+			"/redfish/v1/StorageServices/1/Volumes":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}`,
+			"/redfish/v1/StorageServices/1/Volumes/2": `{"Id": "2", "CapacityBytes": 100, "Description": "` + description + `"}`,
+		}},
+		volumes: map[string]*Volume{},
+	}
+	if err := drv.adoptVolumes(); err != nil {
+		t.Fatalf("adoptVolumes() unexpected error: %v", err)
+	}
+
+	resp, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: name,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() unexpected error: %v", err)
+	}
+	if resp.Volume.VolumeId != "2" || resp.Volume.VolumeContext["name"] != name {
+		t.Errorf("CreateVolume() = %v, want adopted volume 2 named %s", resp.Volume, name)
+	}
+	if len(drv.volumes) != 1 || drv.volumes[name] == nil || drv.volumes[name].Name != name {
+		t.Errorf("adopted volume is not renamed: %v", drv.volumes)
+	}
+
+	// the volume is known by its CSI name when it's adopted again
+	if err := drv.adoptVolumes(); err != nil || len(drv.volumes) != 1 {
+		t.Errorf("adoptVolumes() = %v, volumes %v, want the same volume", err, drv.volumes)
+	}
+}
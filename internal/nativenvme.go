@@ -0,0 +1,260 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"golang.org/x/net/context"
+)
+
+// Backends connecting the volumes on the node
+const (
+	// NVMeBackendNative connects and disconnects the volumes through the kernel
+	// /dev/nvme-fabrics device and sysfs, nvme-cli is used only if they are unavailable
+	NVMeBackendNative = "native"
+	// NVMeBackendCLI runs nvme-cli for all the NVMe operations
+	NVMeBackendCLI = "cli"
+)
+
+// NVMeBackends returns the supported NVMe backends
+func NVMeBackends() []string {
+	return []string{NVMeBackendNative, NVMeBackendCLI}
+}
+
+// SetNVMeBackend sets the backend connecting the volumes on the node.
+// The mount helper always runs nvme-cli, as writing the kernel interfaces
+// needs the privileges the node plugin runs without then.
+func (drv *Driver) SetNVMeBackend(backend string) error {
+	switch backend {
+	case NVMeBackendNative, NVMeBackendCLI:
+	default:
+		return fmt.Errorf("unsupported NVMe backend %q, supported backends: %v", backend, NVMeBackends())
+	}
+	drv.nvmeBackend = backend
+	drv.setTools()
+	return nil
+}
+
+// nvmeControllerRegexp matches names of the NVMe controllers in sysfs
+var nvmeControllerRegexp = regexp.MustCompile(`^nvme[0-9]+$`)
+
+// smartLogSize is the size of the SMART / Health Information log page
+const smartLogSize = 512
+
+// nativeNVMe connects the volumes by writing the connection options to the
+// nvme-fabrics device, like nvme-cli does, and finds and disconnects their
+// devices in sysfs. The embedded nvme-cli implementation is the fallback
+// when the fabrics device or sysfs can't be used, e.g. in containers
+// without /dev of the host.
+type nativeNVMe struct {
+	*nvme
+	// fabrics is the nvme-fabrics device the connections are created with
+	fabrics string
+	// connect writes the options to the fabrics device and returns its response
+	connect func(fabrics, options string) (string, error)
+	// readSmartLog reads the SMART log page of the device
+	readSmartLog func(device string) ([]byte, error)
+	// logger logs falling back to nvme-cli
+	logger Logger
+}
+
+func newNativeNVMe(e Execer, policies policy.Policies, logger Logger) *nativeNVMe {
+	return &nativeNVMe{
+		nvme:         newNVMe(e, policies),
+		logger:       logger,
+		fabrics:      "/dev/nvme-fabrics",
+		connect:      writeFabrics,
+		readSmartLog: readSmartLogPage,
+	}
+}
+
+// fabricsOptions returns the nvme-fabrics options of the connection
+func fabricsOptions(transport, traddr, trsvcid, nqn, hostnqn string, auth FabricAuth) string {
+	options := []string{
+		"nqn=" + nqn,
+		"transport=" + transport,
+		"traddr=" + traddr,
+		"trsvcid=" + trsvcid,
+		"hostnqn=" + hostnqn,
+	}
+	if auth.HostKey != "" {
+		options = append(options, "dhchap_secret="+auth.HostKey)
+	}
+	if auth.CtrlKey != "" {
+		options = append(options, "dhchap_ctrl_secret="+auth.CtrlKey)
+	}
+	return strings.Join(options, ",")
+}
+
+// writeFabrics creates the connection by writing the options to the fabrics
+// device, the kernel responds with the instance of the created controller
+func writeFabrics(fabrics, options string) (string, error) {
+	f, err := os.OpenFile(fabrics, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint: errcheck
+
+	if _, err := f.WriteString(options); err != nil {
+		// the options contain the authentication keys, they are not reported
+		return "", &fabricsError{err: err}
+	}
+	response := make([]byte, 256)
+	n, err := f.Read(response)
+	if err != nil {
+		return "", &fabricsError{err: fmt.Errorf("can't read the response: %v", err)}
+	}
+	return string(response[:n]), nil
+}
+
+// fabricsError is the failure of the connection reported by the kernel,
+// the connection is not retried with nvme-cli then
+type fabricsError struct {
+	err error
+}
+
+func (e *fabricsError) Error() string {
+	return fmt.Sprintf("nvme-fabrics connect failed: %v", e.err)
+}
+
+// parseFabricsResponse returns the controller of the response, e.g. nvme3 of instance=3,cntlid=1
+func parseFabricsResponse(response string) (string, error) {
+	for _, field := range strings.Split(strings.TrimSpace(response), ",") {
+		if strings.HasPrefix(field, "instance=") {
+			instance, err := strconv.Atoi(strings.TrimPrefix(field, "instance="))
+			if err != nil {
+				return "", fmt.Errorf("invalid nvme-fabrics response %q: %v", response, err)
+			}
+			return fmt.Sprintf("nvme%d", instance), nil
+		}
+	}
+	return "", fmt.Errorf("no controller instance in nvme-fabrics response %q", response)
+}
+
+// Connect connects volume to the node through the fabrics device and
+// waits for its device to appear. nvme-cli is run if the fabrics device can't be opened.
+func (n *nativeNVMe) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error) {
+	logger := contextLogger(ctx, n.logger)
+	// listen before connecting, so the device added right away is not missed
+	events, err := n.listen()
+	if err != nil {
+		logger.V(LogLevelState).Info("can't listen to kernel uevents, polling NVMe devices", "error", err)
+		events = nil
+	} else {
+		defer events.Close() // nolint: errcheck
+	}
+
+	response, err := n.connect(n.exec.HostPath(n.fabrics), fabricsOptions(transport, traddr, trsvcid, nqn, hostnqn, auth))
+	if err != nil {
+		if _, failed := err.(*fabricsError); failed {
+			return "", err
+		}
+		logger.V(LogLevelState).Info("can't use the fabrics device, connecting with nvme-cli", "fabrics", n.fabrics, "error", err)
+		return n.nvme.Connect(ctx, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn, auth)
+	}
+	if _, err := parseFabricsResponse(response); err != nil {
+		return "", err
+	}
+
+	return n.findDevice(ctx, nqn, events)
+}
+
+// controllers returns sysfs directories of the controllers of the namespace
+// device, which are all controllers of the subsystem for multipath namespaces
+func (n *nativeNVMe) controllers(device string) ([]string, error) {
+	dir := filepath.Join(n.exec.HostPath(n.sysBlock), filepath.Base(device), "device")
+	if _, err := os.Stat(filepath.Join(dir, "delete_controller")); err == nil {
+		return []string{dir}, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	for _, entry := range entries {
+		if nvmeControllerRegexp.MatchString(entry.Name()) {
+			controllers = append(controllers, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(controllers) == 0 {
+		return nil, fmt.Errorf("no NVMe controllers of %s found in sysfs", device)
+	}
+	return controllers, nil
+}
+
+// Disconnect deletes the controllers of the device in sysfs.
+// nvme-cli is run if they can't be found.
+func (n *nativeNVMe) Disconnect(device string) error {
+	controllers, err := n.controllers(device)
+	if err != nil {
+		n.logger.V(LogLevelState).Info("can't find controllers in sysfs, disconnecting with nvme-cli", "device", device, "error", err)
+		return n.nvme.Disconnect(device)
+	}
+	for _, controller := range controllers {
+		if err := ioutil.WriteFile(filepath.Join(controller, "delete_controller"), []byte("1"), 0200); err != nil {
+			return fmt.Errorf("can't delete NVMe controller %s of %s: %v", filepath.Base(controller), device, err)
+		}
+	}
+	return nil
+}
+
+// parseSmartLog decodes the attributes of the SMART / Health Information log page
+func parseSmartLog(page []byte) (*SmartLog, error) {
+	if len(page) < smartLogSize {
+		return nil, fmt.Errorf("SMART log page of %d bytes is too short", len(page))
+	}
+	// 128-bit little-endian counter, as float64 like nvme-cli reports it
+	counter := func(offset int) float64 {
+		low := binary.LittleEndian.Uint64(page[offset:])
+		high := binary.LittleEndian.Uint64(page[offset+8:])
+		return float64(high)*math.Pow(2, 64) + float64(low)
+	}
+	return &SmartLog{
+		CriticalWarning:  int64(page[0]),
+		Temperature:      int64(binary.LittleEndian.Uint16(page[1:])),
+		MediaErrors:      counter(160),
+		NumErrLogEntries: counter(176),
+	}, nil
+}
+
+// SmartLog reads the SMART log page with the NVMe admin command ioctl,
+// or with nvme-cli if the ioctl fails
+func (n *nativeNVMe) SmartLog(device string) (*SmartLog, error) {
+	page, err := n.readSmartLog(n.exec.HostPath(device))
+	if err != nil {
+		return n.nvme.SmartLog(device)
+	}
+	return parseSmartLog(page)
+}
+
+// List returns connected NVMe devices read from sysfs, or listed by nvme-cli if sysfs is not available
+func (n *nativeNVMe) List() (map[string]string, error) {
+	devices, err := n.sysfsDevices()
+	if err != nil {
+		return n.listDevices()
+	}
+	return devices, nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+// nativeNVMeTest creates native NVMe with sysfs in a temporary directory
+func nativeNVMeTest(t *testing.T, e *fakeExecer) (*nativeNVMe, string) {
+	dir, err := ioutil.TempDir("", "csi-rsd-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := policy.Default()
+	policies.DeviceWait = policy.Retry{Attempts: 2, Delay: time.Millisecond}
+	n := newNativeNVMe(e, policies, NewLogger(0))
+	n.sysBlock = dir
+	n.listen = func() (deviceEvents, error) { return nil, errors.New("no uevents") }
+	return n, dir
+}
+
+// addSysfsController creates the controller directory the namespace device links to
+func addSysfsController(t *testing.T, dir string, path ...string) string {
+	controller := filepath.Join(append([]string{dir}, path...)...)
+	if err := os.MkdirAll(controller, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(controller, "delete_controller"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return controller
+}
+
+func TestFabricsOptions(t *testing.T) {
+	got := fabricsOptions("tcp", "10.0.0.1", "4420", "nqn.volume", "nqn.host", FabricAuth{HostKey: "DHHC-1:00:host:", CtrlKey: "DHHC-1:00:ctrl:"})
+	want := "nqn=nqn.volume,transport=tcp,traddr=10.0.0.1,trsvcid=4420,hostnqn=nqn.host,dhchap_secret=DHHC-1:00:host:,dhchap_ctrl_secret=DHHC-1:00:ctrl:"
+	if got != want {
+		t.Errorf("fabricsOptions() = %q, want %q", got, want)
+	}
+	if got := fabricsOptions("rdma", "10.0.0.1", "4420", "nqn.volume", "nqn.host", FabricAuth{}); got != "nqn=nqn.volume,transport=rdma,traddr=10.0.0.1,trsvcid=4420,hostnqn=nqn.host" {
+		t.Errorf("fabricsOptions() without authentication = %q", got)
+	}
+}
+
+func TestParseFabricsResponse(t *testing.T) {
+	tests := []struct {
+		response string
+		want     string
+		wantErr  bool
+	}{
+		{response: "instance=3,cntlid=1\n", want: "nvme3"},
+		{response: "cntlid=1,instance=12", want: "nvme12"},
+		{response: "cntlid=1", wantErr: true},
+		{response: "instance=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFabricsResponse(tt.response)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFabricsResponse(%q) error = %v, wantErr %v", tt.response, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseFabricsResponse(%q) = %q, want %q", tt.response, got, tt.want)
+		}
+	}
+}
+
+func TestNativeNVMeConnect(t *testing.T) {
+	e := &fakeExecer{}
+	n, dir := nativeNVMeTest(t, e)
+	defer os.RemoveAll(dir)
+
+	var written string
+	n.connect = func(fabrics, options string) (string, error) {
+		written = options
+		if fabrics != "/dev/nvme-fabrics" {
+			t.Errorf("connected with %s, want /dev/nvme-fabrics", fabrics)
+		}
+		controller := addSysfsController(t, dir, "nvme3n1", "device")
+		if err := ioutil.WriteFile(filepath.Join(controller, "subsysnqn"), []byte("nqn.volume\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return "instance=3,cntlid=1\n", nil
+	}
+
+	device, err := n.Connect(context.Background(), "tcp", "10.0.0.1", "ipv4", "4420", "nqn.volume", "nqn.host", FabricAuth{})
+	if err != nil {
+		t.Fatalf("Connect() unexpected error: %v", err)
+	}
+	if device != "/dev/nvme3n1" {
+		t.Errorf("Connect() = %q, want /dev/nvme3n1", device)
+	}
+	if written != "nqn=nqn.volume,transport=tcp,traddr=10.0.0.1,trsvcid=4420,hostnqn=nqn.host" {
+		t.Errorf("unexpected nvme-fabrics options %q", written)
+	}
+	if len(e.commands) != 0 {
+		t.Errorf("unexpected commands %v", e.commands)
+	}
+}
+
+func TestNativeNVMeConnectFallback(t *testing.T) {
+	e := &fakeExecer{}
+	n, dir := nativeNVMeTest(t, e)
+	defer os.RemoveAll(dir)
+	n.fabrics = filepath.Join(dir, "nonexistent")
+	n.connect = writeFabrics
+	addSysfsController(t, dir, "nvme1n1", "device")
+	if err := ioutil.WriteFile(filepath.Join(dir, "nvme1n1", "device", "subsysnqn"), []byte("nqn.volume\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withLogger(context.Background(), NewLogger(LogLevelState).WithValues("request_id", 3))
+	var device string
+	var err error
+	logged := captureLog(func() {
+		device, err = n.Connect(ctx, "rdma", "10.0.0.1", "ipv4", "4420", "nqn.volume", "nqn.host", FabricAuth{})
+	})
+	if err != nil {
+		t.Fatalf("Connect() unexpected error: %v", err)
+	}
+	if device != "/dev/nvme1n1" {
+		t.Errorf("Connect() = %q, want /dev/nvme1n1", device)
+	}
+	if !strings.Contains(strings.Join(logged, "\n"), "connecting with nvme-cli request_id=3 fabrics="+n.fabrics) {
+		t.Errorf("Connect() logged %q, want the fallback with the request logger", logged)
+	}
+	want := []string{"nvme connect --transport rdma --traddr 10.0.0.1 --trsvcid 4420 --nqn nqn.volume --hostnqn nqn.host"}
+	if !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("commands = %v, want %v", e.commands, want)
+	}
+}
+
+func TestNativeNVMeConnectFailure(t *testing.T) {
+	e := &fakeExecer{}
+	n, dir := nativeNVMeTest(t, e)
+	defer os.RemoveAll(dir)
+	n.connect = func(fabrics, options string) (string, error) {
+		return "", &fabricsError{err: errors.New("input/output error")}
+	}
+
+	if _, err := n.Connect(context.Background(), "tcp", "10.0.0.1", "ipv4", "4420", "nqn.volume", "nqn.host", FabricAuth{HostKey: "DHHC-1:00:secret:"}); err == nil {
+		t.Fatal("Connect() unexpected success")
+	}
+	if len(e.commands) != 0 {
+		t.Errorf("connection refused by the kernel is retried with %v", e.commands)
+	}
+}
+
+func TestNativeNVMeDisconnect(t *testing.T) {
+	e := &fakeExecer{}
+	n, dir := nativeNVMeTest(t, e)
+	defer os.RemoveAll(dir)
+
+	single := addSysfsController(t, dir, "nvme1n1", "device")
+	// multipath namespace device is the subsystem with the controllers
+	multipath := []string{
+		addSysfsController(t, dir, "nvme2n1", "device", "nvme2"),
+		addSysfsController(t, dir, "nvme2n1", "device", "nvme3"),
+	}
+
+	for device, controllers := range map[string][]string{"/dev/nvme1n1": {single}, "/dev/nvme2n1": multipath} {
+		if err := n.Disconnect(device); err != nil {
+			t.Fatalf("Disconnect(%s) unexpected error: %v", device, err)
+		}
+		for _, controller := range controllers {
+			deleted, err := ioutil.ReadFile(filepath.Join(controller, "delete_controller"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(deleted) != "1" {
+				t.Errorf("controller %s of %s is not deleted", controller, device)
+			}
+		}
+	}
+	if len(e.commands) != 0 {
+		t.Errorf("unexpected commands %v", e.commands)
+	}
+
+	if err := n.Disconnect("/dev/nvme9n1"); err != nil {
+		t.Fatalf("Disconnect() unexpected error: %v", err)
+	}
+	if want := []string{"nvme disconnect --device /dev/nvme9n1"}; !reflect.DeepEqual(e.commands, want) {
+		t.Errorf("commands = %v, want %v", e.commands, want)
+	}
+}
+
+func TestNativeNVMeSmartLog(t *testing.T) {
+	page := make([]byte, smartLogSize)
+	page[0] = 0x04
+	page[1], page[2] = 0x2a, 0x01 // 298 K
+	page[160] = 7
+	page[176+8] = 1 // 2^64 error log entries
+
+	e := &fakeExecer{outputs: map[string]string{"nvme smart-log /dev/nvme2n1 -o json": `{"temperature": 300}`}}
+	n, dir := nativeNVMeTest(t, e)
+	defer os.RemoveAll(dir)
+	n.readSmartLog = func(device string) ([]byte, error) {
+		if device == "/dev/nvme1n1" {
+			return page, nil
+		}
+		return nil, fmt.Errorf("can't open %s", device)
+	}
+
+	got, err := n.SmartLog("/dev/nvme1n1")
+	if err != nil {
+		t.Fatalf("SmartLog() unexpected error: %v", err)
+	}
+	want := &SmartLog{CriticalWarning: 4, Temperature: 298, MediaErrors: 7, NumErrLogEntries: 18446744073709551616}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SmartLog() = %+v, want %+v", got, want)
+	}
+	if len(e.commands) != 0 {
+		t.Errorf("unexpected commands %v", e.commands)
+	}
+
+	got, err = n.SmartLog("/dev/nvme2n1")
+	if err != nil {
+		t.Fatalf("SmartLog() unexpected error: %v", err)
+	}
+	if got.Temperature != 300 {
+		t.Errorf("SmartLog() read by nvme-cli = %+v, want temperature 300", got)
+	}
+
+	if _, err := parseSmartLog(page[:100]); err == nil {
+		t.Error("parseSmartLog() of a short page unexpected success")
+	}
+}
+
+func TestSetNVMeBackend(t *testing.T) {
+	drv := &Driver{}
+	if err := drv.SetNVMeBackend(NVMeBackendNative); err != nil {
+		t.Fatalf("SetNVMeBackend() unexpected error: %v", err)
+	}
+	if _, ok := drv.nvme.(*nativeNVMe); !ok {
+		t.Errorf("native backend uses %T", drv.nvme)
+	}
+	if err := drv.SetNVMeBackend(NVMeBackendCLI); err != nil {
+		t.Fatalf("SetNVMeBackend() unexpected error: %v", err)
+	}
+	if _, ok := drv.nvme.(*nvme); !ok {
+		t.Errorf("cli backend uses %T", drv.nvme)
+	}
+	if err := drv.SetNVMeBackend("spdk"); err == nil {
+		t.Error("SetNVMeBackend() of unsupported backend unexpected success")
+	}
+}
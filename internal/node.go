@@ -16,7 +16,6 @@ package csirsd
 
 import (
 	"context"
-	"log"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
@@ -27,17 +26,26 @@ import (
 // This is used so the CO knows where to place the workload. The result of this
 // function will be used by the CO in ControllerPublishVolume.
 func (drv *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	log.Printf("NodeGetInfo request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeGetInfo request", "request", req)
 
 	resp := &csi.NodeGetInfoResponse{NodeId: drv.RSDNodeID}
+	if drv.topology {
+		topology, err := drv.nodeTopology(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "can't get RSD storage services reachable from the node: %v", err)
+		}
+		resp.AccessibleTopology = topology
+	}
 
-	log.Printf("NodeGetInfo response: %v", resp)
+	logger.V(LogLevelRequest).Info("NodeGetInfo response", "response", resp)
 	return resp, nil
 }
 
 // NodeGetCapabilities returns the supported capabilities of the node server
 func (drv *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	log.Printf("NodeGetCapabilities request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeGetCapabilities request", "request", req)
 
 	resp := &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
@@ -51,20 +59,46 @@ func (drv *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapa
 			&csi.NodeServiceCapability{
 				Type: &csi.NodeServiceCapability_Rpc{
 					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+						Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 					},
 				},
 			},
 		},
 	}
+	for _, cap := range accessModeNodeCapabilities() {
+		resp.Capabilities = append(resp.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{Type: cap},
+			},
+		})
+	}
+	if drv.volumeStatsAvailable() {
+		resp.Capabilities = append(resp.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+				},
+			},
+		}, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+				},
+			},
+		})
+	}
 
-	log.Printf("NodeGetCapabilities response: %v", resp)
+	logger.V(LogLevelRequest).Info("NodeGetCapabilities response", "response", resp)
 	return resp, nil
 }
 
 // NodeGetVolumeStats returns the volume capacity statistics available for the given volume.
 func (drv *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	log.Printf("NodeGetVolumeStats request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeGetVolumeStats request", "request", req)
+	if !drv.volumeStatsAvailable() {
+		return nil, status.Error(codes.Unimplemented, "volume stats need the node journal to track target paths of the volumes")
+	}
 	if req == nil || req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID can't be empty")
 	}
@@ -78,37 +112,86 @@ func (drv *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolum
 	//	return nil, status.Error(codes.InvalidArgument, "Volume Path must be absolute")
 	//}
 
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+
 	// Check if volume path exists
 	_, vol := drv.findVolByID(req.VolumeId)
 	if vol == nil {
 		return nil, status.Errorf(codes.NotFound, "Volume Id '%s' not found", req.VolumeId)
 	}
 
+	// target paths are lost if the driver restarted after publishing
+	if !vol.IsStaged {
+		if stagingTargetPath := drv.journal.stagingTargetPath(req.VolumeId); stagingTargetPath != "" {
+			if err := drv.restoreJournaled(vol, stagingTargetPath); err != nil {
+				logger.V(LogLevelState).Info("can't restore staging of the volume", "volume_id", req.VolumeId, "error", err)
+			}
+		}
+	}
+
 	// Check if volume path is either stagingtarget or target path
 	_, exists := vol.TargetPaths[req.VolumePath]
 	if !exists && req.VolumePath != vol.StagingTargetPath {
 		return nil, status.Errorf(codes.NotFound, "Path '%s' is neither a staging target path nor target path for the volume '%s'", req.VolumePath, req.VolumeId)
 	}
 
-	resp := &csi.NodeGetVolumeStatsResponse{
-		Usage: []*csi.VolumeUsage{
-			{
-				Total: vol.RSDVolume.CapacityBytes,
-				Unit:  csi.VolumeUsage_BYTES,
-			},
-		},
+	usage, abnormal := drv.volumeStats(vol, req.VolumePath)
+	drv.observeVolumeCondition(logger, vol, abnormal)
+	condition := &csi.VolumeCondition{Abnormal: abnormal != "", Message: abnormal}
+	if abnormal == "" {
+		condition.Message = "volume is healthy"
 	}
+	resp := &csi.NodeGetVolumeStatsResponse{Usage: usage, VolumeCondition: condition}
 
-	log.Printf("NodeGetVolumeStats response: %v", resp)
+	logger.V(LogLevelRequest).Info("NodeGetVolumeStats response", "response", resp)
 	return resp, nil
 }
 
-// getFstype returns FS type considering its default value "ext4"
-func getFsType(fsType string) string {
-	if fsType != "" {
-		return fsType
+// SetMaxConcurrentStages limits the number of volumes staged on the node
+// at the same time, so pods with many volumes don't overload the fabric.
+// Zero means no limit.
+func (drv *Driver) SetMaxConcurrentStages(n int) {
+	drv.stageSlots = nil
+	if n > 0 {
+		drv.stageSlots = make(chan struct{}, n)
 	}
-	return "ext4"
+}
+
+// acquireStageSlot waits until the volume can be staged without
+// exceeding the limit of concurrent stages or the request is cancelled
+func (drv *Driver) acquireStageSlot(ctx context.Context) error {
+	if drv.stageSlots == nil {
+		return nil
+	}
+	select {
+	case drv.stageSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseStageSlot allows another volume to be staged
+func (drv *Driver) releaseStageSlot() {
+	if drv.stageSlots != nil {
+		<-drv.stageSlots
+	}
+}
+
+// storeStaged updates the volume record with the staged state of its copy
+// staged or unstaged without holding drv.volumesRWL
+func (drv *Driver) storeStaged(vol, staged *Volume) {
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	vol.Device = staged.Device
+	vol.FSLabel = staged.FSLabel
+	vol.FSUUID = staged.FSUUID
+	vol.DeviceByID = staged.DeviceByID
+	vol.IsBlock = staged.IsBlock
+	vol.IsStaged = staged.IsStaged
+	vol.StagingTargetPath = staged.StagingTargetPath
+	drv.recordJournal(vol)
 }
 
 // NodeStageVolume mounts the volume to a staging path on the node. This is
@@ -116,7 +199,8 @@ func getFsType(fsType string) string {
 // volume to a staging path. Once mounted, NodePublishVolume will make sure to
 // bindmount it to the appropriate path
 func (drv *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	log.Printf("NodeStageVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeStageVolume request", "request", redactRequest(req))
 
 	if req == nil || req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume: Volume ID can't be empty")
@@ -130,30 +214,89 @@ func (drv *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolume
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume: Volume capability is missing")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
-	drv.volumesRWL.Lock()
-	defer drv.volumesRWL.Unlock()
+	if err := validateNodeCapability(req.VolumeCapability); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+	if err := drv.validateFsType(req.VolumeCapability); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+	if _, err := parsePortalOverride(req.VolumeContext); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+	if mnt := req.VolumeCapability.GetMount(); mnt != nil {
+		if _, err := mkfsArgs(drv.fsType(mnt.FsType), req.VolumeContext[MkfsOptionsParameter]); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+		}
+	}
+
+	secrets, err := parseStageSecrets(req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+	ctx, err = drv.withRSDSecrets(ctx, req.Secrets)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+
+	drv.adoptMissingVolume(req.VolumeId)
+	drv.adoptEndpointVolume(ctx, req.VolumeId)
+
+	// lock the volume to satisfy idepotency requirements
+	if err := drv.volumeLocks.lock(req.VolumeId, "NodeStageVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeStageVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
 
 	// Check if the volume exists
+	drv.volumesRWL.RLock()
 	name, vol := drv.findVolByID(req.VolumeId)
 	if name == "" {
+		drv.volumesRWL.RUnlock()
 		return nil, status.Errorf(codes.NotFound, "NodeStageVolume: No volume with id '%s' found", req.VolumeId)
 	}
 
+	// Stage a copy of the volume record without holding the lock, as nvme
+	// connect and mkfs may take a while, and store the result afterwards
+	staged := *vol
+	drv.volumesRWL.RUnlock()
+	ctx, err = volumeRSDContext(ctx, &staged)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume: %v", err)
+	}
+
 	mnt := req.VolumeCapability.GetMount()
 
-	err := drv.nodeStageVolume(vol, getFsType(mnt.FsType), req.StagingTargetPath, mnt.MountFlags)
+	err = drv.acquireStageSlot(ctx)
+	if err == nil {
+		if req.VolumeCapability.GetBlock() != nil {
+			err = drv.nodeStageBlockVolume(ctx, &staged, req.VolumeContext, req.StagingTargetPath, secrets)
+		} else {
+			err = drv.nodeStageVolume(ctx, &staged, drv.fsType(mnt.GetFsType()), req.VolumeContext, req.StagingTargetPath, mnt.GetMountFlags(), secrets)
+		}
+		drv.releaseStageSlot()
+	}
+
+	if err == nil {
+		drv.setIdentityXattrs(&staged, req.StagingTargetPath)
+		drv.storeStaged(vol, &staged)
+	}
+
 	if err != nil {
-		return nil, status.Errorf(codes.Aborted, "NodeStageVolume: error staging volume %s(%s) on the path %s: %v", name, req.VolumeId, req.StagingTargetPath, err)
+		code := codes.Aborted
+		if _, mismatch := err.(*filesystemMismatchError); mismatch {
+			code = codes.FailedPrecondition
+		}
+		return nil, status.Errorf(code, "NodeStageVolume: error staging volume %s(%s) on the path %s: %v", name, req.VolumeId, req.StagingTargetPath, err)
 	}
 
-	log.Printf("NodeStageVolume: volume %s(%s) has been staged on the path %s", name, req.VolumeId, req.StagingTargetPath)
+	logger.V(LogLevelState).Info("volume has been staged", "volume", name, "volume_id", req.VolumeId, "staging_target_path", req.StagingTargetPath)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
 // NodeUnstageVolume unstages the volume from the staging path
 func (drv *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	log.Printf("NodeUnstageVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeUnstageVolume request", "request", req)
 
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodeUnstageVolume: Volume ID is missing")
@@ -163,28 +306,51 @@ func (drv *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVo
 		return nil, status.Error(codes.InvalidArgument, "NodeUnstageVolume: Staging Target Path is missing")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
-	drv.volumesRWL.Lock()
-	defer drv.volumesRWL.Unlock()
+	// lock the volume to satisfy idepotency requirements
+	if err := drv.volumeLocks.lock(req.VolumeId, "NodeUnstageVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeUnstageVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
 
 	// Check if the volume exists
+	drv.volumesRWL.Lock()
 	name, vol := drv.findVolByID(req.VolumeId)
 	if name == "" {
-		return nil, status.Errorf(codes.NotFound, "NodeUnstageVolume: No volume with id '%s' found", req.VolumeId)
+		drv.volumesRWL.Unlock()
+		notFound := status.Errorf(codes.NotFound, "NodeUnstageVolume: No volume with id '%s' found", req.VolumeId)
+		if err := drv.csiCompat().releaseUnknownVolume(logger, notFound); err != nil {
+			return nil, err
+		}
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	// staged state is lost if the driver restarted after staging, the
+	// device is disconnected only if the journal recorded it
+	if !vol.IsStaged {
+		if err := drv.restoreJournaled(vol, req.StagingTargetPath); err != nil {
+			logger.V(LogLevelState).Info("can't restore staging of the volume", "volume_id", req.VolumeId, "error", err)
+		}
 	}
 
-	err := drv.nodeUnstageVolume(vol, req.StagingTargetPath)
+	// Unstage a copy of the volume record without holding the lock, as
+	// nvme disconnect may take a while, and store the result afterwards
+	unstaged := *vol
+	drv.volumesRWL.Unlock()
+
+	err := drv.nodeUnstageVolume(&unstaged, req.StagingTargetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "NodeUnstageVolume: error unstaging volume %s(%s) from the path %s: %v", name, req.VolumeId, req.StagingTargetPath, err)
 	}
+	drv.storeStaged(vol, &unstaged)
 
-	log.Printf("NodeUnstageVolume: volume %s(%s) has been unstaged from the path %s", name, req.VolumeId, req.StagingTargetPath)
+	logger.V(LogLevelState).Info("volume has been unstaged", "volume", name, "volume_id", req.VolumeId, "staging_target_path", req.StagingTargetPath)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 // NodePublishVolume mounts the volume mounted to the staging path to the target path
 func (drv *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	log.Printf("NodePublishVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodePublishVolume request", "request", redactRequest(req))
 
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume ID is missing")
@@ -202,15 +368,34 @@ func (drv *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume: Volume Capability is missing")
 	}
 
+	if err := validateNodeCapability(req.VolumeCapability); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: %v", err)
+	}
+
 	mnt := req.VolumeCapability.GetMount()
-	options := mnt.MountFlags
+	options := mnt.GetMountFlags()
+	block := req.VolumeCapability.GetBlock() != nil
 
-	options = append(options, "bind")
-	if req.Readonly {
+	if !block {
+		options = append(options, "bind")
+	}
+	if req.Readonly || isReadOnlyMode(req.VolumeCapability) {
 		options = append(options, "ro")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
+	// lock the volume and driver volumes to satisfy idepotency requirements,
+	// mounting the staged volume to the target path is quick
+	if err := drv.volumeLocks.lock(req.VolumeId, "NodePublishVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodePublishVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
+
+	// staging left stale by a fabric reconnect is repaired without holding
+	// the driver lock, as connecting the volume again may take a while
+	if err := drv.repairStaging(ctx, req); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodePublishVolume: %v", err)
+	}
+
 	drv.volumesRWL.Lock()
 	defer drv.volumesRWL.Unlock()
 
@@ -220,18 +405,39 @@ func (drv *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVo
 		return nil, status.Errorf(codes.NotFound, "NodePublishVolume: No volume with id '%s' found", req.VolumeId)
 	}
 
-	err := drv.nodePublishVolume(vol, getFsType(mnt.FsType), req.StagingTargetPath, req.TargetPath, options)
+	if !vol.IsStaged || vol.StagingTargetPath != req.StagingTargetPath {
+		// staged state is lost if the driver restarted after staging
+		recoverErr := drv.restoreJournaled(vol, req.StagingTargetPath)
+		if recoverErr != nil {
+			recoverErr = drv.recoverStaged(vol, req.StagingTargetPath, req.PublishContext, block)
+		}
+		if recoverErr != nil {
+			logger.Error(recoverErr, "can't recover staging of the volume", "volume_id", req.VolumeId)
+			if err := drv.csiCompat().checkStaged(vol, req.StagingTargetPath); err != nil {
+				return nil, status.Errorf(codes.FailedPrecondition, "NodePublishVolume: %v: %v, the volume must be staged again", err, recoverErr)
+			}
+		}
+	}
+
+	var err error
+	if block {
+		err = drv.nodePublishBlockVolume(vol, req.TargetPath, options)
+	} else {
+		err = drv.nodePublishVolume(vol, drv.fsType(mnt.GetFsType()), req.StagingTargetPath, req.TargetPath, options)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "NodePublishVolume: error publishing volume id %s on %s: %v", req.VolumeId, req.StagingTargetPath, err)
 	}
+	drv.recordJournal(vol)
 
-	log.Printf("NodePublishVolume: volume id %s has been published on the path %s", req.VolumeId, req.StagingTargetPath)
+	logger.V(LogLevelState).Info("volume has been published", "volume_id", req.VolumeId, "target_path", req.TargetPath)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 // NodeUnpublishVolume unmounts the volume from the target path
 func (drv *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	log.Printf("NodeUnpublishVolume request: %v", req)
+	logger := drv.requestLogger(ctx)
+	logger.V(LogLevelRequest).Info("NodeUnpublishVolume request", "request", req)
 
 	if req.VolumeId == "" {
 		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume: Volume ID is missing")
@@ -241,21 +447,30 @@ func (drv *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpubli
 		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume: Target Path is missing")
 	}
 
-	// lock driver volumes to satisfy idepotency requirements
+	// lock the volume and driver volumes to satisfy idepotency requirements
+	if err := drv.volumeLocks.lock(req.VolumeId, "NodeUnpublishVolume"); err != nil {
+		return nil, status.Errorf(codes.Aborted, "NodeUnpublishVolume: %v", err)
+	}
+	defer drv.volumeLocks.unlock(req.VolumeId)
 	drv.volumesRWL.Lock()
 	defer drv.volumesRWL.Unlock()
 
 	// Check if the volume exists
 	name, vol := drv.findVolByID(req.VolumeId)
 	if name == "" {
-		return nil, status.Errorf(codes.NotFound, "NodeUpublishVolume: No volume with id '%s' found", req.VolumeId)
+		notFound := status.Errorf(codes.NotFound, "NodeUpublishVolume: No volume with id '%s' found", req.VolumeId)
+		if err := drv.csiCompat().releaseUnknownVolume(logger, notFound); err != nil {
+			return nil, err
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
 	}
 
 	err := drv.nodeUnpublishVolume(vol, req.TargetPath)
 	if err != nil {
 		return nil, status.Errorf(codes.Aborted, "NodeUnpublishVolume: error unpublishing volume id %s from the path %s: %v", req.VolumeId, req.TargetPath, err)
 	}
+	drv.recordJournal(vol)
 
-	log.Printf("NodeUnpublishVolume: volume id %s has been unpublished from the target path %s", req.VolumeId, req.TargetPath)
+	logger.V(LogLevelState).Info("volume has been unpublished", "volume_id", req.VolumeId, "target_path", req.TargetPath)
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
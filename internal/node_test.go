@@ -17,10 +17,15 @@ package csirsd
 import (
 	"context"
 	"errors"
-	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestNodeGetInfo(t *testing.T) {
@@ -34,7 +39,7 @@ func TestNodeGetInfo(t *testing.T) {
 		}
 		want := &csi.NodeGetInfoResponse{NodeId: nodeID}
 
-		if !reflect.DeepEqual(got, want) {
+		if !proto.Equal(got, want) {
 			t.Errorf("Driver.NodeGetInfo() = %v, want %v", got, want)
 		}
 	})
@@ -58,6 +63,13 @@ func TestNodeGetCapabilities(t *testing.T) {
 						},
 					},
 				},
+				&csi.NodeServiceCapability{
+					Type: &csi.NodeServiceCapability_Rpc{
+						Rpc: &csi.NodeServiceCapability_RPC{
+							Type: csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+						},
+					},
+				},
 				&csi.NodeServiceCapability{
 					Type: &csi.NodeServiceCapability_Rpc{
 						Rpc: &csi.NodeServiceCapability_RPC{
@@ -65,19 +77,60 @@ func TestNodeGetCapabilities(t *testing.T) {
 						},
 					},
 				},
+				&csi.NodeServiceCapability{
+					Type: &csi.NodeServiceCapability_Rpc{
+						Rpc: &csi.NodeServiceCapability_RPC{
+							Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+						},
+					},
+				},
 			},
 		}
 
-		if !reflect.DeepEqual(got, want) {
+		if !proto.Equal(got, want) {
 			t.Errorf("Driver.NodeGetCapabilities() = %v, want %v", got, want)
 		}
 	})
 }
 
+func TestStrictCapabilities(t *testing.T) {
+	hasVolumeStats := func(drv *Driver) bool {
+		resp, err := drv.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("NodeGetCapabilities() unexpected error = %v", err)
+		}
+		for _, cap := range resp.Capabilities {
+			if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_GET_VOLUME_STATS {
+				return true
+			}
+		}
+		return false
+	}
+
+	drv := &Driver{volumes: map[string]*Volume{}}
+	drv.SetStrictCapabilities(true)
+	if hasVolumeStats(drv) {
+		t.Errorf("GET_VOLUME_STATS advertised without the node journal")
+	}
+	_, err := drv.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "1", VolumePath: "/target"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("NodeGetVolumeStats() without the node journal error = %v, want Unimplemented", err)
+	}
+
+	drv.journal = &nodeJournal{}
+	if !hasVolumeStats(drv) {
+		t.Errorf("GET_VOLUME_STATS not advertised with the node journal")
+	}
+	_, err = drv.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "1", VolumePath: "/target"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("NodeGetVolumeStats() of unknown volume error = %v, want NotFound", err)
+	}
+}
+
 // testNVME is a mock nvme structure used to avoid calling nvme tool
 type testNVMe struct{}
 
-func (*testNVMe) Connect(transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string) (string, error) {
+func (*testNVMe) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error) {
 	return "/dev/nvme1n1", nil
 }
 
@@ -88,6 +141,14 @@ func (*testNVMe) Disconnect(device string) error {
 	return nil
 }
 
+func (*testNVMe) SmartLog(device string) (*SmartLog, error) {
+	return &SmartLog{CriticalWarning: 0, Temperature: 300, MediaErrors: 2, NumErrLogEntries: 5}, nil
+}
+
+func (*testNVMe) List() (map[string]string, error) {
+	return map[string]string{"/dev/nvme1n1": "nqn.2014-08.org.nvmexpress:uuid:1"}, nil
+}
+
 // testMounter is a mounter test mock
 type testMounter struct{}
 
@@ -95,10 +156,18 @@ func (*testMounter) Mount(source string, target string, fstype string, opts ...s
 	return nil
 }
 
+func (*testMounter) MountBlock(source, target string, opts ...string) error {
+	return nil
+}
+
 func (*testMounter) Unmount(target string) error {
 	return nil
 }
 
+func (*testMounter) RemoveTarget(target string) error {
+	return nil
+}
+
 func (*testMounter) IsMounted(source, target string) (bool, error) {
 	return false, nil
 }
@@ -107,7 +176,23 @@ func (*testMounter) IsFormatted(source string) (bool, error) {
 	return false, nil
 }
 
-func (*testMounter) Format(source, fsType string) error {
+func (*testMounter) GetFilesystemType(source string) (string, error) {
+	return "", nil
+}
+
+func (*testMounter) IsFilesystemEmpty(source, fsType, target string) (bool, error) {
+	return true, nil
+}
+
+func (*testMounter) Format(source, fsType, label string, opts ...string) error {
+	return nil
+}
+
+func (*testMounter) GetFilesystemIDs(source string) (string, string, error) {
+	return "rsd-1", "0b7dbe5f-7d4a-4b43-8e3e-4c1e1a6b0e41", nil
+}
+
+func (*testMounter) ResizeFilesystem(source, target string) error {
 	return nil
 }
 
@@ -125,13 +210,14 @@ func TestNodeStageVolume(t *testing.T) {
 				volumes: map[string]*Volume{
 					"Vol1": &Volume{
 						CSIVolume: &csi.Volume{VolumeId: "1"},
+						RSDVolume: &rsd.Volume{},
 						Name:      "1",
-						EndPoint: &endPointInfo{
-							transportProtocol: "rdma",
-							ipAddress:         "192.168.1.1",
-							ipPort:            4420,
-							ipAddressFamily:   "IPv4",
-							nqn:               "nqn.2000-11.org.nvmexpress:uuid:xxxxx-yyyy-zzzz-0000-ffffffff",
+						EndPoint: &endpoint.Portal{
+							Transport:     "rdma",
+							Address:       "192.168.1.1",
+							Port:          4420,
+							AddressFamily: "IPv4",
+							NQN:           "nqn.2000-11.org.nvmexpress:uuid:xxxxx-yyyy-zzzz-0000-ffffffff",
 						},
 						IsPublished: true,
 						IsStaged:    false,
@@ -217,13 +303,107 @@ func TestNodeStageVolume(t *testing.T) {
 				t.Errorf("Driver.NodeStageVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.NodeStageVolume() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+// blockingMounter is a testMounter blocking formatting until release is closed
+type blockingMounter struct {
+	testMounter
+	mu         sync.Mutex
+	formatting int
+	maxSeen    int
+	started    chan struct{}
+	release    chan struct{}
+}
+
+func (m *blockingMounter) Format(source, fsType, label string, opts ...string) error {
+	m.mu.Lock()
+	m.formatting++
+	if m.formatting > m.maxSeen {
+		m.maxSeen = m.formatting
+	}
+	m.mu.Unlock()
+
+	m.started <- struct{}{}
+	<-m.release
+
+	m.mu.Lock()
+	m.formatting--
+	m.mu.Unlock()
+	return nil
+}
+
+func TestNodeStageVolumeConcurrency(t *testing.T) {
+	mounter := &blockingMounter{started: make(chan struct{}, 3), release: make(chan struct{})}
+	drv := &Driver{
+		volumes: map[string]*Volume{},
+		nvme:    &testNVMe{},
+		mounter: mounter,
+	}
+	drv.SetMaxConcurrentStages(2)
+	for _, id := range []string{"1", "2", "3"} {
+		drv.volumes["pvc-"+id] = &Volume{
+			Name:        "pvc-" + id,
+			CSIVolume:   &csi.Volume{VolumeId: id},
+			RSDVolume:   &rsd.Volume{},
+			EndPoint:    &endpoint.Portal{Transport: "rdma", Address: "192.168.1.1", Port: 4420, AddressFamily: "IPv4"},
+			IsPublished: true,
+		}
+	}
+
+	stage := func(id string) error {
+		_, err := drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          id,
+			StagingTargetPath: "/mnt/" + id,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			},
+		})
+		return err
+	}
+
+	errs := make(chan error, 3)
+	for _, id := range []string{"1", "2", "3"} {
+		go func(id string) { errs <- stage(id) }(id)
+	}
+
+	// two volumes are formatted at the same time without holding the volumes lock
+	<-mounter.started
+	<-mounter.started
+
+	// the volume being staged can't be staged again meanwhile
+	var pending string
+	for _, id := range []string{"1", "2", "3"} {
+		if drv.volumeLocks.isLocked(id) {
+			pending = id
+			break
+		}
+	}
+	if err := stage(pending); status.Code(err) != codes.Aborted {
+		t.Errorf("staging volume %s twice: error = %v, want Aborted", pending, err)
+	}
+
+	close(mounter.release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("NodeStageVolume() unexpected error: %v", err)
+		}
+	}
+
+	if mounter.maxSeen != 2 {
+		t.Errorf("%d volumes were staged at the same time, want 2", mounter.maxSeen)
+	}
+	for name, vol := range drv.volumes {
+		if !vol.IsStaged || drv.volumeLocks.isLocked(vol.CSIVolume.VolumeId) || vol.Device == "" {
+			t.Errorf("volume %s is not staged: %+v", name, vol)
+		}
+	}
+}
+
 func TestNodeUnstageVolume(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -310,7 +490,7 @@ func TestNodeUnstageVolume(t *testing.T) {
 				t.Errorf("Driver.NodeUnstageVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.NodeUnstageVolume() = %v, want %v", got, tt.want)
 			}
 		})
@@ -332,12 +512,12 @@ func TestNodePublishVolume(t *testing.T) {
 					"Vol1": &Volume{
 						CSIVolume: &csi.Volume{VolumeId: "1"},
 						Name:      "1",
-						EndPoint: &endPointInfo{
-							transportProtocol: "rdma",
-							ipAddress:         "192.168.1.1",
-							ipPort:            4420,
-							ipAddressFamily:   "IPv4",
-							nqn:               "nqn.2000-11.org.nvmexpress:uuid:xxxxx-yyyy-zzzz-0000-ffffffff",
+						EndPoint: &endpoint.Portal{
+							Transport:     "rdma",
+							Address:       "192.168.1.1",
+							Port:          4420,
+							AddressFamily: "IPv4",
+							NQN:           "nqn.2000-11.org.nvmexpress:uuid:xxxxx-yyyy-zzzz-0000-ffffffff",
 						},
 						IsPublished: false,
 						IsStaged:    true,
@@ -423,7 +603,7 @@ func TestNodePublishVolume(t *testing.T) {
 				t.Errorf("Driver.NodePublishVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.NodePublishVolume() = %v, want %v", got, tt.want)
 			}
 		})
@@ -491,7 +671,7 @@ func TestNodeUnpublishVolume(t *testing.T) {
 				t.Errorf("Driver.NodeUnpublishVolume() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !proto.Equal(got, tt.want) {
 				t.Errorf("Driver.NodeUnpublishVolume() = %v, want %v", got, tt.want)
 			}
 		})
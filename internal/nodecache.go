@@ -0,0 +1,174 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// nodeCacheNegativeTTL limits the time nodes missing in RSD are cached,
+// so newly composed nodes are found soon
+const nodeCacheNegativeTTL = 30 * time.Second
+
+type nodeCacheEntry struct {
+	node *rsd.Node
+	// nqn is NQN of the node computer system, empty until it's queried
+	nqn string
+	// err is set for nodes missing in RSD
+	err     error
+	expires time.Time
+}
+
+// nodeCache keeps RSD nodes and their NQNs, so publishing volumes doesn't
+// query them every time. Nodes missing in RSD are cached as well.
+// All methods are no-op for nil nodeCache, i.e. caching is disabled.
+type nodeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*nodeCacheEntry
+	now     func() time.Time
+}
+
+func newNodeCache(ttl time.Duration) *nodeCache {
+	return &nodeCache{
+		ttl:     ttl,
+		entries: map[string]*nodeCacheEntry{},
+		now:     time.Now,
+	}
+}
+
+// get returns a copy of the cached node entry.
+// It returns nil if the node is not cached or the entry expired.
+func (cache *nodeCache) get(nodeID string) *nodeCacheEntry {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, exists := cache.entries[nodeID]
+	if !exists || !cache.now().Before(entry.expires) {
+		return nil
+	}
+	result := *entry
+	return &result
+}
+
+// add caches the node, or error if the node is missing
+func (cache *nodeCache) add(nodeID string, node *rsd.Node, err error) {
+	if cache == nil {
+		return
+	}
+	ttl := cache.ttl
+	if err != nil && ttl > nodeCacheNegativeTTL {
+		ttl = nodeCacheNegativeTTL
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[nodeID] = &nodeCacheEntry{node: node, err: err, expires: cache.now().Add(ttl)}
+}
+
+// setNQN stores NQN of the cached node
+func (cache *nodeCache) setNQN(nodeID, nqn string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if entry, exists := cache.entries[nodeID]; exists {
+		entry.nqn = nqn
+	}
+}
+
+// invalidate removes the node from the cache
+func (cache *nodeCache) invalidate(nodeID string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.entries, nodeID)
+}
+
+// SetNodeCacheTTL enables caching of RSD nodes used to publish volumes.
+// Zero ttl disables caching.
+func (drv *Driver) SetNodeCacheTTL(ttl time.Duration) {
+	drv.nodes = nil
+	if ttl > 0 {
+		drv.nodes = newNodeCache(ttl)
+		drv.nodes.now = drv.now
+	}
+}
+
+// nodeCacheOf returns the cache of the nodes of the RSD endpoint of the
+// request, nodes of the endpoints from the secrets are not cached. Nodes of
+// the racks are cached by their IDs prefixed with the rack ID.
+func (drv *Driver) nodeCacheOf(ctx context.Context) *nodeCache {
+	if rsdEndpointOf(ctx) != "" {
+		return nil
+	}
+	return drv.nodes
+}
+
+// getNode returns RSD node from the cache or queries it
+func (drv *Driver) getNode(ctx context.Context, nodeID string) (*rsd.Node, error) {
+	nodes := drv.nodeCacheOf(ctx)
+	if entry := nodes.get(nodeID); entry != nil {
+		return entry.node, entry.err
+	}
+
+	ctx, rsdNodeID := drv.withNodeRack(ctx, nodeID)
+	node, err := rsd.GetNode(ctx, drv.client(ctx), rsdNodeID)
+	if err == nil || rsd.Classify(err) == rsd.CategoryNotFound {
+		nodes.add(nodeID, node, err)
+	}
+	return node, err
+}
+
+// getNodeNQN returns NQN of the node computer system from the cache or queries it
+func (drv *Driver) getNodeNQN(ctx context.Context, nodeID string, node *rsd.Node) (string, error) {
+	nodes := drv.nodeCacheOf(ctx)
+	if entry := nodes.get(nodeID); entry != nil && entry.nqn != "" {
+		return entry.nqn, nil
+	}
+
+	// Get Computer System associated with the node
+	ctx, _ = drv.withNodeRack(ctx, nodeID)
+	var computerSystem rsd.ComputerSystem
+	err := rsd.GetByOdataID(ctx, drv.client(ctx), node.Links.ComputerSystem.OdataID, &computerSystem)
+	if err != nil {
+		return "", err
+	}
+
+	// Get NQN of this Computer System
+	nqn, err := drv.getComputerSystemNQN(ctx, &computerSystem)
+	if err != nil {
+		return "", err
+	}
+
+	nodes.setNQN(nodeID, nqn)
+	return nqn, nil
+}
+
+// invalidateNode removes the node from the cache if the error tells
+// it's not found, e.g. the node was recomposed
+func (drv *Driver) invalidateNode(nodeID string, err error) {
+	if rsd.Classify(err) == rsd.CategoryNotFound {
+		drv.nodes.invalidate(nodeID)
+	}
+}
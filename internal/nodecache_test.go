@@ -0,0 +1,87 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// countingClient is a TestClient counting GET requests
+type countingClient struct {
+	TestClient
+	gets map[string]int
+}
+
+func (client *countingClient) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	client.gets[entrypoint]++
+	return client.TestClient.Get(ctx, entrypoint, result)
+}
+
+func TestNodeCache(t *testing.T) {
+	client := &countingClient{
+		TestClient: TestClient{
+			results: map[string]string{
+				"/redfish/v1/Nodes":   `{"Members": [{"@odata.id": "/redfish/v1/Nodes/1"}]}`,
+				"/redfish/v1/Nodes/1": `{"@odata.id": "/redfish/v1/Nodes/1", "Id": "1"}`,
+			},
+		},
+		gets: map[string]int{},
+	}
+	drv := &Driver{rsdClient: client}
+	drv.SetNodeCacheTTL(time.Minute)
+	now := time.Now()
+	drv.nodes.now = func() time.Time { return now }
+
+	// getNode checks the number of the node collection queries
+	getNode := func(nodeID string, wantErr bool, wantGets int) {
+		t.Helper()
+		_, err := drv.getNode(context.Background(), nodeID)
+		if (err != nil) != wantErr {
+			t.Fatalf("getNode(%s) error = %v, wantErr %v", nodeID, err, wantErr)
+		}
+		if gets := client.gets["/redfish/v1/Nodes"]; gets != wantGets {
+			t.Fatalf("getNode(%s) queried RSD nodes %d times, want %d", nodeID, gets, wantGets)
+		}
+	}
+
+	getNode("1", false, 1)
+	getNode("1", false, 1)
+	getNode("2", true, 2)
+	getNode("2", true, 2)
+
+	// missing nodes are cached for shorter time
+	now = now.Add(nodeCacheNegativeTTL)
+	getNode("1", false, 2)
+	getNode("2", true, 3)
+
+	now = now.Add(time.Minute)
+	getNode("1", false, 4)
+
+	// unrelated errors don't invalidate the node
+	drv.invalidateNode("1", &rsd.HTTPError{StatusCode: http.StatusConflict})
+	getNode("1", false, 4)
+	drv.invalidateNode("1", &rsd.HTTPError{StatusCode: http.StatusNotFound})
+	getNode("1", false, 5)
+
+	// caching is disabled
+	drv.SetNodeCacheTTL(0)
+	getNode("1", false, 6)
+	getNode("1", false, 7)
+}
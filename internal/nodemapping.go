@@ -0,0 +1,136 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeNameSource returns RSD node IDs of the Kubernetes nodes by the node
+// names, e.g. from the csi.intel.com/rsd-node labels of the nodes
+type NodeNameSource interface {
+	RSDNodeIDs() (map[string]string, error)
+}
+
+// nodeNameMapping caches Kubernetes node names of the RSD nodes, so volumes
+// are published to nodes identified by either of them. RSD node IDs
+// labeled on several Kubernetes nodes are conflicts, the names of such
+// nodes are not resolved until the labels are fixed.
+type nodeNameMapping struct {
+	mu     sync.Mutex
+	source NodeNameSource
+	ttl    time.Duration
+	// nodes maps the Kubernetes node names to RSD node IDs
+	nodes map[string]string
+	// conflicts maps the RSD node IDs to the Kubernetes node names sharing them
+	conflicts map[string][]string
+	refreshed time.Time
+	now       func() time.Time
+}
+
+// SetNodeNameMapping enables resolving of Kubernetes node names in
+// ControllerPublishVolume and ControllerUnpublishVolume to RSD node IDs.
+// The mapping is refreshed from the source when it's older than ttl.
+func (drv *Driver) SetNodeNameMapping(source NodeNameSource, ttl time.Duration) {
+	drv.nodeNames = nil
+	if source != nil {
+		drv.nodeNames = &nodeNameMapping{source: source, ttl: ttl, now: drv.now}
+	}
+}
+
+// refresh replaces the mapping with the current one of the source and
+// logs the nodes with the changed RSD node IDs
+func (mapping *nodeNameMapping) refresh(logger Logger) error {
+	nodes, err := mapping.source.RSDNodeIDs()
+	if err != nil {
+		return err
+	}
+
+	conflicts := map[string][]string{}
+	names := map[string][]string{}
+	for name, nodeID := range nodes {
+		names[nodeID] = append(names[nodeID], name)
+	}
+	for nodeID, sharing := range names {
+		if len(sharing) > 1 {
+			sort.Strings(sharing)
+			conflicts[nodeID] = sharing
+			if !equalStrings(mapping.conflicts[nodeID], sharing) {
+				logger.Warning("RSD node ID is labeled on several Kubernetes nodes, their names are not resolved", "node_id", nodeID, "nodes", strings.Join(sharing, ","))
+			}
+		}
+	}
+	for name, nodeID := range nodes {
+		if previous, known := mapping.nodes[name]; known && previous != nodeID {
+			logger.Warning("RSD node ID of the Kubernetes node changed", "node", name, "previous_node_id", previous, "node_id", nodeID)
+		}
+	}
+
+	mapping.nodes = nodes
+	mapping.conflicts = conflicts
+	mapping.refreshed = mapping.now()
+	return nil
+}
+
+// resolve returns RSD node ID of the Kubernetes node name, the node ID itself
+// if it's not a known node name
+func (mapping *nodeNameMapping) resolve(nodeID string, logger Logger) (string, error) {
+	mapping.mu.Lock()
+	defer mapping.mu.Unlock()
+
+	if mapping.nodes == nil || mapping.now().Sub(mapping.refreshed) >= mapping.ttl {
+		if err := mapping.refresh(logger); err != nil {
+			if mapping.nodes == nil {
+				return "", fmt.Errorf("can't map Kubernetes node names to RSD node IDs: %v", err)
+			}
+			logger.Warning("can't refresh Kubernetes node names of RSD nodes, using the cached ones", "error", err)
+		}
+	}
+
+	rsdNodeID, known := mapping.nodes[nodeID]
+	if !known {
+		return nodeID, nil
+	}
+	if sharing, conflict := mapping.conflicts[rsdNodeID]; conflict {
+		return "", fmt.Errorf("RSD node ID %s of the node %s is labeled also on the nodes %s", rsdNodeID, nodeID, strings.Join(sharing, ", "))
+	}
+	return rsdNodeID, nil
+}
+
+// resolveNodeID returns RSD node ID of the node ID of the CSI request,
+// which is either RSD node ID or Kubernetes node name
+func (drv *Driver) resolveNodeID(nodeID string) (string, error) {
+	if drv.nodeNames == nil {
+		return nodeID, nil
+	}
+	return drv.nodeNames.resolve(nodeID, drv.logger)
+}
+
+// equalStrings checks if the lists have the same items in the same order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testNodeNameSource returns the nodes or err and counts the calls
+type testNodeNameSource struct {
+	nodes map[string]string
+	err   error
+	calls int
+}
+
+func (source *testNodeNameSource) RSDNodeIDs() (map[string]string, error) {
+	source.calls++
+	if source.err != nil {
+		return nil, source.err
+	}
+	nodes := map[string]string{}
+	for name, nodeID := range source.nodes {
+		nodes[name] = nodeID
+	}
+	return nodes, nil
+}
+
+func TestResolveNodeID(t *testing.T) {
+	source := &testNodeNameSource{nodes: map[string]string{"worker-1": "1", "worker-2": "2", "worker-3": "2"}}
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	drv := &Driver{}
+	drv.SetNodeNameMapping(source, time.Minute)
+	drv.nodeNames.now = func() time.Time { return now }
+
+	tests := []struct {
+		nodeID  string
+		want    string
+		wantErr bool
+	}{
+		{nodeID: "worker-1", want: "1"},
+		{nodeID: "1", want: "1"},
+		{nodeID: "5", want: "5"},
+		{nodeID: "worker-2", wantErr: true},
+		{nodeID: "worker-3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := drv.resolveNodeID(tt.nodeID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveNodeID(%s) error = %v, wantErr %v", tt.nodeID, err, tt.wantErr)
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("resolveNodeID(%s) = %s, want %s", tt.nodeID, got, tt.want)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("source called %d times within ttl, want once", source.calls)
+	}
+
+	// relabeled nodes are resolved after ttl
+	source.nodes = map[string]string{"worker-1": "3", "worker-2": "2"}
+	if got, _ := drv.resolveNodeID("worker-1"); got != "1" {
+		t.Errorf("resolveNodeID(worker-1) = %s within ttl, want cached 1", got)
+	}
+	now = now.Add(time.Minute)
+	if got, err := drv.resolveNodeID("worker-1"); err != nil || got != "3" {
+		t.Errorf("resolveNodeID(worker-1) = %s, %v after ttl, want 3", got, err)
+	}
+	if got, err := drv.resolveNodeID("worker-2"); err != nil || got != "2" {
+		t.Errorf("resolveNodeID(worker-2) = %s, %v after the conflict is fixed, want 2", got, err)
+	}
+
+	// cached mapping is used when the source fails
+	source.err = errors.New("API server unavailable")
+	now = now.Add(time.Minute)
+	if got, err := drv.resolveNodeID("worker-1"); err != nil || got != "3" {
+		t.Errorf("resolveNodeID(worker-1) = %s, %v with failing source, want cached 3", got, err)
+	}
+}
+
+func TestResolveNodeIDWithoutMapping(t *testing.T) {
+	drv := &Driver{}
+	if got, err := drv.resolveNodeID("worker-1"); err != nil || got != "worker-1" {
+		t.Errorf("resolveNodeID() = %s, %v, want node ID unchanged", got, err)
+	}
+
+	drv.SetNodeNameMapping(&testNodeNameSource{err: errors.New("forbidden")}, time.Minute)
+	if _, err := drv.resolveNodeID("worker-1"); err == nil {
+		t.Error("resolveNodeID() succeeded without any mapping read from the source")
+	}
+}
+
+func TestControllerUnpublishVolumeNodeNameConflict(t *testing.T) {
+	drv := &Driver{volumes: map[string]*Volume{}}
+	drv.SetNodeNameMapping(&testNodeNameSource{nodes: map[string]string{"worker-1": "1", "worker-2": "1"}}, time.Minute)
+
+	_, err := drv.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "1", NodeId: "worker-1"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ControllerUnpublishVolume() error = %v, want FailedPrecondition", err)
+	}
+}
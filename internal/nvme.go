@@ -17,13 +17,14 @@ package csirsd
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"time"
-)
 
-const (
-	devMaxDelay = 10
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
 )
 
 // DeviceList declares list of NVME device paths
@@ -38,74 +39,179 @@ type ControllerInfo struct {
 	Subnqn string `json:"subnqn"`
 }
 
+// SmartLog declares SMART log attributes exported as volume metrics
+type SmartLog struct {
+	CriticalWarning  int64   `json:"critical_warning"`
+	Temperature      int64   `json:"temperature"`
+	MediaErrors      float64 `json:"media_errors"`
+	NumErrLogEntries float64 `json:"num_err_log_entries"`
+}
+
 // NVMe interface declares NVMe operations required by the RSD CSI driver
 type NVMe interface {
 	// Connect to NVMe subsystem
-	Connect(transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string) (string, error)
+	Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error)
 	// Disconnect from NVMe subystem
 	Disconnect(device string) error
+	// SmartLog reads SMART log of the NVMe device
+	SmartLog(device string) (*SmartLog, error)
+	// List returns connected NVMe devices mapped to their subsystem NQNs
+	List() (map[string]string, error)
+}
+
+// FabricAuth is NVMe in-band authentication (DH-HMAC-CHAP) of the connection,
+// no authentication is used if the keys are empty
+type FabricAuth struct {
+	// HostKey is the host key, e.g. DHHC-1:00:...
+	HostKey string
+	// CtrlKey is the controller key for bidirectional authentication
+	CtrlKey string
+}
+
+type nvme struct {
+	exec       Execer
+	deviceWait policy.Retry
+	// sysBlock is the sysfs directory of the block devices
+	sysBlock string
+	// listen starts listening to the events of added NVMe disks
+	listen func() (deviceEvents, error)
 }
 
-type nvme struct{}
+func newNVMe(e Execer, policies policy.Policies) *nvme {
+	return &nvme{
+		exec:       withCommandTimeout(e, policies.CommandTimeout),
+		deviceWait: policies.DeviceWait,
+		sysBlock:   "/sys/block",
+		listen:     listenUevents,
+	}
+}
 
-func nvmeCommand(options []string) ([]byte, error) {
-	out, err := exec.Command("nvme", options...).CombinedOutput()
+func (n *nvme) command(options []string) ([]byte, error) {
+	out, err := n.exec.CombinedOutput("nvme", options...)
 	if err != nil {
 		return nil, fmt.Errorf("command failed: %v, command: 'nvme %s', output: %q",
-			err, strings.Join(options, " "), string(out))
+			err, rsd.Redact(strings.Join(options, " ")), rsd.Redact(string(out)))
 	}
 	return out, nil
 }
 
-// findNVMeDevice uses 'nvme list' and 'id-ctrl' to find device by NQN
-func findNVMeDevice(nqn string) (string, error) {
-	// wait for device node to appear
-	for delay := 1; delay < devMaxDelay; delay++ {
+// listDevices uses 'nvme list' and 'id-ctrl' to get NVMe devices with their subsystem NQNs
+func (n *nvme) listDevices() (map[string]string, error) {
+	out, err := n.command([]string{"list", "-o", "json"})
+	if err != nil {
+		return nil, err
+	}
+
+	var deviceList DeviceList
+	err = json.Unmarshal(out, &deviceList)
+	if err != nil {
+		return nil, fmt.Errorf("Can't unmarshal 'nvme list -o json' output: %v", err)
+	}
 
-		out, err := nvmeCommand([]string{"list", "-o", "json"})
+	devices := map[string]string{}
+	for _, device := range deviceList.Devices {
+		out, err = n.command([]string{"id-ctrl", device.DevicePath, "-o", "json"})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		var deviceList DeviceList
-		err = json.Unmarshal(out, &deviceList)
+		var controllerInfo ControllerInfo
+		err = json.Unmarshal(out, &controllerInfo)
 		if err != nil {
-			return "", fmt.Errorf("Can't unmarshal 'nvme list -o json' output: %v", err)
+			return nil, fmt.Errorf("Can't decode 'nvme id-ctrl %s -o json' output: %v", device.DevicePath, err)
 		}
 
-		for _, device := range deviceList.Devices {
-			out, err = nvmeCommand([]string{"id-ctrl", device.DevicePath, "-o", "json"})
-			if err != nil {
-				return "", err
-			}
+		devices[device.DevicePath] = strings.TrimSpace(controllerInfo.Subnqn)
+	}
+	return devices, nil
+}
 
-			var controllerInfo ControllerInfo
-			err = json.Unmarshal(out, &controllerInfo)
-			if err != nil {
-				return "", fmt.Errorf("Can't decode 'nvme id-ctrl %s -o json' output: %v", device.DevicePath, err)
-			}
+// sysfsDevices returns NVMe namespace devices mapped to their subsystem NQNs
+// read from sysfs, so no nvme commands are needed to find connected devices
+func (n *nvme) sysfsDevices() (map[string]string, error) {
+	sysBlock := n.exec.HostPath(n.sysBlock)
+	entries, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return nil, err
+	}
 
-			if strings.TrimSpace(controllerInfo.Subnqn) == strings.TrimSpace(nqn) {
-				return device.DevicePath, nil
+	devices := map[string]string{}
+	for _, entry := range entries {
+		if !nvmeDiskRegexp.MatchString(entry.Name()) {
+			continue
+		}
+		// device is the controller or, for multipath namespaces, the subsystem
+		subnqn, err := ioutil.ReadFile(filepath.Join(sysBlock, entry.Name(), "device", "subsysnqn"))
+		if err != nil {
+			// the device may be still initialized or already removed
+			continue
+		}
+		devices["/dev/"+entry.Name()] = strings.TrimSpace(string(subnqn))
+	}
+	return devices, nil
+}
+
+// lookupDevice returns connected device of the subsystem NQN, or empty string if
+// it's not found. Devices are listed with 'nvme list' if sysfs is not available.
+func (n *nvme) lookupDevice(nqn string) (string, error) {
+	devices, err := n.sysfsDevices()
+	if err != nil {
+		devices, err = n.listDevices()
+		if err != nil {
+			return "", err
+		}
+	}
+	for device, subnqn := range devices {
+		if subnqn == strings.TrimSpace(nqn) {
+			return device, nil
+		}
+	}
+	return "", nil
+}
+
+// findDevice waits for the device of the subsystem NQN to appear. Devices are
+// looked up as soon as events report an added NVMe disk and after the device
+// wait delays, which are the only wake ups if events is nil.
+// Waiting stops when the context is done.
+func (n *nvme) findDevice(ctx context.Context, nqn string, events deviceEvents) (string, error) {
+	for i := 0; i < n.deviceWait.Attempts; i++ {
+		device, err := n.lookupDevice(nqn)
+		if err != nil || device != "" {
+			return device, err
+		}
+
+		deadline := time.Now().Add(n.deviceWait.DelayAfter(i))
+		for events != nil && ctx.Err() == nil && events.wait(time.Until(deadline)) {
+			device, err := n.lookupDevice(nqn)
+			if err != nil || device != "" {
+				return device, err
 			}
 		}
-		time.Sleep(time.Duration(delay) * time.Second)
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", fmt.Errorf("stopped waiting for NVMe device by NQN %s: %v", nqn, ctx.Err())
+		case <-timer.C:
+		}
 	}
 
 	return "", fmt.Errorf("can't find NVMe device by NQN %s", nqn)
 }
 
 // Connect runs 'nvme connect' command to connect volume to the node
-func (n *nvme) Connect(transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string) (string, error) {
+func (n *nvme) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error) {
 	// nvme connect --transport rdma --traddr 192.168.1.1 --trsvcid 4420
 	//              --nqn nqn.2014-08.org.nvmexpress:uuid:157f29ff-18d2-4784-872e-cbf51bf4701a
 	//              --hostnqn nqn.2014-08.org.nvmexpress:uuid:265524c1-de5f-4b42-93df-e2b99fe02eb4
 	//
 	// --transport: network fabric being used for a NVMe-over-Fabrics network
 	// --traddr: network address of the Controller
-	// --trsvcid: the transport service id. For transports using IP addressing (e.g. rdma) this field is the port number
+	// --trsvcid: the transport service id. For transports using IP addressing (rdma, tcp) this field is the port number
 	// --nqn: NQN of the NVMe subsystem to connect to (volume entry point NQN in this case)
 	// --hostnqn: NQN of the host (computer system NQN in this case)
+	// --dhchap-secret, --dhchap-ctrl-secret: host and controller keys of the in-band authentication
 	options := []string{
 		"connect",
 		"--transport", transport,
@@ -114,17 +220,53 @@ func (n *nvme) Connect(transport, traddr, traddrfamily, trsvcid, nqn, hostnqn st
 		"--nqn", nqn,
 		"--hostnqn", hostnqn,
 	}
-	if _, err := nvmeCommand(options); err != nil {
+	if auth.HostKey != "" {
+		options = append(options, "--dhchap-secret", auth.HostKey)
+	}
+	if auth.CtrlKey != "" {
+		options = append(options, "--dhchap-ctrl-secret", auth.CtrlKey)
+	}
+
+	// listen before connecting, so the device added right away is not missed
+	events, err := n.listen()
+	if err != nil {
+		contextLogger(ctx, Logger{}).V(LogLevelState).Info("can't listen to kernel uevents, polling NVMe devices", "error", err)
+		events = nil
+	} else {
+		defer events.Close() // nolint: errcheck
+	}
+
+	if _, err := n.command(options); err != nil {
 		return "", err
 	}
 
-	return findNVMeDevice(nqn)
+	return n.findDevice(ctx, nqn, events)
 }
 
 // Disconnect disconnects nvme device from the node
 func (n *nvme) Disconnect(device string) error {
 	// nvme disconnect --device /dev/nvme1n1
 	// --device: NVMe device
-	_, err := nvmeCommand([]string{"disconnect", "--device", device})
+	_, err := n.command([]string{"disconnect", "--device", device})
 	return err
 }
+
+// SmartLog runs 'nvme smart-log' command to get device health information
+func (n *nvme) SmartLog(device string) (*SmartLog, error) {
+	out, err := n.command([]string{"smart-log", device, "-o", "json"})
+	if err != nil {
+		return nil, err
+	}
+
+	var smartLog SmartLog
+	err = json.Unmarshal(out, &smartLog)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode 'nvme smart-log %s -o json' output: %v", device, err)
+	}
+	return &smartLog, nil
+}
+
+// List returns connected NVMe devices mapped to their subsystem NQNs
+func (n *nvme) List() (map[string]string, error) {
+	return n.listDevices()
+}
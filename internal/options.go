@@ -0,0 +1,212 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// Option configures the driver created by NewDriverWithOptions
+type Option func(drv *Driver) error
+
+// NewDriverWithOptions returns a CSI plugin configured by the options applied in
+// order, so downstream integrations and tests can assemble custom drivers. It
+// has the defaults of NewDriver: no endpoint, RSD node ID and RSD client, the
+// host mount and nvme tools with the default policies, and the driver metrics.
+func NewDriverWithOptions(opts ...Option) (*Driver, error) {
+	drv := NewDriver("", "", nil)
+	for _, opt := range opts {
+		if err := opt(drv); err != nil {
+			return nil, err
+		}
+	}
+	return drv, nil
+}
+
+// WithEndpoint sets the endpoint the driver serves CSI on, e.g. unix:///csi/csi.sock
+func WithEndpoint(endpoint string) Option {
+	return func(drv *Driver) error {
+		drv.endpoint = endpoint
+		return nil
+	}
+}
+
+// WithRSDNodeID sets RSD node ID of the node the driver runs on
+func WithRSDNodeID(nodeID string) Option {
+	return func(drv *Driver) error {
+		drv.RSDNodeID = nodeID
+		return nil
+	}
+}
+
+// WithRSDClient sets the transport the driver sends RSD requests with
+func WithRSDClient(client rsd.Transport) Option {
+	return func(drv *Driver) error {
+		drv.rsdClient = client
+		return nil
+	}
+}
+
+// WithRack makes the driver serve the RSD PodM of another rack, see AddRack
+func WithRack(id string, client rsd.Transport) Option {
+	return func(drv *Driver) error {
+		return drv.AddRack(id, client)
+	}
+}
+
+// WithPolicies sets timeouts and retries of the nvme and mount tools. It
+// recreates the tools, so it must precede WithMounter and WithNVMe.
+func WithPolicies(policies policy.Policies) Option {
+	return func(drv *Driver) error {
+		drv.SetPolicies(policies)
+		return nil
+	}
+}
+
+// WithMounter replaces the mount and mkfs tools of the node
+func WithMounter(mounter Mounter) Option {
+	return func(drv *Driver) error {
+		if mounter == nil {
+			return fmt.Errorf("mounter is nil")
+		}
+		drv.mounter = mounter
+		return nil
+	}
+}
+
+// WithNVMe replaces the nvme tools of the node
+func WithNVMe(nvme NVMe) Option {
+	return func(drv *Driver) error {
+		if nvme == nil {
+			return fmt.Errorf("NVMe tools are nil")
+		}
+		drv.nvme = nvme
+		return nil
+	}
+}
+
+// WithClock sets the function returning the current time used for the
+// cache expiration, volume records and timestamps instead of time.Now
+func WithClock(now func() time.Time) Option {
+	return func(drv *Driver) error {
+		drv.clock = now
+		return nil
+	}
+}
+
+// WithNodeJournal makes the node keep staged state of the volumes in the
+// journal file, see SetNodeJournal
+func WithNodeJournal(path string) Option {
+	return func(drv *Driver) error {
+		return drv.SetNodeJournal(path)
+	}
+}
+
+// WithLogger sets Logger of the driver
+func WithLogger(logger Logger) Option {
+	return func(drv *Driver) error {
+		drv.SetLogger(logger)
+		return nil
+	}
+}
+
+// WithMetricsRegistry registers the driver metrics in the registry, e.g. to
+// serve the metrics of the embedded driver next to others. The registry must
+// not have the metrics of another driver.
+func WithMetricsRegistry(registry *MetricsRegistry) Option {
+	return func(drv *Driver) error {
+		if registry == nil {
+			return fmt.Errorf("metrics registry is nil")
+		}
+		drv.metrics = newDriverMetricsIn(registry)
+		drv.addMetricsCollectors()
+		return nil
+	}
+}
+
+// WithTopology enables the topology of the storage services, see SetTopology
+func WithTopology(enabled bool) Option {
+	return func(drv *Driver) error {
+		drv.SetTopology(enabled)
+		return nil
+	}
+}
+
+// WithFabricDirect enables the fabric-direct mode, see SetFabricDirect
+func WithFabricDirect(enabled bool) Option {
+	return func(drv *Driver) error {
+		drv.SetFabricDirect(enabled)
+		return nil
+	}
+}
+
+// WithoutControllerCapabilities hides the controller capabilities from
+// ControllerGetCapabilities, so the CO doesn't use them, e.g. snapshots
+// of a storage without snapshot support
+func WithoutControllerCapabilities(caps ...csi.ControllerServiceCapability_RPC_Type) Option {
+	return func(drv *Driver) error {
+		for _, cap := range caps {
+			if !containsCapability(supportedControllerCapabilities(), cap) {
+				return fmt.Errorf("controller capability %s is not supported by the driver", cap)
+			}
+			if drv.disabledCapabilities == nil {
+				drv.disabledCapabilities = map[csi.ControllerServiceCapability_RPC_Type]bool{}
+			}
+			drv.disabledCapabilities[cap] = true
+		}
+		return nil
+	}
+}
+
+// containsCapability checks if the list contains the capability
+func containsCapability(caps []csi.ControllerServiceCapability_RPC_Type, cap csi.ControllerServiceCapability_RPC_Type) bool {
+	for _, item := range caps {
+		if item == cap {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedControllerCapabilities returns the controller capabilities of the
+// driver and of the supported access modes
+func supportedControllerCapabilities() []csi.ControllerServiceCapability_RPC_Type {
+	caps := append([]csi.ControllerServiceCapability_RPC_Type{}, controllerCapabilities...)
+	return append(caps, accessModeControllerCapabilities()...)
+}
+
+// controllerCapabilities returns the controller capabilities the driver reports
+func (drv *Driver) controllerCapabilities() []csi.ControllerServiceCapability_RPC_Type {
+	var result []csi.ControllerServiceCapability_RPC_Type
+	for _, cap := range supportedControllerCapabilities() {
+		if !drv.disabledCapabilities[cap] {
+			result = append(result, cap)
+		}
+	}
+	return result
+}
+
+// now returns the current time of the driver clock
+func (drv *Driver) now() time.Time {
+	if drv.clock == nil {
+		return time.Now()
+	}
+	return drv.clock()
+}
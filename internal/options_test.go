@@ -0,0 +1,105 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestNewDriverWithOptions(t *testing.T) {
+	mounter, nvme := &testMounter{}, &testNVMe{}
+	registry := NewMetricsRegistry()
+	started := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	drv, err := NewDriverWithOptions(
+		WithEndpoint("unix:///csi/csi.sock"),
+		WithRSDNodeID("1"),
+		WithRSDClient(&TestClient{}),
+		WithMounter(mounter),
+		WithNVMe(nvme),
+		WithClock(func() time.Time { return started }),
+		WithMetricsRegistry(registry),
+		WithTopology(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drv.endpoint != "unix:///csi/csi.sock" || drv.RSDNodeID != "1" || drv.rsdClient == nil {
+		t.Errorf("driver endpoint %q, node ID %q and RSD client %v not set", drv.endpoint, drv.RSDNodeID, drv.rsdClient)
+	}
+	if drv.mounter != mounter || drv.nvme != nvme {
+		t.Error("driver node tools are not replaced")
+	}
+	if !drv.topology {
+		t.Error("driver topology is not enabled")
+	}
+	if now := drv.now(); !now.Equal(started) {
+		t.Errorf("driver clock = %v, want %v", now, started)
+	}
+
+	drv.metrics.operations.Inc(operationCreate, operationSuccess)
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "csi_rsd_operations_total") {
+		t.Errorf("metrics registry doesn't serve the driver metrics:\n%s", rec.Body.String())
+	}
+
+	for name, opt := range map[string]Option{
+		"nil mounter":            WithMounter(nil),
+		"nil NVMe":               WithNVMe(nil),
+		"nil registry":           WithMetricsRegistry(nil),
+		"unsupported capability": WithoutControllerCapabilities(csi.ControllerServiceCapability_RPC_CLONE_VOLUME),
+	} {
+		if _, err := NewDriverWithOptions(opt); err == nil {
+			t.Errorf("NewDriverWithOptions() with %s succeeded", name)
+		}
+	}
+}
+
+func TestWithoutControllerCapabilities(t *testing.T) {
+	drv, err := NewDriverWithOptions(WithoutControllerCapabilities(
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := drv.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(supportedControllerCapabilities()) - 2; len(resp.Capabilities) != want {
+		t.Errorf("ControllerGetCapabilities() = %v, want %d capabilities", resp.Capabilities, want)
+	}
+	for _, cap := range resp.Capabilities {
+		if rpc := cap.GetRpc().GetType(); rpc == csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT || rpc == csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS {
+			t.Errorf("ControllerGetCapabilities() reports disabled %s", rpc)
+		}
+	}
+
+	// the CSIDriver object of the driver without ControllerPublishVolume must not require attaching
+	drv, err = NewDriverWithOptions(WithoutControllerCapabilities(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, _ := drv.CheckCSIDriver(CSIDriverSpec{}); len(mismatches) != 1 {
+		t.Errorf("CheckCSIDriver() of default attachRequired = %q, want a mismatch", mismatches)
+	}
+}
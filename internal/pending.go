@@ -0,0 +1,140 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pendingCreation is a volume creation RSD accepted with a task which
+// didn't finish before the deadline of the CreateVolume request. The
+// retried request resumes waiting for the task instead of creating
+// another RSD volume.
+type pendingCreation struct {
+	// task is the URL of the RSD task, the token of the operation
+	task string
+	// location is the task or task monitor location returned by RSD
+	location string
+	// percent is the highest completion percentage seen, -1 if unknown
+	percent int
+	started time.Time
+	// rack is ID of the rack the volume is created in, empty for the default RSD endpoint
+	rack string
+}
+
+// creationPendingError is returned by CreateVolume when the RSD task
+// creating the volume is still running at the request deadline
+type creationPendingError struct {
+	task      string
+	percent   int
+	advancing bool
+}
+
+func (e *creationPendingError) Error() string {
+	if e.percent >= 0 {
+		return fmt.Sprintf("RSD task %s is still running: %d%% complete", e.task, e.percent)
+	}
+	return fmt.Sprintf("RSD task %s is still running", e.task)
+}
+
+// status returns retriable ABORTED while the task is advancing, the
+// retry resumes monitoring it. DEADLINE_EXCEEDED tells the task isn't
+// advancing, the retry still resumes it until the task poll timeout.
+func (e *creationPendingError) status(name string) error {
+	if e.advancing {
+		return status.Errorf(codes.Aborted, "Volume %s: creation in progress (operation %s), retry to resume: %v", name, e.task, e)
+	}
+	return status.Errorf(codes.DeadlineExceeded, "Volume %s: creation isn't advancing (operation %s), retry to resume: %v", name, e.task, e)
+}
+
+// creationPending checks if the creation of the volume was left pending
+func (drv *Driver) creationPending(name string) bool {
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+	_, pending := drv.pendingCreations[name]
+	return pending
+}
+
+// createRSDVolume creates the RSD volume of the CSI volume with the create
+// function, or resumes waiting for the task of the creation left pending
+// by the previous request. It must be called with the creation lock of the
+// volume name held, RSD is queried without holding drv.volumesRWL.
+func (drv *Driver) createRSDVolume(ctx context.Context, name string, create func() (*rsd.Volume, error)) (*rsd.Volume, error) {
+	// the capacity may be allocated even if the creation fails or is pending
+	defer drv.capacity.invalidate()
+
+	drv.volumesRWL.RLock()
+	pending, resumed := drv.pendingCreations[name]
+	drv.volumesRWL.RUnlock()
+
+	var rsdVolume *rsd.Volume
+	var err error
+	if resumed {
+		drv.logger.V(LogLevelState).Info("Resuming pending volume creation", "volume", name, "task", pending.task)
+		rsdVolume, err = rsd.NewVolumeOfTask(ctx, drv.client(ctx), pending.location)
+	} else {
+		rsdVolume, err = create()
+	}
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	if err != nil {
+		return nil, drv.pendingCreation(name, rackOf(ctx), err)
+	}
+	delete(drv.pendingCreations, name)
+	return rsdVolume, nil
+}
+
+// pendingCreation records the task of the volume creation if it's still
+// running, and returns creationPendingError for it. Other errors finish
+// the creation. The task is abandoned after the task poll timeout.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) pendingCreation(name, rack string, err error) error {
+	taskErr, ok := errors.Cause(err).(*rsd.TaskPendingError)
+	if !ok {
+		delete(drv.pendingCreations, name)
+		return err
+	}
+
+	pending, ok := drv.pendingCreations[name]
+	if !ok || pending.task != taskErr.URL {
+		pending = &pendingCreation{task: taskErr.URL, location: taskErr.Location, percent: -1, started: drv.now(), rack: rack}
+		if drv.pendingCreations == nil {
+			drv.pendingCreations = map[string]*pendingCreation{}
+		}
+		drv.pendingCreations[name] = pending
+	}
+
+	if limit := drv.policies.TaskPoll.Total(); limit > 0 && drv.now().Sub(pending.started) > limit {
+		drv.logger.Warning("Abandoned pending volume creation", "volume", name, "task", pending.task, "error", err)
+		delete(drv.pendingCreations, name)
+		return err
+	}
+
+	percent := taskErr.Task.Percent()
+	advancing := percent > pending.percent
+	if advancing {
+		pending.percent = percent
+	}
+	drv.logger.V(LogLevelState).Info("Volume creation pending", "volume", name, "task", pending.task, "percent", percent, "advancing", advancing)
+	return &creationPendingError{task: pending.task, percent: percent, advancing: advancing}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// taskClient accepts volume creation with a task
+type taskClient struct {
+	TestClient
+	posts int
+}
+
+// Post returns location of the task monitor
+func (client *taskClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.posts++
+	return &http.Header{"Location": []string{"/redfish/v1/TaskService/Tasks/1/Monitor"}}, nil
+}
+
+func TestPendingCreation(t *testing.T) {
+	client := &taskClient{
+		TestClient: TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+				"/redfish/v1/StorageServices/1/Volumes":   `{"Members": []}`,
+				"/redfish/v1/TaskService/Tasks/1/Monitor": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 100}`,
+			},
+		},
+	}
+	drv := &Driver{rsdClient: client, volumes: map[string]*Volume{}}
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}},
+		},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100},
+	}
+
+	for _, step := range []struct {
+		name      string
+		task      string
+		wantCode  codes.Code
+		wantPosts int
+	}{
+		{name: "Unknown progress", task: `{"TaskState": "Running"}`, wantCode: codes.DeadlineExceeded, wantPosts: 1},
+		{name: "Progress", task: `{"TaskState": "Running", "PercentComplete": 10}`, wantCode: codes.Aborted, wantPosts: 1},
+		{name: "Stalled", task: `{"TaskState": "Running", "PercentComplete": 10}`, wantCode: codes.DeadlineExceeded, wantPosts: 1},
+		{name: "More progress", task: `{"TaskState": "Running", "PercentComplete": 40}`, wantCode: codes.Aborted, wantPosts: 1},
+		{name: "Failed", task: `{"TaskState": "Exception"}`, wantCode: codes.Internal, wantPosts: 1},
+		{name: "Restarted", task: `{"TaskState": "Running", "PercentComplete": 5}`, wantCode: codes.Aborted, wantPosts: 2},
+		{name: "Completed", task: `{"TaskState": "Completed", "PercentComplete": 100}`, wantCode: codes.OK, wantPosts: 2},
+	} {
+		client.results["/redfish/v1/TaskService/Tasks/1"] = step.task
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		resp, err := drv.CreateVolume(ctx, req)
+		cancel()
+		if code := status.Code(err); code != step.wantCode {
+			t.Fatalf("%s: CreateVolume() code %v, want %v: %v", step.name, code, step.wantCode, err)
+		}
+		if client.posts != step.wantPosts {
+			t.Errorf("%s: volume creation posted %d times, want %d", step.name, client.posts, step.wantPosts)
+		}
+		if err == nil && resp.Volume.VolumeId != "1" {
+			t.Errorf("%s: CreateVolume() volume %q, want 1", step.name, resp.Volume.VolumeId)
+		}
+	}
+	if len(drv.pendingCreations) != 0 {
+		t.Errorf("pending creations %v left after the volume is created", drv.pendingCreations)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// listStorageServices returns all RSD storage services
+func (drv *Driver) listStorageServices(ctx context.Context) ([]*rsd.StorageService, error) {
+	client := drv.client(ctx)
+	ssCollection, err := rsd.GetStorageServiceCollection(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	return ssCollection.GetMembers(ctx, client)
+}
+
+// candidateStorageServices returns the storage services the volumes may be
+// created in: the one selected by the provisioning parameters or all of them,
+// if they're accessible from the requested topology
+func (drv *Driver) candidateStorageServices(ctx context.Context, provisioning *volumeProvisioning) ([]*rsd.StorageService, error) {
+	services, err := drv.selectedStorageServices(ctx, provisioning)
+	if err != nil {
+		return nil, err
+	}
+	return accessibleStorageServices(services, provisioning.accessibilityRequirement())
+}
+
+// selectedStorageServices returns the storage service selected by the
+// provisioning parameters or all of them
+func (drv *Driver) selectedStorageServices(ctx context.Context, provisioning *volumeProvisioning) ([]*rsd.StorageService, error) {
+	if id := provisioning.storageService(); id != "" {
+		service, err := rsd.GetStorageServiceByID(ctx, drv.client(ctx), id)
+		if err != nil {
+			return nil, err
+		}
+		return []*rsd.StorageService{service}, nil
+	}
+
+	services, err := drv.listStorageServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no RSD storage services found")
+	}
+
+	if name := provisioning.storageServiceName(); name != "" {
+		for _, service := range services {
+			if service.Name == name {
+				return []*rsd.StorageService{service}, nil
+			}
+		}
+		return nil, fmt.Errorf("no RSD storage service named %q found", name)
+	}
+
+	if pool := provisioning.storagePool(); pool != "" {
+		for _, service := range services {
+			if service.StoragePools.OdataID != "" && strings.HasPrefix(pool, service.StoragePools.OdataID+"/") {
+				return []*rsd.StorageService{service}, nil
+			}
+		}
+		return nil, fmt.Errorf("storage pool %s is not in any RSD storage service", pool)
+	}
+
+	return services, nil
+}
+
+// availableCapacity returns capacity of the storage pools of the service available for new volumes
+func (drv *Driver) availableCapacity(ctx context.Context, service *rsd.StorageService) (int64, error) {
+	client := drv.client(ctx)
+	poolCollection, err := service.GetStoragePoolCollection(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	pools, err := poolCollection.GetMembers(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	var result int64
+	for _, pool := range pools {
+		result += pool.Capacity.Data.GuaranteedBytes
+	}
+	return result, nil
+}
+
+// selectStorageService returns the storage service the volume is created in.
+// Of several candidate services the one with the most available capacity
+// is selected, the first one if it's the same. The services which fail to
+// report their capacity are skipped.
+func (drv *Driver) selectStorageService(ctx context.Context, provisioning *volumeProvisioning, requiredCapacity int64) (*rsd.StorageService, error) {
+	services, err := drv.candidateStorageServices(ctx, provisioning)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 1 {
+		return services[0], nil
+	}
+
+	var selected *rsd.StorageService
+	var selectedCapacity int64
+	for _, service := range services {
+		available, err := drv.availableCapacity(ctx, service)
+		if err != nil {
+			drv.logger.Warning("can't get available capacity of RSD storage service", "storage_service", service.ID, "error", err)
+			continue
+		}
+		if selected == nil || available > selectedCapacity {
+			selected = service
+			selectedCapacity = available
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("can't get available capacity of any of %d RSD storage services", len(services))
+	}
+	if selectedCapacity < requiredCapacity {
+		drv.logger.Warning("no RSD storage service has the required capacity available", "required_bytes", requiredCapacity, "storage_service", selected.ID, "available_bytes", selectedCapacity)
+	}
+	drv.logger.V(LogLevelState).Info("selected RSD storage service", "storage_service", selected.ID, "available_bytes", selectedCapacity)
+	return selected, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// twoServicesClient returns RSD with two storage services, the second one
+// with more available capacity
+func twoServicesClient() *TestClient {
+	return &TestClient{
+		results: map[string]string{
+			"/redfish/v1/StorageServices":                  `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}, {"@odata.id": "/redfish/v1/StorageServices/2"}]}`,
+			"/redfish/v1/StorageServices/1":                `{"Id": "1", "Name": "rack-a", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}, "StoragePools": {"@odata.id": "/redfish/v1/StorageServices/1/StoragePools"}}`,
+			"/redfish/v1/StorageServices/1/Volumes":        `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes", "Members": []}`,
+			"/redfish/v1/StorageServices/1/StoragePools":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1"}]}`,
+			"/redfish/v1/StorageServices/1/StoragePools/1": `{"Capacity": {"Data": {"GuaranteedBytes": 1000}}}`,
+			"/redfish/v1/StorageServices/2":                `{"Id": "2", "Name": "rack-b", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/2/Volumes"}, "StoragePools": {"@odata.id": "/redfish/v1/StorageServices/2/StoragePools"}}`,
+			"/redfish/v1/StorageServices/2/Volumes":        `{"@odata.id": "/redfish/v1/StorageServices/2/Volumes", "Members": []}`,
+			"/redfish/v1/StorageServices/2/StoragePools":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/2/StoragePools/1"}, {"@odata.id": "/redfish/v1/StorageServices/2/StoragePools/2"}]}`,
+			"/redfish/v1/StorageServices/2/StoragePools/1": `{"Capacity": {"Data": {"GuaranteedBytes": 2000}}}`,
+			"/redfish/v1/StorageServices/2/StoragePools/2": `{"Capacity": {"Data": {"GuaranteedBytes": 500}}}`,
+		},
+	}
+}
+
+func TestSelectStorageService(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		wantService string
+		wantErr     bool
+	}{
+		{name: "most available capacity", parameters: map[string]string{}, wantService: "2"},
+		{name: "by id", parameters: map[string]string{StorageServiceParameter: "1"}, wantService: "1"},
+		{name: "by name", parameters: map[string]string{StorageServiceNameParameter: "rack-a"}, wantService: "1"},
+		{name: "by pool", parameters: map[string]string{StoragePoolParameter: "/redfish/v1/StorageServices/1/StoragePools/1"}, wantService: "1"},
+		{name: "unknown id", parameters: map[string]string{StorageServiceParameter: "3"}, wantErr: true},
+		{name: "unknown name", parameters: map[string]string{StorageServiceNameParameter: "rack-c"}, wantErr: true},
+		{name: "unknown pool", parameters: map[string]string{StoragePoolParameter: "/redfish/v1/StorageServices/3/StoragePools/1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{rsdClient: twoServicesClient()}
+			provisioning, err := parseProvisioning(tt.parameters)
+			if err != nil {
+				t.Fatalf("parseProvisioning() unexpected error: %v", err)
+			}
+			got, err := drv.selectStorageService(context.Background(), provisioning, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectStorageService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != tt.wantService {
+				t.Errorf("selectStorageService() = %s, want %s", got.ID, tt.wantService)
+			}
+		})
+	}
+}
+
+func TestSelectStorageServiceSkipsFailedServices(t *testing.T) {
+	client := twoServicesClient()
+	delete(client.results, "/redfish/v1/StorageServices/2/StoragePools")
+	drv := &Driver{rsdClient: client}
+
+	got, err := drv.selectStorageService(context.Background(), nil, 100)
+	if err != nil {
+		t.Fatalf("selectStorageService() unexpected error: %v", err)
+	}
+	if got.ID != "1" {
+		t.Errorf("selectStorageService() = %s, want the service reporting its capacity", got.ID)
+	}
+}
+
+func TestGetCapacityStorageServices(t *testing.T) {
+	drv := &Driver{rsdClient: twoServicesClient()}
+	tests := []struct {
+		parameters map[string]string
+		want       int64
+	}{
+		{parameters: nil, want: 3500},
+		{parameters: map[string]string{StorageServiceParameter: "1"}, want: 1000},
+		{parameters: map[string]string{StorageServiceNameParameter: "rack-b"}, want: 2500},
+	}
+	for _, tt := range tests {
+		resp, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: tt.parameters})
+		if err != nil {
+			t.Fatalf("GetCapacity(%v) unexpected error: %v", tt.parameters, err)
+		}
+		if resp.AvailableCapacity != tt.want {
+			t.Errorf("GetCapacity(%v) = %d, want %d", tt.parameters, resp.AvailableCapacity, tt.want)
+		}
+	}
+}
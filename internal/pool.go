@@ -0,0 +1,266 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+const (
+	// spareDescriptionPrefix starts RSD volume Description of the spare volumes,
+	// e.g. csi.rsd.intel.com/spare:cluster1:1073741824
+	spareDescriptionPrefix = DriverName + "/spare"
+
+	// spareRetryInterval is an interval of refilling the spare pool after failures
+	spareRetryInterval = time.Minute
+)
+
+// sparePool keeps RSD volumes created in advance, so CreateVolume
+// can claim one instead of waiting for RSD to create a new volume
+type sparePool struct {
+	sync.Mutex
+	// counts maps volume capacity to the number of spare volumes to keep
+	counts map[int64]int
+	// volumes maps volume capacity to the available spare volumes
+	volumes map[int64][]*rsd.Volume
+	// refill wakes up the pool filling loop when a volume is claimed
+	refill chan struct{}
+}
+
+// ParseSpareVolumes parses comma separated list of <capacity>:<count> pairs,
+// e.g. "1Gi:3,10Gi:1". Capacity is a Kubernetes resource quantity.
+func ParseSpareVolumes(spec string) (map[int64]int, error) {
+	counts := map[int64]int{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid spare volumes %q: expected <capacity>:<count>", item)
+		}
+		capacity, err := ParseSize(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid spare volume capacity %q: %v", parts[0], err)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("invalid number of spare volumes %q: must be positive integer", parts[1])
+		}
+		counts[capacity] += count
+	}
+	return counts, nil
+}
+
+// SetSpareVolumes configures the number of spare volumes of each capacity
+// kept by RunSparePool
+func (drv *Driver) SetSpareVolumes(counts map[int64]int) {
+	drv.spares = &sparePool{
+		counts:  counts,
+		volumes: map[int64][]*rsd.Volume{},
+		refill:  make(chan struct{}, 1),
+	}
+}
+
+// spareDescription returns RSD volume Description of the spare volume
+func (drv *Driver) spareDescription(capacity int64) string {
+	return strings.Join([]string{spareDescriptionPrefix, drv.ClusterID, strconv.FormatInt(capacity, 10)}, descriptionSeparator)
+}
+
+// spareCapacityFromDescription returns requested capacity of the spare volume
+// It returns false if volume is not a spare volume of the cluster
+func (drv *Driver) spareCapacityFromDescription(description string) (int64, bool) {
+	parts := strings.SplitN(description, descriptionSeparator, 3)
+	if len(parts) != 3 || parts[0] != spareDescriptionPrefix || parts[1] != drv.ClusterID {
+		return 0, false
+	}
+	capacity, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return capacity, true
+}
+
+// updateSpareMetrics sets number of available spare volumes.
+// It must be called with drv.spares locked.
+func (drv *Driver) updateSpareMetrics() {
+	for capacity := range drv.spares.counts {
+		drv.metrics.spareVolumes.Set(float64(len(drv.spares.volumes[capacity])), strconv.FormatInt(capacity, 10))
+	}
+}
+
+// claimSpareVolume tags spare volume of the required capacity with the CSI
+// volume name and removes it from the pool. It returns nil if there is no
+// such spare volume. It must be called with the creation lock of the volume name held.
+func (drv *Driver) claimSpareVolume(ctx context.Context, name string, requiredCapacity int64) *rsd.Volume {
+	pool := drv.spares
+	if pool == nil {
+		return nil
+	}
+
+	pool.Lock()
+	spares := pool.volumes[requiredCapacity]
+	if len(spares) == 0 {
+		pool.Unlock()
+		return nil
+	}
+	rsdVolume := spares[0]
+	pool.volumes[requiredCapacity] = spares[1:]
+	drv.updateSpareMetrics()
+	pool.Unlock()
+
+	// wake up the filling loop unless it's already woken up
+	select {
+	case pool.refill <- struct{}{}:
+	default:
+	}
+
+	// The volume is dropped from the pool even if tagging fails,
+	// it's adopted again on restart if it's still a spare one.
+	logger := drv.requestLogger(ctx)
+	if err := rsdVolume.SetDescription(ctx, drv.client(ctx), drv.volumeDescription(name)); err != nil {
+		logger.Warning("can't claim spare RSD volume", "rsd_volume", rsdVolume.ID, "volume", name, "error", err)
+		return nil
+	}
+
+	logger.V(LogLevelState).Info("claimed spare RSD volume", "rsd_volume", rsdVolume.ID, "volume", name)
+	return rsdVolume
+}
+
+// adoptSpareVolumes adds spare volumes previously created by the driver
+// in the cluster to the pool, so they are not lost when the driver is restarted
+func (drv *Driver) adoptSpareVolumes() error {
+	ctx := context.Background()
+	client := drv.rsdClient
+	volCollection, err := rsd.GetVolumeCollectionByService(ctx, client, "")
+	if err != nil {
+		return err
+	}
+
+	pool := drv.spares
+	err = volCollection.ForEachMember(ctx, client, func(rsdVolume *rsd.Volume) error {
+		capacity, ok := drv.spareCapacityFromDescription(rsdVolume.Description)
+		if !ok {
+			return nil
+		}
+		pool.Lock()
+		defer pool.Unlock()
+		if _, configured := pool.counts[capacity]; !configured {
+			drv.logger.V(LogLevelState).Info("ignoring spare RSD volume of not configured capacity", "rsd_volume", rsdVolume.ID, "capacity", capacity)
+			return nil
+		}
+		pool.volumes[capacity] = append(pool.volumes[capacity], rsdVolume)
+		drv.logger.V(LogLevelState).Info("adopted spare RSD volume", "rsd_volume", rsdVolume.ID, "capacity", capacity)
+		return nil
+	})
+
+	pool.Lock()
+	defer pool.Unlock()
+	drv.updateSpareMetrics()
+
+	return err
+}
+
+// createSpareVolume creates new RSD volume tagged as a spare one
+func (drv *Driver) createSpareVolume(capacity int64) (*rsd.Volume, error) {
+	defer drv.capacity.invalidate()
+	ctx := context.Background()
+	volCollection, err := rsd.GetVolumeCollectionByService(ctx, drv.rsdClient, "")
+	if err != nil {
+		return nil, err
+	}
+	return volCollection.NewVolume(ctx, drv.rsdClient, &rsd.NewVolumeRequest{
+		CapacityBytes: capacity,
+		Description:   drv.spareDescription(capacity),
+	})
+}
+
+// fillSparePool creates missing spare volumes.
+// It returns false if any of them couldn't be created.
+func (drv *Driver) fillSparePool() bool {
+	pool := drv.spares
+
+	// create volumes without holding the lock, so they can be claimed meanwhile
+	pool.Lock()
+	missing := map[int64]int{}
+	var capacities []int64
+	for capacity, count := range pool.counts {
+		if n := count - len(pool.volumes[capacity]); n > 0 {
+			missing[capacity] = n
+			capacities = append(capacities, capacity)
+		}
+	}
+	pool.Unlock()
+
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i] < capacities[j] })
+
+	for _, capacity := range capacities {
+		for i := 0; i < missing[capacity]; i++ {
+			rsdVolume, err := drv.createSpareVolume(capacity)
+			if category := drv.observeOperation(operationCreate, err); err != nil {
+				drv.logger.Warning("can't create spare RSD volume", "capacity", capacity, "category", category, "error", err)
+				return false
+			}
+
+			pool.Lock()
+			pool.volumes[capacity] = append(pool.volumes[capacity], rsdVolume)
+			drv.updateSpareMetrics()
+			pool.Unlock()
+			drv.logger.V(LogLevelState).Info("created spare RSD volume", "rsd_volume", rsdVolume.ID, "capacity", capacity)
+		}
+	}
+	return true
+}
+
+// RunSparePool adopts existing spare volumes and keeps the pool filled until stop is closed
+func (drv *Driver) RunSparePool(stop <-chan struct{}) {
+	pool := drv.spares
+	if pool == nil {
+		return
+	}
+
+	adopted := false
+	for {
+		if !adopted {
+			// don't create spare volumes until the existing ones are known
+			if err := drv.adoptSpareVolumes(); err != nil {
+				drv.logger.Warning("can't adopt spare RSD volumes", "error", err)
+			} else {
+				adopted = true
+			}
+		}
+
+		var retry <-chan time.Time
+		if !adopted || !drv.fillSparePool() {
+			retry = time.After(spareRetryInterval)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-pool.refill:
+		case <-retry:
+		}
+	}
+}
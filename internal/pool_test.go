@@ -0,0 +1,165 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// patchRecorder is a TestClient recording PATCH requests
+type patchRecorder struct {
+	TestClient
+	patches map[string]interface{}
+}
+
+func (client *patchRecorder) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.patches[entrypoint] = data
+	return nil, nil
+}
+
+func TestParseSpareVolumes(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[int64]int
+		wantErr bool
+	}{
+		{spec: "", want: map[int64]int{}},
+		{spec: "1Gi:3, 10Gi:1", want: map[int64]int{GB: 3, 10 * GB: 1}},
+		{spec: "1Gi:1,1073741824:2", want: map[int64]int{GB: 3}},
+		{spec: "1Gi", wantErr: true},
+		{spec: "1Gi:0", wantErr: true},
+		{spec: "-1Gi:1", wantErr: true},
+		{spec: "big:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSpareVolumes(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSpareVolumes(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSpareVolumes(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeClaimsSpare(t *testing.T) {
+	client := &patchRecorder{
+		TestClient: TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+				"/redfish/v1/StorageServices/1/Volumes":   `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}`,
+				"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 50}`,
+			},
+		},
+		patches: map[string]interface{}{},
+	}
+	drv := &Driver{
+		ClusterID: "cluster1",
+		rsdClient: client,
+		volumes:   map[string]*Volume{},
+		metrics:   newDriverMetrics(),
+	}
+	drv.SetSpareVolumes(map[int64]int{100: 1})
+	drv.spares.volumes[100] = []*rsd.Volume{
+		{OdataID: "/redfish/v1/StorageServices/1/Volumes/2", ID: "2", CapacityBytes: 100, Description: drv.spareDescription(100)},
+	}
+
+	caps := []*csi.VolumeCapability{
+		{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}},
+	}
+	for _, tt := range []struct {
+		name     string
+		capacity int64
+		wantID   string
+	}{
+		{name: "pvc-spare", capacity: 100, wantID: "2"},
+		// the pool is empty now
+		{name: "pvc-new", capacity: 100, wantID: "1"},
+	} {
+		resp, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               tt.name,
+			VolumeCapabilities: caps,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: tt.capacity},
+		})
+		if err != nil {
+			t.Fatalf("CreateVolume(%s) unexpected error: %v", tt.name, err)
+		}
+		if resp.Volume.VolumeId != tt.wantID {
+			t.Errorf("CreateVolume(%s) volume ID = %s, want %s", tt.name, resp.Volume.VolumeId, tt.wantID)
+		}
+	}
+
+	want := map[string]interface{}{
+		"/redfish/v1/StorageServices/1/Volumes/2": map[string]string{"Description": "csi.rsd.intel.com:cluster1:pvc-spare"},
+	}
+	if !reflect.DeepEqual(client.patches, want) {
+		t.Errorf("claiming spare volume patched %v, want %v", client.patches, want)
+	}
+	select {
+	case <-drv.spares.refill:
+	default:
+		t.Error("claiming spare volume didn't trigger refill")
+	}
+}
+
+func TestFillSparePool(t *testing.T) {
+	drv := &Driver{
+		ClusterID: "cluster1",
+		rsdClient: &TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":           `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1":         `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+				"/redfish/v1/StorageServices/1/Volumes": `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}, {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/3"}, {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/4"}]}`,
+				"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100,
+					"Description": "csi.rsd.intel.com/spare:cluster1:100"}`,
+				"/redfish/v1/StorageServices/1/Volumes/2": `{"Id": "2", "CapacityBytes": 100,
+					"Description": "csi.rsd.intel.com/spare:cluster1:100"}`,
+				"/redfish/v1/StorageServices/1/Volumes/3": `{"Id": "3", "CapacityBytes": 100,
+					"Description": "csi.rsd.intel.com/spare:cluster2:100"}`,
+				"/redfish/v1/StorageServices/1/Volumes/4": `{"Id": "4", "CapacityBytes": 100,
+					"Description": "csi.rsd.intel.com:cluster1:pvc-1"}`,
+			},
+		},
+		metrics: newDriverMetrics(),
+	}
+	drv.SetSpareVolumes(map[int64]int{100: 2})
+
+	if err := drv.adoptSpareVolumes(); err != nil {
+		t.Fatalf("adoptSpareVolumes() unexpected error: %v", err)
+	}
+	if n := len(drv.spares.volumes[100]); n != 1 {
+		t.Fatalf("adopted %d spare volumes, want 1", n)
+	}
+
+	if !drv.fillSparePool() {
+		t.Fatal("fillSparePool() failed")
+	}
+	var ids []string
+	for _, volume := range drv.spares.volumes[100] {
+		ids = append(ids, volume.ID)
+	}
+	if want := []string{"2", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("spare volumes = %v, want %v", ids, want)
+	}
+}
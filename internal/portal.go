@@ -0,0 +1,81 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"golang.org/x/net/context"
+)
+
+// SetPortalCheckTimeout makes the node check the volume portal is reachable
+// within the timeout before connecting to it, and try the other portals of the
+// volume if it's not. Portals are not checked if the timeout is 0.
+func (drv *Driver) SetPortalCheckTimeout(timeout time.Duration) {
+	drv.portalCheckTimeout = timeout
+}
+
+// checkPortal connects to the portal over TCP. Refused connection tells the
+// portal host is reachable, e.g. RDMA target not listening on TCP, so only
+// failures like timeouts or unreachable networks are reported.
+func checkPortal(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err == nil {
+		return conn.Close()
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok && sysErr.Err == syscall.ECONNREFUSED {
+			return nil
+		}
+	}
+	return err
+}
+
+// connectVolume connects the volume to the node with nvme connect and returns
+// the device. The portal from the stage secrets is used if it's set, otherwise
+// the volume portals are tried in order until one of them is reachable. The
+// override of the StorageClass is applied to the portals first.
+func (drv *Driver) connectVolume(ctx context.Context, volume *Volume, override portalOverride, secrets stageSecrets) (string, error) {
+	endPoints := override.apply(append([]*endpoint.Portal{volume.EndPoint}, volume.AltEndPoints...))
+	if secrets.portalAddress != "" {
+		endPoints = []*endpoint.Portal{secrets.endPoint(endPoints[0])}
+	}
+
+	check := drv.checkPortal
+	if check == nil {
+		check = checkPortal
+	}
+
+	var unreachable []string
+	for _, ep := range endPoints {
+		if drv.portalCheckTimeout > 0 {
+			address := ep.HostPort()
+			if err := check(address, drv.portalCheckTimeout); err != nil {
+				drv.requestLogger(ctx).Warning("portal unreachable from node", "volume", volume.Name, "portal", address, "error", err)
+				unreachable = append(unreachable, fmt.Sprintf("portal %s unreachable from node: %v", address, err))
+				continue
+			}
+		}
+		return drv.nvme.Connect(ctx, ep.Transport, ep.Address, ep.AddressFamily, strconv.Itoa(ep.Port), ep.NQN, volume.RSDNodeNQN, secrets.auth)
+	}
+	return "", fmt.Errorf("%s", strings.Join(unreachable, "; "))
+}
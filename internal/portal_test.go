@@ -0,0 +1,164 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+// portalNVMe records the portals connected to
+type portalNVMe struct {
+	testNVMe
+	portals    []string
+	transports []string
+}
+
+func (n *portalNVMe) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth FabricAuth) (string, error) {
+	n.portals = append(n.portals, net.JoinHostPort(traddr, trsvcid))
+	n.transports = append(n.transports, transport)
+	return n.testNVMe.Connect(ctx, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn, auth)
+}
+
+func TestCheckPortal(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	if err := checkPortal(address, time.Second); err != nil {
+		t.Errorf("checkPortal(%s) unexpected error: %v", address, err)
+	}
+
+	// refused connection is reachable
+	listener.Close()
+	if err := checkPortal(address, time.Second); err != nil {
+		t.Errorf("checkPortal(%s) of closed port unexpected error: %v", address, err)
+	}
+}
+
+func TestConnectVolumePortals(t *testing.T) {
+	volume := &Volume{
+		Name:     "pvc-1",
+		EndPoint: &endpoint.Portal{Transport: "rdma", Address: "192.168.1.1", AddressFamily: "IPv4", Port: 4420},
+		AltEndPoints: []*endpoint.Portal{
+			{Transport: "rdma", Address: "192.168.2.1", AddressFamily: "IPv4", Port: 4420},
+			{Transport: "rdma", Address: "fd00::1", AddressFamily: "IPv6", Port: 4420},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		timeout       time.Duration
+		unreachable   []string
+		override      portalOverride
+		secrets       stageSecrets
+		want          []string
+		wantTransport string
+		wantErr       string
+	}{
+		{
+			name:        "Not checked",
+			unreachable: []string{"192.168.1.1:4420"},
+			want:        []string{"192.168.1.1:4420"},
+		},
+		{
+			name:    "Reachable",
+			timeout: time.Second,
+			want:    []string{"192.168.1.1:4420"},
+		},
+		{
+			name:        "Next portal",
+			timeout:     time.Second,
+			unreachable: []string{"192.168.1.1:4420", "192.168.2.1:4420"},
+			want:        []string{"[fd00::1]:4420"},
+		},
+		{
+			name:        "Unreachable",
+			timeout:     time.Second,
+			unreachable: []string{"192.168.1.1:4420", "192.168.2.1:4420", "[fd00::1]:4420"},
+			wantErr:     "portal 192.168.1.1:4420 unreachable from node: i/o timeout; portal 192.168.2.1:4420 unreachable",
+		},
+		{
+			name:          "Transport and port override",
+			timeout:       time.Second,
+			unreachable:   []string{"192.168.1.1:8009"},
+			override:      portalOverride{transport: "tcp", port: 8009},
+			want:          []string{"192.168.2.1:8009"},
+			wantTransport: "tcp",
+		},
+		{
+			name:        "Address override",
+			timeout:     time.Second,
+			unreachable: []string{"10.0.0.2:4420"},
+			override:    portalOverride{address: "10.0.0.2"},
+			wantErr:     "portal 10.0.0.2:4420 unreachable from node: i/o timeout",
+		},
+		{
+			name:          "Portal in the secrets with port override",
+			override:      portalOverride{port: 8009},
+			secrets:       stageSecrets{portalAddress: "10.0.0.1"},
+			want:          []string{"10.0.0.1:8009"},
+			wantTransport: "rdma",
+		},
+		{
+			name:        "Portal in the secrets",
+			timeout:     time.Second,
+			unreachable: []string{"10.0.0.1:4421"},
+			secrets:     stageSecrets{portalAddress: "10.0.0.1", portalPort: 4421},
+			wantErr:     "portal 10.0.0.1:4421 unreachable from node",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &portalNVMe{}
+			drv := &Driver{
+				nvme:               n,
+				portalCheckTimeout: tt.timeout,
+				checkPortal: func(address string, timeout time.Duration) error {
+					if containsString(tt.unreachable, address) {
+						return fmt.Errorf("i/o timeout")
+					}
+					return nil
+				},
+			}
+			_, err := drv.connectVolume(context.Background(), volume, tt.override, tt.secrets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("connectVolume() error = %v, want %q", err, tt.wantErr)
+				}
+				if len(n.portals) != 0 {
+					t.Errorf("connected to unreachable portals %v", n.portals)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connectVolume() unexpected error: %v", err)
+			}
+			if fmt.Sprint(n.portals) != fmt.Sprint(tt.want) {
+				t.Errorf("connected to %v, want %v", n.portals, tt.want)
+			}
+			if tt.wantTransport != "" && n.transports[0] != tt.wantTransport {
+				t.Errorf("connected with transport %s, want %s", n.transports[0], tt.wantTransport)
+			}
+		})
+	}
+}
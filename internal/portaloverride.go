@@ -0,0 +1,129 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+const (
+	// TransportParameter is a StorageClass parameter with the NVMe-oF
+	// transport the node connects the volume portals with, e.g. tcp
+	TransportParameter = "transport"
+	// PortalPortParameter is a StorageClass parameter overriding the port of
+	// the volume portals, e.g. 4420
+	PortalPortParameter = "portalPort"
+	// PortalAddressParameter is a StorageClass parameter overriding the IP
+	// address of the volume portals
+	PortalAddressParameter = "portalAddress"
+)
+
+// portalParameters are the parameters of the portal override
+var portalParameters = []string{TransportParameter, PortalPortParameter, PortalAddressParameter}
+
+// portalOverride replaces transport, port and address of the portals derived
+// from the RSD endpoints of the volume if they're not empty
+type portalOverride struct {
+	transport string
+	port      int
+	address   string
+}
+
+// parsePortalOverride validates the portal override of the volume context
+func parsePortalOverride(volumeContext map[string]string) (portalOverride, error) {
+	var override portalOverride
+	if transport := volumeContext[TransportParameter]; transport != "" {
+		if !containsString(endpoint.Transports(), transport) {
+			return override, fmt.Errorf("parameter %s: unknown transport %q, supported transports are %v", TransportParameter, transport, endpoint.Transports())
+		}
+		override.transport = transport
+	}
+	if port := volumeContext[PortalPortParameter]; port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil || number <= 0 || number > 65535 {
+			return override, fmt.Errorf("parameter %s: invalid port %q", PortalPortParameter, port)
+		}
+		override.port = number
+	}
+	if address := volumeContext[PortalAddressParameter]; address != "" {
+		if net.ParseIP(address) == nil {
+			return override, fmt.Errorf("parameter %s: %q is not an IP address", PortalAddressParameter, address)
+		}
+		override.address = address
+	}
+	return override, nil
+}
+
+// portalContext validates the portal override in the CreateVolume parameters
+// and returns it to be stored in the volume context
+func portalContext(parameters map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, key := range portalParameters {
+		if value := parameters[key]; value != "" {
+			result[key] = value
+		}
+	}
+	if _, err := parsePortalOverride(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// hasPortalOverride checks if any portal parameter is set in the volume context
+func hasPortalOverride(volumeContext map[string]string) bool {
+	for _, key := range portalParameters {
+		if volumeContext[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the portals with the override applied, portals made equal by
+// the override are connected to once
+func (override portalOverride) apply(portals []*endpoint.Portal) []*endpoint.Portal {
+	if override == (portalOverride{}) {
+		return portals
+	}
+	var result []*endpoint.Portal
+	seen := map[string]bool{}
+	for _, portal := range portals {
+		overridden := *portal
+		if override.transport != "" {
+			overridden.Transport = override.transport
+		}
+		if override.port != 0 {
+			overridden.Port = override.port
+		}
+		if override.address != "" {
+			overridden.Address = override.address
+			overridden.AddressFamily = endpoint.FamilyIPv4
+			if net.ParseIP(override.address).To4() == nil {
+				overridden.AddressFamily = endpoint.FamilyIPv6
+			}
+		}
+		key := strings.Join([]string{overridden.Transport, overridden.HostPort(), overridden.NQN}, " ")
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, &overridden)
+		}
+	}
+	return result
+}
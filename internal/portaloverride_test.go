@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+func TestPortalContext(t *testing.T) {
+	tests := []struct {
+		parameters map[string]string
+		want       portalOverride
+		wantErr    bool
+	}{
+		{parameters: map[string]string{}},
+		{parameters: map[string]string{TransportParameter: "tcp", PortalPortParameter: "8009", PortalAddressParameter: "fd00::2"}, want: portalOverride{transport: "tcp", port: 8009, address: "fd00::2"}},
+		{parameters: map[string]string{TransportParameter: "TCP"}, wantErr: true},
+		{parameters: map[string]string{TransportParameter: "fc"}, wantErr: true},
+		{parameters: map[string]string{PortalPortParameter: "0"}, wantErr: true},
+		{parameters: map[string]string{PortalPortParameter: "65536"}, wantErr: true},
+		{parameters: map[string]string{PortalAddressParameter: "target.example.com"}, wantErr: true},
+		{parameters: map[string]string{PortalAddressParameter: "10.0.0.1:4420"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		volumeContext, err := portalContext(tt.parameters)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("portalContext(%v) error = %v, wantErr %v", tt.parameters, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(volumeContext) != len(tt.parameters) || hasPortalOverride(volumeContext) != (len(tt.parameters) > 0) {
+			t.Errorf("portalContext(%v) = %v", tt.parameters, volumeContext)
+		}
+		got, err := parsePortalOverride(volumeContext)
+		if err != nil || got != tt.want {
+			t.Errorf("parsePortalOverride(%v) = %+v, %v, want %+v", volumeContext, got, err, tt.want)
+		}
+	}
+}
+
+func TestPortalOverrideApply(t *testing.T) {
+	portals := []*endpoint.Portal{
+		{Transport: "rdma", Address: "192.168.1.1", AddressFamily: endpoint.FamilyIPv4, Port: 4420, NQN: "nqn.1"},
+		{Transport: "rdma", Address: "192.168.2.1", AddressFamily: endpoint.FamilyIPv4, Port: 4420, NQN: "nqn.1"},
+	}
+	tests := []struct {
+		override portalOverride
+		want     string
+	}{
+		{override: portalOverride{}, want: "rdma 192.168.1.1:4420 IPv4, rdma 192.168.2.1:4420 IPv4"},
+		{override: portalOverride{transport: "tcp", port: 8009}, want: "tcp 192.168.1.1:8009 IPv4, tcp 192.168.2.1:8009 IPv4"},
+		{override: portalOverride{address: "fd00::2"}, want: "rdma [fd00::2]:4420 IPv6"},
+	}
+	for _, tt := range tests {
+		var got string
+		for i, portal := range tt.override.apply(portals) {
+			if i > 0 {
+				got += ", "
+			}
+			got += fmt.Sprintf("%s %s %s", portal.Transport, portal.HostPort(), portal.AddressFamily)
+		}
+		if got != tt.want {
+			t.Errorf("%+v applied to the portals = %q, want %q", tt.override, got, tt.want)
+		}
+	}
+	if portals[0].Transport != "rdma" || portals[0].Port != 4420 {
+		t.Errorf("override modified the volume portals: %+v", portals[0])
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kernel state checked by 'csirsd preflight'. It's the same in the host
+// root, so the paths are not translated into it.
+const (
+	sysModule         = "/sys/module"
+	sysInfiniband     = "/sys/class/infiniband"
+	defaultPortalPort = "4420"
+)
+
+// preflightTools are executables used by the node plugin regardless of the filesystems
+var preflightTools = []string{"nvme", "mount", "umount", "lsblk", "blkid", "findmnt"}
+
+// transportModules maps NVMe-oF transports to their kernel modules
+var transportModules = map[string]string{
+	"rdma": "nvme_rdma",
+	"tcp":  "nvme_tcp",
+}
+
+// PreflightOptions defines node prerequisites checked by 'csirsd preflight'
+type PreflightOptions struct {
+	// HostRoot is a directory to look for the tools in, if it's not empty
+	HostRoot string
+	// Transports are NVMe-oF transports to check kernel modules of, e.g. rdma or tcp.
+	// RDMA devices are checked if rdma is one of them.
+	Transports []string
+	// Filesystems are the filesystems to check mkfs tools of, e.g. ext4
+	Filesystems []string
+	// Portals are host:port addresses of the storage portals to connect to.
+	// Port 4420 is used if it's not specified.
+	Portals []string
+	// Timeout limits connecting to each portal
+	Timeout time.Duration
+}
+
+// PreflightCheck is a result of a single node prerequisite check
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// PreflightReport lists results of the node prerequisite checks
+type PreflightReport struct {
+	OK     bool             `json:"ok"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// add adds the check result to the report
+func (report *PreflightReport) add(name string, err error, message string) {
+	check := PreflightCheck{Name: name, OK: err == nil, Message: message}
+	if err != nil {
+		check.Message = err.Error()
+		report.OK = false
+	}
+	report.Checks = append(report.Checks, check)
+}
+
+// Preflight checks the node prerequisites of the node plugin: kernel modules of
+// the NVMe-oF transports, tools, RDMA devices and connectivity to the storage portals.
+func Preflight(opts PreflightOptions) *PreflightReport {
+	return preflight(newExecer(opts.HostRoot), sysModule, sysInfiniband, opts)
+}
+
+func preflight(execer Execer, moduleDir, infinibandDir string, opts PreflightOptions) *PreflightReport {
+	report := &PreflightReport{OK: true}
+
+	for _, transport := range opts.Transports {
+		module, known := transportModules[transport]
+		if !known {
+			report.add("module/"+transport, fmt.Errorf("unknown NVMe-oF transport %q", transport), "")
+			continue
+		}
+		report.add("module/"+module, checkModule(moduleDir, module), "loaded")
+	}
+
+	tools := append([]string{}, preflightTools...)
+	for _, fsType := range opts.Filesystems {
+		tools = append(tools, "mkfs."+fsType)
+	}
+	for _, tool := range tools {
+		path, err := execer.LookPath(tool)
+		report.add("tool/"+tool, err, path)
+	}
+
+	for _, transport := range opts.Transports {
+		if transport != "rdma" {
+			continue
+		}
+		names, err := rdmaDevices(infinibandDir)
+		report.add("rdma-devices", err, strings.Join(names, ","))
+	}
+
+	for _, portal := range opts.Portals {
+		address := portal
+		if _, _, err := net.SplitHostPort(portal); err != nil {
+			address = net.JoinHostPort(portal, defaultPortalPort)
+		}
+		conn, err := net.DialTimeout("tcp", address, opts.Timeout)
+		if err == nil {
+			conn.Close() // nolint: errcheck
+		}
+		report.add("portal/"+address, err, "reachable")
+	}
+
+	return report
+}
+
+// checkModule returns an error if the kernel module is not loaded
+func checkModule(moduleDir, module string) error {
+	// loaded and built-in modules are both listed in sysfs
+	_, err := os.Stat(filepath.Join(moduleDir, module))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("kernel module %s is not loaded", module)
+	}
+	return err
+}
+
+// rdmaDevices returns names of the RDMA devices, or an error if there are none
+func rdmaDevices(infinibandDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(infinibandDir)
+	if err == nil && len(entries) == 0 {
+		err = fmt.Errorf("no RDMA devices found in %s", infinibandDir)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, err
+}
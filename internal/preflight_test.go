@@ -0,0 +1,90 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreflight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	moduleDir := filepath.Join(dir, "module")
+	infinibandDir := filepath.Join(dir, "infiniband")
+	for _, path := range []string{filepath.Join(moduleDir, "nvme_rdma"), filepath.Join(infinibandDir, "mlx5_0")} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reachable := listener.Addr().String()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+	defer listener.Close()
+
+	checks := func(report *PreflightReport) map[string]bool {
+		result := map[string]bool{}
+		for _, check := range report.Checks {
+			result[check.Name] = check.OK
+		}
+		return result
+	}
+
+	report := preflight(&fakeExecer{}, moduleDir, infinibandDir, PreflightOptions{
+		Transports:  []string{"rdma"},
+		Filesystems: []string{"ext4"},
+		Portals:     []string{reachable},
+		Timeout:     time.Second,
+	})
+	if !report.OK {
+		t.Errorf("preflight failed: %+v", report.Checks)
+	}
+	for _, name := range []string{"module/nvme_rdma", "tool/nvme", "tool/mkfs.ext4", "rdma-devices", "portal/" + reachable} {
+		if ok, found := checks(report)[name]; !ok || !found {
+			t.Errorf("check %s: ok %v, found %v", name, ok, found)
+		}
+	}
+
+	report = preflight(&fakeExecer{}, moduleDir, filepath.Join(dir, "none"), PreflightOptions{
+		Transports: []string{"rdma", "tcp", "fc"},
+		Portals:    []string{unreachable},
+		Timeout:    time.Second,
+	})
+	if report.OK {
+		t.Error("preflight unexpectedly succeeded")
+	}
+	for _, name := range []string{"module/nvme_tcp", "module/fc", "rdma-devices", "portal/" + unreachable} {
+		if ok, found := checks(report)[name]; ok || !found {
+			t.Errorf("check %s: ok %v, found %v, want failed", name, ok, found)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// volumeProgressStep is the step in percent of the RSD operation
+// progress reported by the volume events
+const volumeProgressStep = 25
+
+// Reasons of the events reporting RSD operations of the volumes
+const (
+	eventReasonOperationProgress  = "VolumeOperationProgress"
+	eventReasonOperationCompleted = "VolumeOperationCompleted"
+)
+
+// volumeOperation returns the name and the completion percentage of the RSD
+// operation of the volume in progress, e.g. background initialization of
+// a freshly created volume, or an empty name if there is none
+func volumeOperation(rsdVolume *rsd.Volume) (string, int) {
+	if rsdVolume == nil {
+		return "", 0
+	}
+	for _, operation := range rsdVolume.Operations {
+		if operation.PercentageComplete < 100 {
+			return operation.OperationName, operation.PercentageComplete
+		}
+	}
+	return "", 0
+}
+
+// operationProgress describes the RSD operation of the volume in progress
+// for the error messages, empty if there is none
+func (volume *Volume) operationProgress() string {
+	if volume.Operation == "" {
+		return ""
+	}
+	return fmt.Sprintf("RSD operation %s of the volume is %d%% complete", volume.Operation, volume.OperationPercent)
+}
+
+// observeVolumeProgress reports progress of the RSD operation of the volume,
+// so users understand why a freshly created volume isn't attachable yet:
+// changes are logged and exported as csi_rsd_volume_operation_percent_complete,
+// and every volumeProgressStep percent and the completion are reported as
+// events about the volume PVC. The changes are logged with the logger of the
+// request. It must be called with drv.volumesRWL locked.
+func (drv *Driver) observeVolumeProgress(ctx context.Context, volume *Volume) {
+	operation, percent := volumeOperation(volume.RSDVolume)
+	previous, previousPercent := volume.Operation, volume.OperationPercent
+	if operation == previous && percent == previousPercent {
+		return
+	}
+	volume.Operation, volume.OperationPercent = operation, percent
+
+	logger := drv.requestLogger(ctx)
+	volumeID := volume.CSIVolume.VolumeId
+	if previous != "" && previous != operation {
+		drv.metrics.volumeOperationProgress.Delete(volumeID, previous)
+		logger.V(LogLevelState).Info("RSD operation of the volume completed", "volume", volume.Name, "volume_id", volumeID, "operation", previous)
+		message := fmt.Sprintf("RSD operation %s of the volume %s(%s) completed", previous, volume.Name, volumeID)
+		drv.events.report(logger, volume.CSIVolume.VolumeContext, eventReasonOperationCompleted, message)
+	}
+	if operation == "" {
+		return
+	}
+
+	drv.metrics.volumeOperationProgress.Set(float64(percent), volumeID, operation)
+	logger.V(LogLevelState).Info("RSD operation of the volume in progress", "volume", volume.Name, "volume_id", volumeID, "operation", operation, "percent_complete", percent)
+	if operation != previous || percent/volumeProgressStep != previousPercent/volumeProgressStep {
+		message := fmt.Sprintf("RSD operation %s of the volume %s(%s) is %d%% complete, the volume may not be attachable until it completes",
+			operation, volume.Name, volumeID, percent)
+		drv.events.report(logger, volume.CSIVolume.VolumeContext, eventReasonOperationProgress, message)
+	}
+}
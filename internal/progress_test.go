@@ -0,0 +1,113 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestVolumeProgress(t *testing.T) {
+	const odataID = "/redfish/v1/StorageServices/1/Volumes/1"
+	client := &TestClient{results: map[string]string{}}
+	sink := &recordingEventSink{}
+	drv := &Driver{
+		metrics:   newDriverMetrics(),
+		rsdClient: client,
+		volumes: map[string]*Volume{
+			"pvc-1": &Volume{
+				Name: "pvc-1",
+				CSIVolume: &csi.Volume{VolumeId: "1", CapacityBytes: 100,
+					VolumeContext: map[string]string{pvcNameParameter: "data", pvcNamespaceParameter: "default"}},
+				RSDVolume: &rsd.Volume{OdataID: odataID, ID: "1", CapacityBytes: 100},
+			},
+		},
+	}
+	drv.SetEventSink(sink, 1)
+
+	metrics := func() string {
+		rec := httptest.NewRecorder()
+		drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	resync := func(operations string) {
+		client.results[odataID] = fmt.Sprintf(`{"@odata.id": "%s", "Id": "1", "CapacityBytes": 100, "Operations": [%s]}`, odataID, operations)
+		drv.resyncVolumes()
+	}
+
+	resync(`{"OperationName": "Initialize", "PercentageComplete": 10}`)
+	if progress := drv.volumes["pvc-1"].operationProgress(); progress != "RSD operation Initialize of the volume is 10% complete" {
+		t.Errorf("operationProgress() = %q", progress)
+	}
+	line := `csi_rsd_volume_operation_percent_complete{volume_id="1",operation="Initialize"} 10`
+	if got := metrics(); !strings.Contains(got, line+"\n") {
+		t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+	}
+
+	// progress within the same step isn't reported again
+	resync(`{"OperationName": "Initialize", "PercentageComplete": 20}`)
+	resync(`{"OperationName": "Initialize", "PercentageComplete": 60}`)
+	resync("")
+	if drv.volumes["pvc-1"].operationProgress() != "" {
+		t.Errorf("completed operation still in progress: %q", drv.volumes["pvc-1"].operationProgress())
+	}
+	if got := metrics(); strings.Contains(got, "csi_rsd_volume_operation_percent_complete{") {
+		t.Errorf("completed operation still exported:\n%s", got)
+	}
+
+	var reasons []string
+	for _, event := range sink.events {
+		if event.object.Kind != EventKindPVC || event.object.Name != "data" {
+			t.Errorf("event about unexpected object %+v", event.object)
+		}
+		reasons = append(reasons, event.reason)
+	}
+	want := []string{eventReasonOperationProgress, eventReasonOperationProgress, eventReasonOperationCompleted}
+	if strings.Join(reasons, ",") != strings.Join(want, ",") {
+		t.Errorf("reported events %v, want %v", reasons, want)
+	}
+}
+
+func TestVolumeProgressLogger(t *testing.T) {
+	volume := &Volume{
+		Name:      "pvc-1",
+		CSIVolume: &csi.Volume{VolumeId: "1"},
+		RSDVolume: &rsd.Volume{ID: "1"},
+	}
+	if err := json.Unmarshal([]byte(`{"Operations": [{"OperationName": "Initialize", "PercentageComplete": 10}]}`), volume.RSDVolume); err != nil {
+		t.Fatal(err)
+	}
+	drv := &Driver{metrics: newDriverMetrics()}
+
+	// progress is logged with the request ID at the state verbosity
+	ctx := withLogger(context.Background(), NewLogger(LogLevelState).WithValues("request_id", 7))
+	got := captureLog(func() { drv.observeVolumeProgress(ctx, volume) })
+	if len(got) != 1 || !strings.Contains(got[0], "request_id=7") || !strings.Contains(got[0], "percent_complete=10") {
+		t.Errorf("logged %q, want progress of the request 7", got)
+	}
+
+	volume.RSDVolume.Operations[0].PercentageComplete = 20
+	ctx = withLogger(context.Background(), NewLogger(0))
+	if got := captureLog(func() { drv.observeVolumeProgress(ctx, volume) }); len(got) != 1 || got[0] != "" {
+		t.Errorf("logged %q below the state verbosity", got)
+	}
+}
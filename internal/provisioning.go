@@ -0,0 +1,181 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+const (
+	// StorageServiceParameter is a StorageClass parameter with the Id of the
+	// RSD storage service the volumes are created in
+	StorageServiceParameter = "storageService"
+	// StorageServiceNameParameter is a StorageClass parameter with the Name of
+	// the RSD storage service the volumes are created in
+	StorageServiceNameParameter = "storageServiceName"
+	// StoragePoolParameter is a StorageClass parameter with the @odata.id of
+	// the RSD storage pool providing capacity of the volumes
+	StoragePoolParameter = "storagePool"
+	// BootableParameter is a StorageClass parameter marking the volumes bootable
+	BootableParameter = "bootable"
+	// EraseOnDetachParameter is a StorageClass parameter making RSD erase
+	// the volumes when they're detached from the node
+	EraseOnDetachParameter = "eraseOnDetach"
+	// EncryptedParameter is a StorageClass parameter requesting encrypted volumes
+	EncryptedParameter = "encrypted"
+)
+
+// volumeProvisioning are RSD properties of the new volumes set by the StorageClass
+// parameters, zero value creates the volumes in the storage service with the most
+// available capacity with RSD defaults
+type volumeProvisioning struct {
+	serviceID     string
+	serviceName   string
+	pool          string
+	bootable      *bool
+	eraseOnDetach *bool
+	encrypted     *bool
+	rack          string
+	// accessibility restricts the storage services to the ones reachable
+	// from the requested topology, nil if it's not requested
+	accessibility *csi.TopologyRequirement
+}
+
+// parseProvisioning validates provisioning parameters of CreateVolume
+func parseProvisioning(parameters map[string]string) (*volumeProvisioning, error) {
+	result := &volumeProvisioning{
+		serviceID:   parameters[StorageServiceParameter],
+		serviceName: parameters[StorageServiceNameParameter],
+		pool:        parameters[StoragePoolParameter],
+		rack:        parameters[RackParameter],
+	}
+	if result.serviceID != "" && result.serviceName != "" {
+		return nil, fmt.Errorf("parameters %s and %s are mutually exclusive", StorageServiceParameter, StorageServiceNameParameter)
+	}
+	if result.pool != "" && !strings.HasPrefix(result.pool, "/") {
+		return nil, fmt.Errorf("parameter %s: %q is not an @odata.id of RSD storage pool, e.g. /redfish/v1/StorageServices/1/StoragePools/1", StoragePoolParameter, result.pool)
+	}
+	for key, value := range map[string]**bool{
+		BootableParameter:      &result.bootable,
+		EraseOnDetachParameter: &result.eraseOnDetach,
+		EncryptedParameter:     &result.encrypted,
+	} {
+		text, exists := parameters[key]
+		if !exists {
+			continue
+		}
+		flag, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %q is not a boolean", key, text)
+		}
+		*value = &flag
+	}
+	return result, nil
+}
+
+// isDefault checks if the volumes are created like without the parameters
+func (p *volumeProvisioning) isDefault() bool {
+	return p == nil || *p == volumeProvisioning{}
+}
+
+// storageService returns Id of the storage service the volumes are created in,
+// empty if it's not selected by Id
+func (p *volumeProvisioning) storageService() string {
+	if p == nil {
+		return ""
+	}
+	return p.serviceID
+}
+
+// storageServiceName returns Name of the storage service the volumes are
+// created in, empty if it's not selected by Name
+func (p *volumeProvisioning) storageServiceName() string {
+	if p == nil {
+		return ""
+	}
+	return p.serviceName
+}
+
+// storagePool returns @odata.id of the storage pool providing capacity of the volumes
+func (p *volumeProvisioning) storagePool() string {
+	if p == nil {
+		return ""
+	}
+	return p.pool
+}
+
+// rackID returns ID of the rack the volumes are created in, empty if it's not selected
+func (p *volumeProvisioning) rackID() string {
+	if p == nil {
+		return ""
+	}
+	return p.rack
+}
+
+// accessibilityRequirement returns the topology the volumes must be accessible from
+func (p *volumeProvisioning) accessibilityRequirement() *csi.TopologyRequirement {
+	if p == nil {
+		return nil
+	}
+	return p.accessibility
+}
+
+// newVolumeRequest returns request creating RSD volume of the capacity with the properties
+func (p *volumeProvisioning) newVolumeRequest(capacityBytes int64, description string) *rsd.NewVolumeRequest {
+	request := &rsd.NewVolumeRequest{
+		CapacityBytes: capacityBytes,
+		Description:   description,
+	}
+	if p == nil {
+		return request
+	}
+	if p.pool != "" {
+		request.CapacitySources = []rsd.CapacitySource{rsd.NewCapacitySource(p.pool)}
+	}
+	request.Encrypted = p.encrypted
+	if p.bootable != nil || p.eraseOnDetach != nil {
+		request.Oem = &rsd.NewVolumeOem{}
+		request.Oem.IntelRackScale.Bootable = p.bootable
+		request.Oem.IntelRackScale.EraseOnDetach = p.eraseOnDetach
+	}
+	return request
+}
+
+// provisioningContext returns the provisioning parameters to be stored in the volume context
+func provisioningContext(parameters map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, key := range []string{StorageServiceParameter, StorageServiceNameParameter, StoragePoolParameter, BootableParameter, EraseOnDetachParameter, EncryptedParameter, RackParameter} {
+		if value, exists := parameters[key]; exists {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// volumeCollectionOf returns the collection of the storage service the volume is in,
+// so replicas of volumes in any storage service are created next to them
+func (drv *Driver) volumeCollectionOf(ctx context.Context, volume *rsd.Volume) (*rsd.VolumeCollection, error) {
+	if volume.OdataID == "" {
+		return rsd.GetVolumeCollectionByService(ctx, drv.client(ctx), "")
+	}
+	return &rsd.VolumeCollection{OdataID: path.Dir(volume.OdataID)}, nil
+}
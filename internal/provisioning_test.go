@@ -0,0 +1,154 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseProvisioning(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		wantDefault bool
+		wantService string
+		wantErr     bool
+	}{
+		{name: "no parameters", parameters: map[string]string{}, wantDefault: true},
+		{name: "other parameters", parameters: map[string]string{ReformatPolicyParameter: ReformatNever}, wantDefault: true},
+		{name: "storage service", parameters: map[string]string{StorageServiceParameter: "2"}, wantService: "2"},
+		{name: "storage pool", parameters: map[string]string{StoragePoolParameter: "/redfish/v1/StorageServices/1/StoragePools/2"}},
+		{name: "flags", parameters: map[string]string{BootableParameter: "true", EraseOnDetachParameter: "false", EncryptedParameter: "1"}},
+		{name: "relative storage pool", parameters: map[string]string{StoragePoolParameter: "StoragePools/2"}, wantErr: true},
+		{name: "invalid flag", parameters: map[string]string{EncryptedParameter: "yes"}, wantErr: true},
+		{name: "storage service id and name", parameters: map[string]string{StorageServiceParameter: "1", StorageServiceNameParameter: "rack-a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProvisioning(tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProvisioning() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.isDefault() != tt.wantDefault {
+				t.Errorf("parseProvisioning().isDefault() = %v, want %v", got.isDefault(), tt.wantDefault)
+			}
+			if got.storageService() != tt.wantService {
+				t.Errorf("parseProvisioning().storageService() = %q, want %q", got.storageService(), tt.wantService)
+			}
+		})
+	}
+}
+
+func TestNewVolumeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       string
+	}{
+		{
+			name:       "defaults",
+			parameters: map[string]string{},
+			want:       `{"CapacityBytes":1024,"Description":"vol"}`,
+		},
+		{
+			name: "all parameters",
+			parameters: map[string]string{
+				StorageServiceParameter: "1",
+				StoragePoolParameter:    "/redfish/v1/StorageServices/1/StoragePools/2",
+				BootableParameter:       "true",
+				EraseOnDetachParameter:  "false",
+				EncryptedParameter:      "true",
+			},
+			want: `{"CapacityBytes":1024,"Description":"vol",` +
+				`"CapacitySources":[{"ProvidingPools":[{"@odata.id":"/redfish/v1/StorageServices/1/StoragePools/2"}]}],` +
+				`"Encrypted":true,"Oem":{"Intel_RackScale":{"Bootable":true,"EraseOnDetach":false}}}`,
+		},
+		{
+			name:       "encrypted only",
+			parameters: map[string]string{EncryptedParameter: "false"},
+			want:       `{"CapacityBytes":1024,"Description":"vol","Encrypted":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provisioning, err := parseProvisioning(tt.parameters)
+			if err != nil {
+				t.Fatalf("parseProvisioning() unexpected error: %v", err)
+			}
+			data, err := json.Marshal(provisioning.newVolumeRequest(1024, "vol"))
+			if err != nil {
+				t.Fatalf("json.Marshal() unexpected error: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("newVolumeRequest() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+
+	var nilProvisioning *volumeProvisioning
+	if got := nilProvisioning.newVolumeRequest(1024, "vol"); got.Oem != nil || got.CapacitySources != nil || got.Encrypted != nil {
+		t.Errorf("newVolumeRequest() of nil provisioning = %+v, want defaults", got)
+	}
+}
+
+func TestProvisioningContext(t *testing.T) {
+	got := provisioningContext(map[string]string{
+		StorageServiceParameter: "1",
+		EncryptedParameter:      "true",
+		ReformatPolicyParameter: ReformatNever,
+	})
+	if len(got) != 2 || got[StorageServiceParameter] != "1" || got[EncryptedParameter] != "true" {
+		t.Errorf("provisioningContext() = %v", got)
+	}
+}
+
+func TestVolumeCollectionOf(t *testing.T) {
+	drv := &Driver{}
+	got, err := drv.volumeCollectionOf(context.Background(), &rsd.Volume{OdataID: "/redfish/v1/StorageServices/2/Volumes/3"})
+	if err != nil {
+		t.Fatalf("volumeCollectionOf() unexpected error: %v", err)
+	}
+	if got.OdataID != "/redfish/v1/StorageServices/2/Volumes" {
+		t.Errorf("volumeCollectionOf() = %s, want /redfish/v1/StorageServices/2/Volumes", got.OdataID)
+	}
+}
+
+func TestCreateVolumeInvalidProvisioning(t *testing.T) {
+	drv := &Driver{volumes: map[string]*Volume{}}
+	req := &csi.CreateVolumeRequest{
+		Name: "Vol1",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		},
+		Parameters: map[string]string{BootableParameter: "maybe"},
+	}
+	_, err := drv.CreateVolume(context.Background(), req)
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("CreateVolume() error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// devDiskByID is a directory with stable symlinks to the block devices
+const devDiskByID = "/dev/disk/by-id"
+
+// podUIDRegexp matches pod UID in the kubelet target paths,
+// e.g. /var/lib/kubelet/pods/<uid>/volumes/kubernetes.io~csi/<pv>/mount
+var podUIDRegexp = regexp.MustCompile(`/pods/([^/]+)/volumes/`)
+
+// publishedVolume maps target path of the published volume to its device
+// and NVMe subsystem, so node troubleshooting tools don't have to parse logs
+type publishedVolume struct {
+	PodUID            string `json:"podUid,omitempty"`
+	TargetPath        string `json:"targetPath"`
+	Name              string `json:"name"`
+	VolumeID          string `json:"volumeId"`
+	StagingTargetPath string `json:"stagingTargetPath"`
+	Device            string `json:"device"`
+	DeviceByID        string `json:"deviceById,omitempty"`
+	FSUUID            string `json:"fsUuid,omitempty"`
+	FSLabel           string `json:"fsLabel,omitempty"`
+	SubsystemNQN      string `json:"subsystemNqn,omitempty"`
+	HostNQN           string `json:"hostNqn,omitempty"`
+}
+
+// publishedVolumes returns target paths of the volumes published on the node sorted by path
+func (drv *Driver) publishedVolumes() []publishedVolume {
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+
+	result := []publishedVolume{}
+	for name, vol := range drv.volumes {
+		for targetPath := range vol.TargetPaths {
+			published := publishedVolume{
+				PodUID:            podUIDFromTargetPath(targetPath),
+				TargetPath:        targetPath,
+				Name:              name,
+				VolumeID:          vol.CSIVolume.VolumeId,
+				StagingTargetPath: vol.StagingTargetPath,
+				Device:            vol.Device,
+				DeviceByID:        vol.DeviceByID,
+				FSUUID:            vol.FSUUID,
+				FSLabel:           vol.FSLabel,
+				HostNQN:           vol.RSDNodeNQN,
+			}
+			if vol.EndPoint != nil {
+				published.SubsystemNQN = vol.EndPoint.NQN
+			}
+			result = append(result, published)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TargetPath < result[j].TargetPath })
+	return result
+}
+
+// podUIDFromTargetPath returns UID of the pod the kubelet target path belongs to
+func podUIDFromTargetPath(targetPath string) string {
+	if match := podUIDRegexp.FindStringSubmatch(targetPath); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// resolveDeviceByID returns /dev/disk/by-id symlink of the device, preferring
+// the nvme- ones. It returns empty string if there is no such symlink.
+func resolveDeviceByID(hostRoot, device string) string {
+	entries, err := ioutil.ReadDir(filepath.Join(hostRoot, devDiskByID))
+	if err != nil || device == "" {
+		return ""
+	}
+
+	var links []string
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(hostRoot, devDiskByID, entry.Name()))
+		if err != nil {
+			continue
+		}
+		// links are relative to the host directory, e.g. ../../nvme0n1
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(devDiskByID, target)
+		}
+		if filepath.Clean(target) == device {
+			links = append(links, entry.Name())
+		}
+	}
+	if len(links) == 0 {
+		return ""
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		iNVMe, jNVMe := strings.HasPrefix(links[i], "nvme-"), strings.HasPrefix(links[j], "nvme-")
+		if iNVMe != jNVMe {
+			return iNVMe
+		}
+		return links[i] < links[j]
+	})
+	return filepath.Join(devDiskByID, links[0])
+}
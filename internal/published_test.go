@@ -0,0 +1,99 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+func TestResolveDeviceByID(t *testing.T) {
+	hostRoot, err := ioutil.TempDir("", "csirsd-by-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostRoot)
+
+	dir := filepath.Join(hostRoot, devDiskByID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"nvme-eui.0001":          "../../nvme1n1",
+		"nvme-Linux_1234":        "../../nvme1n1",
+		"dm-name-vg":             "../../nvme1n1",
+		"nvme-Linux_5678":        "../../nvme2n1",
+		"nvme-Linux_1234-part1":  "../../nvme1n1p1",
+		"wwn-0x5000c500a1b2c3d4": "/dev/sda",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for device, want := range map[string]string{
+		"/dev/nvme1n1": "/dev/disk/by-id/nvme-Linux_1234",
+		"/dev/nvme2n1": "/dev/disk/by-id/nvme-Linux_5678",
+		"/dev/sda":     "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4",
+		"/dev/nvme3n1": "",
+	} {
+		if got := resolveDeviceByID(hostRoot, device); got != want {
+			t.Errorf("resolveDeviceByID(%s) = %q, want %q", device, got, want)
+		}
+	}
+}
+
+func TestPublishedVolumes(t *testing.T) {
+	drv := &Driver{
+		volumes: map[string]*Volume{
+			"pvc-1": {
+				CSIVolume:         &csi.Volume{VolumeId: "1"},
+				EndPoint:          &endpoint.Portal{NQN: "nqn.target1"},
+				RSDNodeNQN:        "nqn.host1",
+				Device:            "/dev/nvme1n1",
+				DeviceByID:        "/dev/disk/by-id/nvme-Linux_1234",
+				FSUUID:            "c0ffee00-0000-0000-0000-000000000001",
+				StagingTargetPath: "/staging/pvc-1",
+				TargetPaths: map[string]bool{
+					"/var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-1/mount": true,
+				},
+			},
+			// not published volumes are not listed
+			"pvc-2": {CSIVolume: &csi.Volume{VolumeId: "2"}, TargetPaths: map[string]bool{}},
+		},
+	}
+	want := []publishedVolume{{
+		PodUID:            "a1b2",
+		TargetPath:        "/var/lib/kubelet/pods/a1b2/volumes/kubernetes.io~csi/pvc-1/mount",
+		Name:              "pvc-1",
+		VolumeID:          "1",
+		StagingTargetPath: "/staging/pvc-1",
+		Device:            "/dev/nvme1n1",
+		DeviceByID:        "/dev/disk/by-id/nvme-Linux_1234",
+		FSUUID:            "c0ffee00-0000-0000-0000-000000000001",
+		SubsystemNQN:      "nqn.target1",
+		HostNQN:           "nqn.host1",
+	}}
+	if got := drv.publishedVolumes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("publishedVolumes() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+const (
+	// RackParameter is a StorageClass parameter with the ID of the rack the
+	// volumes are created in, DefaultRack for the default RSD endpoint. The
+	// volumes are created in the rack with the most available capacity if
+	// it's not set.
+	RackParameter = "rack"
+	// DefaultRack is the RackParameter value of the default RSD endpoint
+	DefaultRack = "default"
+)
+
+// rackSeparator separates the rack ID from the IDs of the RSD volumes and
+// nodes of the rack, e.g. rack2:1
+const rackSeparator = ":"
+
+// rack is the RSD PodM of another rack served by the driver
+type rack struct {
+	id     string
+	client rsd.Transport
+}
+
+// AddRack makes the driver serve the volumes and nodes of the RSD PodM of
+// another rack with the client next to the default RSD endpoint. CSI volume
+// IDs and node IDs of the rack are prefixed with the rack ID, e.g. rack2:1.
+func (drv *Driver) AddRack(id string, client rsd.Transport) error {
+	if id == "" || id == DefaultRack || strings.ContainsAny(id, rackSeparator+"@/,=") {
+		return fmt.Errorf("invalid rack ID %q: it must not be empty, %q nor contain any of %q", id, DefaultRack, rackSeparator+"@/,=")
+	}
+	if client == nil {
+		return fmt.Errorf("RSD client of the rack %s is nil", id)
+	}
+	if drv.findRack(id) != nil {
+		return fmt.Errorf("rack %s is already added", id)
+	}
+	drv.racks = append(drv.racks, &rack{id: id, client: client})
+	return nil
+}
+
+// findRack returns the rack with the ID, nil if the driver doesn't serve it
+func (drv *Driver) findRack(id string) *rack {
+	for _, r := range drv.racks {
+		if r.id == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// rackID returns the CSI ID of the RSD volume or node of the rack
+func rackID(rack, id string) string {
+	return rack + rackSeparator + id
+}
+
+// splitRackID returns the rack of the CSI volume or node ID and the RSD ID
+// in the rack, the rack is nil for the IDs of the default RSD endpoint
+func (drv *Driver) splitRackID(id string) (*rack, string) {
+	if separator := strings.Index(id, rackSeparator); separator > 0 {
+		if r := drv.findRack(id[:separator]); r != nil {
+			return r, id[separator+len(rackSeparator):]
+		}
+	}
+	return nil, id
+}
+
+// describeRack returns the rack name used in the messages
+func describeRack(id string) string {
+	if id == "" {
+		return "the default rack"
+	}
+	return "rack " + id
+}
+
+// withRack returns the context of the requests served with the client of
+// the rack, nil rack is the default RSD endpoint
+func withRack(ctx context.Context, r *rack) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, rsdEndpointKey{}, &requestRSDEndpoint{rack: r.id, client: r.client})
+}
+
+// rackOf returns ID of the rack of the request, empty for the default RSD
+// endpoint and the endpoints from the secrets
+func rackOf(ctx context.Context) string {
+	if endpoint, ok := ctx.Value(rsdEndpointKey{}).(*requestRSDEndpoint); ok {
+		return endpoint.rack
+	}
+	return ""
+}
+
+// withNodeRack returns the context of the requests to the RSD node served
+// with the client of its rack and ID of the node in the rack. Nodes of the
+// default RSD endpoint are served with the client of the context.
+func (drv *Driver) withNodeRack(ctx context.Context, nodeID string) (context.Context, string) {
+	r, rsdNodeID := drv.splitRackID(nodeID)
+	if r == nil {
+		return ctx, nodeID
+	}
+	return withRack(ctx, r), rsdNodeID
+}
+
+// checkNodeRack checks that the volume and the node are in the same rack,
+// as RSD attaches only the volumes of its own storage services
+func (drv *Driver) checkNodeRack(volume *Volume, nodeID string) error {
+	nodeRack := ""
+	if r, _ := drv.splitRackID(nodeID); r != nil {
+		nodeRack = r.id
+	}
+	if nodeRack != volume.Rack || (nodeRack != "" && volume.RSDEndpoint != "") {
+		return fmt.Errorf("volume in %s can't be attached to the node in %s", describeRack(volume.Rack), describeRack(nodeRack))
+	}
+	return nil
+}
+
+// rackContexts returns the contexts of the requests to the racks the volumes
+// with the provisioning parameters may be created in: the rack of the
+// parameters or the default RSD endpoint and all the racks. Volumes of the
+// RSD endpoint from the secrets are created only in it.
+func (drv *Driver) rackContexts(ctx context.Context, provisioning *volumeProvisioning) ([]context.Context, error) {
+	if rsdEndpointOf(ctx) != "" {
+		return []context.Context{ctx}, nil
+	}
+	switch id := provisioning.rackID(); id {
+	case "":
+		return drv.allRackContexts(ctx), nil
+	case DefaultRack:
+		return []context.Context{ctx}, nil
+	default:
+		r := drv.findRack(id)
+		if r == nil {
+			return nil, fmt.Errorf("parameter %s: rack %q is not served by the driver", RackParameter, id)
+		}
+		return []context.Context{withRack(ctx, r)}, nil
+	}
+}
+
+// allRackContexts returns the contexts of the requests to the default RSD
+// endpoint and all the racks
+func (drv *Driver) allRackContexts(ctx context.Context) []context.Context {
+	result := []context.Context{ctx}
+	for _, r := range drv.racks {
+		result = append(result, withRack(ctx, r))
+	}
+	return result
+}
+
+// selectRack returns the context of the requests to the rack the volume is
+// created in: the rack of the provisioning parameters or the one with the
+// storage service with the most available capacity
+func (drv *Driver) selectRack(ctx context.Context, provisioning *volumeProvisioning) (context.Context, error) {
+	contexts, err := drv.rackContexts(ctx, provisioning)
+	if err != nil {
+		return ctx, err
+	}
+	if len(contexts) == 1 {
+		return contexts[0], nil
+	}
+
+	var selected context.Context
+	var selectedCapacity int64
+	for _, rackCtx := range contexts {
+		services, err := drv.candidateStorageServices(rackCtx, provisioning)
+		if err != nil {
+			drv.logger.Warning("can't get RSD storage services of the rack", "rack", rackOf(rackCtx), "error", err)
+			continue
+		}
+		for _, service := range services {
+			available, err := drv.availableCapacity(rackCtx, service)
+			if err != nil {
+				drv.logger.Warning("can't get available capacity of RSD storage service", "rack", rackOf(rackCtx), "storage_service", service.ID, "error", err)
+				continue
+			}
+			if selected == nil || available > selectedCapacity {
+				selected = rackCtx
+				selectedCapacity = available
+			}
+		}
+	}
+	if selected == nil {
+		return ctx, fmt.Errorf("can't get available capacity of any of %d racks", len(contexts))
+	}
+	return selected, nil
+}
+
+// creationRack returns the context of the requests to the rack the volume
+// is created in, the creation left pending is resumed in its rack
+func (drv *Driver) creationRack(ctx context.Context, name string, provisioning *volumeProvisioning) (context.Context, error) {
+	drv.volumesRWL.RLock()
+	pending, resumed := drv.pendingCreations[name]
+	drv.volumesRWL.RUnlock()
+	if resumed && rsdEndpointOf(ctx) == "" {
+		return withRack(ctx, drv.findRack(pending.rack)), nil
+	}
+	return drv.selectRack(ctx, provisioning)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddRack(t *testing.T) {
+	drv := &Driver{}
+	if err := drv.AddRack("rack2", &TestClient{}); err != nil {
+		t.Fatalf("AddRack() failed: %v", err)
+	}
+	for _, id := range []string{"", DefaultRack, "rack2", "rack:3", "rack@3", "rack3=url"} {
+		if err := drv.AddRack(id, &TestClient{}); err == nil {
+			t.Errorf("AddRack(%q) unexpected success", id)
+		}
+	}
+	if err := drv.AddRack("rack3", nil); err == nil {
+		t.Error("AddRack() unexpected success with nil client")
+	}
+
+	if r, id := drv.splitRackID("rack2:1"); r == nil || r.id != "rack2" || id != "1" {
+		t.Errorf("splitRackID(rack2:1) = %v, %q, want rack2, 1", r, id)
+	}
+	if r, id := drv.splitRackID("rack3:1"); r != nil || id != "rack3:1" {
+		t.Errorf("splitRackID(rack3:1) = %v, %q, want ID of the default rack", r, id)
+	}
+}
+
+// rackClient returns RSD of a rack with a storage service with the
+// available capacity and a volume created in it
+func rackClient(capacity string) *TestClient {
+	return &TestClient{
+		results: map[string]string{
+			"/redfish/v1/StorageServices":                  `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+			"/redfish/v1/StorageServices/1":                `{"Id": "1", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}, "StoragePools": {"@odata.id": "/redfish/v1/StorageServices/1/StoragePools"}}`,
+			"/redfish/v1/StorageServices/1/Volumes":        `{"Members": []}`,
+			"/redfish/v1/StorageServices/1/Volumes/1":      `{"Id": "1", "@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "CapacityBytes": 100}`,
+			"/redfish/v1/StorageServices/1/StoragePools":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1"}]}`,
+			"/redfish/v1/StorageServices/1/StoragePools/1": `{"Capacity": {"Data": {"GuaranteedBytes": ` + capacity + `}}}`,
+		},
+	}
+}
+
+func TestRackVolume(t *testing.T) {
+	drv := &Driver{
+		// the default RSD endpoint fails all deletions
+		rsdClient: &TestClient{
+			results:   rackClient("1000").results,
+			deleteErr: &rsd.HTTPError{StatusCode: http.StatusInternalServerError},
+		},
+		volumes: map[string]*Volume{},
+	}
+	if err := drv.AddRack("rack2", rackClient("2000")); err != nil {
+		t.Fatalf("AddRack() failed: %v", err)
+	}
+	capabilities := []*csi.VolumeCapability{{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+
+	resp, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "rack2-volume",
+		VolumeCapabilities: capabilities,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 50, LimitBytes: 100},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() failed: %v", err)
+	}
+	if resp.Volume.VolumeId != "rack2:1" {
+		t.Errorf("volume ID = %q, want rack2:1 of the rack with the most available capacity", resp.Volume.VolumeId)
+	}
+
+	_, err = drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "rack3-volume",
+		VolumeCapabilities: capabilities,
+		Parameters:         map[string]string{RackParameter: "rack3"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() in unknown rack error = %v, want InvalidArgument", err)
+	}
+
+	// RSD of the rack can't attach the volume to the node of the default rack
+	_, err = drv.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "rack2:1",
+		NodeId:           "1",
+		VolumeCapability: capabilities[0],
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ControllerPublishVolume() to node of other rack error = %v, want FailedPrecondition", err)
+	}
+
+	// the volume is deleted with the client of its rack
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "rack2:1"}); err != nil {
+		t.Fatalf("DeleteVolume() failed: %v", err)
+	}
+	if _, exists := drv.volumes["rack2-volume"]; exists {
+		t.Error("deleted volume is kept")
+	}
+}
+
+func TestGetCapacityRacks(t *testing.T) {
+	drv := &Driver{rsdClient: rackClient("1000")}
+	if err := drv.AddRack("rack2", rackClient("2000")); err != nil {
+		t.Fatalf("AddRack() failed: %v", err)
+	}
+	tests := []struct {
+		parameters map[string]string
+		want       int64
+	}{
+		{parameters: nil, want: 3000},
+		{parameters: map[string]string{RackParameter: DefaultRack}, want: 1000},
+		{parameters: map[string]string{RackParameter: "rack2"}, want: 2000},
+	}
+	for _, tt := range tests {
+		resp, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: tt.parameters})
+		if err != nil {
+			t.Fatalf("GetCapacity(%v) unexpected error: %v", tt.parameters, err)
+		}
+		if resp.AvailableCapacity != tt.want {
+			t.Errorf("GetCapacity(%v) = %d, want %d", tt.parameters, resp.AvailableCapacity, tt.want)
+		}
+	}
+	if _, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{Parameters: map[string]string{RackParameter: "rack3"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetCapacity() of unknown rack error = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// Kinds of the drift between the volume records and RSD found by the reconciliation
+const (
+	// driftDeleted is a volume whose RSD volume is deleted out of band
+	driftDeleted = "deleted"
+	// driftDetached is a published volume which is not attached to its RSD node
+	driftDetached = "detached"
+	// driftReattached is a detached volume attached to its RSD node again
+	driftReattached = "reattached"
+)
+
+// SetReconciliation sets the interval of reconciling the volume records with
+// RSD in the background of Run, the reconciliation is disabled if it's 0.
+// repair makes the reconciliation attach the published volumes to their RSD
+// nodes again if they are detached out of band.
+func (drv *Driver) SetReconciliation(interval time.Duration, repair bool) {
+	drv.reconcileInterval = interval
+	drv.reconcileRepair = repair
+}
+
+// reconciledVolume is the state of the volume record the reconciliation is based on
+type reconciledVolume struct {
+	rack      string
+	odataID   string
+	rsdNodeID string
+}
+
+// reconcileVolumes lists RSD volumes and the volumes attached to the RSD nodes
+// of the published volumes and updates the volume records: records of the RSD
+// volumes deleted out of band are removed unless the volume is in use on the
+// node, detached volumes are attached again or marked as not published.
+func (drv *Driver) reconcileVolumes() {
+	// query RSD without holding the lock
+	drv.volumesRWL.RLock()
+	known := map[string]reconciledVolume{}
+	for name, volume := range drv.volumes {
+		// volumes of the RSD endpoints from the secrets are not listed
+		if volume.RSDEndpoint != "" {
+			continue
+		}
+		state := reconciledVolume{rack: volume.Rack, odataID: volume.RSDVolume.OdataID}
+		if volume.IsPublished && !drv.fabricDirect {
+			state.rsdNodeID = volume.RSDNodeID
+		}
+		known[name] = state
+	}
+	drv.volumesRWL.RUnlock()
+
+	// only @odata.id of the RSD volumes is kept, RSD may have thousands of
+	// them. Volumes of the racks which can't be listed are not reconciled.
+	existing := map[string]map[string]bool{}
+	for _, ctx := range drv.allRackContexts(context.Background()) {
+		rack := rackOf(ctx)
+		volumes := map[string]bool{}
+		err := drv.forEachRSDVolume(ctx, func(rsdVolume *rsd.Volume) error {
+			volumes[rsdVolume.OdataID] = true
+			return nil
+		})
+		if err != nil {
+			drv.logger.Error(err, "reconcile: can't get RSD volumes", "rack", describeRack(rack))
+			continue
+		}
+		existing[rack] = volumes
+	}
+
+	// attachments maps RSD node IDs to the volumes attached to them,
+	// nodes which can't be queried are missing
+	attachments := map[string]map[string]bool{}
+	for _, state := range known {
+		if state.rsdNodeID == "" {
+			continue
+		}
+		if _, queried := attachments[state.rsdNodeID]; queried {
+			continue
+		}
+		attached, err := drv.nodeAttachments(state.rsdNodeID)
+		if err != nil {
+			drv.logger.Error(err, "reconcile: can't get volumes attached to the RSD node", "node_id", state.rsdNodeID)
+			continue
+		}
+		attachments[state.rsdNodeID] = attached
+	}
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	for name, state := range known {
+		volume, exists := drv.volumes[name]
+		// skip volumes deleted or recreated during the reconciliation,
+		// and volumes with operations in progress, e.g. being detached
+		if !exists || volume.RSDVolume.OdataID != state.odataID || drv.volumeLocks.isLocked(volume.CSIVolume.VolumeId) {
+			continue
+		}
+
+		rackVolumes, listed := existing[state.rack]
+		if !listed {
+			continue
+		}
+		if !rackVolumes[state.odataID] {
+			drv.metrics.volumeDrift.Inc(driftDeleted)
+			if volume.IsStaged || len(volume.TargetPaths) > 0 {
+				drv.logger.Warning("reconcile: RSD volume is deleted out of band, the volume is kept while it's in use on the node", "volume", name, "rsd_volume", volume.RSDVolume.ID)
+				continue
+			}
+			drv.logger.Warning("reconcile: RSD volume is deleted out of band, forgetting the volume", "volume", name, "rsd_volume", volume.RSDVolume.ID)
+			delete(drv.volumes, name)
+			drv.allocations.remove(volume.CSIVolume.VolumeId)
+			drv.metrics.volumeCapacityShrunk.Delete(volume.CSIVolume.VolumeId)
+			continue
+		}
+
+		attached, queried := attachments[state.rsdNodeID]
+		if !queried || attached[state.odataID] || !volume.IsPublished || volume.RSDNodeID != state.rsdNodeID {
+			continue
+		}
+		drv.metrics.volumeDrift.Inc(driftDetached)
+		if drv.reconcileRepair {
+			err := drv.reattachVolume(volume)
+			if err == nil {
+				drv.metrics.volumeDrift.Inc(driftReattached)
+				drv.logger.V(LogLevelState).Info("reconcile: volume detached out of band is attached to the RSD node again", "volume", name, "node_id", volume.RSDNodeID)
+				continue
+			}
+			drv.logger.Warning("reconcile: can't attach volume to the RSD node again", "volume", name, "node_id", volume.RSDNodeID, "error", err)
+		}
+		drv.logger.Warning("reconcile: volume is detached from the RSD node out of band, marking it as not published", "volume", name, "node_id", volume.RSDNodeID)
+		volume.IsPublished = false
+	}
+}
+
+// nodeAttachments queries the RSD node and returns @odata.id of the resources attached to it
+func (drv *Driver) nodeAttachments(rsdNodeID string) (map[string]bool, error) {
+	node, err := drv.getNode(context.Background(), rsdNodeID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, _ := drv.withNodeRack(context.Background(), rsdNodeID)
+	var current rsd.Node
+	if err := rsd.GetByOdataID(ctx, drv.client(ctx), node.OdataID, &current); err != nil {
+		drv.invalidateNode(rsdNodeID, err)
+		return nil, err
+	}
+	result := map[string]bool{}
+	for _, storage := range current.Links.Storage {
+		result[storage.OdataID] = true
+	}
+	return result, nil
+}
+
+// reattachVolume attaches the published volume to its RSD node again with the
+// attach options of the volume context. It must be called with drv.volumesRWL locked.
+func (drv *Driver) reattachVolume(volume *Volume) error {
+	opts, err := attachOptions(volume.CSIVolume.VolumeContext)
+	if err != nil {
+		drv.logger.Warning("attach options of the volume are not applied", "volume", volume.Name, "error", err)
+		opts = nil
+	}
+	_, err = drv.attachToNode(withVolumeRSDEndpoint(context.Background(), volume), volume, volume.RSDNodeID, opts)
+	drv.observeAttachment(operationAttach, volume.RSDNodeID, err)
+	return err
+}
+
+// ReconcileVolumes periodically reconciles the volume records with RSD until stop is closed
+func (drv *Driver) ReconcileVolumes(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			drv.reconcileVolumes()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestReconcileVolumes(t *testing.T) {
+	newVolume := func(name, id string) *Volume {
+		return &Volume{
+			Name:        name,
+			CSIVolume:   &csi.Volume{VolumeId: id},
+			RSDVolume:   &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/" + id, ID: id},
+			TargetPaths: map[string]bool{},
+		}
+	}
+	newDriver := func(repair bool) *Driver {
+		drv := &Driver{
+			metrics: newDriverMetrics(),
+			rsdClient: &TestClient{
+				results: map[string]string{
+					"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+					"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+					"/redfish/v1/StorageServices/1/Volumes":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}, {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/4"}]}`,
+					"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1"}`,
+					"/redfish/v1/StorageServices/1/Volumes/4": `{"Id": "4"}`,
+					"/redfish/v1/Nodes":                       `{"Members": [{"@odata.id": "/redfish/v1/Nodes/1"}]}`,
+					"/redfish/v1/Nodes/1": `{
+						"@odata.id": "/redfish/v1/Nodes/1",
+						"Id": "1",
+						"Links": {
+							"ComputerSystem": {"@odata.id": "/redfish/v1/Systems/1"},
+							"Storage": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]
+						},
+						"Actions": {
+							"#ComposedNode.AttachResource": {
+								"target": "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource",
+								"@Redfish.ActionInfo": "/redfish/v1/Nodes/1/Actions/AttachResourceActionInfo"
+							}
+						}
+					}`,
+					"/redfish/v1/Nodes/1/Actions/AttachResourceActionInfo": `{"Parameters": [{"Name": "Resource", "AllowableValues": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/4"}]}]}`,
+					"/redfish/v1/Systems/1":                                `{"Links": {"Endpoints": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1"}]}}`,
+					"/redfish/v1/Fabrics/1/Endpoints/1":                    `{"Identifiers": [{"DurableNameFormat": "NQN", "DurableName": "nqn.2014-08.org.nvmexpress:uuid:node"}]}`,
+				},
+			},
+			volumes: map[string]*Volume{
+				"pvc-1": newVolume("pvc-1", "1"),
+				"pvc-2": newVolume("pvc-2", "2"),
+				"pvc-3": newVolume("pvc-3", "3"),
+				"pvc-4": newVolume("pvc-4", "4"),
+			},
+		}
+		drv.SetReconciliation(0, repair)
+		for _, name := range []string{"pvc-1", "pvc-4"} {
+			drv.volumes[name].IsPublished = true
+			drv.volumes[name].RSDNodeID = "1"
+		}
+		drv.volumes["pvc-3"].IsStaged = true
+		return drv
+	}
+
+	drv := newDriver(false)
+	drv.reconcileVolumes()
+
+	if _, exists := drv.volumes["pvc-2"]; exists {
+		t.Error("volume deleted out of band is not removed")
+	}
+	if _, exists := drv.volumes["pvc-3"]; !exists {
+		t.Error("staged volume deleted out of band is removed")
+	}
+	if !drv.volumes["pvc-1"].IsPublished {
+		t.Error("attached volume is not published")
+	}
+	if drv.volumes["pvc-4"].IsPublished {
+		t.Error("detached volume is published")
+	}
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+	for _, line := range []string{
+		`csi_rsd_volume_drift_total{drift="deleted"} 2`,
+		`csi_rsd_volume_drift_total{drift="detached"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+		}
+	}
+
+	drv = newDriver(true)
+	drv.reconcileVolumes()
+	if volume := drv.volumes["pvc-4"]; !volume.IsPublished || volume.RSDNodeID != "1" {
+		t.Errorf("detached volume is not attached again: published %v, node %q", volume.IsPublished, volume.RSDNodeID)
+	}
+}
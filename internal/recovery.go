@@ -0,0 +1,73 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+)
+
+// recoverStaged re-derives staged state of the volume lost on the driver
+// restart: the volume is staged if its device, found by the subsystem NQN
+// from the publish context, is mounted to the staging path. Block volumes
+// aren't mounted when staged, so their device only has to be connected.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) recoverStaged(volume *Volume, stagingTargetPath string, publishContext map[string]string, block bool) error {
+	if volume.IsStaged {
+		return fmt.Errorf("volume is staged to %s", volume.StagingTargetPath)
+	}
+
+	nqn := publishContext[PublishInfoSubsystemNQN]
+	if nqn == "" && volume.EndPoint != nil {
+		nqn = volume.EndPoint.NQN
+	}
+	if nqn == "" {
+		return fmt.Errorf("subsystem NQN of the volume is not in the publish context")
+	}
+
+	devices, err := drv.nvme.List()
+	if err != nil {
+		return fmt.Errorf("can't list NVMe devices: %v", err)
+	}
+	device := ""
+	for dev, subnqn := range devices {
+		if subnqn == nqn {
+			device = dev
+			break
+		}
+	}
+	if device == "" {
+		return fmt.Errorf("no NVMe device of the subsystem %s is connected", nqn)
+	}
+
+	if !block {
+		mounted, err := drv.mounter.IsMounted(device, stagingTargetPath)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			return fmt.Errorf("device %s of the subsystem %s is not mounted to %s", device, nqn, stagingTargetPath)
+		}
+	}
+
+	volume.Device = device
+	volume.IsBlock = block
+	volume.StagingTargetPath = stagingTargetPath
+	volume.IsStaged = true
+	if volume.TargetPaths == nil {
+		volume.TargetPaths = map[string]bool{}
+	}
+	drv.logger.V(LogLevelState).Info("recovered staging of the volume", "volume", volume.Name, "device", device, "staging_target_path", stagingTargetPath)
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountedMounter reports the source mounted only to the given target
+type mountedMounter struct {
+	testMounter
+	mounts map[string]string
+}
+
+func (m *mountedMounter) IsMounted(source, target string) (bool, error) {
+	mounted, ok := m.mounts[target]
+	return ok && (source == "" || source == mounted), nil
+}
+
+func TestNodePublishRecoversStaging(t *testing.T) {
+	tests := []struct {
+		name           string
+		publishContext map[string]string
+		mounts         map[string]string
+		wantCode       codes.Code
+		wantMessage    string
+	}{
+		{
+			name:           "recovered",
+			publishContext: map[string]string{PublishInfoSubsystemNQN: "nqn.2014-08.org.nvmexpress:uuid:1"},
+			mounts:         map[string]string{"/staging": "/dev/nvme1n1"},
+			wantCode:       codes.OK,
+		},
+		{
+			name:        "no NQN",
+			mounts:      map[string]string{"/staging": "/dev/nvme1n1"},
+			wantCode:    codes.FailedPrecondition,
+			wantMessage: "not in the publish context",
+		},
+		{
+			name:           "device not connected",
+			publishContext: map[string]string{PublishInfoSubsystemNQN: "nqn.2014-08.org.nvmexpress:uuid:2"},
+			mounts:         map[string]string{"/staging": "/dev/nvme1n1"},
+			wantCode:       codes.FailedPrecondition,
+			wantMessage:    "no NVMe device of the subsystem",
+		},
+		{
+			name:           "staging path not mounted",
+			publishContext: map[string]string{PublishInfoSubsystemNQN: "nqn.2014-08.org.nvmexpress:uuid:1"},
+			mounts:         map[string]string{"/other": "/dev/nvme1n1"},
+			wantCode:       codes.FailedPrecondition,
+			wantMessage:    "is not mounted to /staging",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{
+				nvme:    &testNVMe{},
+				mounter: &mountedMounter{mounts: tt.mounts},
+				volumes: map[string]*Volume{
+					"pvc-1": {Name: "pvc-1", CSIVolume: &csi.Volume{VolumeId: "1"}},
+				},
+			}
+			if err := drv.SetCSICompat("1.2"); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:          "1",
+				PublishContext:    tt.publishContext,
+				StagingTargetPath: "/staging",
+				TargetPath:        "/target",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("NodePublishVolume() returned %v, want %v: %v", code, tt.wantCode, err)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.wantMessage) || !strings.Contains(err.Error(), "staged again") {
+					t.Errorf("NodePublishVolume() error %q doesn't tell %q and to stage again", err, tt.wantMessage)
+				}
+				return
+			}
+
+			vol := drv.volumes["pvc-1"]
+			if !vol.IsStaged || vol.StagingTargetPath != "/staging" || vol.Device != "/dev/nvme1n1" || !vol.TargetPaths["/target"] {
+				t.Errorf("volume is not recovered: %+v", vol)
+			}
+		})
+	}
+}
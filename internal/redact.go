@@ -0,0 +1,50 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// secretsField is a name of the CSI request field containing secrets
+const secretsField = "Secrets"
+
+// redactRequest returns a copy of the CSI request with secret values
+// replaced, so it can be logged
+func redactRequest(req proto.Message) proto.Message {
+	if req == nil || !rsd.RedactionEnabled() {
+		return req
+	}
+
+	value := reflect.ValueOf(req)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return req
+	}
+	secrets := value.Elem().FieldByName(secretsField)
+	if !secrets.IsValid() || secrets.Kind() != reflect.Map || secrets.Len() == 0 {
+		return req
+	}
+
+	redacted := proto.Clone(req)
+	redactedSecrets := map[string]string{}
+	for _, key := range secrets.MapKeys() {
+		redactedSecrets[key.String()] = rsd.RedactedValue
+	}
+	reflect.ValueOf(redacted).Elem().FieldByName(secretsField).Set(reflect.ValueOf(redactedSecrets))
+	return redacted
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestRedactRequest(t *testing.T) {
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId: "1",
+		Secrets:  map[string]string{"password": "s3cret"},
+	}
+
+	text := fmt.Sprintf("%v", redactRequest(req))
+	if strings.Contains(text, "s3cret") || !strings.Contains(text, "password") {
+		t.Errorf("secrets are not redacted: %s", text)
+	}
+	if req.Secrets["password"] != "s3cret" {
+		t.Errorf("original request has been modified: %v", req)
+	}
+
+	var nilReq *csi.CreateVolumeRequest
+	if got := redactRequest(nilReq); got != nilReq {
+		t.Errorf("redactRequest(nil) = %v", got)
+	}
+
+	noSecrets := &csi.ProbeRequest{}
+	if got := redactRequest(noSecrets); got != noSecrets {
+		t.Errorf("request without secrets has been copied")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// ReformatPolicyParameter is a StorageClass parameter governing staging of
+	// volumes which have a filesystem of another type than the requested one
+	ReformatPolicyParameter = "reformatPolicy"
+
+	// ReformatNever keeps the existing filesystem and mounts it with its own type
+	ReformatNever = "never"
+	// ReformatIfEmpty reformats the existing filesystem with the requested
+	// type if it's verifiably empty and fails staging otherwise
+	ReformatIfEmpty = "if-empty"
+	// ReformatAlwaysMatch fails staging unless the existing filesystem has
+	// the requested type, it's the default
+	ReformatAlwaysMatch = "always-match"
+)
+
+// ReformatPolicies returns the supported values of ReformatPolicyParameter
+func ReformatPolicies() []string {
+	return []string{ReformatNever, ReformatIfEmpty, ReformatAlwaysMatch}
+}
+
+// reformatContext validates the reformat policy in the CreateVolume parameters
+// and returns it to be stored in the volume context
+func reformatContext(parameters map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	value, exists := parameters[ReformatPolicyParameter]
+	if !exists {
+		return result, nil
+	}
+	for _, policy := range ReformatPolicies() {
+		if value == policy {
+			result[ReformatPolicyParameter] = value
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("parameter %s: unknown policy %q, supported policies are %v", ReformatPolicyParameter, value, ReformatPolicies())
+}
+
+// filesystemMismatchError is returned when the volume can't be staged with
+// the requested filesystem type under its reformat policy
+type filesystemMismatchError struct {
+	device    string
+	existing  string
+	requested string
+	reason    string
+}
+
+func (e *filesystemMismatchError) Error() string {
+	existing := e.existing
+	if existing == "" {
+		existing = "a partition table"
+	}
+	return fmt.Sprintf("device %s has %s instead of %s filesystem: %s", e.device, existing, e.requested, e.reason)
+}
+
+// stagedFilesystem applies the reformat policy of the volume to the formatted
+// device and returns the type of the filesystem to mount it with.
+// The existing filesystem is reformatted with fsType, label and mkfsOpts if the policy allows it.
+func (drv *Driver) stagedFilesystem(ctx context.Context, volume *Volume, reformatPolicy, dev, fsType, label string, mkfsOpts []string, stagingTargetPath string) (string, error) {
+	existing, err := drv.mounter.GetFilesystemType(dev)
+	if err != nil {
+		return "", err
+	}
+	if existing == fsType {
+		return fsType, nil
+	}
+	mismatch := &filesystemMismatchError{device: dev, existing: existing, requested: fsType}
+	if existing == "" {
+		// a partition table is neither mountable nor verifiably empty
+		mismatch.reason = "partitioned devices are not supported"
+		return "", mismatch
+	}
+
+	switch reformatPolicy {
+	case ReformatNever:
+		drv.logger.Warning("existing filesystem is kept", "volume", volume.Name, "filesystem", existing, "requested_filesystem", fsType)
+		return existing, nil
+	case ReformatIfEmpty:
+		empty, err := drv.mounter.IsFilesystemEmpty(dev, existing, stagingTargetPath)
+		if err != nil {
+			return "", err
+		}
+		if !empty {
+			mismatch.reason = fmt.Sprintf("the filesystem is not empty, it's not reformatted by %s=%s", ReformatPolicyParameter, ReformatIfEmpty)
+			return "", mismatch
+		}
+		if err := drv.checkFencing(ctx, volume); err != nil {
+			return "", err
+		}
+		if err := drv.formatVolume(volume, dev, fsType, label, mkfsOpts); err != nil {
+			return "", err
+		}
+		drv.logger.V(LogLevelState).Info("empty filesystem has been reformatted", "volume", volume.Name, "filesystem", existing, "requested_filesystem", fsType)
+		return fsType, nil
+	default:
+		mismatch.reason = fmt.Sprintf("set %s to %s or %s to keep or reformat it", ReformatPolicyParameter, ReformatNever, ReformatIfEmpty)
+		return "", mismatch
+	}
+}
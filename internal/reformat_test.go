@@ -0,0 +1,142 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reformatMounter simulates a device formatted with fsType and records the calls
+type reformatMounter struct {
+	testMounter
+	fsType string
+	empty  bool
+	calls  []string
+}
+
+func (m *reformatMounter) IsFormatted(source string) (bool, error) {
+	return true, nil
+}
+
+func (m *reformatMounter) GetFilesystemType(source string) (string, error) {
+	return m.fsType, nil
+}
+
+func (m *reformatMounter) IsFilesystemEmpty(source, fsType, target string) (bool, error) {
+	m.calls = append(m.calls, "check empty "+fsType)
+	return m.empty, nil
+}
+
+func (m *reformatMounter) Format(source, fsType, label string, opts ...string) error {
+	m.calls = append(m.calls, "format "+fsType)
+	return nil
+}
+
+func (m *reformatMounter) Mount(source, target, fsType string, opts ...string) error {
+	m.calls = append(m.calls, "mount "+fsType)
+	return nil
+}
+
+func TestReformatContext(t *testing.T) {
+	for _, policy := range ReformatPolicies() {
+		got, err := reformatContext(map[string]string{ReformatPolicyParameter: policy})
+		if err != nil {
+			t.Errorf("reformatContext(%s) unexpected error: %v", policy, err)
+		}
+		if got[ReformatPolicyParameter] != policy {
+			t.Errorf("reformatContext(%s) = %v", policy, got)
+		}
+	}
+	if got, err := reformatContext(map[string]string{}); err != nil || len(got) != 0 {
+		t.Errorf("reformatContext() without policy = %v, %v, want empty context", got, err)
+	}
+	if _, err := reformatContext(map[string]string{ReformatPolicyParameter: "sometimes"}); err == nil {
+		t.Error("reformatContext() accepted unknown policy")
+	}
+}
+
+func TestNodeStageVolumeReformatPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		existing  string
+		empty     bool
+		wantCalls []string
+		wantCode  codes.Code
+	}{
+		{name: "matching filesystem", existing: "ext4", wantCalls: []string{"mount ext4"}},
+		{name: "matching filesystem, never", policy: ReformatNever, existing: "ext4", wantCalls: []string{"mount ext4"}},
+		{name: "other filesystem, default", existing: "xfs", wantCode: codes.FailedPrecondition},
+		{name: "other filesystem, always-match", policy: ReformatAlwaysMatch, existing: "xfs", wantCode: codes.FailedPrecondition},
+		{name: "other filesystem, never", policy: ReformatNever, existing: "xfs", wantCalls: []string{"mount xfs"}},
+		{name: "other empty filesystem, if-empty", policy: ReformatIfEmpty, existing: "xfs", empty: true, wantCalls: []string{"check empty xfs", "format ext4", "mount ext4"}},
+		{name: "other filesystem with data, if-empty", policy: ReformatIfEmpty, existing: "xfs", wantCalls: []string{"check empty xfs"}, wantCode: codes.FailedPrecondition},
+		{name: "partition table, never", policy: ReformatNever, wantCode: codes.FailedPrecondition},
+		{name: "partition table, if-empty", policy: ReformatIfEmpty, wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &reformatMounter{fsType: tt.existing, empty: tt.empty}
+			drv := &Driver{
+				volumes: map[string]*Volume{
+					"Vol1": &Volume{
+						CSIVolume: &csi.Volume{VolumeId: "1"},
+						RSDVolume: &rsd.Volume{},
+						Name:      "Vol1",
+						EndPoint: &endpoint.Portal{
+							Transport: "rdma",
+							Address:   "192.168.1.1",
+							Port:      4420,
+							NQN:       "nqn.2014-08.org.nvmexpress:uuid:1",
+						},
+						IsPublished: true,
+					},
+				},
+				nvme:    &testNVMe{},
+				mounter: mounter,
+			}
+			req := &csi.NodeStageVolumeRequest{
+				VolumeId:          "1",
+				StagingTargetPath: "/mnt",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+				},
+				VolumeContext: map[string]string{},
+			}
+			if tt.policy != "" {
+				req.VolumeContext[ReformatPolicyParameter] = tt.policy
+			}
+
+			_, err := drv.NodeStageVolume(context.Background(), req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if strings.Join(mounter.calls, ", ") != strings.Join(tt.wantCalls, ", ") {
+				t.Errorf("mounter calls %q, want %q", mounter.calls, tt.wantCalls)
+			}
+			if staged := drv.volumes["Vol1"].IsStaged; staged != (err == nil) {
+				t.Errorf("volume staged = %v after error %v", staged, err)
+			}
+		})
+	}
+}
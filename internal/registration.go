@@ -0,0 +1,90 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const registrationDialTimeout = 5 * time.Second
+
+// registrationSocket returns path of the socket node-driver-registrar
+// creates in the kubelet plugin registration directory
+func registrationSocket(dir string) string {
+	return filepath.Join(dir, DriverName+"-reg.sock")
+}
+
+// checkRegistration checks that the registration socket exists and
+// there is a registrar listening on it
+func checkRegistration(sockPath string) error {
+	info, err := os.Stat(sockPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("registration socket %s doesn't exist: kubelet plugin registration directory has been wiped "+
+			"or node-driver-registrar is not running, restart the driver pod to register the driver again", sockPath)
+	}
+	if err != nil {
+		return fmt.Errorf("can't check registration socket %s: %v", sockPath, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("registration path %s is not a socket", sockPath)
+	}
+
+	conn, err := net.DialTimeout("unix", sockPath, registrationDialTimeout)
+	if err != nil {
+		return fmt.Errorf("registration socket %s is stale: %v, check node-driver-registrar container logs "+
+			"and restart the driver pod", sockPath, err)
+	}
+	conn.Close() // nolint: errcheck
+	return nil
+}
+
+// WatchRegistration periodically checks that the driver is registered with kubelet
+// through the registration socket in dir. Losing or restoring registration is logged
+// and reported by the csi_rsd_kubelet_registered metric. It returns when stop is closed.
+func (drv *Driver) WatchRegistration(dir string, interval time.Duration, stop <-chan struct{}) {
+	sockPath := registrationSocket(dir)
+	registered := true
+
+	// node-driver-registrar creates the socket after the driver starts serving,
+	// so the first check is done after the interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := checkRegistration(sockPath)
+		switch {
+		case err != nil && registered:
+			drv.logger.Warning("kubelet registration lost", "error", err)
+		case err == nil && !registered:
+			drv.logger.V(LogLevelState).Info("kubelet registration restored", "socket", sockPath)
+		}
+		registered = err == nil
+
+		if registered {
+			drv.metrics.kubeletRegistered.Set(1)
+		} else {
+			drv.metrics.kubeletRegistered.Set(0)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckRegistration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-registration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sockPath := registrationSocket(dir)
+
+	if err := checkRegistration(sockPath); err == nil {
+		t.Error("missing socket: unexpected success")
+	}
+
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRegistration(sockPath); err != nil {
+		t.Errorf("served socket: unexpected error: %v", err)
+	}
+
+	// closing unix listener removes the socket, recreate it as a stale file
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if err := checkRegistration(sockPath); err == nil {
+		t.Error("stale socket: unexpected success")
+	}
+
+	regularFile := filepath.Join(dir, "regular")
+	if err := ioutil.WriteFile(regularFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRegistration(regularFile); err == nil {
+		t.Error("regular file: unexpected success")
+	}
+}
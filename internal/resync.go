@@ -0,0 +1,96 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// refreshCapacity updates capacity of the CSI volume from the RSD volume,
+// as the volume can be resized or replaced out of band.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) refreshCapacity(volume *Volume) {
+	capacity := volume.RSDVolume.CapacityBytes
+	if capacity == volume.CSIVolume.CapacityBytes {
+		return
+	}
+
+	drv.logger.V(LogLevelState).Info("RSD capacity of the volume changed", "volume", volume.Name, "volume_id", volume.CSIVolume.VolumeId, "old_capacity", volume.CSIVolume.CapacityBytes, "capacity", capacity)
+	volume.CSIVolume.CapacityBytes = capacity
+
+	if capacity < volume.RequiredBytes {
+		drv.logger.Warning("RSD capacity of the volume is smaller than requested", "volume", volume.Name, "volume_id", volume.CSIVolume.VolumeId, "capacity", capacity, "required", volume.RequiredBytes)
+		drv.metrics.volumeCapacityShrunk.Set(1, volume.CSIVolume.VolumeId)
+	} else {
+		drv.metrics.volumeCapacityShrunk.Set(0, volume.CSIVolume.VolumeId)
+	}
+}
+
+// resyncVolumes reads all known volumes from RSD and refreshes their capacity
+// and endpoints
+func (drv *Driver) resyncVolumes() {
+	// query RSD without holding the lock
+	drv.volumesRWL.RLock()
+	odataIDs := map[string]string{}
+	clients := map[string]rsd.Transport{}
+	for name, volume := range drv.volumes {
+		odataIDs[name] = volume.RSDVolume.OdataID
+		clients[name] = drv.client(withVolumeRSDEndpoint(context.Background(), volume))
+	}
+	drv.volumesRWL.RUnlock()
+
+	rsdVolumes := map[string]*rsd.Volume{}
+	for name, odataID := range odataIDs {
+		var rsdVolume rsd.Volume
+		if err := rsd.GetByOdataID(context.Background(), clients[name], odataID, &rsdVolume); err != nil {
+			drv.logger.Warning("can't get RSD volume to resync", "volume", name, "error", err)
+			continue
+		}
+		rsdVolumes[name] = &rsdVolume
+	}
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	for name, rsdVolume := range rsdVolumes {
+		volume, exists := drv.volumes[name]
+		// skip volumes deleted or recreated during the resync
+		if !exists || volume.RSDVolume.OdataID != rsdVolume.OdataID {
+			continue
+		}
+		volume.RSDVolume.CapacityBytes = rsdVolume.CapacityBytes
+		// endpoints of the volume tell whether it's attached in RSD
+		volume.RSDVolume.Links = rsdVolume.Links
+		volume.RSDVolume.Operations = rsdVolume.Operations
+		drv.refreshCapacity(volume)
+		drv.observeVolumeProgress(context.Background(), volume)
+	}
+}
+
+// ResyncVolumes periodically refreshes volumes from RSD until stop is closed
+func (drv *Driver) ResyncVolumes(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			drv.resyncVolumes()
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestResyncVolumes(t *testing.T) {
+	drv := &Driver{
+		metrics: newDriverMetrics(),
+		rsdClient: &TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices/1/Volumes/1": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 50}`,
+				"/redfish/v1/StorageServices/1/Volumes/2": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2", "Id": "2", "CapacityBytes": 200}`,
+			},
+		},
+		volumes: map[string]*Volume{
+			"pvc-1": &Volume{
+				Name:          "pvc-1",
+				CSIVolume:     &csi.Volume{VolumeId: "1", CapacityBytes: 100},
+				RSDVolume:     &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/1", ID: "1", CapacityBytes: 100},
+				RequiredBytes: 100,
+			},
+			"pvc-2": &Volume{
+				Name:          "pvc-2",
+				CSIVolume:     &csi.Volume{VolumeId: "2", CapacityBytes: 100},
+				RSDVolume:     &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/2", ID: "2", CapacityBytes: 100},
+				RequiredBytes: 100,
+			},
+		},
+	}
+
+	drv.resyncVolumes()
+
+	for name, want := range map[string]int64{"pvc-1": 50, "pvc-2": 200} {
+		if got := drv.volumes[name].CSIVolume.CapacityBytes; got != want {
+			t.Errorf("volume %s capacity = %d, want %d", name, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	drv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	got := rec.Body.String()
+	for _, line := range []string{
+		`csi_rsd_volume_capacity_shrunk{volume_id="1"} 1`,
+		`csi_rsd_volume_capacity_shrunk{volume_id="2"} 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, got)
+		}
+	}
+}
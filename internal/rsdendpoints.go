@@ -0,0 +1,282 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// Keys of the CSI secrets selecting the RSD PodM the volume is managed by
+const (
+	// secretRSDEndpoint is the base URL of the PodM, e.g. https://podm.rack2:8443
+	secretRSDEndpoint = "rsdEndpoint"
+	// secretRSDUsername is the user name of the PodM
+	secretRSDUsername = "rsdUsername"
+	// secretRSDPassword is the password of the PodM user
+	secretRSDPassword = "rsdPassword"
+)
+
+// rsdEndpointSecrets are the RSD endpoint and credentials from the CSI secrets
+type rsdEndpointSecrets struct {
+	url      string
+	username string
+	password string
+}
+
+// parseRSDEndpointSecrets validates the RSD endpoint of the CSI secrets, the
+// endpoint is empty if the secrets don't set it. Errors never contain secret values.
+func parseRSDEndpointSecrets(secrets map[string]string) (rsdEndpointSecrets, error) {
+	result := rsdEndpointSecrets{
+		url:      strings.TrimSuffix(secrets[secretRSDEndpoint], "/"),
+		username: secrets[secretRSDUsername],
+		password: secrets[secretRSDPassword],
+	}
+	if result.url == "" {
+		if result.username != "" || result.password != "" {
+			return result, errors.New(secretRSDUsername + " and " + secretRSDPassword + " secrets require " + secretRSDEndpoint + " secret")
+		}
+		return result, nil
+	}
+	parsed, err := url.Parse(result.url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+		return result, errors.New(secretRSDEndpoint + " secret must be an http or https URL without credentials")
+	}
+	return result, nil
+}
+
+// RSDClientFactory creates the client of the RSD endpoint set in the CSI
+// secrets, e.g. with the TLS configuration and policies of the default client
+type RSDClientFactory func(baseurl, username, password string) (rsd.Transport, error)
+
+// pooledRSDClient is the client of the RSD endpoint with the hash of the
+// credentials it was created with
+type pooledRSDClient struct {
+	client      rsd.Transport
+	credentials [sha256.Size]byte
+}
+
+// rsdClientPool keeps the clients of the RSD endpoints set in the CSI
+// secrets keyed by the endpoint URL. The client is replaced when the
+// credentials of its endpoint change, e.g. they are rotated.
+type rsdClientPool struct {
+	mu      sync.Mutex
+	factory RSDClientFactory
+	clients map[string]*pooledRSDClient
+}
+
+// get returns the client of the endpoint, it's created by the factory if
+// the pool doesn't have it yet or its credentials changed
+func (pool *rsdClientPool) get(endpoint rsdEndpointSecrets) (rsd.Transport, error) {
+	credentials := sha256.Sum256([]byte(endpoint.username + "\x00" + endpoint.password))
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pooled, exists := pool.clients[endpoint.url]; exists && pooled.credentials == credentials {
+		return pooled.client, nil
+	}
+	client, err := pool.factory(endpoint.url, endpoint.username, endpoint.password)
+	if err != nil {
+		return nil, fmt.Errorf("can't create client of the RSD endpoint %s: %v", endpoint.url, err)
+	}
+	// the replaced client may be still used by requests in progress, it's
+	// left to the garbage collector
+	pool.clients[endpoint.url] = &pooledRSDClient{client: client, credentials: credentials}
+	return client, nil
+}
+
+// close closes the clients of the pool
+func (pool *rsdClientPool) close() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var result error
+	for endpoint, pooled := range pool.clients {
+		if closer, ok := pooled.client.(io.Closer); ok {
+			if err := closer.Close(); err != nil && result == nil {
+				result = err
+			}
+		}
+		delete(pool.clients, endpoint)
+	}
+	return result
+}
+
+// SetRSDClientFactory makes the driver manage the volumes of the RSD endpoints
+// set in the CSI secrets of their requests with the clients created by the
+// factory. RSD endpoints in the secrets are rejected if it's not set.
+func (drv *Driver) SetRSDClientFactory(factory RSDClientFactory) {
+	drv.rsdClients = nil
+	if factory != nil {
+		drv.rsdClients = &rsdClientPool{factory: factory, clients: map[string]*pooledRSDClient{}}
+	}
+}
+
+// rsdEndpointKey is the context key of the RSD endpoint of the request
+type rsdEndpointKey struct{}
+
+// requestRSDEndpoint is the RSD endpoint and its client the request is served
+// with, the endpoint is either from the CSI secrets or of a rack
+type requestRSDEndpoint struct {
+	url    string
+	rack   string
+	client rsd.Transport
+}
+
+// withRSDSecrets returns the context of the request to be served with the
+// client of the RSD endpoint set in the CSI secrets, the context is returned
+// unchanged if the secrets don't set it
+func (drv *Driver) withRSDSecrets(ctx context.Context, secrets map[string]string) (context.Context, error) {
+	endpoint, err := parseRSDEndpointSecrets(secrets)
+	if err != nil || endpoint.url == "" {
+		return ctx, err
+	}
+	if drv.rsdClients == nil {
+		return ctx, errors.New(secretRSDEndpoint + " secret is set, but RSD endpoints from the secrets are not enabled")
+	}
+	client, err := drv.rsdClients.get(endpoint)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, rsdEndpointKey{}, &requestRSDEndpoint{url: endpoint.url, client: client}), nil
+}
+
+// withVolumeRSDEndpoint returns the context of the request to be served with
+// the client of the volume RSD endpoint, unless the request sets its own one
+func withVolumeRSDEndpoint(ctx context.Context, volume *Volume) context.Context {
+	if volume.rsdClient == nil || rsdEndpointOf(ctx) != "" {
+		return ctx
+	}
+	return context.WithValue(ctx, rsdEndpointKey{}, &requestRSDEndpoint{url: volume.RSDEndpoint, rack: volume.Rack, client: volume.rsdClient})
+}
+
+// rsdEndpointOf returns URL of the RSD endpoint of the request, empty for the default one
+func rsdEndpointOf(ctx context.Context) string {
+	if endpoint, ok := ctx.Value(rsdEndpointKey{}).(*requestRSDEndpoint); ok {
+		return endpoint.url
+	}
+	return ""
+}
+
+// client returns the RSD client the request is served with
+func (drv *Driver) client(ctx context.Context) rsd.Transport {
+	if endpoint, ok := ctx.Value(rsdEndpointKey{}).(*requestRSDEndpoint); ok {
+		return endpoint.client
+	}
+	return drv.rsdClient
+}
+
+// endpointVolumeID returns the CSI volume ID of the RSD volume of the
+// endpoint, RSD volume IDs of different endpoints may be the same
+func endpointVolumeID(rsdVolumeID, endpoint string) string {
+	if endpoint == "" {
+		return rsdVolumeID
+	}
+	hash := sha256.Sum256([]byte(endpoint))
+	return rsdVolumeID + "@" + hex.EncodeToString(hash[:4])
+}
+
+// setRSDEndpoint binds the volume record to the RSD endpoint of the request,
+// the default one keeps the record unchanged
+func (volume *Volume) setRSDEndpoint(ctx context.Context) {
+	endpoint, ok := ctx.Value(rsdEndpointKey{}).(*requestRSDEndpoint)
+	if !ok {
+		return
+	}
+	volume.RSDEndpoint = endpoint.url
+	volume.Rack = endpoint.rack
+	volume.rsdClient = endpoint.client
+	if endpoint.rack != "" {
+		volume.CSIVolume.VolumeId = rackID(endpoint.rack, volume.RSDVolume.ID)
+	} else {
+		volume.CSIVolume.VolumeId = endpointVolumeID(volume.RSDVolume.ID, endpoint.url)
+	}
+}
+
+// rsdEndpointMismatchError is returned for the requests with the RSD endpoint
+// in the secrets other than the one of the volume
+type rsdEndpointMismatchError struct {
+	volume string
+}
+
+func (err *rsdEndpointMismatchError) Error() string {
+	return fmt.Sprintf("volume %s is not managed by the RSD endpoint of the secrets", err.volume)
+}
+
+// volumeRSDContext returns the context of the request to the volume served
+// with the client of the volume RSD endpoint. The RSD endpoint from the
+// secrets must be the volume one, its client replaces the volume one, e.g.
+// with rotated credentials. It must be called with a copy of the volume
+// record or drv.volumesRWL locked.
+func volumeRSDContext(ctx context.Context, volume *Volume) (context.Context, error) {
+	endpoint, ok := ctx.Value(rsdEndpointKey{}).(*requestRSDEndpoint)
+	if !ok {
+		return withVolumeRSDEndpoint(ctx, volume), nil
+	}
+	if endpoint.url != volume.RSDEndpoint || endpoint.rack != volume.Rack {
+		return ctx, &rsdEndpointMismatchError{volume: volume.Name}
+	}
+	volume.rsdClient = endpoint.client
+	return ctx, nil
+}
+
+// adoptEndpointVolume adopts the volume of the RSD endpoint of the request
+// unknown to the driver, e.g. after the restart, as only the volumes of the
+// default RSD endpoint are adopted on start
+func (drv *Driver) adoptEndpointVolume(ctx context.Context, volumeID string) {
+	endpoint := rsdEndpointOf(ctx)
+	separator := strings.LastIndex(volumeID, "@")
+	if endpoint == "" || separator < 0 || endpointVolumeID(volumeID[:separator], endpoint) != volumeID {
+		return
+	}
+
+	drv.volumesRWL.RLock()
+	name, _ := drv.findVolByID(volumeID)
+	drv.volumesRWL.RUnlock()
+	if name != "" {
+		return
+	}
+
+	rsdVolumeID := volumeID[:separator]
+	err := drv.forEachRSDVolume(ctx, func(rsdVolume *rsd.Volume) error {
+		if rsdVolume.ID != rsdVolumeID {
+			return nil
+		}
+		name, ok := drv.volumeNameFromDescription(rsdVolume.Description)
+		if !ok {
+			return rsd.ErrStopIteration
+		}
+		drv.volumesRWL.Lock()
+		defer drv.volumesRWL.Unlock()
+		if _, exists := drv.volumes[name]; !exists {
+			volume := newVolumeRecord(name, rsdVolume)
+			volume.setRSDEndpoint(ctx)
+			drv.volumes[name] = volume
+			drv.allocations.adopted(volume)
+			drv.logger.V(LogLevelState).Info("adopted RSD volume", "rsd_volume", rsdVolume.ID, "volume", name, "rsd_endpoint", endpoint)
+		}
+		return rsd.ErrStopIteration
+	})
+	if err != nil {
+		drv.logger.Warning("can't adopt RSD volume", "volume_id", volumeID, "rsd_endpoint", endpoint, "error", err)
+	}
+}
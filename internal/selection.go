@@ -0,0 +1,194 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"golang.org/x/net/context"
+)
+
+// Policies of selecting the portal of the volume exposed by multiple endpoints
+const (
+	// EndPointSelectionFirst selects the first suitable portal reported by RSD
+	EndPointSelectionFirst = "first"
+	// EndPointSelectionLatency selects the portal with the lowest TCP connect latency
+	EndPointSelectionLatency = "latency"
+	// EndPointSelectionRoundRobin spreads the volumes across the portals
+	EndPointSelectionRoundRobin = "round-robin"
+	// EndPointSelectionPreferred selects the portal in the first of the preferred networks
+	EndPointSelectionPreferred = "preferred"
+)
+
+// latencyProbeTimeout limits probing latency of a portal
+const latencyProbeTimeout = 2 * time.Second
+
+// endPointSelector orders the portals of the volume, the first one is
+// connected and the others are tried if it's unreachable. Probing the
+// portals is logged by logger.
+type endPointSelector interface {
+	order(logger Logger, endPoints []*endpoint.Portal) []*endpoint.Portal
+}
+
+// EndPointSelectionPolicies returns the supported portal selection policies
+func EndPointSelectionPolicies() []string {
+	return []string{EndPointSelectionFirst, EndPointSelectionLatency, EndPointSelectionRoundRobin, EndPointSelectionPreferred}
+}
+
+// SetEndPointSelection sets the policy of selecting the portal of the volumes
+// exposed by multiple endpoints. preferred are IP addresses or CIDR networks
+// in the order of preference used by the EndPointSelectionPreferred policy.
+func (drv *Driver) SetEndPointSelection(policy string, preferred []string) error {
+	switch policy {
+	case EndPointSelectionFirst:
+		drv.endPointSelector = nil
+	case EndPointSelectionLatency:
+		drv.endPointSelector = &latencySelector{probe: checkPortal, timeout: latencyProbeTimeout}
+	case EndPointSelectionRoundRobin:
+		drv.endPointSelector = &roundRobinSelector{}
+	case EndPointSelectionPreferred:
+		selector, err := newPreferredSelector(preferred)
+		if err != nil {
+			return err
+		}
+		drv.endPointSelector = selector
+	default:
+		return fmt.Errorf("unsupported endpoint selection policy %q, supported policies: %v", policy, EndPointSelectionPolicies())
+	}
+	return nil
+}
+
+// selectEndPoints sets the portal of the volume and the alternative portals
+func (drv *Driver) selectEndPoints(ctx context.Context, volume *Volume, endPoints []*endpoint.Portal) {
+	logger := drv.requestLogger(ctx)
+	if drv.endPointSelector != nil && len(endPoints) > 1 {
+		endPoints = drv.orderEndPoints(logger, endPoints)
+	}
+	volume.EndPoint = endPoints[0]
+	volume.AltEndPoints = endPoints[1:]
+	logger.V(LogLevelState).Info("selected portal of the volume", "volume", volume.Name, "portal", endPoints[0].HostPort(), "portals", len(endPoints))
+}
+
+// orderEndPoints orders the portals with the selection policy. With the transport
+// preference the portals are ordered within each transport, so the policy
+// never selects a portal of a less preferred transport.
+func (drv *Driver) orderEndPoints(logger Logger, endPoints []*endpoint.Portal) []*endpoint.Portal {
+	if len(drv.transportPreference) == 0 {
+		return drv.endPointSelector.order(logger, endPoints)
+	}
+	var result []*endpoint.Portal
+	for _, transport := range drv.transportPreference {
+		group := endpoint.Prefer(endPoints, []string{transport})
+		if len(group) > 1 {
+			group = drv.endPointSelector.order(logger, group)
+		}
+		result = append(result, group...)
+	}
+	return result
+}
+
+// roundRobinSelector rotates the portals by one for every volume
+type roundRobinSelector struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (selector *roundRobinSelector) order(logger Logger, endPoints []*endpoint.Portal) []*endpoint.Portal {
+	selector.mu.Lock()
+	start := selector.next % len(endPoints)
+	selector.next++
+	selector.mu.Unlock()
+
+	return append(append([]*endpoint.Portal{}, endPoints[start:]...), endPoints[:start]...)
+}
+
+// latencySelector orders the portals by the time of connecting to them,
+// unreachable portals are the last ones
+type latencySelector struct {
+	probe   func(address string, timeout time.Duration) error
+	timeout time.Duration
+}
+
+func (selector *latencySelector) order(logger Logger, endPoints []*endpoint.Portal) []*endpoint.Portal {
+	latencies := map[*endpoint.Portal]time.Duration{}
+	for _, ep := range endPoints {
+		start := time.Now()
+		if err := selector.probe(ep.HostPort(), selector.timeout); err != nil {
+			logger.Warning("portal is unreachable", "portal", ep.HostPort(), "error", err)
+			latencies[ep] = math.MaxInt64
+			continue
+		}
+		latencies[ep] = time.Since(start)
+	}
+
+	result := append([]*endpoint.Portal{}, endPoints...)
+	sort.SliceStable(result, func(i, j int) bool { return latencies[result[i]] < latencies[result[j]] })
+	return result
+}
+
+// preferredSelector orders the portals by the first preferred network containing
+// their address, the portals outside of the networks are the last ones
+type preferredSelector struct {
+	networks []*net.IPNet
+}
+
+func newPreferredSelector(preferred []string) (*preferredSelector, error) {
+	if len(preferred) == 0 {
+		return nil, fmt.Errorf("no preferred portal networks for the %s endpoint selection", EndPointSelectionPreferred)
+	}
+	selector := &preferredSelector{}
+	for _, value := range preferred {
+		value = strings.TrimSpace(value)
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			value = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preferred portal network %q: %v", value, err)
+		}
+		selector.networks = append(selector.networks, network)
+	}
+	return selector, nil
+}
+
+// rank returns index of the first network containing the address
+func (selector *preferredSelector) rank(address string) int {
+	ip := net.ParseIP(address)
+	for i, network := range selector.networks {
+		if ip != nil && network.Contains(ip) {
+			return i
+		}
+	}
+	return len(selector.networks)
+}
+
+func (selector *preferredSelector) order(logger Logger, endPoints []*endpoint.Portal) []*endpoint.Portal {
+	result := append([]*endpoint.Portal{}, endPoints...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return selector.rank(result[i].Address) < selector.rank(result[j].Address)
+	})
+	return result
+}
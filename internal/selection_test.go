@@ -0,0 +1,151 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+func testEndPoints(addresses ...string) []*endpoint.Portal {
+	var endPoints []*endpoint.Portal
+	for _, address := range addresses {
+		endPoints = append(endPoints, &endpoint.Portal{Transport: "rdma", Address: address, Port: 4420})
+	}
+	return endPoints
+}
+
+func endPointAddresses(endPoints []*endpoint.Portal) []string {
+	var addresses []string
+	for _, ep := range endPoints {
+		addresses = append(addresses, ep.Address)
+	}
+	return addresses
+}
+
+func TestEndPointSelection(t *testing.T) {
+	delays := map[string]time.Duration{
+		"10.0.0.1:4420": 30 * time.Millisecond,
+		"10.0.0.2:4420": 0,
+		"10.0.0.3:4420": -1,
+	}
+	probe := func(address string, timeout time.Duration) error {
+		if delays[address] < 0 {
+			return fmt.Errorf("connection timed out")
+		}
+		time.Sleep(delays[address])
+		return nil
+	}
+
+	tests := []struct {
+		name      string
+		policy    string
+		preferred []string
+		expected  [][]string
+	}{
+		{"first", EndPointSelectionFirst, nil, [][]string{
+			{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		}},
+		{"round-robin", EndPointSelectionRoundRobin, nil, [][]string{
+			{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+			{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+			{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		}},
+		{"latency", EndPointSelectionLatency, nil, [][]string{
+			{"10.0.0.2", "10.0.0.1", "10.0.0.3"},
+		}},
+		{"preferred", EndPointSelectionPreferred, []string{"10.0.0.3", "10.0.0.0/24"}, [][]string{
+			{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			drv := &Driver{}
+			if err := drv.SetEndPointSelection(tc.policy, tc.preferred); err != nil {
+				t.Fatal(err)
+			}
+			if selector, ok := drv.endPointSelector.(*latencySelector); ok {
+				selector.probe = probe
+			}
+			for i, expected := range tc.expected {
+				volume := &Volume{Name: fmt.Sprintf("pvc-%d", i)}
+				drv.selectEndPoints(context.Background(), volume, testEndPoints("10.0.0.1", "10.0.0.2", "10.0.0.3"))
+				selected := append([]string{volume.EndPoint.Address}, endPointAddresses(volume.AltEndPoints)...)
+				if !reflect.DeepEqual(selected, expected) {
+					t.Errorf("volume %d: expected portals %v, got %v", i, expected, selected)
+				}
+			}
+		})
+	}
+}
+
+func TestPreferredSelectorUnmatched(t *testing.T) {
+	selector, err := newPreferredSelector([]string{"192.168.0.0/16", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordered := endPointAddresses(selector.order(Logger{}, testEndPoints("10.0.0.1", "fd00::1", "10.0.0.2", "192.168.1.1")))
+	expected := []string{"192.168.1.1", "fd00::1", "10.0.0.1", "10.0.0.2"}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("expected portals %v, got %v", expected, ordered)
+	}
+}
+
+func TestSetEndPointSelectionErrors(t *testing.T) {
+	drv := &Driver{}
+	for _, tc := range []struct {
+		policy    string
+		preferred []string
+	}{
+		{"fastest", nil},
+		{EndPointSelectionPreferred, nil},
+		{EndPointSelectionPreferred, []string{"10.0.0.0/33"}},
+		{EndPointSelectionPreferred, []string{"storage-net"}},
+	} {
+		if err := drv.SetEndPointSelection(tc.policy, tc.preferred); err == nil {
+			t.Errorf("SetEndPointSelection(%q, %v) expected error", tc.policy, tc.preferred)
+		}
+	}
+}
+
+func TestEndPointSelectionTransportPreference(t *testing.T) {
+	endPoints := testEndPoints("10.0.0.1", "10.0.0.2")
+	endPoints = append(endPoints,
+		&endpoint.Portal{Transport: "tcp", Address: "10.0.1.1", Port: 4420},
+		&endpoint.Portal{Transport: "tcp", Address: "10.0.1.2", Port: 4420})
+
+	drv := &Driver{}
+	if err := drv.SetEndPointSelection(EndPointSelectionPreferred, []string{"10.0.0.2", "10.0.1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := drv.SetTransportPreference([]string{"tcp", "rdma"}); err != nil {
+		t.Fatal(err)
+	}
+	volume := &Volume{Name: "pvc-1"}
+	drv.selectEndPoints(context.Background(), volume, endpoint.Prefer(endPoints, drv.transportPreference))
+
+	want := []string{"10.0.1.2", "10.0.1.1", "10.0.0.2", "10.0.0.1"}
+	if got := endPointAddresses(append([]*endpoint.Portal{volume.EndPoint}, volume.AltEndPoints...)); !reflect.DeepEqual(got, want) {
+		t.Errorf("selected portals %v, want %v", got, want)
+	}
+}
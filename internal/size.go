@@ -0,0 +1,251 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// AllocationUnitParameter is a StorageClass parameter with the unit the
+	// capacity of the new volumes is rounded up to, a size, e.g. 1Mi, or one
+	// of AllocationUnitPool and AllocationUnitNone
+	AllocationUnitParameter = "allocationUnit"
+	// AllocationUnitPool rounds the capacity up to the block size of the storage pools
+	AllocationUnitPool = "pool"
+	// AllocationUnitNone creates the volumes with the requested capacity
+	AllocationUnitNone = "none"
+)
+
+// capacityRangeError is returned for the capacity range no volume can be created with
+type capacityRangeError struct {
+	message string
+}
+
+func (e *capacityRangeError) Error() string {
+	return e.message
+}
+
+// volumeCapacity is the capacity of the new volume
+type volumeCapacity struct {
+	// required is the requested capacity, the default one if it's not requested
+	required int64
+	// limit is the maximum capacity, 0 if it's unlimited
+	limit int64
+	// unit is the allocation unit the capacity is rounded up to,
+	// 0 for the block size of the storage pools
+	unit int64
+}
+
+// parseCapacity validates the capacity range and the allocation unit parameter
+// of CreateVolume. The default capacity is used if no capacity is required,
+// down to the limit.
+func parseCapacity(capRange *csi.CapacityRange, parameters map[string]string, defaultCapacity int64) (volumeCapacity, error) {
+	result := volumeCapacity{required: capRange.GetRequiredBytes(), limit: capRange.GetLimitBytes()}
+	if result.required < 0 || result.limit < 0 {
+		return result, &capacityRangeError{fmt.Sprintf("required %d and limit %d bytes must not be negative", result.required, result.limit)}
+	}
+	if result.limit > 0 && result.required > result.limit {
+		return result, &capacityRangeError{fmt.Sprintf("required %d bytes exceed the limit %d", result.required, result.limit)}
+	}
+	if result.required == 0 {
+		result.required = defaultCapacity
+		if result.limit > 0 && result.required > result.limit {
+			result.required = result.limit
+		}
+	}
+
+	switch unit := parameters[AllocationUnitParameter]; unit {
+	case "", AllocationUnitPool:
+	case AllocationUnitNone:
+		result.unit = 1
+	default:
+		size, err := ParseSize(unit)
+		if err != nil {
+			return result, fmt.Errorf("parameter %s: %q is neither a size nor %s or %s: %v", AllocationUnitParameter, unit, AllocationUnitPool, AllocationUnitNone, err)
+		}
+		result.unit = size
+	}
+	return result, nil
+}
+
+// rounded returns the required capacity rounded up to the allocation unit,
+// it fails if the rounded capacity exceeds the limit
+func (c volumeCapacity) rounded(unit int64) (int64, error) {
+	result := c.required
+	if unit > 1 {
+		result = (result + unit - 1) / unit * unit
+	}
+	if c.limit > 0 && result > c.limit {
+		return 0, &capacityRangeError{fmt.Sprintf("required %d bytes rounded up to the allocation unit %d exceed the limit %d", c.required, unit, c.limit)}
+	}
+	return result, nil
+}
+
+// poolBlockSize returns the block size of the storage pools of the service
+// providing capacity of the volume, 0 if they don't report it. Block sizes
+// are powers of two, so the largest one is a multiple of all of them.
+func (drv *Driver) poolBlockSize(ctx context.Context, service *rsd.StorageService, provisioning *volumeProvisioning) (int64, error) {
+	client := drv.client(ctx)
+	poolCollection, err := service.GetStoragePoolCollection(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	pools, err := poolCollection.GetMembers(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+
+	var result int64
+	for _, pool := range pools {
+		if selected := provisioning.storagePool(); selected != "" && pool.OdataID != selected {
+			continue
+		}
+		if blockSize := int64(pool.BlockSizeBytes); blockSize > result {
+			result = blockSize
+		}
+	}
+	return result, nil
+}
+
+// allocatedCapacity returns the capacity the volume is created with in the
+// service. The capacity isn't rounded to the pool block size which can't be
+// read, RSD then validates the capacity itself.
+func (drv *Driver) allocatedCapacity(ctx context.Context, service *rsd.StorageService, capacity volumeCapacity, provisioning *volumeProvisioning) (int64, error) {
+	unit := capacity.unit
+	if unit == 0 {
+		blockSize, err := drv.poolBlockSize(ctx, service, provisioning)
+		if err != nil {
+			drv.logger.Warning("can't get block size of RSD storage pools, capacity isn't rounded", "storage_service", service.ID, "error", err)
+		}
+		unit = blockSize
+	}
+	return capacity.rounded(unit)
+}
+
+// ParseSize parses volume size given as a Kubernetes resource quantity,
+// e.g. "1Gi", "500M" or "1073741824"
+func ParseSize(size string) (int64, error) {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, err
+	}
+	value := quantity.Value()
+	if value <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return value, nil
+}
+
+// SetDefaultVolumeSize sets capacity of the volumes created without capacity range
+func (drv *Driver) SetDefaultVolumeSize(size int64) {
+	drv.defaultVolumeSize = size
+}
+
+// getDefaultVolumeSize returns capacity of the volumes created without capacity range
+func (drv *Driver) getDefaultVolumeSize() int64 {
+	if drv.defaultVolumeSize == 0 {
+		return defaultVolumeCapacity
+	}
+	return drv.defaultVolumeSize
+}
+
+// checkDefaultVolumeSize checks that the default volume size
+// is a multiple of the block size of the RSD storage pools
+// of all storage services
+func (drv *Driver) checkDefaultVolumeSize() error {
+	ctx := context.Background()
+	client := drv.rsdClient
+	services, err := drv.listStorageServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		poolCollection, err := service.GetStoragePoolCollection(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		pools, err := poolCollection.GetMembers(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		if err := checkBlockSize(drv.getDefaultVolumeSize(), pools); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBlockSize checks that size is a multiple of the block size of the pools
+func checkBlockSize(size int64, pools []*rsd.StoragePool) error {
+	for _, pool := range pools {
+		if blockSize := int64(pool.BlockSizeBytes); blockSize > 0 && size%blockSize != 0 {
+			return fmt.Errorf("%d is not a multiple of block size %d of the storage pool %s", size, blockSize, pool.ID)
+		}
+	}
+	return nil
+}
+
+// SetMaxTotalCapacity sets the budget of the total capacity of the volumes
+// provisioned by the driver, it's unlimited if size is 0
+func (drv *Driver) SetMaxTotalCapacity(size int64) {
+	drv.maxTotalCapacity = size
+}
+
+// provisionedCapacity returns the total capacity of the known volumes as
+// reported by RSD and of the volumes being created. It must be called with
+// drv.volumesRWL locked.
+func (drv *Driver) provisionedCapacity() int64 {
+	result := drv.reservedCapacity
+	for _, volume := range drv.volumes {
+		result += volume.CSIVolume.CapacityBytes
+	}
+	return result
+}
+
+// checkCapacityBudget returns an error if provisioning additional bytes
+// exceeds the total capacity budget. It must be called with drv.volumesRWL locked.
+func (drv *Driver) checkCapacityBudget(additional int64) error {
+	if drv.maxTotalCapacity == 0 {
+		return nil
+	}
+	provisioned := drv.provisionedCapacity()
+	if provisioned+additional > drv.maxTotalCapacity {
+		return fmt.Errorf("%d bytes more would exceed the total capacity budget %d, %d bytes are provisioned", additional, drv.maxTotalCapacity, provisioned)
+	}
+	return nil
+}
+
+// remainingCapacityBudget returns the capacity which can be provisioned
+// within the total capacity budget, -1 if it's unlimited
+func (drv *Driver) remainingCapacityBudget() int64 {
+	if drv.maxTotalCapacity == 0 {
+		return -1
+	}
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+	if remaining := drv.maxTotalCapacity - drv.provisionedCapacity(); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
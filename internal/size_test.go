@@ -0,0 +1,269 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "1Gi", want: GB},
+		{size: "500M", want: 500000000},
+		{size: "4096", want: 4 * KB},
+		{size: "0", wantErr: true},
+		{size: "-1Gi", wantErr: true},
+		{size: "1 GiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBlockSize(t *testing.T) {
+	pools := []*rsd.StoragePool{{ID: "1", BlockSizeBytes: 512}, {ID: "2", BlockSizeBytes: 4096}}
+	if err := checkBlockSize(GB, pools); err != nil {
+		t.Errorf("checkBlockSize(%d) unexpected error: %v", GB, err)
+	}
+	if err := checkBlockSize(500000000+512, pools); err == nil {
+		t.Error("checkBlockSize() didn't fail for size not aligned to the pool block size")
+	}
+}
+
+func TestDefaultVolumeSize(t *testing.T) {
+	drv := &Driver{}
+	capacity, _ := parseCapacity(nil, nil, drv.getDefaultVolumeSize())
+	if capacity.required != GB {
+		t.Errorf("default capacity = %d, want %d", capacity.required, GB)
+	}
+	drv.SetDefaultVolumeSize(10 * GB)
+	capacity, _ = parseCapacity(nil, nil, drv.getDefaultVolumeSize())
+	if capacity.required != 10*GB {
+		t.Errorf("configured default capacity = %d, want %d", capacity.required, 10*GB)
+	}
+	capacity, _ = parseCapacity(&csi.CapacityRange{RequiredBytes: MB}, nil, drv.getDefaultVolumeSize())
+	if capacity.required != MB {
+		t.Errorf("required capacity = %d, want %d", capacity.required, MB)
+	}
+}
+
+func TestParseCapacity(t *testing.T) {
+	tests := []struct {
+		name       string
+		capRange   *csi.CapacityRange
+		unit       string
+		want       volumeCapacity
+		wantErr    bool
+		outOfRange bool
+	}{
+		{name: "default", want: volumeCapacity{required: GB}},
+		{name: "required", capRange: &csi.CapacityRange{RequiredBytes: MB}, want: volumeCapacity{required: MB}},
+		{name: "limit only", capRange: &csi.CapacityRange{LimitBytes: 10 * GB}, want: volumeCapacity{required: GB, limit: 10 * GB}},
+		{name: "limit below default", capRange: &csi.CapacityRange{LimitBytes: MB}, want: volumeCapacity{required: MB, limit: MB}},
+		{name: "required within limit", capRange: &csi.CapacityRange{RequiredBytes: MB, LimitBytes: GB}, want: volumeCapacity{required: MB, limit: GB}},
+		{name: "required over limit", capRange: &csi.CapacityRange{RequiredBytes: GB, LimitBytes: MB}, wantErr: true, outOfRange: true},
+		{name: "negative", capRange: &csi.CapacityRange{RequiredBytes: -1}, wantErr: true, outOfRange: true},
+		{name: "pool unit", unit: "pool", want: volumeCapacity{required: GB}},
+		{name: "no unit", unit: "none", want: volumeCapacity{required: GB, unit: 1}},
+		{name: "size unit", unit: "4Mi", want: volumeCapacity{required: GB, unit: 4 * MB}},
+		{name: "invalid unit", unit: "blocks", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameters := map[string]string{}
+			if tt.unit != "" {
+				parameters[AllocationUnitParameter] = tt.unit
+			}
+			got, err := parseCapacity(tt.capRange, parameters, GB)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCapacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, outOfRange := err.(*capacityRangeError); outOfRange != tt.outOfRange {
+				t.Errorf("parseCapacity() error = %v, want out of range %v", err, tt.outOfRange)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseCapacity() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeCapacityRounded(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity volumeCapacity
+		unit     int64
+		want     int64
+		wantErr  bool
+	}{
+		{name: "aligned", capacity: volumeCapacity{required: 4 * KB}, unit: 512, want: 4 * KB},
+		{name: "rounded up", capacity: volumeCapacity{required: 1000}, unit: 512, want: 1024},
+		{name: "unknown unit", capacity: volumeCapacity{required: 1000}, want: 1000},
+		{name: "rounded within limit", capacity: volumeCapacity{required: 1000, limit: 1024}, unit: 512, want: 1024},
+		{name: "rounded over limit", capacity: volumeCapacity{required: 1000, limit: 1023}, unit: 512, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.capacity.rounded(tt.unit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rounded() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rounded() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// capacityClient records the capacity of the created volumes
+type capacityClient struct {
+	TestClient
+	requested []int64
+}
+
+func (client *capacityClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.requested = append(client.requested, data.(*rsd.NewVolumeRequest).CapacityBytes)
+	return client.TestClient.Post(ctx, entrypoint, data, result)
+}
+
+func TestCreateVolumeAllocationUnit(t *testing.T) {
+	tests := []struct {
+		name      string
+		capRange  *csi.CapacityRange
+		unit      string
+		want      int64
+		wantCode  codes.Code
+		wantPosts int
+	}{
+		{name: "pool block size", capRange: &csi.CapacityRange{RequiredBytes: 1000}, want: 4 * KB, wantPosts: 1},
+		{name: "no rounding", capRange: &csi.CapacityRange{RequiredBytes: 1000}, unit: "none", want: 1000, wantPosts: 1},
+		{name: "size unit", capRange: &csi.CapacityRange{RequiredBytes: 1000}, unit: "1Mi", want: MB, wantPosts: 1},
+		{name: "pool block size over limit", capRange: &csi.CapacityRange{RequiredBytes: 1000, LimitBytes: 2 * KB}, wantCode: codes.OutOfRange},
+		{name: "size unit over limit", capRange: &csi.CapacityRange{RequiredBytes: 1000, LimitBytes: 2 * KB}, unit: "1Mi", wantCode: codes.OutOfRange},
+		{name: "required over limit", capRange: &csi.CapacityRange{RequiredBytes: 4 * KB, LimitBytes: 2 * KB}, wantCode: codes.OutOfRange},
+		{name: "invalid unit", unit: "blocks", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &capacityClient{TestClient: TestClient{results: map[string]string{
+				"/redfish/v1/StorageServices":                  `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1":                `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}, "StoragePools": {"@odata.id": "/redfish/v1/StorageServices/1/StoragePools"}}`,
+				"/redfish/v1/StorageServices/1/Volumes":        `{"Members": []}`,
+				"/redfish/v1/StorageServices/1/Volumes/1":      `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 4096}`,
+				"/redfish/v1/StorageServices/1/StoragePools":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1"}, {"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/2"}]}`,
+				"/redfish/v1/StorageServices/1/StoragePools/1": `{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1", "BlockSizeBytes": 512}`,
+				"/redfish/v1/StorageServices/1/StoragePools/2": `{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/2", "BlockSizeBytes": 4096}`,
+			}}}
+			drv := &Driver{rsdClient: client, metrics: newDriverMetrics(), volumes: map[string]*Volume{}}
+			req := &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      tt.capRange,
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+				Parameters:         map[string]string{},
+			}
+			if tt.unit != "" {
+				req.Parameters[AllocationUnitParameter] = tt.unit
+			}
+
+			_, err := drv.CreateVolume(context.Background(), req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("CreateVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if len(client.requested) != tt.wantPosts {
+				t.Fatalf("created volumes of %v bytes, want %d volumes", client.requested, tt.wantPosts)
+			}
+			if tt.wantPosts > 0 && client.requested[0] != tt.want {
+				t.Errorf("created volume of %d bytes, want %d", client.requested[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxTotalCapacity(t *testing.T) {
+	drv := &Driver{
+		metrics: newDriverMetrics(),
+		rsdClient: &TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":                  `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1":                `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}, "StoragePools": {"@odata.id": "/redfish/v1/StorageServices/1/StoragePools"}}`,
+				"/redfish/v1/StorageServices/1/Volumes":        `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}`,
+				"/redfish/v1/StorageServices/1/Volumes/1":      `{"Id": "1", "CapacityBytes": 100}`,
+				"/redfish/v1/StorageServices/1/StoragePools":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/StoragePools/1"}]}`,
+				"/redfish/v1/StorageServices/1/StoragePools/1": `{"Capacity": {"Data": {"GuaranteedBytes": 1000}}}`,
+			},
+		},
+		volumes: map[string]*Volume{
+			"pvc-0": &Volume{
+				Name:      "pvc-0",
+				CSIVolume: &csi.Volume{VolumeId: "0", CapacityBytes: 150},
+				RSDVolume: &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/0"},
+			},
+		},
+	}
+	drv.SetMaxTotalCapacity(200)
+
+	capabilities := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	_, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 100},
+		VolumeCapabilities: capabilities,
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("CreateVolume() error = %v, want ResourceExhausted", err)
+	}
+
+	resp, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	if err != nil {
+		t.Fatalf("GetCapacity() unexpected error: %v", err)
+	}
+	if resp.AvailableCapacity != 50 {
+		t.Errorf("GetCapacity() = %d, want remaining budget 50", resp.AvailableCapacity)
+	}
+
+	if _, err := drv.expandVolume(context.Background(), drv.volumes["pvc-0"], 300); err == nil {
+		t.Error("expandVolume() exceeding the budget succeeded")
+	}
+
+	drv.SetMaxTotalCapacity(250)
+	if _, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 100},
+		VolumeCapabilities: capabilities,
+	}); err != nil {
+		t.Errorf("CreateVolume() within the budget error = %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// snapshotDescriptionPrefix starts RSD volume Description of the snapshots,
+// e.g. csi.rsd.intel.com/snapshot:cluster1:snapshot-4f1e0c2a. It differs from
+// the volume one, so snapshots are never adopted as volumes.
+const snapshotDescriptionPrefix = DriverName + "/snapshot"
+
+// Snapshot is the driver record of the volume snapshot. Snapshots are RSD
+// volumes replicating their source volume, see rsd.VolumeCollection.NewSnapshot.
+type Snapshot struct {
+	Name        string
+	CSISnapshot *csi.Snapshot
+	RSDVolume   *rsd.Volume
+}
+
+// snapshotDescription returns RSD volume Description of the snapshot
+func (drv *Driver) snapshotDescription(name string) string {
+	return strings.Join([]string{snapshotDescriptionPrefix, drv.ClusterID, drv.rsdVolumeName(name)}, descriptionSeparator)
+}
+
+// snapshotNameFromDescription returns CSI snapshot name from the RSD volume Description.
+// It returns false if the volume isn't a snapshot taken by the driver in the cluster.
+func (drv *Driver) snapshotNameFromDescription(description string) (string, bool) {
+	parts := strings.SplitN(description, descriptionSeparator, 3)
+	if len(parts) != 3 || parts[0] != snapshotDescriptionPrefix || parts[1] != drv.ClusterID || parts[2] == "" {
+		return "", false
+	}
+	return drv.names.csiName(parts[2]), true
+}
+
+// newSnapshotRecord creates internal driver record for the RSD snapshot volume
+func newSnapshotRecord(name, sourceVolumeID string, rsdVolume *rsd.Volume, created time.Time) *Snapshot {
+	creationTime, err := ptypes.TimestampProto(created)
+	if err != nil {
+		creationTime = ptypes.TimestampNow()
+	}
+	return &Snapshot{
+		Name: name,
+		CSISnapshot: &csi.Snapshot{
+			SnapshotId:     rsdVolume.ID,
+			SourceVolumeId: sourceVolumeID,
+			SizeBytes:      rsdVolume.CapacityBytes,
+			CreationTime:   creationTime,
+			// RSD replicates the volume until its operations complete
+			ReadyToUse: len(rsdVolume.Operations) == 0,
+		},
+		RSDVolume: rsdVolume,
+	}
+}
+
+// sourceVolumeID returns CSI volume ID of the RSD volume with the @odata.id.
+// Volumes unknown to the driver are identified by the last path segment like RSD does.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) sourceVolumeID(odataID string) string {
+	for _, volume := range drv.volumes {
+		if volume.RSDVolume != nil && volume.RSDVolume.OdataID == odataID {
+			return volume.CSIVolume.VolumeId
+		}
+	}
+	return path.Base(odataID)
+}
+
+// adoptSnapshot adds the snapshot previously taken by the driver to the snapshots map.
+// RSD doesn't keep the time the snapshot was taken, so the time of adoption is used.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) adoptSnapshot(name string, rsdVolume *rsd.Volume) {
+	source := rsdVolume.SnapshotSource()
+	if source == "" {
+		drv.logger.Warning("RSD volume tagged as snapshot doesn't replicate any volume", "rsd_volume", rsdVolume.ID, "snapshot", name)
+		return
+	}
+	if _, exists := drv.snapshots[name]; exists {
+		return
+	}
+	if drv.snapshots == nil {
+		drv.snapshots = map[string]*Snapshot{}
+	}
+	drv.snapshots[name] = newSnapshotRecord(name, drv.sourceVolumeID(source), rsdVolume, drv.now())
+	drv.logger.V(LogLevelState).Info("adopted RSD volume as snapshot", "rsd_volume", rsdVolume.ID, "snapshot", name)
+}
+
+// lookupSnapshot returns the snapshot with the CSI name. Like volumes, adopted
+// snapshots with sanitized names are renamed when they're looked up.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) lookupSnapshot(name string) (*Snapshot, bool) {
+	if snapshot, exists := drv.snapshots[name]; exists {
+		return snapshot, true
+	}
+	rsdName := drv.rsdVolumeName(name)
+	snapshot, exists := drv.snapshots[rsdName]
+	if !exists || rsdName == name {
+		return nil, false
+	}
+
+	delete(drv.snapshots, rsdName)
+	snapshot.Name = name
+	drv.snapshots[name] = snapshot
+	return snapshot, true
+}
+
+// findSnapshotByID returns the snapshot with the CSI snapshot ID or nil if it's not found.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) findSnapshotByID(snapshotID string) *Snapshot {
+	for _, snapshot := range drv.snapshots {
+		if snapshot.CSISnapshot.SnapshotId == snapshotID {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+// newSnapshot takes a snapshot of the source volume and records it. The RSD
+// requests are sent without holding drv.volumesRWL, so it must be called with
+// the snapshot name locked in drv.snapshotLocks and the source volume locked
+// in drv.volumeLocks.
+func (drv *Driver) newSnapshot(ctx context.Context, name string, source *Volume) (*csi.Snapshot, error) {
+	drv.volumesRWL.RLock()
+	sourceVolumeID := source.CSIVolume.VolumeId
+	sourceRSDVolume := *source.RSDVolume
+	drv.volumesRWL.RUnlock()
+
+	client := drv.client(ctx)
+	volCollection, err := drv.volumeCollectionOf(ctx, &sourceRSDVolume)
+	if err != nil {
+		return nil, err
+	}
+
+	rsdVolume, err := volCollection.NewSnapshot(ctx, client, &sourceRSDVolume, drv.snapshotDescription(name))
+	if err != nil {
+		return nil, err
+	}
+	drv.capacity.invalidate()
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	if drv.snapshots == nil {
+		drv.snapshots = map[string]*Snapshot{}
+	}
+	snapshot := newSnapshotRecord(name, sourceVolumeID, rsdVolume, drv.now())
+	drv.snapshots[name] = snapshot
+	return snapshot.CSISnapshot, nil
+}
+
+// newVolumeFromSnapshot creates a volume with the content of the snapshot and
+// returns its record to be added to the Volumes map. It must be called with
+// the creation lock of the volume name held.
+func (drv *Driver) newVolumeFromSnapshot(ctx context.Context, name string, requiredCapacity int64, volumeContext map[string]string, snapshot *Snapshot) (*Volume, error) {
+	rsdVolume, err := drv.createRSDVolume(ctx, name, func() (*rsd.Volume, error) {
+		client := drv.rsdClient
+		volCollection, err := drv.volumeCollectionOf(ctx, snapshot.RSDVolume)
+		if err != nil {
+			return nil, err
+		}
+		return volCollection.NewVolumeFromSnapshot(ctx, client, snapshot.RSDVolume, requiredCapacity, drv.volumeDescription(name))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	volume := newVolumeRecord(name, rsdVolume)
+	volume.RequiredBytes = requiredCapacity
+	for key, value := range volumeContext {
+		volume.CSIVolume.VolumeContext[key] = value
+	}
+	volume.CSIVolume.ContentSource = &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshot.CSISnapshot.SnapshotId},
+		},
+	}
+
+	return volume, nil
+}
+
+// deleteSnapshot deletes RSD snapshot volume and removes the snapshot from
+// drv.snapshots. It does nothing if the snapshot doesn't exist. The RSD
+// request is sent without holding drv.volumesRWL, so it must be called with
+// the snapshot name locked in drv.snapshotLocks.
+func (drv *Driver) deleteSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	err := snapshot.RSDVolume.Delete(ctx, drv.client(ctx))
+	drv.capacity.invalidate()
+	if rsd.Classify(err) == rsd.CategoryNotFound {
+		drv.requestLogger(ctx).Warning("RSD volume of the snapshot is already deleted", "rsd_volume", snapshot.RSDVolume.ID, "snapshot", snapshot.Name, "error", err)
+	} else if err != nil {
+		return errors.Wrapf(err, "can't delete RSD Volume %s", snapshot.RSDVolume.ID)
+	}
+
+	drv.volumesRWL.Lock()
+	defer drv.volumesRWL.Unlock()
+	if drv.snapshots[snapshot.Name] == snapshot {
+		delete(drv.snapshots, snapshot.Name)
+	}
+	return nil
+}
+
+// listCSISnapshots returns snapshots sorted by name. Snapshots are filtered
+// by the snapshot ID and source volume ID unless they're empty.
+// It must be called with drv.volumesRWL locked.
+func (drv *Driver) listCSISnapshots(snapshotID, sourceVolumeID string) []*csi.Snapshot {
+	names := make([]string, 0, len(drv.snapshots))
+	for name := range drv.snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []*csi.Snapshot{}
+	for _, name := range names {
+		snapshot := drv.snapshots[name].CSISnapshot
+		if snapshotID != "" && snapshot.SnapshotId != snapshotID {
+			continue
+		}
+		if sourceVolumeID != "" && snapshot.SourceVolumeId != sourceVolumeID {
+			continue
+		}
+		result = append(result, snapshot)
+	}
+	return result
+}
@@ -0,0 +1,310 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// replicaClient returns the given locations of the created volumes
+// and records the requested replicas
+type replicaClient struct {
+	TestClient
+	locations []string
+	replicas  []rsd.ReplicaInfo
+}
+
+func (client *replicaClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.replicas = append(client.replicas, data.(*rsd.NewVolumeRequest).ReplicaInfos...)
+	location := client.locations[0]
+	client.locations = client.locations[1:]
+	return &http.Header{"Location": []string{location}}, nil
+}
+
+func newSnapshotTestDriver() (*Driver, *replicaClient) {
+	client := &replicaClient{
+		TestClient: TestClient{results: map[string]string{
+			"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+			"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+			"/redfish/v1/StorageServices/1/Volumes":   `{"Members": []}`,
+			"/redfish/v1/StorageServices/1/Volumes/2": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2", "Id": "2", "CapacityBytes": 100, "ReplicaInfos": [{"ReplicaType": "Snapshot", "ReplicaRole": "Target", "Replica": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}}]}`,
+			"/redfish/v1/StorageServices/1/Volumes/3": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/3", "Id": "3", "CapacityBytes": 200}`,
+		}},
+		locations: []string{"/redfish/v1/StorageServices/1/Volumes/2", "/redfish/v1/StorageServices/1/Volumes/3"},
+	}
+	drv := &Driver{
+		rsdClient: client,
+		metrics:   newDriverMetrics(),
+		volumes: map[string]*Volume{
+			"pvc-1": newVolumeRecord("pvc-1", &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/1", ID: "1", CapacityBytes: 100}),
+		},
+	}
+	return drv, client
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	drv, client := newSnapshotTestDriver()
+
+	resp, err := drv.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "1"})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() unexpected error: %v", err)
+	}
+	snapshot := resp.Snapshot
+	if snapshot.SnapshotId != "2" || snapshot.SourceVolumeId != "1" || snapshot.SizeBytes != 100 || !snapshot.ReadyToUse || snapshot.CreationTime == nil {
+		t.Errorf("unexpected snapshot: %v", snapshot)
+	}
+	want := []rsd.ReplicaInfo{rsd.NewReplicaInfo(rsd.ReplicaTypeSnapshot, "/redfish/v1/StorageServices/1/Volumes/1")}
+	if !reflect.DeepEqual(client.replicas, want) {
+		t.Errorf("requested replicas %v, want %v", client.replicas, want)
+	}
+
+	// the request is idempotent
+	resp, err = drv.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "1"})
+	if err != nil || resp.Snapshot != snapshot {
+		t.Errorf("repeated CreateSnapshot() = %v, %v, want %v", resp, err, snapshot)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		req      *csi.CreateSnapshotRequest
+		wantCode codes.Code
+	}{
+		{name: "no name", req: &csi.CreateSnapshotRequest{SourceVolumeId: "1"}, wantCode: codes.InvalidArgument},
+		{name: "no source", req: &csi.CreateSnapshotRequest{Name: "snapshot-2"}, wantCode: codes.InvalidArgument},
+		{name: "unknown source", req: &csi.CreateSnapshotRequest{Name: "snapshot-2", SourceVolumeId: "5"}, wantCode: codes.NotFound},
+		{name: "other source", req: &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "5"}, wantCode: codes.AlreadyExists},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := drv.CreateSnapshot(context.Background(), tt.req); status.Code(err) != tt.wantCode {
+				t.Errorf("CreateSnapshot() error = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+// blockingReplicaClient blocks creation of the volumes until it's released
+type blockingReplicaClient struct {
+	*replicaClient
+	posted  chan struct{}
+	release chan struct{}
+}
+
+func (client *blockingReplicaClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.posted <- struct{}{}
+	<-client.release
+	return client.replicaClient.Post(ctx, entrypoint, data, result)
+}
+
+func TestCreateSnapshotLocks(t *testing.T) {
+	drv, replicas := newSnapshotTestDriver()
+	client := &blockingReplicaClient{replicaClient: replicas, posted: make(chan struct{}), release: make(chan struct{})}
+	drv.rsdClient = client
+
+	created := make(chan error, 1)
+	go func() {
+		_, err := drv.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "1"})
+		created <- err
+	}()
+	<-client.posted
+
+	// the driver volumes aren't locked while RSD replicates the volume
+	listed := make(chan error, 1)
+	go func() {
+		_, err := drv.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{})
+		listed <- err
+	}()
+	select {
+	case err := <-listed:
+		if err != nil {
+			t.Errorf("ListSnapshots() unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListSnapshots() blocked by CreateSnapshot()")
+	}
+
+	// the snapshot name and the source volume are locked
+	if _, err := drv.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "1"}); status.Code(err) != codes.Aborted {
+		t.Errorf("concurrent CreateSnapshot() error = %v, want Aborted", err)
+	}
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "1"}); status.Code(err) != codes.Aborted {
+		t.Errorf("DeleteVolume() of the snapshot source error = %v, want Aborted", err)
+	}
+
+	close(client.release)
+	if err := <-created; err != nil {
+		t.Fatalf("CreateSnapshot() unexpected error: %v", err)
+	}
+	if drv.volumeLocks.isLocked("1") || drv.snapshotLocks.isLocked("snapshot-1") {
+		t.Error("source volume or snapshot name is left locked")
+	}
+	if len(drv.snapshots) != 1 || drv.snapshots["snapshot-1"] == nil {
+		t.Errorf("snapshots %v, want snapshot-1", drv.snapshots)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	drv, _ := newSnapshotTestDriver()
+	drv.snapshots = map[string]*Snapshot{
+		"snapshot-1": newSnapshotRecord("snapshot-1", "1", &rsd.Volume{ID: "2"}, time.Now()),
+		"snapshot-2": newSnapshotRecord("snapshot-2", "1", &rsd.Volume{ID: "3"}, time.Now()),
+		"snapshot-3": newSnapshotRecord("snapshot-3", "4", &rsd.Volume{ID: "5"}, time.Now()),
+	}
+
+	tests := []struct {
+		name          string
+		req           *csi.ListSnapshotsRequest
+		wantIDs       []string
+		wantNextToken string
+	}{
+		{name: "all", req: &csi.ListSnapshotsRequest{}, wantIDs: []string{"2", "3", "5"}},
+		{name: "by snapshot ID", req: &csi.ListSnapshotsRequest{SnapshotId: "3"}, wantIDs: []string{"3"}},
+		{name: "by source volume ID", req: &csi.ListSnapshotsRequest{SourceVolumeId: "1"}, wantIDs: []string{"2", "3"}},
+		{name: "unknown snapshot ID", req: &csi.ListSnapshotsRequest{SnapshotId: "6"}},
+		{name: "first page", req: &csi.ListSnapshotsRequest{MaxEntries: 2}, wantIDs: []string{"2", "3"}, wantNextToken: "2"},
+		{name: "last page", req: &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: "2"}, wantIDs: []string{"5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := drv.ListSnapshots(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("ListSnapshots() unexpected error: %v", err)
+			}
+			var ids []string
+			for _, entry := range resp.Entries {
+				ids = append(ids, entry.Snapshot.SnapshotId)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || resp.NextToken != tt.wantNextToken {
+				t.Errorf("ListSnapshots() = %v, next token %q, want %v, %q", ids, resp.NextToken, tt.wantIDs, tt.wantNextToken)
+			}
+		})
+	}
+
+	if _, err := drv.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{StartingToken: "4"}); status.Code(err) != codes.Aborted {
+		t.Errorf("ListSnapshots() with too big starting token error = %v, want Aborted", err)
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	drv, _ := newSnapshotTestDriver()
+	drv.snapshots = map[string]*Snapshot{
+		"snapshot-1": newSnapshotRecord("snapshot-1", "1", &rsd.Volume{ID: "2"}, time.Now()),
+	}
+
+	if _, err := drv.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DeleteSnapshot() without ID error = %v, want InvalidArgument", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := drv.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: "2"}); err != nil {
+			t.Errorf("DeleteSnapshot() unexpected error: %v", err)
+		}
+	}
+	if len(drv.snapshots) != 0 {
+		t.Errorf("snapshot is not deleted: %v", drv.snapshots)
+	}
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	drv, client := newSnapshotTestDriver()
+	client.locations = client.locations[1:]
+	drv.snapshots = map[string]*Snapshot{
+		"snapshot-1": newSnapshotRecord("snapshot-1", "1", &rsd.Volume{OdataID: "/redfish/v1/StorageServices/1/Volumes/2", ID: "2", CapacityBytes: 100}, time.Now()),
+	}
+	request := func(name, snapshotID string, limitBytes int64) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: &csi.CapacityRange{LimitBytes: limitBytes},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			},
+		}
+	}
+
+	if _, err := drv.CreateVolume(context.Background(), request("pvc-2", "5", 0)); status.Code(err) != codes.NotFound {
+		t.Errorf("CreateVolume() from unknown snapshot error = %v, want NotFound", err)
+	}
+	if _, err := drv.CreateVolume(context.Background(), request("pvc-2", "2", 50)); status.Code(err) != codes.OutOfRange {
+		t.Errorf("CreateVolume() smaller than the snapshot error = %v, want OutOfRange", err)
+	}
+	volumeSource := request("pvc-2", "2", 0)
+	volumeSource.VolumeContentSource.Type = &csi.VolumeContentSource_Volume{Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "1"}}
+	if _, err := drv.CreateVolume(context.Background(), volumeSource); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() from volume error = %v, want InvalidArgument", err)
+	}
+
+	resp, err := drv.CreateVolume(context.Background(), request("pvc-2", "2", 200))
+	if err != nil {
+		t.Fatalf("CreateVolume() from snapshot unexpected error: %v", err)
+	}
+	if resp.Volume.VolumeId != "3" || resp.Volume.ContentSource.GetSnapshot().GetSnapshotId() != "2" {
+		t.Errorf("unexpected volume restored from snapshot: %v", resp.Volume)
+	}
+	want := []rsd.ReplicaInfo{rsd.NewReplicaInfo(rsd.ReplicaTypeClone, "/redfish/v1/StorageServices/1/Volumes/2")}
+	if !reflect.DeepEqual(client.replicas, want) {
+		t.Errorf("requested replicas %v, want %v", client.replicas, want)
+	}
+}
+
+func TestAdoptSnapshots(t *testing.T) {
+	drv := &Driver{
+		ClusterID:        "cluster1",
+		VolumeNamePrefix: "pvc-",
+		rsdClient: &TestClient{
+			results: map[string]string{
+				"/redfish/v1/StorageServices":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+				"/redfish/v1/StorageServices/1": `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+				"/redfish/v1/StorageServices/1/Volumes": `{"Members": [
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"},
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"},
+					{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/3"}
+				]}`,
+				"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100, "Description": "csi.rsd.intel.com:cluster1:pvc-1"}`,
+				"/redfish/v1/StorageServices/1/Volumes/2": `{"Id": "2", "CapacityBytes": 100, "Description": "csi.rsd.intel.com/snapshot:cluster1:snapshot-1",
+					"ReplicaInfos": [{"ReplicaType": "Snapshot", "ReplicaRole": "Target", "Replica": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}}]}`,
+				"/redfish/v1/StorageServices/1/Volumes/3": `{"Id": "3", "CapacityBytes": 100, "Description": "csi.rsd.intel.com/snapshot:cluster2:snapshot-2",
+					"ReplicaInfos": [{"ReplicaType": "Snapshot", "ReplicaRole": "Target", "Replica": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}}]}`,
+			},
+		},
+		volumes: map[string]*Volume{},
+	}
+
+	if err := drv.adoptVolumes(); err != nil {
+		t.Fatalf("adoptVolumes() unexpected error: %v", err)
+	}
+
+	if len(drv.volumes) != 1 || drv.volumes["pvc-1"] == nil {
+		t.Errorf("adopted volumes = %v, want only pvc-1", drv.volumes)
+	}
+	if len(drv.snapshots) != 1 || drv.snapshots["snapshot-1"] == nil {
+		t.Fatalf("adopted snapshots = %v, want only snapshot-1", drv.snapshots)
+	}
+	if snapshot := drv.snapshots["snapshot-1"].CSISnapshot; snapshot.SnapshotId != "2" || snapshot.SourceVolumeId != "1" {
+		t.Errorf("unexpected adopted snapshot: %v", snapshot)
+	}
+}
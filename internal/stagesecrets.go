@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+)
+
+// Keys of the NodeStageVolume secrets with per-volume fabric credentials and portals
+const (
+	// stageSecretDHCHAPKey is the host key of NVMe in-band authentication (DH-HMAC-CHAP)
+	stageSecretDHCHAPKey = "dhchapKey"
+	// stageSecretDHCHAPCtrlKey is the controller key of bidirectional NVMe in-band authentication
+	stageSecretDHCHAPCtrlKey = "dhchapCtrlKey"
+	// stageSecretPortal overrides the volume endpoint address, e.g. 192.168.1.1 or [fd00::1]:4420
+	stageSecretPortal = "portal"
+)
+
+// stageSecrets are the fabric credentials and portal of the volume from the NodeStageVolume secrets
+type stageSecrets struct {
+	auth FabricAuth
+	// portal overrides address and port of the volume endpoint if they're not empty
+	portalAddress string
+	portalPort    int
+}
+
+// parseStageSecrets validates the NodeStageVolume secrets. Errors never contain secret values.
+func parseStageSecrets(secrets map[string]string) (stageSecrets, error) {
+	result := stageSecrets{
+		auth: FabricAuth{
+			HostKey: secrets[stageSecretDHCHAPKey],
+			CtrlKey: secrets[stageSecretDHCHAPCtrlKey],
+		},
+	}
+	if result.auth.CtrlKey != "" && result.auth.HostKey == "" {
+		return result, errors.New(stageSecretDHCHAPCtrlKey + " secret requires " + stageSecretDHCHAPKey + " secret")
+	}
+
+	portal, exists := secrets[stageSecretPortal]
+	if !exists {
+		return result, nil
+	}
+	host, port, err := net.SplitHostPort(portal)
+	if err != nil {
+		host, port = portal, ""
+	}
+	if net.ParseIP(host) == nil {
+		return result, errors.New(stageSecretPortal + " secret must be an IP address with optional port")
+	}
+	result.portalAddress = host
+	if port != "" {
+		result.portalPort, err = strconv.Atoi(port)
+		if err != nil || result.portalPort <= 0 || result.portalPort > 65535 {
+			return result, errors.New(stageSecretPortal + " secret has invalid port")
+		}
+	}
+	return result, nil
+}
+
+// endPoint returns the volume endpoint with the portal override applied
+func (secrets stageSecrets) endPoint(ep *endpoint.Portal) *endpoint.Portal {
+	if secrets.portalAddress == "" {
+		return ep
+	}
+	result := *ep
+	result.Address = secrets.portalAddress
+	result.AddressFamily = endpoint.FamilyIPv4
+	if net.ParseIP(secrets.portalAddress).To4() == nil {
+		result.AddressFamily = endpoint.FamilyIPv6
+	}
+	if secrets.portalPort != 0 {
+		result.Port = secrets.portalPort
+	}
+	return &result
+}
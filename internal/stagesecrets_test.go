@@ -0,0 +1,119 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+func TestParseStageSecrets(t *testing.T) {
+	ep := &endpoint.Portal{Address: "10.0.0.1", AddressFamily: "IPv4", Port: 4420, Transport: "rdma", NQN: "nqn.1"}
+
+	tests := []struct {
+		name     string
+		secrets  map[string]string
+		wantAuth FabricAuth
+		wantEP   *endpoint.Portal
+		wantErr  bool
+	}{
+		{
+			name:   "no secrets",
+			wantEP: ep,
+		},
+		{
+			name:     "authentication keys",
+			secrets:  map[string]string{"dhchapKey": "DHHC-1:00:aG9zdA==:", "dhchapCtrlKey": "DHHC-1:00:Y3RybA==:"},
+			wantAuth: FabricAuth{HostKey: "DHHC-1:00:aG9zdA==:", CtrlKey: "DHHC-1:00:Y3RybA==:"},
+			wantEP:   ep,
+		},
+		{
+			name:    "controller key without host key",
+			secrets: map[string]string{"dhchapCtrlKey": "DHHC-1:00:Y3RybA==:"},
+			wantErr: true,
+		},
+		{
+			name:    "portal address",
+			secrets: map[string]string{"portal": "192.168.1.1"},
+			wantEP:  &endpoint.Portal{Address: "192.168.1.1", AddressFamily: "IPv4", Port: 4420, Transport: "rdma", NQN: "nqn.1"},
+		},
+		{
+			name:    "IPv6 portal with port",
+			secrets: map[string]string{"portal": "[fd00::1]:4421"},
+			wantEP:  &endpoint.Portal{Address: "fd00::1", AddressFamily: "IPv6", Port: 4421, Transport: "rdma", NQN: "nqn.1"},
+		},
+		{
+			name:    "portal host name",
+			secrets: map[string]string{"portal": "tenant-secret-portal:4420"},
+			wantErr: true,
+		},
+		{
+			name:    "portal invalid port",
+			secrets: map[string]string{"portal": "192.168.1.1:99999"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets, err := parseStageSecrets(tt.secrets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStageSecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				for _, value := range tt.secrets {
+					if strings.Contains(err.Error(), value) {
+						t.Errorf("error %q contains secret value", err)
+					}
+				}
+				return
+			}
+			if secrets.auth != tt.wantAuth {
+				t.Errorf("auth = %+v, want %+v", secrets.auth, tt.wantAuth)
+			}
+			if got := secrets.endPoint(ep); !reflect.DeepEqual(got, tt.wantEP) {
+				t.Errorf("endPoint() = %+v, want %+v", got, tt.wantEP)
+			}
+		})
+	}
+}
+
+func TestNVMeConnectAuth(t *testing.T) {
+	e := &fakeExecer{}
+	n := newNVMe(e, policy.Default())
+	n.listen = func() (deviceEvents, error) { return nil, errors.New("not supported") }
+	n.sysBlock = "/nonexistent"
+	auth := FabricAuth{HostKey: "DHHC-1:00:aG9zdA==:", CtrlKey: "DHHC-1:00:Y3RybA==:"}
+	connect := "nvme connect --transport rdma --traddr 10.0.0.1 --trsvcid 4420 --nqn nqn.1 --hostnqn nqn.host " +
+		"--dhchap-secret DHHC-1:00:aG9zdA==: --dhchap-ctrl-secret DHHC-1:00:Y3RybA==:"
+	e.failures = map[string]error{connect: errors.New("exit status 1")}
+
+	_, err := n.Connect(context.Background(), "rdma", "10.0.0.1", "IPv4", "4420", "nqn.1", "nqn.host", auth)
+	if err == nil {
+		t.Fatal("Connect() unexpected success")
+	}
+	if len(e.commands) != 1 || e.commands[0] != connect {
+		t.Errorf("commands = %v, want %q", e.commands, connect)
+	}
+	if strings.Contains(err.Error(), "aG9zdA") || strings.Contains(err.Error(), "Y3RybA") {
+		t.Errorf("error %q contains authentication keys", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+)
+
+// staleStaging returns why the staging of the staged volume is stale, empty
+// if it's healthy: the NVMe device of the volume is gone or belongs to
+// another subsystem, e.g. after a fabric reconnect gave the volume a new
+// device, or the staging path of a filesystem volume isn't mounted anymore.
+// The staging is considered healthy if the devices can't be listed.
+func (drv *Driver) staleStaging(volume *Volume) string {
+	devices, err := drv.nvme.List()
+	if err != nil {
+		drv.logger.Warning("can't list NVMe devices to check the staging", "volume", volume.Name, "error", err)
+		return ""
+	}
+	nqn, exists := devices[volume.Device]
+	if !exists {
+		return fmt.Sprintf("NVMe device %s of the volume is gone", volume.Device)
+	}
+	if volume.EndPoint != nil && volume.EndPoint.NQN != "" && nqn != volume.EndPoint.NQN {
+		return fmt.Sprintf("NVMe device %s belongs to another subsystem %s", volume.Device, nqn)
+	}
+	if volume.IsBlock {
+		return ""
+	}
+	mounted, err := drv.mounter.IsMounted("", volume.StagingTargetPath)
+	if err != nil {
+		drv.logger.Warning("can't check the staging path is mounted", "volume", volume.Name, "error", err)
+		return ""
+	}
+	if !mounted {
+		return fmt.Sprintf("staging path %s is not mounted", volume.StagingTargetPath)
+	}
+	return ""
+}
+
+// resetStaleStaging unmounts the stale staging path of the volume and
+// disconnects its device, so the volume is staged again from scratch.
+// Disconnect failures are only logged, as the device is usually gone.
+func (drv *Driver) resetStaleStaging(volume *Volume, reason string) error {
+	drv.logger.Warning("staging of the volume is stale, staging it again", "volume", volume.Name,
+		"volume_id", volume.CSIVolume.VolumeId, "staging_target_path", volume.StagingTargetPath, "reason", reason)
+
+	if !volume.IsBlock {
+		mounted, err := drv.mounter.IsMounted("", volume.StagingTargetPath)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			if err := drv.mounter.Unmount(volume.StagingTargetPath); err != nil {
+				return fmt.Errorf("can't unmount stale staging path %s: %v", volume.StagingTargetPath, err)
+			}
+		}
+	}
+	if volume.Device != "" {
+		if err := drv.nvme.Disconnect(volume.Device); err != nil {
+			drv.logger.Warning("can't disconnect stale NVMe device", "volume", volume.Name, "device", volume.Device, "error", err)
+		}
+	}
+
+	volume.Device = ""
+	volume.DeviceByID = ""
+	volume.FSUUID = ""
+	volume.IsBlock = false
+	volume.IsStaged = false
+	volume.StagingTargetPath = ""
+	return nil
+}
+
+// repairStaging stages the volume published to the target path again if its
+// staging is stale, e.g. after a fabric reconnect, instead of publishing
+// it from the stale mount. The stale target path mount is unmounted first.
+// The volume is staged again without the stage secrets, which aren't
+// passed to NodePublishVolume. It must be called with the volume locked.
+func (drv *Driver) repairStaging(ctx context.Context, req *csi.NodePublishVolumeRequest) error {
+	drv.volumesRWL.RLock()
+	name, vol := drv.findVolByID(req.VolumeId)
+	if name == "" || !vol.IsStaged || vol.StagingTargetPath != req.StagingTargetPath {
+		drv.volumesRWL.RUnlock()
+		return nil
+	}
+	staged := *vol
+	drv.volumesRWL.RUnlock()
+
+	reason := drv.staleStaging(&staged)
+	if reason == "" {
+		return nil
+	}
+	if err := drv.resetStaleStaging(&staged, reason); err != nil {
+		return err
+	}
+	mounted, err := drv.mounter.IsMounted("", req.TargetPath)
+	if err == nil && mounted {
+		err = drv.mounter.Unmount(req.TargetPath)
+	}
+	if err == nil {
+		err = drv.acquireStageSlot(ctx)
+	}
+	if err == nil {
+		if req.VolumeCapability.GetBlock() != nil {
+			err = drv.nodeStageBlockVolume(ctx, &staged, req.VolumeContext, req.StagingTargetPath, stageSecrets{})
+		} else {
+			mnt := req.VolumeCapability.GetMount()
+			err = drv.nodeStageVolume(ctx, &staged, drv.fsType(mnt.GetFsType()), req.VolumeContext, req.StagingTargetPath, mnt.GetMountFlags(), stageSecrets{})
+		}
+		drv.releaseStageSlot()
+	}
+	// the reset staging is stored even if staging failed, so the
+	// volume isn't published from the stale mount
+	drv.storeStaged(vol, &staged)
+	if err != nil {
+		return fmt.Errorf("can't stage volume %s(%s) again after stale staging (%s): %v", name, req.VolumeId, reason, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// trackingMounter keeps the mounts by target and records the unmounts
+type trackingMounter struct {
+	testMounter
+	mounts   map[string]string
+	unmounts []string
+}
+
+func (m *trackingMounter) Mount(source string, target string, fstype string, opts ...string) error {
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *trackingMounter) Unmount(target string) error {
+	delete(m.mounts, target)
+	m.unmounts = append(m.unmounts, target)
+	return nil
+}
+
+func (m *trackingMounter) IsMounted(source, target string) (bool, error) {
+	mounted, ok := m.mounts[target]
+	return ok && (source == "" || source == mounted), nil
+}
+
+func TestStaleStaging(t *testing.T) {
+	const nqn = "nqn.2014-08.org.nvmexpress:uuid:1"
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+	}
+	tests := []struct {
+		name        string
+		device      string
+		nqn         string
+		mounts      map[string]string
+		publish     bool
+		wantUnmount []string
+	}{
+		{name: "healthy", device: "/dev/nvme1n1", nqn: nqn, mounts: map[string]string{"/staging": "/dev/nvme1n1"}},
+		{name: "device gone", device: "/dev/nvme0n1", nqn: nqn, mounts: map[string]string{"/staging": "/dev/nvme0n1"}, wantUnmount: []string{"/staging"}},
+		{name: "device of another subsystem", device: "/dev/nvme1n1", nqn: "nqn.2014-08.org.nvmexpress:uuid:2",
+			mounts: map[string]string{"/staging": "/dev/nvme1n1"}, wantUnmount: []string{"/staging"}},
+		{name: "staging path not mounted", device: "/dev/nvme1n1", nqn: nqn, mounts: map[string]string{}},
+		{name: "publish healthy", device: "/dev/nvme1n1", nqn: nqn, publish: true,
+			mounts: map[string]string{"/staging": "/dev/nvme1n1", "/target": "/staging"}},
+		{name: "publish device gone", device: "/dev/nvme0n1", nqn: nqn, publish: true,
+			mounts: map[string]string{"/staging": "/dev/nvme0n1", "/target": "/staging"}, wantUnmount: []string{"/staging", "/target"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &trackingMounter{mounts: tt.mounts}
+			vol := &Volume{
+				Name:              "pvc-1",
+				CSIVolume:         &csi.Volume{VolumeId: "1"},
+				RSDVolume:         &rsd.Volume{},
+				EndPoint:          &endpoint.Portal{Transport: "rdma", Address: "192.168.1.1", Port: 4420, AddressFamily: "IPv4", NQN: tt.nqn},
+				IsPublished:       true,
+				IsStaged:          true,
+				Device:            tt.device,
+				StagingTargetPath: "/staging",
+				TargetPaths:       map[string]bool{},
+			}
+			drv := &Driver{
+				volumes: map[string]*Volume{"pvc-1": vol},
+				nvme:    &testNVMe{},
+				mounter: mounter,
+				logger:  NewLogger(0),
+			}
+
+			var err error
+			if tt.publish {
+				_, err = drv.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+					VolumeId: "1", StagingTargetPath: "/staging", TargetPath: "/target", VolumeCapability: capability,
+				})
+			} else {
+				_, err = drv.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+					VolumeId: "1", StagingTargetPath: "/staging", VolumeCapability: capability,
+				})
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(mounter.unmounts) != len(tt.wantUnmount) {
+				t.Fatalf("unmounted %v, want %v", mounter.unmounts, tt.wantUnmount)
+			}
+			for i, target := range tt.wantUnmount {
+				if mounter.unmounts[i] != target {
+					t.Errorf("unmounted %v, want %v", mounter.unmounts, tt.wantUnmount)
+				}
+			}
+			if !vol.IsStaged || vol.Device != "/dev/nvme1n1" {
+				t.Errorf("volume staged %v on the device %q, want staged on /dev/nvme1n1", vol.IsStaged, vol.Device)
+			}
+			if _, mounted := mounter.mounts["/staging"]; !mounted {
+				t.Errorf("staging path isn't mounted: %v", mounter.mounts)
+			}
+			if _, mounted := mounter.mounts["/target"]; tt.publish && (!mounted || !vol.TargetPaths["/target"]) {
+				t.Errorf("target path isn't mounted: %v", mounter.mounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+// SetStrictCapabilities makes the driver advertise only the capabilities
+// whose prerequisites are enabled, and fail their RPCs with Unimplemented
+// otherwise instead of serving partial answers, so the container
+// orchestrator can rely on the advertised capabilities
+func (drv *Driver) SetStrictCapabilities(enabled bool) {
+	drv.strictCapabilities = enabled
+}
+
+// volumeStatsAvailable checks if the node can serve GET_VOLUME_STATS. In the
+// strict mode it needs the node journal: without it the target paths of the
+// volumes are lost on the driver restart, so the stats of the published
+// volumes can't be found.
+func (drv *Driver) volumeStatsAvailable() bool {
+	return !drv.strictCapabilities || drv.journal != nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+// Backends mounting the staged and published volumes
+const (
+	// MountBackendMount runs mount(8) and umount(8)
+	MountBackendMount = "mount"
+	// MountBackendSystemd creates transient systemd mount units with systemd-mount(1),
+	// so the host service manager tracks the mounts instead of cleaning them up
+	MountBackendSystemd = "systemd"
+)
+
+// MountBackends returns the supported mount backends
+func MountBackends() []string {
+	return []string{MountBackendMount, MountBackendSystemd}
+}
+
+// SetMountBackend sets the backend mounting the volumes on the node
+func (drv *Driver) SetMountBackend(backend string) error {
+	switch backend {
+	case MountBackendMount, MountBackendSystemd:
+	default:
+		return fmt.Errorf("unsupported mount backend %q, supported backends: %v", backend, MountBackends())
+	}
+	drv.mountBackend = backend
+	drv.setTools()
+	return nil
+}
+
+// systemdMounter mounts volumes as transient systemd mount units. Checking
+// and formatting of the volumes is the same as the mounter one, as well as
+// bind-mounting of block volumes: systemd tracks them as mount units anyway.
+type systemdMounter struct {
+	*mounter
+}
+
+func newSystemdMounter(e Execer, policies policy.Policies) *systemdMounter {
+	return &systemdMounter{mounter: newMounter(e, policies)}
+}
+
+func (m *systemdMounter) Mount(source, target, fsType string, opts ...string) error {
+	if fsType == "" {
+		return errors.New("fs type is not specified for mounting the volume")
+	}
+
+	if source == "" {
+		return errors.New("source is not specified for mounting the volume")
+	}
+
+	if target == "" {
+		return errors.New("target is not specified for mounting the volume")
+	}
+
+	// --fsck=no: the volume is checked by mkfs or the previous stage, not by the unit
+	// --collect: unload the unit even if it failed, so the mount can be retried
+	mountArgs := []string{"--fsck=no", "--collect", "-t", fsType}
+
+	if len(opts) > 0 {
+		mountArgs = append(mountArgs, "-o", strings.Join(opts, ","))
+	}
+
+	mountArgs = append(mountArgs, source)
+	mountArgs = append(mountArgs, target)
+
+	// create target, os.Mkdirall is noop if it exists
+	err := os.MkdirAll(m.exec.HostPath(target), 0750)
+	if err != nil {
+		return err
+	}
+
+	out, err := m.exec.CombinedOutput("systemd-mount", mountArgs...)
+	if err != nil {
+		return fmt.Errorf("mounting failed: %v cmd: 'systemd-mount %s' output: %q", err, strings.Join(mountArgs, " "), string(out))
+	}
+
+	return nil
+}
+
+func (m *systemdMounter) Unmount(target string) error {
+	if target == "" {
+		return errors.New("target is not specified for unmounting the volume")
+	}
+
+	// stopping the mount unit unmounts the target
+	out, err := m.exec.CombinedOutput("systemd-mount", "--umount", target)
+	if err != nil {
+		return fmt.Errorf("unmounting failed: %v cmd: 'systemd-mount --umount %s' output: %q",
+			err, target, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// removeTarget removes the target directory or the block volume target file
+// left after unmounting the volume. It keeps symlinks, mount points, targets
+// which are not empty or owned by other user than the driver one, as they
+// are not created by the driver. It's noop if the target doesn't exist.
+func removeTarget(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return fmt.Errorf("%s is a symlink", path)
+	case info.IsDir():
+	case info.Mode().IsRegular():
+		if info.Size() != 0 {
+			return fmt.Errorf("%s is not empty", path)
+		}
+	default:
+		return fmt.Errorf("%s is neither a directory nor a regular file", path)
+	}
+
+	if owner, dev, ok := fileOwner(info); ok {
+		if owner != os.Geteuid() {
+			return fmt.Errorf("%s is owned by uid %d, not by the driver", path, owner)
+		}
+		parent, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if _, parentDev, ok := fileOwner(parent); ok && parentDev != dev {
+			return fmt.Errorf("%s is a mount point", path)
+		}
+	}
+
+	// os.Remove doesn't remove directories which are not empty
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	emptyDir := filepath.Join(dir, "empty")
+	usedDir := filepath.Join(dir, "used")
+	emptyFile := filepath.Join(dir, "block")
+	usedFile := filepath.Join(dir, "data")
+	symlink := filepath.Join(dir, "symlink")
+	for _, path := range []string{emptyDir, usedDir} {
+		if err := os.Mkdir(path, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(usedDir, "file"), []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(emptyFile, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(usedFile, []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(emptyDir, symlink); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+		removed bool
+	}{
+		{name: "Symlink", path: symlink, wantErr: true},
+		{name: "Empty directory", path: emptyDir, removed: true},
+		{name: "Directory not empty", path: usedDir, wantErr: true},
+		{name: "Empty file", path: emptyFile, removed: true},
+		{name: "File not empty", path: usedFile, wantErr: true},
+		{name: "Missing", path: filepath.Join(dir, "missing"), removed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := removeTarget(tt.path); (err != nil) != tt.wantErr {
+				t.Errorf("removeTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Lstat(tt.path); os.IsNotExist(err) != tt.removed {
+				t.Errorf("target removed %v, want %v", os.IsNotExist(err), tt.removed)
+			}
+		})
+	}
+
+	// content of the directory which is not empty is kept
+	if _, err := os.Stat(filepath.Join(dir, "used", "file")); err != nil {
+		t.Errorf("file of the directory not empty is removed: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"golang.org/x/net/context"
+)
+
+// TopologyStorageServiceKeyPrefix is the prefix of the topology keys of the
+// RSD storage services, e.g. csi.rsd.intel.com/storage-service-1. The key is
+// set to "true" in the topology of the nodes the storage service is reachable from.
+const TopologyStorageServiceKeyPrefix = DriverName + "/storage-service-"
+
+// topologyReachable is the value of the storage service topology keys
+const topologyReachable = "true"
+
+// SetTopology makes the node report the RSD storage services it can reach
+// as its topology, and the controller create the volumes in the storage
+// services reachable from the topology requested by the container orchestrator
+func (drv *Driver) SetTopology(enabled bool) {
+	drv.topology = enabled
+}
+
+// storageServiceOf returns Id of the storage service of the RSD volume
+// taken from its @odata.id, e.g. /redfish/v1/StorageServices/1/Volumes/2
+func storageServiceOf(rsdVolume *rsd.Volume) string {
+	parts := strings.Split(strings.Trim(rsdVolume.OdataID, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "StorageServices" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// volumeTopology returns the topology the RSD volume is accessible from,
+// nil if the topology is disabled
+func (drv *Driver) volumeTopology(rsdVolume *rsd.Volume) []*csi.Topology {
+	if !drv.topology || rsdVolume == nil {
+		return nil
+	}
+	service := storageServiceOf(rsdVolume)
+	if service == "" {
+		return nil
+	}
+	return []*csi.Topology{{Segments: map[string]string{TopologyStorageServiceKeyPrefix + service: topologyReachable}}}
+}
+
+// topologyServices returns Ids of the storage services reachable from the
+// topologies. It returns false if the topologies have no storage service
+// segments, i.e. they don't restrict the storage services.
+func topologyServices(topologies []*csi.Topology) (map[string]bool, bool) {
+	result := map[string]bool{}
+	restricted := false
+	for _, topology := range topologies {
+		for key, value := range topology.GetSegments() {
+			if !strings.HasPrefix(key, TopologyStorageServiceKeyPrefix) {
+				continue
+			}
+			restricted = true
+			if value == topologyReachable {
+				result[strings.TrimPrefix(key, TopologyStorageServiceKeyPrefix)] = true
+			}
+		}
+	}
+	return result, restricted
+}
+
+// filterStorageServices returns the storage services with the Ids
+func filterStorageServices(services []*rsd.StorageService, ids map[string]bool) []*rsd.StorageService {
+	var result []*rsd.StorageService
+	for _, service := range services {
+		if ids[service.ID] {
+			result = append(result, service)
+		}
+	}
+	return result
+}
+
+// accessibleStorageServices returns the storage services reachable from one
+// of the requisite topologies. Of them, the ones reachable from the first
+// preferred topology reaching any are returned, so the volumes of the pods
+// already scheduled to a node are accessible from it.
+func accessibleStorageServices(services []*rsd.StorageService, requirement *csi.TopologyRequirement) ([]*rsd.StorageService, error) {
+	if requirement == nil {
+		return services, nil
+	}
+	if requisite, restricted := topologyServices(requirement.Requisite); restricted {
+		services = filterStorageServices(services, requisite)
+		if len(services) == 0 {
+			return nil, fmt.Errorf("no RSD storage service is reachable from the requisite topology")
+		}
+	}
+	for _, topology := range requirement.Preferred {
+		preferred, restricted := topologyServices([]*csi.Topology{topology})
+		if !restricted {
+			continue
+		}
+		if result := filterStorageServices(services, preferred); len(result) > 0 {
+			return result, nil
+		}
+	}
+	return services, nil
+}
+
+// reachableStorageServices returns the storage services the node can connect
+// to: the ones with endpoints of a transport the node can use. All services are
+// reachable if the node transports are not checked, and so are the services
+// which don't report their endpoints.
+func (drv *Driver) reachableStorageServices(ctx context.Context) ([]*rsd.StorageService, error) {
+	services, err := drv.listStorageServices(ctx)
+	if err != nil || drv.transports == nil {
+		return services, err
+	}
+
+	client := drv.client(ctx)
+	var result []*rsd.StorageService
+	for _, service := range services {
+		endPoints, err := service.GetEndPoints(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		transports := endpoint.EndPointTransports(endPoints)
+		reachable := len(transports) == 0
+		for _, transport := range transports {
+			if err, probed := drv.transports[transport]; probed && err == nil && drv.isPreferredTransport(transport) {
+				reachable = true
+			}
+		}
+		if reachable {
+			result = append(result, service)
+		}
+	}
+	return result, nil
+}
+
+// nodeTopology returns the topology of the node with the segments of the
+// storage services reachable from it
+func (drv *Driver) nodeTopology(ctx context.Context) (*csi.Topology, error) {
+	services, err := drv.reachableStorageServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	segments := map[string]string{}
+	for _, service := range services {
+		segments[TopologyStorageServiceKeyPrefix+service.ID] = topologyReachable
+	}
+	return &csi.Topology{Segments: segments}, nil
+}
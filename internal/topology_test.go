@@ -0,0 +1,169 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// serviceTopology returns topology reaching the storage services
+func serviceTopology(ids ...string) *csi.Topology {
+	topology := &csi.Topology{Segments: map[string]string{"kubernetes.io/hostname": "node"}}
+	for _, id := range ids {
+		topology.Segments[TopologyStorageServiceKeyPrefix+id] = "true"
+	}
+	return topology
+}
+
+func TestSelectStorageServiceTopology(t *testing.T) {
+	tests := []struct {
+		name        string
+		requirement *csi.TopologyRequirement
+		wantService string
+		wantErr     bool
+	}{
+		{name: "no requirement", wantService: "2"},
+		{name: "requisite", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{serviceTopology("1")}}, wantService: "1"},
+		{name: "requisite of both", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{serviceTopology("1"), serviceTopology("2")}}, wantService: "2"},
+		{
+			name: "preferred",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{serviceTopology("1", "2")},
+				Preferred: []*csi.Topology{serviceTopology("1"), serviceTopology("2")},
+			},
+			wantService: "1",
+		},
+		{
+			name: "preferred not requisite",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{serviceTopology("2")},
+				Preferred: []*csi.Topology{serviceTopology("1"), serviceTopology("2")},
+			},
+			wantService: "2",
+		},
+		{name: "other topology keys", requirement: &csi.TopologyRequirement{Preferred: []*csi.Topology{serviceTopology()}}, wantService: "2"},
+		{name: "unreachable", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{serviceTopology("3")}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{rsdClient: twoServicesClient()}
+			got, err := drv.selectStorageService(context.Background(), &volumeProvisioning{accessibility: tt.requirement}, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectStorageService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != tt.wantService {
+				t.Errorf("selectStorageService() = %s, want %s", got.ID, tt.wantService)
+			}
+		})
+	}
+}
+
+func TestGetCapacityTopology(t *testing.T) {
+	drv := &Driver{rsdClient: twoServicesClient()}
+	resp, err := drv.GetCapacity(context.Background(), &csi.GetCapacityRequest{AccessibleTopology: serviceTopology("2")})
+	if err != nil {
+		t.Fatalf("GetCapacity() unexpected error: %v", err)
+	}
+	if resp.AvailableCapacity != 2500 {
+		t.Errorf("GetCapacity() = %d, want 2500 of the reachable storage service", resp.AvailableCapacity)
+	}
+}
+
+func TestCreateVolumeTopology(t *testing.T) {
+	client := twoServicesClient()
+	client.results["/redfish/v1/StorageServices/1/Volumes/1"] = `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 100}`
+	drv := &Driver{rsdClient: client, volumes: map[string]*Volume{}}
+	drv.SetTopology(true)
+
+	req := &csi.CreateVolumeRequest{
+		Name:          "pvc-1",
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 100},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		AccessibilityRequirements: &csi.TopologyRequirement{Preferred: []*csi.Topology{serviceTopology("1")}},
+	}
+	want := []*csi.Topology{{Segments: map[string]string{TopologyStorageServiceKeyPrefix + "1": "true"}}}
+	for i := 0; i < 2; i++ {
+		resp, err := drv.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateVolume() unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(resp.Volume.AccessibleTopology, want) {
+			t.Errorf("CreateVolume() accessible topology = %v, want %v", resp.Volume.AccessibleTopology, want)
+		}
+	}
+
+	caps, err := drv.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginCapabilities() unexpected error: %v", err)
+	}
+	advertised := false
+	for _, capability := range caps.Capabilities {
+		if capability.GetService().GetType() == csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+			advertised = true
+		}
+	}
+	if !advertised {
+		t.Errorf("GetPluginCapabilities() = %v, want VOLUME_ACCESSIBILITY_CONSTRAINTS", caps.Capabilities)
+	}
+}
+
+func TestNodeGetInfoTopology(t *testing.T) {
+	client := &TestClient{
+		results: map[string]string{
+			"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}, {"@odata.id": "/redfish/v1/StorageServices/2"}, {"@odata.id": "/redfish/v1/StorageServices/3"}]}`,
+			"/redfish/v1/StorageServices/1":           `{"Id": "1", "Endpoints": {"@odata.id": "/redfish/v1/StorageServices/1/Endpoints"}}`,
+			"/redfish/v1/StorageServices/1/Endpoints": `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/1"}]}`,
+			"/redfish/v1/Fabrics/1/Endpoints/1":       `{"IPTransportDetails": [{"TransportProtocol": "RoCEv2"}]}`,
+			"/redfish/v1/StorageServices/2":           `{"Id": "2", "Endpoints": {"@odata.id": "/redfish/v1/StorageServices/2/Endpoints"}}`,
+			"/redfish/v1/StorageServices/2/Endpoints": `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/2"}]}`,
+			"/redfish/v1/Fabrics/1/Endpoints/2":       `{"IPTransportDetails": [{"TransportProtocol": "NVMeOverFabrics"}, {"TransportProtocol": "TCP"}]}`,
+			"/redfish/v1/StorageServices/3":           `{"Id": "3"}`,
+		},
+	}
+	drv := &Driver{RSDNodeID: "1", rsdClient: client}
+	drv.SetTopology(true)
+
+	tests := []struct {
+		name       string
+		transports nodeTransports
+		want       []string
+	}{
+		{name: "transports not checked", want: []string{"1", "2", "3"}},
+		{name: "rdma unavailable", transports: nodeTransports{"rdma": fmt.Errorf("no RDMA devices"), "tcp": nil}, want: []string{"2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv.transports = tt.transports
+			resp, err := drv.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("NodeGetInfo() unexpected error: %v", err)
+			}
+			want := map[string]string{}
+			for _, id := range tt.want {
+				want[TopologyStorageServiceKeyPrefix+id] = "true"
+			}
+			if !reflect.DeepEqual(resp.AccessibleTopology.GetSegments(), want) {
+				t.Errorf("NodeGetInfo() topology = %v, want %v", resp.AccessibleTopology.GetSegments(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"golang.org/x/net/context"
+)
+
+// Modes of checking that the node can use the transports of the published volumes
+const (
+	// TransportCheckFail fails publishing of the volumes the node can't connect
+	TransportCheckFail = "fail"
+	// TransportCheckWarn only logs a warning when publishing such volumes
+	TransportCheckWarn = "warn"
+	// TransportCheckOff disables probing of the node transports
+	TransportCheckOff = "off"
+)
+
+// nodeTransports maps NVMe-oF transports to the reason the node can't use
+// them, which is nil for the usable transports
+type nodeTransports map[string]error
+
+// probeTransports checks kernel modules of the NVMe-oF transports and the RDMA devices
+func probeTransports(moduleDir, infinibandDir string) nodeTransports {
+	transports := nodeTransports{}
+	for transport, module := range transportModules {
+		transports[transport] = checkModule(moduleDir, module)
+	}
+	if transports["rdma"] == nil {
+		if _, err := rdmaDevices(infinibandDir); err != nil {
+			transports["rdma"] = err
+		}
+	}
+	return transports
+}
+
+func (transports nodeTransports) String() string {
+	var names []string
+	for transport := range transports {
+		names = append(names, transport)
+	}
+	sort.Strings(names)
+
+	var result []string
+	for _, transport := range names {
+		if err := transports[transport]; err != nil {
+			result = append(result, fmt.Sprintf("%s unavailable (%v)", transport, err))
+		} else {
+			result = append(result, transport+" available")
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+// TransportCheckModes returns the supported modes of the transport check
+func TransportCheckModes() []string {
+	return []string{TransportCheckFail, TransportCheckWarn, TransportCheckOff}
+}
+
+// SetTransportCheck probes NVMe-oF transports the node can use, unless the
+// mode is TransportCheckOff, and sets how publishing of volumes with endpoints
+// of other transports is handled
+func (drv *Driver) SetTransportCheck(mode string) error {
+	switch mode {
+	case TransportCheckOff:
+		drv.transports = nil
+		return nil
+	case TransportCheckFail, TransportCheckWarn:
+	default:
+		return fmt.Errorf("unsupported transport check mode %q, supported modes: %v", mode, TransportCheckModes())
+	}
+
+	drv.transports = probeTransports(sysModule, sysInfiniband)
+	drv.transportCheckWarn = mode == TransportCheckWarn
+	drv.logger.V(LogLevelState).Info("NVMe-oF transports of the node", "transports", drv.transports)
+	return nil
+}
+
+// SetTransportPreference sets NVMe-oF transports of the volume portals in the
+// order of preference, e.g. tcp before rdma. Portals of other transports are
+// not used. Portals of all supported transports are used in the order reported
+// by RSD if the list is empty.
+func (drv *Driver) SetTransportPreference(transports []string) error {
+	supported := map[string]bool{}
+	for _, transport := range endpoint.Transports() {
+		supported[transport] = true
+	}
+	var preference []string
+	seen := map[string]bool{}
+	for _, transport := range transports {
+		transport = strings.ToLower(strings.TrimSpace(transport))
+		if !supported[transport] {
+			return fmt.Errorf("unsupported NVMe-oF transport %q, supported transports: %v", transport, endpoint.Transports())
+		}
+		if !seen[transport] {
+			seen[transport] = true
+			preference = append(preference, transport)
+		}
+	}
+	drv.transportPreference = preference
+	return nil
+}
+
+// checkNodeTransports returns an error naming the missing node capabilities
+// if the node can't use any transport of the volume endpoints. The endpoints
+// of the volumes not attached yet may be unknown, the volume is then expected
+// to be connected with any of the transports supported by the driver.
+// Only the preferred transports are checked if the preference is set, only
+// the override transport if it's not empty.
+func (drv *Driver) checkNodeTransports(ctx context.Context, volume *Volume, override string) error {
+	if drv.transports == nil || volume.IsPublished {
+		return nil
+	}
+
+	candidates := map[string]bool{}
+	if override != "" {
+		candidates[override] = true
+	} else if len(volume.RSDVolume.Links.Oem.IntelRackScale.Endpoints) > 0 {
+		endPoints, err := volume.RSDVolume.GetEndPoints(ctx, drv.client(ctx))
+		if err != nil {
+			drv.requestLogger(ctx).Warning("can't check transports of the volume endpoints", "volume", volume.Name, "error", err)
+		}
+		for _, transport := range endpoint.EndPointTransports(endPoints) {
+			if drv.isPreferredTransport(transport) {
+				candidates[transport] = true
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		defaults := drv.transportPreference
+		if len(defaults) == 0 {
+			defaults = endpoint.Transports()
+		}
+		for _, transport := range defaults {
+			candidates[transport] = true
+		}
+	}
+
+	var names []string
+	for transport := range candidates {
+		names = append(names, transport)
+	}
+	sort.Strings(names)
+
+	var missing []string
+	for _, transport := range names {
+		err, probed := drv.transports[transport]
+		if !probed {
+			err = fmt.Errorf("not probed")
+		}
+		if err == nil {
+			return nil
+		}
+		missing = append(missing, fmt.Sprintf("%s: %v", transport, err))
+	}
+
+	err := fmt.Errorf("node lacks NVMe-oF transport of the volume endpoints: %s", strings.Join(missing, "; "))
+	if drv.transportCheckWarn {
+		drv.requestLogger(ctx).Warning("node lacks NVMe-oF transport of the volume endpoints", "volume", volume.Name, "error", err)
+		return nil
+	}
+	return err
+}
+
+// isPreferredTransport returns true if the portals of the transport are used
+func (drv *Driver) isPreferredTransport(transport string) bool {
+	if len(drv.transportPreference) == 0 {
+		return true
+	}
+	for _, preferred := range drv.transportPreference {
+		if preferred == transport {
+			return true
+		}
+	}
+	return false
+}
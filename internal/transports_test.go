@@ -0,0 +1,154 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProbeTransports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-transports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	moduleDir := filepath.Join(dir, "module")
+	infinibandDir := filepath.Join(dir, "infiniband")
+	for _, path := range []string{filepath.Join(moduleDir, "nvme_rdma"), filepath.Join(moduleDir, "nvme_tcp"), infinibandDir} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transports := probeTransports(moduleDir, infinibandDir)
+	if transports["tcp"] != nil {
+		t.Errorf("tcp is unavailable: %v", transports["tcp"])
+	}
+	if err := transports["rdma"]; err == nil || !strings.Contains(err.Error(), "no RDMA devices") {
+		t.Errorf("rdma error = %v, want no RDMA devices", err)
+	}
+
+	if err := os.Mkdir(filepath.Join(infinibandDir, "mlx5_0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(moduleDir, "nvme_tcp")); err != nil {
+		t.Fatal(err)
+	}
+	transports = probeTransports(moduleDir, infinibandDir)
+	if got, want := transports.String(), "rdma available, tcp unavailable (kernel module nvme_tcp is not loaded)"; got != want {
+		t.Errorf("probed transports %q, want %q", got, want)
+	}
+}
+
+func TestPublishVolumeMissingTransport(t *testing.T) {
+	client := &TestClient{results: map[string]string{
+		"/redfish/v1/Fabrics/1/Endpoints/tcp":  `{"Id": "tcp", "IPTransportDetails": [{"TransportProtocol": "TCP"}]}`,
+		"/redfish/v1/Fabrics/1/Endpoints/roce": `{"Id": "roce", "IPTransportDetails": [{"TransportProtocol": "RoCEv2"}]}`,
+	}}
+	newVolume := func(endPoints ...string) *Volume {
+		var links []map[string]string
+		for _, endPoint := range endPoints {
+			links = append(links, map[string]string{"@odata.id": "/redfish/v1/Fabrics/1/Endpoints/" + endPoint})
+		}
+		content, err := json.Marshal(map[string]interface{}{
+			"Id":    "1",
+			"Links": map[string]interface{}{"Oem": map[string]interface{}{"Intel_RackScale": map[string]interface{}{"Endpoints": links}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rsdVolume rsd.Volume
+		if err := json.Unmarshal(content, &rsdVolume); err != nil {
+			t.Fatal(err)
+		}
+		return newVolumeRecord("pvc-1", &rsdVolume)
+	}
+	noRDMA := nodeTransports{"rdma": errors.New("no RDMA devices found"), "tcp": nil}
+
+	drv := &Driver{
+		RSDNodeID:  "1",
+		rsdClient:  client,
+		volumes:    map[string]*Volume{"pvc-1": newVolume()},
+		transports: nodeTransports{"rdma": errors.New("no RDMA devices found"), "tcp": errors.New("kernel module nvme_tcp is not loaded")},
+	}
+	_, err := drv.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "1",
+		NodeId:   "1",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "rdma: no RDMA devices found") {
+		t.Errorf("ControllerPublishVolume() error = %v, want FailedPrecondition naming missing rdma", err)
+	}
+
+	tests := []struct {
+		name       string
+		volume     *Volume
+		transports nodeTransports
+		preference []string
+		override   string
+		warn       bool
+		wantErr    bool
+	}{
+		{name: "Not probed", volume: newVolume("roce")},
+		{name: "RoCE endpoint without RDMA", volume: newVolume("roce"), transports: noRDMA, wantErr: true},
+		{name: "RoCE endpoint with RDMA", volume: newVolume("roce"), transports: nodeTransports{"rdma": nil}},
+		{name: "TCP endpoint without RDMA", volume: newVolume("tcp"), transports: noRDMA},
+		{name: "Mixed endpoints without RDMA", volume: newVolume("roce", "tcp"), transports: noRDMA},
+		{name: "Mixed endpoints preferring RDMA", volume: newVolume("roce", "tcp"), transports: noRDMA, preference: []string{"rdma"}, wantErr: true},
+		{name: "Unknown endpoints preferring RDMA", volume: newVolume(), transports: noRDMA, preference: []string{"rdma"}, wantErr: true},
+		{name: "Warning only", volume: newVolume("roce"), transports: noRDMA, warn: true},
+		{name: "RoCE endpoint overridden by TCP", volume: newVolume("roce"), transports: noRDMA, override: "tcp"},
+		{name: "TCP endpoint overridden by RoCE", volume: newVolume("tcp"), transports: noRDMA, override: "rdma", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &Driver{rsdClient: client, transports: tt.transports, transportPreference: tt.preference, transportCheckWarn: tt.warn}
+			if err := drv.checkNodeTransports(context.Background(), tt.volume, tt.override); (err != nil) != tt.wantErr {
+				t.Errorf("checkNodeTransports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetTransportPreference(t *testing.T) {
+	drv := &Driver{}
+	if err := drv.SetTransportPreference([]string{"TCP", " rdma", "tcp"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tcp", "rdma"}; !reflect.DeepEqual(drv.transportPreference, want) {
+		t.Errorf("transport preference %v, want %v", drv.transportPreference, want)
+	}
+	if err := drv.SetTransportPreference([]string{"fc"}); err == nil {
+		t.Error("SetTransportPreference() accepted unsupported transport")
+	}
+	if err := drv.SetTransportPreference(nil); err != nil || drv.transportPreference != nil {
+		t.Errorf("SetTransportPreference(nil) = %v, preference %v", err, drv.transportPreference)
+	}
+}
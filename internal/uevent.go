@@ -0,0 +1,71 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// nvmeDiskRegexp matches names of the NVMe namespace block devices, e.g. nvme1n1.
+// Hidden paths of multipath namespaces, e.g. nvme0c1n1, are not matched.
+var nvmeDiskRegexp = regexp.MustCompile(`^nvme[0-9]+n[0-9]+$`)
+
+// deviceEvents notifies about NVMe disks added to the node
+type deviceEvents interface {
+	// wait waits until an NVMe disk is added or the timeout expires.
+	// It returns false on timeout.
+	wait(timeout time.Duration) bool
+	// Close stops listening to the events
+	Close() error
+}
+
+// uevent is a kernel device event
+type uevent struct {
+	action    string
+	subsystem string
+	devType   string
+	devName   string
+}
+
+// parseUevent parses kernel uevent message, e.g.
+// "add@/devices/...\x00ACTION=add\x00SUBSYSTEM=block\x00DEVTYPE=disk\x00DEVNAME=nvme1n1\x00"
+func parseUevent(msg []byte) uevent {
+	var event uevent
+	for _, field := range strings.Split(string(msg), "\x00") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "ACTION":
+			event.action = parts[1]
+		case "SUBSYSTEM":
+			event.subsystem = parts[1]
+		case "DEVTYPE":
+			event.devType = parts[1]
+		case "DEVNAME":
+			event.devName = parts[1]
+		}
+	}
+	return event
+}
+
+// isNVMeDiskAdded returns true if the event reports added NVMe namespace
+func (event uevent) isNVMeDiskAdded() bool {
+	return event.action == "add" && event.subsystem == "block" && event.devType == "disk" &&
+		nvmeDiskRegexp.MatchString(strings.TrimPrefix(event.devName, "/dev/"))
+}
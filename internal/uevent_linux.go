@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"syscall"
+	"time"
+)
+
+const (
+	// ueventKernelGroup is the netlink multicast group of the kernel uevents
+	ueventKernelGroup = 1
+	// ueventBufferSize is enough for the largest uevent message
+	ueventBufferSize = 16 * 1024
+)
+
+// ueventSocket receives kernel uevents from the netlink socket
+type ueventSocket struct {
+	fd  int
+	buf []byte
+}
+
+// listenUevents starts listening to the kernel uevents. The driver must
+// run in the host network namespace to receive them.
+func listenUevents() (deviceEvents, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventKernelGroup})
+	if err != nil {
+		syscall.Close(fd) // nolint: errcheck
+		return nil, err
+	}
+	return &ueventSocket{fd: fd, buf: make([]byte, ueventBufferSize)}, nil
+}
+
+func (s *ueventSocket) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		// zero timeout would block forever
+		if remaining < time.Millisecond {
+			remaining = time.Millisecond
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			time.Sleep(remaining)
+			return false
+		}
+		n, _, err := syscall.Recvfrom(s.fd, s.buf, 0)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			return false
+		case err == syscall.ENOBUFS:
+			// events are lost, the devices must be looked up again
+			return true
+		case err != nil:
+			time.Sleep(time.Until(deadline))
+			return false
+		case parseUevent(s.buf[:n]).isNVMeDiskAdded():
+			return true
+		}
+	}
+}
+
+func (s *ueventSocket) Close() error {
+	return syscall.Close(s.fd)
+}
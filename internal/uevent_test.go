@@ -0,0 +1,63 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUevent(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   []string
+		added bool
+	}{
+		{
+			name:  "NVMe disk added",
+			msg:   []string{"add@/devices/virtual/nvme-fabrics/ctl/nvme1/nvme1n1", "ACTION=add", "SUBSYSTEM=block", "DEVNAME=nvme1n1", "DEVTYPE=disk", "SEQNUM=4242"},
+			added: true,
+		},
+		{
+			name: "NVMe disk removed",
+			msg:  []string{"remove@/devices/virtual/nvme-fabrics/ctl/nvme1/nvme1n1", "ACTION=remove", "SUBSYSTEM=block", "DEVNAME=nvme1n1", "DEVTYPE=disk"},
+		},
+		{
+			name: "NVMe partition added",
+			msg:  []string{"add@/devices/virtual/nvme-fabrics/ctl/nvme1/nvme1n1/nvme1n1p1", "ACTION=add", "SUBSYSTEM=block", "DEVNAME=nvme1n1p1", "DEVTYPE=partition"},
+		},
+		{
+			name: "hidden multipath path added",
+			msg:  []string{"add@/devices/virtual/nvme-fabrics/ctl/nvme1/nvme1c1n1", "ACTION=add", "SUBSYSTEM=block", "DEVNAME=nvme1c1n1", "DEVTYPE=disk"},
+		},
+		{
+			name: "NVMe controller added",
+			msg:  []string{"add@/devices/virtual/nvme-fabrics/ctl/nvme1", "ACTION=add", "SUBSYSTEM=nvme", "DEVNAME=nvme1"},
+		},
+		{
+			name: "other disk added",
+			msg:  []string{"add@/devices/virtual/block/loop0", "ACTION=add", "SUBSYSTEM=block", "DEVNAME=loop0", "DEVTYPE=disk"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseUevent([]byte(strings.Join(tt.msg, "\x00") + "\x00"))
+			if added := event.isNVMeDiskAdded(); added != tt.added {
+				t.Errorf("isNVMeDiskAdded() = %v, want %v for %+v", added, tt.added, event)
+			}
+		})
+	}
+}
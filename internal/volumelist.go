@@ -0,0 +1,140 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+)
+
+// maxVolumeLists is the number of the latest paged volume list snapshots
+// kept for the ListVolumes pages requested with their starting tokens
+const maxVolumeLists = 8
+
+// listTokenSeparator separates the ID of the volume list snapshot from the
+// index of the first volume in ListVolumes tokens, e.g. 42.100
+const listTokenSeparator = "."
+
+// volumeList is an immutable snapshot of the CSI volumes listed by
+// ListVolumes sorted by name. The CSI volumes are copies, so the snapshot
+// can be paged through while the volume records change.
+type volumeList struct {
+	id      uint64
+	volumes []*csi.Volume
+}
+
+// volumeLists are the latest snapshots of the paged volume listings
+type volumeLists struct {
+	mu     sync.Mutex
+	lastID uint64
+	lists  []*volumeList
+}
+
+// find returns the snapshot with the ID, nil if it's not kept
+func (l *volumeLists) find(id uint64) *volumeList {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, list := range l.lists {
+		if list.id == id {
+			return list
+		}
+	}
+	return nil
+}
+
+// keep assigns an ID to the snapshot of a paged listing and keeps it for
+// the next pages, the oldest one is dropped if there are more than
+// maxVolumeLists snapshots
+func (l *volumeLists) keep(list *volumeList) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if list.id != 0 {
+		return
+	}
+	l.lastID++
+	list.id = l.lastID
+	l.lists = append(l.lists, list)
+	if len(l.lists) > maxVolumeLists {
+		l.lists = l.lists[len(l.lists)-maxVolumeLists:]
+	}
+}
+
+// listCSIVolumes returns a new snapshot of the volumes, so every listing
+// starts with their current state
+func (drv *Driver) listCSIVolumes() *volumeList {
+	drv.volumesRWL.RLock()
+	defer drv.volumesRWL.RUnlock()
+
+	// sort volume names
+	keys := make([]string, 0, len(drv.volumes))
+	for k := range drv.volumes {
+		if strings.HasPrefix(k, drv.VolumeNamePrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	// collect copies of CSI volumes ordered by name
+	list := &volumeList{volumes: []*csi.Volume{}}
+	for _, k := range keys {
+		list.volumes = append(list.volumes, proto.Clone(drv.volumes[k].CSIVolume).(*csi.Volume))
+	}
+	return list
+}
+
+// volumeListPage returns the snapshot the ListVolumes starting token refers
+// to and the index of the first volume of the page. The listing starts with
+// a new snapshot without the token. Plain indexes of the tokens issued by
+// the previous driver versions are applied to a new snapshot.
+func (drv *Driver) volumeListPage(token string) (*volumeList, int, error) {
+	if token == "" {
+		return drv.listCSIVolumes(), 0, nil
+	}
+	separator := strings.Index(token, listTokenSeparator)
+	if separator < 0 {
+		index, err := strconv.Atoi(token)
+		if err != nil {
+			return nil, 0, fmt.Errorf("can't convert startingToken %s into int32: %v", token, err)
+		}
+		return drv.listCSIVolumes(), index, nil
+	}
+
+	id, err := strconv.ParseUint(token[:separator], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot of startingToken %s: %v", token, err)
+	}
+	index, err := strconv.Atoi(token[separator+len(listTokenSeparator):])
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't convert startingToken %s into int32: %v", token, err)
+	}
+	list := drv.volumeLists.find(id)
+	if list == nil {
+		return nil, 0, fmt.Errorf("volumes listed with startingToken %s are not kept anymore, as too many listings were paged meanwhile, the listing must be restarted", token)
+	}
+	return list, index, nil
+}
+
+// nextToken keeps the snapshot for the next pages and returns the
+// ListVolumes token of the page starting with the volume at the index
+func (drv *Driver) nextToken(list *volumeList, index int) string {
+	drv.volumeLists.keep(list)
+	return strconv.FormatUint(list.id, 10) + listTokenSeparator + strconv.Itoa(index)
+}
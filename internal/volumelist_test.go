@@ -0,0 +1,194 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// listTestVolume returns the volume record of the RSD volume with the ID
+func listTestVolume(name, id string) *Volume {
+	return newVolumeRecord(name, &rsd.Volume{ID: id, OdataID: "/redfish/v1/StorageServices/1/Volumes/" + id})
+}
+
+// listAllVolumes pages through all the volumes and returns their names
+func listAllVolumes(drv *Driver, maxEntries int32) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		resp, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: maxEntries, StartingToken: token})
+		if err != nil {
+			return names, err
+		}
+		for _, entry := range resp.Entries {
+			names = append(names, entry.Volume.VolumeContext["name"])
+		}
+		if resp.NextToken == "" {
+			return names, nil
+		}
+		token = resp.NextToken
+	}
+}
+
+func TestListVolumesSnapshot(t *testing.T) {
+	drv := &Driver{rsdClient: &TestClient{}, volumes: map[string]*Volume{}}
+	for i, name := range []string{"pvc-b", "pvc-c", "pvc-d"} {
+		drv.storeCreated(context.Background(), listTestVolume(name, fmt.Sprint(i+1)), 0)
+	}
+
+	first, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("ListVolumes() failed: %v", err)
+	}
+	if len(first.Entries) != 2 || first.NextToken == "" {
+		t.Fatalf("ListVolumes() = %v, want first 2 volumes and next token", first)
+	}
+
+	// volumes created and deleted meanwhile don't shift the next page
+	drv.storeCreated(context.Background(), listTestVolume("pvc-a", "4"), 0)
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "3"}); err != nil {
+		t.Fatalf("DeleteVolume() failed: %v", err)
+	}
+	drv.volumes["pvc-b"].CSIVolume.CapacityBytes = 100
+	if first.Entries[0].Volume.CapacityBytes != 0 {
+		t.Error("listed volume changed with its record")
+	}
+	next, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: first.NextToken})
+	if err != nil {
+		t.Fatalf("ListVolumes(%s) failed: %v", first.NextToken, err)
+	}
+	if len(next.Entries) != 1 || next.Entries[0].Volume.VolumeId != "3" || next.NextToken != "" {
+		t.Errorf("ListVolumes(%s) = %v, want the last volume of the snapshot", first.NextToken, next)
+	}
+
+	names, err := listAllVolumes(drv, 0)
+	if err != nil {
+		t.Fatalf("ListVolumes() failed: %v", err)
+	}
+	if fmt.Sprint(names) != "[pvc-a pvc-b pvc-c]" {
+		t.Errorf("listed volumes = %v, want the current volumes", names)
+	}
+
+	// new listings see the capacity changed in place, e.g. by the resync
+	resp, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("ListVolumes() failed: %v", err)
+	}
+	if resp.Entries[1].Volume.CapacityBytes != 100 {
+		t.Errorf("listed capacity = %d, want the current capacity 100", resp.Entries[1].Volume.CapacityBytes)
+	}
+	if len(drv.volumeLists.lists) != 1 {
+		t.Errorf("%d snapshots are kept, want only the paged one", len(drv.volumeLists.lists))
+	}
+}
+
+func TestListVolumesExpiredToken(t *testing.T) {
+	drv := &Driver{volumes: map[string]*Volume{}}
+	drv.storeCreated(context.Background(), listTestVolume("pvc-a", "1"), 0)
+	drv.storeCreated(context.Background(), listTestVolume("pvc-b", "2"), 0)
+	first, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 1})
+	if err != nil {
+		t.Fatalf("ListVolumes() failed: %v", err)
+	}
+
+	// later paged listings drop the snapshot of the token
+	for i := 0; i < maxVolumeLists; i++ {
+		drv.storeCreated(context.Background(), listTestVolume(fmt.Sprintf("pvc-c%d", i), fmt.Sprint(i+3)), 0)
+		if _, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 1}); err != nil {
+			t.Fatalf("ListVolumes() failed: %v", err)
+		}
+	}
+	for _, token := range []string{first.NextToken, "x.1", "1.x", "x", "-1"} {
+		_, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: token})
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("ListVolumes(%s) error = %v, want Aborted", token, err)
+		}
+	}
+
+	// plain indexes of the previous versions are applied to the current volumes
+	resp, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: "9"})
+	if err != nil || len(resp.Entries) != 1 {
+		t.Errorf("ListVolumes(9) = %v, %v, want the last volume", resp, err)
+	}
+}
+
+func TestListVolumesConcurrent(t *testing.T) {
+	drv := &Driver{rsdClient: &TestClient{}, volumes: map[string]*Volume{}}
+	for i := 0; i < 20; i++ {
+		drv.storeCreated(context.Background(), listTestVolume(fmt.Sprintf("pvc-%03d", i), fmt.Sprint(i)), 0)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 20; i < 60; i++ {
+			drv.storeCreated(context.Background(), listTestVolume(fmt.Sprintf("pvc-%03d", i), fmt.Sprint(i)), 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: fmt.Sprint(i)}); err != nil {
+				t.Errorf("DeleteVolume(%d) failed: %v", i, err)
+			}
+		}
+	}()
+
+	// every listing is a consistent snapshot: sorted without duplicates,
+	// unless too many listings were paged meanwhile. The volumes are
+	// dumped meanwhile as well.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		drv.dumpVolumes()
+		names, err := listAllVolumes(drv, 3)
+		if status.Code(err) == codes.Aborted {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ListVolumes() failed: %v", err)
+		}
+		for i := 1; i < len(names); i++ {
+			if names[i-1] >= names[i] {
+				t.Fatalf("listed volumes %v are not sorted or contain duplicates", names)
+			}
+		}
+	}
+
+	names, err := listAllVolumes(drv, 7)
+	if err != nil {
+		t.Fatalf("ListVolumes() failed: %v", err)
+	}
+	if len(names) != 40 || names[0] != "pvc-020" {
+		t.Errorf("listed volumes = %v, want pvc-020 to pvc-059", names)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// inFlightOperation is a CSI operation of a volume in progress
+type inFlightOperation struct {
+	name    string
+	started time.Time
+}
+
+// operationPendingError is returned for an operation of the volume
+// started while another operation of the volume is in progress
+type operationPendingError struct {
+	key       string
+	operation inFlightOperation
+}
+
+func (err *operationPendingError) Error() string {
+	return fmt.Sprintf("another operation of the volume %s is in progress: %s started %v ago",
+		err.key, err.operation.name, time.Since(err.operation.started).Round(time.Millisecond))
+}
+
+// volumeLocks track the operations of the volumes in progress. An operation
+// locks its volume for its whole duration and holds drv.volumesRWL only
+// to read and update the volume records, so operations of different
+// volumes run in parallel. Another operation of a locked volume, including
+// a retry of the same call, fails right away with ABORTED naming the
+// operation in progress, as CSI expects, instead of racing with it.
+// The zero value has no locked volumes.
+type volumeLocks struct {
+	mu         sync.Mutex
+	operations map[string]inFlightOperation
+}
+
+// lock locks the volume for the operation, it returns *operationPendingError
+// if another operation of the volume is in progress
+func (locks *volumeLocks) lock(key, operation string) error {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if inFlight, locked := locks.operations[key]; locked {
+		return &operationPendingError{key: key, operation: inFlight}
+	}
+	if locks.operations == nil {
+		locks.operations = map[string]inFlightOperation{}
+	}
+	locks.operations[key] = inFlightOperation{name: operation, started: time.Now()}
+	return nil
+}
+
+// unlock unlocks the volume locked by lock
+func (locks *volumeLocks) unlock(key string) {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	delete(locks.operations, key)
+}
+
+// inFlight returns the name of the operation of the volume in progress,
+// empty if the volume isn't locked
+func (locks *volumeLocks) inFlight(key string) string {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	return locks.operations[key].name
+}
+
+// isLocked checks if an operation of the volume is in progress
+func (locks *volumeLocks) isLocked(key string) bool {
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	_, locked := locks.operations[key]
+	return locked
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeLocks(t *testing.T) {
+	var locks volumeLocks
+	if locks.isLocked("1") {
+		t.Error("volume of zero volumeLocks is locked")
+	}
+	if err := locks.lock("1", "NodeStageVolume"); err != nil {
+		t.Fatalf("lock() of unlocked volume failed: %v", err)
+	}
+	err := locks.lock("1", "NodeUnstageVolume")
+	if pending, ok := err.(*operationPendingError); !ok || pending.operation.name != "NodeStageVolume" {
+		t.Errorf("lock() of locked volume error = %v, want NodeStageVolume in progress", err)
+	}
+	if err := locks.lock("2", "NodeStageVolume"); err != nil {
+		t.Errorf("lock() of another volume failed: %v", err)
+	}
+	if got := locks.inFlight("1"); got != "NodeStageVolume" {
+		t.Errorf("inFlight() = %q, want NodeStageVolume", got)
+	}
+	locks.unlock("1")
+	if locks.isLocked("1") || !locks.isLocked("2") || locks.inFlight("1") != "" {
+		t.Errorf("unexpected locked volumes %v", locks.operations)
+	}
+	if err := locks.lock("1", "NodeUnstageVolume"); err != nil {
+		t.Errorf("lock() of unlocked volume failed: %v", err)
+	}
+}
+
+// blockingClient blocks requests of the entry point until release is closed
+type blockingClient struct {
+	TestClient
+	entrypoint string
+	started    chan struct{}
+	release    chan struct{}
+}
+
+func newBlockingClient(entrypoint string, results map[string]string) *blockingClient {
+	return &blockingClient{
+		TestClient: TestClient{results: results},
+		entrypoint: entrypoint,
+		started:    make(chan struct{}, 1),
+		release:    make(chan struct{}),
+	}
+}
+
+func (client *blockingClient) block(entrypoint string) {
+	if entrypoint == client.entrypoint {
+		client.started <- struct{}{}
+		<-client.release
+	}
+}
+
+func (client *blockingClient) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	client.block(entrypoint)
+	return client.TestClient.Get(ctx, entrypoint, result)
+}
+
+func (client *blockingClient) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	client.block(entrypoint)
+	return client.TestClient.Post(ctx, entrypoint, data, result)
+}
+
+func TestControllerPublishVolumeConcurrency(t *testing.T) {
+	client := newBlockingClient("/redfish/v1/Nodes", map[string]string{"/redfish/v1/Nodes": `{"Members": []}`})
+	drv := &Driver{
+		RSDNodeID: "1",
+		rsdClient: client,
+		volumes: map[string]*Volume{
+			"pvc-1": {Name: "pvc-1", RSDVolume: &rsd.Volume{}, CSIVolume: &csi.Volume{VolumeId: "1"}},
+			"pvc-2": {Name: "pvc-2", RSDVolume: &rsd.Volume{}, CSIVolume: &csi.Volume{VolumeId: "2"}},
+		},
+	}
+	publish := func() error {
+		_, err := drv.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId: "1",
+			NodeId:   "1",
+			VolumeCapability: &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		})
+		return err
+	}
+
+	done := make(chan error)
+	go func() { done <- publish() }()
+	select {
+	case err := <-done:
+		t.Fatalf("ControllerPublishVolume() returned before querying the RSD node: %v", err)
+	case <-client.started:
+	}
+
+	// the volume being published can't be published again meanwhile
+	if err := publish(); status.Code(err) != codes.Aborted || !strings.Contains(err.Error(), "ControllerPublishVolume") {
+		t.Errorf("publishing volume twice: error = %v, want Aborted by ControllerPublishVolume in progress", err)
+	}
+
+	// other volumes are not blocked by the RSD node query
+	if _, err := drv.ListVolumes(context.Background(), &csi.ListVolumesRequest{}); err != nil {
+		t.Errorf("ListVolumes() unexpected error: %v", err)
+	}
+	if _, err := drv.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "2"}); err != nil {
+		t.Errorf("DeleteVolume() unexpected error: %v", err)
+	}
+
+	close(client.release)
+	if err := <-done; err == nil {
+		t.Error("publishing to unknown node unexpected success")
+	}
+	if drv.volumeLocks.isLocked("1") {
+		t.Error("volume is locked after publishing")
+	}
+	if vol := drv.volumes["pvc-1"]; vol.IsPublished {
+		t.Errorf("volume is published: %+v", vol)
+	}
+}
+
+func TestCreateVolumeReservesCapacity(t *testing.T) {
+	client := newBlockingClient("/redfish/v1/StorageServices/1/Volumes", map[string]string{
+		"/redfish/v1/StorageServices":             `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}]}`,
+		"/redfish/v1/StorageServices/1":           `{"Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+		"/redfish/v1/StorageServices/1/Volumes":   `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}`,
+		"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100}`,
+	})
+	drv := &Driver{
+		rsdClient:        client,
+		volumes:          map[string]*Volume{},
+		maxTotalCapacity: 150,
+	}
+	create := func(name string) error {
+		_, err := drv.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}},
+			},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 100},
+			Parameters:    map[string]string{AllocationUnitParameter: AllocationUnitNone},
+		})
+		return err
+	}
+
+	done := make(chan error)
+	go func() { done <- create("pvc-1") }()
+	<-client.started
+
+	if err := create("pvc-1"); status.Code(err) != codes.Aborted {
+		t.Errorf("creating volume twice: error = %v, want Aborted", err)
+	}
+	// the capacity of the volume being created counts in the budget
+	if err := create("pvc-2"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("creating volume over the budget: error = %v, want ResourceExhausted", err)
+	}
+
+	close(client.release)
+	if err := <-done; err != nil {
+		t.Fatalf("CreateVolume() unexpected error: %v", err)
+	}
+	if _, exists := drv.volumes["pvc-1"]; !exists {
+		t.Error("created volume is not stored")
+	}
+	if drv.reservedCapacity != 0 {
+		t.Errorf("%d bytes are reserved after creating", drv.reservedCapacity)
+	}
+}
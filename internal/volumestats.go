@@ -0,0 +1,97 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// filesystemStats is the usage of the filesystem a path is on
+type filesystemStats struct {
+	totalBytes     int64
+	usedBytes      int64
+	availableBytes int64
+	totalInodes    int64
+	usedInodes     int64
+	freeInodes     int64
+}
+
+// usage returns the filesystem stats as CSI volume usage in bytes and inodes
+func (stats *filesystemStats) usage() []*csi.VolumeUsage {
+	return []*csi.VolumeUsage{
+		{
+			Total:     stats.totalBytes,
+			Used:      stats.usedBytes,
+			Available: stats.availableBytes,
+			Unit:      csi.VolumeUsage_BYTES,
+		},
+		{
+			Total:     stats.totalInodes,
+			Used:      stats.usedInodes,
+			Available: stats.freeInodes,
+			Unit:      csi.VolumeUsage_INODES,
+		},
+	}
+}
+
+// volumeStats returns usage of the volume at the path, its staging or
+// target path, and why the volume is abnormal, empty if it's healthy.
+// Filesystem volumes report the usage of their filesystem, block volumes
+// and the volumes whose filesystem can't be read only their capacity.
+func (drv *Driver) volumeStats(volume *Volume, path string) ([]*csi.VolumeUsage, string) {
+	capacity := []*csi.VolumeUsage{
+		{
+			Total: volume.RSDVolume.CapacityBytes,
+			Unit:  csi.VolumeUsage_BYTES,
+		},
+	}
+
+	abnormal := ""
+	if volume.IsStaged {
+		abnormal = drv.staleStaging(volume)
+	}
+	if volume.IsBlock {
+		return capacity, abnormal
+	}
+
+	stats, err := filesystemUsage(path)
+	if err != nil {
+		if abnormal == "" {
+			abnormal = fmt.Sprintf("can't read filesystem usage of %s: %v", path, err)
+		}
+		return capacity, abnormal
+	}
+	return stats.usage(), abnormal
+}
+
+// observeVolumeCondition logs the volume becoming abnormal or healthy again
+// and reports a Warning event about its PVC when it becomes abnormal, as
+// kubelet doesn't report the volume condition of NodeGetVolumeStats by
+// default. It must be called with drv.volumesRWL locked.
+func (drv *Driver) observeVolumeCondition(logger Logger, volume *Volume, abnormal string) {
+	if abnormal == volume.Abnormal {
+		return
+	}
+	volume.Abnormal = abnormal
+	if abnormal == "" {
+		logger.Info("volume is healthy again", "volume", volume.Name, "volume_id", volume.CSIVolume.VolumeId)
+		return
+	}
+	logger.Warning("volume is abnormal", "volume", volume.Name, "volume_id", volume.CSIVolume.VolumeId, "reason", abnormal)
+	drv.events.warn(logger, volume.CSIVolume.VolumeContext, "VolumeAbnormal",
+		fmt.Sprintf("volume %s on the node %s is abnormal: %s", volume.CSIVolume.VolumeId, drv.RSDNodeID, abnormal))
+}
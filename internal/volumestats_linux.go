@@ -0,0 +1,34 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import "syscall"
+
+// filesystemUsage returns stats of the filesystem the path is on
+func filesystemUsage(path string) (*filesystemStats, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+	blockSize := int64(stat.Bsize)
+	return &filesystemStats{
+		totalBytes:     int64(stat.Blocks) * blockSize,
+		usedBytes:      int64(stat.Blocks-stat.Bfree) * blockSize,
+		availableBytes: int64(stat.Bavail) * blockSize,
+		totalInodes:    int64(stat.Files),
+		usedInodes:     int64(stat.Files - stat.Ffree),
+		freeInodes:     int64(stat.Ffree),
+	}, nil
+}
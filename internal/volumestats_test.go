@@ -0,0 +1,121 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csirsd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/endpoint"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func TestVolumeStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("filesystem usage is read only on linux")
+	}
+	dir, err := ioutil.TempDir("", "csi-rsd-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const nqn = "nqn.2014-08.org.nvmexpress:uuid:1"
+	tests := []struct {
+		name         string
+		device       string
+		block        bool
+		path         string
+		wantUnits    []csi.VolumeUsage_Unit
+		wantAbnormal string
+	}{
+		{name: "filesystem", device: "/dev/nvme1n1", path: dir, wantUnits: []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES, csi.VolumeUsage_INODES}},
+		{name: "block", device: "/dev/nvme1n1", block: true, path: filepath.Join(dir, "block"), wantUnits: []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES}},
+		{name: "unreadable filesystem", device: "/dev/nvme1n1", path: filepath.Join(dir, "missing"),
+			wantUnits: []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES}, wantAbnormal: "can't read filesystem usage"},
+		{name: "device gone", device: "/dev/nvme0n1", path: dir,
+			wantUnits: []csi.VolumeUsage_Unit{csi.VolumeUsage_BYTES, csi.VolumeUsage_INODES}, wantAbnormal: "is gone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingEventSink{}
+			drv := &Driver{
+				mounter: &trackingMounter{mounts: map[string]string{"/staging": tt.device}},
+				nvme:    &testNVMe{},
+			}
+			drv.SetEventSink(sink, 1)
+			vol := &Volume{
+				Name: "pvc-1",
+				CSIVolume: &csi.Volume{
+					VolumeId:      "1",
+					VolumeContext: map[string]string{pvcNameParameter: "pvc", pvcNamespaceParameter: "default"},
+				},
+				RSDVolume:         &rsd.Volume{CapacityBytes: 1 << 30},
+				EndPoint:          &endpoint.Portal{NQN: nqn},
+				Device:            tt.device,
+				IsBlock:           tt.block,
+				IsStaged:          true,
+				StagingTargetPath: "/staging",
+			}
+
+			usage, abnormal := drv.volumeStats(vol, tt.path)
+			var units []csi.VolumeUsage_Unit
+			for _, u := range usage {
+				units = append(units, u.Unit)
+				if u.Total <= 0 || u.Used+u.Available > u.Total {
+					t.Errorf("%s usage total %d, used %d, available %d", u.Unit, u.Total, u.Used, u.Available)
+				}
+			}
+			if len(units) != len(tt.wantUnits) {
+				t.Fatalf("usage units %v, want %v", units, tt.wantUnits)
+			}
+			for i := range units {
+				if units[i] != tt.wantUnits[i] {
+					t.Errorf("usage units %v, want %v", units, tt.wantUnits)
+				}
+			}
+			if len(units) == 1 && usage[0].Total != vol.RSDVolume.CapacityBytes {
+				t.Errorf("capacity usage total %d, want %d", usage[0].Total, vol.RSDVolume.CapacityBytes)
+			}
+			if (abnormal == "") != (tt.wantAbnormal == "") || !strings.Contains(abnormal, tt.wantAbnormal) {
+				t.Errorf("abnormal %q, want %q", abnormal, tt.wantAbnormal)
+			}
+
+			// the event is reported once the volume becomes abnormal
+			for i := 0; i < 2; i++ {
+				drv.observeVolumeCondition(drv.logger, vol, abnormal)
+			}
+			wantEvents := 0
+			if tt.wantAbnormal != "" {
+				wantEvents = 1
+			}
+			if len(sink.events) != wantEvents {
+				t.Fatalf("events %v, want %d", sink.events, wantEvents)
+			}
+			if wantEvents > 0 && (sink.events[0].reason != "VolumeAbnormal" || sink.events[0].object.Name != "pvc") {
+				t.Errorf("event %+v, want VolumeAbnormal about the PVC", sink.events[0])
+			}
+			drv.observeVolumeCondition(drv.logger, vol, "")
+			if vol.Abnormal != "" || len(sink.events) != wantEvents {
+				t.Errorf("healthy volume is abnormal %q with events %v", vol.Abnormal, sink.events)
+			}
+		})
+	}
+}
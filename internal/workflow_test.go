@@ -0,0 +1,420 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build fakenode
+// +build fakenode
+
+package csirsd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+const (
+	fakeRSDVolumes   = "/redfish/v1/StorageServices/1/Volumes"
+	fakeRSDEndpoints = "/redfish/v1/Fabrics/1/Endpoints/"
+	fakeRSDNode      = "/redfish/v1/Nodes/1"
+	fakeRSDAttach    = fakeRSDNode + "/Actions/ComposedNode.AttachResource"
+	fakeRSDDetach    = fakeRSDNode + "/Actions/ComposedNode.DetachResource"
+	fakeRSDSystem    = "/redfish/v1/Systems/1"
+	fakeRSDHostNQN   = "nqn.2014-08.org.nvmexpress:uuid:host"
+)
+
+// fakeRSD is an RSD transport keeping volumes of a storage service and their
+// attachments to a composed node in memory. Like RSD, it rejects attaching
+// attached volumes, detaching detached ones and deleting attached ones.
+type fakeRSD struct {
+	mu       sync.Mutex
+	lastID   int
+	volumes  map[string]*rsd.NewVolumeRequest
+	attached map[string]bool
+}
+
+func newFakeRSD() *fakeRSD {
+	return &fakeRSD{volumes: map[string]*rsd.NewVolumeRequest{}, attached: map[string]bool{}}
+}
+
+// odataLinks returns the links to the resources
+func odataLinks(odataIDs ...string) []map[string]string {
+	result := []map[string]string{}
+	for _, odataID := range odataIDs {
+		result = append(result, map[string]string{"@odata.id": odataID})
+	}
+	return result
+}
+
+// resource returns the resource at the path, it must be called with f.mu locked
+func (f *fakeRSD) resource(path string) (interface{}, bool) {
+	switch path {
+	case "/redfish/v1/StorageServices":
+		return map[string]interface{}{"Members": odataLinks("/redfish/v1/StorageServices/1")}, true
+	case "/redfish/v1/StorageServices/1":
+		return map[string]interface{}{"Id": "1", "Volumes": map[string]string{"@odata.id": fakeRSDVolumes}}, true
+	case fakeRSDVolumes:
+		var members []string
+		for odataID := range f.volumes {
+			members = append(members, odataID)
+		}
+		sort.Strings(members)
+		return map[string]interface{}{"@odata.id": fakeRSDVolumes, "Members": odataLinks(members...)}, true
+	case "/redfish/v1/Nodes":
+		return map[string]interface{}{"Members": odataLinks(fakeRSDNode)}, true
+	case fakeRSDNode:
+		var storage []string
+		for odataID := range f.attached {
+			storage = append(storage, odataID)
+		}
+		sort.Strings(storage)
+		return map[string]interface{}{
+			"Id": "1",
+			"Links": map[string]interface{}{
+				"ComputerSystem": map[string]string{"@odata.id": fakeRSDSystem},
+				"Storage":        odataLinks(storage...),
+			},
+			"Actions": map[string]interface{}{
+				"#ComposedNode.AttachResource": map[string]string{"target": fakeRSDAttach},
+				"#ComposedNode.DetachResource": map[string]string{"target": fakeRSDDetach},
+			},
+		}, true
+	case fakeRSDSystem:
+		return map[string]interface{}{"Links": map[string]interface{}{"Endpoints": odataLinks(fakeRSDEndpoints + "host")}}, true
+	case fakeRSDEndpoints + "host":
+		return map[string]interface{}{
+			"Id":          "host",
+			"Identifiers": []map[string]string{{"DurableName": fakeRSDHostNQN, "DurableNameFormat": "NQN"}},
+		}, true
+	}
+
+	if strings.HasPrefix(path, fakeRSDEndpoints) {
+		id := strings.TrimPrefix(path, fakeRSDEndpoints)
+		if _, exists := f.volumes[fakeRSDVolumes+"/"+id]; !exists {
+			return nil, false
+		}
+		return map[string]interface{}{
+			"Id": id,
+			"IPTransportDetails": []map[string]interface{}{{
+				"IPv4Address":       map[string]string{"Address": "10.0.0.1"},
+				"Port":              4420,
+				"TransportProtocol": "RoCEv2",
+			}},
+			"Identifiers": []map[string]string{{"DurableName": "nqn.2019-05.com.intel:volume-" + id, "DurableNameFormat": "NQN"}},
+		}, true
+	}
+
+	request, exists := f.volumes[path]
+	if !exists {
+		return nil, false
+	}
+	id := strings.TrimPrefix(path, fakeRSDVolumes+"/")
+	// the target endpoint of the volume appears once it's attached
+	var endpoints []string
+	if f.attached[path] {
+		endpoints = append(endpoints, fakeRSDEndpoints+id)
+	}
+	return map[string]interface{}{
+		"@odata.id":     path,
+		"Id":            id,
+		"Description":   request.Description,
+		"CapacityBytes": request.CapacityBytes,
+		"Links":         map[string]interface{}{"Oem": map[string]interface{}{"Intel_RackScale": map[string]interface{}{"Endpoints": odataLinks(endpoints...)}}},
+	}, true
+}
+
+// decode decodes the request data, as RSD receives it, into the result
+func decode(data, result interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, result)
+}
+
+// Get decodes the resource at the entry point into the result
+func (f *fakeRSD) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	f.mu.Lock()
+	resource, exists := f.resource(entrypoint)
+	f.mu.Unlock()
+	if !exists {
+		return &rsd.HTTPError{StatusCode: http.StatusNotFound, URL: entrypoint}
+	}
+	return decode(resource, result)
+}
+
+// Post creates volumes and performs the attach and detach actions of the node
+func (f *fakeRSD) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if entrypoint == fakeRSDVolumes {
+		request := &rsd.NewVolumeRequest{}
+		if err := decode(data, request); err != nil {
+			return nil, err
+		}
+		f.lastID++
+		location := fmt.Sprintf("%s/%d", fakeRSDVolumes, f.lastID)
+		f.volumes[location] = request
+		return &http.Header{"Location": []string{location}}, nil
+	}
+
+	var action struct {
+		Resource struct {
+			OdataID string `json:"@odata.id"`
+		}
+	}
+	if err := decode(data, &action); err != nil {
+		return nil, err
+	}
+	volume := action.Resource.OdataID
+	if _, exists := f.volumes[volume]; !exists {
+		return nil, &rsd.HTTPError{StatusCode: http.StatusNotFound, URL: volume}
+	}
+	switch {
+	case entrypoint == fakeRSDAttach && !f.attached[volume]:
+		f.attached[volume] = true
+	case entrypoint == fakeRSDDetach && f.attached[volume]:
+		delete(f.attached, volume)
+	default:
+		return nil, &rsd.HTTPError{StatusCode: http.StatusConflict, URL: entrypoint}
+	}
+	return nil, nil
+}
+
+// Delete deletes the volume unless it's attached
+func (f *fakeRSD) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.volumes[entrypoint]; !exists {
+		return nil, &rsd.HTTPError{StatusCode: http.StatusNotFound, URL: entrypoint}
+	}
+	if f.attached[entrypoint] {
+		return nil, &rsd.HTTPError{StatusCode: http.StatusConflict, URL: entrypoint}
+	}
+	delete(f.volumes, entrypoint)
+	return nil, nil
+}
+
+// Patch isn't used by the workflow
+func (f *fakeRSD) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	return nil, fmt.Errorf("unsupported PATCH of %s", entrypoint)
+}
+
+// TestKubeletWorkflow drives the driver through the calls kubelet and the
+// sidecars make for a pod using a volume, repeating them as they retry,
+// restarting the driver in the middle and calling them out of order
+func TestKubeletWorkflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "journal.json")
+	staging := filepath.Join(dir, "plugins", "globalmount")
+	target := filepath.Join(dir, "pods", "uid", "mount")
+
+	server := newFakeRSD()
+	var mounter Mounter
+	var nvme NVMe
+	// the restarted driver finds the volumes in RSD and the node journal,
+	// the connections and mounts of the node are kept
+	newDriver := func() *Driver {
+		drv := NewDriver("", "1", server)
+		if err := drv.SetFakeNode(); err != nil {
+			t.Fatal(err)
+		}
+		if mounter == nil {
+			mounter, nvme = drv.mounter, drv.nvme
+		}
+		drv.mounter, drv.nvme = mounter, nvme
+		if err := drv.SetNodeJournal(journal); err != nil {
+			t.Fatal(err)
+		}
+		if err := drv.adoptVolumes(); err != nil {
+			t.Fatal(err)
+		}
+		return drv
+	}
+	check := func(call string, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", call, err)
+		}
+	}
+
+	ctx := context.Background()
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	drv := newDriver()
+
+	// the provisioner retries CreateVolume until it gets the response,
+	// also from the driver restarted after creating the volume
+	createReq := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+	}
+	created, err := drv.CreateVolume(ctx, createReq)
+	check("CreateVolume", err)
+	volume := created.Volume
+	for _, restart := range []bool{false, true} {
+		if restart {
+			drv = newDriver()
+		}
+		retried, err := drv.CreateVolume(ctx, createReq)
+		check("CreateVolume retry", err)
+		if retried.Volume.VolumeId != volume.VolumeId {
+			t.Fatalf("CreateVolume retry (restart %v) = volume %s, want %s", restart, retried.Volume.VolumeId, volume.VolumeId)
+		}
+	}
+	if len(server.volumes) != 1 {
+		t.Fatalf("RSD volumes %v after CreateVolume retries, want one", server.volumes)
+	}
+
+	// node calls before the volume is attached
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          volume.VolumeId,
+		StagingTargetPath: staging,
+		VolumeCapability:  capability,
+		VolumeContext:     volume.VolumeContext,
+	}
+	if _, err := drv.NodeStageVolume(ctx, stageReq); err == nil {
+		t.Fatal("NodeStageVolume of not attached volume succeeded")
+	}
+	_, err = drv.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volume.VolumeId, TargetPath: target})
+	check("NodeUnpublishVolume of not published volume", err)
+	_, err = drv.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volume.VolumeId, StagingTargetPath: staging})
+	check("NodeUnstageVolume of not staged volume", err)
+	_, err = drv.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volume.VolumeId, NodeId: "1"})
+	check("ControllerUnpublishVolume of not attached volume", err)
+
+	// the attacher retries ControllerPublishVolume lost with the restart
+	// of the driver, the volume is already attached then
+	publishReq := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volume.VolumeId,
+		NodeId:           "1",
+		VolumeCapability: capability,
+		VolumeContext:    volume.VolumeContext,
+	}
+	_, err = drv.ControllerPublishVolume(ctx, publishReq)
+	check("ControllerPublishVolume", err)
+	drv = newDriver()
+	var published *csi.ControllerPublishVolumeResponse
+	for i := 0; i < 2; i++ {
+		published, err = drv.ControllerPublishVolume(ctx, publishReq)
+		check("ControllerPublishVolume retry", err)
+	}
+	if nqn := published.PublishContext[PublishInfoSubsystemNQN]; nqn != "nqn.2019-05.com.intel:volume-"+volume.VolumeId {
+		t.Errorf("publish context subsystem NQN %q", nqn)
+	}
+
+	// kubelet stages and publishes the volume, retrying the calls
+	stageReq.PublishContext = published.PublishContext
+	nodePublishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          volume.VolumeId,
+		PublishContext:    published.PublishContext,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  capability,
+		VolumeContext:     volume.VolumeContext,
+	}
+	for i := 0; i < 2; i++ {
+		_, err = drv.NodeStageVolume(ctx, stageReq)
+		check("NodeStageVolume", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = drv.NodePublishVolume(ctx, nodePublishReq)
+		check("NodePublishVolume", err)
+	}
+	if devices, _ := nvme.List(); len(devices) != 1 {
+		t.Errorf("connected devices %v after staging, want one", devices)
+	}
+
+	// the volume in use isn't deleted
+	if _, err := drv.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volume.VolumeId}); err == nil {
+		t.Fatal("DeleteVolume of attached volume succeeded")
+	}
+
+	// the restarted driver serves the stats of the running pod
+	statsReq := &csi.NodeGetVolumeStatsRequest{VolumeId: volume.VolumeId, VolumePath: target}
+	for _, restart := range []bool{false, true} {
+		if restart {
+			drv = newDriver()
+		}
+		stats, err := drv.NodeGetVolumeStats(ctx, statsReq)
+		check("NodeGetVolumeStats", err)
+		// the fake node reports usage of the filesystem of the target directory
+		if len(stats.Usage) != 2 || stats.Usage[1].Unit != csi.VolumeUsage_INODES {
+			t.Errorf("NodeGetVolumeStats (restart %v) usage %v, want bytes and inodes", restart, stats.Usage)
+		}
+		if stats.VolumeCondition == nil || stats.VolumeCondition.Abnormal {
+			t.Errorf("NodeGetVolumeStats (restart %v) volume condition %v, want normal", restart, stats.VolumeCondition)
+		}
+	}
+
+	// the pod is deleted: the volume is unpublished, unstaged, detached
+	// and deleted, every call repeated and the driver restarted meanwhile
+	for i := 0; i < 2; i++ {
+		_, err = drv.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volume.VolumeId, TargetPath: target})
+		check("NodeUnpublishVolume", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = drv.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volume.VolumeId, StagingTargetPath: staging})
+		check("NodeUnstageVolume", err)
+	}
+	_, err = drv.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volume.VolumeId, NodeId: "1"})
+	check("ControllerUnpublishVolume", err)
+	drv = newDriver()
+	_, err = drv.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volume.VolumeId, NodeId: "1"})
+	check("ControllerUnpublishVolume retry", err)
+	for i := 0; i < 2; i++ {
+		_, err = drv.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volume.VolumeId})
+		check("DeleteVolume", err)
+	}
+
+	// nothing is left in RSD, on the node nor in the driver
+	if len(server.volumes) != 0 || len(server.attached) != 0 {
+		t.Errorf("RSD volumes %v attached %v are left", server.volumes, server.attached)
+	}
+	if devices, _ := nvme.List(); len(devices) != 0 {
+		t.Errorf("connected devices %v are left", devices)
+	}
+	if mounts := mounter.(*fakeMounter).mounts; len(mounts) != 0 {
+		t.Errorf("mounts %v are left", mounts)
+	}
+	for _, path := range []string{staging, target} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is left: %v", path, err)
+		}
+	}
+	if len(drv.volumes) != 0 {
+		t.Errorf("volume records %v are left", drv.volumes)
+	}
+	if len(drv.journal.volumes) != 0 || len(drv.journal.formats) != 0 {
+		t.Errorf("journal records %v and format fences %v are left", drv.journal.volumes, drv.journal.formats)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package driver embeds the RSD CSI driver into other programs: it's created
+// by NewDriverWithOptions with the options replacing the node tools, the
+// clock, the logger or the metrics registry, so downstream integrations and
+// tests assemble custom drivers without importing the internal package.
+package driver
+
+import (
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	csirsd "github.com/intel/csi-intel-rsd/internal"
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// Driver is the RSD CSI plugin serving the identity, controller and node services
+type Driver = csirsd.Driver
+
+// Option configures the driver created by NewDriverWithOptions
+type Option = csirsd.Option
+
+// Mounter declares volume mounting and formatting operations of the node
+type Mounter = csirsd.Mounter
+
+// NVMe declares NVMe operations of the node
+type NVMe = csirsd.NVMe
+
+// FabricAuth is NVMe in-band authentication (DH-HMAC-CHAP) of the connection
+type FabricAuth = csirsd.FabricAuth
+
+// SmartLog is SMART log of the NVMe device
+type SmartLog = csirsd.SmartLog
+
+// Logger writes structured messages of the driver
+type Logger = csirsd.Logger
+
+// MetricsRegistry keeps the driver metrics and serves them over HTTP
+// in the Prometheus text format
+type MetricsRegistry = csirsd.MetricsRegistry
+
+// Verbosity levels of the driver messages
+const (
+	// LogLevelState logs volume state changes: created, attached, staged, ...
+	LogLevelState = csirsd.LogLevelState
+	// LogLevelRequest logs CSI requests and responses
+	LogLevelRequest = csirsd.LogLevelRequest
+	// LogLevelRSD logs requests sent to RSD
+	LogLevelRSD = csirsd.LogLevelRSD
+)
+
+// NewDriverWithOptions returns a CSI plugin configured by the options applied
+// in order. Without options it uses the host mount and nvme tools with the
+// default policies and has no endpoint, RSD node ID and RSD client.
+func NewDriverWithOptions(opts ...Option) (*Driver, error) {
+	return csirsd.NewDriverWithOptions(opts...)
+}
+
+// NewLogger returns Logger writing messages up to the verbosity level
+func NewLogger(verbosity int) Logger {
+	return csirsd.NewLogger(verbosity)
+}
+
+// NewMetricsRegistry returns an empty registry for the metrics of a driver
+func NewMetricsRegistry() *MetricsRegistry {
+	return csirsd.NewMetricsRegistry()
+}
+
+// WithEndpoint sets the endpoint the driver serves CSI on, e.g. unix:///csi/csi.sock
+func WithEndpoint(endpoint string) Option {
+	return csirsd.WithEndpoint(endpoint)
+}
+
+// WithRSDNodeID sets RSD node ID of the node the driver runs on
+func WithRSDNodeID(nodeID string) Option {
+	return csirsd.WithRSDNodeID(nodeID)
+}
+
+// WithRSDClient sets the transport the driver sends RSD requests with
+func WithRSDClient(client rsd.Transport) Option {
+	return csirsd.WithRSDClient(client)
+}
+
+// WithRack makes the driver serve the RSD PodM of another rack
+func WithRack(id string, client rsd.Transport) Option {
+	return csirsd.WithRack(id, client)
+}
+
+// WithPolicies sets timeouts and retries of the nvme and mount tools. It
+// recreates the tools, so it must precede WithMounter and WithNVMe.
+func WithPolicies(policies policy.Policies) Option {
+	return csirsd.WithPolicies(policies)
+}
+
+// WithMounter replaces the mount and mkfs tools of the node
+func WithMounter(mounter Mounter) Option {
+	return csirsd.WithMounter(mounter)
+}
+
+// WithNVMe replaces the nvme tools of the node
+func WithNVMe(nvme NVMe) Option {
+	return csirsd.WithNVMe(nvme)
+}
+
+// WithClock sets the function returning the current time used instead of time.Now
+func WithClock(now func() time.Time) Option {
+	return csirsd.WithClock(now)
+}
+
+// WithNodeJournal makes the node keep staged state of the volumes in the journal file
+func WithNodeJournal(path string) Option {
+	return csirsd.WithNodeJournal(path)
+}
+
+// WithLogger sets Logger of the driver
+func WithLogger(logger Logger) Option {
+	return csirsd.WithLogger(logger)
+}
+
+// WithMetricsRegistry registers the driver metrics in the registry. The
+// registry must not have the metrics of another driver.
+func WithMetricsRegistry(registry *MetricsRegistry) Option {
+	return csirsd.WithMetricsRegistry(registry)
+}
+
+// WithTopology enables the topology of the storage services
+func WithTopology(enabled bool) Option {
+	return csirsd.WithTopology(enabled)
+}
+
+// WithFabricDirect enables the fabric-direct mode
+func WithFabricDirect(enabled bool) Option {
+	return csirsd.WithFabricDirect(enabled)
+}
+
+// WithoutControllerCapabilities hides the controller capabilities from
+// ControllerGetCapabilities, so the CO doesn't use them
+func WithoutControllerCapabilities(caps ...csi.ControllerServiceCapability_RPC_Type) Option {
+	return csirsd.WithoutControllerCapabilities(caps...)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/intel/csi-intel-rsd/pkg/driver"
+)
+
+// fakeMounter is a node without mounts, everything succeeds
+type fakeMounter struct{}
+
+func (fakeMounter) Mount(source, target, fsType string, opts ...string) error { return nil }
+func (fakeMounter) MountBlock(source, target string, opts ...string) error    { return nil }
+func (fakeMounter) Unmount(target string) error                               { return nil }
+func (fakeMounter) RemoveTarget(target string) error                          { return nil }
+func (fakeMounter) IsMounted(source, target string) (bool, error)             { return false, nil }
+func (fakeMounter) IsFormatted(source string) (bool, error)                   { return false, nil }
+func (fakeMounter) GetFilesystemType(source string) (string, error)           { return "", nil }
+func (fakeMounter) IsFilesystemEmpty(source, fsType, target string) (bool, error) {
+	return true, nil
+}
+func (fakeMounter) Format(source, fsType, label string, opts ...string) error { return nil }
+func (fakeMounter) GetFilesystemIDs(source string) (string, string, error)    { return "", "", nil }
+func (fakeMounter) ResizeFilesystem(source, target string) error              { return nil }
+
+// fakeNVMe is a node without NVMe devices
+type fakeNVMe struct{}
+
+func (fakeNVMe) Connect(ctx context.Context, transport, traddr, traddrfamily, trsvcid, nqn, hostnqn string, auth driver.FabricAuth) (string, error) {
+	return "/dev/nvme1n1", nil
+}
+func (fakeNVMe) Disconnect(device string) error                   { return nil }
+func (fakeNVMe) SmartLog(device string) (*driver.SmartLog, error) { return &driver.SmartLog{}, nil }
+func (fakeNVMe) List() (map[string]string, error)                 { return map[string]string{}, nil }
+
+var (
+	_ driver.Mounter = fakeMounter{}
+	_ driver.NVMe    = fakeNVMe{}
+)
+
+func TestNewDriverWithOptions(t *testing.T) {
+	registry := driver.NewMetricsRegistry()
+	drv, err := driver.NewDriverWithOptions(
+		driver.WithEndpoint("unix:///csi/csi.sock"),
+		driver.WithRSDNodeID("1"),
+		driver.WithMounter(fakeMounter{}),
+		driver.WithNVMe(fakeNVMe{}),
+		driver.WithClock(func() time.Time { return time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC) }),
+		driver.WithLogger(driver.NewLogger(driver.LogLevelState)),
+		driver.WithMetricsRegistry(registry),
+		driver.WithoutControllerCapabilities(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT),
+	)
+	if err != nil {
+		t.Fatalf("NewDriverWithOptions() unexpected error: %v", err)
+	}
+
+	info, err := drv.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	if err != nil || info.Name == "" {
+		t.Errorf("GetPluginInfo() = %v, %v, want the driver name", info, err)
+	}
+	caps, err := drv.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities() unexpected error: %v", err)
+	}
+	for _, cap := range caps.Capabilities {
+		if cap.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT {
+			t.Error("ControllerGetCapabilities() advertises the disabled snapshots")
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "csi_rsd_") {
+		t.Errorf("registry doesn't serve the driver metrics:\n%s", rec.Body.String())
+	}
+
+	if _, err := driver.NewDriverWithOptions(driver.WithMounter(nil)); err == nil {
+		t.Error("NewDriverWithOptions() with nil mounter unexpected success")
+	}
+}
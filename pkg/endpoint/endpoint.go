@@ -0,0 +1,147 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package endpoint finds the portals NVMe-oF hosts connect to the RSD volumes
+// through. The portals are read from the IP transport details of the volume
+// endpoints and their Redfish transport protocols are mapped to the transports
+// of 'nvme connect'.
+package endpoint
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// Address families of the portals as they are passed to 'nvme connect'
+const (
+	FamilyIPv4 = "IPv4"
+	FamilyIPv6 = "IPv6"
+)
+
+// transports maps upper case Redfish transport protocols of the endpoints
+// to the NVMe-oF transports the hosts connect to them with
+var transports = map[string]string{
+	"ROCE":        "rdma",
+	"ROCEV2":      "rdma",
+	"TCP":         "tcp",
+	"NVMEOVERTCP": "tcp",
+}
+
+// Transport returns NVMe-oF transport of the Redfish transport protocol, e.g.
+// rdma for RoCEv2 or tcp for NVMeOverTCP. Protocols are matched
+// case-insensitively. It returns false if the protocol is not supported.
+func Transport(protocol string) (string, bool) {
+	transport, ok := transports[strings.ToUpper(protocol)]
+	return transport, ok
+}
+
+// Transports returns sorted list of the supported NVMe-oF transports
+func Transports() []string {
+	unique := map[string]bool{}
+	for _, transport := range transports {
+		unique[transport] = true
+	}
+	var result []string
+	for transport := range unique {
+		result = append(result, transport)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Portal is the address a host connects to the NVMe subsystem of the endpoint at
+type Portal struct {
+	// Address is IPv4 or IPv6 address of the portal
+	Address string
+	// AddressFamily is FamilyIPv4 or FamilyIPv6
+	AddressFamily string
+	// Port is the transport service ID, 4420 for NVMe-oF usually
+	Port int
+	// Transport is NVMe-oF transport, e.g. rdma
+	Transport string
+	// NQN is the subsystem NQN of the endpoint
+	NQN string
+}
+
+// HostPort returns host:port address of the portal
+func (portal *Portal) HostPort() string {
+	return net.JoinHostPort(portal.Address, strconv.Itoa(portal.Port))
+}
+
+// Portals returns portals of the endpoint with the supported transports in the
+// order of its IP transport details. IPv4 address of each transport detail is
+// preferred, details without any address are skipped.
+func Portals(endPoint *rsd.EndPoint) []*Portal {
+	var result []*Portal
+	nqn := endPoint.GetNQN()
+	for _, detail := range endPoint.IPTransportDetails {
+		transport, ok := Transport(detail.TransportProtocol)
+		if !ok {
+			continue
+		}
+		portal := &Portal{Port: detail.Port, Transport: transport, NQN: nqn}
+		switch {
+		case detail.IPv4Address.Address != "":
+			portal.Address, portal.AddressFamily = detail.IPv4Address.Address, FamilyIPv4
+		case detail.IPv6Address.Address != "":
+			portal.Address, portal.AddressFamily = detail.IPv6Address.Address, FamilyIPv6
+		default:
+			continue
+		}
+		result = append(result, portal)
+	}
+	return result
+}
+
+// Prefer returns the portals of the given NVMe-oF transports ordered by
+// the transports. Portals of the same transport are kept in their order,
+// portals of other transports are left out.
+func Prefer(portals []*Portal, transports []string) []*Portal {
+	var result []*Portal
+	for _, transport := range transports {
+		for _, portal := range portals {
+			if portal.Transport == transport {
+				result = append(result, portal)
+			}
+		}
+	}
+	return result
+}
+
+// Find returns portals of the endpoints in their order
+func Find(endPoints []*rsd.EndPoint) []*Portal {
+	var result []*Portal
+	for _, endPoint := range endPoints {
+		result = append(result, Portals(endPoint)...)
+	}
+	return result
+}
+
+// EndPointTransports returns NVMe-oF transports of the supported transport
+// protocols of the endpoints, including the ones without portal addresses
+func EndPointTransports(endPoints []*rsd.EndPoint) []string {
+	var result []string
+	for _, endPoint := range endPoints {
+		for _, detail := range endPoint.IPTransportDetails {
+			if transport, ok := Transport(detail.TransportProtocol); ok {
+				result = append(result, transport)
+			}
+		}
+	}
+	return result
+}
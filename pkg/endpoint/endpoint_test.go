@@ -0,0 +1,162 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package endpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+func loadEndPoint(t *testing.T, name string) *rsd.EndPoint {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var endPoint rsd.EndPoint
+	if err := json.Unmarshal(content, &endPoint); err != nil {
+		t.Fatalf("can't decode %s: %v", name, err)
+	}
+	return &endPoint
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		protocol  string
+		transport string
+		ok        bool
+	}{
+		{"RoCEv2", "rdma", true},
+		{"ROCEV2", "rdma", true},
+		{"rocev2", "rdma", true},
+		{"RoCE", "rdma", true},
+		{"TCP", "tcp", true},
+		{"NVMeOverTCP", "tcp", true},
+		{"nvmeovertcp", "tcp", true},
+		{"iWARP", "", false},
+		{"NVMeOverFabrics", "", false},
+		{"", "", false},
+		{" RoCEv2", "", false},
+	}
+	for _, tt := range tests {
+		transport, ok := Transport(tt.protocol)
+		if transport != tt.transport || ok != tt.ok {
+			t.Errorf("Transport(%q) = %q, %v, want %q, %v", tt.protocol, transport, ok, tt.transport, tt.ok)
+		}
+	}
+
+	if transports := Transports(); !reflect.DeepEqual(transports, []string{"rdma", "tcp"}) {
+		t.Errorf("Transports() = %v", transports)
+	}
+}
+
+func TestPortals(t *testing.T) {
+	tests := []struct {
+		file string
+		want []*Portal
+	}{
+		{
+			file: "roce-ipv4.json",
+			want: []*Portal{
+				{Address: "192.168.1.1", AddressFamily: FamilyIPv4, Port: 4420, Transport: "rdma", NQN: "nqn.2014-08.org.nvmexpress:uuid:157f29ff-18d2-4784-872e-cbf51bf4701a"},
+			},
+		},
+		{
+			// unsupported protocols are skipped, IPv4 address is preferred
+			file: "multi-protocol.json",
+			want: []*Portal{
+				{Address: "10.0.0.1", AddressFamily: FamilyIPv4, Port: 4420, Transport: "tcp", NQN: "nqn.2014-08.org.nvmexpress:uuid:multi"},
+				{Address: "10.0.1.1", AddressFamily: FamilyIPv4, Port: 4420, Transport: "rdma", NQN: "nqn.2014-08.org.nvmexpress:uuid:multi"},
+				{Address: "10.0.3.1", AddressFamily: FamilyIPv4, Port: 4421, Transport: "rdma", NQN: "nqn.2014-08.org.nvmexpress:uuid:multi"},
+			},
+		},
+		{
+			file: "ipv6-only.json",
+			want: []*Portal{
+				{Address: "fd00::10", AddressFamily: FamilyIPv6, Port: 4420, Transport: "rdma", NQN: "nqn.2014-08.org.nvmexpress:uuid:ipv6"},
+			},
+		},
+		{
+			// no NQN identifier, the detail without address is skipped
+			file: "missing-identifiers.json",
+			want: []*Portal{
+				{Address: "192.168.4.1", AddressFamily: FamilyIPv4, Port: 4420, Transport: "rdma"},
+			},
+		},
+		{
+			file: "initiator.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got := Portals(loadEndPoint(t, tt.file))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Portals() = %s, want %s", portalsString(got), portalsString(tt.want))
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	endPoints := []*rsd.EndPoint{
+		loadEndPoint(t, "initiator.json"),
+		loadEndPoint(t, "ipv6-only.json"),
+		loadEndPoint(t, "roce-ipv4.json"),
+	}
+	var addresses []string
+	for _, portal := range Find(endPoints) {
+		addresses = append(addresses, portal.HostPort())
+	}
+	want := []string{"[fd00::10]:4420", "192.168.1.1:4420"}
+	if !reflect.DeepEqual(addresses, want) {
+		t.Errorf("Find() portals %v, want %v", addresses, want)
+	}
+
+	transports := EndPointTransports(append(endPoints, loadEndPoint(t, "multi-protocol.json")))
+	if want := []string{"rdma", "rdma", "tcp", "rdma", "rdma"}; !reflect.DeepEqual(transports, want) {
+		t.Errorf("EndPointTransports() = %v, want %v", transports, want)
+	}
+}
+
+func TestPrefer(t *testing.T) {
+	portals := Portals(loadEndPoint(t, "multi-protocol.json"))
+	tests := []struct {
+		transports []string
+		want       []string
+	}{
+		{transports: []string{"rdma", "tcp"}, want: []string{"10.0.1.1:4420", "10.0.3.1:4421", "10.0.0.1:4420"}},
+		{transports: []string{"tcp", "rdma"}, want: []string{"10.0.0.1:4420", "10.0.1.1:4420", "10.0.3.1:4421"}},
+		{transports: []string{"tcp"}, want: []string{"10.0.0.1:4420"}},
+		{transports: nil, want: nil},
+	}
+	for _, tt := range tests {
+		var addresses []string
+		for _, portal := range Prefer(portals, tt.transports) {
+			addresses = append(addresses, portal.HostPort())
+		}
+		if !reflect.DeepEqual(addresses, tt.want) {
+			t.Errorf("Prefer(%v) portals %v, want %v", tt.transports, addresses, tt.want)
+		}
+	}
+}
+
+func portalsString(portals []*Portal) string {
+	content, _ := json.Marshal(portals)
+	return string(content)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package endpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// FuzzPortals checks that portals found in any endpoint payload have
+// a supported transport, the endpoint NQN and the address of their family
+func FuzzPortals(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+	}
+
+	supported := map[string]bool{}
+	for _, transport := range Transports() {
+		supported[transport] = true
+	}
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		var endPoint rsd.EndPoint
+		if err := json.Unmarshal(content, &endPoint); err != nil {
+			return
+		}
+		portals := Portals(&endPoint)
+		if len(portals) > len(endPoint.IPTransportDetails) {
+			t.Fatalf("%d portals of %d transport details", len(portals), len(endPoint.IPTransportDetails))
+		}
+		for _, portal := range portals {
+			if portal.Address == "" {
+				t.Errorf("portal without address: %+v", portal)
+			}
+			if portal.NQN != endPoint.GetNQN() {
+				t.Errorf("portal NQN %q, endpoint NQN %q", portal.NQN, endPoint.GetNQN())
+			}
+			if !supported[portal.Transport] {
+				t.Errorf("portal transport %q is not supported", portal.Transport)
+			}
+			if portal.AddressFamily != FamilyIPv4 && portal.AddressFamily != FamilyIPv6 {
+				t.Errorf("portal address family %q", portal.AddressFamily)
+			}
+			if _, _, err := net.SplitHostPort(portal.HostPort()); err != nil {
+				t.Errorf("invalid portal address %q: %v", portal.HostPort(), err)
+			}
+		}
+	})
+}
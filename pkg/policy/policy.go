@@ -0,0 +1,121 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy defines timeouts and retries of the RSD requests
+// and of the node tools shared by the RSD client and the driver
+package policy
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Retry defines how an operation is attempted until it succeeds
+type Retry struct {
+	// Attempts is the maximum number of attempts
+	Attempts int
+	// Delay is the delay after the first attempt
+	Delay time.Duration
+	// Backoff multiplies the delay after each attempt, the delay is constant if it's 0 or 1
+	Backoff float64
+	// MaxDelay caps the delay growing with Backoff, uncapped if it's 0
+	MaxDelay time.Duration
+	// Jitter randomizes the delays by up to this fraction of them, e.g. 0.2
+	// for delays 20% shorter or longer, so retries of many clients spread
+	Jitter float64
+}
+
+// DelayAfter returns the delay after the attempt, counted from 0
+func (r Retry) DelayAfter(attempt int) time.Duration {
+	delay := r.Delay
+	for i := 0; i < attempt && r.Backoff > 1; i++ {
+		delay = time.Duration(float64(delay) * r.Backoff)
+		if r.MaxDelay > 0 && delay >= r.MaxDelay {
+			return r.MaxDelay
+		}
+	}
+	return delay
+}
+
+// JitteredDelayAfter returns the delay after the attempt randomized by Jitter
+func (r Retry) JitteredDelayAfter(attempt int) time.Duration {
+	delay := r.DelayAfter(attempt)
+	if r.Jitter <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + r.Jitter*(2*rand.Float64()-1)))
+}
+
+// Total returns the sum of the delays between the attempts
+func (r Retry) Total() time.Duration {
+	var total time.Duration
+	for i := 0; i < r.Attempts-1; i++ {
+		total += r.DelayAfter(i)
+	}
+	return total
+}
+
+// WithTimeout returns the retry with the number of attempts
+// set so that their delays take at least the timeout
+func (r Retry) WithTimeout(timeout time.Duration) Retry {
+	if r.Delay <= 0 {
+		return r
+	}
+	r.Attempts = 1
+	for total := time.Duration(0); total < timeout; r.Attempts++ {
+		total += r.DelayAfter(r.Attempts - 1)
+	}
+	return r
+}
+
+// Policies are timeouts and retries of the driver operations. They are
+// constructed once from the command line flags and shared by the RSD
+// client, NVMe and mount tools.
+type Policies struct {
+	// ReadTimeout limits RSD GET requests, no limit if it's 0
+	ReadTimeout time.Duration
+	// WriteTimeout limits RSD requests creating or changing resources,
+	// e.g. volume creation or node actions, no limit if it's 0
+	WriteTimeout time.Duration
+	// TaskPoll is polling the asynchronous RSD tasks until they finish
+	TaskPoll Retry
+	// NodeAction is waiting for the resource to become allowed in the RSD node action
+	NodeAction Retry
+	// Request retries RSD requests failing with transient errors, not retried if Attempts is 1
+	Request Retry
+	// RetryStatusCodes are the HTTP status codes of transient RSD errors
+	RetryStatusCodes []int
+	// CommandTimeout limits nvme, mount and mkfs commands, no limit if it's 0
+	CommandTimeout time.Duration
+	// DeviceWait is waiting for the NVMe device to appear after connecting it
+	DeviceWait Retry
+	// EndpointWait is polling the RSD volume until its endpoints appear after
+	// attaching it, as some PODMs link them a few seconds later
+	EndpointWait Retry
+}
+
+// Default returns the default policies
+func Default() Policies {
+	return Policies{
+		ReadTimeout:      10 * time.Second,
+		WriteTimeout:     2 * time.Minute,
+		TaskPoll:         Retry{Attempts: 34, Delay: time.Second, Backoff: 2, MaxDelay: 10 * time.Second},
+		NodeAction:       Retry{Attempts: 34, Delay: time.Second, Backoff: 2, MaxDelay: 10 * time.Second, Jitter: 0.2},
+		Request:          Retry{Attempts: 3, Delay: 500 * time.Millisecond, Backoff: 2, MaxDelay: 5 * time.Second, Jitter: 0.2},
+		RetryStatusCodes: []int{429, 502, 503, 504},
+		CommandTimeout:   5 * time.Minute,
+		DeviceWait:       Retry{Attempts: 9, Delay: time.Second, Backoff: 2, MaxDelay: 8 * time.Second},
+		EndpointWait:     Retry{Attempts: 10, Delay: 500 * time.Millisecond, Backoff: 2, MaxDelay: 5 * time.Second},
+	}
+}
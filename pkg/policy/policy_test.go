@@ -0,0 +1,67 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	constant := Retry{Attempts: 3, Delay: 2 * time.Second}
+	backoff := Retry{Attempts: 5, Delay: time.Second, Backoff: 2, MaxDelay: 5 * time.Second}
+	for _, tt := range []struct {
+		name    string
+		retry   Retry
+		attempt int
+		want    time.Duration
+	}{
+		{name: "constant first", retry: constant, attempt: 0, want: 2 * time.Second},
+		{name: "constant later", retry: constant, attempt: 2, want: 2 * time.Second},
+		{name: "backoff first", retry: backoff, attempt: 0, want: time.Second},
+		{name: "backoff grows", retry: backoff, attempt: 2, want: 4 * time.Second},
+		{name: "backoff capped", retry: backoff, attempt: 3, want: 5 * time.Second},
+	} {
+		if got := tt.retry.DelayAfter(tt.attempt); got != tt.want {
+			t.Errorf("%s: DelayAfter(%d) = %v, want %v", tt.name, tt.attempt, got, tt.want)
+		}
+	}
+
+	jittered := Retry{Attempts: 3, Delay: time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if got := jittered.JitteredDelayAfter(0); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("JitteredDelayAfter(0) = %v, want 1s +-20%%", got)
+		}
+	}
+	if got := constant.JitteredDelayAfter(1); got != 2*time.Second {
+		t.Errorf("JitteredDelayAfter(1) without jitter = %v, want 2s", got)
+	}
+
+	// delays 1s, 2s, 4s, 8s and 10s after that: 33 delays wait for 305s
+	if got := Default().TaskPoll.WithTimeout(5 * time.Minute).Attempts; got != 34 {
+		t.Errorf("TaskPoll.WithTimeout(5m).Attempts = %d, want 34", got)
+	}
+	if got := Default().TaskPoll.Total(); got != 305*time.Second {
+		t.Errorf("TaskPoll.Total() = %v, want 305s", got)
+	}
+	// delays 0.5s, 1s, 2s, 4s and 5s after that: 9 delays wait for 32.5s
+	if got := Default().EndpointWait.WithTimeout(30 * time.Second).Attempts; got != 10 {
+		t.Errorf("EndpointWait.WithTimeout(30s).Attempts = %d, want 10", got)
+	}
+	// delays 1s, 2s, 4s, 5s
+	if got := backoff.WithTimeout(12 * time.Second).Attempts; got != 5 {
+		t.Errorf("WithTimeout(12s).Attempts = %d, want 5", got)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/intel/csi-intel-rsd/pkg/rsd"
+)
+
+// The declarations below fail to compile if the exported API of the package
+// changes incompatibly, which needs a new major version of the module
+var (
+	_ rsd.Transport      = (*rsd.Client)(nil)
+	_ rsd.EndPointGetter = (*rsd.Volume)(nil)
+	_ rsd.EndPointGetter = (*rsd.ComputerSystem)(nil)
+	_ rsd.EndPointGetter = (*rsd.Fabric)(nil)
+	_ rsd.EndPointGetter = (*rsd.Zone)(nil)
+	_ rsd.Deleter        = (*rsd.Volume)(nil)
+	_ rsd.Deleter        = (*rsd.Zone)(nil)
+	_ error              = rsd.ErrIncompleteResource
+	_ error              = (*rsd.RedfishError)(nil)
+	_ error              = (*rsd.TaskError)(nil)
+	_ error              = (*rsd.TaskPendingError)(nil)
+	_ rsd.Transport      = (*rsd.RetryTransport)(nil)
+
+	_ func(string, string, string, *http.Client) (*rsd.Client, error)                  = rsd.NewClient
+	_ func(context.Context, rsd.Transport, string, interface{}) error                  = rsd.GetByOdataID
+	_ func([]byte) *rsd.RedfishError                                                   = rsd.ParseRedfishError
+	_ func(context.Context, rsd.Transport) (*rsd.StorageServiceCollection, error)      = rsd.GetStorageServiceCollection
+	_ func(context.Context, rsd.Transport) (*rsd.StorageService, error)                = rsd.GetDefaultStorageService
+	_ func(context.Context, rsd.Transport, string) (*rsd.StorageService, error)        = rsd.GetStorageServiceByID
+	_ func(context.Context, rsd.Transport, string) (*rsd.VolumeCollection, error)      = rsd.GetVolumeCollectionByService
+	_ func(context.Context, rsd.Transport, string, string) (*rsd.Volume, error)        = rsd.GetVolumeByService
+	_ func(context.Context, rsd.Transport, string) (*rsd.Volume, error)                = rsd.GetVolumeByPath
+	_ func(context.Context, rsd.Transport, string) (*rsd.StoragePoolCollection, error) = rsd.GetStoragePoolCollectionByService
+	_ func(context.Context, rsd.Transport, string) (*rsd.Fabric, error)                = rsd.GetFabricByID
+	_ func(context.Context, rsd.Transport, string) (*rsd.Node, error)                  = rsd.GetNode
+	_ func(context.Context, rsd.Transport, string, policy.Retry) (*rsd.Task, error)    = rsd.WaitForTask
+	_ func(context.Context, rsd.Transport, string) (*rsd.Volume, error)                = rsd.NewVolumeOfTask
+	_ func(error) rsd.ErrorCategory                                                    = rsd.Classify
+	_ func(rsd.Transport) *rsd.RetryTransport                                          = rsd.NewRetryTransport
+
+	_ func(*rsd.Client, context.Context, string, interface{}) error                              = (*rsd.Client).Get
+	_ func(*rsd.Client, context.Context, string, interface{}, interface{}) (*http.Header, error) = (*rsd.Client).Post
+
+	_ func(*rsd.VolumeCollection, context.Context, rsd.Transport, *rsd.NewVolumeRequest) (*rsd.Volume, error) = (*rsd.VolumeCollection).NewVolume
+	_ func(*rsd.VolumeCollection, context.Context, rsd.Transport, string) (*rsd.Volume, error)                = (*rsd.VolumeCollection).GetVolume
+	_ func(*rsd.Volume, context.Context, rsd.Transport, int64) error                                          = (*rsd.Volume).SetCapacity
+	_ func(*rsd.Node, context.Context, rsd.Transport, string) error                                           = (*rsd.Node).AttachResource
+	_ func(*rsd.Node, context.Context, rsd.Transport, string) error                                           = (*rsd.Node).DetachResource
+
+	// deprecated positional API
+	_ func(context.Context, rsd.Transport, int) (*rsd.StorageService, error)        = rsd.GetStorageService
+	_ func(context.Context, rsd.Transport, int) (*rsd.VolumeCollection, error)      = rsd.GetVolumeCollection
+	_ func(context.Context, rsd.Transport, int, string) (*rsd.Volume, error)        = rsd.GetVolume
+	_ func(context.Context, rsd.Transport, int) (*rsd.StoragePoolCollection, error) = rsd.GetStoragePoolCollection
+	_ func(context.Context, rsd.Transport, int) (*rsd.Fabric, error)                = rsd.GetFabric
+)
+
+func TestDeprecatedWrappers(t *testing.T) {
+	ctx := context.Background()
+	resources := map[string]string{
+		rsd.StorageServiceCollectionEntryPoint:    `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}, {"@odata.id": "/redfish/v1/StorageServices/2"}]}`,
+		"/redfish/v1/StorageServices/1":           `{"Id": "1", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+		"/redfish/v1/StorageServices/2":           `{"Id": "2", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/2/Volumes"}}`,
+		"/redfish/v1/StorageServices/1/Volumes":   `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes", "Members": []}`,
+		"/redfish/v1/StorageServices/2/Volumes":   `{"@odata.id": "/redfish/v1/StorageServices/2/Volumes", "Members": [{"@odata.id": "/redfish/v1/StorageServices/2/Volumes/a"}]}`,
+		"/redfish/v1/StorageServices/2/Volumes/a": `{"Id": "a", "CapacityBytes": 100}`,
+		rsd.FabricCollectionEntryPoint:            `{"Members": [{"@odata.id": "/redfish/v1/Fabrics/1"}, {"@odata.id": "/redfish/v1/Fabrics/2"}]}`,
+		"/redfish/v1/Fabrics/1":                   `{"Id": "1"}`,
+		"/redfish/v1/Fabrics/2":                   `{"Id": "2"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		content, ok := resources[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	client, err := rsd.NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := rsd.GetStorageService(ctx, client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultService, err := rsd.GetDefaultStorageService(ctx, client); err != nil || defaultService.ID != service.ID {
+		t.Errorf("GetDefaultStorageService() = %v, %v, want storage service %s", defaultService, err, service.ID)
+	}
+
+	volumes, err := rsd.GetVolumeCollection(ctx, client, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byService, err := rsd.GetVolumeCollectionByService(ctx, client, "2"); err != nil || byService.OdataID != volumes.OdataID {
+		t.Errorf("GetVolumeCollectionByService() = %v, %v, want %s", byService, err, volumes.OdataID)
+	}
+
+	volume, err := rsd.GetVolume(ctx, client, 1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byService, err := rsd.GetVolumeByService(ctx, client, "2", "a"); err != nil || byService.OdataID != volume.OdataID {
+		t.Errorf("GetVolumeByService() = %v, %v, want %s", byService, err, volume.OdataID)
+	}
+
+	fabric, err := rsd.GetFabric(ctx, client, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byID, err := rsd.GetFabricByID(ctx, client, "2"); err != nil || byID.ID != fabric.ID {
+		t.Errorf("GetFabricByID() = %v, %v, want fabric %s", byID, err, fabric.ID)
+	}
+	if first, err := rsd.GetFabricByID(ctx, client, ""); err != nil || first.ID != "1" {
+		t.Errorf("GetFabricByID(\"\") = %v, %v, want fabric 1", first, err)
+	}
+	if _, err := rsd.GetFabricByID(ctx, client, "3"); rsd.Classify(err) != rsd.CategoryNotFound {
+		t.Errorf("GetFabricByID() error = %v, want not found", err)
+	}
+}
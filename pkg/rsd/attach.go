@@ -0,0 +1,111 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Parameters of the ComposedNode.AttachResource action besides the Resource
+const (
+	attachProtocolParameter         = "Protocol"
+	attachMinBandwidthMbpsParameter = "MinBandwidthMbps"
+	attachMaxBandwidthMbpsParameter = "MaxBandwidthMbps"
+	attachMaxIOPSParameter          = "MaxIOPS"
+)
+
+// AttachOptions are optional parameters of the ComposedNode.AttachResource
+// action. Newer PODM versions use them to pick the protocol of the attachment
+// and to reserve fabric bandwidth for it. Zero values are not sent.
+type AttachOptions struct {
+	// Protocol of the attachment, e.g. NVMeOverFabrics
+	Protocol string
+	// MinBandwidthMbps is the fabric bandwidth reserved for the attachment
+	MinBandwidthMbps int64
+	// MaxBandwidthMbps limits the fabric bandwidth of the attachment
+	MaxBandwidthMbps int64
+	// MaxIOPS limits I/O operations per second of the attachment
+	MaxIOPS int64
+}
+
+// parameters returns action parameters of the options which are set
+func (opts *AttachOptions) parameters() map[string]interface{} {
+	result := map[string]interface{}{}
+	if opts == nil {
+		return result
+	}
+	if opts.Protocol != "" {
+		result[attachProtocolParameter] = opts.Protocol
+	}
+	for name, value := range map[string]int64{
+		attachMinBandwidthMbpsParameter: opts.MinBandwidthMbps,
+		attachMaxBandwidthMbpsParameter: opts.MaxBandwidthMbps,
+		attachMaxIOPSParameter:          opts.MaxIOPS,
+	} {
+		if value > 0 {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// actionParameters returns names of the parameters declared by the action info.
+// It returns false if the node doesn't provide the action info.
+func (node *Node) actionParameters(ctx context.Context, rsd Transport, actionResource ComposedNodeResource) (map[string]bool, bool, error) {
+	if actionResource.RedfishActionInfo.OdataID == "" {
+		return nil, false, nil
+	}
+	var actionInfo ActionInfo
+	if err := GetByOdataID(ctx, rsd, actionResource.RedfishActionInfo.OdataID, &actionInfo); err != nil {
+		return nil, false, errors.Wrapf(err, "node %s: can't get action info %s", node.ID, actionResource.RedfishActionInfo.OdataID)
+	}
+	result := map[string]bool{}
+	for _, parameter := range actionInfo.Parameters {
+		result[parameter.Name] = true
+	}
+	return result, true, nil
+}
+
+// AttachResourceWithOptions attaches resource to the node with the options.
+// Older PODM versions reject unknown action parameters, so parameters not
+// declared by the action info of the node are left out. Their names are
+// returned sorted. All of them are sent if the node has no action info.
+func (node *Node) AttachResourceWithOptions(ctx context.Context, rsd Transport, resourceOdataID string, opts *AttachOptions) ([]string, error) {
+	actionResource := node.Actions.ComposedNodeAttachResource
+	if err := node.WaitForAllowed(ctx, rsd, resourceOdataID, actionResource, policiesOf(rsd).NodeAction); err != nil {
+		return nil, err
+	}
+
+	parameters := opts.parameters()
+	var ignored []string
+	if len(parameters) > 0 {
+		declared, ok, err := node.actionParameters(ctx, rsd, actionResource)
+		if err != nil {
+			return nil, err
+		}
+		for name := range parameters {
+			if ok && !declared[name] {
+				ignored = append(ignored, name)
+				delete(parameters, name)
+			}
+		}
+		sort.Strings(ignored)
+	}
+
+	return ignored, node.actionWithParameters(ctx, rsd, resourceOdataID, actionResource.Target, parameters)
+}
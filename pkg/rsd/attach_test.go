@@ -0,0 +1,117 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttachResourceWithOptions(t *testing.T) {
+	const (
+		actionURL     = "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource"
+		actionInfoURL = "/redfish/v1/Nodes/1/Actions/AttachResourceActionInfo"
+		volumeURL     = "/redfish/v1/StorageServices/1/Volumes/1"
+	)
+	opts := &AttachOptions{Protocol: "NVMeOverFabrics", MinBandwidthMbps: 1000, MaxIOPS: 50000}
+
+	var tcases = []struct {
+		name        string
+		opts        *AttachOptions
+		noLink      bool
+		wantBody    map[string]interface{}
+		wantIgnored []string
+	}{
+		{
+			name: "No options",
+			wantBody: map[string]interface{}{
+				"Resource": map[string]interface{}{"@odata.id": volumeURL},
+			},
+		},
+		{
+			name: "Undeclared options left out",
+			opts: opts,
+			wantBody: map[string]interface{}{
+				"Resource": map[string]interface{}{"@odata.id": volumeURL},
+				"Protocol": "NVMeOverFabrics",
+			},
+			wantIgnored: []string{"MaxIOPS", "MinBandwidthMbps"},
+		},
+		{
+			name:   "No ActionInfo link",
+			opts:   opts,
+			noLink: true,
+			wantBody: map[string]interface{}{
+				"Resource":         map[string]interface{}{"@odata.id": volumeURL},
+				"Protocol":         "NVMeOverFabrics",
+				"MinBandwidthMbps": float64(1000),
+				"MaxIOPS":          float64(50000),
+			},
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case actionInfoURL:
+					rw.Write([]byte(`{"Parameters": [
+						{"Name": "Protocol", "AllowableValues": ["NVMeOverFabrics"]},
+						{"Name": "Resource", "AllowableValues": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}
+					]}`))
+				case actionURL:
+					content, _ := ioutil.ReadAll(req.Body)
+					if err := json.Unmarshal(content, &body); err != nil {
+						t.Errorf("can't decode action body %q: %v", content, err)
+					}
+					rw.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+					rw.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			node := &Node{ID: "1"}
+			node.Actions.ComposedNodeAttachResource.Target = actionURL
+			if !tc.noLink {
+				node.Actions.ComposedNodeAttachResource.RedfishActionInfo.OdataID = actionInfoURL
+			}
+
+			ignored, err := node.AttachResourceWithOptions(context.Background(), rsdClient, volumeURL, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(ignored, tc.wantIgnored) {
+				t.Errorf("ignored parameters %v, want %v", ignored, tc.wantIgnored)
+			}
+			if !reflect.DeepEqual(body, tc.wantBody) {
+				t.Errorf("action body %v, want %v", body, tc.wantBody)
+			}
+		})
+	}
+}
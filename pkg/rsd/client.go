@@ -16,28 +16,45 @@ package rsd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/intel/csi-intel-rsd/pkg/policy"
 	"github.com/pkg/errors"
 )
 
-// Transport is an interface to communicate with RSD server
+// Transport is an interface to communicate with RSD server. Requests are
+// cancelled when their context is done.
 type Transport interface {
-	Get(entrypoint string, result interface{}) error
-	Post(entrypoint string, data interface{}, result interface{}) (*http.Header, error)
-	Delete(entrypoint string, data interface{}, result interface{}) (*http.Header, error)
+	Get(ctx context.Context, entrypoint string, result interface{}) error
+	Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error)
+	Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error)
+	Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error)
 }
 
 // Client is a struct that interfaces with the RSD Redfish API
 type Client struct {
+	// mu guards baseurl, credentials and closed
+	mu         sync.RWMutex
 	baseurl    string
 	username   string
 	password   string
+	closed     bool
 	httpClient *http.Client
+	policies   policy.Policies
+	recorder   *Recorder
+	schemas    *Schemas
+	// logRequest logs the requests, nil if they're not logged
+	logRequest RequestLogger
+
+	// inflight counts requests in progress, so Close can wait for them
+	inflight sync.WaitGroup
 }
 
 // NewClient creates new RSD Client
@@ -47,42 +64,176 @@ func NewClient(baseurl, username, password string, httpClient *http.Client) (*Cl
 		username:   username,
 		password:   password,
 		httpClient: httpClient,
+		policies:   policy.Default(),
 	}, nil
 }
 
+// SetPolicies sets timeouts and retries of the RSD requests
+func (rsd *Client) SetPolicies(policies policy.Policies) {
+	rsd.policies = policies
+}
+
+// Policies returns timeouts and retries of the RSD requests
+func (rsd *Client) Policies() policy.Policies {
+	return rsd.policies
+}
+
+// SetRecorder makes the client record responses for diagnostics
+func (rsd *Client) SetRecorder(recorder *Recorder) {
+	rsd.recorder = recorder
+}
+
+// SetSchemas makes the client record OData schema versions of the key resources
+func (rsd *Client) SetSchemas(schemas *Schemas) {
+	rsd.schemas = schemas
+}
+
+// credentialsKey is the context key of the request credentials
+type credentialsKey struct{}
+
+// requestCredentials are RSD credentials overriding the ones of the client
+type requestCredentials struct {
+	username string
+	password string
+}
+
+// WithCredentials returns context making the client send its requests with
+// the credentials instead of its own ones, e.g. with credentials of a role
+// allowed to modify the resources
+func WithCredentials(ctx context.Context, username, password string) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, requestCredentials{username: username, password: password})
+}
+
+// RequestLogger logs a request sent to RSD. Credentials are redacted from
+// the url and body, unless redaction is disabled by SetRedaction.
+type RequestLogger func(method, url, body string)
+
+// SetRequestLogger makes the client log the requests it sends
+func (rsd *Client) SetRequestLogger(logger RequestLogger) {
+	rsd.logRequest = logger
+}
+
+// Reconfigure changes the RSD URL and credentials of the client. Idle
+// connections are closed, so they are not reused with the old configuration.
+// Requests in progress are finished with the old configuration.
+func (rsd *Client) Reconfigure(baseurl, username, password string) {
+	rsd.mu.Lock()
+	rsd.baseurl = baseurl
+	rsd.username = username
+	rsd.password = password
+	rsd.mu.Unlock()
+
+	rsd.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes idle connections of the underlying http.Client
+func (rsd *Client) CloseIdleConnections() {
+	rsd.httpClient.CloseIdleConnections()
+}
+
+// Close waits for the requests in progress to finish and closes
+// the connections to RSD. Requests made after Close fail.
+func (rsd *Client) Close() error {
+	rsd.mu.Lock()
+	rsd.closed = true
+	rsd.mu.Unlock()
+
+	rsd.inflight.Wait()
+	rsd.CloseIdleConnections()
+	return nil
+}
+
+// begin registers new request and returns the RSD URL and credentials for it.
+// The request must be finished by calling rsd.inflight.Done().
+func (rsd *Client) begin() (baseurl, username, password string, err error) {
+	rsd.mu.Lock()
+	defer rsd.mu.Unlock()
+	if rsd.closed {
+		return "", "", "", errors.New("RSD client is closed")
+	}
+	rsd.inflight.Add(1)
+	return rsd.baseurl, rsd.username, rsd.password, nil
+}
+
+// requestTimeout returns timeout for the request method
+func (rsd *Client) requestTimeout(method string) time.Duration {
+	if method == http.MethodGet {
+		return rsd.policies.ReadTimeout
+	}
+	return rsd.policies.WriteTimeout
+}
+
 // request queries sends HTTP request to the RSD endpoint and decodes HTTP response
-func (rsd *Client) request(entrypoint, method string, body io.Reader, result interface{}) (*http.Header, error) {
-	url := rsd.baseurl + entrypoint
-	req, err := http.NewRequest(method, url, body)
+func (rsd *Client) request(ctx context.Context, entrypoint, method string, body []byte, result interface{}) (*http.Header, error) {
+	baseurl, username, password, err := rsd.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer rsd.inflight.Done()
+	if credentials, ok := ctx.Value(credentialsKey{}).(requestCredentials); ok {
+		username, password = credentials.username, credentials.password
+	}
+
+	url := baseurl + entrypoint
+	if rsd.logRequest != nil {
+		rsd.logRequest(method, Redact(url), Redact(string(body)))
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can't make request from %s", url)
 	}
 
-	if rsd.username != "" {
-		req.SetBasicAuth(rsd.username, rsd.password)
+	if username != "" {
+		req.SetBasicAuth(username, password)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
+	if timeout := rsd.requestTimeout(method); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+
 	resp, err := rsd.httpClient.Do(req)
 	if err != nil {
+		rsd.recorder.record(method, url, 0, nil, err)
 		return nil, errors.Wrapf(err, "Can't get http response from %s", url)
 	}
 
 	defer resp.Body.Close() // nolint: errcheck
 
-	if resp.StatusCode >= 400 {
+	if rsd.recorder != nil || rsd.schemas != nil {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		rsd.recorder.record(method, url, resp.StatusCode, respBody, err)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't read http response from %s", url)
+		}
+		if method == http.MethodGet && resp.StatusCode == http.StatusOK {
+			rsd.schemas.record(url, respBody)
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	}
+
+	// redirects are followed by the HTTP client, other statuses than 2xx are errors
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		respBody, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, errors.Wrapf(err, "HTTP error %d while requesting %s: can't read response body", resp.StatusCode, url)
 		}
-		return nil, fmt.Errorf("HTTP error %d while requesting %s: %s", resp.StatusCode, url, string(respBody))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, URL: url, Body: string(respBody), Redfish: ParseRedfishError(respBody)}
 	}
 
-	// Decode response if needed
+	// Decode response if needed, empty response body is not an error
 	if result != nil {
 		err = json.NewDecoder(resp.Body).Decode(result)
-		if err != nil {
+		if err != nil && err != io.EOF {
 			return &resp.Header, errors.Wrapf(err, "Can't decode http response from %s", url)
 		}
 	}
@@ -91,33 +242,42 @@ func (rsd *Client) request(entrypoint, method string, body io.Reader, result int
 }
 
 // Get sends GET RSD endpoint and returns decoded http response
-func (rsd *Client) Get(entrypoint string, result interface{}) error {
-	_, err := rsd.request(entrypoint, "GET", nil, result)
+func (rsd *Client) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	_, err := rsd.request(ctx, entrypoint, "GET", nil, result)
 	return err
 }
 
 // Post sends POST request to RSD endpoint and returns decoded http response
-func (rsd *Client) Post(entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+func (rsd *Client) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
 	marshalled, err := json.Marshal(data)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can't marshal data: %v", data)
 	}
-	return rsd.request(entrypoint, "POST", bytes.NewReader(marshalled), result)
+	return rsd.request(ctx, entrypoint, "POST", marshalled, result)
 }
 
 // Delete sends DELETE request to RSD endpoint
-func (rsd *Client) Delete(entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+func (rsd *Client) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
 	marshalled, err := json.Marshal(data)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can't marshal data: %v", data)
 	}
-	return rsd.request(entrypoint, "DELETE", bytes.NewReader(marshalled), result)
+	return rsd.request(ctx, entrypoint, "DELETE", marshalled, result)
+}
+
+// Patch sends PATCH request to RSD endpoint to update resource properties
+func (rsd *Client) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	marshalled, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't marshal data: %v", data)
+	}
+	return rsd.request(ctx, entrypoint, "PATCH", marshalled, result)
 }
 
 // GetStorageServiceCollection returns StorageServiceCollection
-func GetStorageServiceCollection(rsd Transport) (*StorageServiceCollection, error) {
+func GetStorageServiceCollection(ctx context.Context, rsd Transport) (*StorageServiceCollection, error) {
 	var result StorageServiceCollection
-	err := rsd.Get(StorageServiceCollectionEntryPoint, &result)
+	err := rsd.Get(ctx, StorageServiceCollectionEntryPoint, &result)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't query StorageServiceCollection")
 	}
@@ -126,13 +286,25 @@ func GetStorageServiceCollection(rsd Transport) (*StorageServiceCollection, erro
 }
 
 // GetStorageService returns storage service by its index
-func GetStorageService(rsd Transport, ssNum int) (*StorageService, error) {
-	ssCollection, err := GetStorageServiceCollection(rsd)
+//
+// Deprecated: GetStorageService relies on the order of the storage services,
+// use GetStorageServiceByID or GetDefaultStorageService instead.
+func GetStorageService(ctx context.Context, rsd Transport, ssNum int) (*StorageService, error) {
+	return storageServiceAt(ctx, rsd, ssNum)
+}
+
+// GetDefaultStorageService returns the first storage service of the collection
+func GetDefaultStorageService(ctx context.Context, rsd Transport) (*StorageService, error) {
+	return storageServiceAt(ctx, rsd, 0)
+}
+
+func storageServiceAt(ctx context.Context, rsd Transport, ssNum int) (*StorageService, error) {
+	ssCollection, err := GetStorageServiceCollection(ctx, rsd)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't get storage service collection")
 	}
 
-	services, err := ssCollection.GetMembers(rsd)
+	services, err := ssCollection.GetMembers(ctx, rsd)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't get storage service collection members")
 	}
@@ -140,28 +312,78 @@ func GetStorageService(rsd Transport, ssNum int) (*StorageService, error) {
 	if len(services) == 0 {
 		return nil, errors.New("No storage services found in a collection")
 	}
+	if ssNum < 0 || ssNum >= len(services) {
+		return nil, newNotFoundError("storage service %d not found, %d services in a collection", ssNum, len(services))
+	}
 
 	return services[ssNum], nil
 }
 
+// GetStorageServiceByID returns storage service by its Id
+func GetStorageServiceByID(ctx context.Context, rsd Transport, serviceID string) (*StorageService, error) {
+	ssCollection, err := GetStorageServiceCollection(ctx, rsd)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get storage service collection")
+	}
+
+	for _, member := range ssCollection.Members {
+		var service StorageService
+		if err := rsd.Get(ctx, member.OdataID, &service); err != nil {
+			return nil, errors.Wrapf(err, "Can't query StorageServiceCollection members %s", member.OdataID)
+		}
+		if service.ID == serviceID {
+			return &service, nil
+		}
+	}
+	return nil, newNotFoundError("storage service id %s not found", serviceID)
+}
+
 // GetVolumeCollection returns VolumeCollection for the storage service <ssNum>
-func GetVolumeCollection(rsd Transport, ssNum int) (*VolumeCollection, error) {
-	storageService, err := GetStorageService(rsd, ssNum)
+//
+// Deprecated: GetVolumeCollection relies on the order of the storage services,
+// use GetVolumeCollectionByService instead.
+func GetVolumeCollection(ctx context.Context, rsd Transport, ssNum int) (*VolumeCollection, error) {
+	storageService, err := storageServiceAt(ctx, rsd, ssNum)
+	if err != nil {
+		return nil, err
+	}
+	return storageService.GetVolumeCollection(ctx, rsd)
+}
+
+// GetVolumeCollectionByService returns VolumeCollection of the storage service
+// with the Id serviceID, the default storage service if serviceID is empty
+func GetVolumeCollectionByService(ctx context.Context, rsd Transport, serviceID string) (*VolumeCollection, error) {
+	storageService, err := getStorageService(ctx, rsd, serviceID)
 	if err != nil {
 		return nil, err
 	}
-	return storageService.GetVolumeCollection(rsd)
+	return storageService.GetVolumeCollection(ctx, rsd)
+}
+
+// getStorageService returns storage service by its Id or the default one
+func getStorageService(ctx context.Context, rsd Transport, serviceID string) (*StorageService, error) {
+	if serviceID == "" {
+		return GetDefaultStorageService(ctx, rsd)
+	}
+	return GetStorageServiceByID(ctx, rsd, serviceID)
 }
 
 // GetVolume returns Volume by storage collection id and volume id
-func GetVolume(rsd Transport, ssNum int, volID string) (*Volume, error) {
+//
+// Deprecated: GetVolume relies on the order of the storage services, use
+// GetVolumeByService or GetVolumeByPath instead.
+func GetVolume(ctx context.Context, rsd Transport, ssNum int, volID string) (*Volume, error) {
 	// Get Volume collection
-	volCollection, err := GetVolumeCollection(rsd, ssNum)
+	storageService, err := storageServiceAt(ctx, rsd, ssNum)
+	if err != nil {
+		return nil, err
+	}
+	volCollection, err := storageService.GetVolumeCollection(ctx, rsd)
 	if err != nil {
 		return nil, err
 	}
 
-	volumes, err := volCollection.GetMembers(rsd)
+	volumes, err := volCollection.GetMembers(ctx, rsd)
 	if err != nil {
 		return nil, err
 	}
@@ -174,10 +396,35 @@ func GetVolume(rsd Transport, ssNum int, volID string) (*Volume, error) {
 	return nil, fmt.Errorf("volume id %s not found", volID)
 }
 
+// GetVolumeByService returns Volume by its Id in the storage service with the Id serviceID
+func GetVolumeByService(ctx context.Context, rsd Transport, serviceID, volumeID string) (*Volume, error) {
+	volCollection, err := GetVolumeCollectionByService(ctx, rsd, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	return volCollection.GetVolume(ctx, rsd, volumeID)
+}
+
+// GetVolumeByPath returns Volume by its @odata.id. It fails with
+// ErrIncompleteResource if RSD returns the volume without required fields.
+func GetVolumeByPath(ctx context.Context, rsd Transport, odataID string) (*Volume, error) {
+	var volume Volume
+	if err := rsd.Get(ctx, odataID, &volume); err != nil {
+		return nil, errors.Wrapf(err, "Can't query volume %s", odataID)
+	}
+	if err := validateResource(odataID, &volume); err != nil {
+		return nil, err
+	}
+	if volume.OdataID == "" {
+		volume.OdataID = odataID
+	}
+	return &volume, nil
+}
+
 // GetNodesCollection returns RSD NodesCollection
-func GetNodesCollection(rsd Transport) (*NodesCollection, error) {
+func GetNodesCollection(ctx context.Context, rsd Transport) (*NodesCollection, error) {
 	var result NodesCollection
-	err := rsd.Get(NodesCollectionEntryPoint, &result)
+	err := rsd.Get(ctx, NodesCollectionEntryPoint, &result)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't query NodeCollection")
 	}
@@ -186,15 +433,15 @@ func GetNodesCollection(rsd Transport) (*NodesCollection, error) {
 }
 
 // GetNode gets node by id
-func GetNode(rsd Transport, nodeID string) (*Node, error) {
+func GetNode(ctx context.Context, rsd Transport, nodeID string) (*Node, error) {
 	// Get nodes collection
-	nodesCollection, err := GetNodesCollection(rsd)
+	nodesCollection, err := GetNodesCollection(ctx, rsd)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get nodes
-	nodes, err := nodesCollection.GetMembers(rsd)
+	nodes, err := nodesCollection.GetMembers(ctx, rsd)
 	if err != nil {
 		return nil, err
 	}
@@ -205,14 +452,27 @@ func GetNode(rsd Transport, nodeID string) (*Node, error) {
 			return node, nil
 		}
 	}
-	return nil, fmt.Errorf("node id %s not found", nodeID)
+	return nil, newNotFoundError("node id %s not found", nodeID)
 }
 
 // GetStoragePoolCollection returns StoragePoolCollection for the storage service <ssNum>
-func GetStoragePoolCollection(rsd Transport, ssNum int) (*StoragePoolCollection, error) {
-	storageService, err := GetStorageService(rsd, ssNum)
+//
+// Deprecated: GetStoragePoolCollection relies on the order of the storage
+// services, use GetStoragePoolCollectionByService instead.
+func GetStoragePoolCollection(ctx context.Context, rsd Transport, ssNum int) (*StoragePoolCollection, error) {
+	storageService, err := storageServiceAt(ctx, rsd, ssNum)
+	if err != nil {
+		return nil, err
+	}
+	return storageService.GetStoragePoolCollection(ctx, rsd)
+}
+
+// GetStoragePoolCollectionByService returns StoragePoolCollection of the storage
+// service with the Id serviceID, the default storage service if serviceID is empty
+func GetStoragePoolCollectionByService(ctx context.Context, rsd Transport, serviceID string) (*StoragePoolCollection, error) {
+	storageService, err := getStorageService(ctx, rsd, serviceID)
 	if err != nil {
 		return nil, err
 	}
-	return storageService.GetStoragePoolCollection(rsd)
+	return storageService.GetStoragePoolCollection(ctx, rsd)
 }
@@ -15,11 +15,15 @@
 package rsd
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/pkg/errors"
 )
 
 func TestGetStorageServiceCollection(t *testing.T) {
@@ -56,7 +60,7 @@ func TestGetStorageServiceCollection(t *testing.T) {
 				t.Fatalf("%+v", err)
 			}
 
-			ssCollection, err := GetStorageServiceCollection(rsdClient)
+			ssCollection, err := GetStorageServiceCollection(context.Background(), rsdClient)
 			if err == nil && tc.isError {
 				t.Error("unexpected success")
 			}
@@ -67,3 +71,388 @@ func TestGetStorageServiceCollection(t *testing.T) {
 		})
 	}
 }
+
+func TestNewVolume(t *testing.T) {
+	const (
+		collectionURL = "/redfish/v1/StorageServices/1/Volumes"
+		volumeURL     = "/redfish/v1/StorageServices/1/Volumes/1"
+		taskURL       = "/redfish/v1/TaskService/Tasks/1"
+		volumeJSON    = `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1", "Id": "1", "CapacityBytes": 100}`
+	)
+
+	var tcases = []struct {
+		name     string
+		isError  bool
+		location string
+		status   int
+		body     string
+		task     string
+		monitor  string
+	}{
+		{
+			name:     "Location header",
+			location: volumeURL,
+			status:   http.StatusCreated,
+		},
+		{
+			name:   "Volume in response body",
+			status: http.StatusCreated,
+			body:   volumeJSON,
+		},
+		{
+			name:    "No Location header nor volume",
+			isError: true,
+			status:  http.StatusCreated,
+		},
+		{
+			name:     "Task monitor",
+			location: taskURL + "/Monitor",
+			status:   http.StatusAccepted,
+			task:     `{"Id": "1", "TaskState": "Completed"}`,
+			monitor:  volumeJSON,
+		},
+		{
+			name:     "Failed task",
+			isError:  true,
+			location: taskURL + "/Monitor",
+			status:   http.StatusAccepted,
+			task:     `{"Id": "1", "TaskState": "Exception", "Messages": [{"Message": "No space left"}]}`,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch {
+				case req.Method == http.MethodPost && req.URL.Path == collectionURL:
+					if tc.location != "" {
+						rw.Header().Set("Location", tc.location)
+					}
+					rw.WriteHeader(tc.status)
+					rw.Write([]byte(tc.body))
+				case req.URL.Path == volumeURL:
+					rw.Write([]byte(volumeJSON))
+				case req.URL.Path == taskURL:
+					rw.Write([]byte(tc.task))
+				case req.URL.Path == taskURL+"/Monitor":
+					rw.Write([]byte(tc.monitor))
+				default:
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+					rw.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			collection := &VolumeCollection{OdataID: collectionURL}
+			volume, err := collection.NewVolume(context.Background(), rsdClient, &NewVolumeRequest{CapacityBytes: 100})
+			if tc.isError {
+				if err == nil {
+					t.Error("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if volume.ID != "1" || volume.CapacityBytes != 100 {
+				t.Errorf("unexpected volume: %+v", volume)
+			}
+		})
+	}
+}
+
+func TestGetVolumeByService(t *testing.T) {
+	ctx := context.Background()
+	resources := map[string]string{
+		StorageServiceCollectionEntryPoint:      `{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1"}, {"@odata.id": "/redfish/v1/StorageServices/2"}]}`,
+		"/redfish/v1/StorageServices/1":         `{"Id": "1", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes"}}`,
+		"/redfish/v1/StorageServices/2":         `{"Id": "2", "Volumes": {"@odata.id": "/redfish/v1/StorageServices/2/Volumes"}}`,
+		"/redfish/v1/StorageServices/1/Volumes": `{"@odata.id": "/redfish/v1/StorageServices/1/Volumes", "Members": []}`,
+		"/redfish/v1/StorageServices/2/Volumes": `{"@odata.id": "/redfish/v1/StorageServices/2/Volumes", "Members": [
+			{"@odata.id": "/redfish/v1/StorageServices/2/Volumes/a"}, {"@odata.id": "/redfish/v1/StorageServices/2/Volumes/x"}]}`,
+		"/redfish/v1/StorageServices/2/Volumes/a": `{"Id": "a", "CapacityBytes": 100}`,
+		"/redfish/v1/StorageServices/2/Volumes/x": `{"Id": "b", "CapacityBytes": 200}`,
+		"/redfish/v1/StorageServices/2/Volumes/e": `{}`,
+	}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.Path)
+		content, ok := resources[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var tcases = []struct {
+		name      string
+		serviceID string
+		volumeID  string
+		want      string
+		wantErr   ErrorCategory
+	}{
+		{name: "Volume path", serviceID: "2", volumeID: "a", want: "/redfish/v1/StorageServices/2/Volumes/a"},
+		{name: "Other volume path", serviceID: "2", volumeID: "b", want: "/redfish/v1/StorageServices/2/Volumes/x"},
+		{name: "Other storage service", serviceID: "1", volumeID: "a", wantErr: CategoryNotFound},
+		{name: "Unknown storage service", serviceID: "3", volumeID: "a", wantErr: CategoryNotFound},
+	}
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests = nil
+			volume, err := GetVolumeByService(ctx, rsdClient, tc.serviceID, tc.volumeID)
+			if category := Classify(err); category != tc.wantErr {
+				t.Fatalf("GetVolumeByService() error = %v, want category %q", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if volume.ID != tc.volumeID || volume.OdataID != tc.want {
+				t.Errorf("GetVolumeByService() = %s %s, want %s %s", volume.ID, volume.OdataID, tc.volumeID, tc.want)
+			}
+		})
+	}
+
+	// the volume named by its Id is queried directly
+	requests = nil
+	if _, err := GetVolumeByService(ctx, rsdClient, "2", "a"); err != nil {
+		t.Fatalf("GetVolumeByService() unexpected error: %v", err)
+	}
+	for _, path := range requests {
+		if path == "/redfish/v1/StorageServices/2/Volumes/x" {
+			t.Errorf("unexpected query of other volume: %v", requests)
+		}
+	}
+
+	if _, err := GetVolumeByPath(ctx, rsdClient, "/redfish/v1/StorageServices/2/Volumes/e"); errors.Cause(err) != ErrIncompleteResource {
+		t.Errorf("GetVolumeByPath() error = %v, want %v", err, ErrIncompleteResource)
+	}
+	if _, err := GetStorageService(ctx, rsdClient, 2); Classify(err) != CategoryNotFound {
+		t.Errorf("GetStorageService() error = %v, want not found", err)
+	}
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			time.Sleep(200 * time.Millisecond)
+		}
+		rw.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	policies := policy.Default()
+	policies.ReadTimeout = 10 * time.Second
+	policies.WriteTimeout = 20 * time.Millisecond
+	policies.TaskPoll = policies.TaskPoll.WithTimeout(time.Minute)
+	rsdClient.SetPolicies(policies)
+
+	var result map[string]interface{}
+	if err := rsdClient.Get(context.Background(), "/redfish/v1", &result); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	}
+
+	_, err = rsdClient.Post(context.Background(), "/redfish/v1/StorageServices/1/Volumes", nil, &result)
+	if err == nil {
+		t.Fatal("Post: unexpected success")
+	}
+	if category := Classify(err); category != CategoryTimeout {
+		t.Errorf("Post: error category %q, want %q: %v", category, CategoryTimeout, err)
+	}
+
+	retry := policiesOf(rsdClient).TaskPoll
+	var total time.Duration
+	for i := 0; i < retry.Attempts-1; i++ {
+		total += retry.DelayAfter(i)
+	}
+	if total < time.Minute {
+		t.Errorf("task poll delays %v of %d attempts are shorter than task poll timeout", total, retry.Attempts)
+	}
+}
+
+func TestReconfigureAndClose(t *testing.T) {
+	newServer := func(name string, requests chan<- string, release <-chan struct{}) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			username, _, _ := req.BasicAuth()
+			requests <- name + ":" + username
+			<-release
+			rw.Write([]byte("{}"))
+		}))
+	}
+
+	requests := make(chan string, 10)
+	release := make(chan struct{})
+	close(release)
+	old := newServer("old", requests, release)
+	defer old.Close()
+
+	blocked := make(chan struct{})
+	current := newServer("new", requests, blocked)
+	defer current.Close()
+
+	rsdClient, err := NewClient(old.URL, "user1", "pass1", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsdClient.Get(context.Background(), "/redfish/v1", nil); err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	if got := <-requests; got != "old:user1" {
+		t.Errorf("request reached %s, want old:user1", got)
+	}
+
+	rsdClient.Reconfigure(current.URL, "user2", "pass2")
+	done := make(chan error)
+	go func() {
+		done <- rsdClient.Get(context.Background(), "/redfish/v1", nil)
+	}()
+	if got := <-requests; got != "new:user2" {
+		t.Errorf("request reached %s, want new:user2", got)
+	}
+
+	// Close waits for the request in progress
+	closed := make(chan struct{})
+	go func() {
+		rsdClient.Close() // nolint: errcheck
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() didn't wait for the request in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(blocked)
+	if err := <-done; err != nil {
+		t.Errorf("Get() unexpected error: %v", err)
+	}
+	<-closed
+
+	if err := rsdClient.Get(context.Background(), "/redfish/v1", nil); err == nil {
+		t.Error("Get() succeeded after Close()")
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if body, _ := ioutil.ReadAll(req.Body); req.Method == http.MethodPost && len(body) == 0 {
+			t.Error("POST request without body")
+		}
+		rw.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "admin", "secret", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	rsdClient.SetRequestLogger(func(method, url, body string) {
+		logged = append(logged, method+" "+url+" "+body)
+	})
+
+	if err := rsdClient.Get(context.Background(), "/redfish/v1", nil); err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	data := map[string]string{"Username": "admin", "Password": "secret"}
+	if _, err := rsdClient.Post(context.Background(), "/redfish/v1/Fabrics/1/Endpoints", data, nil); err != nil {
+		t.Fatalf("Post() unexpected error: %v", err)
+	}
+
+	want := []string{
+		"GET " + server.URL + "/redfish/v1 ",
+		"POST " + server.URL + `/redfish/v1/Fabrics/1/Endpoints {"Password":"***","Username":"***"}`,
+	}
+	if len(logged) != len(want) {
+		t.Fatalf("logged requests %q, want %q", logged, want)
+	}
+	for i := range want {
+		if logged[i] != want[i] {
+			t.Errorf("logged request %q, want %q", logged[i], want[i])
+		}
+	}
+}
+
+func TestRequestHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/redfish/v1/StorageServices/1/Volumes":
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"error": {"code": "Base.1.0.GeneralError", "message": "See ExtendedInfo for more information.",
+				"@Message.ExtendedInfo": [{"MessageId": "Swordfish.1.0.0.InsufficientCapacity", "Message": "Not enough space in the storage pools"}]}}`))
+		case "/redfish/v1/Nodes/1":
+			rw.WriteHeader(http.StatusNotModified)
+		default:
+			rw.WriteHeader(http.StatusUnauthorized)
+			rw.Write([]byte("Unauthorized"))
+		}
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "admin", "secret", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rsdClient.Post(context.Background(), "/redfish/v1/StorageServices/1/Volumes", NewVolumeRequest{CapacityBytes: 1}, nil)
+	httpErr, ok := errors.Cause(err).(*HTTPError)
+	if !ok || httpErr.StatusCode != http.StatusBadRequest || httpErr.Redfish == nil {
+		t.Fatalf("Post() error = %#v, want HTTPError with Redfish error", err)
+	}
+	if got := httpErr.Redfish.ExtendedInfo[0].Name(); got != "InsufficientCapacity" {
+		t.Errorf("Redfish message name = %q, want InsufficientCapacity", got)
+	}
+	if Classify(err) != CategoryCapacity {
+		t.Errorf("Classify(%v) = %q, want %q", err, Classify(err), CategoryCapacity)
+	}
+
+	var result struct{ ID string }
+	err = rsdClient.Get(context.Background(), "/redfish/v1/Nodes/1", &result)
+	if httpErr, ok := errors.Cause(err).(*HTTPError); !ok || httpErr.StatusCode != http.StatusNotModified {
+		t.Errorf("Get() of 304 response error = %v, want HTTPError", err)
+	}
+
+	err = rsdClient.Get(context.Background(), "/redfish/v1", &result)
+	if httpErr, ok := errors.Cause(err).(*HTTPError); !ok || httpErr.Redfish != nil || Classify(err) != CategoryAuth {
+		t.Errorf("Get() of 401 response error = %v, want HTTPError without Redfish error", err)
+	}
+}
+
+func TestWithCredentials(t *testing.T) {
+	var users []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		users = append(users, username+":"+password)
+		rw.Write([]byte("{}")) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "reader", "pass1", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithCredentials(context.Background(), "modifier", "pass2")
+	if _, err := NewRetryTransport(rsdClient).Patch(ctx, "/redfish/v1/Volumes/1", nil, nil); err != nil {
+		t.Fatalf("Patch() unexpected error: %v", err)
+	}
+	if err := rsdClient.Get(context.Background(), "/redfish/v1", nil); err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+	if len(users) != 2 || users[0] != "modifier:pass2" || users[1] != "reader:pass1" {
+		t.Errorf("requests sent with credentials %v, want the context ones and then the client ones", users)
+	}
+}
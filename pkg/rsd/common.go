@@ -14,15 +14,75 @@
 
 package rsd
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// EndPointGetter is a resource linked to fabric endpoints, e.g. Volume,
+// ComputerSystem, Fabric or Zone
+type EndPointGetter interface {
+	GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error)
+}
+
+// Deleter is a resource the driver deletes, e.g. Volume or Zone
+type Deleter interface {
+	Delete(ctx context.Context, rsd Transport) error
+}
 
 type endPointOdataID struct {
 	OdataID string `json:"@odata.id"`
 }
 
+// ErrStopIteration stops iterating over the collection members without an
+// error when it's returned by the callback of ForEachMember
+var ErrStopIteration = errors.New("stop iteration")
+
+// memberPage is a page of the members of a Redfish collection. Large
+// collections link the next page of the members by Members@odata.nextLink.
+type memberPage struct {
+	Members []struct {
+		OdataID string `json:"@odata.id"`
+	} `json:"Members"`
+	NextLink string `json:"Members@odata.nextLink"`
+}
+
+// forEachMemberID calls fn with @odata.id of each member of the collection
+// starting with its first page. The next page is read only once all members of
+// the previous one are done, so only one page is kept in memory.
+func forEachMemberID(ctx context.Context, rsd Transport, page memberPage, fn func(odataID string) error) error {
+	read := map[string]bool{}
+	for {
+		for _, member := range page.Members {
+			if err := fn(member.OdataID); err != nil {
+				if errors.Cause(err) == ErrStopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+		next := page.NextLink
+		if next == "" {
+			return nil
+		}
+		// a PODM linking a page again would make the iteration endless
+		if read[next] {
+			return errors.Errorf("collection page %s is linked twice", next)
+		}
+		read[next] = true
+		page = memberPage{}
+		if err := rsd.Get(ctx, next, &page); err != nil {
+			return errors.Wrapf(err, "Can't query collection page %s", next)
+		}
+	}
+}
+
 // GetByOdataID gets resource by its ODataID
-func GetByOdataID(rsd Transport, oDataID string, result interface{}) error {
-	err := rsd.Get(oDataID, result)
+func GetByOdataID(ctx context.Context, rsd Transport, oDataID string, result interface{}) error {
+	err := rsd.Get(ctx, oDataID, result)
 	if err != nil {
 		return errors.Wrapf(err, "can't query resource by ODataID %s", oDataID)
 	}
@@ -31,12 +91,12 @@ func GetByOdataID(rsd Transport, oDataID string, result interface{}) error {
 }
 
 // GetEndPoints returns List of EndPoints associated with a Volume
-func GetEndPoints(rsd Transport, endPointOdataIDs []endPointOdataID) ([]*EndPoint, error) {
+func GetEndPoints(ctx context.Context, rsd Transport, endPointOdataIDs []endPointOdataID) ([]*EndPoint, error) {
 	var result []*EndPoint
 	for _, ep := range endPointOdataIDs {
 		epURL := ep.OdataID
 		endPoint := EndPoint{}
-		err := rsd.Get(epURL, &endPoint)
+		err := rsd.Get(ctx, epURL, &endPoint)
 		if err != nil {
 			return nil, errors.Wrapf(err, "can't query EndPoint %s", epURL)
 		}
@@ -44,3 +104,30 @@ func GetEndPoints(rsd Transport, endPointOdataIDs []endPointOdataID) ([]*EndPoin
 	}
 	return result, nil
 }
+
+// createResource posts the request to the collection and reads the created
+// resource from the 'Location' header or, if it's missing, from the response body
+func createResource(ctx context.Context, rsd Transport, collectionID string, request interface{}, result interface{}) error {
+	var body json.RawMessage
+	header, err := rsd.Post(ctx, collectionID, request, &body)
+	if err != nil {
+		return err
+	}
+
+	var location string
+	if header != nil {
+		location = header.Get("Location")
+	}
+	if location == "" {
+		if len(body) == 0 {
+			return errors.Errorf("No 'Location' header nor resource found in the response: %s", collectionID)
+		}
+		return errors.Wrapf(json.Unmarshal(body, result), "Can't decode created resource: %s", collectionID)
+	}
+
+	locURL, err := url.Parse(location)
+	if err != nil {
+		return errors.Errorf("Can't parse location url %s", location)
+	}
+	return GetByOdataID(ctx, rsd, locURL.EscapedPath(), result)
+}
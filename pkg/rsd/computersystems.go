@@ -14,6 +14,8 @@
 
 package rsd
 
+import "context"
+
 // ComputerSystem JSON payload structure
 type ComputerSystem struct {
 	OdataContext string `json:"@odata.context"`
@@ -126,6 +128,6 @@ type ComputerSystem struct {
 }
 
 // GetEndPoints returns List of EndPoints associated with a Volume
-func (cs *ComputerSystem) GetEndPoints(rsd Transport) ([]*EndPoint, error) {
-	return GetEndPoints(rsd, cs.Links.Endpoints)
+func (cs *ComputerSystem) GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error) {
+	return GetEndPoints(ctx, rsd, cs.Links.Endpoints)
 }
@@ -0,0 +1,81 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// CredentialFiles are the files the RSD username and password are read from,
+// e.g. keys of a mounted Kubernetes secret, so the credentials are exposed
+// neither in the environment nor in the process arguments
+type CredentialFiles struct {
+	// UsernameFile is the file with the username, the username isn't read if it's empty
+	UsernameFile string
+	// PasswordFile is the file with the password, the password isn't read if it's empty
+	PasswordFile string
+}
+
+// Enabled reports if any of the credentials is read from a file
+func (files CredentialFiles) Enabled() bool {
+	return files.UsernameFile != "" || files.PasswordFile != ""
+}
+
+// Read returns the username and password read from the files with the
+// surrounding whitespace trimmed. The given username or password is
+// returned for the file that isn't set.
+func (files CredentialFiles) Read(username, password string) (string, string, error) {
+	var err error
+	if username, err = readCredential(files.UsernameFile, username); err != nil {
+		return "", "", err
+	}
+	if password, err = readCredential(files.PasswordFile, password); err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// readCredential returns the credential read from the file, or value if the file isn't set
+func readCredential(file, value string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// ReloadCredentials reads the credentials from the files and reconfigures the
+// client with them if they changed. It returns true if the client was
+// reconfigured. The client keeps its credentials if the files can't be read,
+// e.g. while they are being replaced.
+func (rsd *Client) ReloadCredentials(files CredentialFiles) (bool, error) {
+	rsd.mu.RLock()
+	baseurl, username, password := rsd.baseurl, rsd.username, rsd.password
+	rsd.mu.RUnlock()
+
+	newUsername, newPassword, err := files.Read(username, password)
+	if err != nil {
+		return false, err
+	}
+	if newUsername == username && newPassword == password {
+		return false, nil
+	}
+	rsd.Reconfigure(baseurl, newUsername, newPassword)
+	return true, nil
+}
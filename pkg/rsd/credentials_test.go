@@ -0,0 +1,75 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-rsd-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := CredentialFiles{UsernameFile: filepath.Join(dir, "username"), PasswordFile: filepath.Join(dir, "password")}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(files.UsernameFile, "admin\n")
+	write(files.PasswordFile, " secret\n")
+
+	if username, password, err := files.Read("flag", "flag"); err != nil || username != "admin" || password != "secret" {
+		t.Errorf("Read() = %q, %q, %v, want admin, secret", username, password, err)
+	}
+	onlyPassword := CredentialFiles{PasswordFile: files.PasswordFile}
+	if username, password, err := onlyPassword.Read("flag", "flag"); err != nil || username != "flag" || password != "secret" {
+		t.Errorf("Read() of password file = %q, %q, %v, want flag, secret", username, password, err)
+	}
+	if (CredentialFiles{}).Enabled() || !onlyPassword.Enabled() {
+		t.Error("Enabled() doesn't report set files")
+	}
+
+	client, err := NewClient("http://localhost", "admin", "secret", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := client.ReloadCredentials(files); err != nil || reloaded {
+		t.Errorf("ReloadCredentials() of unchanged files = %v, %v, want false", reloaded, err)
+	}
+
+	write(files.PasswordFile, "rotated")
+	if reloaded, err := client.ReloadCredentials(files); err != nil || !reloaded {
+		t.Errorf("ReloadCredentials() of rotated password = %v, %v, want true", reloaded, err)
+	}
+	if client.username != "admin" || client.password != "rotated" {
+		t.Errorf("client credentials %q, %q after reload, want admin, rotated", client.username, client.password)
+	}
+
+	// credentials are kept while the files are missing, e.g. during the secret update
+	os.Remove(files.UsernameFile) // nolint: errcheck
+	if _, err := client.ReloadCredentials(files); err == nil {
+		t.Error("ReloadCredentials() of missing file succeeded")
+	}
+	if client.username != "admin" || client.password != "rotated" {
+		t.Errorf("client credentials %q, %q after failed reload, want admin, rotated", client.username, client.password)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rsd is a client of the Intel RSD Redfish and Swordfish API: storage
+// services, volumes and their replicas, composed nodes, fabrics and zones.
+//
+// Functions sending requests to RSD take the context of the requests as their
+// first parameter. The requests, their retries and waiting for RSD tasks and
+// nodes stop when the context is done.
+//
+// The package follows semantic versioning of the module: exported identifiers
+// and their signatures don't change within a major version. An API to be
+// replaced is marked as deprecated in its doc comment, e.g. the functions
+// addressing storage services and fabrics by their index, and keeps working
+// next to its replacement until the next major version of the module. The
+// compatibility of the exported API is checked at compile time by the tests
+// of the rsd_test package.
+package rsd
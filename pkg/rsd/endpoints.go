@@ -82,11 +82,8 @@ type EndPoint struct {
 	Name string `json:"Name"`
 	Oem  struct {
 		IntelRackScale struct {
-			OdataType      string `json:"@odata.type"`
-			Authentication struct {
-				Password string `json:"Password"`
-				Username string `json:"Username"`
-			} `json:"Authentication"`
+			OdataType      string         `json:"@odata.type"`
+			Authentication Authentication `json:"Authentication"`
 		} `json:"Intel_RackScale"`
 	} `json:"Oem"`
 	PciID      interface{}   `json:"PciId"`
@@ -108,3 +105,14 @@ func (ep *EndPoint) GetNQN() string {
 	}
 	return ""
 }
+
+// IsInitiator checks if the endpoint connects a host initiating requests
+// to the fabric, e.g. NVMe-oF host of the composed node
+func (ep *EndPoint) IsInitiator() bool {
+	for _, entity := range ep.ConnectedEntities {
+		if entity.EntityRole == "Initiator" {
+			return true
+		}
+	}
+	return false
+}
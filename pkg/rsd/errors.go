@@ -0,0 +1,236 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorCategory is a category of the RSD operation failure operators can alert on
+type ErrorCategory string
+
+// Categories of RSD operation failures
+const (
+	CategoryNone     ErrorCategory = ""
+	CategoryAuth     ErrorCategory = "auth"
+	CategoryCapacity ErrorCategory = "capacity"
+	CategoryZoning   ErrorCategory = "zoning"
+	CategoryTimeout  ErrorCategory = "timeout"
+	CategoryConflict ErrorCategory = "conflict"
+	CategoryNotFound ErrorCategory = "not_found"
+	CategoryOther    ErrorCategory = "other"
+)
+
+// HTTPError is returned when RSD responds with HTTP error status
+type HTTPError struct {
+	StatusCode int
+	URL        string
+	Body       string
+	// Redfish is the error payload decoded from Body, nil if it's not a Redfish error
+	Redfish *RedfishError
+}
+
+func (e *HTTPError) Error() string {
+	if redfish := e.redfishError(); redfish != nil {
+		return fmt.Sprintf("HTTP error %d while requesting %s: %s", e.StatusCode, Redact(e.URL), Redact(redfish.Error()))
+	}
+	return fmt.Sprintf("HTTP error %d while requesting %s: %s", e.StatusCode, Redact(e.URL), Redact(e.Body))
+}
+
+// redfishError returns the Redfish error payload, decoding it from Body
+// if the error wasn't created by the client
+func (e *HTTPError) redfishError() *RedfishError {
+	if e.Redfish != nil {
+		return e.Redfish
+	}
+	return ParseRedfishError([]byte(e.Body))
+}
+
+// RedfishMessage JSON payload structure of the @Message.ExtendedInfo item
+type RedfishMessage struct {
+	MessageID         string   `json:"MessageId"`
+	Message           string   `json:"Message"`
+	Severity          string   `json:"Severity,omitempty"`
+	Resolution        string   `json:"Resolution,omitempty"`
+	RelatedProperties []string `json:"RelatedProperties,omitempty"`
+}
+
+// Name returns the message ID without the registry prefix, e.g.
+// ResourceMissingAtURI for Base.1.0.ResourceMissingAtURI
+func (m RedfishMessage) Name() string {
+	return m.MessageID[strings.LastIndex(m.MessageID, ".")+1:]
+}
+
+// RedfishError JSON payload structure of the error RSD responds with
+type RedfishError struct {
+	Code         string           `json:"code"`
+	Message      string           `json:"message"`
+	ExtendedInfo []RedfishMessage `json:"@Message.ExtendedInfo"`
+}
+
+func (e *RedfishError) Error() string {
+	msgs := []string{}
+	if e.Code != "" {
+		msgs = append(msgs, e.Code)
+	}
+	if e.Message != "" {
+		msgs = append(msgs, e.Message)
+	}
+	for _, info := range e.ExtendedInfo {
+		msg := info.Name()
+		if info.Message != "" {
+			msg += ": " + info.Message
+		}
+		msgs = append(msgs, msg)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ParseRedfishError decodes the Redfish error payload of the RSD response,
+// it returns nil if the body isn't a Redfish error
+func ParseRedfishError(body []byte) *RedfishError {
+	var payload struct {
+		Error *RedfishError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Error == nil {
+		return nil
+	}
+	if payload.Error.Code == "" && payload.Error.Message == "" && len(payload.Error.ExtendedInfo) == 0 {
+		return nil
+	}
+	return payload.Error
+}
+
+// redfishMessageCategories are the categories of the Redfish message IDs without the registry prefix
+var redfishMessageCategories = map[string]ErrorCategory{
+	"ResourceMissingAtURI":  CategoryNotFound,
+	"ResourceNotFound":      CategoryNotFound,
+	"InsufficientCapacity":  CategoryCapacity,
+	"InsufficientPrivilege": CategoryAuth,
+	"AccessDenied":          CategoryAuth,
+	"NoValidSession":        CategoryAuth,
+	"ResourceInUse":         CategoryConflict,
+	"ResourceAlreadyExists": CategoryConflict,
+}
+
+// timeoutError is returned when RSD didn't finish an operation in time
+type timeoutError struct {
+	msg string
+}
+
+func (e *timeoutError) Error() string {
+	return e.msg
+}
+
+// Timeout implements net.Error-like interface
+func (e *timeoutError) Timeout() bool {
+	return true
+}
+
+func newTimeoutError(format string, args ...interface{}) error {
+	return &timeoutError{msg: fmt.Sprintf(format, args...)}
+}
+
+// notFoundError is returned when the resource is missing in the RSD collection
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+// NotFound tells the resource is missing
+func (e *notFoundError) NotFound() bool {
+	return true
+}
+
+func newNotFoundError(format string, args ...interface{}) error {
+	return &notFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Classify returns the category of the error returned by the RSD operation
+func Classify(err error) ErrorCategory {
+	if err == nil {
+		return CategoryNone
+	}
+
+	cause := errors.Cause(err)
+	if cause == context.DeadlineExceeded {
+		return CategoryTimeout
+	}
+	if timeout, ok := cause.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+		return CategoryTimeout
+	}
+
+	if notFound, ok := cause.(interface{ NotFound() bool }); ok && notFound.NotFound() {
+		return CategoryNotFound
+	}
+
+	if taskErr, ok := cause.(*TaskError); ok {
+		for _, msg := range taskErr.Task.Messages {
+			if category, known := redfishMessageCategories[RedfishMessage{MessageID: msg.MessageID}.Name()]; known {
+				return category
+			}
+		}
+		return CategoryOther
+	}
+
+	httpErr, ok := cause.(*HTTPError)
+	if !ok {
+		return CategoryOther
+	}
+
+	if redfish := httpErr.redfishError(); redfish != nil {
+		for _, info := range redfish.ExtendedInfo {
+			if category, known := redfishMessageCategories[info.Name()]; known {
+				return category
+			}
+		}
+	}
+
+	switch httpErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CategoryAuth
+	case http.StatusConflict:
+		return CategoryConflict
+	case http.StatusNotFound:
+		return CategoryNotFound
+	case http.StatusInsufficientStorage, http.StatusRequestEntityTooLarge:
+		return CategoryCapacity
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CategoryTimeout
+	}
+
+	// PODM reports the rest of the failures as generic errors with the reason in the message
+	body := strings.ToLower(httpErr.Body)
+	switch {
+	case strings.Contains(body, "resourcemissing"):
+		return CategoryNotFound
+	case strings.Contains(body, "zone"):
+		return CategoryZoning
+	case strings.Contains(body, "capacity"), strings.Contains(body, "insufficient"), strings.Contains(body, "no space"):
+		return CategoryCapacity
+	case strings.Contains(body, "already"), strings.Contains(body, "in use"):
+		return CategoryConflict
+	}
+	return CategoryOther
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// netTimeout mimics net.Error returned by http.Client on timeouts
+type netTimeout struct{}
+
+func (netTimeout) Error() string   { return "i/o timeout" }
+func (netTimeout) Timeout() bool   { return true }
+func (netTimeout) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	var tcases = []struct {
+		name string
+		err  error
+		want ErrorCategory
+	}{
+		{
+			name: "No error",
+			want: CategoryNone,
+		},
+		{
+			name: "Unauthorized",
+			err:  errors.Wrap(&HTTPError{StatusCode: http.StatusUnauthorized}, "Can't create new Volume"),
+			want: CategoryAuth,
+		},
+		{
+			name: "Conflict",
+			err:  &HTTPError{StatusCode: http.StatusConflict},
+			want: CategoryConflict,
+		},
+		{
+			name: "Not found",
+			err:  &HTTPError{StatusCode: http.StatusNotFound},
+			want: CategoryNotFound,
+		},
+		{
+			name: "Resource missing in the message",
+			err:  &HTTPError{StatusCode: http.StatusBadRequest, Body: `{"error": {"@Message.ExtendedInfo": [{"MessageId": "Base.1.0.ResourceMissingAtURI"}]}}`},
+			want: CategoryNotFound,
+		},
+		{
+			name: "Capacity in the message",
+			err:  &HTTPError{StatusCode: http.StatusBadRequest, Body: `{"error": {"message": "Insufficient capacity in storage pools"}}`},
+			want: CategoryCapacity,
+		},
+		{
+			name: "Redfish capacity message",
+			err:  &HTTPError{StatusCode: http.StatusInternalServerError, Body: `{"error": {"@Message.ExtendedInfo": [{"MessageId": "Swordfish.1.0.0.InsufficientCapacity"}]}}`},
+			want: CategoryCapacity,
+		},
+		{
+			name: "Redfish privilege message",
+			err:  &HTTPError{StatusCode: http.StatusBadRequest, Redfish: &RedfishError{ExtendedInfo: []RedfishMessage{{MessageID: "Base.1.0.InsufficientPrivilege"}}}},
+			want: CategoryAuth,
+		},
+		{
+			name: "Zoning in the message",
+			err:  &HTTPError{StatusCode: http.StatusInternalServerError, Body: `{"error": {"message": "Endpoint is not in the Zone"}}`},
+			want: CategoryZoning,
+		},
+		{
+			name: "Unknown HTTP error",
+			err:  &HTTPError{StatusCode: http.StatusInternalServerError, Body: "oops"},
+			want: CategoryOther,
+		},
+		{
+			name: "Action timeout",
+			err:  errors.Wrap(newTimeoutError("timeout expired"), "can't attach"),
+			want: CategoryTimeout,
+		},
+		{
+			name: "HTTP client timeout",
+			err:  errors.Wrap(&url.Error{Op: "Get", URL: "/redfish/v1", Err: netTimeout{}}, "Can't get http response"),
+			want: CategoryTimeout,
+		},
+		{
+			name: "Context deadline",
+			err:  errors.Wrap(context.DeadlineExceeded, "Can't query volume"),
+			want: CategoryTimeout,
+		},
+		{
+			name: "Node not found",
+			err:  newNotFoundError("node id 1 not found"),
+			want: CategoryNotFound,
+		},
+		{
+			name: "Other error",
+			err:  errors.New("volume id 1 not found"),
+			want: CategoryOther,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Classify(tc.err); got != tc.want {
+				t.Errorf("Classify(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRedfishError(t *testing.T) {
+	var tcases = []struct {
+		name    string
+		body    string
+		wantNil bool
+		want    string
+	}{
+		{
+			name: "Extended info",
+			body: `{"error": {"code": "Base.1.0.GeneralError", "message": "See ExtendedInfo for more information.",
+				"@Message.ExtendedInfo": [{"MessageId": "Base.1.0.ResourceMissingAtURI", "Message": "The resource at the URI /redfish/v1/Nodes/9 was not found."}]}}`,
+			want: "Base.1.0.GeneralError; See ExtendedInfo for more information.; ResourceMissingAtURI: The resource at the URI /redfish/v1/Nodes/9 was not found.",
+		},
+		{
+			name: "Message only",
+			body: `{"error": {"message": "Insufficient capacity in storage pools"}}`,
+			want: "Insufficient capacity in storage pools",
+		},
+		{
+			name:    "Not JSON",
+			body:    "Internal Server Error",
+			wantNil: true,
+		},
+		{
+			name:    "Other JSON",
+			body:    `{"Id": "1"}`,
+			wantNil: true,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseRedfishError([]byte(tc.body))
+			if (got == nil) != tc.wantNil {
+				t.Fatalf("ParseRedfishError() = %v, want nil %v", got, tc.wantNil)
+			}
+			if got != nil && got.Error() != tc.want {
+				t.Errorf("ParseRedfishError() = %q, want %q", got.Error(), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FabricCollectionEntryPoint is a URL path to the Fabrics entry point
+	FabricCollectionEntryPoint = "/redfish/v1/Fabrics"
+)
+
+// FabricCollection JSON payload structure
+type FabricCollection struct {
+	OdataContext string            `json:"@odata.context"`
+	OdataID      string            `json:"@odata.id"`
+	OdataType    string            `json:"@odata.type"`
+	Name         string            `json:"Name"`
+	Members      []endPointOdataID `json:"Members"`
+}
+
+// Fabric JSON payload structure
+type Fabric struct {
+	OdataContext string `json:"@odata.context"`
+	OdataID      string `json:"@odata.id"`
+	OdataType    string `json:"@odata.type"`
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	FabricType   string `json:"FabricType"`
+	Zones        struct {
+		OdataID string `json:"@odata.id"`
+	} `json:"Zones"`
+	Endpoints struct {
+		OdataID string `json:"@odata.id"`
+	} `json:"Endpoints"`
+}
+
+// EndPointCollection JSON payload structure
+type EndPointCollection struct {
+	OdataID string            `json:"@odata.id"`
+	Members []endPointOdataID `json:"Members"`
+}
+
+// EndPointIdentifier identifies the endpoint, e.g. by NQN
+type EndPointIdentifier struct {
+	DurableNameFormat string `json:"DurableNameFormat"`
+	DurableName       string `json:"DurableName"`
+}
+
+// ConnectedEntity describes the entity connected to the fabric through the endpoint
+type ConnectedEntity struct {
+	EntityRole  string               `json:"EntityRole"`
+	EntityLink  *endPointOdataID     `json:"EntityLink,omitempty"`
+	Identifiers []EndPointIdentifier `json:"Identifiers,omitempty"`
+}
+
+// NewEndPointRequest JSON payload structure
+type NewEndPointRequest struct {
+	Identifiers       []EndPointIdentifier `json:"Identifiers,omitempty"`
+	ConnectedEntities []ConnectedEntity    `json:"ConnectedEntities"`
+}
+
+// NewInitiatorEndPointRequest returns request to create endpoint of the NVMe-oF host
+func NewInitiatorEndPointRequest(hostNQN string) *NewEndPointRequest {
+	identifiers := []EndPointIdentifier{{DurableNameFormat: "NQN", DurableName: hostNQN}}
+	return &NewEndPointRequest{
+		Identifiers:       identifiers,
+		ConnectedEntities: []ConnectedEntity{{EntityRole: "Initiator", Identifiers: identifiers}},
+	}
+}
+
+// NewTargetEndPointRequest returns request to create endpoint exposing the volume
+func NewTargetEndPointRequest(volumeID string) *NewEndPointRequest {
+	return &NewEndPointRequest{
+		ConnectedEntities: []ConnectedEntity{{EntityRole: "Target", EntityLink: &endPointOdataID{OdataID: volumeID}}},
+	}
+}
+
+// zoneLinks JSON payload structure of the zone endpoints
+type zoneLinks struct {
+	Links struct {
+		Endpoints []endPointOdataID `json:"Endpoints"`
+	} `json:"Links"`
+}
+
+func newZoneLinks(endPointIDs []string) *zoneLinks {
+	links := &zoneLinks{}
+	links.Links.Endpoints = []endPointOdataID{}
+	for _, id := range endPointIDs {
+		links.Links.Endpoints = append(links.Links.Endpoints, endPointOdataID{OdataID: id})
+	}
+	return links
+}
+
+// GetFabric returns fabric by its index
+//
+// Deprecated: GetFabric relies on the order of the fabrics, use GetFabricByID
+// instead.
+func GetFabric(ctx context.Context, rsd Transport, fabricNum int) (*Fabric, error) {
+	var collection FabricCollection
+	err := rsd.Get(ctx, FabricCollectionEntryPoint, &collection)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't query FabricCollection")
+	}
+
+	if len(collection.Members) <= fabricNum {
+		return nil, errors.New("No fabrics found in a collection")
+	}
+
+	var fabric Fabric
+	if err := GetByOdataID(ctx, rsd, collection.Members[fabricNum].OdataID, &fabric); err != nil {
+		return nil, err
+	}
+	return &fabric, nil
+}
+
+// GetFabricByID returns fabric by its Id, the first fabric of the collection
+// if fabricID is empty
+func GetFabricByID(ctx context.Context, rsd Transport, fabricID string) (*Fabric, error) {
+	var collection FabricCollection
+	err := rsd.Get(ctx, FabricCollectionEntryPoint, &collection)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't query FabricCollection")
+	}
+
+	for _, member := range collection.Members {
+		var fabric Fabric
+		if err := GetByOdataID(ctx, rsd, member.OdataID, &fabric); err != nil {
+			return nil, err
+		}
+		if fabricID == "" || fabric.ID == fabricID {
+			return &fabric, nil
+		}
+	}
+	if fabricID == "" {
+		return nil, errors.New("No fabrics found in a collection")
+	}
+	return nil, newNotFoundError("fabric id %s not found", fabricID)
+}
+
+// GetEndPoints returns all endpoints of the fabric
+func (fabric *Fabric) GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error) {
+	var collection EndPointCollection
+	err := rsd.Get(ctx, fabric.Endpoints.OdataID, &collection)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't query endpoints of the fabric %s", fabric.ID)
+	}
+	return GetEndPoints(ctx, rsd, collection.Members)
+}
+
+// NewEndPoint creates new endpoint in the fabric
+func (fabric *Fabric) NewEndPoint(ctx context.Context, rsd Transport, request *NewEndPointRequest) (*EndPoint, error) {
+	var endPoint EndPoint
+	if err := createResource(ctx, rsd, fabric.Endpoints.OdataID, request, &endPoint); err != nil {
+		return nil, errors.Wrapf(err, "Can't create endpoint in the fabric %s", fabric.ID)
+	}
+	return &endPoint, nil
+}
+
+// NewZone creates new zone of the endpoints in the fabric
+func (fabric *Fabric) NewZone(ctx context.Context, rsd Transport, endPointIDs []string) (*Zone, error) {
+	var zone Zone
+	if err := createResource(ctx, rsd, fabric.Zones.OdataID, newZoneLinks(endPointIDs), &zone); err != nil {
+		return nil, errors.Wrapf(err, "Can't create zone in the fabric %s", fabric.ID)
+	}
+	return &zone, nil
+}
+
+// SetEndPoints replaces endpoints of the zone
+func (zone *Zone) SetEndPoints(ctx context.Context, rsd Transport, endPointIDs []string) error {
+	_, err := rsd.Patch(ctx, zone.OdataID, newZoneLinks(endPointIDs), nil)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set endpoints of the zone %s", zone.ID)
+	}
+	zone.Links.Endpoints = newZoneLinks(endPointIDs).Links.Endpoints
+	return nil
+}
+
+// Delete deletes the zone, waiting for the RSD task if it's deleted asynchronously
+func (zone *Zone) Delete(ctx context.Context, rsd Transport) error {
+	header, err := rsd.Delete(ctx, zone.OdataID, map[string]string{}, nil)
+	if err == nil {
+		err = waitForAcceptedTask(ctx, rsd, header)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Can't delete zone %s", zone.ID)
+	}
+	return nil
+}
@@ -15,11 +15,11 @@
 package rsd
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"strings"
-	"time"
 
+	"github.com/intel/csi-intel-rsd/pkg/policy"
 	"github.com/pkg/errors"
 )
 
@@ -27,10 +27,24 @@ const (
 	// NodesCollectionEntryPoint is a URL path to the RSD Nodes colection
 	NodesCollectionEntryPoint = "/redfish/v1/Nodes"
 
-	nodeActionDelay    = 10 * time.Second
-	nodeActionAttempts = 30
+	actionResourceParameter = "Resource"
+
+	// PowerStateOn is the PowerState of the powered on node
+	PowerStateOn = "On"
+	// ResetTypeOn is the ComposedNode.Reset type powering the node on
+	ResetTypeOn = "On"
 )
 
+// composedNodeStateFailed is the ComposedNodeState of the node failed to be composed
+const composedNodeStateFailed = "Failed"
+
+// attachableNodeStates are ComposedNodeState values of the nodes resources can be attached to
+var attachableNodeStates = map[string]bool{
+	"Allocated": true,
+	// some PODM versions don't report the state
+	"": true,
+}
+
 // NodesCollection JSON payload structure
 type NodesCollection struct {
 	OdataContext      string `json:"@odata.context"`
@@ -138,29 +152,47 @@ type ActionInfo struct {
 	} `json:"Parameters"`
 }
 
-// GetMembers returns members of Nodes collection
-func (collection *NodesCollection) GetMembers(rsd Transport) ([]*Node, error) {
+// GetMembers returns members of Nodes collection. It fails with
+// ErrIncompleteResource if RSD returns a member without required fields.
+func (collection *NodesCollection) GetMembers(ctx context.Context, rsd Transport) ([]*Node, error) {
 	var result []*Node
 	for _, member := range collection.Members {
 		var item Node
-		err := rsd.Get(member.OdataID, &item)
+		err := rsd.Get(ctx, member.OdataID, &item)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Can't query NodesCollection members %s", member.OdataID)
 		}
+		if err := validateResource(member.OdataID, &item); err != nil {
+			return nil, err
+		}
+		if item.OdataID == "" {
+			item.OdataID = member.OdataID
+		}
 
 		result = append(result, &item)
 	}
 	return result, nil
 }
 
-// Action calls node Action
-func (node *Node) Action(rsd Transport, odataID, action string) error {
-	data := map[string]map[string]string{
-		"Resource": {
+// Action calls node Action, waiting for the RSD task if it's performed asynchronously
+func (node *Node) Action(ctx context.Context, rsd Transport, odataID, action string) error {
+	return node.actionWithParameters(ctx, rsd, odataID, action, nil)
+}
+
+// actionWithParameters calls node Action with extra parameters besides the Resource
+func (node *Node) actionWithParameters(ctx context.Context, rsd Transport, odataID, action string, parameters map[string]interface{}) error {
+	data := map[string]interface{}{
+		actionResourceParameter: map[string]string{
 			"@odata.id": odataID,
 		}}
+	for name, value := range parameters {
+		data[name] = value
+	}
 
-	_, err := rsd.Post(action, data, nil)
+	header, err := rsd.Post(ctx, action, data, nil)
+	if err == nil {
+		err = waitForAcceptedTask(ctx, rsd, header)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "node %s: resource: %s: can't perform action %s", node.ID, odataID, action)
 	}
@@ -203,42 +235,173 @@ func (ai *RedfishActionInfo) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// WaitForAllowed checks if odataID is in AllowableValues in specified intervals
-func (node *Node) WaitForAllowed(rsd Transport, resourceOdataID string, actionResource ComposedNodeResource, delay time.Duration, times int) error {
-	for i := 0; i < times; i++ {
+// allowableResources returns @odata.id values of the AllowableValues of the action "Resource" parameter.
+// It returns false if the action info doesn't declare allowable resources.
+func (actionInfo *ActionInfo) allowableResources() ([]string, bool) {
+	index := -1
+	for i, parameter := range actionInfo.Parameters {
+		if parameter.Name == actionResourceParameter {
+			index = i
+			break
+		}
+	}
+	// some PODM versions don't name the only action parameter
+	if index < 0 && len(actionInfo.Parameters) == 1 && actionInfo.Parameters[0].Name == "" {
+		index = 0
+	}
+	if index < 0 || actionInfo.Parameters[index].AllowableValues == nil {
+		return nil, false
+	}
+
+	var result []string
+	for _, value := range actionInfo.Parameters[index].AllowableValues {
+		switch val := value.(type) {
+		case map[string]interface{}:
+			if odataID, ok := val["@odata.id"].(string); ok {
+				result = append(result, odataID)
+			}
+		case string:
+			result = append(result, val)
+		}
+	}
+	return result, true
+}
+
+// WaitForAllowed checks if odataID is in AllowableValues in specified intervals.
+// If the node doesn't provide action info or the action info doesn't declare
+// allowable resources, the action is expected to be attempted directly.
+// Waiting stops when the context is done.
+func (node *Node) WaitForAllowed(ctx context.Context, rsd Transport, resourceOdataID string, actionResource ComposedNodeResource, retry policy.Retry) error {
+	if actionResource.RedfishActionInfo.OdataID == "" {
+		return nil
+	}
+	for i := 0; i < retry.Attempts; i++ {
 		// Get action info
 		var actionInfo ActionInfo
-		err := GetByOdataID(rsd, actionResource.RedfishActionInfo.OdataID, &actionInfo)
+		err := GetByOdataID(ctx, rsd, actionResource.RedfishActionInfo.OdataID, &actionInfo)
 		if err != nil {
-			return errors.Wrapf(err, "node %s: can't get action info %s", node.ID, actionResource.RedfishActionInfo)
+			return errors.Wrapf(err, "node %s: can't get action info %s", node.ID, actionResource.RedfishActionInfo.OdataID)
 		}
 		// Check if resource is in AllowableValues
-		for _, value := range actionInfo.Parameters[0].AllowableValues {
-			val := value.(map[string]interface{})
-			if val["@odata.id"] == resourceOdataID {
+		allowed, ok := actionInfo.allowableResources()
+		if !ok {
+			return nil
+		}
+		for _, odataID := range allowed {
+			if odataID == resourceOdataID {
 				return nil
 			}
 		}
-		time.Sleep(delay)
+		if err := sleep(ctx, retry.JitteredDelayAfter(i)); err != nil {
+			return errors.Wrapf(err, "node %s: resource %s didn't appear in the AllowableValues array of %s", node.ID, resourceOdataID, actionResource.RedfishActionInfo.OdataID)
+		}
 	}
-	return fmt.Errorf("node%s: resource %s didn't apear in the AllowableValues array of %s: timeout expired", node.ID, resourceOdataID, actionResource.RedfishActionInfo)
+	return newTimeoutError("node %s: resource %s didn't appear in the AllowableValues array of %s: timeout expired", node.ID, resourceOdataID, actionResource.RedfishActionInfo.OdataID)
 }
 
 // Helper to avoid code duplication in the Attach/DetachResource APIs
-func (node *Node) attachOrDetach(rsd Transport, resourceOdataID string, actionResource ComposedNodeResource) error {
-	err := node.WaitForAllowed(rsd, resourceOdataID, actionResource, nodeActionDelay, nodeActionAttempts)
+func (node *Node) attachOrDetach(ctx context.Context, rsd Transport, resourceOdataID string, actionResource ComposedNodeResource) error {
+	err := node.WaitForAllowed(ctx, rsd, resourceOdataID, actionResource, policiesOf(rsd).NodeAction)
 	if err != nil {
 		return err
 	}
-	return node.Action(rsd, resourceOdataID, actionResource.Target)
+	return node.Action(ctx, rsd, resourceOdataID, actionResource.Target)
 }
 
 // AttachResource attaches resource to the node
-func (node *Node) AttachResource(rsd Transport, resourceOdataID string) error {
-	return node.attachOrDetach(rsd, resourceOdataID, node.Actions.ComposedNodeAttachResource)
+func (node *Node) AttachResource(ctx context.Context, rsd Transport, resourceOdataID string) error {
+	_, err := node.AttachResourceWithOptions(ctx, rsd, resourceOdataID, nil)
+	return err
 }
 
 // DetachResource detaches resource from the node
-func (node *Node) DetachResource(rsd Transport, resourceOdataID string) error {
-	return node.attachOrDetach(rsd, resourceOdataID, node.Actions.ComposedNodeDetachResource)
+func (node *Node) DetachResource(ctx context.Context, rsd Transport, resourceOdataID string) error {
+	return node.attachOrDetach(ctx, rsd, resourceOdataID, node.Actions.ComposedNodeDetachResource)
+}
+
+// IsAttached queries the node and returns true if the resource is attached to it
+func (node *Node) IsAttached(ctx context.Context, rsd Transport, resourceOdataID string) (bool, error) {
+	var current Node
+	if err := rsd.Get(ctx, node.OdataID, &current); err != nil {
+		return false, errors.Wrapf(err, "Can't query node %s", node.OdataID)
+	}
+	for _, storage := range current.Links.Storage {
+		if storage.OdataID == resourceOdataID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Reset calls the ComposedNode.Reset action of the node with the reset type, e.g. ResetTypeOn
+func (node *Node) Reset(ctx context.Context, rsd Transport, resetType string) error {
+	action := node.Actions.ComposedNodeReset
+	if action.Target == "" {
+		return errors.Errorf("node %s doesn't support reset", node.ID)
+	}
+	if allowed := action.ResetTypeRedfishAllowableValues; len(allowed) > 0 {
+		found := false
+		for _, value := range allowed {
+			found = found || value == resetType
+		}
+		if !found {
+			return errors.Errorf("node %s: reset type %s is not allowed, allowed types: %v", node.ID, resetType, allowed)
+		}
+	}
+
+	header, err := rsd.Post(ctx, action.Target, map[string]string{"ResetType": resetType}, nil)
+	if err == nil {
+		err = waitForAcceptedTask(ctx, rsd, header)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "node %s: can't reset node with type %s", node.ID, resetType)
+	}
+	return nil
+}
+
+// IsAttachable tells if the node is powered on and composed, so resources can be attached to it
+func (node *Node) IsAttachable() bool {
+	return node.PowerState == PowerStateOn && attachableNodeStates[node.ComposedNodeState]
+}
+
+// WaitForAttachable queries the node in specified intervals until it's
+// attachable and updates the node with its current state. Waiting stops
+// when the context is done.
+func (node *Node) WaitForAttachable(ctx context.Context, rsd Transport, retry policy.Retry) error {
+	for i := 0; i < retry.Attempts; i++ {
+		var current Node
+		if err := rsd.Get(ctx, node.OdataID, &current); err != nil {
+			return errors.Wrapf(err, "Can't query node %s", node.OdataID)
+		}
+		*node = current
+		if node.IsAttachable() {
+			return nil
+		}
+		if node.ComposedNodeState == composedNodeStateFailed {
+			return errors.Errorf("node %s: composed node state is %s", node.ID, node.ComposedNodeState)
+		}
+		if err := sleep(ctx, retry.JitteredDelayAfter(i)); err != nil {
+			return errors.Wrapf(err, "node %s didn't become attachable", node.ID)
+		}
+	}
+	return newTimeoutError("node %s didn't become attachable, power state %s, composed node state %s: timeout expired", node.ID, node.PowerState, node.ComposedNodeState)
+}
+
+// PowerOn powers on the node, unless it's already powered on,
+// and waits until resources can be attached to it
+func (node *Node) PowerOn(ctx context.Context, rsd Transport) error {
+	var current Node
+	if err := rsd.Get(ctx, node.OdataID, &current); err != nil {
+		return errors.Wrapf(err, "Can't query node %s", node.OdataID)
+	}
+	*node = current
+	if node.IsAttachable() {
+		return nil
+	}
+	if node.PowerState != PowerStateOn {
+		if err := node.Reset(ctx, rsd, ResetTypeOn); err != nil {
+			return err
+		}
+	}
+	return node.WaitForAttachable(ctx, rsd, policiesOf(rsd).NodeAction)
 }
@@ -0,0 +1,223 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+func TestWaitForAllowed(t *testing.T) {
+	const (
+		actionInfoURL = "/redfish/v1/Nodes/1/Actions/AttachResourceActionInfo"
+		volumeURL     = "/redfish/v1/StorageServices/1/Volumes/1"
+	)
+
+	var tcases = []struct {
+		name       string
+		isError    bool
+		actionInfo string
+		noLink     bool
+	}{
+		{
+			name: "Named Resource parameter",
+			actionInfo: `{"Parameters": [
+				{"Name": "Protocol", "AllowableValues": ["NVMeOverFabrics"]},
+				{"Name": "Resource", "AllowableValues": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}
+			]}`,
+		},
+		{
+			name:       "Unnamed single parameter",
+			actionInfo: `{"Parameters": [{"AllowableValues": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}]}`,
+		},
+		{
+			name:       "AllowableValues as strings",
+			actionInfo: `{"Parameters": [{"Name": "Resource", "AllowableValues": ["/redfish/v1/StorageServices/1/Volumes/1"]}]}`,
+		},
+		{
+			name:       "Resource not allowed",
+			isError:    true,
+			actionInfo: `{"Parameters": [{"Name": "Resource", "AllowableValues": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}]}`,
+		},
+		{
+			name:       "Empty AllowableValues",
+			isError:    true,
+			actionInfo: `{"Parameters": [{"Name": "Resource", "AllowableValues": []}]}`,
+		},
+		{
+			name:       "Malformed AllowableValues",
+			isError:    true,
+			actionInfo: `{"Parameters": [{"Name": "Resource", "AllowableValues": [null, 1, {"@odata.id": 2}, {}]}]}`,
+		},
+		{
+			name:       "Empty parameters",
+			actionInfo: `{"Parameters": []}`,
+		},
+		{
+			name:       "No parameters",
+			actionInfo: `{}`,
+		},
+		{
+			name:       "No Resource parameter",
+			actionInfo: `{"Parameters": [{"Name": "Protocol", "AllowableValues": ["NVMeOverFabrics"]}]}`,
+		},
+		{
+			name:   "No ActionInfo link",
+			noLink: true,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != actionInfoURL {
+					t.Errorf("Unexpected URL: %s, should be: %s", req.URL.Path, actionInfoURL)
+				}
+				rw.Write([]byte(tc.actionInfo))
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			action := ComposedNodeResource{Target: "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource"}
+			if !tc.noLink {
+				action.RedfishActionInfo.OdataID = actionInfoURL
+			}
+
+			node := &Node{ID: "1"}
+			err = node.WaitForAllowed(context.Background(), rsdClient, volumeURL, action, policy.Retry{Attempts: 2, Delay: time.Millisecond})
+			if tc.isError && err == nil {
+				t.Error("unexpected success")
+			}
+			if !tc.isError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNodePowerOn(t *testing.T) {
+	const (
+		nodeURL  = "/redfish/v1/Nodes/1"
+		resetURL = "/redfish/v1/Nodes/1/Actions/ComposedNode.Reset"
+	)
+
+	var tcases = []struct {
+		name       string
+		isError    bool
+		powerState string
+		allowed    string
+		// states are ComposedNodeState values returned after the reset
+		states     []string
+		wantResets int
+	}{
+		{
+			name:       "Powered on",
+			powerState: "On",
+			states:     []string{"Allocated"},
+		},
+		{
+			name:       "Powered off",
+			powerState: "Off",
+			allowed:    `["On", "ForceOff"]`,
+			states:     []string{"Assembling", "Allocated"},
+			wantResets: 1,
+		},
+		{
+			name:       "Reset type not allowed",
+			isError:    true,
+			powerState: "Off",
+			allowed:    `["ForceOff"]`,
+		},
+		{
+			name:       "Failed node",
+			isError:    true,
+			powerState: "Off",
+			states:     []string{"Failed"},
+			wantResets: 1,
+		},
+		{
+			name:       "Timeout",
+			isError:    true,
+			powerState: "Off",
+			states:     []string{"Assembling", "Assembling", "Assembling"},
+			wantResets: 1,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			powerState := tc.powerState
+			state := "Allocated"
+			states := tc.states
+			resets := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch {
+				case req.Method == http.MethodGet && req.URL.Path == nodeURL:
+					if powerState == "On" && len(states) > 0 {
+						state, states = states[0], states[1:]
+					}
+					allowed := tc.allowed
+					if allowed == "" {
+						allowed = "null"
+					}
+					fmt.Fprintf(rw, `{"@odata.id": %q, "Id": "1", "PowerState": %q, "ComposedNodeState": %q,
+						"Actions": {"#ComposedNode.Reset": {"target": %q, "ResetType@Redfish.AllowableValues": %s}}}`,
+						nodeURL, powerState, state, resetURL, allowed)
+				case req.Method == http.MethodPost && req.URL.Path == resetURL:
+					resets++
+					powerState = "On"
+					rw.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+					rw.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			policies := policy.Default()
+			policies.NodeAction = policy.Retry{Attempts: 2, Delay: time.Millisecond}
+			rsdClient.SetPolicies(policies)
+
+			node := &Node{OdataID: nodeURL, ID: "1"}
+			err = node.PowerOn(context.Background(), rsdClient)
+			if tc.isError && err == nil {
+				t.Error("unexpected success")
+			}
+			if !tc.isError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.isError && !node.IsAttachable() {
+				t.Errorf("node is not attachable: %s, %s", node.PowerState, node.ComposedNodeState)
+			}
+			if resets != tc.wantResets {
+				t.Errorf("%d resets, want %d", resets, tc.wantResets)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRecordedBodySize limits the size of the response body kept by the Recorder
+const maxRecordedBodySize = 16 * 1024
+
+// RecordedResponse is an RSD response kept by the Recorder
+type RecordedResponse struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Body       string    `json:"body,omitempty"`
+}
+
+// Recorder keeps the latest RSD responses, so they can be included
+// in the diagnostics. Credentials are redacted before recording.
+type Recorder struct {
+	mu        sync.Mutex
+	size      int
+	next      int
+	responses []RecordedResponse
+}
+
+// NewRecorder returns Recorder keeping size latest responses
+func NewRecorder(size int) *Recorder {
+	return &Recorder{size: size}
+}
+
+// record adds the response replacing the oldest one if the recorder is full
+func (rec *Recorder) record(method, url string, statusCode int, body []byte, err error) {
+	if rec == nil || rec.size <= 0 {
+		return
+	}
+
+	if len(body) > maxRecordedBodySize {
+		body = body[:maxRecordedBodySize]
+	}
+	resp := RecordedResponse{
+		Time:       time.Now(),
+		Method:     method,
+		URL:        Redact(url),
+		StatusCode: statusCode,
+		Body:       Redact(string(body)),
+	}
+	if err != nil {
+		resp.Error = Redact(err.Error())
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.responses) < rec.size {
+		rec.responses = append(rec.responses, resp)
+		return
+	}
+	rec.responses[rec.next] = resp
+	rec.next = (rec.next + 1) % rec.size
+}
+
+// Responses returns recorded responses starting from the oldest one
+func (rec *Recorder) Responses() []RecordedResponse {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	result := make([]RecordedResponse, 0, len(rec.responses))
+	result = append(result, rec.responses[rec.next:]...)
+	return append(result, rec.responses[:rec.next]...)
+}
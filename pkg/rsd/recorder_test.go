@@ -0,0 +1,63 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/redfish/v1/missing" {
+			http.Error(rw, "not found", http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(`{"Id": "1", "Password": "secret"}`))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	recorder := NewRecorder(2)
+	rsdClient.SetRecorder(recorder)
+
+	var result map[string]interface{}
+	for _, path := range []string{"/redfish/v1/1", "/redfish/v1/2", "/redfish/v1/missing"} {
+		rsdClient.Get(context.Background(), path, &result) // nolint: errcheck
+	}
+	if result["Id"] != "1" {
+		t.Errorf("recording changed the decoded response: %v", result)
+	}
+
+	responses := recorder.Responses()
+	if len(responses) != 2 {
+		t.Fatalf("recorded %d responses, want 2", len(responses))
+	}
+	if !strings.HasSuffix(responses[0].URL, "/redfish/v1/2") || !strings.HasSuffix(responses[1].URL, "/redfish/v1/missing") {
+		t.Errorf("recorded wrong responses: %v", responses)
+	}
+	if responses[1].StatusCode != http.StatusNotFound {
+		t.Errorf("recorded status %d, want %d", responses[1].StatusCode, http.StatusNotFound)
+	}
+	if strings.Contains(responses[0].Body, "secret") {
+		t.Errorf("recorded body is not redacted: %s", responses[0].Body)
+	}
+}
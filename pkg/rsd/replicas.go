@@ -0,0 +1,108 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Replica types of the volumes created from other volumes
+const (
+	// ReplicaTypeSnapshot is a point-in-time copy of the source volume
+	ReplicaTypeSnapshot = "Snapshot"
+	// ReplicaTypeClone is a full copy of the source volume
+	ReplicaTypeClone = "Clone"
+)
+
+// Replica roles of the volumes
+const (
+	// ReplicaRoleSource is the role of the volume the replica is created from
+	ReplicaRoleSource = "Source"
+	// ReplicaRoleTarget is the role of the replica volume
+	ReplicaRoleTarget = "Target"
+)
+
+// ReplicaInfo JSON payload structure. It links the replica volume with its source
+// and the source volume with its replicas.
+type ReplicaInfo struct {
+	ReplicaType           string          `json:"ReplicaType"`
+	ReplicaRole           string          `json:"ReplicaRole,omitempty"`
+	ReplicaReadOnlyAccess string          `json:"ReplicaReadOnlyAccess,omitempty"`
+	Replica               endPointOdataID `json:"Replica"`
+}
+
+// NewReplicaInfo returns ReplicaInfo requesting a replica of the given type of the source volume
+func NewReplicaInfo(replicaType, sourceOdataID string) ReplicaInfo {
+	return ReplicaInfo{ReplicaType: replicaType, Replica: endPointOdataID{OdataID: sourceOdataID}}
+}
+
+// NewSnapshot creates a snapshot of the source volume. Snapshot is a volume
+// of the same capacity with ReplicaInfos linking it to the source volume.
+func (collection *VolumeCollection) NewSnapshot(ctx context.Context, rsd Transport, source *Volume, description string) (*Volume, error) {
+	snapshot, err := collection.NewVolume(ctx, rsd, &NewVolumeRequest{
+		CapacityBytes: source.CapacityBytes,
+		Description:   description,
+		ReplicaInfos:  []ReplicaInfo{NewReplicaInfo(ReplicaTypeSnapshot, source.OdataID)},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't create snapshot of Volume %s", source.ID)
+	}
+	return snapshot, nil
+}
+
+// NewVolumeFromSnapshot creates a volume of at least the snapshot capacity
+// with the content of the snapshot, by cloning the snapshot volume
+func (collection *VolumeCollection) NewVolumeFromSnapshot(ctx context.Context, rsd Transport, snapshot *Volume, capacityBytes int64, description string) (*Volume, error) {
+	if capacityBytes < snapshot.CapacityBytes {
+		capacityBytes = snapshot.CapacityBytes
+	}
+	volume, err := collection.NewVolume(ctx, rsd, &NewVolumeRequest{
+		CapacityBytes: capacityBytes,
+		Description:   description,
+		ReplicaInfos:  []ReplicaInfo{NewReplicaInfo(ReplicaTypeClone, snapshot.OdataID)},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't restore snapshot %s", snapshot.ID)
+	}
+	return volume, nil
+}
+
+// SnapshotSource returns @odata.id of the volume the snapshot is created from.
+// It returns empty string if the volume is not a snapshot.
+func (volume *Volume) SnapshotSource() string {
+	for _, info := range volume.ReplicaInfos {
+		if info.ReplicaType == ReplicaTypeSnapshot && info.ReplicaRole != ReplicaRoleSource {
+			return info.Replica.OdataID
+		}
+	}
+	return ""
+}
+
+// GetSnapshots returns members of Volume collection which are snapshots
+func (collection *VolumeCollection) GetSnapshots(ctx context.Context, rsd Transport) ([]*Volume, error) {
+	var result []*Volume
+	err := collection.ForEachMember(ctx, rsd, func(volume *Volume) error {
+		if volume.SnapshotSource() != "" {
+			result = append(result, volume)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
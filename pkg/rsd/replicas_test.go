@@ -0,0 +1,91 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewSnapshot(t *testing.T) {
+	const (
+		collectionURL = "/redfish/v1/StorageServices/1/Volumes"
+		sourceURL     = "/redfish/v1/StorageServices/1/Volumes/1"
+		snapshotURL   = "/redfish/v1/StorageServices/1/Volumes/2"
+	)
+
+	var posted string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == collectionURL:
+			body, _ := ioutil.ReadAll(req.Body)
+			posted = string(body)
+			rw.Header().Set("Location", snapshotURL)
+			rw.WriteHeader(http.StatusCreated)
+		case req.URL.Path == snapshotURL:
+			rw.Write([]byte(`{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2", "Id": "2", "CapacityBytes": 100,
+				"ReplicaInfos": [{"ReplicaType": "Snapshot", "ReplicaRole": "Target", "Replica": {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}}]}`))
+		default:
+			t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	collection := &VolumeCollection{OdataID: collectionURL}
+	source := &Volume{OdataID: sourceURL, ID: "1", CapacityBytes: 100}
+	snapshot, err := collection.NewSnapshot(context.Background(), rsdClient, source, "snapshot-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if snapshot.ID != "2" || snapshot.SnapshotSource() != sourceURL {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+	if source.SnapshotSource() != "" {
+		t.Errorf("source volume is reported as snapshot of %s", source.SnapshotSource())
+	}
+
+	var request NewVolumeRequest
+	if err := json.Unmarshal([]byte(posted), &request); err != nil {
+		t.Fatalf("can't decode posted request %q: %v", posted, err)
+	}
+	if request.CapacityBytes != 100 || request.Description != "snapshot-1" || len(request.ReplicaInfos) != 1 ||
+		request.ReplicaInfos[0] != NewReplicaInfo(ReplicaTypeSnapshot, sourceURL) {
+		t.Errorf("unexpected snapshot request: %s", posted)
+	}
+}
+
+func TestSnapshotSource(t *testing.T) {
+	source := &Volume{ReplicaInfos: []ReplicaInfo{
+		{ReplicaType: ReplicaTypeSnapshot, ReplicaRole: ReplicaRoleSource, Replica: endPointOdataID{OdataID: "/redfish/v1/StorageServices/1/Volumes/2"}},
+	}}
+	if got := source.SnapshotSource(); got != "" {
+		t.Errorf("SnapshotSource() of the source volume = %q, want empty", got)
+	}
+	clone := &Volume{ReplicaInfos: []ReplicaInfo{NewReplicaInfo(ReplicaTypeClone, "/redfish/v1/StorageServices/1/Volumes/2")}}
+	if got := clone.SnapshotSource(); got != "" {
+		t.Errorf("SnapshotSource() of the clone = %q, want empty", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/pkg/errors"
+)
+
+// retryableWrites are the status codes telling RSD didn't apply the request,
+// so requests changing resources are retried only for them
+var retryableWrites = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusServiceUnavailable: true,
+}
+
+// sleep waits for the delay or until the context is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryTransport retries requests of the transport failing with transient
+// errors, with the Request retry and RetryStatusCodes of its policies.
+// GET requests are retried also when RSD isn't reachable, requests changing
+// resources only for the status codes telling RSD didn't apply them.
+// Retries stop when the context of the request is done.
+type RetryTransport struct {
+	transport Transport
+}
+
+// NewRetryTransport returns RetryTransport of the transport
+func NewRetryTransport(transport Transport) *RetryTransport {
+	return &RetryTransport{transport: transport}
+}
+
+// Policies returns policies of the transport
+func (t *RetryTransport) Policies() policy.Policies {
+	return policiesOf(t.transport)
+}
+
+// Close closes the transport if it's a Closer
+func (t *RetryTransport) Close() error {
+	if closer, ok := t.transport.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// retryable checks if the request with the method failed with a transient error
+func retryable(method string, err error, statusCodes []int) bool {
+	cause := errors.Cause(err)
+	if httpErr, ok := cause.(*HTTPError); ok {
+		if method != http.MethodGet && !retryableWrites[httpErr.StatusCode] {
+			return false
+		}
+		for _, code := range statusCodes {
+			if httpErr.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := cause.(net.Error); ok {
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// do sends the request until it succeeds, fails with non-transient error or
+// the attempts are exhausted. It returns the last error then.
+func (t *RetryTransport) do(ctx context.Context, method string, request func() (*http.Header, error)) (*http.Header, error) {
+	policies := t.Policies()
+	retry := policies.Request
+	for attempt := 0; ; attempt++ {
+		header, err := request()
+		if err == nil || attempt+1 >= retry.Attempts || !retryable(method, err, policies.RetryStatusCodes) {
+			return header, err
+		}
+		if sleep(ctx, retry.JitteredDelayAfter(attempt)) != nil {
+			return header, err
+		}
+	}
+}
+
+// Get sends GET request with the retries
+func (t *RetryTransport) Get(ctx context.Context, entrypoint string, result interface{}) error {
+	_, err := t.do(ctx, http.MethodGet, func() (*http.Header, error) {
+		return nil, t.transport.Get(ctx, entrypoint, result)
+	})
+	return err
+}
+
+// Post sends POST request with the retries
+func (t *RetryTransport) Post(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	return t.do(ctx, http.MethodPost, func() (*http.Header, error) {
+		return t.transport.Post(ctx, entrypoint, data, result)
+	})
+}
+
+// Delete sends DELETE request with the retries
+func (t *RetryTransport) Delete(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	return t.do(ctx, http.MethodDelete, func() (*http.Header, error) {
+		return t.transport.Delete(ctx, entrypoint, data, result)
+	})
+}
+
+// Patch sends PATCH request with the retries
+func (t *RetryTransport) Patch(ctx context.Context, entrypoint string, data interface{}, result interface{}) (*http.Header, error) {
+	return t.do(ctx, http.MethodPatch, func() (*http.Header, error) {
+		return t.transport.Patch(ctx, entrypoint, data, result)
+	})
+}
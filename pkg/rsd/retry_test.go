@@ -0,0 +1,140 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var tcases = []struct {
+		name         string
+		method       string
+		statuses     []int
+		attempts     int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "GET recovered", method: http.MethodGet, statuses: []int{503, 502, 200}, attempts: 3, wantRequests: 3},
+		{name: "GET attempts exhausted", method: http.MethodGet, statuses: []int{503, 503, 503, 200}, attempts: 3, wantRequests: 3, wantErr: true},
+		{name: "GET permanent error", method: http.MethodGet, statuses: []int{500, 200}, attempts: 3, wantRequests: 1, wantErr: true},
+		{name: "GET retries disabled", method: http.MethodGet, statuses: []int{503, 200}, attempts: 1, wantRequests: 1, wantErr: true},
+		{name: "POST not applied", method: http.MethodPost, statuses: []int{429, 503, 201}, attempts: 3, wantRequests: 3},
+		{name: "POST maybe applied", method: http.MethodPost, statuses: []int{504, 201}, attempts: 3, wantRequests: 1, wantErr: true},
+		{name: "DELETE not applied", method: http.MethodDelete, statuses: []int{503, 204}, attempts: 3, wantRequests: 2},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if req.Method != tc.method {
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+				}
+				rw.WriteHeader(tc.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			policies := policy.Default()
+			policies.Request = policy.Retry{Attempts: tc.attempts, Delay: time.Millisecond, Backoff: 2, Jitter: 0.2}
+			rsdClient.SetPolicies(policies)
+
+			transport := NewRetryTransport(rsdClient)
+			switch tc.method {
+			case http.MethodGet:
+				err = transport.Get(context.Background(), "/redfish/v1", nil)
+			case http.MethodPost:
+				_, err = transport.Post(context.Background(), "/redfish/v1/StorageServices/1/Volumes", struct{}{}, nil)
+			case http.MethodDelete:
+				_, err = transport.Delete(context.Background(), "/redfish/v1/StorageServices/1/Volumes/1", nil, nil)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s error = %v, wantErr %v", tc.method, err, tc.wantErr)
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("%s sent %d requests, want %d", tc.method, requests, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryTransportContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		cancel()
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := policy.Default()
+	policies.Request = policy.Retry{Attempts: 3, Delay: time.Hour}
+	rsdClient.SetPolicies(policies)
+
+	transport := NewRetryTransport(rsdClient)
+	if err := transport.Get(ctx, "/redfish/v1", nil); err == nil {
+		t.Errorf("Get() succeeded")
+	}
+	if requests != 1 {
+		t.Errorf("Get() sent %d requests after the context is done, want 1", requests)
+	}
+	if got := policiesOf(transport).Request.Delay; got != time.Hour {
+		t.Errorf("policies of the retry transport have request delay %v, want 1h", got)
+	}
+}
+
+func TestWaitForAllowedContext(t *testing.T) {
+	const actionInfoURL = "/redfish/v1/Nodes/1/Actions/AttachResourceActionInfo"
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{"Parameters": [{"Name": "Resource", "AllowableValues": []}]}`))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	node := &Node{ID: "1"}
+	actionResource := ComposedNodeResource{}
+	actionResource.RedfishActionInfo.OdataID = actionInfoURL
+	start := time.Now()
+	err = node.WaitForAllowed(ctx, rsdClient, "/redfish/v1/StorageServices/1/Volumes/1", actionResource, policy.Default().NodeAction)
+	if category := Classify(err); category != CategoryTimeout {
+		t.Errorf("WaitForAllowed() error category %q, want %q: %v", category, CategoryTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("WaitForAllowed() returned %v after the context deadline", elapsed)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// testedSchemas lists OData schema versions of the key RSD resources the
+// client is tested with, by the resource namespace
+var testedSchemas = map[string][]string{
+	"Volume":       {"v1_1_0"},
+	"ComposedNode": {"v1_1_0"},
+	"Endpoint":     {"v1_1_0"},
+}
+
+// SchemaVersion is an OData type of RSD resources seen by the client
+type SchemaVersion struct {
+	Resource  string    `json:"resource"`
+	Type      string    `json:"type"`
+	Version   string    `json:"version"`
+	Tested    bool      `json:"tested"`
+	FirstSeen time.Time `json:"firstSeen"`
+	URL       string    `json:"url"`
+}
+
+// Schemas records OData types of the key RSD resources on their first fetch,
+// so the schema versions of the rack can be included in the diagnostics.
+// Types which differ from the tested versions are reported once.
+type Schemas struct {
+	mu   sync.Mutex
+	seen map[string]SchemaVersion
+	warn func(msg string, keysAndValues ...interface{})
+}
+
+// NewSchemas returns Schemas calling warn for the schema versions which
+// are not tested. warn may be nil.
+func NewSchemas(warn func(msg string, keysAndValues ...interface{})) *Schemas {
+	return &Schemas{seen: map[string]SchemaVersion{}, warn: warn}
+}
+
+// parseODataType splits OData type like #Volume.v1_1_0.Volume into
+// the namespace and version
+func parseODataType(odataType string) (namespace, version string) {
+	parts := strings.Split(strings.TrimPrefix(odataType, "#"), ".")
+	if len(parts) != 3 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// record records OData type of the response body if it's a key resource
+func (schemas *Schemas) record(url string, body []byte) {
+	if schemas == nil {
+		return
+	}
+
+	var resource struct {
+		OdataType string `json:"@odata.type"`
+	}
+	if err := json.Unmarshal(body, &resource); err != nil || resource.OdataType == "" {
+		return
+	}
+	namespace, version := parseODataType(resource.OdataType)
+	tested, exists := testedSchemas[namespace]
+	if !exists {
+		return
+	}
+
+	schemas.mu.Lock()
+	defer schemas.mu.Unlock()
+	if _, exists := schemas.seen[resource.OdataType]; exists {
+		return
+	}
+	schema := SchemaVersion{
+		Resource:  namespace,
+		Type:      resource.OdataType,
+		Version:   version,
+		FirstSeen: time.Now(),
+		URL:       Redact(url),
+	}
+	for _, testedVersion := range tested {
+		if version == testedVersion {
+			schema.Tested = true
+		}
+	}
+	schemas.seen[resource.OdataType] = schema
+	if !schema.Tested && schemas.warn != nil {
+		schemas.warn("RSD resource schema version is not tested with the driver",
+			"type", schema.Type, "url", schema.URL, "tested", strings.Join(tested, ","))
+	}
+}
+
+// Versions returns the recorded schema versions sorted by the OData type
+func (schemas *Schemas) Versions() []SchemaVersion {
+	if schemas == nil {
+		return []SchemaVersion{}
+	}
+	schemas.mu.Lock()
+	defer schemas.mu.Unlock()
+	result := make([]SchemaVersion, 0, len(schemas.seen))
+	for _, schema := range schemas.seen {
+		result = append(result, schema)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+	return result
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchemas(t *testing.T) {
+	types := map[string]string{
+		"/redfish/v1/Volumes/1":   "#Volume.v1_1_0.Volume",
+		"/redfish/v1/Volumes/2":   "#Volume.v1_1_0.Volume",
+		"/redfish/v1/Nodes/1":     "#ComposedNode.v1_2_0.ComposedNode",
+		"/redfish/v1/Nodes/2":     "#ComposedNode.v1_2_0.ComposedNode",
+		"/redfish/v1/Fabrics/1":   "#Fabric.v1_0_0.Fabric",
+		"/redfish/v1/Endpoints/1": "#Endpoint.v1_1_0.Endpoint",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{"@odata.type": "` + types[req.URL.Path] + `"}`)) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var warnings []interface{}
+	schemas := NewSchemas(func(msg string, keysAndValues ...interface{}) {
+		warnings = append(warnings, keysAndValues[1])
+	})
+	rsdClient.SetSchemas(schemas)
+
+	var result map[string]interface{}
+	for _, path := range []string{"/redfish/v1/Volumes/1", "/redfish/v1/Volumes/2", "/redfish/v1/Nodes/1",
+		"/redfish/v1/Nodes/2", "/redfish/v1/Fabrics/1", "/redfish/v1/Endpoints/1"} {
+		if err := rsdClient.Get(context.Background(), path, &result); err != nil {
+			t.Fatalf("Get(%s) unexpected error: %v", path, err)
+		}
+	}
+	if result["@odata.type"] != "#Endpoint.v1_1_0.Endpoint" {
+		t.Errorf("recording changed the decoded response: %v", result)
+	}
+
+	versions := schemas.Versions()
+	if len(versions) != 3 {
+		t.Fatalf("recorded %d schema versions, want 3 of the key resources: %v", len(versions), versions)
+	}
+	for i, want := range []SchemaVersion{
+		{Resource: "ComposedNode", Type: "#ComposedNode.v1_2_0.ComposedNode", Version: "v1_2_0", Tested: false},
+		{Resource: "Endpoint", Type: "#Endpoint.v1_1_0.Endpoint", Version: "v1_1_0", Tested: true},
+		{Resource: "Volume", Type: "#Volume.v1_1_0.Volume", Version: "v1_1_0", Tested: true},
+	} {
+		got := versions[i]
+		if got.Resource != want.Resource || got.Type != want.Type || got.Version != want.Version || got.Tested != want.Tested {
+			t.Errorf("schema version %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if len(versions) == 3 && versions[0].URL != server.URL+"/redfish/v1/Nodes/1" {
+		t.Errorf("schema version recorded for %s, want the first fetched node", versions[0].URL)
+	}
+	if len(warnings) != 1 || warnings[0] != "#ComposedNode.v1_2_0.ComposedNode" {
+		t.Errorf("warned about %v, want the untested node schema once", warnings)
+	}
+}
@@ -14,7 +14,11 @@
 
 package rsd
 
-import "github.com/pkg/errors"
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
 
 // StoragePoolCollection JSON payload structure
 type StoragePoolCollection struct {
@@ -80,11 +84,11 @@ type StoragePool struct {
 }
 
 // GetMembers returns members of StoragePool collection
-func (collection *StoragePoolCollection) GetMembers(rsd Transport) ([]*StoragePool, error) {
+func (collection *StoragePoolCollection) GetMembers(ctx context.Context, rsd Transport) ([]*StoragePool, error) {
 	var result []*StoragePool
 	for _, member := range collection.Members {
 		var item StoragePool
-		err := rsd.Get(member.OdataID, &item)
+		err := rsd.Get(ctx, member.OdataID, &item)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Can't query StoragePoolCollection members %s", member.OdataID)
 		}
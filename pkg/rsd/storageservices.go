@@ -15,6 +15,8 @@
 package rsd
 
 import (
+	"context"
+
 	"github.com/pkg/errors"
 )
 
@@ -74,11 +76,11 @@ type StorageService struct {
 }
 
 // GetMembers returns members of StorageService collection
-func (collection *StorageServiceCollection) GetMembers(rsd Transport) ([]*StorageService, error) {
+func (collection *StorageServiceCollection) GetMembers(ctx context.Context, rsd Transport) ([]*StorageService, error) {
 	var result []*StorageService
 	for _, member := range collection.Members {
 		var item StorageService
-		err := rsd.Get(member.OdataID, &item)
+		err := rsd.Get(ctx, member.OdataID, &item)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Can't query StorageServiceCollection members %s", member.OdataID)
 		}
@@ -89,9 +91,9 @@ func (collection *StorageServiceCollection) GetMembers(rsd Transport) ([]*Storag
 }
 
 // GetVolumeCollection returns VolumeCollection associated with a Storage Service
-func (service *StorageService) GetVolumeCollection(rsd Transport) (*VolumeCollection, error) {
+func (service *StorageService) GetVolumeCollection(ctx context.Context, rsd Transport) (*VolumeCollection, error) {
 	var result VolumeCollection
-	err := rsd.Get(service.Volumes.OdataID, &result)
+	err := rsd.Get(ctx, service.Volumes.OdataID, &result)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can't query StorageService VolumeCollection %s", service.Volumes.OdataID)
 	}
@@ -100,11 +102,25 @@ func (service *StorageService) GetVolumeCollection(rsd Transport) (*VolumeCollec
 }
 
 // GetStoragePoolCollection returns StoragePoolCollection assicoated with a Storage Service
-func (service *StorageService) GetStoragePoolCollection(rsd Transport) (*StoragePoolCollection, error) {
+func (service *StorageService) GetStoragePoolCollection(ctx context.Context, rsd Transport) (*StoragePoolCollection, error) {
 	var result StoragePoolCollection
-	err := rsd.Get(service.StoragePools.OdataID, &result)
+	err := rsd.Get(ctx, service.StoragePools.OdataID, &result)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Can't query StorageService StoragePool Collection %s", service.StoragePools.OdataID)
 	}
 	return &result, nil
 }
+
+// GetEndPoints returns endpoints of the storage service, none if the
+// service doesn't report them
+func (service *StorageService) GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error) {
+	if service.Endpoints.OdataID == "" {
+		return nil, nil
+	}
+	var collection EndPointCollection
+	err := rsd.Get(ctx, service.Endpoints.OdataID, &collection)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't query StorageService EndPoint Collection %s", service.Endpoints.OdataID)
+	}
+	return GetEndPoints(ctx, rsd, collection.Members)
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TaskServiceEntryPoint is a URL path to the RSD TaskService
+	TaskServiceEntryPoint = "/redfish/v1/TaskService"
+
+	taskMonitorSuffix = "/Monitor"
+
+	// taskDeadlineMargin is left of the context deadline to
+	// finish the request after waiting for the task is given up
+	taskDeadlineMargin = time.Second
+)
+
+// policySource is implemented by transports with configurable policies
+type policySource interface {
+	Policies() policy.Policies
+}
+
+// policiesOf returns policies of the transport, or the default ones
+func policiesOf(rsd Transport) policy.Policies {
+	if source, ok := rsd.(policySource); ok {
+		return source.Policies()
+	}
+	return policy.Default()
+}
+
+// Task JSON payload structure
+type Task struct {
+	OdataContext string `json:"@odata.context"`
+	OdataID      string `json:"@odata.id"`
+	OdataType    string `json:"@odata.type"`
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	TaskState    string `json:"TaskState"`
+	TaskStatus   string `json:"TaskStatus"`
+	StartTime    string `json:"StartTime"`
+	EndTime      string `json:"EndTime"`
+	Messages     []struct {
+		MessageID string `json:"MessageId"`
+		Message   string `json:"Message"`
+		Severity  string `json:"Severity"`
+	} `json:"Messages"`
+	// PercentComplete is reported only by some RSD versions
+	PercentComplete *int `json:"PercentComplete,omitempty"`
+}
+
+// IsTaskLocation checks if the URL path points to the task or task monitor
+// RSD returns in the Location header of 202 Accepted responses
+func IsTaskLocation(location string) bool {
+	return strings.HasPrefix(location, TaskServiceEntryPoint+"/")
+}
+
+// IsFinished checks if the task is not running anymore
+func (task *Task) IsFinished() bool {
+	switch task.TaskState {
+	case "Completed", "Exception", "Killed", "Cancelled":
+		return true
+	}
+	return false
+}
+
+// messages returns task messages as a single string
+func (task *Task) messages() string {
+	var result []string
+	for _, msg := range task.Messages {
+		result = append(result, msg.Message)
+	}
+	return strings.Join(result, "; ")
+}
+
+// TaskError is returned when the RSD task didn't complete successfully
+type TaskError struct {
+	URL  string
+	Task *Task
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %s finished in state %s: %s", e.URL, e.Task.TaskState, e.Task.messages())
+}
+
+// Percent returns completion percentage of the task, -1 if it's unknown
+func (task *Task) Percent() int {
+	if task.PercentComplete == nil {
+		return -1
+	}
+	return *task.PercentComplete
+}
+
+// TaskPendingError is returned when the task is still running at the
+// deadline of the context. Waiting for the task can be resumed by its Location.
+type TaskPendingError struct {
+	URL string
+	// Location is the task or task monitor location waited for
+	Location string
+	Task     *Task
+}
+
+func (e *TaskPendingError) Error() string {
+	if percent := e.Task.Percent(); percent >= 0 {
+		return fmt.Sprintf("task %s is still running: %d%% complete", e.URL, percent)
+	}
+	return fmt.Sprintf("task %s is still running", e.URL)
+}
+
+// Timeout tells the task didn't finish in time
+func (e *TaskPendingError) Timeout() bool {
+	return true
+}
+
+// WaitForTask polls the task with the retry delays until it's finished,
+// giving up before the context deadline. It returns TaskError if the task
+// didn't complete successfully, TaskPendingError if it's still running at
+// the deadline, and the context error if the context is cancelled meanwhile.
+func WaitForTask(ctx context.Context, rsd Transport, location string, retry policy.Retry) (*Task, error) {
+	taskURL := strings.TrimSuffix(location, taskMonitorSuffix)
+	deadline, hasDeadline := ctx.Deadline()
+	for i := 0; i < retry.Attempts; i++ {
+		var task Task
+		err := GetByOdataID(ctx, rsd, taskURL, &task)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get task %s", taskURL)
+		}
+
+		if task.IsFinished() {
+			if task.TaskState != "Completed" {
+				return &task, &TaskError{URL: taskURL, Task: &task}
+			}
+			return &task, nil
+		}
+		delay := retry.DelayAfter(i)
+		if hasDeadline && time.Until(deadline) < delay+taskDeadlineMargin {
+			return &task, &TaskPendingError{URL: taskURL, Location: location, Task: &task}
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return &task, err
+		}
+	}
+	return nil, newTimeoutError("task %s didn't finish: timeout expired", taskURL)
+}
+
+// waitForAcceptedTask waits for the task RSD returns in the Location header
+// of 202 Accepted response to the operation. It does nothing if the header
+// doesn't point to a task, as RSD completed the operation synchronously.
+func waitForAcceptedTask(ctx context.Context, rsd Transport, header *http.Header) error {
+	if header == nil {
+		return nil
+	}
+	locURL, err := url.Parse(header.Get("Location"))
+	if err != nil || !IsTaskLocation(locURL.EscapedPath()) {
+		return nil
+	}
+	_, err = WaitForTask(ctx, rsd, locURL.EscapedPath(), policiesOf(rsd).TaskPoll)
+	return err
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/intel/csi-intel-rsd/pkg/policy"
+	"github.com/pkg/errors"
+)
+
+func TestAsyncOperations(t *testing.T) {
+	const (
+		volumeURL = "/redfish/v1/StorageServices/1/Volumes/1"
+		actionURL = "/redfish/v1/Nodes/1/Actions/ComposedNode.AttachResource"
+		taskURL   = "/redfish/v1/TaskService/Tasks/1"
+	)
+
+	var tcases = []struct {
+		name         string
+		location     string
+		tasks        []string
+		wantPolls    int
+		wantCategory ErrorCategory
+	}{
+		{
+			name: "Synchronous",
+		},
+		{
+			name:      "Completed task",
+			location:  taskURL + "/Monitor",
+			tasks:     []string{`{"TaskState": "Running"}`, `{"TaskState": "Running"}`, `{"TaskState": "Completed"}`},
+			wantPolls: 3,
+		},
+		{
+			name:         "Failed task",
+			location:     taskURL,
+			tasks:        []string{`{"TaskState": "Exception", "Messages": [{"MessageId": "Swordfish.1.0.0.InsufficientCapacity", "Message": "No space left"}]}`},
+			wantPolls:    1,
+			wantCategory: CategoryCapacity,
+		},
+		{
+			name:         "Task timeout",
+			location:     taskURL,
+			tasks:        []string{`{"TaskState": "Running"}`},
+			wantPolls:    3,
+			wantCategory: CategoryTimeout,
+		},
+	}
+
+	for _, tc := range tcases {
+		t.Run(tc.name, func(t *testing.T) {
+			polls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case volumeURL, actionURL:
+					if tc.location != "" {
+						rw.Header().Set("Location", tc.location)
+						rw.WriteHeader(http.StatusAccepted)
+					}
+				case taskURL:
+					task := tc.tasks[len(tc.tasks)-1]
+					if polls < len(tc.tasks) {
+						task = tc.tasks[polls]
+					}
+					polls++
+					rw.Write([]byte(task))
+				default:
+					t.Errorf("Unexpected request: %s %s", req.Method, req.URL.Path)
+					rw.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			policies := policy.Default()
+			policies.TaskPoll = policy.Retry{Attempts: 3, Delay: time.Millisecond, Backoff: 2}
+			rsdClient.SetPolicies(policies)
+
+			err = (&Volume{OdataID: volumeURL}).Delete(context.Background(), rsdClient)
+			if category := Classify(err); category != tc.wantCategory {
+				t.Errorf("Volume.Delete() error category %q, want %q: %v", category, tc.wantCategory, err)
+			}
+			if polls != tc.wantPolls {
+				t.Errorf("Volume.Delete() polled the task %d times, want %d", polls, tc.wantPolls)
+			}
+
+			polls = 0
+			err = (&Node{ID: "1"}).Action(context.Background(), rsdClient, volumeURL, actionURL)
+			if category := Classify(err); category != tc.wantCategory {
+				t.Errorf("Node.Action() error category %q, want %q: %v", category, tc.wantCategory, err)
+			}
+			if polls != tc.wantPolls {
+				t.Errorf("Node.Action() polled the task %d times, want %d", polls, tc.wantPolls)
+			}
+		})
+	}
+}
+
+func TestWaitForTaskDeadline(t *testing.T) {
+	const taskURL = "/redfish/v1/TaskService/Tasks/1"
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		polls++
+		rw.Write([]byte(`{"TaskState": "Running", "PercentComplete": 30}`))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = WaitForTask(ctx, rsdClient, taskURL+"/Monitor", policy.Default().TaskPoll)
+	pending, ok := err.(*TaskPendingError)
+	if !ok {
+		t.Fatalf("WaitForTask() error %v, want TaskPendingError", err)
+	}
+	if pending.URL != taskURL || pending.Location != taskURL+"/Monitor" || pending.Task.Percent() != 30 {
+		t.Errorf("WaitForTask() pending %s at %s, %d%% complete", pending.URL, pending.Location, pending.Task.Percent())
+	}
+	if polls != 1 {
+		t.Errorf("WaitForTask() polled the task %d times, want 1", polls)
+	}
+	if category := Classify(err); category != CategoryTimeout {
+		t.Errorf("Classify() = %q, want %q", category, CategoryTimeout)
+	}
+}
+
+func TestAcceptedTaskCancelled(t *testing.T) {
+	const (
+		volumeURL = "/redfish/v1/StorageServices/1/Volumes/1"
+		taskURL   = "/redfish/v1/TaskService/Tasks/1"
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == volumeURL {
+			rw.Header().Set("Location", taskURL)
+			rw.WriteHeader(http.StatusAccepted)
+			return
+		}
+		// the CSI request is cancelled while waiting for the running task
+		polls++
+		time.AfterFunc(10*time.Millisecond, cancel)
+		rw.Write([]byte(`{"TaskState": "Running"}`))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := policy.Default()
+	policies.TaskPoll = policy.Retry{Attempts: 3, Delay: time.Hour}
+	rsdClient.SetPolicies(policies)
+
+	err = (&Volume{OdataID: volumeURL}).Delete(ctx, rsdClient)
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("Volume.Delete() error %v, want context cancellation", err)
+	}
+	if polls != 1 {
+		t.Errorf("Volume.Delete() polled the task %d times, want 1", polls)
+	}
+}
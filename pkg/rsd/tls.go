@@ -0,0 +1,106 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// tlsVersions are the TLS versions by their names
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions configure verification of the RSD server certificate and the
+// client certificate presented to it
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the CAs verifying the RSD server certificate,
+	// the system CAs are used if it's empty
+	CAFile string
+	// CertFile and KeyFile are PEM client certificate and its key for mutual
+	// TLS, no client certificate is presented if they're empty
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, e.g. 1.2, Go default if it's empty
+	MinVersion string
+	// ServerName overrides the host name the RSD server certificate is
+	// verified for, e.g. when RSD is accessed by its IP address
+	ServerName string
+	// Insecure disables verification of the RSD server certificate
+	Insecure bool
+}
+
+// TLSConfig returns TLS configuration of the options
+func (opts TLSOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.Insecure, // nolint: gosec
+	}
+
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q, supported versions: 1.0, 1.1, 1.2, 1.3", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Can't read CA bundle")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CAFile)
+		}
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Can't load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewHTTPClient returns HTTP client connecting to RSD with the TLS options.
+// Request timeouts are set by the Client per request.
+func NewHTTPClient(opts TLSOptions) (*http.Client, error) {
+	config, err := opts.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config,
+		},
+	}, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("{}")) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "csi-rsd-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}) // nolint: errcheck
+	caFile.Close()
+
+	tests := []struct {
+		name       string
+		opts       TLSOptions
+		wantConfig bool
+		wantErr    bool
+	}{
+		{name: "untrusted server", opts: TLSOptions{}, wantConfig: true, wantErr: true},
+		{name: "insecure", opts: TLSOptions{Insecure: true}, wantConfig: true},
+		{name: "CA bundle", opts: TLSOptions{CAFile: caFile.Name(), MinVersion: "1.2"}, wantConfig: true},
+		{name: "server name", opts: TLSOptions{CAFile: caFile.Name(), ServerName: "example.com"}, wantConfig: true},
+		{name: "wrong server name", opts: TLSOptions{CAFile: caFile.Name(), ServerName: "rsd.example.org"}, wantConfig: true, wantErr: true},
+		{name: "missing CA bundle", opts: TLSOptions{CAFile: caFile.Name() + "-missing"}},
+		{name: "certificate without key", opts: TLSOptions{CertFile: caFile.Name()}},
+		{name: "unsupported version", opts: TLSOptions{MinVersion: "2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient, err := NewHTTPClient(tt.opts)
+			if (err == nil) != tt.wantConfig {
+				t.Fatalf("NewHTTPClient() error = %v, want configuration %v", err, tt.wantConfig)
+			}
+			if err != nil {
+				return
+			}
+			rsdClient, err := NewClient(server.URL, "", "", httpClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rsdClient.Get(context.Background(), "/redfish/v1", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfigMinVersion(t *testing.T) {
+	config, err := TLSOptions{MinVersion: "1.3"}.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig() unexpected error: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLSConfig() minimum version %x, want TLS 1.3", config.MinVersion)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrIncompleteResource is the cause of errors returned when RSD responds with
+// a resource lacking fields the driver relies on, e.g. because of a schema drift.
+// Check it with errors.Cause(err) == ErrIncompleteResource.
+var ErrIncompleteResource = errors.New("incomplete RSD resource")
+
+// validator is implemented by resources checking their required fields after decoding
+type validator interface {
+	missingFields() []string
+}
+
+// missingFields returns the required Volume fields which are empty
+func (volume *Volume) missingFields() []string {
+	var missing []string
+	if volume.ID == "" {
+		missing = append(missing, "Id")
+	}
+	return missing
+}
+
+// missingFields returns the required Node fields which are empty
+func (node *Node) missingFields() []string {
+	var missing []string
+	if node.ID == "" {
+		missing = append(missing, "Id")
+	}
+	return missing
+}
+
+// validateResource returns ErrIncompleteResource naming the missing fields
+// if the resource decoded from odataID lacks any of its required fields
+func validateResource(odataID string, resource validator) error {
+	if missing := resource.missingFields(); len(missing) > 0 {
+		return errors.Wrapf(ErrIncompleteResource, "%s: missing %s", odataID, strings.Join(missing, ", "))
+	}
+	return nil
+}
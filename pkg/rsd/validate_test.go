@@ -0,0 +1,77 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestIncompleteResources(t *testing.T) {
+	ctx := context.Background()
+	resources := map[string]string{
+		NodesCollectionEntryPoint:                 `{"Members": [{"@odata.id": "/redfish/v1/Nodes/1"}, {"@odata.id": "/redfish/v1/Nodes/2"}]}`,
+		"/redfish/v1/Nodes/1":                     `{"Id": "1"}`,
+		"/redfish/v1/Nodes/2":                     `{"Name": "renamed Id"}`,
+		"/redfish/v1/StorageServices/1/Volumes/1": `{"Id": "1", "CapacityBytes": 100}`,
+		"/redfish/v1/StorageServices/1/Volumes/2": `{"VolumeId": "2", "CapacityBytes": 100}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		content, ok := resources[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	rsdClient, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetNode(ctx, rsdClient, "1"); errors.Cause(err) != ErrIncompleteResource {
+		t.Errorf("GetNode() error = %v, want %v", err, ErrIncompleteResource)
+	}
+
+	collection := &VolumeCollection{}
+	if err := json.Unmarshal([]byte(`{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}]}`), collection); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collection.GetVolume(ctx, rsdClient, "1"); err != nil {
+		t.Errorf("GetVolume() unexpected error: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Members": [{"@odata.id": "/redfish/v1/StorageServices/1/Volumes/1"}, {"@odata.id": "/redfish/v1/StorageServices/1/Volumes/2"}]}`), collection); err != nil {
+		t.Fatal(err)
+	}
+	_, err = collection.GetVolume(ctx, rsdClient, "2")
+	if errors.Cause(err) != ErrIncompleteResource {
+		t.Fatalf("GetVolume() error = %v, want %v", err, ErrIncompleteResource)
+	}
+	if want := "/redfish/v1/StorageServices/1/Volumes/2: missing Id: incomplete RSD resource"; err.Error() != want {
+		t.Errorf("GetVolume() error = %q, want %q", err, want)
+	}
+	if _, err := collection.GetMembers(ctx, rsdClient); errors.Cause(err) != ErrIncompleteResource {
+		t.Errorf("GetMembers() error = %v, want %v", err, ErrIncompleteResource)
+	}
+}
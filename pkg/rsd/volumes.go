@@ -15,7 +15,10 @@
 package rsd
 
 import (
+	"context"
+	"encoding/json"
 	"net/url"
+	"path"
 
 	"github.com/pkg/errors"
 )
@@ -30,7 +33,9 @@ type VolumeCollection struct {
 	Members           []struct {
 		OdataID string `json:"@odata.id"`
 	} `json:"Members"`
-	Oem struct {
+	// MembersNextLink is the next page of the members of a large collection
+	MembersNextLink string `json:"Members@odata.nextLink"`
+	Oem             struct {
 	} `json:"Oem:"`
 }
 
@@ -72,14 +77,9 @@ type Volume struct {
 		ProvidingDrives []map[string]string `json:"ProvidingDrives"`
 		ProvidingPools  []map[string]string `json:"ProvidingPools"`
 	} `json:"CapacitySources"`
-	AccessCapabilities []string `json:"AccessCapabilities"`
-	ReplicaInfos       []struct {
-		ReplicaType string `json:"ReplicaType"`
-		Replica     struct {
-			OdataID string `json:"@odata.id"`
-		} `json:"Replica"`
-	} `json:"ReplicaInfos"`
-	Links struct {
+	AccessCapabilities []string      `json:"AccessCapabilities"`
+	ReplicaInfos       []ReplicaInfo `json:"ReplicaInfos"`
+	Links              struct {
 		Drives []map[string]string `json:"Drives"`
 		Oem    struct {
 			IntelRackScale struct {
@@ -108,17 +108,70 @@ type Volume struct {
 	} `json:"Oem"`
 }
 
-// NewVolume creates new volume
-func (collection *VolumeCollection) NewVolume(rsd Transport, capacity int64) (*Volume, error) {
-	data := map[string]int64{"CapacityBytes": capacity}
-	header, err := rsd.Post(collection.OdataID, data, nil)
+// NewVolumeRequest JSON payload structure
+type NewVolumeRequest struct {
+	CapacityBytes   int64            `json:"CapacityBytes"`
+	Description     string           `json:"Description,omitempty"`
+	ReplicaInfos    []ReplicaInfo    `json:"ReplicaInfos,omitempty"`
+	CapacitySources []CapacitySource `json:"CapacitySources,omitempty"`
+	// Encrypted requests an encrypted volume, RSD decides if it's not set
+	Encrypted *bool `json:"Encrypted,omitempty"`
+	// Oem are Intel RackScale properties of the volume, RSD defaults are used if it's nil
+	Oem *NewVolumeOem `json:"Oem,omitempty"`
+}
+
+// CapacitySource JSON payload structure. It selects the storage pools
+// providing capacity of the new volume.
+type CapacitySource struct {
+	ProvidingPools []endPointOdataID `json:"ProvidingPools"`
+}
+
+// NewCapacitySource returns CapacitySource providing capacity from the storage pools
+func NewCapacitySource(poolOdataIDs ...string) CapacitySource {
+	source := CapacitySource{ProvidingPools: []endPointOdataID{}}
+	for _, odataID := range poolOdataIDs {
+		source.ProvidingPools = append(source.ProvidingPools, endPointOdataID{OdataID: odataID})
+	}
+	return source
+}
+
+// NewVolumeOem JSON payload structure of the Intel RackScale volume properties.
+// Properties which are not set are left to RSD defaults.
+type NewVolumeOem struct {
+	IntelRackScale struct {
+		Bootable      *bool `json:"Bootable,omitempty"`
+		EraseOnDetach *bool `json:"EraseOnDetach,omitempty"`
+	} `json:"Intel_RackScale"`
+}
+
+// NewVolume creates new volume, waiting for the task of the asynchronous
+// creation until the context deadline. It returns TaskPendingError if the
+// task is still running then.
+func (collection *VolumeCollection) NewVolume(ctx context.Context, rsd Transport, request *NewVolumeRequest) (*Volume, error) {
+	var body json.RawMessage
+	header, err := rsd.Post(ctx, collection.OdataID, request, &body)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't create new Volume")
 	}
 
-	location := header.Get("Location")
+	var location string
+	if header != nil {
+		location = header.Get("Location")
+	}
+
+	// Some PODM versions return created volume in the response body
+	// instead of the 'Location' header
 	if location == "" {
-		return nil, errors.Errorf("No 'Location' header found: %s", collection.OdataID)
+		var volume Volume
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, &volume); err != nil {
+				return nil, errors.Wrapf(err, "Can't decode new volume: %s", collection.OdataID)
+			}
+		}
+		if volume.ID == "" {
+			return nil, errors.Errorf("No 'Location' header nor volume found in the response: %s", collection.OdataID)
+		}
+		return &volume, nil
 	}
 
 	locURL, err := url.Parse(location)
@@ -126,40 +179,144 @@ func (collection *VolumeCollection) NewVolume(rsd Transport, capacity int64) (*V
 		return nil, errors.Errorf("Can't parse location url %s for new volume", location)
 	}
 
+	return NewVolumeOfTask(ctx, rsd, locURL.EscapedPath())
+}
+
+// NewVolumeOfTask returns the volume at the location RSD returned for the
+// new volume. If it's a task, the volume is created asynchronously: it waits
+// for the task to complete until the context deadline, the task monitor
+// returns created volume after that. It's used to resume waiting for
+// the task after NewVolume returned TaskPendingError.
+func NewVolumeOfTask(ctx context.Context, rsd Transport, location string) (*Volume, error) {
+	volumeURL := location
+	if IsTaskLocation(volumeURL) {
+		if _, err := WaitForTask(ctx, rsd, volumeURL, policiesOf(rsd).TaskPoll); err != nil {
+			return nil, errors.Wrap(err, "Can't create new Volume")
+		}
+	}
+
 	var volume Volume
-	err = rsd.Get(locURL.EscapedPath(), &volume)
+	err := rsd.Get(ctx, volumeURL, &volume)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Can't query new volume url: %s", locURL.EscapedPath())
+		return nil, errors.Wrapf(err, "Can't query new volume url: %s", volumeURL)
+	}
+
+	if volume.ID == "" {
+		return nil, errors.Errorf("No volume found at %s", volumeURL)
+	}
+	if volume.OdataID == "" {
+		volume.OdataID = volumeURL
 	}
 
 	return &volume, nil
 }
 
-// GetMembers returns members of Volume collection
-func (collection *VolumeCollection) GetMembers(rsd Transport) ([]*Volume, error) {
+// GetMembers returns members of Volume collection of all its pages. It fails with
+// ErrIncompleteResource if RSD returns a member without required fields.
+// Use ForEachMember to go through large collections without loading them at once.
+func (collection *VolumeCollection) GetMembers(ctx context.Context, rsd Transport) ([]*Volume, error) {
 	var result []*Volume
-	for _, member := range collection.Members {
+	err := collection.ForEachMember(ctx, rsd, func(volume *Volume) error {
+		result = append(result, volume)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ForEachMember calls fn with each member of Volume collection, reading the
+// members one by one and the pages of the collection linked by
+// Members@odata.nextLink one by one, so collections of thousands of volumes
+// are never loaded at once. It stops at the first error returned by fn,
+// ErrStopIteration stops it without an error. It fails with
+// ErrIncompleteResource if RSD returns a member without required fields.
+func (collection *VolumeCollection) ForEachMember(ctx context.Context, rsd Transport, fn func(*Volume) error) error {
+	page := memberPage{Members: collection.Members, NextLink: collection.MembersNextLink}
+	return forEachMemberID(ctx, rsd, page, func(odataID string) error {
 		var item Volume
-		err := rsd.Get(member.OdataID, &item)
+		err := rsd.Get(ctx, odataID, &item)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Can't query VolumeCollection members %s", member.OdataID)
+			return errors.Wrapf(err, "Can't query VolumeCollection members %s", odataID)
+		}
+		if err := validateResource(odataID, &item); err != nil {
+			return err
 		}
 
-		result = append(result, &item)
+		if item.OdataID == "" {
+			item.OdataID = odataID
+		}
+		return fn(&item)
+	})
+}
+
+// GetVolume returns member of Volume collection by its Id. The member with
+// the Id as the last @odata.id path segment is queried first, as RSD names them.
+func (collection *VolumeCollection) GetVolume(ctx context.Context, rsd Transport, volumeID string) (*Volume, error) {
+	for _, member := range collection.Members {
+		if path.Base(member.OdataID) != volumeID {
+			continue
+		}
+		volume, err := GetVolumeByPath(ctx, rsd, member.OdataID)
+		if err != nil {
+			return nil, err
+		}
+		if volume.ID == volumeID {
+			return volume, nil
+		}
+	}
+
+	var result *Volume
+	err := collection.ForEachMember(ctx, rsd, func(volume *Volume) error {
+		if volume.ID != volumeID {
+			return nil
+		}
+		result = volume
+		return ErrStopIteration
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, newNotFoundError("volume id %s not found in %s", volumeID, collection.OdataID)
 	}
 	return result, nil
 }
 
-// Delete deletes volume
-func (volume *Volume) Delete(rsd Transport) error {
-	_, err := rsd.Delete(volume.OdataID, map[string]string{}, nil)
+// Delete deletes volume, waiting for the RSD task if it's deleted asynchronously
+func (volume *Volume) Delete(ctx context.Context, rsd Transport) error {
+	header, err := rsd.Delete(ctx, volume.OdataID, map[string]string{}, nil)
+	if err == nil {
+		err = waitForAcceptedTask(ctx, rsd, header)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "Can't delete Volume %s", volume.Name)
 	}
 	return nil
 }
 
+// SetDescription updates Description of the volume
+func (volume *Volume) SetDescription(ctx context.Context, rsd Transport, description string) error {
+	_, err := rsd.Patch(ctx, volume.OdataID, map[string]string{"Description": description}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set description of Volume %s", volume.ID)
+	}
+	volume.Description = description
+	return nil
+}
+
+// SetCapacity requests RSD to change CapacityBytes of the volume. RSD may
+// allocate more, so the volume must be read again to get the new capacity.
+func (volume *Volume) SetCapacity(ctx context.Context, rsd Transport, capacityBytes int64) error {
+	_, err := rsd.Patch(ctx, volume.OdataID, map[string]int64{"CapacityBytes": capacityBytes}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Can't set capacity of Volume %s", volume.ID)
+	}
+	return nil
+}
+
 // GetEndPoints returns List of EndPoints associated with a Volume
-func (volume *Volume) GetEndPoints(rsd Transport) ([]*EndPoint, error) {
-	return GetEndPoints(rsd, volume.Links.Oem.IntelRackScale.Endpoints)
+func (volume *Volume) GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error) {
+	return GetEndPoints(ctx, rsd, volume.Links.Oem.IntelRackScale.Endpoints)
 }
@@ -0,0 +1,117 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestVolumeCollectionPages(t *testing.T) {
+	ctx := context.Background()
+	const collectionURL = "/redfish/v1/StorageServices/1/Volumes"
+
+	// the collection of 5 volumes is split into pages of 2 members
+	looped := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var page, id int
+		switch {
+		case req.URL.Path == collectionURL:
+			page = 1
+			if req.URL.RawQuery != "" {
+				fmt.Sscanf(req.URL.RawQuery, "page=%d", &page) // nolint: errcheck
+			}
+		case func() bool { _, err := fmt.Sscanf(req.URL.Path, collectionURL+"/%d", &id); return err == nil }():
+			fmt.Fprintf(rw, `{"@odata.id": "%s/%d", "Id": "%d"}`, collectionURL, id, id) // nolint: errcheck
+			return
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		next := ""
+		switch {
+		case looped && page == 2:
+			next = `, "Members@odata.nextLink": "` + collectionURL + `?page=1"`
+		case page < 3:
+			next = fmt.Sprintf(`, "Members@odata.nextLink": "%s?page=%d"`, collectionURL, page+1)
+		}
+		members := ""
+		for id := 2*page - 1; id <= 2*page && id <= 5; id++ {
+			if members != "" {
+				members += ", "
+			}
+			members += fmt.Sprintf(`{"@odata.id": "%s/%d"}`, collectionURL, id)
+		}
+		fmt.Fprintf(rw, `{"@odata.id": "%s", "Members": [%s]%s}`, collectionURL, members, next) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "", "", &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collection VolumeCollection
+	if err := GetByOdataID(ctx, client, collectionURL, &collection); err != nil {
+		t.Fatal(err)
+	}
+	if len(collection.Members) != 2 || collection.MembersNextLink == "" {
+		t.Fatalf("first page has members %v and next link %q", collection.Members, collection.MembersNextLink)
+	}
+
+	volumes, err := collection.GetMembers(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 5 || volumes[4].ID != "5" {
+		t.Errorf("GetMembers() returned %d volumes, want 5 of all pages", len(volumes))
+	}
+
+	// the iteration stops without reading the next pages
+	var ids []string
+	err = collection.ForEachMember(ctx, client, func(volume *Volume) error {
+		ids = append(ids, volume.ID)
+		if len(ids) == 3 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil || len(ids) != 3 {
+		t.Errorf("ForEachMember() stopped with %v after volumes %v, want 3 volumes", err, ids)
+	}
+	failure := errors.New("failure")
+	if err := collection.ForEachMember(ctx, client, func(*Volume) error { return failure }); err != failure {
+		t.Errorf("ForEachMember() = %v, want the error of the callback", err)
+	}
+
+	volume, err := collection.GetVolume(ctx, client, "5")
+	if err != nil || volume.ID != "5" {
+		t.Errorf("GetVolume() of the last page = %v, %v", volume, err)
+	}
+	if _, err := collection.GetVolume(ctx, client, "6"); Classify(err) != CategoryNotFound {
+		t.Errorf("GetVolume() of unknown volume error = %v, want not found", err)
+	}
+
+	// pages linked in a loop fail instead of going through them forever
+	looped = true
+	if _, err := collection.GetMembers(ctx, client); err == nil {
+		t.Error("GetMembers() of looped pages succeeded")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Intel Corporation. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rsd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Zone JSON payload structure
+type Zone struct {
+	OdataContext string `json:"@odata.context"`
+	OdataID      string `json:"@odata.id"`
+	OdataType    string `json:"@odata.type"`
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	Description  string `json:"Description"`
+	Status       struct {
+		State        string `json:"State"`
+		Health       string `json:"Health"`
+		HealthRollup string `json:"HealthRollup"`
+	} `json:"Status"`
+	Links struct {
+		Endpoints []endPointOdataID `json:"Endpoints"`
+	} `json:"Links"`
+}
+
+// GetEndPoints returns List of EndPoints in the Zone
+func (zone *Zone) GetEndPoints(ctx context.Context, rsd Transport) ([]*EndPoint, error) {
+	return GetEndPoints(ctx, rsd, zone.Links.Endpoints)
+}
+
+// GetZones returns List of Zones the EndPoint belongs to
+func (ep *EndPoint) GetZones(ctx context.Context, rsd Transport) ([]*Zone, error) {
+	var result []*Zone
+	for _, z := range ep.Links.Oem.IntelRackScale.Zones {
+		zone := Zone{}
+		err := rsd.Get(ctx, z.OdataID, &zone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't query Zone %s", z.OdataID)
+		}
+		result = append(result, &zone)
+	}
+	return result, nil
+}